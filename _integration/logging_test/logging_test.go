@@ -502,6 +502,12 @@ func (provider *testInstanceIDProvider) GetInstanceIDs(
 	return instances, nil
 }
 
+func (provider *testInstanceIDProvider) GetInstanceHistory(
+	filter cloudprotocol.InstanceFilter,
+) (events []logging.InstanceEvent, err error) {
+	return nil, nil
+}
+
 func (provider *testInstanceIDProvider) AddFilter(filter cloudprotocol.InstanceFilter) (instanceID string) {
 	instanceID = instanceFormFilter(filter)
 
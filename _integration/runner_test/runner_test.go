@@ -110,7 +110,7 @@ func TestMain(m *testing.M) {
 ***********************************************************************************************************************/
 
 func TestStartStopService(t *testing.T) {
-	runnerInstance, err := runner.New()
+	runnerInstance, err := runner.New(runner.RuncRuntime)
 	if err != nil {
 		t.Fatalf("Can't create runner: %v", err)
 	}
@@ -188,7 +188,7 @@ func TestStartStopService(t *testing.T) {
 }
 
 func TestRunParameters(t *testing.T) {
-	runnerInstance, err := runner.New()
+	runnerInstance, err := runner.New(runner.RuncRuntime)
 	if err != nil {
 		t.Fatalf("Can't create runner: %v", err)
 	}
@@ -0,0 +1,537 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/aostypes"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	"github.com/aosedge/aos_common/utils/pbconvert"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/aosedge/aos_servicemanager/config"
+	"github.com/aosedge/aos_servicemanager/database"
+	"github.com/aosedge/aos_servicemanager/launcher"
+	"github.com/aosedge/aos_servicemanager/smclient"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	daemonRequestTimeout = 2 * time.Second
+	cliTimeFormat        = time.RFC3339
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type cliCommand struct {
+	name        string
+	description string
+	handler     func(cfg *config.Config, out io.Writer, asJSON bool) error
+}
+
+type instanceState struct {
+	launcher.InstanceInfo
+	RunState string `json:"runState,omitempty"`
+}
+
+type unitConfig struct {
+	aostypes.NodeUnitConfig
+	VendorVersion string `json:"vendorVersion"`
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func getCLICommands() []cliCommand {
+	return []cliCommand{
+		{"services", "list installed services", printServices},
+		{"layers", "list installed layers", printLayers},
+		{"instances", "list service instances", printInstances},
+		{"history", "show instances start, stop and crash history", printHistory},
+		{"evictions", "show evicted services and layers", printEvictions},
+		{"runs", "show scheduled instances runs", printScheduledRuns},
+		{"networks", "list networks", printNetworks},
+		{"traffic", "show traffic counters", printTraffic},
+		{"envvars", "list override environment variables", printEnvVars},
+		{"unitconfig", "show node unit configuration", printUnitConfig},
+	}
+}
+
+func cliUsage() string {
+	var builder strings.Builder
+
+	builder.WriteString("\nCommands:\n")
+
+	for _, command := range getCLICommands() {
+		fmt.Fprintf(&builder, "  %-12s%s\n", command.name, command.description)
+	}
+
+	builder.WriteString("\nCommands accept -json option to print data in JSON format.\n")
+
+	return builder.String()
+}
+
+// runCLICommand runs read only command to inspect node state.
+func runCLICommand(configFile string, args []string, out io.Writer) error {
+	// Don't mix logs with command output
+	log.SetOutput(os.Stderr)
+
+	if log.GetLevel() == log.InfoLevel {
+		log.SetLevel(log.WarnLevel)
+	}
+
+	for _, command := range getCLICommands() {
+		if command.name != args[0] {
+			continue
+		}
+
+		flagSet := flag.NewFlagSet(command.name, flag.ContinueOnError)
+		asJSON := flagSet.Bool("json", false, "print data in JSON format")
+
+		if err := flagSet.Parse(args[1:]); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		cfg, err := config.New(configFile)
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		return command.handler(cfg, out, *asJSON)
+	}
+
+	return aoserrors.Errorf("unknown command: %s", args[0])
+}
+
+func openReadOnlyDatabase(cfg *config.Config) (*database.Database, error) {
+	db, err := database.NewReadOnly(path.Join(cfg.WorkingDir, dbFileName))
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return db, nil
+}
+
+func printServices(cfg *config.Config, out io.Writer, asJSON bool) error {
+	db, err := openReadOnlyDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	services, err := db.GetServices()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if asJSON {
+		return printJSON(out, services)
+	}
+
+	rows := make([][]string, 0, len(services))
+
+	for _, service := range services {
+		rows = append(rows, []string{
+			service.ServiceID, fmt.Sprint(service.AosVersion), service.VendorVersion, service.ServiceProvider,
+			fmt.Sprint(service.Cached), fmt.Sprint(service.Size), fmt.Sprint(service.GID),
+			service.Timestamp.Format(cliTimeFormat), service.ImagePath,
+		})
+	}
+
+	return printTable(out,
+		[]string{"ID", "AOS VERSION", "VENDOR VERSION", "PROVIDER", "CACHED", "SIZE", "GID", "TIMESTAMP", "PATH"}, rows)
+}
+
+func printLayers(cfg *config.Config, out io.Writer, asJSON bool) error {
+	db, err := openReadOnlyDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	layers, err := db.GetLayersInfo()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if asJSON {
+		return printJSON(out, layers)
+	}
+
+	rows := make([][]string, 0, len(layers))
+
+	for _, layer := range layers {
+		rows = append(rows, []string{
+			layer.LayerID, layer.Digest, fmt.Sprint(layer.AosVersion), layer.VendorVersion, layer.OSVersion,
+			fmt.Sprint(layer.Cached), fmt.Sprint(layer.Size), layer.Timestamp.Format(cliTimeFormat), layer.Path,
+		})
+	}
+
+	return printTable(out,
+		[]string{"ID", "DIGEST", "AOS VERSION", "VENDOR VERSION", "OS VERSION", "CACHED", "SIZE", "TIMESTAMP", "PATH"},
+		rows)
+}
+
+func printInstances(cfg *config.Config, out io.Writer, asJSON bool) error {
+	db, err := openReadOnlyDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	instances, err := db.GetAllInstances()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	var runStates map[aostypes.InstanceIdent]string
+
+	if cfg.LocalAPI.Socket != "" {
+		if runStates, err = getDaemonRunStates(cfg.LocalAPI.Socket); err != nil {
+			log.Warnf("Can't get instances run state from service manager: %v", err)
+		}
+	}
+
+	states := make([]instanceState, len(instances))
+
+	for i, instance := range instances {
+		states[i] = instanceState{InstanceInfo: instance, RunState: runStates[instance.InstanceIdent]}
+	}
+
+	if asJSON {
+		return printJSON(out, states)
+	}
+
+	rows := make([][]string, 0, len(states))
+
+	for _, state := range states {
+		rows = append(rows, []string{
+			state.InstanceID, state.ServiceID, state.SubjectID, fmt.Sprint(state.Instance), fmt.Sprint(state.UID),
+			fmt.Sprint(state.Priority), state.NetworkID, state.IP, state.RunState,
+		})
+	}
+
+	return printTable(out,
+		[]string{"INSTANCE ID", "SERVICE", "SUBJECT", "INDEX", "UID", "PRIORITY", "NETWORK", "IP", "RUN STATE"}, rows)
+}
+
+func printHistory(cfg *config.Config, out io.Writer, asJSON bool) error {
+	db, err := openReadOnlyDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	events, err := db.GetInstanceEvents(cloudprotocol.InstanceFilter{})
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if asJSON {
+		return printJSON(out, events)
+	}
+
+	rows := make([][]string, 0, len(events))
+
+	for _, event := range events {
+		rows = append(rows, []string{
+			event.Timestamp.Format(cliTimeFormat), event.InstanceID, event.ServiceID, event.SubjectID,
+			fmt.Sprint(event.Instance), event.Type, fmt.Sprint(event.ExitCode), fmt.Sprint(event.Signal), event.Message,
+		})
+	}
+
+	return printTable(out,
+		[]string{"TIMESTAMP", "INSTANCE ID", "SERVICE", "SUBJECT", "INDEX", "EVENT", "EXIT CODE", "SIGNAL", "MESSAGE"},
+		rows)
+}
+
+func printEvictions(cfg *config.Config, out io.Writer, asJSON bool) error {
+	db, err := openReadOnlyDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	items, err := db.GetEvictedItems()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if asJSON {
+		return printJSON(out, items)
+	}
+
+	rows := make([][]string, 0, len(items))
+
+	for _, item := range items {
+		rows = append(rows, []string{
+			item.Timestamp.Format(cliTimeFormat), item.Type, item.ID, fmt.Sprint(item.AosVersion),
+			fmt.Sprint(item.Size), item.Reason,
+		})
+	}
+
+	return printTable(out, []string{"TIMESTAMP", "TYPE", "ID", "AOS VERSION", "SIZE", "REASON"}, rows)
+}
+
+func printScheduledRuns(cfg *config.Config, out io.Writer, asJSON bool) error {
+	db, err := openReadOnlyDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	runs, err := db.GetScheduledRuns(cloudprotocol.InstanceFilter{})
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if asJSON {
+		return printJSON(out, runs)
+	}
+
+	rows := make([][]string, 0, len(runs))
+
+	for _, run := range runs {
+		rows = append(rows, []string{
+			run.ScheduledTime.Format(cliTimeFormat), run.InstanceID, run.ServiceID, run.SubjectID,
+			fmt.Sprint(run.Instance), formatCLITime(run.StartTime), formatCLITime(run.EndTime), run.Result,
+			fmt.Sprint(run.ExitCode), run.Message,
+		})
+	}
+
+	return printTable(out, []string{
+		"SCHEDULED TIME", "INSTANCE ID", "SERVICE", "SUBJECT", "INDEX", "START TIME", "END TIME", "RESULT",
+		"EXIT CODE", "MESSAGE",
+	}, rows)
+}
+
+func printNetworks(cfg *config.Config, out io.Writer, asJSON bool) error {
+	db, err := openReadOnlyDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	networks, err := db.GetNetworksInfo()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if asJSON {
+		return printJSON(out, networks)
+	}
+
+	rows := make([][]string, 0, len(networks))
+
+	for _, network := range networks {
+		rows = append(rows, []string{
+			network.NetworkID, network.Subnet, network.IP, fmt.Sprint(network.VlanID), network.VlanIfName,
+		})
+	}
+
+	return printTable(out, []string{"NETWORK ID", "SUBNET", "IP", "VLAN ID", "VLAN INTERFACE"}, rows)
+}
+
+func printTraffic(cfg *config.Config, out io.Writer, asJSON bool) error {
+	db, err := openReadOnlyDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	traffic, err := db.GetAllTrafficMonitorData()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	sort.Slice(traffic, func(i, j int) bool { return traffic[i].Chain < traffic[j].Chain })
+
+	if asJSON {
+		return printJSON(out, traffic)
+	}
+
+	rows := make([][]string, 0, len(traffic))
+
+	for _, chainData := range traffic {
+		rows = append(rows, []string{
+			chainData.Chain, fmt.Sprint(chainData.Value), chainData.Timestamp.Format(cliTimeFormat),
+		})
+	}
+
+	return printTable(out, []string{"CHAIN", "VALUE", "TIMESTAMP"}, rows)
+}
+
+func printEnvVars(cfg *config.Config, out io.Writer, asJSON bool) error {
+	db, err := openReadOnlyDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	envVars, err := db.GetOverrideEnvVars()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if asJSON {
+		return printJSON(out, envVars)
+	}
+
+	var rows [][]string
+
+	for _, instanceEnvVars := range envVars {
+		serviceID, subjectID, instance := "*", "*", "*"
+
+		if instanceEnvVars.ServiceID != nil {
+			serviceID = *instanceEnvVars.ServiceID
+		}
+
+		if instanceEnvVars.SubjectID != nil {
+			subjectID = *instanceEnvVars.SubjectID
+		}
+
+		if instanceEnvVars.Instance != nil {
+			instance = fmt.Sprint(*instanceEnvVars.Instance)
+		}
+
+		for _, envVar := range instanceEnvVars.EnvVars {
+			ttl := ""
+
+			if envVar.TTL != nil {
+				ttl = envVar.TTL.Format(cliTimeFormat)
+			}
+
+			rows = append(rows, []string{serviceID, subjectID, instance, envVar.ID, envVar.Variable, ttl})
+		}
+	}
+
+	return printTable(out, []string{"SERVICE", "SUBJECT", "INSTANCE", "ID", "VARIABLE", "TTL"}, rows)
+}
+
+func printUnitConfig(cfg *config.Config, out io.Writer, asJSON bool) error {
+	data, err := os.ReadFile(cfg.UnitConfigFile)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	var nodeConfig unitConfig
+
+	if err = json.Unmarshal(data, &nodeConfig); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if asJSON {
+		return printJSON(out, nodeConfig)
+	}
+
+	devices := make([]string, len(nodeConfig.Devices))
+
+	for i, device := range nodeConfig.Devices {
+		devices[i] = device.Name
+	}
+
+	resources := make([]string, len(nodeConfig.Resources))
+
+	for i, resource := range nodeConfig.Resources {
+		resources[i] = resource.Name
+	}
+
+	return printTable(out, []string{"PARAMETER", "VALUE"}, [][]string{
+		{"Vendor version", nodeConfig.VendorVersion},
+		{"Node type", nodeConfig.NodeType},
+		{"Priority", fmt.Sprint(nodeConfig.Priority)},
+		{"Labels", strings.Join(nodeConfig.Labels, ", ")},
+		{"Devices", strings.Join(devices, ", ")},
+		{"Resources", strings.Join(resources, ", ")},
+	})
+}
+
+// getDaemonRunStates gets instances run state from running service manager through local API.
+func getDaemonRunStates(socket string) (map[aostypes.InstanceIdent]string, error) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), daemonRequestTimeout)
+	defer cancelFunc()
+
+	connection, err := grpc.DialContext(ctx, "unix://"+socket,
+		grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+	defer connection.Close()
+
+	runStatus, err := smclient.GetLocalRunStatus(ctx, connection)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	runStates := make(map[aostypes.InstanceIdent]string)
+
+	for _, instance := range runStatus.GetInstances() {
+		runStates[pbconvert.NewInstanceIdentFromPB(instance.GetInstance())] = instance.GetRunState()
+	}
+
+	return runStates, nil
+}
+
+func printJSON(out io.Writer, data interface{}) error {
+	encoder := json.NewEncoder(out)
+
+	encoder.SetIndent("", "  ")
+
+	return aoserrors.Wrap(encoder.Encode(data))
+}
+
+func printTable(out io.Writer, header []string, rows [][]string) error {
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0) //nolint:gomnd
+
+	fmt.Fprintln(writer, strings.Join(header, "\t"))
+
+	for _, row := range rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+
+	return aoserrors.Wrap(writer.Flush())
+}
+
+// formatCLITime formats time for table output, not set time (e.g. start time of missed run) is printed as empty.
+func formatCLITime(value time.Time) string {
+	if value.IsZero() {
+		return ""
+	}
+
+	return value.Format(cliTimeFormat)
+}
@@ -0,0 +1,287 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aosedge/aos_common/aostypes"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/database"
+	"github.com/aosedge/aos_servicemanager/eviction"
+	"github.com/aosedge/aos_servicemanager/launcher"
+	"github.com/aosedge/aos_servicemanager/layermanager"
+	"github.com/aosedge/aos_servicemanager/servicemanager"
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var (
+	tmpDir     string
+	configFile string
+)
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Main
+ **********************************************************************************************************************/
+
+func TestMain(m *testing.M) {
+	var err error
+
+	if tmpDir, err = os.MkdirTemp("", "sm_"); err != nil {
+		log.Fatalf("Error create temporary dir: %v", err)
+	}
+
+	if err = prepareNodeState(); err != nil {
+		log.Fatalf("Can't prepare node state: %v", err)
+	}
+
+	ret := m.Run()
+
+	if err = os.RemoveAll(tmpDir); err != nil {
+		log.Fatalf("Error removing tmp dir: %v", err)
+	}
+
+	os.Exit(ret)
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestCLICommandParsing(t *testing.T) {
+	testData := []struct {
+		args      []string
+		expectErr bool
+	}{
+		{args: []string{"services"}},
+		{args: []string{"services", "-json"}},
+		{args: []string{"services", "--json"}},
+		{args: []string{"unknown"}, expectErr: true},
+		{args: []string{"services", "-unknown"}, expectErr: true},
+		{args: []string{"services", "-json=wrong"}, expectErr: true},
+	}
+
+	for _, item := range testData {
+		var out bytes.Buffer
+
+		err := runCLICommand(configFile, item.args, &out)
+
+		if item.expectErr && err == nil {
+			t.Errorf("Command %v should fail", item.args)
+		}
+
+		if !item.expectErr && err != nil {
+			t.Errorf("Can't run command %v: %v", item.args, err)
+		}
+	}
+
+	var out bytes.Buffer
+
+	if err := runCLICommand(path.Join(tmpDir, "missing.cfg"), []string{"services"}, &out); err == nil {
+		t.Error("Command with missing config should fail")
+	}
+}
+
+func TestCLICommandOutput(t *testing.T) {
+	testData := []struct {
+		command string
+		header  []string
+		row     []string
+	}{
+		{
+			command: "services",
+			header:  []string{"ID", "AOS VERSION", "VENDOR VERSION", "PROVIDER"},
+			row:     []string{"service0", "1", "provider0"},
+		},
+		{
+			command: "layers",
+			header:  []string{"ID", "DIGEST", "AOS VERSION"},
+			row:     []string{"layer0", "sha256:layer0", "2"},
+		},
+		{
+			command: "instances",
+			header:  []string{"INSTANCE ID", "SERVICE", "SUBJECT", "INDEX", "UID"},
+			row:     []string{"instance0", "service0", "subject0", "1", "5000"},
+		},
+		{
+			command: "history",
+			header:  []string{"TIMESTAMP", "INSTANCE ID", "SERVICE", "SUBJECT", "INDEX", "EVENT", "EXIT CODE"},
+			row:     []string{"instance0", "service0", "subject0", "1", launcher.InstanceEventCrash, "137"},
+		},
+		{
+			command: "evictions",
+			header:  []string{"TIMESTAMP", "TYPE", "ID", "AOS VERSION", "SIZE", "REASON"},
+			row:     []string{eviction.LayerItem, "layer1", "1", eviction.ReasonTTL},
+		},
+		{
+			command: "runs",
+			header:  []string{"SCHEDULED TIME", "INSTANCE ID", "SERVICE", "RESULT", "EXIT CODE"},
+			row:     []string{"instance0", "service0", "subject0", launcher.ScheduledRunFailed, "3"},
+		},
+		{
+			command: "unitconfig",
+			header:  []string{"PARAMETER", "VALUE"},
+			row:     []string{"Vendor version", "3.0.0"},
+		},
+	}
+
+	for _, item := range testData {
+		var out bytes.Buffer
+
+		if err := runCLICommand(configFile, []string{item.command}, &out); err != nil {
+			t.Fatalf("Can't run command %s: %v", item.command, err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+		if len(lines) < 2 {
+			t.Fatalf("Wrong %s output: %s", item.command, out.String())
+		}
+
+		if !containsFields(lines[0], item.header) {
+			t.Errorf("Wrong %s header: %s", item.command, lines[0])
+		}
+
+		if !containsFields(lines[1], item.row) {
+			t.Errorf("Wrong %s row: %s", item.command, lines[1])
+		}
+
+		out.Reset()
+
+		if err := runCLICommand(configFile, []string{item.command, "-json"}, &out); err != nil {
+			t.Fatalf("Can't run command %s: %v", item.command, err)
+		}
+
+		var data interface{}
+
+		if err := json.Unmarshal(out.Bytes(), &data); err != nil {
+			t.Errorf("Wrong %s JSON output: %v", item.command, err)
+		}
+
+		if items, ok := data.([]interface{}); ok && len(items) != 1 {
+			t.Errorf("Wrong %s JSON items count: %d", item.command, len(items))
+		}
+	}
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func prepareNodeState() error {
+	workingDir := path.Join(tmpDir, "workdir")
+
+	if err := os.MkdirAll(workingDir, 0o755); err != nil {
+		return err
+	}
+
+	unitConfigFile := path.Join(tmpDir, "unit_config.cfg")
+
+	if err := os.WriteFile(unitConfigFile,
+		[]byte(`{"vendorVersion": "3.0.0", "nodeType": "type0", "priority": 10}`), 0o600); err != nil {
+		return err
+	}
+
+	configFile = path.Join(tmpDir, "aos_servicemanager.cfg")
+
+	if err := os.WriteFile(configFile, []byte(`{"workingDir": "`+workingDir+`", "unitConfigFile": "`+
+		unitConfigFile+`"}`), 0o600); err != nil {
+		return err
+	}
+
+	db, err := database.New(path.Join(workingDir, dbFileName), tmpDir, tmpDir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err = db.AddService(servicemanager.ServiceInfo{
+		VersionInfo: aostypes.VersionInfo{AosVersion: 1},
+		ServiceID:   "service0", ServiceProvider: "provider0", Timestamp: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	if err = db.AddLayer(layermanager.LayerInfo{
+		VersionInfo: aostypes.VersionInfo{AosVersion: 2},
+		Digest:      "sha256:layer0", LayerID: "layer0", Timestamp: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	if err = db.AddEvictedItem(eviction.EvictedItem{
+		Type: eviction.LayerItem, ID: "layer1", AosVersion: 1, Reason: eviction.ReasonTTL, Timestamp: time.Now(),
+	}, 10); err != nil { //nolint:gomnd
+		return err
+	}
+
+	ident := aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 1}
+
+	if err = db.AddInstance(launcher.InstanceInfo{
+		InstanceInfo: aostypes.InstanceInfo{InstanceIdent: ident, UID: 5000},
+		InstanceID:   "instance0",
+	}); err != nil {
+		return err
+	}
+
+	if err = db.AddScheduledRun(launcher.ScheduledRun{
+		InstanceIdent: ident, InstanceID: "instance0", ScheduledTime: time.Now(), StartTime: time.Now(),
+		EndTime: time.Now(), Result: launcher.ScheduledRunFailed, ExitCode: 3,
+	}, 10); err != nil { //nolint:gomnd
+		return err
+	}
+
+	return db.AddInstanceEvent(launcher.InstanceEvent{
+		InstanceIdent: ident, InstanceID: "instance0", Timestamp: time.Now(), Type: launcher.InstanceEventCrash,
+		ExitCode: 137, Signal: 9,
+	}, 10) //nolint:gomnd
+}
+
+func containsFields(line string, fields []string) bool {
+	for _, field := range fields {
+		if !strings.Contains(line, field) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -52,6 +52,76 @@ type Logging struct {
 	MaxPartCount uint64 `json:"maxPartCount"`
 }
 
+// CrashLoopBackoff configuration for restarting instances failed by runner.
+type CrashLoopBackoff struct {
+	InitialDelay aostypes.Duration `json:"initialDelay"`
+	MaxDelay     aostypes.Duration `json:"maxDelay"`
+}
+
+// Downloader configuration for downloading service and layer packages. Bandwidth is limited if max bandwidth
+// (bytes per second) is set. If streaming unpack is set, packages are unpacked while downloading and are not stored
+// in download dir.
+type Downloader struct {
+	MaxBandwidth    uint64            `json:"maxBandwidth"`
+	MaxTry          int               `json:"maxTry"`
+	RetryDelay      aostypes.Duration `json:"retryDelay"`
+	MaxRetryDelay   aostypes.Duration `json:"maxRetryDelay"`
+	StreamingUnpack bool              `json:"streamingUnpack"`
+}
+
+// ImageSignature configuration for service and layer images signature verification. Trust anchors are PEM files with
+// certificates trusted to sign images. Signature verification is disabled if trust anchors are not set.
+type ImageSignature struct {
+	TrustAnchors []string `json:"trustAnchors"`
+}
+
+// Scrubber configuration for periodic integrity check of installed services and layers. Scrubbing is disabled if
+// period is not set. Read rate (bytes per second) is limited if max read rate is set. If reinstall damaged is set,
+// damaged services and layers are reinstalled on next desired state.
+type Scrubber struct {
+	Period           aostypes.Duration `json:"period"`
+	MaxReadRate      uint64            `json:"maxReadRate"`
+	ReinstallDamaged bool              `json:"reinstallDamaged"`
+}
+
+// ImageStorage configuration for installed services and layers storage. If format (squashfs or erofs) is set, each
+// service rootfs and layer is converted into read-only image which is loop mounted. If verity is set, image is
+// mounted through dm-verity device and verified with root hash stored on install.
+type ImageStorage struct {
+	Format string `json:"format"`
+	Verity bool   `json:"verity"`
+}
+
+// EvictionPin item which is never evicted. If aos version is not set, all item versions are pinned.
+type EvictionPin struct {
+	ID         string `json:"id"`
+	AosVersion uint64 `json:"aosVersion"`
+}
+
+// Eviction configuration of cached items eviction. Policy defines items eviction order: by install time (timestamp)
+// or by last instance use (lru). Each megabyte of item size makes item older by size weight. If min free space is
+// set, cached items are evicted until available storage space reaches it.
+type Eviction struct {
+	Policy       string            `json:"policy"`
+	SizeWeight   aostypes.Duration `json:"sizeWeight"`
+	MinFreeSpace uint64            `json:"minFreeSpace"`
+	Pins         []EvictionPin     `json:"pins"`
+}
+
+// Registry OCI distribution registry access configuration. Credentials are used to get registry auth token and
+// are read from JSON file with username and password fields. The file should be accessible by owner only.
+type Registry struct {
+	Host            string `json:"host"`
+	CredentialsFile string `json:"credentialsFile"`
+	PlainHTTP       bool   `json:"plainHttp"`
+}
+
+// LocalAPI local API configuration. Local API is disabled if socket is not set.
+type LocalAPI struct {
+	Socket      string   `json:"socket"`
+	AllowedUIDs []uint32 `json:"allowedUids"`
+}
+
 // Migration struct represents path for db migration.
 type Migration struct {
 	MigrationPath       string `json:"migrationPath"`
@@ -76,15 +146,28 @@ type Config struct {
 	ExtractDir                string                 `json:"extractDir"`
 	RemoteNode                bool                   `json:"remoteNode"`
 	RunnerFeatures            []string               `json:"runnerFeatures"`
+	RunnerBackends            map[string]string      `json:"runnerBackends"`
 	UnitConfigFile            string                 `json:"unitConfigFile"`
 	ServiceTTLDays            uint64                 `json:"serviceTtlDays"`
 	LayerTTLDays              uint64                 `json:"layerTtlDays"`
 	ServiceHealthCheckTimeout aostypes.Duration      `json:"serviceHealthCheckTimeout"`
+	ServiceDependencyTimeout  aostypes.Duration      `json:"serviceDependencyTimeout"`
+	InitStepTimeout           aostypes.Duration      `json:"initStepTimeout"`
+	RollbackWindow            aostypes.Duration      `json:"rollbackWindow"`
+	CrashLoopBackoff          CrashLoopBackoff       `json:"crashLoopBackoff"`
+	Downloader                Downloader             `json:"downloader"`
+	ImageSignature            ImageSignature         `json:"imageSignature"`
+	Scrubber                  Scrubber               `json:"scrubber"`
+	ImageStorage              ImageStorage           `json:"imageStorage"`
+	ServiceEviction           Eviction               `json:"serviceEviction"`
+	LayerEviction             Eviction               `json:"layerEviction"`
+	Registries                []Registry             `json:"registries"`
 	Monitoring                resourcemonitor.Config `json:"monitoring"`
 	Logging                   Logging                `json:"logging"`
 	JournalAlerts             journalalerts.Config   `json:"journalAlerts,omitempty"`
 	HostBinds                 []string               `json:"hostBinds"`
 	Hosts                     []aostypes.Host        `json:"hosts,omitempty"`
+	LocalAPI                  LocalAPI               `json:"localApi"`
 	Migration                 Migration              `json:"migration"`
 }
 
@@ -103,10 +186,21 @@ func New(fileName string) (config *Config, err error) {
 		ServiceTTLDays:            30,                                            //nolint:gomnd
 		LayerTTLDays:              30,                                            //nolint:gomnd
 		ServiceHealthCheckTimeout: aostypes.Duration{Duration: 35 * time.Second}, //nolint:gomnd
+		ServiceDependencyTimeout:  aostypes.Duration{Duration: 2 * time.Minute},  //nolint:gomnd
+		InitStepTimeout:           aostypes.Duration{Duration: 5 * time.Minute},  //nolint:gomnd
 		Monitoring: resourcemonitor.Config{
 			SendPeriod: aostypes.Duration{Duration: 1 * time.Minute},
 			PollPeriod: aostypes.Duration{Duration: 10 * time.Second},
 		},
+		CrashLoopBackoff: CrashLoopBackoff{
+			InitialDelay: aostypes.Duration{Duration: 10 * time.Second}, //nolint:gomnd
+			MaxDelay:     aostypes.Duration{Duration: 5 * time.Minute},  //nolint:gomnd
+		},
+		Downloader: Downloader{
+			MaxTry:        5,                                            //nolint:gomnd
+			RetryDelay:    aostypes.Duration{Duration: 1 * time.Second}, //nolint:gomnd
+			MaxRetryDelay: aostypes.Duration{Duration: 1 * time.Minute}, //nolint:gomnd
+		},
 		Logging: Logging{
 			MaxPartSize:  524288, //nolint:gomnd
 			MaxPartCount: 20,     //nolint:gomnd
@@ -52,9 +52,65 @@ func createConfigFile() (err error) {
 	"extractDir": "/var/aos/servicemanager/extract",
 	"remoteNode": true,
 	"runnerFeatures": ["crun", "runc"],
+	"runnerBackends": {
+		"crun": "oci"
+	},
 	"unitConfigFile": "/var/aos/aos_unit.cfg",
 	"layerTtlDays": 40,
 	"serviceHealthCheckTimeout": "10s",
+	"serviceDependencyTimeout": "1m",
+	"initStepTimeout": "30s",
+	"rollbackWindow": "2m",
+	"crashLoopBackoff": {
+		"initialDelay": "5s",
+		"maxDelay": "10m"
+	},
+	"downloader": {
+		"maxBandwidth": 1048576,
+		"maxTry": 10,
+		"retryDelay": "2s",
+		"streamingUnpack": true
+	},
+	"imageSignature": {
+		"trustAnchors": ["/var/aos/crypt/signing/root.pem", "/var/aos/crypt/signing/vendor.pem"]
+	},
+	"scrubber": {
+		"period": "24h",
+		"maxReadRate": 4194304,
+		"reinstallDamaged": true
+	},
+	"imageStorage": {
+		"format": "squashfs",
+		"verity": true
+	},
+	"serviceEviction": {
+		"policy": "lru",
+		"sizeWeight": "1h",
+		"minFreeSpace": 104857600,
+		"pins": [
+			{
+				"id": "service1",
+				"aosVersion": 2
+			}
+		]
+	},
+	"layerEviction": {
+		"pins": [
+			{
+				"id": "layer1"
+			}
+		]
+	},
+	"registries": [
+		{
+			"host": "registry.example.com",
+			"credentialsFile": "/var/aos/registry_credentials.json"
+		},
+		{
+			"host": "localhost:5000",
+			"plainHttp": true
+		}
+	],
 	"monitoring": {
 		"sendPeriod": "5m",
 		"pollPeriod": "1s",
@@ -88,6 +144,10 @@ func createConfigFile() (err error) {
 			"hostName" : "wwwaosum"
 		}
 	],
+	"localApi": {
+		"socket": "/run/aos/servicemanager.sock",
+		"allowedUids": [1000, 1001]
+	},
 	"migration": {
 		"migrationPath" : "/usr/share/aos_servicemnager/migration",
 		"mergedMigrationPath" : "/var/aos/servicemanager/mergedMigration"
@@ -381,6 +441,140 @@ func TestHosts(t *testing.T) {
 	}
 }
 
+func TestDownloader(t *testing.T) {
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %s", err)
+	}
+
+	if config.Downloader.MaxBandwidth != 1048576 {
+		t.Errorf("Wrong max bandwidth value: %d", config.Downloader.MaxBandwidth)
+	}
+
+	if config.Downloader.MaxTry != 10 {
+		t.Errorf("Wrong max try value: %d", config.Downloader.MaxTry)
+	}
+
+	if config.Downloader.RetryDelay.Duration != 2*time.Second {
+		t.Errorf("Wrong retry delay value: %s", config.Downloader.RetryDelay.String())
+	}
+
+	if config.Downloader.MaxRetryDelay.Duration != 1*time.Minute {
+		t.Errorf("Wrong max retry delay value: %s", config.Downloader.MaxRetryDelay.String())
+	}
+
+	if !config.Downloader.StreamingUnpack {
+		t.Error("Streaming unpack should be enabled")
+	}
+}
+
+func TestImageSignature(t *testing.T) {
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %s", err)
+	}
+
+	expectedTrustAnchors := []string{"/var/aos/crypt/signing/root.pem", "/var/aos/crypt/signing/vendor.pem"}
+
+	if !reflect.DeepEqual(config.ImageSignature.TrustAnchors, expectedTrustAnchors) {
+		t.Errorf("Wrong trust anchors value: %v", config.ImageSignature.TrustAnchors)
+	}
+}
+
+func TestScrubber(t *testing.T) {
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %s", err)
+	}
+
+	if config.Scrubber.Period.Duration != 24*time.Hour {
+		t.Errorf("Wrong scrubber period value: %s", config.Scrubber.Period.String())
+	}
+
+	if config.Scrubber.MaxReadRate != 4194304 {
+		t.Errorf("Wrong max read rate value: %d", config.Scrubber.MaxReadRate)
+	}
+
+	if !config.Scrubber.ReinstallDamaged {
+		t.Error("Reinstall damaged should be enabled")
+	}
+}
+
+func TestImageStorage(t *testing.T) {
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %s", err)
+	}
+
+	if config.ImageStorage.Format != "squashfs" {
+		t.Errorf("Wrong image storage format value: %s", config.ImageStorage.Format)
+	}
+
+	if !config.ImageStorage.Verity {
+		t.Error("Image storage verity should be enabled")
+	}
+}
+
+func TestEviction(t *testing.T) {
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %s", err)
+	}
+
+	if config.ServiceEviction.Policy != "lru" {
+		t.Errorf("Wrong service eviction policy value: %s", config.ServiceEviction.Policy)
+	}
+
+	if config.ServiceEviction.SizeWeight.Duration != time.Hour {
+		t.Errorf("Wrong service eviction size weight value: %s", config.ServiceEviction.SizeWeight.String())
+	}
+
+	if config.ServiceEviction.MinFreeSpace != 104857600 {
+		t.Errorf("Wrong service eviction min free space value: %d", config.ServiceEviction.MinFreeSpace)
+	}
+
+	if len(config.ServiceEviction.Pins) != 1 || config.ServiceEviction.Pins[0].ID != "service1" ||
+		config.ServiceEviction.Pins[0].AosVersion != 2 {
+		t.Errorf("Wrong service eviction pins value: %v", config.ServiceEviction.Pins)
+	}
+
+	if config.LayerEviction.Policy != "" || len(config.LayerEviction.Pins) != 1 ||
+		config.LayerEviction.Pins[0].ID != "layer1" || config.LayerEviction.Pins[0].AosVersion != 0 {
+		t.Errorf("Wrong layer eviction value: %v", config.LayerEviction)
+	}
+}
+
+func TestRegistries(t *testing.T) {
+	expectedRegistries := []config.Registry{
+		{Host: "registry.example.com", CredentialsFile: "/var/aos/registry_credentials.json"},
+		{Host: "localhost:5000", PlainHTTP: true},
+	}
+
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %s", err)
+	}
+
+	if !reflect.DeepEqual(config.Registries, expectedRegistries) {
+		t.Errorf("Wrong registries value: %v", config.Registries)
+	}
+}
+
+func TestLocalAPI(t *testing.T) {
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %s", err)
+	}
+
+	if config.LocalAPI.Socket != "/run/aos/servicemanager.sock" {
+		t.Errorf("Wrong local API socket: %s", config.LocalAPI.Socket)
+	}
+
+	if !reflect.DeepEqual(config.LocalAPI.AllowedUIDs, []uint32{1000, 1001}) {
+		t.Errorf("Wrong local API allowed UIDs: %v", config.LocalAPI.AllowedUIDs)
+	}
+}
+
 func TestDatabaseMigration(t *testing.T) {
 	config, err := config.New("tmp/aos_servicemanager.cfg")
 	if err != nil {
@@ -418,6 +612,54 @@ func TestServiceHealthCheckTimeout(t *testing.T) {
 	}
 }
 
+func TestServiceDependencyTimeout(t *testing.T) {
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %s", err)
+	}
+
+	if config.ServiceDependencyTimeout.Duration != time.Minute {
+		t.Errorf("Wrong ServiceDependencyTimeout value: %s", config.ServiceDependencyTimeout.String())
+	}
+}
+
+func TestInitStepTimeout(t *testing.T) {
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %s", err)
+	}
+
+	if config.InitStepTimeout.Duration != 30*time.Second {
+		t.Errorf("Wrong InitStepTimeout value: %s", config.InitStepTimeout.String())
+	}
+}
+
+func TestRollbackWindow(t *testing.T) {
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %s", err)
+	}
+
+	if config.RollbackWindow.Duration != 2*time.Minute {
+		t.Errorf("Wrong RollbackWindow value: %s", config.RollbackWindow.String())
+	}
+}
+
+func TestCrashLoopBackoff(t *testing.T) {
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %s", err)
+	}
+
+	if config.CrashLoopBackoff.InitialDelay.Duration != 5*time.Second {
+		t.Errorf("Wrong initial delay value: %s", config.CrashLoopBackoff.InitialDelay.String())
+	}
+
+	if config.CrashLoopBackoff.MaxDelay.Duration != 10*time.Minute {
+		t.Errorf("Wrong max delay value: %s", config.CrashLoopBackoff.MaxDelay.String())
+	}
+}
+
 func TestPartLimit(t *testing.T) {
 	config, err := config.New("tmp/aos_servicemanager.cfg")
 	if err != nil {
@@ -453,4 +695,8 @@ func TestRunnerFeatures(t *testing.T) {
 	if !reflect.DeepEqual(config.RunnerFeatures, []string{"crun", "runc"}) {
 		t.Errorf("Wrong runnerFeatures value: %v", config.RunnerFeatures)
 	}
+
+	if !reflect.DeepEqual(config.RunnerBackends, map[string]string{"crun": "oci"}) {
+		t.Errorf("Wrong runnerBackends value: %v", config.RunnerBackends)
+	}
 }
@@ -34,8 +34,12 @@ import (
 	_ "github.com/mattn/go-sqlite3" // ignore lint
 	log "github.com/sirupsen/logrus"
 
+	"github.com/aosedge/aos_servicemanager/downloader"
+	"github.com/aosedge/aos_servicemanager/eviction"
+	"github.com/aosedge/aos_servicemanager/fsimage"
 	"github.com/aosedge/aos_servicemanager/launcher"
 	"github.com/aosedge/aos_servicemanager/layermanager"
+	"github.com/aosedge/aos_servicemanager/logging"
 	"github.com/aosedge/aos_servicemanager/networkmanager"
 	"github.com/aosedge/aos_servicemanager/servicemanager"
 )
@@ -71,6 +75,13 @@ type Database struct {
 	sql *sql.DB
 }
 
+// TrafficMonitorData traffic monitor chain data.
+type TrafficMonitorData struct {
+	Chain     string    `json:"chain"`
+	Timestamp time.Time `json:"timestamp"`
+	Value     uint64    `json:"value"`
+}
+
 /***********************************************************************************************************************
  * Public
  **********************************************************************************************************************/
@@ -84,6 +95,28 @@ func New(name string, migrationPath string, mergedMigrationPath string) (db *Dat
 	return db, nil
 }
 
+// NewReadOnly opens existing database in read only mode. Database is not created and not migrated.
+func NewReadOnly(name string) (db *Database, err error) {
+	log.WithField("name", name).Debug("Open database read only")
+
+	if _, err = os.Stat(name); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	sqlite, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&_busy_timeout=%d", name, busyTimeout))
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if err = sqlite.Ping(); err != nil {
+		sqlite.Close()
+
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return &Database{sqlite}, nil
+}
+
 // GetOperationVersion returns operation version.
 func (db *Database) GetOperationVersion() (version uint64, err error) {
 	if err = db.getDataFromQuery("SELECT operationVersion FROM config", &version); err != nil {
@@ -100,13 +133,30 @@ func (db *Database) SetOperationVersion(version uint64) error {
 
 // AddService adds new service.
 func (db *Database) AddService(service servicemanager.ServiceInfo) (err error) {
-	return db.executeQuery("INSERT INTO services values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	if err = db.executeQuery("INSERT INTO services values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		service.ServiceID, service.AosVersion, service.ServiceProvider, service.Description, service.ImagePath,
-		service.ManifestDigest, service.Cached, service.Timestamp, service.Size, service.GID)
+		service.ManifestDigest, service.Cached, service.Timestamp, service.Size, service.GID); err != nil {
+		return err
+	}
+
+	_, err = db.sql.Exec("INSERT OR REPLACE INTO servicemanifests VALUES(?, ?, ?)",
+		service.ServiceID, service.AosVersion, service.OriginalManifestDigest)
+
+	return aoserrors.Wrap(err)
 }
 
 // RemoveService removes existing service.
 func (db *Database) RemoveService(serviceID string, aosVersion uint64) (err error) {
+	if _, err = db.sql.Exec("DELETE FROM damagedservices WHERE id = ? AND aosVersion = ?",
+		serviceID, aosVersion); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if _, err = db.sql.Exec("DELETE FROM servicemanifests WHERE id = ? AND aosVersion = ?",
+		serviceID, aosVersion); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
 	if err = db.executeQuery("DELETE FROM services WHERE id = ? AND aosVersion = ?",
 		serviceID, aosVersion); errors.Is(err, errNotExist) {
 		return nil
@@ -119,12 +169,13 @@ func (db *Database) RemoveService(serviceID string, aosVersion uint64) (err erro
 func (db *Database) GetServices() (services []servicemanager.ServiceInfo, err error) {
 	return getFromQuery(
 		db,
-		"SELECT * FROM services",
+		"SELECT services.*, damagedservices.id IS NOT NULL, servicemanifests.digest FROM services "+
+			"LEFT JOIN damagedservices USING(id, aosVersion) LEFT JOIN servicemanifests USING(id, aosVersion)",
 		func(service *servicemanager.ServiceInfo) []any {
 			return []any{
 				&service.ServiceID, &service.AosVersion, &service.ServiceProvider, &service.Description,
 				&service.ImagePath, &service.ManifestDigest, &service.Cached, &service.Timestamp,
-				&service.Size, &service.GID,
+				&service.Size, &service.GID, &service.Damaged, &service.OriginalManifestDigest,
 			}
 		})
 }
@@ -133,12 +184,14 @@ func (db *Database) GetServices() (services []servicemanager.ServiceInfo, err er
 func (db *Database) GetAllServiceVersions(id string) (services []servicemanager.ServiceInfo, err error) {
 	if services, err = getFromQuery(
 		db,
-		"SELECT * FROM services WHERE id = ? ORDER BY aosVersion",
+		"SELECT services.*, damagedservices.id IS NOT NULL, servicemanifests.digest FROM services "+
+			"LEFT JOIN damagedservices USING(id, aosVersion) LEFT JOIN servicemanifests USING(id, aosVersion) "+
+			"WHERE services.id = ? ORDER BY aosVersion",
 		func(service *servicemanager.ServiceInfo) []any {
 			return []any{
 				&service.ServiceID, &service.AosVersion, &service.ServiceProvider, &service.Description,
 				&service.ImagePath, &service.ManifestDigest, &service.Cached, &service.Timestamp,
-				&service.Size, &service.GID,
+				&service.Size, &service.GID, &service.Damaged, &service.OriginalManifestDigest,
 			}
 		}, id); err != nil {
 		return nil, err
@@ -161,6 +214,56 @@ func (db *Database) SetServiceCached(serviceID string, aosVersion uint64, cached
 	return err
 }
 
+// SetServiceDamaged sets damaged status for the service.
+func (db *Database) SetServiceDamaged(serviceID string, aosVersion uint64, damaged bool) (err error) {
+	if err = db.getDataFromQuery(
+		fmt.Sprintf("SELECT aosVersion FROM services WHERE id = \"%s\" AND aosVersion = %d", serviceID, aosVersion),
+		&aosVersion); err != nil {
+		if errors.Is(err, errNotExist) {
+			return servicemanager.ErrNotExist
+		}
+
+		return err
+	}
+
+	if damaged {
+		_, err = db.sql.Exec("INSERT OR IGNORE INTO damagedservices VALUES(?, ?)", serviceID, aosVersion)
+	} else {
+		_, err = db.sql.Exec("DELETE FROM damagedservices WHERE id = ? AND aosVersion = ?", serviceID, aosVersion)
+	}
+
+	return aoserrors.Wrap(err)
+}
+
+// SetKnownGoodServiceVersion stores last service version known to work.
+func (db *Database) SetKnownGoodServiceVersion(serviceID string, aosVersion uint64) (err error) {
+	if err = db.executeQuery("UPDATE knowngoodservices SET aosVersion = ? WHERE id = ?",
+		aosVersion, serviceID); errors.Is(err, errNotExist) {
+		if _, err := db.sql.Exec("INSERT INTO knowngoodservices VALUES(?, ?)", serviceID, aosVersion); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		return nil
+	}
+
+	return err
+}
+
+// GetKnownGoodServiceVersion returns last service version known to work or 0 if there is no such version.
+func (db *Database) GetKnownGoodServiceVersion(serviceID string) (aosVersion uint64, err error) {
+	if err = db.getDataFromQuery(
+		fmt.Sprintf("SELECT aosVersion FROM knowngoodservices WHERE id = \"%s\"", serviceID),
+		&aosVersion); err != nil {
+		if errors.Is(err, errNotExist) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	return aosVersion, nil
+}
+
 // SetTrafficMonitorData stores traffic monitor data.
 func (db *Database) SetTrafficMonitorData(chain string, timestamp time.Time, value uint64) (err error) {
 	if err = db.executeQuery("UPDATE trafficmonitor SET time = ?, value = ? where chain = ?",
@@ -189,6 +292,16 @@ func (db *Database) GetTrafficMonitorData(chain string) (timestamp time.Time, va
 	return timestamp, value, nil
 }
 
+// GetAllTrafficMonitorData returns traffic monitor data of all chains.
+func (db *Database) GetAllTrafficMonitorData() (data []TrafficMonitorData, err error) {
+	return getFromQuery(
+		db,
+		"SELECT * FROM trafficmonitor",
+		func(chainData *TrafficMonitorData) []any {
+			return []any{&chainData.Chain, &chainData.Timestamp, &chainData.Value}
+		})
+}
+
 // RemoveTrafficMonitorData removes existing traffic monitor entry.
 func (db *Database) RemoveTrafficMonitorData(chain string) (err error) {
 	if err = db.executeQuery("DELETE FROM trafficmonitor WHERE chain = ?", chain); errors.Is(err, errNotExist) {
@@ -198,6 +311,50 @@ func (db *Database) RemoveTrafficMonitorData(chain string) (err error) {
 	return err
 }
 
+// GetDownloadInfo returns partial download info by URL.
+func (db *Database) GetDownloadInfo(url string) (downloadInfo downloader.DownloadInfo, err error) {
+	downloadInfos, err := db.getDownloadInfosFromQuery("SELECT * FROM downloads WHERE url = ?", url)
+	if err != nil {
+		return downloadInfo, err
+	}
+
+	if len(downloadInfos) == 0 {
+		return downloadInfo, downloader.ErrNotExist
+	}
+
+	return downloadInfos[0], nil
+}
+
+// GetAllDownloadInfos returns all partial download infos.
+func (db *Database) GetAllDownloadInfos() (downloadInfos []downloader.DownloadInfo, err error) {
+	return db.getDownloadInfosFromQuery("SELECT * FROM downloads")
+}
+
+// SetDownloadInfo stores partial download info.
+func (db *Database) SetDownloadInfo(downloadInfo downloader.DownloadInfo) (err error) {
+	if err = db.executeQuery("UPDATE downloads SET fileName = ?, size = ?, sha256 = ?, timestamp = ? WHERE url = ?",
+		downloadInfo.FileName, downloadInfo.Size, downloadInfo.Sha256, downloadInfo.Timestamp,
+		downloadInfo.URL); errors.Is(err, errNotExist) {
+		if _, err := db.sql.Exec("INSERT INTO downloads VALUES(?, ?, ?, ?, ?)", downloadInfo.URL,
+			downloadInfo.FileName, downloadInfo.Size, downloadInfo.Sha256, downloadInfo.Timestamp); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		return nil
+	}
+
+	return err
+}
+
+// RemoveDownloadInfo removes partial download info.
+func (db *Database) RemoveDownloadInfo(url string) (err error) {
+	if err = db.executeQuery("DELETE FROM downloads WHERE url = ?", url); errors.Is(err, errNotExist) {
+		return nil
+	}
+
+	return err
+}
+
 // SetJournalCursor stores system logger cursor.
 func (db *Database) SetJournalCursor(cursor string) error {
 	return db.executeQuery("UPDATE config SET cursor = ?", cursor)
@@ -257,13 +414,22 @@ func (db *Database) SetOnlineTime(onlineTime time.Time) error {
 
 // AddLayer add layer to layers table.
 func (db *Database) AddLayer(layer layermanager.LayerInfo) (err error) {
-	return db.executeQuery("INSERT INTO layers values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	if err = db.executeQuery("INSERT INTO layers values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		layer.Digest, layer.LayerID, layer.Path, layer.OSVersion, layer.VendorVersion,
-		layer.Description, layer.AosVersion, layer.Timestamp, layer.Cached, layer.Size)
+		layer.Description, layer.AosVersion, layer.Timestamp, layer.Cached, layer.Size); err != nil {
+		return err
+	}
+
+	return db.executeQuery("INSERT OR REPLACE INTO layerintegrity values(?, ?, ?)",
+		layer.Digest, layer.ContentDigest, layer.Damaged)
 }
 
 // DeleteLayerByDigest remove layer from DB by digest.
 func (db *Database) DeleteLayerByDigest(digest string) (err error) {
+	if _, err = db.sql.Exec("DELETE FROM layerintegrity WHERE digest = ?", digest); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
 	if err = db.executeQuery("DELETE FROM layers WHERE digest = ?", digest); errors.Is(err, errNotExist) {
 		return nil
 	}
@@ -275,22 +441,26 @@ func (db *Database) DeleteLayerByDigest(digest string) (err error) {
 func (db *Database) GetLayersInfo() (layersList []layermanager.LayerInfo, err error) {
 	return getFromQuery(
 		db,
-		"SELECT * FROM layers",
+		"SELECT layers.*, IFNULL(contentDigest, \"\"), IFNULL(damaged, 0) FROM layers "+
+			"LEFT JOIN layerintegrity USING(digest)",
 		func(layer *layermanager.LayerInfo) []any {
 			return []any{
 				&layer.Digest, &layer.LayerID, &layer.Path, &layer.OSVersion,
 				&layer.VendorVersion, &layer.Description, &layer.AosVersion, &layer.Timestamp,
-				&layer.Cached, &layer.Size,
+				&layer.Cached, &layer.Size, &layer.ContentDigest, &layer.Damaged,
 			}
 		})
 }
 
 // GetLayerInfoByDigest returns layers information by layer digest.
 func (db *Database) GetLayerInfoByDigest(digest string) (layer layermanager.LayerInfo, err error) {
-	if err = db.getDataFromQuery(fmt.Sprintf("SELECT * FROM layers WHERE digest = \"%s\"", digest),
+	if err = db.getDataFromQuery(fmt.Sprintf(
+		"SELECT layers.*, IFNULL(contentDigest, \"\"), IFNULL(damaged, 0) FROM layers "+
+			"LEFT JOIN layerintegrity USING(digest) WHERE layers.digest = \"%s\"", digest),
 		&layer.Digest, &layer.LayerID, &layer.Path, &layer.OSVersion,
 		&layer.VendorVersion, &layer.Description,
-		&layer.AosVersion, &layer.Timestamp, &layer.Cached, &layer.Size); err != nil {
+		&layer.AosVersion, &layer.Timestamp, &layer.Cached, &layer.Size,
+		&layer.ContentDigest, &layer.Damaged); err != nil {
 		if errors.Is(err, errNotExist) {
 			return layer, layermanager.ErrNotExist
 		}
@@ -321,6 +491,113 @@ func (db *Database) SetLayerCached(digest string, cached bool) (err error) {
 	return err
 }
 
+// SetLayerDamaged sets damaged status for the layer.
+func (db *Database) SetLayerDamaged(digest string, damaged bool) (err error) {
+	if err = db.getDataFromQuery(fmt.Sprintf("SELECT digest FROM layers WHERE digest = \"%s\"", digest),
+		&digest); err != nil {
+		if errors.Is(err, errNotExist) {
+			return layermanager.ErrNotExist
+		}
+
+		return err
+	}
+
+	// Layers installed before integrity check was introduced have no integrity entry
+	_, err = db.sql.Exec(`INSERT INTO layerintegrity values(?, "", ?)
+						  ON CONFLICT(digest) DO UPDATE SET damaged = excluded.damaged`, digest, damaged)
+
+	return aoserrors.Wrap(err)
+}
+
+// AddFSImage adds service or layer image information to db.
+func (db *Database) AddFSImage(info fsimage.ImageInfo) error {
+	return db.executeQuery("INSERT OR REPLACE INTO fsimages values(?, ?, ?, ?)",
+		info.MountPoint, info.ImagePath, info.Format, info.RootHash)
+}
+
+// GetFSImage returns image information by mount point.
+func (db *Database) GetFSImage(mountPoint string) (info fsimage.ImageInfo, err error) {
+	infos, err := getFromQuery(db, "SELECT * FROM fsimages WHERE mountPoint = ?",
+		func(info *fsimage.ImageInfo) []any {
+			return []any{&info.MountPoint, &info.ImagePath, &info.Format, &info.RootHash}
+		}, mountPoint)
+	if err != nil {
+		return info, err
+	}
+
+	if len(infos) == 0 {
+		return info, fsimage.ErrNotExist
+	}
+
+	return infos[0], nil
+}
+
+// RemoveFSImage removes image information from db.
+func (db *Database) RemoveFSImage(mountPoint string) (err error) {
+	if err = db.executeQuery("DELETE FROM fsimages WHERE mountPoint = ?", mountPoint); errors.Is(err, errNotExist) {
+		return fsimage.ErrNotExist
+	}
+
+	return err
+}
+
+// SetItemLastUsed sets service or layer last use time.
+func (db *Database) SetItemLastUsed(itemType, id string, lastUsed time.Time) error {
+	_, err := db.sql.Exec("INSERT OR REPLACE INTO itemusage values(?, ?, ?)", itemType, id, lastUsed)
+
+	return aoserrors.Wrap(err)
+}
+
+// GetItemLastUsed returns service or layer last use time.
+func (db *Database) GetItemLastUsed(itemType, id string) (lastUsed time.Time, err error) {
+	lastUsedTimes, err := getFromQuery(db, "SELECT lastUsed FROM itemusage WHERE type = ? AND id = ?",
+		func(lastUsed *time.Time) []any {
+			return []any{lastUsed}
+		}, itemType, id)
+	if err != nil {
+		return lastUsed, err
+	}
+
+	if len(lastUsedTimes) == 0 {
+		return lastUsed, eviction.ErrNotExist
+	}
+
+	return lastUsedTimes[0], nil
+}
+
+// RemoveItemUsage removes service or layer usage information.
+func (db *Database) RemoveItemUsage(itemType, id string) (err error) {
+	if err = db.executeQuery("DELETE FROM itemusage WHERE type = ? AND id = ?",
+		itemType, id); errors.Is(err, errNotExist) {
+		return eviction.ErrNotExist
+	}
+
+	return err
+}
+
+// AddEvictedItem adds evicted item and removes the oldest evicted items of the same type above max items count.
+func (db *Database) AddEvictedItem(item eviction.EvictedItem, maxItems int) error {
+	if _, err := db.sql.Exec("INSERT INTO evicteditems VALUES(?, ?, ?, ?, ?, ?)",
+		item.Type, item.ID, item.AosVersion, item.Size, item.Reason, item.Timestamp); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if _, err := db.sql.Exec(`DELETE FROM evicteditems WHERE rowid IN (SELECT rowid FROM evicteditems
+		WHERE type = ? ORDER BY timestamp DESC LIMIT -1 OFFSET ?)`, item.Type, maxItems); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+// GetEvictedItems returns evicted services and layers ordered by eviction time.
+func (db *Database) GetEvictedItems() (items []eviction.EvictedItem, err error) {
+	return getFromQuery(db, "SELECT * FROM evicteditems ORDER BY timestamp",
+		func(item *eviction.EvictedItem) []any {
+			return []any{&item.Type, &item.ID, &item.AosVersion, &item.Size, &item.Reason, &item.Timestamp}
+		})
+}
+
 // AddInstance adds instance information to db.
 func (db *Database) AddInstance(instance launcher.InstanceInfo) error {
 	network, err := json.Marshal(instance.NetworkParameters)
@@ -398,38 +675,127 @@ func (db *Database) GetAllInstances() (instances []launcher.InstanceInfo, err er
 
 // GetInstanceIDs returns instance ids by filter.
 func (db *Database) GetInstanceIDs(filter cloudprotocol.InstanceFilter) (instances []string, err error) {
-	var (
-		conditionList []string
-		whereOperator string
-	)
-
-	if filter.ServiceID != nil {
-		conditionList = append(conditionList, fmt.Sprintf("serviceID = \"%s\"", *filter.ServiceID))
+	instanceInfos, err := db.getInstancesFromQuery(
+		fmt.Sprintf("SELECT * FROM instances %s", getInstanceFilterCondition(filter)))
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
 	}
 
-	if filter.SubjectID != nil {
-		conditionList = append(conditionList, fmt.Sprintf("subjectID = \"%s\"", *filter.SubjectID))
+	for _, value := range instanceInfos {
+		instances = append(instances, value.InstanceID)
 	}
 
-	if filter.Instance != nil {
-		conditionList = append(conditionList, fmt.Sprintf("instance = %d", *filter.Instance))
+	return instances, nil
+}
+
+// AddInstanceEvent adds instance event and removes the oldest instance events above max events count.
+func (db *Database) AddInstanceEvent(event launcher.InstanceEvent, maxEvents int) error {
+	if _, err := db.sql.Exec("INSERT INTO instanceevents VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		event.ServiceID, event.SubjectID, event.Instance, event.InstanceID, event.Timestamp, event.Type,
+		event.ExitCode, event.Signal, event.Message); err != nil {
+		return aoserrors.Wrap(err)
 	}
 
-	if len(conditionList) > 0 {
-		whereOperator = "WHERE"
+	if _, err := db.sql.Exec(`DELETE FROM instanceevents WHERE rowid IN (SELECT rowid FROM instanceevents
+		WHERE serviceID = ? AND subjectID = ? AND instance = ? ORDER BY timestamp DESC LIMIT -1 OFFSET ?)`,
+		event.ServiceID, event.SubjectID, event.Instance, maxEvents); err != nil {
+		return aoserrors.Wrap(err)
 	}
 
-	instanceInfos, err := db.getInstancesFromQuery(fmt.Sprintf("SELECT * FROM instances %s %s", whereOperator,
-		strings.Join(conditionList, " AND ")))
+	return nil
+}
+
+// GetInstanceEvents returns instance events by filter ordered by timestamp.
+func (db *Database) GetInstanceEvents(
+	filter cloudprotocol.InstanceFilter,
+) (events []launcher.InstanceEvent, err error) {
+	rows, err := db.sql.Query(fmt.Sprintf("SELECT * FROM instanceevents %s ORDER BY timestamp",
+		getInstanceFilterCondition(filter)))
 	if err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
+	defer rows.Close()
 
-	for _, value := range instanceInfos {
-		instances = append(instances, value.InstanceID)
+	if rows.Err() != nil {
+		return nil, aoserrors.Wrap(rows.Err())
 	}
 
-	return instances, nil
+	for rows.Next() {
+		var event launcher.InstanceEvent
+
+		if err = rows.Scan(&event.ServiceID, &event.SubjectID, &event.Instance, &event.InstanceID, &event.Timestamp,
+			&event.Type, &event.ExitCode, &event.Signal, &event.Message); err != nil {
+			return nil, aoserrors.Wrap(err)
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// GetInstanceHistory returns instance events by filter for instance crash log.
+func (db *Database) GetInstanceHistory(filter cloudprotocol.InstanceFilter) ([]logging.InstanceEvent, error) {
+	events, err := db.GetInstanceEvents(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]logging.InstanceEvent, 0, len(events))
+
+	for _, event := range events {
+		history = append(history, logging.InstanceEvent{
+			InstanceID: event.InstanceID, Timestamp: event.Timestamp, Type: event.Type, ExitCode: event.ExitCode,
+			Signal: event.Signal, Message: event.Message,
+		})
+	}
+
+	return history, nil
+}
+
+// AddScheduledRun adds scheduled instance run and removes the oldest runs above max runs count.
+func (db *Database) AddScheduledRun(run launcher.ScheduledRun, maxRuns int) error {
+	if _, err := db.sql.Exec("INSERT INTO scheduledruns VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		run.ServiceID, run.SubjectID, run.Instance, run.InstanceID, run.ScheduledTime, run.StartTime, run.EndTime,
+		run.Result, run.ExitCode, run.Message); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if _, err := db.sql.Exec(`DELETE FROM scheduledruns WHERE rowid IN (SELECT rowid FROM scheduledruns
+		WHERE serviceID = ? AND subjectID = ? AND instance = ? ORDER BY scheduledTime DESC LIMIT -1 OFFSET ?)`,
+		run.ServiceID, run.SubjectID, run.Instance, maxRuns); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+// GetLastScheduledRun returns the latest scheduled run of the instance.
+func (db *Database) GetLastScheduledRun(instanceIdent aostypes.InstanceIdent) (run launcher.ScheduledRun, err error) {
+	if err = db.sql.QueryRow(`SELECT * FROM scheduledruns WHERE serviceID = ? AND subjectID = ? AND instance = ?
+		ORDER BY scheduledTime DESC LIMIT 1`, instanceIdent.ServiceID, instanceIdent.SubjectID, instanceIdent.Instance,
+	).Scan(&run.ServiceID, &run.SubjectID, &run.Instance, &run.InstanceID, &run.ScheduledTime, &run.StartTime,
+		&run.EndTime, &run.Result, &run.ExitCode, &run.Message); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return run, aoserrors.Wrap(launcher.ErrNotExist)
+		}
+
+		return run, aoserrors.Wrap(err)
+	}
+
+	return run, nil
+}
+
+// GetScheduledRuns returns scheduled instance runs by filter ordered by scheduled time.
+func (db *Database) GetScheduledRuns(filter cloudprotocol.InstanceFilter) (runs []launcher.ScheduledRun, err error) {
+	return getFromQuery(db, fmt.Sprintf("SELECT * FROM scheduledruns %s ORDER BY scheduledTime",
+		getInstanceFilterCondition(filter)),
+		func(run *launcher.ScheduledRun) []any {
+			return []any{
+				&run.ServiceID, &run.SubjectID, &run.Instance, &run.InstanceID, &run.ScheduledTime, &run.StartTime,
+				&run.EndTime, &run.Result, &run.ExitCode, &run.Message,
+			}
+		})
 }
 
 // AddNetworkInfo adds network information to db.
@@ -561,6 +927,46 @@ func newDatabase(name string, migrationPath string, mergedMigrationPath string,
 		return db, err
 	}
 
+	if err := db.createKnownGoodServicesTable(); err != nil {
+		return db, err
+	}
+
+	if err := db.createInstanceEventsTable(); err != nil {
+		return db, err
+	}
+
+	if err := db.createDownloadsTable(); err != nil {
+		return db, err
+	}
+
+	if err := db.createDamagedServicesTable(); err != nil {
+		return db, err
+	}
+
+	if err := db.createServiceManifestsTable(); err != nil {
+		return db, err
+	}
+
+	if err := db.createLayerIntegrityTable(); err != nil {
+		return db, err
+	}
+
+	if err := db.createFSImagesTable(); err != nil {
+		return db, err
+	}
+
+	if err := db.createItemUsageTable(); err != nil {
+		return db, err
+	}
+
+	if err := db.createEvictedItemsTable(); err != nil {
+		return db, err
+	}
+
+	if err := db.createScheduledRunsTable(); err != nil {
+		return db, err
+	}
+
 	return db, nil
 }
 
@@ -682,7 +1088,142 @@ func (db *Database) createInstancesTable() (err error) {
 	return aoserrors.Wrap(err)
 }
 
+func (db *Database) createKnownGoodServicesTable() (err error) {
+	log.Info("Create known good services table")
+
+	_, err = db.sql.Exec(`CREATE TABLE IF NOT EXISTS knowngoodservices (id TEXT NOT NULL PRIMARY KEY,
+																		aosVersion INTEGER)`)
+
+	return aoserrors.Wrap(err)
+}
+
+func (db *Database) createInstanceEventsTable() (err error) {
+	log.Info("Create instance events table")
+
+	_, err = db.sql.Exec(`CREATE TABLE IF NOT EXISTS instanceevents (serviceID TEXT,
+																	 subjectID TEXT,
+																	 instance INTEGER,
+																	 instanceID TEXT,
+																	 timestamp TIMESTAMP,
+																	 type TEXT,
+																	 exitCode INTEGER,
+																	 signal INTEGER,
+																	 message TEXT)`)
+
+	return aoserrors.Wrap(err)
+}
+
+func (db *Database) createScheduledRunsTable() (err error) {
+	log.Info("Create scheduled runs table")
+
+	_, err = db.sql.Exec(`CREATE TABLE IF NOT EXISTS scheduledruns (serviceID TEXT,
+																	subjectID TEXT,
+																	instance INTEGER,
+																	instanceID TEXT,
+																	scheduledTime TIMESTAMP,
+																	startTime TIMESTAMP,
+																	endTime TIMESTAMP,
+																	result TEXT,
+																	exitCode INTEGER,
+																	message TEXT)`)
+
+	return aoserrors.Wrap(err)
+}
+
+func (db *Database) createDownloadsTable() (err error) {
+	log.Info("Create downloads table")
+
+	_, err = db.sql.Exec(`CREATE TABLE IF NOT EXISTS downloads (url TEXT NOT NULL PRIMARY KEY,
+																fileName TEXT,
+																size INTEGER,
+																sha256 BLOB,
+																timestamp TIMESTAMP)`)
+
+	return aoserrors.Wrap(err)
+}
+
+func (db *Database) createDamagedServicesTable() (err error) {
+	log.Info("Create damaged services table")
+
+	_, err = db.sql.Exec(`CREATE TABLE IF NOT EXISTS damagedservices (id TEXT NOT NULL,
+																	  aosVersion INTEGER,
+																	  PRIMARY KEY(id, aosVersion))`)
+
+	return aoserrors.Wrap(err)
+}
+
+func (db *Database) createServiceManifestsTable() (err error) {
+	log.Info("Create service manifests table")
+
+	_, err = db.sql.Exec(`CREATE TABLE IF NOT EXISTS servicemanifests (id TEXT NOT NULL,
+																	   aosVersion INTEGER,
+																	   digest BLOB,
+																	   PRIMARY KEY(id, aosVersion))`)
+
+	return aoserrors.Wrap(err)
+}
+
+func (db *Database) createLayerIntegrityTable() (err error) {
+	log.Info("Create layer integrity table")
+
+	_, err = db.sql.Exec(`CREATE TABLE IF NOT EXISTS layerintegrity (digest TEXT NOT NULL PRIMARY KEY,
+																	 contentDigest TEXT,
+																	 damaged INTEGER)`)
+
+	return aoserrors.Wrap(err)
+}
+
+func (db *Database) createFSImagesTable() (err error) {
+	log.Info("Create fs images table")
+
+	_, err = db.sql.Exec(`CREATE TABLE IF NOT EXISTS fsimages (mountPoint TEXT NOT NULL PRIMARY KEY,
+															   imagePath TEXT,
+															   format TEXT,
+															   rootHash TEXT)`)
+
+	return aoserrors.Wrap(err)
+}
+
+func (db *Database) createItemUsageTable() (err error) {
+	log.Info("Create item usage table")
+
+	_, err = db.sql.Exec(`CREATE TABLE IF NOT EXISTS itemusage (type TEXT NOT NULL,
+																id TEXT NOT NULL,
+																lastUsed TIMESTAMP,
+																PRIMARY KEY(type, id))`)
+
+	return aoserrors.Wrap(err)
+}
+
+func (db *Database) createEvictedItemsTable() (err error) {
+	log.Info("Create evicted items table")
+
+	_, err = db.sql.Exec(`CREATE TABLE IF NOT EXISTS evicteditems (type TEXT NOT NULL,
+																   id TEXT NOT NULL,
+																   aosVersion INTEGER,
+																   size INTEGER,
+																   reason TEXT,
+																   timestamp TIMESTAMP)`)
+
+	return aoserrors.Wrap(err)
+}
+
+func (db *Database) getDownloadInfosFromQuery(
+	query string, args ...interface{},
+) (downloadInfos []downloader.DownloadInfo, err error) {
+	return getFromQuery(db, query, func(downloadInfo *downloader.DownloadInfo) []any {
+		return []any{
+			&downloadInfo.URL, &downloadInfo.FileName, &downloadInfo.Size, &downloadInfo.Sha256,
+			&downloadInfo.Timestamp,
+		}
+	}, args...)
+}
+
 func (db *Database) removeAllServices() (err error) {
+	if _, err = db.sql.Exec("DELETE FROM servicemanifests"); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
 	_, err = db.sql.Exec("DELETE FROM services")
 
 	return aoserrors.Wrap(err)
@@ -824,3 +1365,25 @@ func (db *Database) getDataFromQuery(query string, result ...interface{}) error
 
 	return nil
 }
+
+func getInstanceFilterCondition(filter cloudprotocol.InstanceFilter) string {
+	var conditionList []string
+
+	if filter.ServiceID != nil {
+		conditionList = append(conditionList, fmt.Sprintf("serviceID = \"%s\"", *filter.ServiceID))
+	}
+
+	if filter.SubjectID != nil {
+		conditionList = append(conditionList, fmt.Sprintf("subjectID = \"%s\"", *filter.SubjectID))
+	}
+
+	if filter.Instance != nil {
+		conditionList = append(conditionList, fmt.Sprintf("instance = %d", *filter.Instance))
+	}
+
+	if len(conditionList) == 0 {
+		return ""
+	}
+
+	return "WHERE " + strings.Join(conditionList, " AND ")
+}
@@ -36,6 +36,9 @@ import (
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/aosedge/aos_servicemanager/downloader"
+	"github.com/aosedge/aos_servicemanager/eviction"
+	"github.com/aosedge/aos_servicemanager/fsimage"
 	"github.com/aosedge/aos_servicemanager/launcher"
 	"github.com/aosedge/aos_servicemanager/layermanager"
 	"github.com/aosedge/aos_servicemanager/networkmanager"
@@ -132,9 +135,10 @@ func TestAddGetService(t *testing.T) {
 		VersionInfo: aostypes.VersionInfo{
 			AosVersion: 1,
 		},
-		ServiceProvider: "sp1",
-		ImagePath:       "to/service1",
-		Size:            80,
+		ServiceProvider:        "sp1",
+		ImagePath:              "to/service1",
+		Size:                   80,
+		OriginalManifestDigest: []byte("originalManifestDigest"),
 	}
 
 	if err := db.AddService(service2); err != nil {
@@ -353,6 +357,15 @@ func TestTrafficMonitor(t *testing.T) {
 		t.Fatalf("Wrong value time: %s, value %d", getTime, getValue)
 	}
 
+	allData, err := db.GetAllTrafficMonitorData()
+	if err != nil {
+		t.Fatalf("Can't get all traffic monitor data: %s", err)
+	}
+
+	if len(allData) != 1 || allData[0].Chain != "chain1" || allData[0].Value != setValue {
+		t.Errorf("Wrong traffic monitor data: %v", allData)
+	}
+
 	if err := db.RemoveTrafficMonitorData("chain1"); err != nil {
 		t.Fatalf("Can't remove traffic monitor: %s", err)
 	}
@@ -367,6 +380,298 @@ func TestTrafficMonitor(t *testing.T) {
 	}
 }
 
+func TestDownloadInfo(t *testing.T) {
+	if _, err := db.GetDownloadInfo("http://server/file0"); !errors.Is(err, downloader.ErrNotExist) {
+		t.Errorf("Wrong error: %v", err)
+	}
+
+	setInfos := []downloader.DownloadInfo{
+		{
+			URL: "http://server/file0", FileName: "/download/file0", Size: 1024, Sha256: []byte{1, 2, 3},
+			Timestamp: time.Now().UTC(),
+		},
+		{
+			URL: "http://server/file1", FileName: "/download/file1", Size: 2048, Sha256: []byte{4, 5, 6},
+			Timestamp: time.Now().UTC(),
+		},
+	}
+
+	for _, setInfo := range setInfos {
+		if err := db.SetDownloadInfo(setInfo); err != nil {
+			t.Fatalf("Can't set download info: %v", err)
+		}
+	}
+
+	setInfos[0].Timestamp = time.Now().UTC().Add(time.Hour)
+
+	if err := db.SetDownloadInfo(setInfos[0]); err != nil {
+		t.Fatalf("Can't set download info: %v", err)
+	}
+
+	getInfo, err := db.GetDownloadInfo(setInfos[0].URL)
+	if err != nil {
+		t.Fatalf("Can't get download info: %v", err)
+	}
+
+	if !reflect.DeepEqual(getInfo, setInfos[0]) {
+		t.Errorf("Wrong download info: %v", getInfo)
+	}
+
+	getInfos, err := db.GetAllDownloadInfos()
+	if err != nil {
+		t.Fatalf("Can't get all download infos: %v", err)
+	}
+
+	if !reflect.DeepEqual(getInfos, setInfos) {
+		t.Errorf("Wrong download infos: %v", getInfos)
+	}
+
+	for _, setInfo := range setInfos {
+		if err = db.RemoveDownloadInfo(setInfo.URL); err != nil {
+			t.Fatalf("Can't remove download info: %v", err)
+		}
+	}
+
+	if getInfos, err = db.GetAllDownloadInfos(); err != nil {
+		t.Fatalf("Can't get all download infos: %v", err)
+	}
+
+	if len(getInfos) != 0 {
+		t.Errorf("Wrong download infos count: %d", len(getInfos))
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	if err := db.SetJournalCursor("readOnlyCursor"); err != nil {
+		t.Fatalf("Can't set journal cursor: %s", err)
+	}
+
+	readOnlyDB, err := NewReadOnly(path.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Can't open read only database: %s", err)
+	}
+	defer readOnlyDB.Close()
+
+	cursor, err := readOnlyDB.GetJournalCursor()
+	if err != nil {
+		t.Fatalf("Can't get journal cursor: %s", err)
+	}
+
+	if cursor != "readOnlyCursor" {
+		t.Errorf("Wrong journal cursor: %s", cursor)
+	}
+
+	if err = readOnlyDB.SetJournalCursor("newCursor"); err == nil {
+		t.Error("Read only database should not be modified")
+	}
+
+	if _, err = NewReadOnly(path.Join(tmpDir, "notExist.db")); err == nil {
+		t.Error("Not existing database should not be opened")
+	}
+}
+
+func TestDamagedService(t *testing.T) {
+	service := servicemanager.ServiceInfo{
+		ServiceID:   "damagedService",
+		VersionInfo: aostypes.VersionInfo{AosVersion: 1},
+		ImagePath:   "to/damagedService",
+	}
+
+	if err := db.AddService(service); err != nil {
+		t.Fatalf("Can't add service: %v", err)
+	}
+
+	if err := db.SetServiceDamaged(service.ServiceID, 2, true); !errors.Is(err, servicemanager.ErrNotExist) {
+		t.Errorf("Unexpected set damaged error: %v", err)
+	}
+
+	for _, damaged := range []bool{true, true, false, true} {
+		if err := db.SetServiceDamaged(service.ServiceID, service.AosVersion, damaged); err != nil {
+			t.Fatalf("Can't set service damaged: %v", err)
+		}
+
+		services, err := db.GetAllServiceVersions(service.ServiceID)
+		if err != nil {
+			t.Fatalf("Can't get service: %v", err)
+		}
+
+		if len(services) != 1 || services[0].Damaged != damaged {
+			t.Errorf("Wrong service damaged status: %v", services)
+		}
+	}
+
+	if err := db.RemoveService(service.ServiceID, service.AosVersion); err != nil {
+		t.Fatalf("Can't remove service: %v", err)
+	}
+
+	// Damaged status should not be inherited by new service with the same version
+	if err := db.AddService(service); err != nil {
+		t.Fatalf("Can't add service: %v", err)
+	}
+
+	services, err := db.GetAllServiceVersions(service.ServiceID)
+	if err != nil {
+		t.Fatalf("Can't get service: %v", err)
+	}
+
+	if !reflect.DeepEqual(services, []servicemanager.ServiceInfo{service}) {
+		t.Errorf("Wrong services: %v", services)
+	}
+
+	if err = db.RemoveService(service.ServiceID, service.AosVersion); err != nil {
+		t.Errorf("Can't remove service: %v", err)
+	}
+}
+
+func TestKnownGoodServiceVersion(t *testing.T) {
+	aosVersion, err := db.GetKnownGoodServiceVersion("knownGoodService")
+	if err != nil {
+		t.Fatalf("Can't get known good service version: %v", err)
+	}
+
+	if aosVersion != 0 {
+		t.Errorf("Wrong known good service version: %d", aosVersion)
+	}
+
+	for _, setVersion := range []uint64{1, 3} {
+		if err = db.SetKnownGoodServiceVersion("knownGoodService", setVersion); err != nil {
+			t.Fatalf("Can't set known good service version: %v", err)
+		}
+
+		if aosVersion, err = db.GetKnownGoodServiceVersion("knownGoodService"); err != nil {
+			t.Fatalf("Can't get known good service version: %v", err)
+		}
+
+		if aosVersion != setVersion {
+			t.Errorf("Wrong known good service version: %d", aosVersion)
+		}
+	}
+}
+
+func TestInstanceEvents(t *testing.T) {
+	const maxEvents = 3
+
+	ident := aostypes.InstanceIdent{ServiceID: "eventService", SubjectID: "eventSubject", Instance: 1}
+	otherIdent := aostypes.InstanceIdent{ServiceID: "eventService", SubjectID: "eventSubject", Instance: 2}
+	startTime := time.Now().UTC()
+
+	for i := 0; i < 5; i++ {
+		if err := db.AddInstanceEvent(launcher.InstanceEvent{
+			InstanceIdent: ident, InstanceID: "eventInstance", Timestamp: startTime.Add(time.Duration(i) * time.Second),
+			Type: launcher.InstanceEventCrash, ExitCode: i, Signal: 9, Message: "crashed",
+		}, maxEvents); err != nil {
+			t.Fatalf("Can't add instance event: %v", err)
+		}
+	}
+
+	if err := db.AddInstanceEvent(launcher.InstanceEvent{
+		InstanceIdent: otherIdent, InstanceID: "otherInstance", Timestamp: startTime,
+		Type: launcher.InstanceEventStart,
+	}, maxEvents); err != nil {
+		t.Fatalf("Can't add instance event: %v", err)
+	}
+
+	events, err := db.GetInstanceEvents(cloudprotocol.InstanceFilter{
+		ServiceID: &ident.ServiceID, SubjectID: &ident.SubjectID, Instance: &ident.Instance,
+	})
+	if err != nil {
+		t.Fatalf("Can't get instance events: %v", err)
+	}
+
+	if len(events) != maxEvents {
+		t.Fatalf("Wrong instance events count: %d", len(events))
+	}
+
+	for i, event := range events {
+		if event.InstanceIdent != ident || event.InstanceID != "eventInstance" ||
+			event.Type != launcher.InstanceEventCrash || event.ExitCode != i+2 || event.Signal != 9 ||
+			event.Message != "crashed" || !event.Timestamp.Equal(startTime.Add(time.Duration(i+2)*time.Second)) {
+			t.Errorf("Wrong instance event: %v", event)
+		}
+	}
+
+	if events, err = db.GetInstanceEvents(cloudprotocol.InstanceFilter{ServiceID: &ident.ServiceID}); err != nil {
+		t.Fatalf("Can't get instance events: %v", err)
+	}
+
+	if len(events) != maxEvents+1 {
+		t.Errorf("Wrong instance events count: %d", len(events))
+	}
+
+	history, err := db.GetInstanceHistory(cloudprotocol.InstanceFilter{
+		ServiceID: &otherIdent.ServiceID, SubjectID: &otherIdent.SubjectID, Instance: &otherIdent.Instance,
+	})
+	if err != nil {
+		t.Fatalf("Can't get instance history: %v", err)
+	}
+
+	if len(history) != 1 || history[0].InstanceID != "otherInstance" ||
+		history[0].Type != launcher.InstanceEventStart || !history[0].Timestamp.Equal(startTime) {
+		t.Errorf("Wrong instance history: %v", history)
+	}
+}
+
+func TestScheduledRuns(t *testing.T) {
+	const maxRuns = 3
+
+	ident := aostypes.InstanceIdent{ServiceID: "scheduledService", SubjectID: "scheduledSubject", Instance: 1}
+	scheduledTime := time.Now().UTC()
+
+	if _, err := db.GetLastScheduledRun(ident); !errors.Is(err, launcher.ErrNotExist) {
+		t.Errorf("Wrong error: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := db.AddScheduledRun(launcher.ScheduledRun{
+			InstanceIdent: ident, InstanceID: "scheduledInstance",
+			ScheduledTime: scheduledTime.Add(time.Duration(i) * time.Minute),
+			StartTime:     scheduledTime.Add(time.Duration(i) * time.Minute),
+			EndTime:       scheduledTime.Add(time.Duration(i)*time.Minute + time.Second),
+			Result:        launcher.ScheduledRunFailed, ExitCode: i, Message: "failed",
+		}, maxRuns); err != nil {
+			t.Fatalf("Can't add scheduled run: %v", err)
+		}
+	}
+
+	run, err := db.GetLastScheduledRun(ident)
+	if err != nil {
+		t.Fatalf("Can't get last scheduled run: %v", err)
+	}
+
+	if run.InstanceIdent != ident || run.InstanceID != "scheduledInstance" ||
+		run.Result != launcher.ScheduledRunFailed || run.ExitCode != 4 || run.Message != "failed" ||
+		!run.ScheduledTime.Equal(scheduledTime.Add(4*time.Minute)) ||
+		!run.EndTime.Equal(scheduledTime.Add(4*time.Minute+time.Second)) {
+		t.Errorf("Wrong scheduled run: %v", run)
+	}
+
+	var count int
+
+	if err = db.sql.QueryRow("SELECT COUNT(*) FROM scheduledruns WHERE serviceID = ?",
+		ident.ServiceID).Scan(&count); err != nil {
+		t.Fatalf("Can't get scheduled runs count: %v", err)
+	}
+
+	if count != maxRuns {
+		t.Errorf("Wrong scheduled runs count: %d", count)
+	}
+
+	runs, err := db.GetScheduledRuns(cloudprotocol.InstanceFilter{ServiceID: &ident.ServiceID})
+	if err != nil {
+		t.Fatalf("Can't get scheduled runs: %v", err)
+	}
+
+	if len(runs) != maxRuns {
+		t.Fatalf("Wrong scheduled runs count: %d", len(runs))
+	}
+
+	for i, run := range runs {
+		if run.InstanceIdent != ident || run.ExitCode != i+2 {
+			t.Errorf("Wrong scheduled run: %v", run)
+		}
+	}
+}
+
 func TestOperationVersion(t *testing.T) {
 	var setOperationVersion uint64 = 123
 
@@ -599,6 +904,173 @@ func TestLayers(t *testing.T) {
 	}
 }
 
+func TestLayerIntegrity(t *testing.T) {
+	layer := layermanager.LayerInfo{
+		Digest: "sha256:integrity", LayerID: "integrity", Path: "integrityPath",
+		ContentDigest: "sha256:content",
+	}
+
+	if err := db.AddLayer(layer); err != nil {
+		t.Fatalf("Can't add layer: %v", err)
+	}
+
+	if err := db.SetLayerDamaged("sha256:unknown", true); !errors.Is(err, layermanager.ErrNotExist) {
+		t.Errorf("Unexpected set damaged error: %v", err)
+	}
+
+	for _, damaged := range []bool{true, false, true} {
+		if err := db.SetLayerDamaged(layer.Digest, damaged); err != nil {
+			t.Fatalf("Can't set layer damaged: %v", err)
+		}
+
+		layer.Damaged = damaged
+
+		savedLayer, err := db.GetLayerInfoByDigest(layer.Digest)
+		if err != nil {
+			t.Fatalf("Can't get layer info: %v", err)
+		}
+
+		if !reflect.DeepEqual(savedLayer, layer) {
+			t.Errorf("Wrong layer info: %v", savedLayer)
+		}
+	}
+
+	// Layer without integrity entry
+	if _, err := db.sql.Exec("DELETE FROM layerintegrity WHERE digest = ?", layer.Digest); err != nil {
+		t.Fatalf("Can't remove layer integrity: %v", err)
+	}
+
+	if err := db.SetLayerDamaged(layer.Digest, true); err != nil {
+		t.Fatalf("Can't set layer damaged: %v", err)
+	}
+
+	layers, err := db.GetLayersInfo()
+	if err != nil {
+		t.Fatalf("Can't get layers info: %v", err)
+	}
+
+	for _, savedLayer := range layers {
+		if savedLayer.Digest == layer.Digest && (!savedLayer.Damaged || savedLayer.ContentDigest != "") {
+			t.Errorf("Wrong layer info: %v", savedLayer)
+		}
+	}
+
+	if err := db.DeleteLayerByDigest(layer.Digest); err != nil {
+		t.Fatalf("Can't delete layer: %v", err)
+	}
+}
+
+func TestFSImages(t *testing.T) {
+	images := []fsimage.ImageInfo{
+		{MountPoint: "/services/service1", ImagePath: "/services/service1.img", Format: "squashfs"},
+		{MountPoint: "/layers/layer1", ImagePath: "/layers/layer1.img", Format: "erofs", RootHash: "0123456789abcdef"},
+	}
+
+	for _, image := range images {
+		if err := db.AddFSImage(image); err != nil {
+			t.Fatalf("Can't add image: %v", err)
+		}
+	}
+
+	for _, image := range images {
+		savedImage, err := db.GetFSImage(image.MountPoint)
+		if err != nil {
+			t.Fatalf("Can't get image: %v", err)
+		}
+
+		if !reflect.DeepEqual(savedImage, image) {
+			t.Errorf("Wrong image info: %v", savedImage)
+		}
+
+		if err = db.RemoveFSImage(image.MountPoint); err != nil {
+			t.Fatalf("Can't remove image: %v", err)
+		}
+
+		if _, err = db.GetFSImage(image.MountPoint); !errors.Is(err, fsimage.ErrNotExist) {
+			t.Errorf("Unexpected get image error: %v", err)
+		}
+	}
+
+	if err := db.RemoveFSImage("/services/unknown"); !errors.Is(err, fsimage.ErrNotExist) {
+		t.Errorf("Unexpected remove image error: %v", err)
+	}
+}
+
+func TestItemUsage(t *testing.T) {
+	lastUsed := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, err := db.GetItemLastUsed(eviction.ServiceItem, "service1_1"); !errors.Is(err, eviction.ErrNotExist) {
+		t.Errorf("Unexpected get last used error: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		lastUsed = lastUsed.Add(time.Hour)
+
+		if err := db.SetItemLastUsed(eviction.ServiceItem, "service1_1", lastUsed); err != nil {
+			t.Fatalf("Can't set last used: %v", err)
+		}
+
+		savedLastUsed, err := db.GetItemLastUsed(eviction.ServiceItem, "service1_1")
+		if err != nil {
+			t.Fatalf("Can't get last used: %v", err)
+		}
+
+		if !savedLastUsed.Equal(lastUsed) {
+			t.Errorf("Wrong last used time: %v", savedLastUsed)
+		}
+	}
+
+	if _, err := db.GetItemLastUsed(eviction.LayerItem, "service1_1"); !errors.Is(err, eviction.ErrNotExist) {
+		t.Errorf("Unexpected get last used error: %v", err)
+	}
+
+	if err := db.RemoveItemUsage(eviction.ServiceItem, "service1_1"); err != nil {
+		t.Fatalf("Can't remove item usage: %v", err)
+	}
+
+	if err := db.RemoveItemUsage(eviction.ServiceItem, "service1_1"); !errors.Is(err, eviction.ErrNotExist) {
+		t.Errorf("Unexpected remove item usage error: %v", err)
+	}
+}
+
+func TestEvictedItems(t *testing.T) {
+	const maxItems = 2
+
+	startTime := time.Now().UTC()
+
+	for i := 0; i < 3; i++ {
+		if err := db.AddEvictedItem(eviction.EvictedItem{
+			Type: eviction.ServiceItem, ID: "evictedService", AosVersion: uint64(i), Size: 1024,
+			Reason: eviction.ReasonNoSpace, Timestamp: startTime.Add(time.Duration(i) * time.Second),
+		}, maxItems); err != nil {
+			t.Fatalf("Can't add evicted item: %v", err)
+		}
+	}
+
+	if err := db.AddEvictedItem(eviction.EvictedItem{
+		Type: eviction.LayerItem, ID: "evictedLayer", Reason: eviction.ReasonTTL, Timestamp: startTime,
+	}, maxItems); err != nil {
+		t.Fatalf("Can't add evicted item: %v", err)
+	}
+
+	items, err := db.GetEvictedItems()
+	if err != nil {
+		t.Fatalf("Can't get evicted items: %v", err)
+	}
+
+	if len(items) != maxItems+1 || items[0].ID != "evictedLayer" {
+		t.Fatalf("Wrong evicted items: %v", items)
+	}
+
+	for i, item := range items[1:] {
+		if item.Type != eviction.ServiceItem || item.AosVersion != uint64(i+1) || item.Size != 1024 ||
+			item.Reason != eviction.ReasonNoSpace ||
+			!item.Timestamp.Equal(startTime.Add(time.Duration(i+1)*time.Second)) {
+			t.Errorf("Wrong evicted item: %v", item)
+		}
+	}
+}
+
 func TestInstances(t *testing.T) {
 	const (
 		testServiceID = "testService"
@@ -0,0 +1,466 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package downloader provides resumable bandwidth limited downloads of service and layer packages.
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/image"
+	"github.com/aosedge/aos_common/utils/retryhelper"
+	"github.com/cavaliergopher/grab/v3"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/config"
+	"github.com/aosedge/aos_servicemanager/utils/ratelimiter"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	progressPeriod       = 10 * time.Second
+	partialDownloadTTL   = 7 * 24 * time.Hour
+	maxBufferSize        = 32 * 1024
+	minBufferSize        = 1024
+	bufferSizeRateFactor = 8
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// ErrNotExist is returned when requested download info does not exist.
+var ErrNotExist = errors.New("download info does not exist")
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// DownloadInfo partial download information.
+type DownloadInfo struct {
+	URL       string
+	FileName  string
+	Size      uint64
+	Sha256    []byte
+	Timestamp time.Time
+}
+
+// Storage provides API to store partial download information.
+type Storage interface {
+	GetDownloadInfo(url string) (DownloadInfo, error)
+	GetAllDownloadInfos() ([]DownloadInfo, error)
+	SetDownloadInfo(info DownloadInfo) error
+	RemoveDownloadInfo(url string) error
+}
+
+// Downloader downloads files into download dir. Interrupted downloads are resumed from partial files.
+// Concurrent downloads of the same URL share one downloaded file.
+type Downloader struct {
+	sync.Mutex
+
+	downloadDir   string
+	storage       Storage
+	maxTry        int
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+	rateLimiter   *ratelimiter.RateLimiter
+	bufferSize    int
+	client        *grab.Client
+	downloads     map[string]*activeDownload
+	unpacks       map[string]chan struct{}
+}
+
+type activeDownload struct {
+	doneChannel chan struct{}
+	fileName    string
+	err         error
+	refCount    int
+}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// New creates new downloader.
+func New(config *config.Config, storage Storage) (downloader *Downloader, err error) {
+	log.Debug("Create downloader")
+
+	downloader = &Downloader{
+		downloadDir:   config.DownloadDir,
+		storage:       storage,
+		maxTry:        config.Downloader.MaxTry,
+		retryDelay:    config.Downloader.RetryDelay.Duration,
+		maxRetryDelay: config.Downloader.MaxRetryDelay.Duration,
+		bufferSize:    maxBufferSize,
+		client:        grab.NewClient(),
+		downloads:     make(map[string]*activeDownload),
+		unpacks:       make(map[string]chan struct{}),
+	}
+
+	if config.Downloader.MaxBandwidth != 0 {
+		downloader.rateLimiter = ratelimiter.New(config.Downloader.MaxBandwidth)
+
+		// Buffer size should be much lower than rate limit to get smooth transfer
+		if bufferSize := config.Downloader.MaxBandwidth / bufferSizeRateFactor; bufferSize < maxBufferSize {
+			downloader.bufferSize = int(bufferSize)
+		}
+
+		if downloader.bufferSize < minBufferSize {
+			downloader.bufferSize = minBufferSize
+		}
+	}
+
+	if err = os.MkdirAll(downloader.downloadDir, 0o755); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if err = downloader.removeOutdatedDownloads(); err != nil {
+		return nil, err
+	}
+
+	return downloader, nil
+}
+
+// Download downloads file by URL and returns downloaded file name. If download fails, it is retried with
+// backoff. Partial file is kept on failure and download is resumed next time the same file is requested.
+// If the same URL is already downloading, it waits for this download and returns its result. Each successful
+// download should be released by Release.
+func (downloader *Downloader) Download(
+	ctx context.Context, url string, fileInfo image.FileInfo,
+) (fileName string, err error) {
+	return downloader.DownloadWithHeader(ctx, url, nil, fileInfo)
+}
+
+// DownloadWithHeader downloads file the same way as Download and adds header to download requests.
+func (downloader *Downloader) DownloadWithHeader(
+	ctx context.Context, url string, header http.Header, fileInfo image.FileInfo,
+) (fileName string, err error) {
+	downloader.Lock()
+
+	if download, ok := downloader.downloads[url]; ok {
+		download.refCount++
+
+		downloader.Unlock()
+
+		log.WithField("url", url).Debug("Wait for active download")
+
+		select {
+		case <-download.doneChannel:
+			if download.err != nil {
+				downloader.unrefDownload(url, download)
+
+				return "", download.err
+			}
+
+			return download.fileName, nil
+
+		case <-ctx.Done():
+			downloader.unrefDownload(url, download)
+
+			return "", aoserrors.Wrap(ctx.Err())
+		}
+	}
+
+	download := &activeDownload{doneChannel: make(chan struct{}), refCount: 1}
+	downloader.downloads[url] = download
+
+	downloader.Unlock()
+
+	fileName, err = downloader.download(ctx, url, header, fileInfo)
+
+	downloader.Lock()
+	download.fileName, download.err = fileName, err
+	close(download.doneChannel)
+	downloader.Unlock()
+
+	if err != nil {
+		downloader.unrefDownload(url, download)
+
+		return "", err
+	}
+
+	return fileName, nil
+}
+
+// Release removes downloaded or partial file and its download info. If the file is shared by concurrent
+// downloads, it is removed when the last download is released.
+func (downloader *Downloader) Release(url string) error {
+	downloader.Lock()
+	defer downloader.Unlock()
+
+	if download, ok := downloader.downloads[url]; ok {
+		if download.refCount--; download.refCount > 0 {
+			return nil
+		}
+
+		delete(downloader.downloads, url)
+	}
+
+	downloadInfo, err := downloader.storage.GetDownloadInfo(url)
+	if err != nil {
+		if errors.Is(err, ErrNotExist) {
+			return nil
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	return downloader.removeDownload(downloadInfo)
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (downloader *Downloader) download(
+	ctx context.Context, url string, header http.Header, fileInfo image.FileInfo,
+) (string, error) {
+	downloadInfo, err := downloader.getDownloadInfo(url, fileInfo)
+	if err != nil {
+		return "", err
+	}
+
+	if err = retryhelper.Retry(ctx,
+		func() error {
+			return downloader.downloadFile(ctx, downloadInfo, header)
+		},
+		func(retryCount int, delay time.Duration, err error) {
+			log.WithField("url", url).Warnf("Can't download file: %v, retry in %v", err, delay)
+		},
+		downloader.maxTry, downloader.retryDelay, downloader.maxRetryDelay); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	return downloadInfo.FileName, nil
+}
+
+// unrefDownload drops failed or canceled download reference. Partial file is kept to resume download later.
+func (downloader *Downloader) unrefDownload(url string, download *activeDownload) {
+	downloader.Lock()
+	defer downloader.Unlock()
+
+	if download.refCount--; download.refCount == 0 && downloader.downloads[url] == download {
+		delete(downloader.downloads, url)
+	}
+}
+
+// lockUnpack waits until other unpack of the same URL is finished.
+func (downloader *Downloader) lockUnpack(ctx context.Context, url string) error {
+	for {
+		downloader.Lock()
+
+		unpackChannel, ok := downloader.unpacks[url]
+		if !ok {
+			downloader.unpacks[url] = make(chan struct{})
+			downloader.Unlock()
+
+			return nil
+		}
+
+		downloader.Unlock()
+
+		log.WithField("url", url).Debug("Wait for active unpack")
+
+		select {
+		case <-unpackChannel:
+
+		case <-ctx.Done():
+			return aoserrors.Wrap(ctx.Err())
+		}
+	}
+}
+
+func (downloader *Downloader) unlockUnpack(url string) {
+	downloader.Lock()
+	defer downloader.Unlock()
+
+	close(downloader.unpacks[url])
+	delete(downloader.unpacks, url)
+}
+
+// getDownloadInfo returns download info of partial file if it matches requested file, otherwise creates new one.
+func (downloader *Downloader) getDownloadInfo(url string, fileInfo image.FileInfo) (DownloadInfo, error) {
+	downloadInfo, err := downloader.storage.GetDownloadInfo(url)
+	if err != nil && !errors.Is(err, ErrNotExist) {
+		return DownloadInfo{}, aoserrors.Wrap(err)
+	}
+
+	if err == nil {
+		if downloadInfo.Size == fileInfo.Size && bytes.Equal(downloadInfo.Sha256, fileInfo.Sha256) {
+			log.WithFields(log.Fields{"url": url, "file": downloadInfo.FileName}).Debug("Resume partial download")
+
+			downloadInfo.Timestamp = time.Now()
+
+			if err = downloader.storage.SetDownloadInfo(downloadInfo); err != nil {
+				return DownloadInfo{}, aoserrors.Wrap(err)
+			}
+
+			return downloadInfo, nil
+		}
+
+		log.WithField("url", url).Debug("Partial download is outdated")
+
+		if err = downloader.removeDownload(downloadInfo); err != nil {
+			return DownloadInfo{}, err
+		}
+	}
+
+	urlHash := sha256.Sum256([]byte(url))
+
+	downloadInfo = DownloadInfo{
+		URL:       url,
+		FileName:  filepath.Join(downloader.downloadDir, hex.EncodeToString(urlHash[:])),
+		Size:      fileInfo.Size,
+		Sha256:    fileInfo.Sha256,
+		Timestamp: time.Now(),
+	}
+
+	if err = os.RemoveAll(downloadInfo.FileName); err != nil {
+		return DownloadInfo{}, aoserrors.Wrap(err)
+	}
+
+	if err = downloader.storage.SetDownloadInfo(downloadInfo); err != nil {
+		return DownloadInfo{}, aoserrors.Wrap(err)
+	}
+
+	return downloadInfo, nil
+}
+
+func (downloader *Downloader) downloadFile(ctx context.Context, downloadInfo DownloadInfo, header http.Header) error {
+	log.WithFields(log.Fields{"url": downloadInfo.URL, "file": downloadInfo.FileName}).Debug("Start downloading file")
+
+	timer := time.NewTicker(progressPeriod)
+	defer timer.Stop()
+
+	req, err := grab.NewRequest(downloadInfo.FileName, downloadInfo.URL)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	req = req.WithContext(ctx)
+
+	for key, values := range header {
+		req.HTTPRequest.Header[key] = values
+	}
+	req.Size = int64(downloadInfo.Size)
+	req.BufferSize = downloader.bufferSize
+
+	if downloader.rateLimiter != nil {
+		req.RateLimiter = downloader.rateLimiter
+	}
+
+	resp := downloader.client.Do(req)
+
+	for {
+		select {
+		case <-timer.C:
+			log.WithFields(log.Fields{
+				"url": downloadInfo.URL, "complete": resp.BytesComplete(), "total": resp.Size(),
+			}).Debug("Download progress")
+
+		case <-resp.Done:
+			if err := resp.Err(); err != nil {
+				// Partial file doesn't match remote file, download it from scratch next time
+				if errors.Is(err, grab.ErrBadLength) {
+					if removeErr := os.RemoveAll(downloadInfo.FileName); removeErr != nil {
+						log.Errorf("Can't remove partial file: %v", removeErr)
+					}
+				}
+
+				return aoserrors.Wrap(err)
+			}
+
+			log.WithFields(log.Fields{
+				"url": downloadInfo.URL, "file": downloadInfo.FileName, "resumed": resp.DidResume,
+			}).Debug("Download complete")
+
+			return nil
+		}
+	}
+}
+
+func (downloader *Downloader) removeDownload(downloadInfo DownloadInfo) error {
+	if err := os.RemoveAll(downloadInfo.FileName); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err := downloader.storage.RemoveDownloadInfo(downloadInfo.URL); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+// removeOutdatedDownloads removes files which are not partial downloads and partial downloads which are not
+// resumed for long time.
+func (downloader *Downloader) removeOutdatedDownloads() error {
+	downloadInfos, err := downloader.storage.GetAllDownloadInfos()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	partialFiles := make(map[string]struct{})
+
+	for _, downloadInfo := range downloadInfos {
+		if time.Since(downloadInfo.Timestamp) > partialDownloadTTL ||
+			filepath.Dir(downloadInfo.FileName) != filepath.Clean(downloader.downloadDir) {
+			log.WithField("url", downloadInfo.URL).Debug("Remove outdated partial download")
+
+			if err = downloader.removeDownload(downloadInfo); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		partialFiles[downloadInfo.FileName] = struct{}{}
+	}
+
+	entries, err := os.ReadDir(downloader.downloadDir)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	for _, entry := range entries {
+		fileName := filepath.Join(downloader.downloadDir, entry.Name())
+
+		if _, ok := partialFiles[fileName]; ok {
+			continue
+		}
+
+		log.WithField("file", fileName).Debug("Remove unknown download file")
+
+		if err = os.RemoveAll(fileName); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	return nil
+}
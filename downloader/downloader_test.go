@@ -0,0 +1,847 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloader_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/aostypes"
+	"github.com/aosedge/aos_common/image"
+	"github.com/aosedge/aos_common/spaceallocator"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/config"
+	"github.com/aosedge/aos_servicemanager/downloader"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const testFileSize = 64 * 1024
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type testStorage struct {
+	sync.Mutex
+	downloadInfos map[string]downloader.DownloadInfo
+}
+
+type testAllocator struct {
+	sync.Mutex
+
+	allocatedSize uint64
+}
+
+type testSpace struct {
+	allocator *testAllocator
+	size      uint64
+}
+
+type testArchiveItem struct {
+	name    string
+	content []byte
+	symlink bool
+}
+
+type testServer struct {
+	sync.Mutex
+	*httptest.Server
+
+	content      []byte
+	failRequests int
+	abortOffset  int
+	ranges       []string
+	headers      []string
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var tmpDir string
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Main
+ **********************************************************************************************************************/
+
+func TestMain(m *testing.M) {
+	var err error
+
+	if tmpDir, err = os.MkdirTemp("", "aos_"); err != nil {
+		log.Fatalf("Can't create tmp dir: %v", err)
+	}
+
+	ret := m.Run()
+
+	if err = os.RemoveAll(tmpDir); err != nil {
+		log.Errorf("Can't remove tmp dir: %v", err)
+	}
+
+	os.Exit(ret)
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestDownload(t *testing.T) {
+	server, err := newTestServer()
+	if err != nil {
+		t.Fatalf("Can't create test server: %v", err)
+	}
+	defer server.Close()
+
+	storage := newTestStorage()
+
+	testDownloader, err := downloader.New(newTestConfig(t), storage)
+	if err != nil {
+		t.Fatalf("Can't create downloader: %v", err)
+	}
+
+	url := server.URL + "/file"
+
+	fileName, err := testDownloader.Download(context.Background(), url, image.FileInfo{Size: testFileSize})
+	if err != nil {
+		t.Fatalf("Can't download file: %v", err)
+	}
+
+	if err = checkFileContent(fileName, server.content); err != nil {
+		t.Errorf("Wrong downloaded file: %v", err)
+	}
+
+	if err = testDownloader.Release(url); err != nil {
+		t.Fatalf("Can't release download: %v", err)
+	}
+
+	if _, err = os.Stat(fileName); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Downloaded file should be removed: %v", err)
+	}
+
+	if _, err = storage.GetDownloadInfo(url); !errors.Is(err, downloader.ErrNotExist) {
+		t.Errorf("Download info should be removed: %v", err)
+	}
+}
+
+func TestDownloadWithHeader(t *testing.T) {
+	server, err := newTestServer()
+	if err != nil {
+		t.Fatalf("Can't create test server: %v", err)
+	}
+	defer server.Close()
+
+	testDownloader, err := downloader.New(newTestConfig(t), newTestStorage())
+	if err != nil {
+		t.Fatalf("Can't create downloader: %v", err)
+	}
+
+	url := server.URL + "/file"
+	header := http.Header{"Authorization": []string{"Bearer token"}}
+
+	fileName, err := testDownloader.DownloadWithHeader(
+		context.Background(), url, header, image.FileInfo{Size: testFileSize})
+	if err != nil {
+		t.Fatalf("Can't download file: %v", err)
+	}
+
+	if err = checkFileContent(fileName, server.content); err != nil {
+		t.Errorf("Wrong downloaded file: %v", err)
+	}
+
+	if len(server.headers) != 1 || server.headers[0] != "Bearer token" {
+		t.Errorf("Wrong request headers: %v", server.headers)
+	}
+
+	if err = testDownloader.Release(url); err != nil {
+		t.Fatalf("Can't release download: %v", err)
+	}
+}
+
+func TestResumeDownload(t *testing.T) {
+	server, err := newTestServer()
+	if err != nil {
+		t.Fatalf("Can't create test server: %v", err)
+	}
+	defer server.Close()
+
+	server.abortOffset = testFileSize / 4
+
+	storage := newTestStorage()
+	downloaderConfig := newTestConfig(t)
+	downloaderConfig.Downloader.MaxTry = 1
+
+	testDownloader, err := downloader.New(downloaderConfig, storage)
+	if err != nil {
+		t.Fatalf("Can't create downloader: %v", err)
+	}
+
+	url := server.URL + "/file"
+
+	if _, err = testDownloader.Download(context.Background(), url, image.FileInfo{Size: testFileSize}); err == nil {
+		t.Fatal("Download should fail")
+	}
+
+	downloadInfo, err := storage.GetDownloadInfo(url)
+	if err != nil {
+		t.Fatalf("Can't get download info: %v", err)
+	}
+
+	fileInfo, err := os.Stat(downloadInfo.FileName)
+	if err != nil {
+		t.Fatalf("Can't stat partial file: %v", err)
+	}
+
+	if fileInfo.Size() != int64(server.abortOffset) {
+		t.Errorf("Wrong partial file size: %d", fileInfo.Size())
+	}
+
+	// Partial file should be kept and resumed after restart
+
+	server.abortOffset = 0
+
+	if testDownloader, err = downloader.New(downloaderConfig, storage); err != nil {
+		t.Fatalf("Can't create downloader: %v", err)
+	}
+
+	fileName, err := testDownloader.Download(context.Background(), url, image.FileInfo{Size: testFileSize})
+	if err != nil {
+		t.Fatalf("Can't download file: %v", err)
+	}
+
+	if err = checkFileContent(fileName, server.content); err != nil {
+		t.Errorf("Wrong downloaded file: %v", err)
+	}
+
+	expectedRange := "bytes=" + strconv.Itoa(testFileSize/4) + "-"
+
+	if len(server.ranges) != 2 || server.ranges[1] != expectedRange {
+		t.Errorf("Wrong requested ranges: %v", server.ranges)
+	}
+
+	if err = testDownloader.Release(url); err != nil {
+		t.Fatalf("Can't release download: %v", err)
+	}
+}
+
+func TestConcurrentDownload(t *testing.T) {
+	server, err := newTestServer()
+	if err != nil {
+		t.Fatalf("Can't create test server: %v", err)
+	}
+	defer server.Close()
+
+	downloaderConfig := newTestConfig(t)
+	downloaderConfig.Downloader.MaxBandwidth = testFileSize * 4
+
+	testDownloader, err := downloader.New(downloaderConfig, newTestStorage())
+	if err != nil {
+		t.Fatalf("Can't create downloader: %v", err)
+	}
+
+	const numDownloads = 3
+
+	var (
+		wg        sync.WaitGroup
+		fileNames [numDownloads]string
+		url       = server.URL + "/file"
+	)
+
+	for i := 0; i < numDownloads; i++ {
+		wg.Add(1)
+
+		go func(index int) {
+			defer wg.Done()
+
+			fileName, err := testDownloader.Download(context.Background(), url, image.FileInfo{Size: testFileSize})
+			if err != nil {
+				t.Errorf("Can't download file: %v", err)
+			}
+
+			fileNames[index] = fileName
+		}(i)
+	}
+
+	wg.Wait()
+
+	if len(server.ranges) != 1 {
+		t.Errorf("Wrong requests count: %d", len(server.ranges))
+	}
+
+	for _, fileName := range fileNames {
+		if fileName != fileNames[0] {
+			t.Errorf("Wrong downloaded file: %s", fileName)
+		}
+	}
+
+	if err = checkFileContent(fileNames[0], server.content); err != nil {
+		t.Errorf("Wrong downloaded file: %v", err)
+	}
+
+	// File is removed when the last download is released
+
+	for i := 0; i < numDownloads; i++ {
+		if err = testDownloader.Release(url); err != nil {
+			t.Fatalf("Can't release download: %v", err)
+		}
+
+		_, err = os.Stat(fileNames[0])
+
+		if i < numDownloads-1 && err != nil {
+			t.Errorf("Downloaded file should not be removed: %v", err)
+		}
+
+		if i == numDownloads-1 && !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Downloaded file should be removed: %v", err)
+		}
+	}
+}
+
+func TestOutdatedPartialDownload(t *testing.T) {
+	server, err := newTestServer()
+	if err != nil {
+		t.Fatalf("Can't create test server: %v", err)
+	}
+	defer server.Close()
+
+	server.abortOffset = testFileSize / 4
+
+	storage := newTestStorage()
+	downloaderConfig := newTestConfig(t)
+	downloaderConfig.Downloader.MaxTry = 1
+
+	testDownloader, err := downloader.New(downloaderConfig, storage)
+	if err != nil {
+		t.Fatalf("Can't create downloader: %v", err)
+	}
+
+	url := server.URL + "/file"
+
+	if _, err = testDownloader.Download(context.Background(), url, image.FileInfo{
+		Size: testFileSize, Sha256: []byte{1},
+	}); err == nil {
+		t.Fatal("Download should fail")
+	}
+
+	// Partial file of different file version should not be resumed
+
+	server.abortOffset = 0
+
+	if _, err = testDownloader.Download(context.Background(), url, image.FileInfo{
+		Size: testFileSize, Sha256: []byte{2},
+	}); err != nil {
+		t.Fatalf("Can't download file: %v", err)
+	}
+
+	if len(server.ranges) != 2 || server.ranges[1] != "" {
+		t.Errorf("Wrong requested ranges: %v", server.ranges)
+	}
+
+	// Unknown files should be removed on start
+
+	unknownFile := filepath.Join(downloaderConfig.DownloadDir, "unknown")
+
+	if err = os.WriteFile(unknownFile, []byte("unknown"), 0o600); err != nil {
+		t.Fatalf("Can't create unknown file: %v", err)
+	}
+
+	if _, err = downloader.New(downloaderConfig, storage); err != nil {
+		t.Fatalf("Can't create downloader: %v", err)
+	}
+
+	if _, err = os.Stat(unknownFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Unknown file should be removed: %v", err)
+	}
+
+	downloadInfo, err := storage.GetDownloadInfo(url)
+	if err != nil {
+		t.Fatalf("Can't get download info: %v", err)
+	}
+
+	if _, err = os.Stat(downloadInfo.FileName); err != nil {
+		t.Errorf("Download file should not be removed: %v", err)
+	}
+}
+
+func TestRetryDownload(t *testing.T) {
+	server, err := newTestServer()
+	if err != nil {
+		t.Fatalf("Can't create test server: %v", err)
+	}
+	defer server.Close()
+
+	server.failRequests = 2
+
+	downloaderConfig := newTestConfig(t)
+	downloaderConfig.Downloader.MaxTry = 3
+
+	testDownloader, err := downloader.New(downloaderConfig, newTestStorage())
+	if err != nil {
+		t.Fatalf("Can't create downloader: %v", err)
+	}
+
+	fileName, err := testDownloader.Download(context.Background(), server.URL+"/file",
+		image.FileInfo{Size: testFileSize})
+	if err != nil {
+		t.Fatalf("Can't download file: %v", err)
+	}
+
+	if err = checkFileContent(fileName, server.content); err != nil {
+		t.Errorf("Wrong downloaded file: %v", err)
+	}
+
+	server.failRequests = 3
+
+	if _, err = testDownloader.Download(context.Background(), server.URL+"/otherFile",
+		image.FileInfo{Size: testFileSize}); err == nil {
+		t.Error("Download should fail")
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	server, err := newTestServer()
+	if err != nil {
+		t.Fatalf("Can't create test server: %v", err)
+	}
+	defer server.Close()
+
+	downloaderConfig := newTestConfig(t)
+	downloaderConfig.Downloader.MaxBandwidth = testFileSize * 4
+
+	testDownloader, err := downloader.New(downloaderConfig, newTestStorage())
+	if err != nil {
+		t.Fatalf("Can't create downloader: %v", err)
+	}
+
+	var wg sync.WaitGroup
+
+	startTime := time.Now()
+
+	// Limit is applied to all downloads
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+
+		go func(url string) {
+			defer wg.Done()
+
+			if _, err := testDownloader.Download(context.Background(), url,
+				image.FileInfo{Size: testFileSize}); err != nil {
+				t.Errorf("Can't download file: %v", err)
+			}
+		}(server.URL + "/file" + strconv.Itoa(i))
+	}
+
+	wg.Wait()
+
+	if elapsed := time.Since(startTime); elapsed < 400*time.Millisecond {
+		t.Errorf("Download is too fast: %v", elapsed)
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	server, err := newTestServer()
+	if err != nil {
+		t.Fatalf("Can't create test server: %v", err)
+	}
+	defer server.Close()
+
+	testDownloader, err := downloader.New(newTestConfig(t), newTestStorage())
+	if err != nil {
+		t.Fatalf("Can't create downloader: %v", err)
+	}
+
+	items := []testArchiveItem{
+		{name: "blobs/"},
+		{name: "manifest.json", content: []byte(`{"schemaVersion":2}`)},
+		{name: "blobs/data", content: server.content},
+	}
+
+	expectedSize := uint64(4*1024 + len(items[1].content) + len(items[2].content))
+
+	for _, compress := range []bool{false, true} {
+		archive, err := createTestArchive(items, compress)
+		if err != nil {
+			t.Fatalf("Can't create archive: %v", err)
+		}
+
+		server.Lock()
+		server.content = archive
+		server.Unlock()
+
+		destination := filepath.Join(tmpDir, t.Name(), "unpack"+strconv.FormatBool(compress))
+		allocator := &testAllocator{}
+
+		size, space, err := testDownloader.Unpack(
+			context.Background(), server.URL+"/archive", getFileInfo(archive), destination, allocator)
+		if err != nil {
+			t.Fatalf("Can't unpack file: %v", err)
+		}
+
+		if size != expectedSize || allocator.allocatedSize != expectedSize {
+			t.Errorf("Wrong unpacked size: %d, allocated: %d, expected: %d",
+				size, allocator.allocatedSize, expectedSize)
+		}
+
+		for _, item := range items[1:] {
+			if err = checkFileContent(filepath.Join(destination, item.name), item.content); err != nil {
+				t.Errorf("Wrong unpacked file %s: %v", item.name, err)
+			}
+		}
+
+		if err = space.Release(); err != nil {
+			t.Errorf("Can't release space: %v", err)
+		}
+
+		if allocator.allocatedSize != 0 {
+			t.Errorf("Wrong allocated size after release: %d", allocator.allocatedSize)
+		}
+	}
+}
+
+func TestUnpackFailure(t *testing.T) {
+	server, err := newTestServer()
+	if err != nil {
+		t.Fatalf("Can't create test server: %v", err)
+	}
+	defer server.Close()
+
+	testDownloader, err := downloader.New(newTestConfig(t), newTestStorage())
+	if err != nil {
+		t.Fatalf("Can't create downloader: %v", err)
+	}
+
+	validArchive, err := createTestArchive([]testArchiveItem{{name: "data", content: server.content}}, false)
+	if err != nil {
+		t.Fatalf("Can't create archive: %v", err)
+	}
+
+	symlinkArchive, err := createTestArchive([]testArchiveItem{{name: "link", symlink: true}}, false)
+	if err != nil {
+		t.Fatalf("Can't create archive: %v", err)
+	}
+
+	wrongFileInfo := getFileInfo(validArchive)
+	wrongFileInfo.Sha256 = make([]byte, sha256.Size)
+
+	cases := []struct {
+		name     string
+		archive  []byte
+		fileInfo image.FileInfo
+	}{
+		{name: "checksum mismatch", archive: validArchive, fileInfo: wrongFileInfo},
+		{name: "unsupported item", archive: symlinkArchive, fileInfo: getFileInfo(symlinkArchive)},
+	}
+
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			server.Lock()
+			server.content = tCase.archive
+			server.Unlock()
+
+			destination := filepath.Join(tmpDir, t.Name())
+			allocator := &testAllocator{}
+
+			if err := os.MkdirAll(destination, 0o755); err != nil {
+				t.Fatalf("Can't create destination dir: %v", err)
+			}
+
+			if _, _, err := testDownloader.Unpack(
+				context.Background(), server.URL+"/archive", tCase.fileInfo, destination, allocator); err == nil {
+				t.Error("Error expected")
+			}
+
+			if allocator.allocatedSize != 0 {
+				t.Errorf("Allocated space should be released: %d", allocator.allocatedSize)
+			}
+
+			entries, err := os.ReadDir(destination)
+			if err != nil {
+				t.Fatalf("Can't read destination dir: %v", err)
+			}
+
+			if len(entries) != 0 {
+				t.Errorf("Destination dir should be cleaned: %v", entries)
+			}
+		})
+	}
+}
+
+/***********************************************************************************************************************
+ * testStorage
+ **********************************************************************************************************************/
+
+func newTestStorage() *testStorage {
+	return &testStorage{downloadInfos: make(map[string]downloader.DownloadInfo)}
+}
+
+func (storage *testStorage) GetDownloadInfo(url string) (downloader.DownloadInfo, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	downloadInfo, ok := storage.downloadInfos[url]
+	if !ok {
+		return downloader.DownloadInfo{}, downloader.ErrNotExist
+	}
+
+	return downloadInfo, nil
+}
+
+func (storage *testStorage) GetAllDownloadInfos() ([]downloader.DownloadInfo, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	downloadInfos := make([]downloader.DownloadInfo, 0, len(storage.downloadInfos))
+
+	for _, downloadInfo := range storage.downloadInfos {
+		downloadInfos = append(downloadInfos, downloadInfo)
+	}
+
+	return downloadInfos, nil
+}
+
+func (storage *testStorage) SetDownloadInfo(downloadInfo downloader.DownloadInfo) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	storage.downloadInfos[downloadInfo.URL] = downloadInfo
+
+	return nil
+}
+
+func (storage *testStorage) RemoveDownloadInfo(url string) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	delete(storage.downloadInfos, url)
+
+	return nil
+}
+
+/***********************************************************************************************************************
+ * testAllocator
+ **********************************************************************************************************************/
+
+func (allocator *testAllocator) AllocateSpace(size uint64) (spaceallocator.Space, error) {
+	allocator.Lock()
+	defer allocator.Unlock()
+
+	allocator.allocatedSize += size
+
+	return &testSpace{allocator: allocator, size: size}, nil
+}
+
+func (allocator *testAllocator) FreeSpace(size uint64) {
+	allocator.Lock()
+	defer allocator.Unlock()
+
+	allocator.allocatedSize -= size
+}
+
+func (allocator *testAllocator) AddOutdatedItem(id string, size uint64, timestamp time.Time) error {
+	return nil
+}
+
+func (allocator *testAllocator) RestoreOutdatedItem(id string) {
+}
+
+func (allocator *testAllocator) Close() error {
+	return nil
+}
+
+func (space *testSpace) Accept() error {
+	return nil
+}
+
+func (space *testSpace) Release() error {
+	space.allocator.FreeSpace(space.size)
+
+	return nil
+}
+
+/***********************************************************************************************************************
+ * testServer
+ **********************************************************************************************************************/
+
+func newTestServer() (*testServer, error) {
+	server := &testServer{content: make([]byte, testFileSize)}
+
+	if _, err := rand.Read(server.content); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handleRequest))
+
+	return server, nil
+}
+
+func (server *testServer) handleRequest(w http.ResponseWriter, r *http.Request) {
+	server.Lock()
+
+	if r.Method == http.MethodHead {
+		server.Unlock()
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(server.content))
+
+		return
+	}
+
+	server.ranges = append(server.ranges, r.Header.Get("Range"))
+	server.headers = append(server.headers, r.Header.Get("Authorization"))
+
+	if server.failRequests > 0 {
+		server.failRequests--
+		server.Unlock()
+
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	abortOffset := server.abortOffset
+
+	server.Unlock()
+
+	if abortOffset != 0 {
+		w.Header().Set("Content-Length", strconv.Itoa(len(server.content)))
+		w.WriteHeader(http.StatusOK)
+
+		_, _ = w.Write(server.content[:abortOffset])
+
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+
+		// Abort connection to simulate network failure
+		panic(http.ErrAbortHandler)
+	}
+
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(server.content))
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func newTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	return &config.Config{
+		DownloadDir: filepath.Join(tmpDir, t.Name()),
+		Downloader: config.Downloader{
+			MaxTry:        1,
+			RetryDelay:    aostypes.Duration{Duration: 10 * time.Millisecond},
+			MaxRetryDelay: aostypes.Duration{Duration: 100 * time.Millisecond},
+		},
+	}
+}
+
+func checkFileContent(fileName string, content []byte) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if !bytes.Equal(data, content) {
+		return aoserrors.New("file content mismatch")
+	}
+
+	return nil
+}
+
+func getFileInfo(content []byte) image.FileInfo {
+	sha256Sum := sha256.Sum256(content)
+	sha512Sum := sha512.Sum512(content)
+
+	return image.FileInfo{Sha256: sha256Sum[:], Sha512: sha512Sum[:], Size: uint64(len(content))}
+}
+
+func createTestArchive(items []testArchiveItem, compress bool) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	var gzipWriter *gzip.Writer
+
+	tarWriter := tar.NewWriter(&buffer)
+
+	if compress {
+		gzipWriter = gzip.NewWriter(&buffer)
+		tarWriter = tar.NewWriter(gzipWriter)
+	}
+
+	for _, item := range items {
+		header := &tar.Header{Name: item.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(item.content))}
+
+		switch {
+		case item.symlink:
+			header.Typeflag, header.Linkname = tar.TypeSymlink, "/etc"
+
+		case item.content == nil:
+			header.Typeflag, header.Mode = tar.TypeDir, 0o755
+		}
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return nil, aoserrors.Wrap(err)
+		}
+
+		if _, err := tarWriter.Write(item.content); err != nil {
+			return nil, aoserrors.Wrap(err)
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if gzipWriter != nil {
+		if err := gzipWriter.Close(); err != nil {
+			return nil, aoserrors.Wrap(err)
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,317 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloader
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/image"
+	"github.com/aosedge/aos_common/spaceallocator"
+	"github.com/aosedge/aos_common/utils/retryhelper"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/utils/ratelimiter"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	// Dir size is calculated the same way as image.GetUncompressedTarContentSize does.
+	dirItemSize     = 4 * 1024
+	contentTypeSize = 64
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// unpackSpace combines spaces allocated for unpacked items.
+type unpackSpace struct {
+	spaces []spaceallocator.Space
+}
+
+// streamReader hashes and counts received data.
+type streamReader struct {
+	reader     io.Reader
+	bufferSize int
+	sha256     hash.Hash
+	sha512     hash.Hash
+	size       uint64
+}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// Unpack downloads tar or tar.gz archive and unpacks it into destination dir on the fly. Archive is not stored on
+// disk: space for each archive item is allocated by allocator before the item is unpacked. Archive size and
+// checksums are verified when the whole archive is received. On error, destination dir content is removed and
+// allocated space is released.
+func (downloader *Downloader) Unpack(
+	ctx context.Context, url string, fileInfo image.FileInfo, destination string, allocator spaceallocator.Allocator,
+) (size uint64, space spaceallocator.Space, err error) {
+	if err = downloader.lockUnpack(ctx, url); err != nil {
+		return 0, nil, err
+	}
+	defer downloader.unlockUnpack(url)
+
+	if err = retryhelper.Retry(ctx,
+		func() (err error) {
+			size, space, err = downloader.unpack(ctx, url, fileInfo, destination, allocator)
+
+			return err
+		},
+		func(retryCount int, delay time.Duration, err error) {
+			log.WithField("url", url).Warnf("Can't unpack file: %v, retry in %v", err, delay)
+		},
+		downloader.maxTry, downloader.retryDelay, downloader.maxRetryDelay); err != nil {
+		return 0, nil, aoserrors.Wrap(err)
+	}
+
+	return size, space, nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (downloader *Downloader) unpack(
+	ctx context.Context, url string, fileInfo image.FileInfo, destination string, allocator spaceallocator.Allocator,
+) (size uint64, space spaceallocator.Space, err error) {
+	log.WithFields(log.Fields{"url": url, "destination": destination}).Debug("Start unpacking file")
+
+	itemsSpace := &unpackSpace{}
+
+	defer func() {
+		if err != nil {
+			if releaseErr := itemsSpace.Release(); releaseErr != nil {
+				log.Errorf("Can't release memory: %v", releaseErr)
+			}
+
+			if cleanErr := cleanDir(destination); cleanErr != nil {
+				log.Errorf("Can't clean unpack dir: %v", cleanErr)
+			}
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, nil, aoserrors.Wrap(err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, aoserrors.Wrap(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, nil, aoserrors.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	stream := &streamReader{
+		reader: ratelimiter.NewReader(ctx, resp.Body, downloader.rateLimiter), bufferSize: downloader.bufferSize,
+		sha256: sha256.New(), sha512: sha512.New(),
+	}
+
+	if size, err = untarStream(stream, destination, allocator, itemsSpace); err != nil {
+		return 0, nil, err
+	}
+
+	if stream.size != fileInfo.Size {
+		return 0, nil, aoserrors.Errorf("file size mismatch: %d, expected: %d", stream.size, fileInfo.Size)
+	}
+
+	if !bytes.Equal(stream.sha256.Sum(nil), fileInfo.Sha256) ||
+		!bytes.Equal(stream.sha512.Sum(nil), fileInfo.Sha512) {
+		return 0, nil, aoserrors.New("file checksum mismatch")
+	}
+
+	log.WithFields(log.Fields{"url": url, "destination": destination, "size": size}).Debug("Unpack complete")
+
+	return size, itemsSpace, nil
+}
+
+func untarStream(
+	reader io.Reader, destination string, allocator spaceallocator.Allocator, itemsSpace *unpackSpace,
+) (size uint64, err error) {
+	bReader := bufio.NewReader(reader)
+
+	testBytes, err := bReader.Peek(contentTypeSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	archiveReader := io.Reader(bReader)
+
+	if strings.Contains(http.DetectContentType(testBytes), "x-gzip") {
+		gzipReader, err := gzip.NewReader(bReader)
+		if err != nil {
+			return 0, aoserrors.Wrap(err)
+		}
+		defer gzipReader.Close()
+
+		archiveReader = gzipReader
+	}
+
+	tarReader := tar.NewReader(archiveReader)
+
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return 0, aoserrors.Wrap(err)
+		}
+
+		itemSize, err := untarItem(tarReader, header, destination, allocator, itemsSpace)
+		if err != nil {
+			return 0, err
+		}
+
+		size += itemSize
+	}
+
+	// Read tar padding and rest of compressed data to get checksums of the whole file
+	if _, err = io.Copy(io.Discard, bReader); err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	return size, nil
+}
+
+func untarItem(
+	reader io.Reader, header *tar.Header, destination string, allocator spaceallocator.Allocator,
+	itemsSpace *unpackSpace,
+) (size uint64, err error) {
+	itemPath := filepath.Join(destination, filepath.Clean("/"+header.Name))
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		size = dirItemSize
+
+	case tar.TypeReg:
+		size = uint64(header.Size)
+
+	default:
+		return 0, aoserrors.Errorf("unsupported archive item type %c: %s", header.Typeflag, header.Name)
+	}
+
+	itemSpace, err := allocator.AllocateSpace(size)
+	if err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	itemsSpace.spaces = append(itemsSpace.spaces, itemSpace)
+
+	if header.Typeflag == tar.TypeDir {
+		return size, aoserrors.Wrap(os.MkdirAll(itemPath, header.FileInfo().Mode().Perm()))
+	}
+
+	if err = os.MkdirAll(filepath.Dir(itemPath), 0o755); err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	file, err := os.OpenFile(itemPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, header.FileInfo().Mode().Perm())
+	if err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+	defer file.Close()
+
+	if _, err = io.Copy(file, reader); err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	return size, nil
+}
+
+func cleanDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	for _, entry := range entries {
+		if err = os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func (space *unpackSpace) Accept() (err error) {
+	for _, itemSpace := range space.spaces {
+		if acceptErr := itemSpace.Accept(); acceptErr != nil && err == nil {
+			err = aoserrors.Wrap(acceptErr)
+		}
+	}
+
+	return err
+}
+
+func (space *unpackSpace) Release() (err error) {
+	for _, itemSpace := range space.spaces {
+		if releaseErr := itemSpace.Release(); releaseErr != nil && err == nil {
+			err = aoserrors.Wrap(releaseErr)
+		}
+	}
+
+	space.spaces = nil
+
+	return err
+}
+
+func (stream *streamReader) Read(p []byte) (n int, err error) {
+	if len(p) > stream.bufferSize {
+		p = p[:stream.bufferSize]
+	}
+
+	n, err = stream.reader.Read(p)
+
+	if n > 0 {
+		stream.sha256.Write(p[:n])
+		stream.sha512.Write(p[:n])
+		stream.size += uint64(n)
+	}
+
+	return n, err //nolint:wrapcheck
+}
@@ -0,0 +1,267 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package eviction provides eviction policy of cached services and layers.
+package eviction
+
+import (
+	"errors"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/config"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Eviction policies.
+const (
+	PolicyTimestamp = "timestamp"
+	PolicyLRU       = "lru"
+)
+
+// Item types.
+const (
+	ServiceItem = "service"
+	LayerItem   = "layer"
+)
+
+// Eviction reasons.
+const (
+	ReasonTTL          = "ttl"
+	ReasonNoSpace      = "noSpace"
+	ReasonMinFreeSpace = "minFreeSpace"
+)
+
+const (
+	megabyte       = 1 << 20
+	maxReportItems = 100
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// UsageStorage provides API to store items last use time and eviction report.
+type UsageStorage interface {
+	SetItemLastUsed(itemType, id string, lastUsed time.Time) error
+	GetItemLastUsed(itemType, id string) (time.Time, error)
+	RemoveItemUsage(itemType, id string) error
+	AddEvictedItem(item EvictedItem, maxItems int) error
+}
+
+// Item eviction candidate.
+type Item struct {
+	ID         string
+	AosVersion uint64
+	Size       uint64
+	Timestamp  time.Time
+	LastUsed   time.Time
+}
+
+// EvictedItem evicted item report entry.
+type EvictedItem struct {
+	Type       string
+	ID         string
+	AosVersion uint64
+	Size       uint64
+	Reason     string
+	Timestamp  time.Time
+}
+
+// Policy defines order in which cached items are evicted.
+type Policy struct {
+	itemType     string
+	storage      UsageStorage
+	lru          bool
+	sizeWeight   time.Duration
+	minFreeSpace uint64
+	pins         []config.EvictionPin
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// ErrNotExist is returned when requested item usage doesn't exist.
+var ErrNotExist = errors.New("item usage does not exist")
+
+// GetAvailableSpace returns available space of partition containing the path.
+//
+//nolint:gochecknoglobals // used for unit test mock
+var GetAvailableSpace = getAvailableSpace
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// New creates eviction policy for items of specified type.
+func New(itemType string, config config.Eviction, storage UsageStorage) (policy *Policy, err error) {
+	policy = &Policy{
+		itemType:     itemType,
+		storage:      storage,
+		sizeWeight:   config.SizeWeight.Duration,
+		minFreeSpace: config.MinFreeSpace,
+		pins:         config.Pins,
+	}
+
+	switch config.Policy {
+	case "", PolicyTimestamp:
+
+	case PolicyLRU:
+		policy.lru = true
+
+	default:
+		return nil, aoserrors.Errorf("unsupported eviction policy: %s", config.Policy)
+	}
+
+	return policy, nil
+}
+
+// SetUsed sets item last use time to now.
+func (policy *Policy) SetUsed(usageID string) error {
+	return aoserrors.Wrap(policy.storage.SetItemLastUsed(policy.itemType, usageID, time.Now().UTC()))
+}
+
+// GetLastUsed returns item last use time. Zero time is returned if item has never been used.
+func (policy *Policy) GetLastUsed(usageID string) (time.Time, error) {
+	lastUsed, err := policy.storage.GetItemLastUsed(policy.itemType, usageID)
+	if err != nil {
+		if errors.Is(err, ErrNotExist) {
+			return time.Time{}, nil
+		}
+
+		return time.Time{}, aoserrors.Wrap(err)
+	}
+
+	return lastUsed, nil
+}
+
+// RemoveUsage removes item usage information. It should be called when item is removed.
+func (policy *Policy) RemoveUsage(usageID string) {
+	if err := policy.storage.RemoveItemUsage(policy.itemType, usageID); err != nil && !errors.Is(err, ErrNotExist) {
+		log.WithFields(log.Fields{"type": policy.itemType, "id": usageID}).Errorf("Can't remove item usage: %v", err)
+	}
+}
+
+// IsPinned returns true if item should never be evicted.
+func (policy *Policy) IsPinned(id string, aosVersion uint64) bool {
+	for _, pin := range policy.pins {
+		if pin.ID == id && (pin.AosVersion == 0 || pin.AosVersion == aosVersion) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EvictionTime returns time used to order items eviction: item with earliest time is evicted first. Item time is its
+// install time or, for LRU policy, its last use time. Each megabyte of item size moves its time back by size weight,
+// so large items are evicted before small ones of the same age.
+func (policy *Policy) EvictionTime(item Item) time.Time {
+	evictionTime := item.Timestamp
+
+	if policy.lru && item.LastUsed.After(evictionTime) {
+		evictionTime = item.LastUsed
+	}
+
+	return evictionTime.Add(-time.Duration(float64(policy.sizeWeight) * float64(item.Size) / megabyte))
+}
+
+// EnsureMinFreeSpace evicts items in eviction order until available space of the path reaches configured minimum.
+// Pinned items are never evicted.
+func (policy *Policy) EnsureMinFreeSpace(path string, items []Item, evict func(item Item) error) error {
+	if policy.minFreeSpace == 0 {
+		return nil
+	}
+
+	var candidates []Item
+
+	for _, item := range items {
+		if !policy.IsPinned(item.ID, item.AosVersion) {
+			candidates = append(candidates, item)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return policy.EvictionTime(candidates[i]).Before(policy.EvictionTime(candidates[j]))
+	})
+
+	for _, item := range candidates {
+		availableSpace, err := GetAvailableSpace(path)
+		if err != nil {
+			return err
+		}
+
+		if availableSpace >= policy.minFreeSpace {
+			return nil
+		}
+
+		if err = evict(item); err != nil {
+			return err
+		}
+
+		policy.AddEvicted(item, ReasonMinFreeSpace)
+	}
+
+	availableSpace, err := GetAvailableSpace(path)
+	if err != nil {
+		return err
+	}
+
+	if availableSpace < policy.minFreeSpace {
+		log.WithFields(log.Fields{
+			"type": policy.itemType, "availableSpace": availableSpace, "minFreeSpace": policy.minFreeSpace,
+		}).Warn("Can't reach min free space: no more items to evict")
+	}
+
+	return nil
+}
+
+// AddEvicted adds evicted item to the report. Report keeps limited number of the latest items of each type.
+func (policy *Policy) AddEvicted(item Item, reason string) {
+	log.WithFields(log.Fields{
+		"type": policy.itemType, "id": item.ID, "aosVersion": item.AosVersion, "size": item.Size, "reason": reason,
+	}).Info("Item evicted")
+
+	if err := policy.storage.AddEvictedItem(EvictedItem{
+		Type: policy.itemType, ID: item.ID, AosVersion: item.AosVersion, Size: item.Size, Reason: reason,
+		Timestamp: time.Now().UTC(),
+	}, maxReportItems); err != nil {
+		log.WithFields(log.Fields{"type": policy.itemType, "id": item.ID}).Errorf("Can't add evicted item: %v", err)
+	}
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func getAvailableSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,256 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eviction_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aosedge/aos_common/aostypes"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/config"
+	"github.com/aosedge/aos_servicemanager/eviction"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const megabyte = 1 << 20
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type testUsageStorage struct {
+	usage   map[string]time.Time
+	evicted []eviction.EvictedItem
+}
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestUnsupportedPolicy(t *testing.T) {
+	if _, err := eviction.New(eviction.ServiceItem, config.Eviction{Policy: "random"}, nil); err == nil {
+		t.Error("Error expected for unsupported policy")
+	}
+}
+
+func TestEvictionTime(t *testing.T) {
+	timestamp := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	type testData struct {
+		config       config.Eviction
+		item         eviction.Item
+		evictionTime time.Time
+	}
+
+	data := []testData{
+		{
+			item:         eviction.Item{Timestamp: timestamp, LastUsed: timestamp.Add(time.Hour)},
+			evictionTime: timestamp,
+		},
+		{
+			config:       config.Eviction{Policy: eviction.PolicyLRU},
+			item:         eviction.Item{Timestamp: timestamp, LastUsed: timestamp.Add(time.Hour)},
+			evictionTime: timestamp.Add(time.Hour),
+		},
+		{
+			config:       config.Eviction{Policy: eviction.PolicyLRU},
+			item:         eviction.Item{Timestamp: timestamp},
+			evictionTime: timestamp,
+		},
+		{
+			config: config.Eviction{
+				Policy: eviction.PolicyTimestamp, SizeWeight: aostypes.Duration{Duration: time.Hour},
+			},
+			item:         eviction.Item{Timestamp: timestamp, Size: megabyte / 2},
+			evictionTime: timestamp.Add(-30 * time.Minute),
+		},
+	}
+
+	for i, item := range data {
+		policy, err := eviction.New(eviction.LayerItem, item.config, newTestUsageStorage())
+		if err != nil {
+			t.Fatalf("Can't create eviction policy: %v", err)
+		}
+
+		if evictionTime := policy.EvictionTime(item.item); !evictionTime.Equal(item.evictionTime) {
+			t.Errorf("Wrong eviction time %d: %v", i, evictionTime)
+		}
+	}
+}
+
+func TestPins(t *testing.T) {
+	policy, err := eviction.New(eviction.ServiceItem, config.Eviction{Pins: []config.EvictionPin{
+		{ID: "service1"}, {ID: "service2", AosVersion: 2},
+	}}, newTestUsageStorage())
+	if err != nil {
+		t.Fatalf("Can't create eviction policy: %v", err)
+	}
+
+	type testData struct {
+		id         string
+		aosVersion uint64
+		pinned     bool
+	}
+
+	data := []testData{
+		{id: "service1", aosVersion: 1, pinned: true},
+		{id: "service1", aosVersion: 5, pinned: true},
+		{id: "service2", aosVersion: 1, pinned: false},
+		{id: "service2", aosVersion: 2, pinned: true},
+		{id: "service3", aosVersion: 2, pinned: false},
+	}
+
+	for _, item := range data {
+		if pinned := policy.IsPinned(item.id, item.aosVersion); pinned != item.pinned {
+			t.Errorf("Wrong pinned state %s %d: %v", item.id, item.aosVersion, pinned)
+		}
+	}
+}
+
+func TestEnsureMinFreeSpace(t *testing.T) {
+	storage := newTestUsageStorage()
+
+	policy, err := eviction.New(eviction.ServiceItem, config.Eviction{
+		Policy: eviction.PolicyLRU, MinFreeSpace: 3 * megabyte, Pins: []config.EvictionPin{{ID: "service4"}},
+	}, storage)
+	if err != nil {
+		t.Fatalf("Can't create eviction policy: %v", err)
+	}
+
+	timestamp := time.Now().Add(-time.Hour)
+
+	items := []eviction.Item{
+		{ID: "service1", Size: megabyte, Timestamp: timestamp.Add(time.Minute)},
+		{ID: "service2", Size: megabyte, Timestamp: timestamp},
+		{ID: "service3", Size: megabyte, Timestamp: timestamp.Add(2 * time.Minute)},
+		{ID: "service4", Size: megabyte, Timestamp: timestamp.Add(-time.Minute)},
+	}
+
+	if err = policy.SetUsed("service2"); err != nil {
+		t.Fatalf("Can't set item used: %v", err)
+	}
+
+	if items[1].LastUsed, err = policy.GetLastUsed("service2"); err != nil {
+		t.Fatalf("Can't get item last used: %v", err)
+	}
+
+	availableSpace := uint64(megabyte)
+
+	getAvailableSpace := eviction.GetAvailableSpace
+	defer func() { eviction.GetAvailableSpace = getAvailableSpace }()
+
+	eviction.GetAvailableSpace = func(path string) (uint64, error) {
+		return availableSpace, nil
+	}
+
+	var evicted []string
+
+	if err = policy.EnsureMinFreeSpace("", items, func(item eviction.Item) error {
+		evicted = append(evicted, item.ID)
+		availableSpace += item.Size
+
+		return nil
+	}); err != nil {
+		t.Fatalf("Can't ensure min free space: %v", err)
+	}
+
+	if !reflect.DeepEqual(evicted, []string{"service1", "service3"}) {
+		t.Errorf("Wrong evicted items: %v", evicted)
+	}
+
+	report := storage.evicted
+
+	if len(report) != 2 || report[0].ID != "service1" || report[1].ID != "service3" ||
+		report[0].Reason != eviction.ReasonMinFreeSpace || report[0].Type != eviction.ServiceItem {
+		t.Errorf("Wrong eviction report: %v", report)
+	}
+
+	policy.RemoveUsage("service2")
+
+	if lastUsed, err := policy.GetLastUsed("service2"); err != nil || !lastUsed.IsZero() {
+		t.Errorf("Wrong last used: %v, %v", lastUsed, err)
+	}
+}
+
+/***********************************************************************************************************************
+ * Interfaces
+ **********************************************************************************************************************/
+
+func (storage *testUsageStorage) SetItemLastUsed(itemType, id string, lastUsed time.Time) error {
+	storage.usage[itemType+"/"+id] = lastUsed
+
+	return nil
+}
+
+func (storage *testUsageStorage) GetItemLastUsed(itemType, id string) (time.Time, error) {
+	lastUsed, ok := storage.usage[itemType+"/"+id]
+	if !ok {
+		return lastUsed, eviction.ErrNotExist
+	}
+
+	return lastUsed, nil
+}
+
+func (storage *testUsageStorage) AddEvictedItem(item eviction.EvictedItem, maxItems int) error {
+	storage.evicted = append(storage.evicted, item)
+
+	if len(storage.evicted) > maxItems {
+		storage.evicted = storage.evicted[len(storage.evicted)-maxItems:]
+	}
+
+	return nil
+}
+
+func (storage *testUsageStorage) RemoveItemUsage(itemType, id string) error {
+	if _, ok := storage.usage[itemType+"/"+id]; !ok {
+		return eviction.ErrNotExist
+	}
+
+	delete(storage.usage, itemType+"/"+id)
+
+	return nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func newTestUsageStorage() *testUsageStorage {
+	return &testUsageStorage{usage: make(map[string]time.Time)}
+}
@@ -0,0 +1,386 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fsimage provides read-only compressed images storage for installed service rootfs and layers.
+package fsimage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/spaceallocator"
+	aosfs "github.com/aosedge/aos_common/utils/fs"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/config"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Supported image formats.
+const (
+	FormatSquashFS = "squashfs"
+	FormatEROFS    = "erofs"
+)
+
+const (
+	imageFileExt   = ".img"
+	verityFileExt  = ".verity"
+	verityDevDir   = "/dev/mapper"
+	verityDevLen   = 16
+	verityDevStart = "aos-"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// ImageInfo installed image information. Image is mounted to mount point which is original item dir.
+type ImageInfo struct {
+	MountPoint string
+	ImagePath  string
+	Format     string
+	RootHash   string
+}
+
+// Storage provides API to store images information.
+type Storage interface {
+	AddFSImage(info ImageInfo) error
+	GetFSImage(mountPoint string) (ImageInfo, error)
+	RemoveFSImage(mountPoint string) error
+}
+
+// Store converts item dirs into read-only images and mounts them.
+type Store struct {
+	format  string
+	verity  bool
+	storage Storage
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// ErrNotExist is returned when requested image doesn't exist.
+var ErrNotExist = errors.New("image does not exist")
+
+// RunCommand runs external command and returns its combined output.
+//
+//nolint:gochecknoglobals // used for unit test mock
+var RunCommand = runCommand
+
+var rootHashRegexp = regexp.MustCompile(`Root hash:\s+([0-9a-fA-F]+)`)
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// New creates image store.
+func New(config *config.Config, storage Storage) (store *Store, err error) {
+	switch config.ImageStorage.Format {
+	case "", FormatSquashFS, FormatEROFS:
+
+	default:
+		return nil, aoserrors.Errorf("unsupported image format: %s", config.ImageStorage.Format)
+	}
+
+	if config.ImageStorage.Verity && config.ImageStorage.Format == "" {
+		return nil, aoserrors.New("image format should be set to use verity")
+	}
+
+	return &Store{
+		format: config.ImageStorage.Format, verity: config.ImageStorage.Verity, storage: storage,
+	}, nil
+}
+
+// Convert converts dir into image and mounts the image to the dir. Dir is left as is if image format is not set.
+// Space for the image is allocated in addition to the dir space and released down to the real image size when the
+// image replaces the dir. It returns image size or zero if dir is not converted.
+func (store *Store) Convert(dir string, allocator spaceallocator.Allocator) (imageSize uint64, err error) {
+	if store.format == "" {
+		return 0, nil
+	}
+
+	log.WithFields(log.Fields{"dir": dir, "format": store.format}).Debug("Convert dir to image")
+
+	info := ImageInfo{MountPoint: dir, ImagePath: dir + imageFileExt, Format: store.format}
+
+	dirSize, err := aosfs.GetDirSize(dir)
+	if err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	// Compressed image is not larger than dir content, so dir size is allocated to create the image
+	allocatedSize := uint64(dirSize)
+
+	imageSpace, err := allocator.AllocateSpace(allocatedSize)
+	if err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	spaces := []spaceallocator.Space{imageSpace}
+
+	defer func() {
+		if err != nil {
+			for _, fileName := range []string{info.ImagePath, info.ImagePath + verityFileExt} {
+				if removeErr := os.RemoveAll(fileName); removeErr != nil {
+					log.Errorf("Can't remove image file: %v", removeErr)
+				}
+			}
+		}
+
+		for _, space := range spaces {
+			if err != nil {
+				if releaseErr := space.Release(); releaseErr != nil {
+					log.Errorf("Can't release image space: %v", releaseErr)
+				}
+
+				continue
+			}
+
+			if acceptErr := space.Accept(); acceptErr != nil {
+				log.Errorf("Can't accept image space: %v", acceptErr)
+			}
+		}
+	}()
+
+	if err = createImage(store.format, dir, info.ImagePath); err != nil {
+		return 0, err
+	}
+
+	if store.verity {
+		if info.RootHash, err = formatVerity(info.ImagePath, info.ImagePath+verityFileExt); err != nil {
+			return 0, err
+		}
+	}
+
+	if imageSize, err = getImageSize(info); err != nil {
+		return 0, err
+	}
+
+	// Verity hash tree may make image larger than dir content
+	if imageSize > allocatedSize {
+		var extraSpace spaceallocator.Space
+
+		if extraSpace, err = allocator.AllocateSpace(imageSize - allocatedSize); err != nil {
+			return 0, aoserrors.Wrap(err)
+		}
+
+		spaces = append(spaces, extraSpace)
+		allocatedSize = imageSize
+	}
+
+	if err = os.RemoveAll(dir); err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	if err = store.storage.AddFSImage(info); err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	if err = mountImage(info); err != nil {
+		if removeErr := store.storage.RemoveFSImage(dir); removeErr != nil {
+			log.Errorf("Can't remove image info: %v", removeErr)
+		}
+
+		return 0, err
+	}
+
+	// Dir is replaced by the image, so space of the dir and unused image space are released
+	allocator.FreeSpace(uint64(dirSize))
+
+	if imageSize < allocatedSize {
+		allocator.FreeSpace(allocatedSize - imageSize)
+	}
+
+	return imageSize, nil
+}
+
+// Mount mounts image of the dir if it is not mounted yet. Nothing is done if dir has no image.
+func (store *Store) Mount(dir string) error {
+	info, err := store.storage.GetFSImage(dir)
+	if err != nil {
+		if errors.Is(err, ErrNotExist) {
+			return nil
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	if isMounted(dir) {
+		return nil
+	}
+
+	return mountImage(info)
+}
+
+// Remove unmounts and removes image of the dir. Nothing is done if dir has no image.
+func (store *Store) Remove(dir string) error {
+	info, err := store.storage.GetFSImage(dir)
+	if err != nil {
+		if errors.Is(err, ErrNotExist) {
+			return nil
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	log.WithField("dir", dir).Debug("Remove image")
+
+	if isMounted(dir) {
+		if err = unmountImage(info); err != nil {
+			return err
+		}
+	}
+
+	for _, fileName := range []string{info.ImagePath, info.ImagePath + verityFileExt} {
+		if err = os.RemoveAll(fileName); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	return aoserrors.Wrap(store.storage.RemoveFSImage(dir))
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func getImageSize(info ImageInfo) (size uint64, err error) {
+	fileNames := []string{info.ImagePath}
+
+	if info.RootHash != "" {
+		fileNames = append(fileNames, info.ImagePath+verityFileExt)
+	}
+
+	for _, fileName := range fileNames {
+		fileSize, err := aosfs.GetDirSize(fileName)
+		if err != nil {
+			return 0, aoserrors.Wrap(err)
+		}
+
+		size += uint64(fileSize)
+	}
+
+	return size, nil
+}
+
+func createImage(format, dir, imagePath string) error {
+	switch format {
+	case FormatSquashFS:
+		if _, err := RunCommand("mksquashfs", dir, imagePath, "-noappend", "-quiet"); err != nil {
+			return err
+		}
+
+	case FormatEROFS:
+		if _, err := RunCommand("mkfs.erofs", imagePath, dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatVerity(imagePath, hashPath string) (rootHash string, err error) {
+	output, err := RunCommand("veritysetup", "format", imagePath, hashPath)
+	if err != nil {
+		return "", err
+	}
+
+	match := rootHashRegexp.FindStringSubmatch(string(output))
+	if match == nil {
+		return "", aoserrors.New("can't get verity root hash")
+	}
+
+	return strings.ToLower(match[1]), nil
+}
+
+// mountImage mounts image. Verity device is opened with recorded root hash, so tampered hash tree fails the mount
+// and tampered image blocks fail on read.
+func mountImage(info ImageInfo) (err error) {
+	log.WithFields(log.Fields{"image": info.ImagePath, "mountPoint": info.MountPoint}).Debug("Mount image")
+
+	if err = os.MkdirAll(info.MountPoint, 0o755); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if info.RootHash == "" {
+		_, err = RunCommand("mount", "-t", info.Format, "-o", "loop,ro", info.ImagePath, info.MountPoint)
+
+		return err
+	}
+
+	device := verityDevice(info.MountPoint)
+
+	if _, err = RunCommand("veritysetup", "open", info.ImagePath, device, info.ImagePath+verityFileExt,
+		info.RootHash); err != nil {
+		return err
+	}
+
+	if _, err = RunCommand("mount", "-t", info.Format, "-o", "ro", verityDevDir+"/"+device,
+		info.MountPoint); err != nil {
+		if _, closeErr := RunCommand("veritysetup", "close", device); closeErr != nil {
+			log.Errorf("Can't close verity device: %v", closeErr)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func unmountImage(info ImageInfo) error {
+	if _, err := RunCommand("umount", info.MountPoint); err != nil {
+		return err
+	}
+
+	if info.RootHash != "" {
+		if _, err := RunCommand("veritysetup", "close", verityDevice(info.MountPoint)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func verityDevice(mountPoint string) string {
+	hash := sha256.Sum256([]byte(mountPoint))
+
+	return verityDevStart + hex.EncodeToString(hash[:])[:verityDevLen]
+}
+
+func isMounted(dir string) bool {
+	mountPoint, err := aosfs.GetMountPoint(dir)
+
+	return err == nil && mountPoint == dir
+}
+
+func runCommand(name string, args ...string) (output []byte, err error) {
+	if output, err = exec.Command(name, args...).CombinedOutput(); err != nil {
+		return output, aoserrors.Errorf("%s: %v: %s", name, err, strings.TrimSpace(string(output)))
+	}
+
+	return output, nil
+}
@@ -0,0 +1,524 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsimage_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/spaceallocator"
+	aosfs "github.com/aosedge/aos_common/utils/fs"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/config"
+	"github.com/aosedge/aos_servicemanager/fsimage"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const testRootHash = "5a3c8f0e1b2d4c6e8f0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60"
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type testStorage struct {
+	images map[string]fsimage.ImageInfo
+}
+
+// testAllocator tracks allocated space size.
+type testAllocator struct {
+	size uint64
+}
+
+type testSpace struct {
+	allocator *testAllocator
+	size      uint64
+}
+
+// testCommandRunner emulates image tools: image is a copy of source dir, verity hash file contains image path and
+// mount copies image content to mount point.
+type testCommandRunner struct {
+	commands      []string
+	verityDevices map[string]string
+	failCommand   string
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var tmpDir string
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Main
+ **********************************************************************************************************************/
+
+func TestMain(m *testing.M) {
+	var err error
+
+	if tmpDir, err = os.MkdirTemp("", "sm_"); err != nil {
+		log.Fatalf("Error create temporary dir: %v", err)
+	}
+
+	ret := m.Run()
+
+	if err = os.RemoveAll(tmpDir); err != nil {
+		log.Errorf("Error removing tmp dir: %v", err)
+	}
+
+	os.Exit(ret)
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestUnsupportedFormat(t *testing.T) {
+	if _, err := fsimage.New(&config.Config{
+		ImageStorage: config.ImageStorage{Format: "ext4"},
+	}, newTestStorage()); err == nil {
+		t.Error("Error expected for unsupported format")
+	}
+
+	if _, err := fsimage.New(&config.Config{
+		ImageStorage: config.ImageStorage{Verity: true},
+	}, newTestStorage()); err == nil {
+		t.Error("Error expected for verity without format")
+	}
+}
+
+func TestNoFormat(t *testing.T) {
+	runner := newTestCommandRunner()
+	storage := newTestStorage()
+
+	store, err := fsimage.New(&config.Config{}, storage)
+	if err != nil {
+		t.Fatalf("Can't create image store: %v", err)
+	}
+
+	dir := filepath.Join(tmpDir, "noformat")
+
+	if err = createTestDir(dir); err != nil {
+		t.Fatalf("Can't create test dir: %v", err)
+	}
+
+	if _, err = store.Convert(dir, &testAllocator{}); err != nil {
+		t.Fatalf("Can't convert dir: %v", err)
+	}
+
+	if err = store.Mount(dir); err != nil {
+		t.Fatalf("Can't mount dir: %v", err)
+	}
+
+	if err = store.Remove(dir); err != nil {
+		t.Fatalf("Can't remove dir image: %v", err)
+	}
+
+	if len(runner.commands) != 0 || len(storage.images) != 0 {
+		t.Errorf("Unexpected commands: %v", runner.commands)
+	}
+
+	if err = checkTestDir(dir); err != nil {
+		t.Errorf("Wrong dir content: %v", err)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	type testData struct {
+		format   string
+		verity   bool
+		commands []string
+	}
+
+	data := []testData{
+		{
+			format:   fsimage.FormatSquashFS,
+			commands: []string{"mksquashfs", "mount -o loop,ro"},
+		},
+		{
+			format:   fsimage.FormatEROFS,
+			commands: []string{"mkfs.erofs", "mount -o loop,ro"},
+		},
+		{
+			format:   fsimage.FormatSquashFS,
+			verity:   true,
+			commands: []string{"mksquashfs", "veritysetup format", "veritysetup open", "mount -o ro"},
+		},
+	}
+
+	for i, item := range data {
+		runner := newTestCommandRunner()
+		storage := newTestStorage()
+
+		store, err := fsimage.New(&config.Config{
+			ImageStorage: config.ImageStorage{Format: item.format, Verity: item.verity},
+		}, storage)
+		if err != nil {
+			t.Fatalf("Can't create image store: %v", err)
+		}
+
+		dir := filepath.Join(tmpDir, "convert", item.format, strings.Repeat("item", i+1))
+
+		if err = createTestDir(dir); err != nil {
+			t.Fatalf("Can't create test dir: %v", err)
+		}
+
+		dirSize, err := aosfs.GetDirSize(dir)
+		if err != nil {
+			t.Fatalf("Can't get dir size: %v", err)
+		}
+
+		// Emulate space allocated for unpacked dir
+		allocator := &testAllocator{size: uint64(dirSize)}
+
+		imageSize, err := store.Convert(dir, allocator)
+		if err != nil {
+			t.Fatalf("Can't convert dir: %v", err)
+		}
+
+		if imageSize == 0 || allocator.size != imageSize {
+			t.Errorf("Wrong allocated space: %d, image size: %d", allocator.size, imageSize)
+		}
+
+		if !reflect.DeepEqual(runner.commands, item.commands) {
+			t.Errorf("Wrong commands: %v", runner.commands)
+		}
+
+		if err = checkTestDir(dir); err != nil {
+			t.Errorf("Wrong mounted dir content: %v", err)
+		}
+
+		info, err := storage.GetFSImage(dir)
+		if err != nil {
+			t.Fatalf("Can't get image info: %v", err)
+		}
+
+		if info.Format != item.format || info.ImagePath != dir+".img" {
+			t.Errorf("Wrong image info: %v", info)
+		}
+
+		if item.verity && info.RootHash != testRootHash {
+			t.Errorf("Wrong root hash: %s", info.RootHash)
+		}
+
+		if err = store.Remove(dir); err != nil {
+			t.Fatalf("Can't remove image: %v", err)
+		}
+
+		if _, err = os.Stat(info.ImagePath); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Image file should be removed: %v", err)
+		}
+
+		if _, err = storage.GetFSImage(dir); !errors.Is(err, fsimage.ErrNotExist) {
+			t.Errorf("Image info should be removed: %v", err)
+		}
+	}
+}
+
+func TestMount(t *testing.T) {
+	runner := newTestCommandRunner()
+	storage := newTestStorage()
+
+	store, err := fsimage.New(&config.Config{
+		ImageStorage: config.ImageStorage{Format: fsimage.FormatEROFS, Verity: true},
+	}, storage)
+	if err != nil {
+		t.Fatalf("Can't create image store: %v", err)
+	}
+
+	dir := filepath.Join(tmpDir, "mount")
+
+	if err = createTestDir(dir); err != nil {
+		t.Fatalf("Can't create test dir: %v", err)
+	}
+
+	if _, err = store.Convert(dir, &testAllocator{}); err != nil {
+		t.Fatalf("Can't convert dir: %v", err)
+	}
+
+	// Emulate reboot
+	if err = os.RemoveAll(dir); err != nil {
+		t.Fatalf("Can't remove dir: %v", err)
+	}
+
+	runner.verityDevices = make(map[string]string)
+
+	if err = store.Mount(dir); err != nil {
+		t.Fatalf("Can't mount image: %v", err)
+	}
+
+	if err = checkTestDir(dir); err != nil {
+		t.Errorf("Wrong mounted dir content: %v", err)
+	}
+
+	// Emulate tampered image
+	if err = os.RemoveAll(dir); err != nil {
+		t.Fatalf("Can't remove dir: %v", err)
+	}
+
+	runner.verityDevices = make(map[string]string)
+	runner.failCommand = "veritysetup open"
+
+	if err = store.Mount(dir); err == nil {
+		t.Error("Error expected for tampered image")
+	}
+
+	// Dir without image
+	if err = store.Mount(filepath.Join(tmpDir, "unknown")); err != nil {
+		t.Errorf("Can't mount dir without image: %v", err)
+	}
+}
+
+func TestConvertFailed(t *testing.T) {
+	runner := newTestCommandRunner()
+	storage := newTestStorage()
+
+	store, err := fsimage.New(&config.Config{
+		ImageStorage: config.ImageStorage{Format: fsimage.FormatSquashFS, Verity: true},
+	}, storage)
+	if err != nil {
+		t.Fatalf("Can't create image store: %v", err)
+	}
+
+	dir := filepath.Join(tmpDir, "failed")
+
+	if err = createTestDir(dir); err != nil {
+		t.Fatalf("Can't create test dir: %v", err)
+	}
+
+	runner.failCommand = "mount"
+
+	allocator := &testAllocator{}
+
+	if _, err = store.Convert(dir, allocator); err == nil {
+		t.Fatal("Error expected")
+	}
+
+	if allocator.size != 0 {
+		t.Errorf("Image space should be released: %d", allocator.size)
+	}
+
+	if !reflect.DeepEqual(runner.commands[len(runner.commands)-1:], []string{"veritysetup close"}) {
+		t.Errorf("Verity device should be closed: %v", runner.commands)
+	}
+
+	for _, fileName := range []string{dir + ".img", dir + ".img.verity"} {
+		if _, err = os.Stat(fileName); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Image file should be removed: %v", err)
+		}
+	}
+
+	if len(storage.images) != 0 {
+		t.Errorf("Image info should be removed: %v", storage.images)
+	}
+}
+
+/***********************************************************************************************************************
+ * Interfaces
+ **********************************************************************************************************************/
+
+func (allocator *testAllocator) AllocateSpace(size uint64) (spaceallocator.Space, error) {
+	allocator.size += size
+
+	return &testSpace{allocator: allocator, size: size}, nil
+}
+
+func (allocator *testAllocator) FreeSpace(size uint64) {
+	allocator.size -= size
+}
+
+func (allocator *testAllocator) AddOutdatedItem(id string, size uint64, timestamp time.Time) error {
+	return nil
+}
+
+func (allocator *testAllocator) RestoreOutdatedItem(id string) {
+}
+
+func (allocator *testAllocator) Close() error {
+	return nil
+}
+
+func (space *testSpace) Accept() error {
+	return nil
+}
+
+func (space *testSpace) Release() error {
+	space.allocator.FreeSpace(space.size)
+
+	return nil
+}
+
+func (storage *testStorage) AddFSImage(info fsimage.ImageInfo) error {
+	storage.images[info.MountPoint] = info
+
+	return nil
+}
+
+func (storage *testStorage) GetFSImage(mountPoint string) (fsimage.ImageInfo, error) {
+	info, ok := storage.images[mountPoint]
+	if !ok {
+		return info, fsimage.ErrNotExist
+	}
+
+	return info, nil
+}
+
+func (storage *testStorage) RemoveFSImage(mountPoint string) error {
+	if _, ok := storage.images[mountPoint]; !ok {
+		return fsimage.ErrNotExist
+	}
+
+	delete(storage.images, mountPoint)
+
+	return nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func newTestStorage() *testStorage {
+	return &testStorage{images: make(map[string]fsimage.ImageInfo)}
+}
+
+func newTestCommandRunner() *testCommandRunner {
+	runner := &testCommandRunner{verityDevices: make(map[string]string)}
+
+	fsimage.RunCommand = runner.runCommand
+
+	return runner
+}
+
+func (runner *testCommandRunner) runCommand(name string, args ...string) (output []byte, err error) {
+	command := name
+
+	switch name {
+	case "veritysetup":
+		command = name + " " + args[0]
+
+	case "mount":
+		command = name + " -o " + args[3]
+	}
+
+	runner.commands = append(runner.commands, command)
+
+	if runner.failCommand != "" && strings.HasPrefix(command, runner.failCommand) {
+		return nil, aoserrors.Errorf("%s failed", command)
+	}
+
+	switch command {
+	case "mksquashfs":
+		return nil, copyDir(args[0], args[1])
+
+	case "mkfs.erofs":
+		return nil, copyDir(args[1], args[0])
+
+	case "veritysetup format":
+		if err = os.WriteFile(args[2], []byte(args[1]), 0o600); err != nil {
+			return nil, aoserrors.Wrap(err)
+		}
+
+		return []byte("VERITY header information for " + args[2] + "\nRoot hash:      \t" +
+			strings.ToUpper(testRootHash) + "\n"), nil
+
+	case "veritysetup open":
+		if args[4] != testRootHash {
+			return nil, aoserrors.New("root hash mismatch")
+		}
+
+		runner.verityDevices[filepath.Join("/dev/mapper", args[2])] = args[1]
+
+	case "veritysetup close":
+		delete(runner.verityDevices, filepath.Join("/dev/mapper", args[1]))
+
+	case "mount -o loop,ro":
+		return nil, copyDir(args[4], args[5])
+
+	case "mount -o ro":
+		imagePath, ok := runner.verityDevices[args[4]]
+		if !ok {
+			return nil, aoserrors.Errorf("device %s not found", args[4])
+		}
+
+		return nil, copyDir(imagePath, args[5])
+	}
+
+	return nil, nil
+}
+
+func createTestDir(dir string) error {
+	if err := os.MkdirAll(filepath.Join(dir, "subdir"), 0o755); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "subdir", "file"), []byte("content"), 0o600); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func checkTestDir(dir string) error {
+	content, err := os.ReadFile(filepath.Join(dir, "subdir", "file"))
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if string(content) != "content" {
+		return aoserrors.Errorf("wrong file content: %s", content)
+	}
+
+	return nil
+}
+
+func copyDir(source, destination string) error {
+	if err := os.MkdirAll(destination, 0o755); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	output, err := exec.Command("cp", "-a", source+"/.", destination).CombinedOutput()
+	if err != nil {
+		return aoserrors.Errorf("cp: %v: %s", err, output)
+	}
+
+	return nil
+}
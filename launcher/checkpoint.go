@@ -0,0 +1,227 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/aostypes"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/runner"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	checkpointsDir     = "checkpoints"
+	checkpointImageDir = "image"
+	checkpointInfoFile = "checkpoint.json"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type checkpointInfo struct {
+	AosVersion uint64 `json:"aosVersion"`
+	SpecHash   string `json:"specHash"`
+}
+
+// checkpointSpec instance parameters checkpoint depends on. It doesn't include data which is changed on each instance
+// start, like instance secret, as checkpoint can't be restored otherwise.
+type checkpointSpec struct {
+	ImageDigest   []byte                     `json:"imageDigest"`
+	ServiceConfig *ServiceConfig             `json:"serviceConfig"`
+	Mounts        []runtimespec.Mount        `json:"mounts"`
+	Network       aostypes.NetworkParameters `json:"network"`
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (launcher *Launcher) prepareCheckpoints() {
+	launcher.runMutex.Lock()
+	defer launcher.runMutex.Unlock()
+
+	for _, instance := range launcher.currentInstances {
+		instance.checkpoint = instance.service != nil && instance.service.serviceConfig != nil &&
+			instance.service.serviceConfig.Checkpoint && !secretRequired(instance.service) &&
+			instance.prevInstance == nil && instance.runStatus.State == cloudprotocol.InstanceStateActive
+	}
+}
+
+func (launcher *Launcher) checkpointInstance(instance *runtimeInstanceInfo) {
+	log.WithFields(instanceLogFields(instance, nil)).Debug("Checkpoint instance")
+
+	if err := launcher.doCheckpointInstance(instance); err != nil {
+		log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't checkpoint instance: %v", err)
+
+		launcher.removeCheckpoint(instance.InstanceID)
+
+		return
+	}
+
+	log.WithFields(instanceLogFields(instance, nil)).Info("Instance successfully checkpointed")
+}
+
+func (launcher *Launcher) doCheckpointInstance(instance *runtimeInstanceInfo) error {
+	checkpointDir := launcher.getCheckpointDir(instance.InstanceID)
+
+	if err := os.RemoveAll(checkpointDir); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	specHash, err := getSpecHash(instance)
+	if err != nil {
+		return err
+	}
+
+	imageDir := filepath.Join(checkpointDir, checkpointImageDir)
+
+	if err := os.MkdirAll(imageDir, 0o700); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err := instance.runner.CheckpointInstance(instance.InstanceID, imageDir); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	data, err := json.Marshal(checkpointInfo{AosVersion: instance.service.AosVersion, SpecHash: specHash})
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(checkpointDir, checkpointInfoFile), data, 0o600); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+// getRestorePath returns checkpoint image path if instance can be restored from it.
+func (launcher *Launcher) getRestorePath(instance *runtimeInstanceInfo) string {
+	if secretRequired(instance.service) {
+		return ""
+	}
+
+	checkpointDir := launcher.getCheckpointDir(instance.InstanceID)
+
+	var info checkpointInfo
+
+	if err := getJSONFromFile(filepath.Join(checkpointDir, checkpointInfoFile), &info); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.WithFields(instanceLogFields(instance, nil)).Warnf("Can't read checkpoint info: %v", err)
+		}
+
+		return ""
+	}
+
+	specHash, err := getSpecHash(instance)
+	if err != nil {
+		log.WithFields(instanceLogFields(instance, nil)).Warnf("Can't get checkpoint spec hash: %v", err)
+
+		return ""
+	}
+
+	if info.AosVersion != instance.service.AosVersion || info.SpecHash != specHash {
+		log.WithFields(instanceLogFields(instance, nil)).Debug("Instance checkpoint is outdated")
+
+		return ""
+	}
+
+	return filepath.Join(checkpointDir, checkpointImageDir)
+}
+
+func (launcher *Launcher) checkRestoreStatus(
+	instance *runtimeInstanceInfo, runParams runner.RunParameters, runStatus runner.InstanceStatus,
+) runner.InstanceStatus {
+	defer launcher.removeCheckpoint(instance.InstanceID)
+
+	if runStatus.State != cloudprotocol.InstanceStateFailed {
+		log.WithFields(instanceLogFields(instance, nil)).Info("Instance restored from checkpoint")
+
+		return runStatus
+	}
+
+	log.WithFields(instanceLogFields(instance, nil)).Warnf("Can't restore instance, start from scratch: %v",
+		runStatus.Err)
+
+	if err := instance.runner.StopInstance(instance.InstanceID); err != nil {
+		log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't stop instance: %v", err)
+	}
+
+	runParams.RestorePath = ""
+
+	return instance.runner.StartInstance(instance.InstanceID, instance.runtimeDir, runParams)
+}
+
+func (launcher *Launcher) getCheckpointDir(instanceID string) string {
+	return filepath.Join(launcher.config.WorkingDir, checkpointsDir, instanceID)
+}
+
+func (launcher *Launcher) removeCheckpoint(instanceID string) {
+	if err := os.RemoveAll(launcher.getCheckpointDir(instanceID)); err != nil {
+		log.WithField("instanceID", instanceID).Errorf("Can't remove checkpoint: %v", err)
+	}
+}
+
+func (launcher *Launcher) removeCheckpoints() {
+	if err := os.RemoveAll(filepath.Join(launcher.config.WorkingDir, checkpointsDir)); err != nil {
+		log.Errorf("Can't remove checkpoints: %v", err)
+	}
+}
+
+// secretRequired returns true if instance is registered with new secret on each start. Such instance can't be restored
+// as restored process keeps the secret issued on previous start.
+func secretRequired(service *serviceInfo) bool {
+	return service != nil && service.serviceConfig != nil && service.serviceConfig.Permissions != nil
+}
+
+func getSpecHash(instance *runtimeInstanceInfo) (string, error) {
+	var ociSpec runtimespec.Spec
+
+	if err := getJSONFromFile(filepath.Join(instance.runtimeDir, runtimeConfigFile), &ociSpec); err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(checkpointSpec{
+		ImageDigest:   instance.service.ManifestDigest,
+		ServiceConfig: instance.service.serviceConfig,
+		Mounts:        ociSpec.Mounts,
+		Network:       instance.NetworkParameters,
+	})
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	hash := sha256.Sum256(data)
+
+	return hex.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,424 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+
+	"github.com/aosedge/aos_servicemanager/runner"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Service dependency conditions.
+const (
+	DependencyConditionStarted = "started"
+	DependencyConditionHealthy = "healthy"
+)
+
+const defaultDependencyTimeout = 2 * time.Minute
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// ServiceDependency service dependency parameters. Instances of the service are started only when all instances of
+// the dependency service are active or, for healthy condition, pass their health check.
+type ServiceDependency struct {
+	ServiceID string `json:"serviceId"`
+	Condition string `json:"condition,omitempty"`
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var errDependencyNotReady = errors.New("dependency is not ready")
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// startInstances starts instances in dependency order: instances are started in waves, each wave contains instances
+// which dependencies are satisfied. Within a wave instances are started by priority. Instances which dependencies are
+// not satisfied within dependency timeout are failed.
+func (launcher *Launcher) startInstances(instances []*runtimeInstanceInfo) {
+	pendingInstances := launcher.failCyclicInstances(instances)
+
+	waitTimer := time.NewTimer(launcher.getDependencyTimeout())
+	defer waitTimer.Stop()
+
+	waitTimeout := false
+
+	for len(pendingInstances) > 0 {
+		readyInstances, waitingInstances := launcher.getReadyInstances(pendingInstances, waitTimeout)
+
+		if len(readyInstances) > 0 {
+			launcher.startPriorityInstances(readyInstances)
+		} else if len(waitingInstances) > 0 {
+			waitTimeout = !launcher.waitDependencies(waitTimer.C)
+		}
+
+		pendingInstances = waitingInstances
+	}
+}
+
+// waitDependencies waits for instance status change. Launcher lock is released while waiting to not block other
+// launcher operations. Returns false if wait is timed out or launcher is closed.
+func (launcher *Launcher) waitDependencies(timeout <-chan time.Time) bool {
+	launcher.Unlock()
+	defer launcher.Lock()
+
+	select {
+	case <-launcher.dependencyChannel:
+		return true
+
+	case <-timeout:
+		return false
+
+	case <-launcher.ctx.Done():
+		return false
+	}
+}
+
+// notifyDependencies wakes up instances waiting for their dependencies.
+func (launcher *Launcher) notifyDependencies() {
+	select {
+	case launcher.dependencyChannel <- struct{}{}:
+
+	default:
+	}
+}
+
+// waitRunInstancesDone waits until run instances in progress is done. Launcher lock should be taken.
+func (launcher *Launcher) waitRunInstancesDone() {
+	for launcher.runInstancesInProgress {
+		launcher.runInstancesCond.Wait()
+	}
+}
+
+func (launcher *Launcher) startPriorityInstances(instances []*runtimeInstanceInfo) {
+	var currentPriority uint64
+
+	if len(instances) > 0 {
+		currentPriority = instances[0].Priority
+
+		log.WithField("priority", currentPriority).Debug("Start instances with priority")
+	}
+
+	for _, instance := range instances {
+		if currentPriority != instance.Priority {
+			launcher.actionHandler.Wait()
+
+			currentPriority = instance.Priority
+
+			log.WithField("priority", currentPriority).Debug("Start instances with priority")
+		}
+
+		launcher.doStartAction(instance)
+	}
+
+	launcher.actionHandler.Wait()
+}
+
+// stopInstances stops instances in reverse dependency order: dependents are stopped before their dependencies.
+func (launcher *Launcher) stopInstances(instances []*runtimeInstanceInfo) {
+	for _, levelInstances := range getStopLevels(instances) {
+		for _, instance := range levelInstances {
+			launcher.doStopAction(instance)
+		}
+
+		launcher.actionHandler.Wait()
+	}
+}
+
+// failCyclicInstances fails instances of services which are part of dependency cycle and returns the rest.
+func (launcher *Launcher) failCyclicInstances(instances []*runtimeInstanceInfo) []*runtimeInstanceInfo {
+	launcher.runMutex.Lock()
+	defer launcher.runMutex.Unlock()
+
+	cycles := make(map[string]error)
+	visited := make(map[string]bool)
+
+	var (
+		path  []string
+		visit func(serviceID string)
+	)
+
+	visit = func(serviceID string) {
+		if inPath, ok := visited[serviceID]; ok {
+			if !inPath {
+				return
+			}
+
+			cycle := append(append([]string{}, path[slices.Index(path, serviceID):]...), serviceID)
+
+			for _, cycleServiceID := range cycle {
+				if _, ok := cycles[cycleServiceID]; !ok {
+					cycles[cycleServiceID] = aoserrors.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+				}
+			}
+
+			return
+		}
+
+		visited[serviceID] = true
+		path = append(path, serviceID)
+
+		for _, dependency := range launcher.getServiceDependencies(serviceID) {
+			visit(dependency.ServiceID)
+		}
+
+		path = path[:len(path)-1]
+		visited[serviceID] = false
+	}
+
+	pendingInstances := make([]*runtimeInstanceInfo, 0, len(instances))
+
+	for _, instance := range instances {
+		visit(instance.ServiceID)
+
+		if err, ok := cycles[instance.ServiceID]; ok {
+			launcher.dependencyFailed(instance, err)
+
+			continue
+		}
+
+		pendingInstances = append(pendingInstances, instance)
+	}
+
+	return pendingInstances
+}
+
+// getReadyInstances returns instances which dependencies are satisfied and instances which should wait for their
+// dependencies. Instances which dependencies can't be satisfied are failed.
+func (launcher *Launcher) getReadyInstances(
+	instances []*runtimeInstanceInfo, waitTimeout bool,
+) (readyInstances, waitingInstances []*runtimeInstanceInfo) {
+	launcher.runMutex.Lock()
+	defer launcher.runMutex.Unlock()
+
+	pendingServices := make(map[string]struct{})
+
+	for _, instance := range instances {
+		pendingServices[instance.ServiceID] = struct{}{}
+	}
+
+	for _, instance := range instances {
+		err := launcher.checkDependencies(instance, pendingServices)
+
+		switch {
+		case err == nil:
+			readyInstances = append(readyInstances, instance)
+
+		case errors.Is(err, errDependencyNotReady) && !waitTimeout:
+			waitingInstances = append(waitingInstances, instance)
+
+		default:
+			launcher.dependencyFailed(instance, err)
+		}
+	}
+
+	return readyInstances, waitingInstances
+}
+
+func (launcher *Launcher) checkDependencies(
+	instance *runtimeInstanceInfo, pendingServices map[string]struct{},
+) error {
+	for _, dependency := range launcher.getServiceDependencies(instance.ServiceID) {
+		if _, ok := pendingServices[dependency.ServiceID]; ok {
+			return aoserrors.Errorf("%w: %s", errDependencyNotReady, dependency.ServiceID)
+		}
+
+		if err := launcher.checkDependency(dependency); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (launcher *Launcher) checkDependency(dependency ServiceDependency) error {
+	if dependency.Condition != "" && dependency.Condition != DependencyConditionStarted &&
+		dependency.Condition != DependencyConditionHealthy {
+		return aoserrors.Errorf("unsupported dependency condition: %s", dependency.Condition)
+	}
+
+	running := false
+
+	for _, instance := range launcher.currentInstances {
+		if instance.ServiceID != dependency.ServiceID || instance.stopping {
+			continue
+		}
+
+		running = true
+
+		switch {
+		case instance.runStatus.State == cloudprotocol.InstanceStateFailed,
+			instance.runStatus.State == runner.InstanceStateCompleted && instance.runStatus.ExitCode != 0:
+			return aoserrors.Errorf("dependency service %s failed", dependency.ServiceID)
+
+		// Successfully completed job satisfies any condition
+		case instance.runStatus.State == runner.InstanceStateCompleted:
+
+		case instance.runStatus.State != cloudprotocol.InstanceStateActive,
+			dependency.Condition == DependencyConditionHealthy && !instance.isHealthy():
+			return aoserrors.Errorf("%w: %s", errDependencyNotReady, dependency.ServiceID)
+		}
+	}
+
+	if !running {
+		return aoserrors.Errorf("dependency service %s is not running", dependency.ServiceID)
+	}
+
+	return nil
+}
+
+func (launcher *Launcher) dependencyFailed(instance *runtimeInstanceInfo, err error) {
+	// Previous instance keeps running if new version can't be started
+	if instance.prevInstance != nil {
+		log.WithFields(instanceLogFields(instance, log.Fields{
+			"prevInstanceID": instance.prevInstance.InstanceID,
+		})).Errorf("Can't start new instance version, keep previous one: %v", err)
+
+		return
+	}
+
+	launcher.currentInstances[instance.InstanceID] = instance
+	instance.runStatus = runner.InstanceStatus{InstanceID: instance.InstanceID}
+
+	launcher.instanceFailed(instance, err)
+}
+
+func (launcher *Launcher) getServiceDependencies(serviceID string) []ServiceDependency {
+	service, ok := launcher.currentServices[serviceID]
+	if !ok || service.serviceConfig == nil {
+		return nil
+	}
+
+	return service.serviceConfig.Dependencies
+}
+
+func (launcher *Launcher) getDependencyTimeout() time.Duration {
+	if launcher.config.ServiceDependencyTimeout.Duration != 0 {
+		return launcher.config.ServiceDependencyTimeout.Duration
+	}
+
+	return defaultDependencyTimeout
+}
+
+func (launcher *Launcher) setInstanceHealthy(instance *runtimeInstanceInfo, healthy bool) {
+	launcher.runMutex.Lock()
+	defer launcher.runMutex.Unlock()
+
+	if instance.healthy == healthy {
+		return
+	}
+
+	instance.healthy = healthy
+
+	launcher.notifyDependencies()
+}
+
+func (instance *runtimeInstanceInfo) isHealthy() bool {
+	if instance.service == nil || instance.service.serviceConfig == nil ||
+		instance.service.serviceConfig.HealthCheck == nil {
+		return true
+	}
+
+	return instance.healthy
+}
+
+// getStopLevels groups instances by dependency level in stop order: instances which no other stopped instance
+// depends on go first.
+func getStopLevels(instances []*runtimeInstanceInfo) [][]*runtimeInstanceInfo {
+	dependencies := make(map[string][]ServiceDependency)
+
+	for _, instance := range instances {
+		if instance.service != nil && instance.service.serviceConfig != nil {
+			dependencies[instance.ServiceID] = instance.service.serviceConfig.Dependencies
+		}
+	}
+
+	levels := make(map[string]int)
+
+	var getLevel func(serviceID string, visiting map[string]bool) int
+
+	getLevel = func(serviceID string, visiting map[string]bool) int {
+		if level, ok := levels[serviceID]; ok {
+			return level
+		}
+
+		// Break dependency cycles
+		if visiting[serviceID] {
+			return 0
+		}
+
+		visiting[serviceID] = true
+		defer delete(visiting, serviceID)
+
+		level := 0
+
+		for _, dependency := range dependencies[serviceID] {
+			if _, ok := dependencies[dependency.ServiceID]; !ok {
+				continue
+			}
+
+			if dependencyLevel := getLevel(dependency.ServiceID, visiting) + 1; dependencyLevel > level {
+				level = dependencyLevel
+			}
+		}
+
+		levels[serviceID] = level
+
+		return level
+	}
+
+	instanceLevels := make(map[int][]*runtimeInstanceInfo)
+
+	for _, instance := range instances {
+		level := getLevel(instance.ServiceID, make(map[string]bool))
+		instanceLevels[level] = append(instanceLevels[level], instance)
+	}
+
+	stopLevels := make([]int, 0, len(instanceLevels))
+
+	for level := range instanceLevels {
+		stopLevels = append(stopLevels, level)
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(stopLevels)))
+
+	result := make([][]*runtimeInstanceInfo, 0, len(stopLevels))
+
+	for _, level := range stopLevels {
+		result = append(result, instanceLevels[level])
+	}
+
+	return result
+}
@@ -0,0 +1,345 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/aostypes"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/runner"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Health check actions.
+const (
+	HealthCheckActionRestart = "restart"
+	HealthCheckActionFail    = "fail"
+)
+
+const (
+	defaultHealthCheckInterval         = 30 * time.Second
+	defaultHealthCheckTimeout          = 10 * time.Second
+	defaultHealthCheckFailureThreshold = 3
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// HealthCheck service instance health check parameters.
+type HealthCheck struct {
+	Exec             *ExecProbe        `json:"exec,omitempty"`
+	TCPSocket        *TCPSocketProbe   `json:"tcpSocket,omitempty"`
+	HTTPGet          *HTTPGetProbe     `json:"httpGet,omitempty"`
+	Interval         aostypes.Duration `json:"interval,omitempty"`
+	Timeout          aostypes.Duration `json:"timeout,omitempty"`
+	FailureThreshold uint              `json:"failureThreshold,omitempty"`
+	Action           string            `json:"action,omitempty"`
+}
+
+// ExecProbe executes command inside instance container.
+type ExecProbe struct {
+	Command []string `json:"command"`
+}
+
+// TCPSocketProbe connects to instance TCP port.
+type TCPSocketProbe struct {
+	Port uint16 `json:"port"`
+}
+
+// HTTPGetProbe performs HTTP GET request to the instance.
+type HTTPGetProbe struct {
+	Path string `json:"path,omitempty"`
+	Port uint16 `json:"port"`
+}
+
+type healthChecker struct {
+	cancelFunction context.CancelFunc
+	doneChannel    chan struct{}
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var errHealthCheckFailed = errors.New("health check failed")
+
+// Health probes are sent directly to the instance without proxy and don't keep idle connections to instances.
+//
+//nolint:gochecknoglobals // shared by all HTTP probes
+var probeHTTPClient = &http.Client{Transport: &http.Transport{Proxy: nil, DisableKeepAlives: true}}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (launcher *Launcher) startHealthCheck(instance *runtimeInstanceInfo) {
+	if instance.service == nil || instance.service.serviceConfig == nil ||
+		instance.service.serviceConfig.HealthCheck == nil {
+		return
+	}
+
+	healthCheck := launcher.getHealthCheckParams(*instance.service.serviceConfig.HealthCheck)
+
+	log.WithFields(instanceLogFields(instance, log.Fields{
+		"interval": healthCheck.Interval, "timeout": healthCheck.Timeout, "action": healthCheck.Action,
+	})).Debug("Start instance health check")
+
+	ctx, cancelFunction := context.WithCancel(context.Background())
+
+	instance.healthChecker = &healthChecker{cancelFunction: cancelFunction, doneChannel: make(chan struct{})}
+
+	go launcher.runHealthCheck(ctx, instance, healthCheck, instance.healthChecker.doneChannel)
+}
+
+func (launcher *Launcher) stopHealthCheck(instance *runtimeInstanceInfo) {
+	if instance.healthChecker == nil {
+		return
+	}
+
+	instance.healthChecker.cancelFunction()
+	<-instance.healthChecker.doneChannel
+
+	instance.healthChecker = nil
+}
+
+func (launcher *Launcher) getHealthCheckParams(healthCheck HealthCheck) HealthCheck {
+	if healthCheck.Interval.Duration == 0 {
+		healthCheck.Interval.Duration = defaultHealthCheckInterval
+	}
+
+	if healthCheck.Timeout.Duration == 0 {
+		healthCheck.Timeout = launcher.config.ServiceHealthCheckTimeout
+	}
+
+	if healthCheck.Timeout.Duration == 0 {
+		healthCheck.Timeout.Duration = defaultHealthCheckTimeout
+	}
+
+	if healthCheck.FailureThreshold == 0 {
+		healthCheck.FailureThreshold = defaultHealthCheckFailureThreshold
+	}
+
+	if healthCheck.Action == "" {
+		healthCheck.Action = HealthCheckActionRestart
+	}
+
+	return healthCheck
+}
+
+func (launcher *Launcher) runHealthCheck(
+	ctx context.Context, instance *runtimeInstanceInfo, healthCheck HealthCheck, doneChannel chan<- struct{},
+) {
+	defer close(doneChannel)
+
+	var (
+		failureCount uint
+		unhealthy    bool
+	)
+
+	for {
+		select {
+		case <-time.After(healthCheck.Interval.Duration):
+
+		case <-ctx.Done():
+			return
+		}
+
+		// Don't probe instance which is failed by runner, unless it is failed by health check
+		if !unhealthy && !launcher.isInstanceActive(instance) {
+			failureCount = 0
+
+			continue
+		}
+
+		err := launcher.probeInstance(ctx, instance, healthCheck)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			failureCount = 0
+
+			launcher.setInstanceHealthy(instance, true)
+
+			if unhealthy {
+				log.WithFields(instanceLogFields(instance, nil)).Info("Instance is healthy again")
+
+				unhealthy = false
+
+				launcher.sendHealthStatus(ctx, runner.InstanceStatus{
+					InstanceID: instance.InstanceID, State: cloudprotocol.InstanceStateActive,
+				})
+			}
+
+			continue
+		}
+
+		failureCount++
+
+		log.WithFields(instanceLogFields(instance, log.Fields{
+			"failureCount": failureCount,
+		})).Warnf("Instance health check failed: %v", err)
+
+		if unhealthy || failureCount < healthCheck.FailureThreshold {
+			continue
+		}
+
+		failureCount = 0
+
+		launcher.setInstanceHealthy(instance, false)
+
+		if healthCheck.Action == HealthCheckActionFail {
+			unhealthy = true
+
+			launcher.sendHealthStatus(ctx, runner.InstanceStatus{
+				InstanceID: instance.InstanceID,
+				State:      cloudprotocol.InstanceStateFailed,
+				Err:        aoserrors.Errorf("%w: %v", errHealthCheckFailed, err),
+			})
+
+			continue
+		}
+
+		log.WithFields(instanceLogFields(instance, nil)).Warn("Restart unhealthy instance")
+
+		launcher.sendHealthStatus(ctx, launcher.restartRuntimeInstance(instance))
+	}
+}
+
+func (launcher *Launcher) probeInstance(
+	ctx context.Context, instance *runtimeInstanceInfo, healthCheck HealthCheck,
+) error {
+	ctx, cancelFunction := context.WithTimeout(ctx, healthCheck.Timeout.Duration)
+	defer cancelFunction()
+
+	switch {
+	case healthCheck.Exec != nil:
+		return aoserrors.Wrap(instance.runner.ExecInstance(ctx, instance.InstanceID, healthCheck.Exec.Command))
+
+	case healthCheck.TCPSocket != nil:
+		address, err := launcher.getInstanceAddress(instance, healthCheck.TCPSocket.Port)
+		if err != nil {
+			return err
+		}
+
+		var dialer net.Dialer
+
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		conn.Close()
+
+		return nil
+
+	case healthCheck.HTTPGet != nil:
+		address, err := launcher.getInstanceAddress(instance, healthCheck.HTTPGet.Port)
+		if err != nil {
+			return err
+		}
+
+		requestURL := url.URL{Scheme: "http", Host: address, Path: healthCheck.HTTPGet.Path}
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL.String(), nil)
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		response, err := probeHTTPClient.Do(request)
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusBadRequest {
+			return aoserrors.Errorf("unexpected HTTP status: %s", response.Status)
+		}
+
+		return nil
+
+	default:
+		return aoserrors.New("no health probe specified")
+	}
+}
+
+func (launcher *Launcher) getInstanceAddress(instance *runtimeInstanceInfo, port uint16) (string, error) {
+	if !instance.networkEnabled() {
+		return "", aoserrors.New("instance network is not available")
+	}
+
+	ip, err := launcher.networkManager.GetInstanceIP(instance.InstanceID, instance.service.ServiceProvider)
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	return net.JoinHostPort(ip, strconv.FormatUint(uint64(port), 10)), nil
+}
+
+// restartRuntimeInstance restarts instance together with its sidecars.
+func (launcher *Launcher) restartRuntimeInstance(instance *runtimeInstanceInfo) runner.InstanceStatus {
+	if err := launcher.stopSidecars(instance); err != nil {
+		log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't stop sidecars: %v", err)
+	}
+
+	if err := launcher.stopRuntimeInstance(instance); err != nil {
+		log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't stop instance: %v", err)
+	}
+
+	runParams, err := launcher.getRunParameters(instance)
+	if err != nil {
+		return runner.InstanceStatus{
+			InstanceID: instance.InstanceID, State: cloudprotocol.InstanceStateFailed, Err: err,
+		}
+	}
+
+	status := instance.runner.StartInstance(instance.InstanceID, instance.runtimeDir, runParams)
+	if status.State != cloudprotocol.InstanceStateActive {
+		return status
+	}
+
+	if err = launcher.startSidecars(instance); err != nil {
+		return runner.InstanceStatus{
+			InstanceID: instance.InstanceID, State: cloudprotocol.InstanceStateFailed, Err: err,
+		}
+	}
+
+	return status
+}
+
+func (launcher *Launcher) sendHealthStatus(ctx context.Context, status runner.InstanceStatus) {
+	select {
+	case launcher.healthStatusChannel <- []runner.InstanceStatus{status}:
+
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aosedge/aos_common/aostypes"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/runner"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Instance event types.
+const (
+	InstanceEventStart    = "start"
+	InstanceEventStop     = "stop"
+	InstanceEventCrash    = "crash"
+	InstanceEventComplete = "complete"
+)
+
+const maxInstanceEvents = 100
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// InstanceEvent instance history event.
+type InstanceEvent struct {
+	aostypes.InstanceIdent
+	InstanceID string
+	Timestamp  time.Time
+	Type       string
+	ExitCode   int
+	Signal     int
+	Message    string
+}
+
+type crashLoopRestart struct {
+	cancelFunction context.CancelFunc
+	doneChannel    chan struct{}
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (launcher *Launcher) addInstanceEvent(instance *runtimeInstanceInfo, eventType string) {
+	event := InstanceEvent{
+		InstanceIdent: instance.InstanceIdent,
+		InstanceID:    instance.InstanceID,
+		Timestamp:     time.Now(),
+		Type:          eventType,
+	}
+
+	if eventType == InstanceEventCrash || eventType == InstanceEventComplete {
+		event.ExitCode = instance.runStatus.ExitCode
+		event.Signal = instance.runStatus.Signal
+
+		if instance.runStatus.Err != nil {
+			event.Message = instance.runStatus.Err.Error()
+		}
+	}
+
+	if err := launcher.storage.AddInstanceEvent(event, maxInstanceEvents); err != nil {
+		log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't add instance event: %v", err)
+	}
+}
+
+func (launcher *Launcher) startCrashLoopRestart(instance *runtimeInstanceInfo) {
+	backoff := launcher.config.CrashLoopBackoff
+
+	// Instance was working long enough, consider it as new crash loop
+	if time.Since(instance.activeTime) > backoff.MaxDelay.Duration {
+		instance.restartCount = 0
+	}
+
+	if backoff.InitialDelay.Duration == 0 || instance.service == nil || instance.stopping ||
+		instance.service.isJob() || instance.crashLoopRestart != nil || launcher.rollbackRequested ||
+		errors.Is(instance.runStatus.Err, errHealthCheckFailed) {
+		return
+	}
+
+	ctx, cancelFunction := context.WithCancel(context.Background())
+
+	instance.crashLoopRestart = &crashLoopRestart{cancelFunction: cancelFunction, doneChannel: make(chan struct{})}
+
+	go launcher.runCrashLoopRestart(ctx, instance, instance.crashLoopRestart.doneChannel)
+}
+
+func (launcher *Launcher) stopCrashLoopRestart(instance *runtimeInstanceInfo) {
+	launcher.runMutex.Lock()
+	crashLoopRestart := instance.crashLoopRestart
+	launcher.runMutex.Unlock()
+
+	if crashLoopRestart == nil {
+		return
+	}
+
+	crashLoopRestart.cancelFunction()
+	<-crashLoopRestart.doneChannel
+}
+
+func (launcher *Launcher) runCrashLoopRestart(
+	ctx context.Context, instance *runtimeInstanceInfo, doneChannel chan<- struct{},
+) {
+	defer close(doneChannel)
+
+	for {
+		launcher.runMutex.Lock()
+		restartCount := instance.restartCount
+		launcher.runMutex.Unlock()
+
+		delay := launcher.getCrashLoopDelay(restartCount)
+
+		log.WithFields(instanceLogFields(instance, log.Fields{
+			"restartCount": restartCount, "delay": delay,
+		})).Warn("Restart failed instance with backoff")
+
+		select {
+		case <-time.After(delay):
+
+		case <-ctx.Done():
+			return
+		}
+
+		// Instance is restarted by runner itself
+		if launcher.isInstanceActive(instance) {
+			launcher.finishCrashLoopRestart(instance, nil)
+
+			return
+		}
+
+		launcher.runMutex.Lock()
+		instance.restartCount++
+		launcher.runMutex.Unlock()
+
+		status := launcher.restartRuntimeInstance(instance)
+
+		if status.State == cloudprotocol.InstanceStateActive {
+			launcher.finishCrashLoopRestart(instance, &status)
+
+			return
+		}
+
+		log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't restart failed instance: %v", status.Err)
+	}
+}
+
+func (launcher *Launcher) finishCrashLoopRestart(instance *runtimeInstanceInfo, status *runner.InstanceStatus) {
+	launcher.runMutex.Lock()
+	defer launcher.runMutex.Unlock()
+
+	instance.crashLoopRestart = nil
+
+	if status == nil || launcher.currentInstances[instance.InstanceID] != instance {
+		return
+	}
+
+	if launcher.updateInstanceStatus(instance, *status) && !launcher.runInstancesInProgress {
+		launcher.runtimeStatusChannel <- RuntimeStatus{
+			UpdateStatus: &InstancesStatus{Instances: []cloudprotocol.InstanceStatus{instance.getCloudStatus()}},
+		}
+	}
+}
+
+func (launcher *Launcher) getCrashLoopDelay(restartCount uint) time.Duration {
+	delay := launcher.config.CrashLoopBackoff.InitialDelay.Duration
+	maxDelay := launcher.config.CrashLoopBackoff.MaxDelay.Duration
+
+	for i := uint(0); i < restartCount && delay < maxDelay; i++ {
+		delay *= 2
+	}
+
+	if maxDelay != 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+
+	return delay
+}
@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/aostypes"
+	log "github.com/sirupsen/logrus"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	initStepsDir            = "init"
+	initContainerIDTemplate = "%s-init%d"
+	defaultInitStepTimeout  = 5 * time.Minute
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// InitStep command which runs to completion before instance main process is started. The command runs in the
+// instance rootfs with the instance network, storage and state. Step is canceled on timeout, if step timeout is not
+// set, init step timeout from config is used.
+type InitStep struct {
+	Name    string            `json:"name,omitempty"`
+	Cmd     []string          `json:"cmd"`
+	Timeout aostypes.Duration `json:"timeout,omitempty"`
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// runInitSteps runs service init steps one by one. Each step runs as separate container created from the instance
+// runtime spec with replaced command. Instance fails if any step fails or exits with non zero code.
+func (launcher *Launcher) runInitSteps(instance *runtimeInstanceInfo, spec *runtimeSpec) error {
+	for i, step := range instance.service.serviceConfig.InitSteps {
+		name := step.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+
+		log.WithFields(instanceLogFields(instance, log.Fields{"step": name})).Debug("Run init step")
+
+		exitCode, err := launcher.runInitStep(instance, spec, i, step)
+		if err != nil {
+			return aoserrors.Errorf("can't run init step %s: %v", name, err)
+		}
+
+		if exitCode != 0 {
+			launcher.runMutex.Lock()
+			instance.runStatus.ExitCode = exitCode
+			launcher.runMutex.Unlock()
+
+			return aoserrors.Errorf("init step %s exited with code %d", name, exitCode)
+		}
+	}
+
+	return nil
+}
+
+func (launcher *Launcher) runInitStep(
+	instance *runtimeInstanceInfo, spec *runtimeSpec, index int, step InitStep,
+) (exitCode int, err error) {
+	if len(step.Cmd) == 0 {
+		return 0, aoserrors.New("empty command")
+	}
+
+	containerID := fmt.Sprintf(initContainerIDTemplate, instance.InstanceID, index)
+	bundleDir := filepath.Join(instance.runtimeDir, initStepsDir, strconv.Itoa(index))
+
+	if err = os.MkdirAll(bundleDir, 0o755); err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	process := *spec.ociSpec.Process
+	process.Args = step.Cmd
+
+	stepSpec := &runtimeSpec{ociSpec: spec.ociSpec}
+
+	stepSpec.ociSpec.Process = &process
+
+	if spec.ociSpec.Linux != nil {
+		linux := *spec.ociSpec.Linux
+		linux.CgroupsPath = cgroupsPath + containerID
+
+		stepSpec.ociSpec.Linux = &linux
+	}
+
+	if err = stepSpec.save(filepath.Join(bundleDir, runtimeConfigFile)); err != nil {
+		return 0, err
+	}
+
+	timeout := step.Timeout.Duration
+	if timeout == 0 {
+		timeout = launcher.getInitStepTimeout()
+	}
+
+	// Init step is also canceled on launcher close
+	ctx, cancelFunc := context.WithTimeout(launcher.ctx, timeout)
+	defer cancelFunc()
+
+	if exitCode, err = instance.runner.RunInitContainer(ctx, containerID, bundleDir); err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	return exitCode, nil
+}
+
+func (launcher *Launcher) getInitStepTimeout() time.Duration {
+	if launcher.config.InitStepTimeout.Duration != 0 {
+		return launcher.config.InitStepTimeout.Duration
+	}
+
+	return defaultInitStepTimeout
+}
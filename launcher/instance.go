@@ -18,7 +18,9 @@
 package launcher
 
 import (
+	"fmt"
 	"path/filepath"
+	"time"
 
 	"github.com/aosedge/aos_common/api/cloudprotocol"
 	log "github.com/sirupsen/logrus"
@@ -37,6 +39,35 @@ type runtimeInstanceInfo struct {
 	runtimeDir      string
 	secret          string
 	overrideEnvVars []string
+	// instance replaced by this one during rolling update
+	prevInstance *runtimeInstanceInfo
+	// devices shared with previous instance till rolling update is completed
+	pendingDevices []string
+	// registration and devices are taken over by new instance
+	replaced bool
+	// start time is used to detect failures within rollback window
+	startTime      time.Time
+	startFailed    bool
+	knownGoodTimer *time.Timer
+	healthChecker  *healthChecker
+	// last time instance became active, used to reset crash loop backoff
+	activeTime       time.Time
+	restartCount     uint
+	crashLoopRestart *crashLoopRestart
+	stopping         bool
+	quotas           []*instanceQuota
+	// instance is checkpointed on stop to be restored on next start
+	checkpoint bool
+	// instance passed health check, used to start dependent instances
+	healthy bool
+	// instance runtime is set up, scheduled instance is launched only for its runs
+	launched  bool
+	scheduler *instanceScheduler
+	// closed when current scheduled run is finished
+	runDone chan struct{}
+	// runner which started the instance, it controls the instance even if service version changes runner
+	runnerName string
+	runner     InstanceRunner
 }
 
 /***********************************************************************************************************************
@@ -55,6 +86,12 @@ func (instance *runtimeInstanceInfo) getCloudStatus() cloudprotocol.InstanceStat
 
 	if instance.service != nil {
 		status.AosVersion = instance.service.AosVersion
+
+		// Active rolled back instance is reported with dedicated state, the rollback reason is reported as error info
+		if instance.service.rollback != nil && status.RunState == cloudprotocol.InstanceStateActive {
+			status.RunState = InstanceStateRolledBack
+			status.ErrorInfo = &cloudprotocol.ErrorInfo{Message: instance.service.rollback.message()}
+		}
 	}
 
 	if status.RunState == cloudprotocol.InstanceStateFailed {
@@ -63,11 +100,32 @@ func (instance *runtimeInstanceInfo) getCloudStatus() cloudprotocol.InstanceStat
 		if instance.runStatus.Err != nil {
 			status.ErrorInfo.Message = instance.runStatus.Err.Error()
 		}
+
+		// Instance failed again after crash loop restart
+		if instance.restartCount > 0 {
+			status.ErrorInfo.Message = fmt.Sprintf("%s (restart count: %d)", status.ErrorInfo.Message,
+				instance.restartCount)
+		}
+	}
+
+	if status.RunState == runner.InstanceStateCompleted && instance.runStatus.ExitCode != 0 {
+		status.ErrorInfo = &cloudprotocol.ErrorInfo{ExitCode: instance.runStatus.ExitCode}
 	}
 
 	return status
 }
 
+// networkEnabled returns false for runners which instances don't use node network, i.e. runx.
+func (instance *runtimeInstanceInfo) networkEnabled() bool {
+	return instance.runnerName != runxRunner
+}
+
+// needsRestart returns false if instance is active, is completed job or is scheduled to run.
+func (instance *runtimeInstanceInfo) needsRestart() bool {
+	return instance.runStatus.State != cloudprotocol.InstanceStateActive &&
+		instance.runStatus.State != runner.InstanceStateCompleted && instance.scheduler == nil
+}
+
 func (instance *runtimeInstanceInfo) setRunStatus(runStatus runner.InstanceStatus) {
 	instance.runStatus = runStatus
 
@@ -77,6 +135,14 @@ func (instance *runtimeInstanceInfo) setRunStatus(runStatus runner.InstanceStatu
 		return
 	}
 
+	if runStatus.State == runner.InstanceStateCompleted {
+		log.WithFields(instanceLogFields(instance, log.Fields{
+			"exitCode": runStatus.ExitCode,
+		})).Info("Instance completed")
+
+		return
+	}
+
 	log.WithFields(instanceLogFields(instance, nil)).Info("Instance successfully started")
 }
 
@@ -39,7 +39,6 @@ import (
 	"github.com/google/uuid"
 	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/exp/slices"
 	"golang.org/x/sys/unix"
 
 	"github.com/aosedge/aos_servicemanager/config"
@@ -62,6 +61,7 @@ const (
 	instanceMountPointsDir = "mounts"
 	instanceStateFile      = "/state.dat"
 	instanceStorageDir     = "/storage"
+	instanceRunnerFile     = "runner"
 	runxRunner             = "runx"
 )
 
@@ -79,24 +79,35 @@ type Storage interface {
 	SetOverrideEnvVars(envVarsInfo []cloudprotocol.EnvVarsInstanceInfo) error
 	GetOnlineTime() (time.Time, error)
 	SetOnlineTime(t time.Time) error
+	GetKnownGoodServiceVersion(serviceID string) (uint64, error)
+	SetKnownGoodServiceVersion(serviceID string, aosVersion uint64) error
+	AddInstanceEvent(event InstanceEvent, maxEvents int) error
+	AddScheduledRun(run ScheduledRun, maxRuns int) error
+	GetLastScheduledRun(instanceIdent aostypes.InstanceIdent) (ScheduledRun, error)
 }
 
 // ServiceProvider service provider.
 type ServiceProvider interface {
 	GetServiceInfo(serviceID string) (servicemanager.ServiceInfo, error)
+	GetServiceVersionInfo(serviceID string, aosVersion uint64) (servicemanager.ServiceInfo, error)
 	GetImageParts(service servicemanager.ServiceInfo) (servicemanager.ImageParts, error)
 	ValidateService(service servicemanager.ServiceInfo) error
+	UseService(serviceID string, aosVersion uint64) error
 }
 
 // LayerProvider layer provider.
 type LayerProvider interface {
 	GetLayerInfoByDigest(digest string) (layermanager.LayerInfo, error)
+	UseLayer(digest string) error
 }
 
 // InstanceRunner interface to start/stop service instances.
 type InstanceRunner interface {
 	StartInstance(instanceID, runtimeDir string, params runner.RunParameters) runner.InstanceStatus
 	StopInstance(instanceID string) error
+	ExecInstance(ctx context.Context, instanceID string, cmd []string) error
+	CheckpointInstance(instanceID, imagePath string) error
+	RunInitContainer(ctx context.Context, containerID, bundleDir string) (exitCode int, err error)
 	InstanceStatusChannel() <-chan []runner.InstanceStatus
 }
 
@@ -113,7 +124,9 @@ type ResourceManager interface {
 // NetworkManager provides network access.
 type NetworkManager interface {
 	GetNetnsPath(instanceID string) string
+	GetInstanceIP(instanceID, networkID string) (string, error)
 	AddInstanceToNetwork(instanceID, networkID string, params networkmanager.NetworkParams) error
+	UpdateInstanceNetwork(instanceID, networkID string, params networkmanager.NetworkParams) error
 	RemoveInstanceFromNetwork(instanceID, networkID string) error
 }
 
@@ -160,7 +173,7 @@ type Launcher struct {
 	storage           Storage
 	serviceProvider   ServiceProvider
 	layerProvider     LayerProvider
-	instanceRunner    InstanceRunner
+	instanceRunners   map[string]InstanceRunner
 	resourceManager   ResourceManager
 	networkManager    NetworkManager
 	instanceRegistrar InstanceRegistrar
@@ -168,16 +181,27 @@ type Launcher struct {
 	alertSender       AlertSender
 
 	config                 *config.Config
+	ctx                    context.Context //nolint:containedctx // cancels dependency waits on close
 	runtimeStatusChannel   chan RuntimeStatus
+	healthStatusChannel    chan []runner.InstanceStatus
+	runnerStatusChannel    chan []runner.InstanceStatus
 	cancelFunction         context.CancelFunc
+	handlersWaitGroup      sync.WaitGroup
 	actionHandler          *action.Handler
 	runMutex               sync.Mutex
 	runInstancesInProgress bool
+	runInstancesCond       *sync.Cond
+	dependencyChannel      chan struct{}
 	currentInstances       map[string]*runtimeInstanceInfo
 	currentServices        map[string]*serviceInfo
 	currentEnvVars         []cloudprotocol.EnvVarsInstanceInfo
 	onlineTime             time.Time
 	isCloudOnline          bool
+	rollbacks              map[string]*serviceRollback
+	rollbackRequested      bool
+	rollbackChannel        chan struct{}
+	quotaMutex             sync.Mutex
+	diskQuotas             map[string]*instanceQuota
 }
 
 /***********************************************************************************************************************
@@ -207,6 +231,8 @@ var (
 	RuntimeDir = "/run/aos/runtime"
 	// CheckTTLsPeriod specifies period different TTL timers are checked with.
 	CheckTTLsPeriod = 1 * time.Hour
+	// CheckQuotasPeriod specifies period instance disk quotas usage is checked with.
+	CheckQuotasPeriod = 10 * time.Second
 )
 
 var defaultHostFSBinds = []string{"bin", "sbin", "lib", "lib64", "usr"} //nolint:gochecknoglobals // const
@@ -215,30 +241,51 @@ var defaultHostFSBinds = []string{"bin", "sbin", "lib", "lib64", "usr"} //nolint
  * Public
  **********************************************************************************************************************/
 
-// New creates new launcher object.
+// New creates new launcher object. Instance runners are keyed by runner name used in service config.
 func New(config *config.Config, storage Storage, serviceProvider ServiceProvider, layerProvider LayerProvider,
-	instanceRunner InstanceRunner, resourceManager ResourceManager, networkManager NetworkManager,
+	instanceRunners map[string]InstanceRunner, resourceManager ResourceManager, networkManager NetworkManager,
 	instanceRegistrar InstanceRegistrar, instanceMonitor InstanceMonitor, alertSender AlertSender,
 ) (launcher *Launcher, err error) {
 	log.Debug("New launcher")
 
 	launcher = &Launcher{
 		storage: storage, serviceProvider: serviceProvider, layerProvider: layerProvider,
-		instanceRunner: instanceRunner, resourceManager: resourceManager, networkManager: networkManager,
+		instanceRunners: instanceRunners, resourceManager: resourceManager, networkManager: networkManager,
 		instanceRegistrar: instanceRegistrar, instanceMonitor: instanceMonitor, alertSender: alertSender,
 
 		config:               config,
 		actionHandler:        action.New(maxParallelInstanceActions),
 		runtimeStatusChannel: make(chan RuntimeStatus, 1),
+		healthStatusChannel:  make(chan []runner.InstanceStatus, 1),
+		runnerStatusChannel:  make(chan []runner.InstanceStatus, 1),
 		currentInstances:     make(map[string]*runtimeInstanceInfo),
+		rollbacks:            make(map[string]*serviceRollback),
+		rollbackChannel:      make(chan struct{}, 1),
+		dependencyChannel:    make(chan struct{}, 1),
+		diskQuotas:           make(map[string]*instanceQuota),
 	}
 
+	launcher.runInstancesCond = sync.NewCond(&launcher.Mutex)
+
 	ctx, cancelFunction := context.WithCancel(context.Background())
 
+	launcher.ctx = ctx
 	launcher.cancelFunction = cancelFunction
 
+	launcher.handleRunnerStatuses(ctx)
+
+	launcher.handlersWaitGroup.Add(1)
+
 	go launcher.handleChannels(ctx)
 
+	launcher.handlersWaitGroup.Add(1)
+
+	go launcher.handleTTLs(ctx)
+
+	launcher.handlersWaitGroup.Add(1)
+
+	go launcher.handleRollbacks(ctx)
+
 	if err = launcher.prepareHostFSDir(); err != nil {
 		return nil, err
 	}
@@ -256,21 +303,31 @@ func New(config *config.Config, storage Storage, serviceProvider ServiceProvider
 	}
 
 	// Restart previously started instances
+	launcher.Lock()
+
 	if err = launcher.restartStoredInstances(); err != nil {
 		log.Errorf("Restart instances error: %v", err)
 	}
 
+	launcher.Unlock()
+
 	return launcher, nil
 }
 
 // Close closes launcher.
 func (launcher *Launcher) Close() (err error) {
+	log.Debug("Close launcher")
+
+	// Handlers take launcher lock, so they should be stopped before it is taken
+	launcher.cancelFunction()
+	launcher.handlersWaitGroup.Wait()
+
 	launcher.Lock()
 	defer launcher.Unlock()
 
-	log.Debug("Close launcher")
+	launcher.waitRunInstancesDone()
 
-	launcher.cancelFunction()
+	launcher.prepareCheckpoints()
 	launcher.stopCurrentInstances()
 
 	if removeErr := os.RemoveAll(RuntimeDir); removeErr != nil && err == nil {
@@ -285,6 +342,8 @@ func (launcher *Launcher) RunInstances(instances []aostypes.InstanceInfo, forceR
 	launcher.Lock()
 	defer launcher.Unlock()
 
+	launcher.waitRunInstancesDone()
+
 	if forceRestart {
 		log.Debug("Restart instances")
 
@@ -303,6 +362,8 @@ func (launcher *Launcher) OverrideEnvVars(
 	launcher.Lock()
 	defer launcher.Unlock()
 
+	launcher.waitRunInstancesDone()
+
 	envVarsStatus := launcher.setEnvVars(envVarsInfo)
 
 	launcher.updateInstancesEnvVars()
@@ -342,13 +403,44 @@ func (launcher *Launcher) CloudConnection(connected bool) error {
  **********************************************************************************************************************/
 
 func (launcher *Launcher) handleChannels(ctx context.Context) {
+	defer launcher.handlersWaitGroup.Done()
+
+	quotaTicker := time.NewTicker(CheckQuotasPeriod)
+	defer quotaTicker.Stop()
+
 	for {
 		select {
-		case instances := <-launcher.instanceRunner.InstanceStatusChannel():
-			launcher.updateInstancesStatuses(instances)
+		case instances := <-launcher.runnerStatusChannel:
+			if launcher.updateInstancesStatuses(instances) {
+				launcher.requestRollback()
+			}
 
-		case <-time.After(CheckTTLsPeriod):
+		case instances := <-launcher.healthStatusChannel:
+			if launcher.updateInstancesStatuses(instances) {
+				launcher.requestRollback()
+			}
+
+		case <-quotaTicker.C:
+			launcher.checkInstanceQuotas()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleTTLs checks TTLs out of channels handler to keep runner statuses handled while instances are restarted.
+func (launcher *Launcher) handleTTLs(ctx context.Context) {
+	defer launcher.handlersWaitGroup.Done()
+
+	ttlTicker := time.NewTicker(CheckTTLsPeriod)
+	defer ttlTicker.Stop()
+
+	for {
+		select {
+		case <-ttlTicker.C:
 			launcher.Lock()
+			launcher.waitRunInstancesDone()
 			launcher.updateInstancesEnvVars()
 			launcher.updateOfflineTimeouts()
 			launcher.Unlock()
@@ -359,7 +451,7 @@ func (launcher *Launcher) handleChannels(ctx context.Context) {
 	}
 }
 
-func (launcher *Launcher) updateInstancesStatuses(instances []runner.InstanceStatus) {
+func (launcher *Launcher) updateInstancesStatuses(instances []runner.InstanceStatus) (rollbackRequested bool) {
 	launcher.runMutex.Lock()
 	defer launcher.runMutex.Unlock()
 
@@ -368,23 +460,62 @@ func (launcher *Launcher) updateInstancesStatuses(instances []runner.InstanceSta
 	for _, instanceStatus := range instances {
 		currentInstance, ok := launcher.currentInstances[instanceStatus.InstanceID]
 		if !ok {
-			log.WithField("instanceID", instanceStatus.InstanceID).Warn("Not running instance status received")
-			continue
-		}
-
-		if currentInstance.runStatus.State != instanceStatus.State {
-			currentInstance.setRunStatus(instanceStatus)
+			sidecarInstance, isSidecar := launcher.sidecarStatusChanged(instanceStatus)
+			if !isSidecar {
+				log.WithField("instanceID", instanceStatus.InstanceID).Warn("Not running instance status received")
+				continue
+			}
 
-			if !launcher.runInstancesInProgress {
+			if sidecarInstance != nil && !launcher.runInstancesInProgress {
 				updateInstancesStatus.Instances = append(updateInstancesStatus.Instances,
-					currentInstance.getCloudStatus())
+					sidecarInstance.getCloudStatus())
 			}
+
+			continue
+		}
+
+		if launcher.updateInstanceStatus(currentInstance, instanceStatus) && !launcher.runInstancesInProgress {
+			updateInstancesStatus.Instances = append(updateInstancesStatus.Instances,
+				currentInstance.getCloudStatus())
 		}
 	}
 
 	if len(updateInstancesStatus.Instances) > 0 {
 		launcher.runtimeStatusChannel <- RuntimeStatus{UpdateStatus: updateInstancesStatus}
 	}
+
+	return launcher.rollbackRequested
+}
+
+func (launcher *Launcher) updateInstanceStatus(instance *runtimeInstanceInfo, status runner.InstanceStatus) bool {
+	if instance.runStatus.State == status.State {
+		return false
+	}
+
+	instance.setRunStatus(status)
+	launcher.instanceStateChanged(instance)
+	launcher.notifyDependencies()
+
+	return true
+}
+
+func (launcher *Launcher) instanceStateChanged(instance *runtimeInstanceInfo) {
+	switch instance.runStatus.State {
+	case cloudprotocol.InstanceStateActive:
+		instance.activeTime = time.Now()
+
+		launcher.addInstanceEvent(instance, InstanceEventStart)
+
+	case cloudprotocol.InstanceStateFailed:
+		launcher.addInstanceEvent(instance, InstanceEventCrash)
+		launcher.checkServiceRollback(instance)
+		launcher.startCrashLoopRestart(instance)
+		launcher.scheduledRunDone(instance)
+
+	case runner.InstanceStateCompleted:
+		launcher.addInstanceEvent(instance, InstanceEventComplete)
+		launcher.scheduledRunDone(instance)
+	}
 }
 
 func (launcher *Launcher) runInstances(runInstances []InstanceInfo) error {
@@ -400,20 +531,30 @@ func (launcher *Launcher) runInstances(runInstances []InstanceInfo) error {
 		defer launcher.runMutex.Unlock()
 
 		launcher.runInstancesInProgress = false
+		launcher.runInstancesCond.Broadcast()
 		launcher.sendRunInstancesStatuses()
 	}()
 
 	launcher.runInstancesInProgress = true
 	launcher.runMutex.Unlock()
 
+	launcher.updateInstances(runInstances)
+
+	// Services failed during start are rolled back to known good version
+	if launcher.takeRollbackRequest() {
+		launcher.updateInstances(runInstances)
+	}
+
+	return nil
+}
+
+func (launcher *Launcher) updateInstances(runInstances []InstanceInfo) {
 	launcher.cacheCurrentServices(runInstances)
 
 	stopInstances, startInstances := launcher.calculateInstances(runInstances)
 
 	launcher.stopInstances(stopInstances)
 	launcher.startInstances(startInstances)
-
-	return nil
 }
 
 func (launcher *Launcher) calculateInstances(
@@ -440,6 +581,8 @@ func (launcher *Launcher) calculateInstances(
 
 runInstancesLoop:
 	for _, runInstance := range runInstances {
+		var prevInstance *runtimeInstanceInfo
+
 		for i, currentInstance := range currentInstances {
 			if currentInstance.InstanceIdent != runInstance.InstanceIdent {
 				continue
@@ -448,14 +591,19 @@ runInstancesLoop:
 			if currentInstance.service != nil &&
 				currentInstance.service.AosVersion == launcher.currentServices[currentInstance.ServiceID].AosVersion &&
 				instanceInfoEqual(currentInstance.InstanceInfo.InstanceInfo, runInstance.InstanceInfo) &&
-				currentInstance.runStatus.State == cloudprotocol.InstanceStateActive &&
+				!currentInstance.needsRestart() &&
 				currentInstance.Priority >= maxStartPriority && !maxPriorityIncreased {
 				currentInstances = append(currentInstances[:i], currentInstances[i+1:]...)
 
 				continue runInstancesLoop
 			}
 
-			stopInstances = append(stopInstances, currentInstance)
+			if launcher.isRollingUpdate(currentInstance, runInstance) {
+				prevInstance = currentInstance
+			} else {
+				stopInstances = append(stopInstances, currentInstance)
+			}
+
 			currentInstances = append(currentInstances[:i], currentInstances[i+1:]...)
 
 			break
@@ -465,6 +613,12 @@ runInstancesLoop:
 			maxStartPriority = runInstance.Priority
 		}
 
+		if prevInstance != nil {
+			startInstances = append(startInstances, newRollingUpdateInstance(runInstance, prevInstance))
+
+			continue
+		}
+
 		startInstances = append(startInstances, newRuntimeInstanceInfo(runInstance))
 	}
 
@@ -473,14 +627,6 @@ runInstancesLoop:
 	return stopInstances, startInstances
 }
 
-func (launcher *Launcher) stopInstances(instances []*runtimeInstanceInfo) {
-	for _, instance := range instances {
-		launcher.doStopAction(instance)
-	}
-
-	launcher.actionHandler.Wait()
-}
-
 func (launcher *Launcher) doStopAction(instance *runtimeInstanceInfo) {
 	launcher.actionHandler.Execute(instance.InstanceID, func(instanceID string) (err error) {
 		defer func() {
@@ -502,14 +648,15 @@ func (launcher *Launcher) doStopAction(instance *runtimeInstanceInfo) {
 }
 
 func (launcher *Launcher) releaseRuntime(instance *runtimeInstanceInfo) (err error) {
-	if instance.service.serviceConfig.Permissions != nil {
+	// Registration is shared between instances during rolling update
+	if instance.service.serviceConfig.Permissions != nil && instance.prevInstance == nil && !instance.replaced {
 		if registerErr := launcher.instanceRegistrar.UnregisterInstance(
 			instance.InstanceIdent); registerErr != nil && err == nil {
 			err = aoserrors.Wrap(registerErr)
 		}
 	}
 
-	if !slices.Contains(launcher.config.RunnerFeatures, runxRunner) {
+	if instance.networkEnabled() {
 		if networkErr := launcher.networkManager.RemoveInstanceFromNetwork(
 			instance.InstanceID, instance.service.ServiceProvider); networkErr != nil && err == nil {
 			err = aoserrors.Wrap(networkErr)
@@ -520,6 +667,10 @@ func (launcher *Launcher) releaseRuntime(instance *runtimeInstanceInfo) (err err
 		err = aoserrors.Wrap(deviceErr)
 	}
 
+	if quotaErr := launcher.releaseInstanceQuotas(instance); quotaErr != nil && err == nil {
+		err = quotaErr
+	}
+
 	return err
 }
 
@@ -534,29 +685,56 @@ func (launcher *Launcher) stopInstance(instance *runtimeInstanceInfo) (err error
 			return aoserrors.New("instance already stopped")
 		}
 
+		instance.stopping = true
+
 		return nil
 	}(); err != nil {
 		return err
 	}
 
+	launcher.stopHealthCheck(instance)
+	launcher.stopCrashLoopRestart(instance)
+	launcher.stopSchedule(instance)
+
 	defer func() {
 		launcher.runMutex.Lock()
 		defer launcher.runMutex.Unlock()
 
 		delete(launcher.currentInstances, instance.InstanceID)
+		instance.stopKnownGoodTimer()
 	}()
 
-	if instance.service == nil {
+	// Scheduled instance runtime is set up only while the instance runs
+	if instance.service == nil || !instance.launched {
 		return nil
 	}
 
+	return launcher.shutdownInstance(instance)
+}
+
+// shutdownInstance stops instance runtime and releases its resources.
+func (launcher *Launcher) shutdownInstance(instance *runtimeInstanceInfo) (err error) {
+	defer func() {
+		instance.launched = false
+	}()
+
+	launcher.addInstanceEvent(instance, InstanceEventStop)
+
 	if monitorErr := launcher.instanceMonitor.StopInstanceMonitor(
 		instance.InstanceID); monitorErr != nil && err == nil {
 		err = aoserrors.Wrap(monitorErr)
 	}
 
-	if runnerErr := launcher.instanceRunner.StopInstance(instance.InstanceID); runnerErr != nil && err == nil {
-		err = aoserrors.Wrap(runnerErr)
+	if instance.checkpoint {
+		launcher.checkpointInstance(instance)
+	}
+
+	if sidecarErr := launcher.stopSidecars(instance); sidecarErr != nil && err == nil {
+		err = sidecarErr
+	}
+
+	if runnerErr := launcher.stopRuntimeInstance(instance); runnerErr != nil && err == nil {
+		err = runnerErr
 	}
 
 	if releaseErr := launcher.releaseRuntime(instance); releaseErr != nil && err == nil {
@@ -580,30 +758,6 @@ func (launcher *Launcher) stopInstance(instance *runtimeInstanceInfo) (err error
 	return err
 }
 
-func (launcher *Launcher) startInstances(instances []*runtimeInstanceInfo) {
-	var currentPriority uint64
-
-	if len(instances) > 0 {
-		currentPriority = instances[0].Priority
-
-		log.WithField("priority", currentPriority).Debug("Start instances with priority")
-	}
-
-	for _, instance := range instances {
-		if currentPriority != instance.Priority {
-			launcher.actionHandler.Wait()
-
-			currentPriority = instance.Priority
-
-			log.WithField("priority", currentPriority).Debug("Start instances with priority")
-		}
-
-		launcher.doStartAction(instance)
-	}
-
-	launcher.actionHandler.Wait()
-}
-
 func (launcher *Launcher) doStartAction(instance *runtimeInstanceInfo) {
 	launcher.actionHandler.Execute(instance.InstanceID, func(instanceID string) (err error) {
 		defer func() {
@@ -612,9 +766,14 @@ func (launcher *Launcher) doStartAction(instance *runtimeInstanceInfo) {
 				defer launcher.runMutex.Unlock()
 
 				launcher.instanceFailed(instance, err)
+				launcher.checkServiceRollback(instance)
 			}
 		}()
 
+		if instance.prevInstance != nil {
+			return launcher.rollingUpdateInstance(instance)
+		}
+
 		if err = launcher.startInstance(instance); err != nil {
 			return err
 		}
@@ -643,7 +802,33 @@ func (launcher *Launcher) setupNetwork(instance *runtimeInstanceInfo) (err error
 		return aoserrors.Wrap(err)
 	}
 
-	params := networkmanager.NetworkParams{
+	params, err := launcher.getNetworkParams(instance)
+	if err != nil {
+		return err
+	}
+
+	// Previous instance keeps its IP till rolling update is completed, host names are shared by both instances
+	if instance.prevInstance != nil {
+		params.IP = ""
+		params.ReplacedInstanceID = instance.prevInstance.InstanceID
+	}
+
+	if instance.networkEnabled() {
+		if err := launcher.networkManager.AddInstanceToNetwork(
+			instance.InstanceID, instance.service.ServiceProvider, params); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func (launcher *Launcher) getNetworkParams(
+	instance *runtimeInstanceInfo,
+) (params networkmanager.NetworkParams, err error) {
+	networkFilesDir := filepath.Join(instance.runtimeDir, instanceMountPointsDir)
+
+	params = networkmanager.NetworkParams{
 		InstanceIdent:      instance.InstanceIdent,
 		HostsFilePath:      filepath.Join(networkFilesDir, "etc", "hosts"),
 		ResolvConfFilePath: filepath.Join(networkFilesDir, "etc", "resolv.conf"),
@@ -653,7 +838,7 @@ func (launcher *Launcher) setupNetwork(instance *runtimeInstanceInfo) (err error
 
 	resourceHosts, err := launcher.getHostsFromResources(instance.service.serviceConfig.Resources)
 	if err != nil {
-		return err
+		return params, err
 	}
 
 	params.Hosts = append(params.Hosts, resourceHosts...)
@@ -684,14 +869,13 @@ func (launcher *Launcher) setupNetwork(instance *runtimeInstanceInfo) (err error
 		params.ExposedPorts = append(params.ExposedPorts, key)
 	}
 
-	if !slices.Contains(launcher.config.RunnerFeatures, runxRunner) {
-		if err := launcher.networkManager.AddInstanceToNetwork(
-			instance.InstanceID, instance.service.ServiceProvider, params); err != nil {
-			return aoserrors.Wrap(err)
-		}
+	params.AllowedConnections = make([]string, 0, len(instance.service.serviceConfig.AllowedConnections))
+
+	for key := range instance.service.serviceConfig.AllowedConnections {
+		params.AllowedConnections = append(params.AllowedConnections, key)
 	}
 
-	return nil
+	return params, nil
 }
 
 func (launcher *Launcher) allocateDevices(instance *runtimeInstanceInfo) (err error) {
@@ -704,11 +888,20 @@ func (launcher *Launcher) allocateDevices(instance *runtimeInstanceInfo) (err er
 	}()
 
 	for _, device := range instance.service.serviceConfig.Devices {
-		if err = launcher.resourceManager.AllocateDevice(device.Name, instance.InstanceID); err != nil {
-			launcher.alertSender.SendAlert(deviceAllocateAlert(instance, device.Name, err))
+		allocateErr := launcher.resourceManager.AllocateDevice(device.Name, instance.InstanceID)
+		if allocateErr == nil {
+			continue
+		}
 
-			return aoserrors.Wrap(err)
+		if launcher.isDeviceAllocatedByPrevInstance(instance, device.Name) {
+			instance.pendingDevices = append(instance.pendingDevices, device.Name)
+
+			continue
 		}
+
+		launcher.alertSender.SendAlert(deviceAllocateAlert(instance, device.Name, allocateErr))
+
+		return aoserrors.Wrap(allocateErr)
 	}
 
 	return nil
@@ -719,7 +912,9 @@ func (launcher *Launcher) setupRuntime(instance *runtimeInstanceInfo) error {
 		return err
 	}
 
-	if instance.service.serviceConfig.Permissions != nil {
+	if instance.service.serviceConfig.Permissions != nil && instance.prevInstance != nil {
+		instance.secret = instance.prevInstance.secret
+	} else if instance.service.serviceConfig.Permissions != nil {
 		secret, err := launcher.instanceRegistrar.RegisterInstance(
 			instance.InstanceIdent, instance.service.serviceConfig.Permissions)
 		if err != nil {
@@ -756,16 +951,35 @@ func (launcher *Launcher) startInstance(instance *runtimeInstanceInfo) error {
 
 		instance.service = service
 		instance.runStatus = runner.InstanceStatus{InstanceID: instance.InstanceID}
+		instance.startTime = time.Now()
 
 		return nil
 	}(); err != nil {
 		return err
 	}
 
+	if instance.service.serviceConfig.Schedule != nil {
+		return launcher.startSchedule(instance)
+	}
+
+	return launcher.launchInstance(instance)
+}
+
+// launchInstance sets up instance runtime and starts the instance by runner.
+func (launcher *Launcher) launchInstance(instance *runtimeInstanceInfo) error {
+	instance.launched = true
+	instance.runnerName, instance.runner = instance.service.runnerName, instance.service.runner
+
 	if err := os.MkdirAll(instance.runtimeDir, 0o755); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
+	// Runner is stored to stop the instance by the same runner after launcher restart
+	if err := os.WriteFile(
+		filepath.Join(instance.runtimeDir, instanceRunnerFile), []byte(instance.runnerName), 0o600); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
 	if err := launcher.setupRuntime(instance); err != nil {
 		return err
 	}
@@ -775,16 +989,29 @@ func (launcher *Launcher) startInstance(instance *runtimeInstanceInfo) error {
 		return err
 	}
 
-	if err := launcher.prepareRootFS(instance, runtimeSpec); err != nil {
+	if err := launcher.prepareRootFS(instance.service, instance.runtimeDir, runtimeSpec); err != nil {
 		return err
 	}
 
-	runStatus := launcher.instanceRunner.StartInstance(
-		instance.InstanceID, instance.runtimeDir, runner.RunParameters{
-			StartInterval:   instance.service.serviceConfig.RunParameters.StartInterval.Duration,
-			StartBurst:      instance.service.serviceConfig.RunParameters.StartBurst,
-			RestartInterval: instance.service.serviceConfig.RunParameters.RestartInterval.Duration,
-		})
+	runParams, err := launcher.getRunParameters(instance)
+	if err != nil {
+		return err
+	}
+
+	runParams.RestorePath = launcher.getRestorePath(instance)
+
+	// Restored instance has already passed its init steps
+	if runParams.RestorePath == "" {
+		if err := launcher.runInitSteps(instance, runtimeSpec); err != nil {
+			return err
+		}
+	}
+
+	runStatus := instance.runner.StartInstance(instance.InstanceID, instance.runtimeDir, runParams)
+
+	if runParams.RestorePath != "" {
+		runStatus = launcher.checkRestoreStatus(instance, runParams, runStatus)
+	}
 
 	// Update current status if it is not updated by runner status channel. Instance runner status goes asynchronously
 	// by status channel. And therefore, new status may arrive before returning by StartInstance API. We detect this
@@ -793,10 +1020,23 @@ func (launcher *Launcher) startInstance(instance *runtimeInstanceInfo) error {
 
 	if instance.runStatus.State == "" {
 		instance.setRunStatus(runStatus)
+		launcher.instanceStateChanged(instance)
 	}
 
+	launcher.startKnownGoodTimer(instance)
+
+	active := instance.runStatus.State == cloudprotocol.InstanceStateActive
+
 	launcher.runMutex.Unlock()
 
+	if active {
+		if err := launcher.startSidecars(instance); err != nil {
+			return err
+		}
+	}
+
+	launcher.startHealthCheck(instance)
+
 	monitorParams := resourcemonitor.ResourceMonitorParams{
 		InstanceIdent: instance.InstanceIdent,
 		UID:           int(instance.UID),
@@ -806,14 +1046,14 @@ func (launcher *Launcher) startInstance(instance *runtimeInstanceInfo) error {
 
 	if instance.StoragePath != "" {
 		monitorParams.Partitions = append(monitorParams.Partitions, resourcemonitor.PartitionParam{
-			Name: "storage",
+			Name: storageQuotaParameter,
 			Path: launcher.getAbsStoragePath(instance.StoragePath),
 		})
 	}
 
 	if instance.StatePath != "" {
 		monitorParams.Partitions = append(monitorParams.Partitions, resourcemonitor.PartitionParam{
-			Name: "state",
+			Name: stateQuotaParameter,
 			Path: launcher.getAbsStatePath(instance.StatePath),
 		})
 	}
@@ -949,14 +1189,14 @@ func (launcher *Launcher) getAbsStatePath(path string) string {
 	return filepath.Join(launcher.config.StateDir, path)
 }
 
-func (launcher *Launcher) prepareRootFS(instance *runtimeInstanceInfo, runtimeConfig *runtimeSpec) error {
-	mountPointsDir := filepath.Join(instance.runtimeDir, instanceMountPointsDir)
+func (launcher *Launcher) prepareRootFS(service *serviceInfo, runtimeDir string, runtimeConfig *runtimeSpec) error {
+	mountPointsDir := filepath.Join(runtimeDir, instanceMountPointsDir)
 
 	if err := launcher.createMountPoints(mountPointsDir, runtimeConfig.ociSpec.Mounts); err != nil {
 		return err
 	}
 
-	imageParts, err := launcher.serviceProvider.GetImageParts(instance.service.ServiceInfo)
+	imageParts, err := launcher.serviceProvider.GetImageParts(service.ServiceInfo)
 	if err != nil {
 		return aoserrors.Wrap(err)
 	}
@@ -970,11 +1210,19 @@ func (launcher *Launcher) prepareRootFS(instance *runtimeInstanceInfo, runtimeCo
 		}
 
 		layersDir = append(layersDir, layer.Path)
+
+		if err = launcher.layerProvider.UseLayer(digest); err != nil {
+			log.WithField("digest", digest).Warnf("Can't set layer last use time: %v", err)
+		}
+	}
+
+	if err = launcher.serviceProvider.UseService(service.ServiceID, service.AosVersion); err != nil {
+		log.WithField("serviceID", service.ServiceID).Warnf("Can't set service last use time: %v", err)
 	}
 
 	layersDir = append(layersDir, path.Join(launcher.config.WorkingDir, hostFSWiteoutsDir), "/")
 
-	rootfsDir := filepath.Join(instance.runtimeDir, instanceRootFS)
+	rootfsDir := filepath.Join(runtimeDir, instanceRootFS)
 
 	if err = os.MkdirAll(rootfsDir, 0o755); err != nil {
 		return aoserrors.Wrap(err)
@@ -1085,12 +1333,18 @@ func (launcher *Launcher) restartStoredInstances() error {
 		}
 
 		instance.service = service
+		// Instance may be left running by previous launcher run
+		instance.launched = true
+		instance.runnerName, instance.runner = launcher.getLaunchedRunner(instance)
 	}
 
 	launcher.runMutex.Unlock()
 
 	launcher.stopCurrentInstances()
 
+	// Checkpoints are used only to restore stored instances
+	defer launcher.removeCheckpoints()
+
 	return launcher.runInstances(currentInstances)
 }
 
@@ -20,9 +20,11 @@ package launcher_test
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -30,6 +32,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -42,6 +45,7 @@ import (
 	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/shirou/gopsutil/cpu"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 
 	"github.com/aosedge/aos_servicemanager/config"
 	"github.com/aosedge/aos_servicemanager/launcher"
@@ -77,14 +81,18 @@ var defaultEnvVars = []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/us
 
 type testStorage struct {
 	sync.RWMutex
-	instances  map[string]launcher.InstanceInfo
-	envVars    []cloudprotocol.EnvVarsInstanceInfo
-	onlineTime time.Time
+	instances         map[string]launcher.InstanceInfo
+	envVars           []cloudprotocol.EnvVarsInstanceInfo
+	onlineTime        time.Time
+	knownGoodVersions map[string]uint64
+	instanceEvents    []launcher.InstanceEvent
+	scheduledRuns     []launcher.ScheduledRun
 }
 
 type testServiceProvider struct {
-	services     map[string]servicemanager.ServiceInfo
-	layerDigests map[string][]string
+	services       map[string]servicemanager.ServiceInfo
+	cachedServices map[string][]servicemanager.ServiceInfo
+	layerDigests   map[string][]string
 }
 
 type testLayerProvider struct {
@@ -93,9 +101,13 @@ type testLayerProvider struct {
 
 type testRunner struct {
 	sync.Mutex
-	statusChannel chan []runner.InstanceStatus
-	startFunc     func(instanceID string) runner.InstanceStatus
-	stopFunc      func(instanceID string) error
+	statusChannel  chan []runner.InstanceStatus
+	startFunc      func(instanceID string) runner.InstanceStatus
+	stopFunc       func(instanceID string) error
+	execFunc       func(instanceID string, cmd []string) error
+	checkpointFunc func(instanceID, imagePath string) error
+	initFunc       func(ctx context.Context, containerID, bundleDir string) (int, error)
+	runParams      map[string]runner.RunParameters
 }
 
 type testResourceManager struct {
@@ -125,11 +137,25 @@ type testMounter struct {
 	mounts map[string]mountInfo
 }
 
+type testQuotaProvider struct {
+	sync.Mutex
+	quotas map[string]*testDiskQuota
+}
+
+type testDiskQuota struct {
+	sync.Mutex
+	path      string
+	projectID uint32
+	limit     uint64
+	usage     uint64
+	released  bool
+}
+
 type serviceInfo struct {
 	aostypes.ServiceInfo
 	gid           uint32
 	imageConfig   *imagespec.Image
-	serviceConfig *aostypes.ServiceConfig
+	serviceConfig *launcher.ServiceConfig
 	layerDigests  []string
 }
 
@@ -159,7 +185,9 @@ type testDevice struct {
 }
 
 type testAlertSender struct {
-	alerts []cloudprotocol.DeviceAllocateAlert
+	alerts            []cloudprotocol.DeviceAllocateAlert
+	instanceAlerts    []cloudprotocol.ServiceInstanceAlert
+	quotaAlertChannel chan cloudprotocol.InstanceQuotaAlert
 }
 
 /***********************************************************************************************************************
@@ -167,8 +195,9 @@ type testAlertSender struct {
  **********************************************************************************************************************/
 
 var (
-	tmpDir  string
-	mounter = newTestMounter()
+	tmpDir        string
+	mounter       = newTestMounter()
+	quotaProvider = newTestQuotaProvider()
 )
 
 /***********************************************************************************************************************
@@ -301,8 +330,8 @@ func TestRunInstances(t *testing.T) {
 	)
 
 	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
-		layerProvider, instanceRunner, newTestResourceManager(), newTestNetworkManager(), newTestRegistrar(),
-		newTestInstanceMonitor(), newTestAlertSender())
+		layerProvider, newTestRunners(instanceRunner), newTestResourceManager(), newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
 	if err != nil {
 		t.Fatalf("Can't create launcher: %v", err)
 	}
@@ -352,7 +381,7 @@ func TestUpdateInstances(t *testing.T) {
 	instanceRunner := newTestRunner(nil, nil)
 
 	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider, layerProvider,
-		instanceRunner, newTestResourceManager(), newTestNetworkManager(), newTestRegistrar(),
+		newTestRunners(instanceRunner), newTestResourceManager(), newTestNetworkManager(), newTestRegistrar(),
 		newTestInstanceMonitor(), newTestAlertSender())
 	if err != nil {
 		t.Fatalf("Can't create launcher: %v", err)
@@ -446,8 +475,8 @@ func TestRestartInstances(t *testing.T) {
 	)
 
 	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, newTestStorage(), serviceProvider,
-		layerProvider, instanceRunner, newTestResourceManager(), newTestNetworkManager(), newTestRegistrar(),
-		newTestInstanceMonitor(), newTestAlertSender())
+		layerProvider, newTestRunners(instanceRunner), newTestResourceManager(), newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
 	if err != nil {
 		t.Fatalf("Can't create launcher: %v", err)
 	}
@@ -527,7 +556,7 @@ func TestHostFSDir(t *testing.T) {
 		WorkingDir: tmpDir,
 		HostBinds:  hostFSBinds,
 	},
-		newTestStorage(), newTestServiceProvider(), newTestLayerProvider(), newTestRunner(nil, nil),
+		newTestStorage(), newTestServiceProvider(), newTestLayerProvider(), newTestRunners(newTestRunner(nil, nil)),
 		newTestResourceManager(), newTestNetworkManager(), newTestRegistrar(), newTestInstanceMonitor(),
 		newTestAlertSender())
 	if err != nil {
@@ -607,7 +636,7 @@ func TestRuntimeSpec(t *testing.T) {
 						Env:        []string{"env1=val1", "env2=val2", "env3=val3"},
 					},
 				},
-				serviceConfig: &aostypes.ServiceConfig{
+				serviceConfig: &launcher.ServiceConfig{ServiceConfig: aostypes.ServiceConfig{
 					Hostname: newString("testHostName"),
 					Sysctl:   map[string]string{"key1": "val1", "key2": "val2", "key3": "val3"},
 					Quotas: aostypes.ServiceQuotas{
@@ -624,7 +653,7 @@ func TestRuntimeSpec(t *testing.T) {
 					},
 					Resources:   []string{"resource1", "resource2", "resource3"},
 					Permissions: map[string]map[string]string{"perm1": {"key1": "val1"}},
-				},
+				}},
 			},
 		},
 		instances: []aostypes.InstanceInfo{
@@ -642,7 +671,7 @@ func TestRuntimeSpec(t *testing.T) {
 		StorageDir: filepath.Join(tmpDir, "storages"),
 		StateDir:   filepath.Join(tmpDir, "states"),
 	}, storage, serviceProvider,
-		newTestLayerProvider(), newTestRunner(nil, nil), resourceManager, networkManager, testRegistrar,
+		newTestLayerProvider(), newTestRunners(newTestRunner(nil, nil)), resourceManager, networkManager, testRegistrar,
 		newTestInstanceMonitor(), newTestAlertSender())
 	if err != nil {
 		t.Fatalf("Can't create launcher: %v", err)
@@ -1023,9 +1052,10 @@ func TestRuntimeEnvironment(t *testing.T) {
 						ExposedPorts: map[string]struct{}{"port0": {}, "port1": {}, "port2": {}},
 					},
 				},
-				serviceConfig: &aostypes.ServiceConfig{
-					Hostname:    newString("host1"),
-					Permissions: map[string]map[string]string{"perm1": {"key1": "val1"}},
+				serviceConfig: &launcher.ServiceConfig{ServiceConfig: aostypes.ServiceConfig{
+					Hostname:           newString("host1"),
+					Permissions:        map[string]map[string]string{"perm1": {"key1": "val1"}},
+					AllowedConnections: map[string]struct{}{"service1/8080/tcp": {}, "service2/53/udp": {}},
 					Quotas: aostypes.ServiceQuotas{
 						DownloadSpeed: newUint64(4096),
 						UploadSpeed:   newUint64(8192),
@@ -1063,7 +1093,7 @@ func TestRuntimeEnvironment(t *testing.T) {
 							MinThreshold: 50, MaxThreshold: 500,
 						},
 					},
-				},
+				}},
 			},
 		},
 		layers: []aostypes.LayerInfo{
@@ -1084,7 +1114,8 @@ func TestRuntimeEnvironment(t *testing.T) {
 		StorageDir: filepath.Join(tmpDir, "storages"),
 		StateDir:   filepath.Join(tmpDir, "states"),
 	}, storage, serviceProvider, layerProvider,
-		newTestRunner(nil, nil), resourceManager, networkManager, registrar, instanceMonitor, newTestAlertSender())
+		newTestRunners(newTestRunner(nil, nil)), resourceManager, networkManager, registrar, instanceMonitor,
+		newTestAlertSender())
 	if err != nil {
 		t.Fatalf("Can't create launcher: %v", err)
 	}
@@ -1139,6 +1170,7 @@ func TestRuntimeEnvironment(t *testing.T) {
 		Hostname:           *serviceConfig.Hostname,
 		Hosts:              resourceHosts,
 		ExposedPorts:       convertMapToStringList(imageConfig.Config.ExposedPorts),
+		AllowedConnections: convertMapToStringList(serviceConfig.AllowedConnections),
 		HostsFilePath:      filepath.Join(launcher.RuntimeDir, instance.InstanceID, "mounts", "etc", "hosts"),
 		ResolvConfFilePath: filepath.Join(launcher.RuntimeDir, instance.InstanceID, "mounts", "etc", "resolv.conf"),
 		IngressKbit:        *serviceConfig.Quotas.DownloadSpeed,
@@ -1227,6 +1259,26 @@ func TestRuntimeEnvironment(t *testing.T) {
 		t.Errorf("Wrong lower dirs value: %v", mountInfo.lowerDirs)
 	}
 
+	// Check quotas
+
+	storageQuota, err := quotaProvider.getQuota(filepath.Join(tmpDir, storagesDir, instance.StoragePath))
+	if err != nil {
+		t.Fatalf("Can't get storage quota: %v", err)
+	}
+
+	if storageQuota.limit != *serviceConfig.Quotas.StorageLimit || storageQuota.projectID != instance.UID {
+		t.Errorf("Wrong storage quota: limit %d, project ID %d", storageQuota.limit, storageQuota.projectID)
+	}
+
+	stateQuota, err := quotaProvider.getQuota(filepath.Join(tmpDir, statesDir, instance.StatePath))
+	if err != nil {
+		t.Fatalf("Can't get state quota: %v", err)
+	}
+
+	if stateQuota.limit != *serviceConfig.Quotas.StateLimit || stateQuota.projectID == instance.UID {
+		t.Errorf("Wrong state quota: limit %d, project ID %d", stateQuota.limit, stateQuota.projectID)
+	}
+
 	// Stop instances and check runtime release
 
 	if err = testLauncher.RunInstances(nil, false); err != nil {
@@ -1269,6 +1321,111 @@ func TestRuntimeEnvironment(t *testing.T) {
 	if _, ok := mounter.mounts[filepath.Join(launcher.RuntimeDir, instance.InstanceID, instanceRootFS)]; ok {
 		t.Error("Instance root FS should be unmounted")
 	}
+
+	// Check quotas
+
+	if !storageQuota.isReleased() || !stateQuota.isReleased() {
+		t.Error("Instance quotas should be released")
+	}
+}
+
+func TestInstanceQuotaAlert(t *testing.T) {
+	storage := newTestStorage()
+	serviceProvider := newTestServiceProvider()
+	alertSender := newTestAlertSender()
+
+	testLauncher, err := launcher.New(&config.Config{
+		WorkingDir: tmpDir,
+		StorageDir: filepath.Join(tmpDir, storagesDir),
+		StateDir:   filepath.Join(tmpDir, statesDir),
+	}, storage, serviceProvider, newTestLayerProvider(), newTestRunners(newTestRunner(nil, nil)),
+		newTestResourceManager(), newTestNetworkManager(), newTestRegistrar(), newTestInstanceMonitor(), alertSender)
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if err = serviceProvider.installServices([]serviceInfo{
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "service0", VersionInfo: aostypes.VersionInfo{AosVersion: 1}},
+			serviceConfig: &launcher.ServiceConfig{ServiceConfig: aostypes.ServiceConfig{
+				Quotas: aostypes.ServiceQuotas{StorageLimit: newUint64(1024)},
+			}},
+		},
+	}); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	instanceInfo := aostypes.InstanceInfo{
+		InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0},
+		StoragePath:   "quotaStorage",
+		UID:           5000,
+	}
+
+	if err = testLauncher.RunInstances([]aostypes.InstanceInfo{instanceInfo}, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{InstanceIdent: instanceInfo.InstanceIdent, AosVersion: 1, RunState: cloudprotocol.InstanceStateActive},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	storageQuota, err := quotaProvider.getQuota(filepath.Join(tmpDir, storagesDir, instanceInfo.StoragePath))
+	if err != nil {
+		t.Fatalf("Can't get storage quota: %v", err)
+	}
+
+	storageQuota.setUsage(512)
+
+	select {
+	case alert := <-alertSender.quotaAlertChannel:
+		t.Errorf("Unexpected quota alert: %v", alert)
+
+	case <-time.After(3 * launcher.CheckQuotasPeriod):
+	}
+
+	storageQuota.setUsage(1024)
+
+	select {
+	case alert := <-alertSender.quotaAlertChannel:
+		if alert.InstanceIdent != instanceInfo.InstanceIdent || alert.Parameter != "storage" || alert.Value != 1024 {
+			t.Errorf("Wrong quota alert: %v", alert)
+		}
+
+	case <-time.After(defaultStatusTimeout):
+		t.Error("Quota alert expected")
+	}
+
+	// Alert should be sent once till usage goes below limit
+
+	select {
+	case alert := <-alertSender.quotaAlertChannel:
+		t.Errorf("Unexpected quota alert: %v", alert)
+
+	case <-time.After(3 * launcher.CheckQuotasPeriod):
+	}
+
+	if err = testLauncher.RunInstances(nil, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if !storageQuota.isReleased() {
+		t.Error("Storage quota should be released")
+	}
 }
 
 func TestOverrideEnvVars(t *testing.T) {
@@ -1592,7 +1749,7 @@ func TestOverrideEnvVars(t *testing.T) {
 	storage := newTestStorage()
 
 	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider, layerProvider,
-		newTestRunner(nil, nil), newTestResourceManager(), newTestNetworkManager(), newTestRegistrar(),
+		newTestRunners(newTestRunner(nil, nil)), newTestResourceManager(), newTestNetworkManager(), newTestRegistrar(),
 		newTestInstanceMonitor(), newTestAlertSender())
 	if err != nil {
 		t.Fatalf("Can't create launcher: %v", err)
@@ -1691,8 +1848,8 @@ func TestRestartStoredInstancesOnStart(t *testing.T) {
 	}
 
 	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
-		newTestLayerProvider(), newTestRunner(nil, nil), newTestResourceManager(), newTestNetworkManager(),
-		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+		newTestLayerProvider(), newTestRunners(newTestRunner(nil, nil)), newTestResourceManager(),
+		newTestNetworkManager(), newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
 	if err != nil {
 		t.Fatalf("Can't create launcher: %v", err)
 	}
@@ -2132,8 +2289,8 @@ func TestInstancePriorities(t *testing.T) {
 	)
 
 	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider, layerProvider,
-		instanceRunner, newTestResourceManager(), newTestNetworkManager(), newTestRegistrar(), newTestInstanceMonitor(),
-		newTestAlertSender())
+		newTestRunners(instanceRunner), newTestResourceManager(), newTestNetworkManager(), newTestRegistrar(),
+		newTestInstanceMonitor(), newTestAlertSender())
 	if err != nil {
 		t.Fatalf("Can't create launcher: %v", err)
 	}
@@ -2196,7 +2353,9 @@ func TestResourceAlerts(t *testing.T) {
 		alerts []cloudprotocol.DeviceAllocateAlert
 	}
 
-	serviceConfig := &aostypes.ServiceConfig{Devices: []aostypes.ServiceDevice{{Name: "device0", Permissions: "rw"}}}
+	serviceConfig := &launcher.ServiceConfig{ServiceConfig: aostypes.ServiceConfig{
+		Devices: []aostypes.ServiceDevice{{Name: "device0", Permissions: "rw"}},
+	}}
 
 	data := []testAlertItem{
 		// Try to allocate device3 (shared count 1) by 3 instances. Instance with index 0 should allocate the device as
@@ -2299,7 +2458,7 @@ func TestResourceAlerts(t *testing.T) {
 	resourceManager.addDevice(aostypes.DeviceInfo{Name: "device0", SharedCount: 1})
 
 	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, newTestStorage(), serviceProvider,
-		newTestLayerProvider(), newTestRunner(nil, nil), resourceManager, newTestNetworkManager(),
+		newTestLayerProvider(), newTestRunners(newTestRunner(nil, nil)), resourceManager, newTestNetworkManager(),
 		newTestRegistrar(), newTestInstanceMonitor(), alertSender)
 	if err != nil {
 		t.Fatalf("Can't create launcher: %v", err)
@@ -2350,8 +2509,8 @@ func TestOfflineTimeout(t *testing.T) {
 	storage := newTestStorage()
 
 	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
-		newTestLayerProvider(), newTestRunner(nil, nil), newTestResourceManager(), newTestNetworkManager(),
-		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+		newTestLayerProvider(), newTestRunners(newTestRunner(nil, nil)), newTestResourceManager(),
+		newTestNetworkManager(), newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
 	if err != nil {
 		t.Fatalf("Can't create launcher: %v", err)
 	}
@@ -2368,18 +2527,18 @@ func TestOfflineTimeout(t *testing.T) {
 			},
 			{
 				ServiceInfo: aostypes.ServiceInfo{ID: "service1"},
-				serviceConfig: &aostypes.ServiceConfig{
+				serviceConfig: &launcher.ServiceConfig{ServiceConfig: aostypes.ServiceConfig{
 					OfflineTTL: aostypes.Duration{Duration: 5 * time.Second},
-				},
+				}},
 			},
 			{
 				ServiceInfo: aostypes.ServiceInfo{ID: "service2"},
 			},
 			{
 				ServiceInfo: aostypes.ServiceInfo{ID: "service3"},
-				serviceConfig: &aostypes.ServiceConfig{
+				serviceConfig: &launcher.ServiceConfig{ServiceConfig: aostypes.ServiceConfig{
 					OfflineTTL: aostypes.Duration{Duration: 10 * time.Second},
-				},
+				}},
 			},
 		},
 		instances: []aostypes.InstanceInfo{
@@ -2471,8 +2630,8 @@ func TestOfflineTimeout(t *testing.T) {
 	testLauncher.Close()
 
 	if testLauncher, err = launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
-		newTestLayerProvider(), newTestRunner(nil, nil), newTestResourceManager(), newTestNetworkManager(),
-		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender()); err != nil {
+		newTestLayerProvider(), newTestRunners(newTestRunner(nil, nil)), newTestResourceManager(),
+		newTestNetworkManager(), newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender()); err != nil {
 		t.Fatalf("Can't create launcher: %v", err)
 	}
 	defer testLauncher.Close()
@@ -2499,132 +2658,2292 @@ func TestOfflineTimeout(t *testing.T) {
 	}
 }
 
-/***********************************************************************************************************************
- * testStorage
- **********************************************************************************************************************/
-
-func newTestStorage() *testStorage {
-	return &testStorage{
-		instances: make(map[string]launcher.InstanceInfo),
+func TestRollingUpdate(t *testing.T) {
+	type testRollingItem struct {
+		installVersion uint64
+		failStart      bool
+		runningVersion uint64
+		replaced       bool
 	}
-}
 
-func (storage *testStorage) AddInstance(instance launcher.InstanceInfo) error {
-	storage.Lock()
-	defer storage.Unlock()
-
-	if _, ok := storage.instances[instance.InstanceID]; ok {
-		return aoserrors.New("instance exists")
+	data := []testRollingItem{
+		{installVersion: 1, runningVersion: 1},
+		// New version is started alongside the previous one and replaces it
+		{installVersion: 2, runningVersion: 2, replaced: true},
+		// New version fails to start, previous one keeps running
+		{installVersion: 3, failStart: true, runningVersion: 2},
 	}
 
-	storage.instances[instance.InstanceID] = instance
+	var (
+		failStart        bool
+		stoppedInstances []string
+	)
 
-	return nil
-}
+	storage := newTestStorage()
+	serviceProvider := newTestServiceProvider()
+	resourceManager := newTestResourceManager()
+	networkManager := newTestNetworkManager()
+	registrar := newTestRegistrar()
 
-func (storage *testStorage) UpdateInstance(instance launcher.InstanceInfo) error {
-	storage.Lock()
-	defer storage.Unlock()
+	resourceManager.addDevice(aostypes.DeviceInfo{Name: "device0", SharedCount: 1})
 
-	if _, ok := storage.instances[instance.InstanceID]; !ok {
-		return launcher.ErrNotExist
-	}
+	instanceRunner := newTestRunner(
+		func(instanceID string) runner.InstanceStatus {
+			if failStart {
+				return runner.InstanceStatus{
+					InstanceID: instanceID,
+					State:      cloudprotocol.InstanceStateFailed,
+					Err:        errors.New("start failed"), //nolint:goerr113
+				}
+			}
 
-	storage.instances[instance.InstanceID] = instance
+			return runner.InstanceStatus{InstanceID: instanceID, State: cloudprotocol.InstanceStateActive}
+		},
+		func(instanceID string) error {
+			stoppedInstances = append(stoppedInstances, instanceID)
 
-	return nil
-}
+			return nil
+		},
+	)
 
-func (storage *testStorage) RemoveInstance(instanceID string) error {
-	storage.Lock()
-	defer storage.Unlock()
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		newTestLayerProvider(), newTestRunners(instanceRunner), resourceManager, networkManager, registrar,
+		newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
 
-	if _, ok := storage.instances[instanceID]; !ok {
-		return launcher.ErrNotExist
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
 	}
 
-	delete(storage.instances, instanceID)
+	instanceInfo := aostypes.InstanceInfo{
+		InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0},
+		NetworkParameters: aostypes.NetworkParameters{
+			IP:     "172.17.0.2",
+			Subnet: "172.17.0.0/16",
+		},
+	}
 
-	return nil
-}
+	var prevInstanceID string
 
-func (storage *testStorage) GetAllInstances() (instances []launcher.InstanceInfo, err error) {
-	storage.RLock()
-	defer storage.RUnlock()
+	for i, item := range data {
+		t.Logf("Rolling update: %d", i)
 
-	for _, instance := range storage.instances {
-		instances = append(instances, instance)
-	}
+		failStart = item.failStart
+		stoppedInstances = nil
 
-	return instances, nil
-}
+		if err = serviceProvider.installServices([]serviceInfo{{
+			ServiceInfo: aostypes.ServiceInfo{
+				ID: "service0", VersionInfo: aostypes.VersionInfo{AosVersion: item.installVersion},
+			},
+			serviceConfig: &launcher.ServiceConfig{
+				ServiceConfig: aostypes.ServiceConfig{
+					Devices:     []aostypes.ServiceDevice{{Name: "device0", Permissions: "rw"}},
+					Permissions: map[string]map[string]string{"vis": {"*": "rw"}},
+				},
+				UpdateStrategy: launcher.UpdateStrategyRolling,
+			},
+		}}); err != nil {
+			t.Fatalf("Can't install services: %v", err)
+		}
 
-func (storage *testStorage) GetOverrideEnvVars() ([]cloudprotocol.EnvVarsInstanceInfo, error) {
-	storage.RLock()
-	defer storage.RUnlock()
+		if err = testLauncher.RunInstances([]aostypes.InstanceInfo{instanceInfo}, false); err != nil {
+			t.Fatalf("Can't run instances: %v", err)
+		}
 
-	return storage.envVars, nil
-}
+		if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+			RunStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(testItem{
+				services: []serviceInfo{{ServiceInfo: aostypes.ServiceInfo{
+					ID: "service0", VersionInfo: aostypes.VersionInfo{AosVersion: item.runningVersion},
+				}}},
+				instances: []aostypes.InstanceInfo{instanceInfo},
+			})},
+		}, defaultStatusTimeout); err != nil {
+			t.Errorf("Check runtime status error: %v", err)
+		}
 
-func (storage *testStorage) SetOverrideEnvVars(envVarsInfo []cloudprotocol.EnvVarsInstanceInfo) error {
-	storage.Lock()
-	defer storage.Unlock()
+		storedInstance, err := storage.getInstanceByIdent(instanceInfo.InstanceIdent)
+		if err != nil {
+			t.Fatalf("Can't get stored instance: %v", err)
+		}
 
-	storage.envVars = envVarsInfo
+		if len(storage.instances) != 1 {
+			t.Errorf("Wrong stored instances count: %d", len(storage.instances))
+		}
 
-	return nil
-}
+		if item.replaced {
+			if storedInstance.InstanceID == prevInstanceID {
+				t.Error("Instance should be replaced")
+			}
 
-func (storage *testStorage) GetOnlineTime() (time.Time, error) {
-	storage.Lock()
-	defer storage.Unlock()
+			if !reflect.DeepEqual(stoppedInstances, []string{prevInstanceID}) {
+				t.Errorf("Wrong stopped instances: %v", stoppedInstances)
+			}
+		} else if prevInstanceID != "" && storedInstance.InstanceID != prevInstanceID {
+			t.Error("Instance should not be replaced")
+		}
 
-	return storage.onlineTime, nil
-}
+		networkParams, ok := networkManager.instances[storedInstance.InstanceID]
+		if len(networkManager.instances) != 1 || !ok ||
+			networkParams.InstanceIdent != instanceInfo.InstanceIdent || networkParams.ReplacedInstanceID != "" {
+			t.Errorf("Wrong network instances: %v", networkManager.instances)
+		}
 
-func (storage *testStorage) SetOnlineTime(onlineTime time.Time) error {
-	storage.Lock()
-	defer storage.Unlock()
+		// Instance which replaces previous one keeps IP it is attached with during rolling update
+		if i == 0 && networkParams.IP != instanceInfo.NetworkParameters.IP {
+			t.Errorf("Wrong instance IP: %s", networkParams.IP)
+		}
 
-	storage.onlineTime = onlineTime
+		if !reflect.DeepEqual(resourceManager.allocatedDevices["device0"], []string{storedInstance.InstanceID}) {
+			t.Errorf("Wrong device allocation: %v", resourceManager.allocatedDevices["device0"])
+		}
 
-	return nil
+		if _, ok := registrar.secrets[instanceInfo.InstanceIdent]; !ok {
+			t.Error("Instance should be registered")
+		}
+
+		prevInstanceID = storedInstance.InstanceID
+	}
 }
 
-func (storage *testStorage) fromTestItem(item testItem) {
-	storage.Lock()
-	defer storage.Unlock()
+func TestRollback(t *testing.T) {
+	var (
+		failStart        bool
+		stoppedInstances []string
+	)
 
-	storage.instances = make(map[string]launcher.InstanceInfo)
+	storage := newTestStorage()
+	serviceProvider := newTestServiceProvider()
+	alertSender := newTestAlertSender()
 
-	for _, instance := range item.instances {
-		instanceID := uuid.New().String()
+	instanceRunner := newTestRunner(
+		func(instanceID string) runner.InstanceStatus {
+			if failStart {
+				failStart = false
 
-		storage.instances[instanceID] = launcher.InstanceInfo{
-			InstanceInfo: instance,
-			InstanceID:   instanceID,
-		}
-	}
-}
+				return runner.InstanceStatus{
+					InstanceID: instanceID,
+					State:      cloudprotocol.InstanceStateFailed,
+					Err:        errors.New("start failed"), //nolint:goerr113
+				}
+			}
 
-/***********************************************************************************************************************
- * testServiceProvider
- **********************************************************************************************************************/
+			return runner.InstanceStatus{InstanceID: instanceID, State: cloudprotocol.InstanceStateActive}
+		},
+		func(instanceID string) error {
+			stoppedInstances = append(stoppedInstances, instanceID)
 
-func newTestServiceProvider() *testServiceProvider {
-	return &testServiceProvider{}
-}
+			return nil
+		},
+	)
 
-func (provider *testServiceProvider) GetServiceInfo(serviceID string) (servicemanager.ServiceInfo, error) {
-	service, ok := provider.services[serviceID]
-	if !ok {
-		return servicemanager.ServiceInfo{}, servicemanager.ErrNotExist
+	testLauncher, err := launcher.New(&config.Config{
+		WorkingDir: tmpDir, RollbackWindow: aostypes.Duration{Duration: 1 * time.Second},
+	}, storage, serviceProvider, newTestLayerProvider(), newTestRunners(instanceRunner), newTestResourceManager(),
+		newTestNetworkManager(), newTestRegistrar(), newTestInstanceMonitor(), alertSender)
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
 	}
+	defer testLauncher.Close()
 
-	return service, nil
-}
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
 
-func (provider *testServiceProvider) GetImageParts(
+	instanceInfo := aostypes.InstanceInfo{
+		InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0},
+	}
+
+	installService := func(aosVersion uint64) {
+		if err := serviceProvider.installServices([]serviceInfo{{
+			ServiceInfo: aostypes.ServiceInfo{
+				ID: "service0", VersionInfo: aostypes.VersionInfo{AosVersion: aosVersion},
+			},
+		}}); err != nil {
+			t.Fatalf("Can't install services: %v", err)
+		}
+	}
+
+	instanceStatus := func(aosVersion uint64, runState, message string) cloudprotocol.InstanceStatus {
+		status := cloudprotocol.InstanceStatus{
+			InstanceIdent: instanceInfo.InstanceIdent, AosVersion: aosVersion, RunState: runState,
+		}
+
+		if message != "" {
+			status.ErrorInfo = &cloudprotocol.ErrorInfo{Message: message}
+		}
+
+		return status
+	}
+
+	// Version 1 becomes known good after rollback window
+
+	installService(1)
+
+	if err = testLauncher.RunInstances([]aostypes.InstanceInfo{instanceInfo}, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			instanceStatus(1, cloudprotocol.InstanceStateActive, ""),
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	if version, _ := storage.GetKnownGoodServiceVersion("service0"); version != 1 {
+		t.Errorf("Wrong known good version: %d", version)
+	}
+
+	// Version 2 fails on start and is rolled back to version 1
+
+	installService(2)
+
+	failStart = true
+
+	if err = testLauncher.RunInstances([]aostypes.InstanceInfo{instanceInfo}, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			instanceStatus(1, launcher.InstanceStateRolledBack,
+				"rolled back to version 1 from version 2: start failed"),
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if len(alertSender.instanceAlerts) != 1 || alertSender.instanceAlerts[0].AosVersion != 2 {
+		t.Errorf("Wrong instance alerts: %v", alertSender.instanceAlerts)
+	}
+
+	// Rolled back version keeps running while failed version is desired
+
+	stoppedInstances = nil
+
+	if err = testLauncher.RunInstances([]aostypes.InstanceInfo{instanceInfo}, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			instanceStatus(1, launcher.InstanceStateRolledBack, "rolled back to version 1 from version 2"),
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if len(stoppedInstances) != 0 {
+		t.Errorf("Rolled back instance should not be restarted: %v", stoppedInstances)
+	}
+
+	// Version 3 fails after start and is rolled back to version 1
+
+	installService(3)
+
+	if err = testLauncher.RunInstances([]aostypes.InstanceInfo{instanceInfo}, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			instanceStatus(3, cloudprotocol.InstanceStateActive, ""),
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	storedInstance, err := storage.getInstanceByIdent(instanceInfo.InstanceIdent)
+	if err != nil {
+		t.Fatalf("Can't get stored instance: %v", err)
+	}
+
+	instanceRunner.statusChannel <- []runner.InstanceStatus{{
+		InstanceID: storedInstance.InstanceID,
+		State:      cloudprotocol.InstanceStateFailed,
+		Err:        errors.New("instance crashed"), //nolint:goerr113
+	}}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		UpdateStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			instanceStatus(3, cloudprotocol.InstanceStateFailed, "instance crashed"),
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			instanceStatus(1, launcher.InstanceStateRolledBack,
+				"rolled back to version 1 from version 3: instance crashed"),
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if len(alertSender.instanceAlerts) != 2 || alertSender.instanceAlerts[1].AosVersion != 3 {
+		t.Errorf("Wrong instance alerts: %v", alertSender.instanceAlerts)
+	}
+
+	if version, _ := storage.GetKnownGoodServiceVersion("service0"); version != 1 {
+		t.Errorf("Wrong known good version: %d", version)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	var (
+		unhealthy        bool
+		mutex            sync.Mutex
+		restartChannel   = make(chan string, 1)
+		listener, errTCP = net.Listen("tcp", "127.0.0.1:0")
+	)
+
+	if errTCP != nil {
+		t.Fatalf("Can't create TCP listener: %v", errTCP)
+	}
+	defer listener.Close()
+
+	setUnhealthy := func(value bool) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		unhealthy = value
+	}
+
+	storage := newTestStorage()
+	serviceProvider := newTestServiceProvider()
+
+	instanceRunner := newTestRunner(nil, func(instanceID string) error {
+		select {
+		case restartChannel <- instanceID:
+
+		default:
+		}
+
+		return nil
+	})
+
+	instanceRunner.execFunc = func(instanceID string, cmd []string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if unhealthy {
+			return errors.New("probe failed") //nolint:goerr113
+		}
+
+		return nil
+	}
+
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		newTestLayerProvider(), newTestRunners(instanceRunner), newTestResourceManager(), newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if err = serviceProvider.installServices([]serviceInfo{
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "service0", VersionInfo: aostypes.VersionInfo{AosVersion: 1}},
+			serviceConfig: &launcher.ServiceConfig{HealthCheck: &launcher.HealthCheck{
+				Exec:             &launcher.ExecProbe{Command: []string{"check"}},
+				Interval:         aostypes.Duration{Duration: 50 * time.Millisecond},
+				FailureThreshold: 2,
+				Action:           launcher.HealthCheckActionFail,
+			}},
+		},
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "service1", VersionInfo: aostypes.VersionInfo{AosVersion: 1}},
+			serviceConfig: &launcher.ServiceConfig{HealthCheck: &launcher.HealthCheck{
+				TCPSocket: &launcher.TCPSocketProbe{Port: uint16(listener.Addr().(*net.TCPAddr).Port)},
+				Interval:  aostypes.Duration{Duration: 50 * time.Millisecond},
+			}},
+		},
+	}); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	execInstance := aostypes.InstanceInfo{
+		InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0},
+	}
+	tcpInstance := aostypes.InstanceInfo{
+		InstanceIdent:     aostypes.InstanceIdent{ServiceID: "service1", SubjectID: "subject0", Instance: 0},
+		NetworkParameters: aostypes.NetworkParameters{IP: "127.0.0.1"},
+	}
+
+	if err = testLauncher.RunInstances([]aostypes.InstanceInfo{execInstance, tcpInstance}, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{InstanceIdent: execInstance.InstanceIdent, AosVersion: 1, RunState: cloudprotocol.InstanceStateActive},
+			{InstanceIdent: tcpInstance.InstanceIdent, AosVersion: 1, RunState: cloudprotocol.InstanceStateActive},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	// Failed exec probe marks instance as failed
+
+	setUnhealthy(true)
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		UpdateStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{
+				InstanceIdent: execInstance.InstanceIdent, AosVersion: 1, RunState: cloudprotocol.InstanceStateFailed,
+				ErrorInfo: &cloudprotocol.ErrorInfo{Message: "health check failed"},
+			},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	setUnhealthy(false)
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		UpdateStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{InstanceIdent: execInstance.InstanceIdent, AosVersion: 1, RunState: cloudprotocol.InstanceStateActive},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	// Failed TCP probe restarts instance
+
+	storedInstance, err := storage.getInstanceByIdent(tcpInstance.InstanceIdent)
+	if err != nil {
+		t.Fatalf("Can't get stored instance: %v", err)
+	}
+
+	select {
+	case instanceID := <-restartChannel:
+		t.Errorf("Unexpected instance restart: %s", instanceID)
+
+	default:
+	}
+
+	listener.Close()
+
+	select {
+	case instanceID := <-restartChannel:
+		if instanceID != storedInstance.InstanceID {
+			t.Errorf("Wrong restarted instance: %s", instanceID)
+		}
+
+	case <-time.After(defaultStatusTimeout):
+		t.Error("Instance should be restarted")
+	}
+}
+
+func TestCrashLoopBackoff(t *testing.T) {
+	var (
+		mutex      sync.Mutex
+		startTimes []time.Time
+	)
+
+	storage := newTestStorage()
+	serviceProvider := newTestServiceProvider()
+
+	instanceRunner := newTestRunner(func(instanceID string) runner.InstanceStatus {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		startTimes = append(startTimes, time.Now())
+
+		return runner.InstanceStatus{InstanceID: instanceID, State: cloudprotocol.InstanceStateActive}
+	}, nil)
+
+	testLauncher, err := launcher.New(&config.Config{
+		WorkingDir: tmpDir,
+		CrashLoopBackoff: config.CrashLoopBackoff{
+			InitialDelay: aostypes.Duration{Duration: 100 * time.Millisecond},
+			MaxDelay:     aostypes.Duration{Duration: 1 * time.Second},
+		},
+	}, storage, serviceProvider, newTestLayerProvider(), newTestRunners(instanceRunner), newTestResourceManager(),
+		newTestNetworkManager(), newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if err = serviceProvider.installServices([]serviceInfo{
+		{ServiceInfo: aostypes.ServiceInfo{ID: "service0", VersionInfo: aostypes.VersionInfo{AosVersion: 1}}},
+	}); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	instanceInfo := aostypes.InstanceInfo{
+		InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0},
+	}
+
+	if err = testLauncher.RunInstances([]aostypes.InstanceInfo{instanceInfo}, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{InstanceIdent: instanceInfo.InstanceIdent, AosVersion: 1, RunState: cloudprotocol.InstanceStateActive},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	storedInstance, err := storage.getInstanceByIdent(instanceInfo.InstanceIdent)
+	if err != nil {
+		t.Fatalf("Can't get stored instance: %v", err)
+	}
+
+	crashTime := time.Now()
+
+	instanceRunner.statusChannel <- []runner.InstanceStatus{{
+		InstanceID: storedInstance.InstanceID,
+		State:      cloudprotocol.InstanceStateFailed,
+		Err:        errors.New("instance crashed"), //nolint:goerr113
+		ExitCode:   137,
+		Signal:     9,
+	}}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		UpdateStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{
+				InstanceIdent: instanceInfo.InstanceIdent, AosVersion: 1, RunState: cloudprotocol.InstanceStateFailed,
+				ErrorInfo: &cloudprotocol.ErrorInfo{ExitCode: 137, Message: "instance crashed"},
+			},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		UpdateStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{InstanceIdent: instanceInfo.InstanceIdent, AosVersion: 1, RunState: cloudprotocol.InstanceStateActive},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	mutex.Lock()
+
+	if len(startTimes) != 2 {
+		t.Errorf("Wrong start count: %d", len(startTimes))
+	} else if delay := startTimes[1].Sub(crashTime); delay < 100*time.Millisecond {
+		t.Errorf("Instance restarted without backoff: %v", delay)
+	}
+
+	mutex.Unlock()
+
+	// Crash within crash loop reports restart count
+
+	instanceRunner.statusChannel <- []runner.InstanceStatus{{
+		InstanceID: storedInstance.InstanceID,
+		State:      cloudprotocol.InstanceStateFailed,
+		Err:        errors.New("instance crashed"), //nolint:goerr113
+		ExitCode:   137,
+		Signal:     9,
+	}}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		UpdateStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{
+				InstanceIdent: instanceInfo.InstanceIdent, AosVersion: 1, RunState: cloudprotocol.InstanceStateFailed,
+				ErrorInfo: &cloudprotocol.ErrorInfo{ExitCode: 137, Message: "instance crashed (restart count: 1)"},
+			},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		UpdateStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{InstanceIdent: instanceInfo.InstanceIdent, AosVersion: 1, RunState: cloudprotocol.InstanceStateActive},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if err = testLauncher.RunInstances(nil, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	expectedEvents := []string{
+		launcher.InstanceEventStart, launcher.InstanceEventCrash, launcher.InstanceEventStart,
+		launcher.InstanceEventCrash, launcher.InstanceEventStart, launcher.InstanceEventStop,
+	}
+
+	if events := storage.getInstanceEvents(storedInstance.InstanceID); !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("Wrong instance events: %v", events)
+	}
+}
+
+func TestGracefulStop(t *testing.T) {
+	storage := newTestStorage()
+	serviceProvider := newTestServiceProvider()
+	alertSender := newTestAlertSender()
+	instanceRunner := newTestRunner(nil, func(instanceID string) error {
+		return aoserrors.Wrap(runner.ErrStopForced)
+	})
+
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		newTestLayerProvider(), newTestRunners(instanceRunner), newTestResourceManager(), newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), alertSender)
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if err = serviceProvider.installServices([]serviceInfo{
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "service0", VersionInfo: aostypes.VersionInfo{AosVersion: 1}},
+			imageConfig: &imagespec.Image{OS: "linux", Config: imagespec.ImageConfig{StopSignal: "SIGINT"}},
+			serviceConfig: &launcher.ServiceConfig{StopParameters: &launcher.StopParameters{
+				Timeout: aostypes.Duration{Duration: 30 * time.Second},
+				PreStop: []string{"flush", "/state.dat"},
+			}},
+		},
+		{
+			ServiceInfo:   aostypes.ServiceInfo{ID: "service1", VersionInfo: aostypes.VersionInfo{AosVersion: 1}},
+			imageConfig:   &imagespec.Image{OS: "linux", Config: imagespec.ImageConfig{StopSignal: "SIGINT"}},
+			serviceConfig: &launcher.ServiceConfig{StopParameters: &launcher.StopParameters{Signal: "quit"}},
+		},
+	}); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	instances := []aostypes.InstanceInfo{
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0}},
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "service1", SubjectID: "subject0", Instance: 0}},
+	}
+
+	if err = testLauncher.RunInstances(instances, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{InstanceIdent: instances[0].InstanceIdent, AosVersion: 1, RunState: cloudprotocol.InstanceStateActive},
+			{InstanceIdent: instances[1].InstanceIdent, AosVersion: 1, RunState: cloudprotocol.InstanceStateActive},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	expectedParams := []runner.RunParameters{
+		{StopSignal: syscall.SIGINT, StopTimeout: 30 * time.Second, PreStop: []string{"flush", "/state.dat"}},
+		{StopSignal: syscall.SIGQUIT},
+	}
+
+	for i, instance := range instances {
+		storedInstance, err := storage.getInstanceByIdent(instance.InstanceIdent)
+		if err != nil {
+			t.Fatalf("Can't get stored instance: %v", err)
+		}
+
+		if params := instanceRunner.getRunParams(storedInstance.InstanceID); !reflect.DeepEqual(
+			params, expectedParams[i]) {
+			t.Errorf("Wrong run parameters: %v", params)
+		}
+	}
+
+	// Forced stop should be reported
+
+	if err = testLauncher.RunInstances(nil, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if len(alertSender.instanceAlerts) != len(instances) {
+		t.Fatalf("Wrong instance alerts: %v", alertSender.instanceAlerts)
+	}
+
+	for _, alert := range alertSender.instanceAlerts {
+		if alert.Message != "instance stop forced after timeout" {
+			t.Errorf("Wrong alert message: %s", alert.Message)
+		}
+	}
+}
+
+func TestCheckpointRestore(t *testing.T) {
+	var (
+		checkpointPath string
+		restoreFails   bool
+	)
+
+	storage := newTestStorage()
+	serviceProvider := newTestServiceProvider()
+
+	instanceRunner := newTestRunner(nil, nil)
+
+	instanceRunner.startFunc = func(instanceID string) runner.InstanceStatus {
+		if restoreFails && instanceRunner.runParams[instanceID].RestorePath != "" {
+			return runner.InstanceStatus{
+				InstanceID: instanceID, State: cloudprotocol.InstanceStateFailed, Err: errors.New("restore failed"),
+			}
+		}
+
+		return runner.InstanceStatus{InstanceID: instanceID, State: cloudprotocol.InstanceStateActive}
+	}
+
+	instanceRunner.checkpointFunc = func(instanceID, imagePath string) error {
+		checkpointPath = imagePath
+
+		return nil
+	}
+
+	serviceConfig := &launcher.ServiceConfig{Checkpoint: true}
+
+	if err := serviceProvider.installServices([]serviceInfo{
+		{
+			ServiceInfo:   aostypes.ServiceInfo{ID: "service0", VersionInfo: aostypes.VersionInfo{AosVersion: 1}},
+			serviceConfig: serviceConfig,
+		},
+	}); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	instance := aostypes.InstanceInfo{
+		InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0},
+	}
+	expectedStatus := launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{
+		Instances: []cloudprotocol.InstanceStatus{
+			{InstanceIdent: instance.InstanceIdent, AosVersion: 1, RunState: cloudprotocol.InstanceStateActive},
+		},
+	}}
+
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		newTestLayerProvider(), newTestRunners(instanceRunner), newTestResourceManager(), newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if err = testLauncher.RunInstances([]aostypes.InstanceInfo{instance}, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), expectedStatus,
+		defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	storedInstance, err := storage.getInstanceByIdent(instance.InstanceIdent)
+	if err != nil {
+		t.Fatalf("Can't get stored instance: %v", err)
+	}
+
+	if params := instanceRunner.getRunParams(storedInstance.InstanceID); params.RestorePath != "" {
+		t.Errorf("Instance should not be restored: %s", params.RestorePath)
+	}
+
+	for _, restoreFails = range []bool{false, true} {
+		// Instance is checkpointed on close and restored on next start
+
+		checkpointPath = ""
+
+		if err = testLauncher.Close(); err != nil {
+			t.Errorf("Can't close launcher: %v", err)
+		}
+
+		if checkpointPath == "" {
+			t.Fatal("Instance should be checkpointed")
+		}
+
+		if testLauncher, err = launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+			newTestLayerProvider(), newTestRunners(instanceRunner), newTestResourceManager(), newTestNetworkManager(),
+			newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender()); err != nil {
+			t.Fatalf("Can't create launcher: %v", err)
+		}
+
+		if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), expectedStatus,
+			defaultStatusTimeout); err != nil {
+			t.Errorf("Check runtime status error: %v", err)
+		}
+
+		params := instanceRunner.getRunParams(storedInstance.InstanceID)
+
+		if !restoreFails && params.RestorePath != checkpointPath {
+			t.Errorf("Instance should be restored: %s", params.RestorePath)
+		}
+
+		if restoreFails && params.RestorePath != "" {
+			t.Errorf("Instance should be started from scratch: %s", params.RestorePath)
+		}
+
+		if _, err = os.Stat(checkpointPath); !os.IsNotExist(err) {
+			t.Error("Checkpoint should be removed")
+		}
+	}
+
+	// Checkpoint is outdated if service config is changed
+
+	checkpointPath = ""
+
+	if err = testLauncher.Close(); err != nil {
+		t.Errorf("Can't close launcher: %v", err)
+	}
+
+	if checkpointPath == "" {
+		t.Fatal("Instance should be checkpointed")
+	}
+
+	serviceConfig.Hostname = newString("checkpoint")
+
+	if err = serviceProvider.installServices([]serviceInfo{
+		{
+			ServiceInfo:   aostypes.ServiceInfo{ID: "service0", VersionInfo: aostypes.VersionInfo{AosVersion: 1}},
+			serviceConfig: serviceConfig,
+		},
+	}); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	if testLauncher, err = launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		newTestLayerProvider(), newTestRunners(instanceRunner), newTestResourceManager(), newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender()); err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), expectedStatus,
+		defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if params := instanceRunner.getRunParams(storedInstance.InstanceID); params.RestorePath != "" {
+		t.Errorf("Instance should not be restored: %s", params.RestorePath)
+	}
+
+	// Instance with permissions is not checkpointed as it gets new secret on each start
+
+	serviceConfig.Permissions = map[string]map[string]string{"vis": {"*": "r"}}
+
+	if err = serviceProvider.installServices([]serviceInfo{
+		{
+			ServiceInfo:   aostypes.ServiceInfo{ID: "service0", VersionInfo: aostypes.VersionInfo{AosVersion: 1}},
+			serviceConfig: serviceConfig,
+		},
+	}); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	checkpointPath = ""
+
+	if err = testLauncher.Close(); err != nil {
+		t.Errorf("Can't close launcher: %v", err)
+	}
+
+	if checkpointPath == "" {
+		t.Fatal("Instance should be checkpointed")
+	}
+
+	if testLauncher, err = launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		newTestLayerProvider(), newTestRunners(instanceRunner), newTestResourceManager(), newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender()); err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), expectedStatus,
+		defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if params := instanceRunner.getRunParams(storedInstance.InstanceID); params.RestorePath != "" {
+		t.Errorf("Instance should not be restored: %s", params.RestorePath)
+	}
+
+	checkpointPath = ""
+
+	if err = testLauncher.Close(); err != nil {
+		t.Errorf("Can't close launcher: %v", err)
+	}
+
+	if checkpointPath != "" {
+		t.Errorf("Instance should not be checkpointed: %s", checkpointPath)
+	}
+}
+
+func TestServiceRunners(t *testing.T) {
+	var (
+		runcInstances  []string
+		runxInstances  []string
+		runcStopped    []string
+		runxStopped    []string
+		mutex          sync.Mutex
+		storage        = newTestStorage()
+		networkManager = newTestNetworkManager()
+		runcRunner     = newTestRunner(func(instanceID string) runner.InstanceStatus {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			runcInstances = append(runcInstances, instanceID)
+
+			return runner.InstanceStatus{InstanceID: instanceID, State: cloudprotocol.InstanceStateActive}
+		}, func(instanceID string) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			runcStopped = append(runcStopped, instanceID)
+
+			return nil
+		})
+		runxRunner = newTestRunner(func(instanceID string) runner.InstanceStatus {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			runxInstances = append(runxInstances, instanceID)
+
+			return runner.InstanceStatus{InstanceID: instanceID, State: cloudprotocol.InstanceStateActive}
+		}, func(instanceID string) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			runxStopped = append(runxStopped, instanceID)
+
+			return nil
+		})
+		serviceProvider = newTestServiceProvider()
+	)
+
+	services := []serviceInfo{
+		{ServiceInfo: aostypes.ServiceInfo{ID: "service0"}},
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "service1"},
+			serviceConfig: &launcher.ServiceConfig{
+				ServiceConfig: aostypes.ServiceConfig{Runner: "runx"},
+			},
+		},
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "service2"},
+			serviceConfig: &launcher.ServiceConfig{
+				ServiceConfig: aostypes.ServiceConfig{Runner: "crun"},
+			},
+		},
+	}
+
+	instances := []aostypes.InstanceInfo{
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0}},
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "service1", SubjectID: "subject0", Instance: 0}},
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "service2", SubjectID: "subject0", Instance: 0}},
+	}
+
+	if err := serviceProvider.installServices(services); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir, RunnerFeatures: []string{"runc", "runx"}},
+		storage, serviceProvider, newTestLayerProvider(),
+		map[string]launcher.InstanceRunner{"runc": runcRunner, "runx": runxRunner, "crun": runcRunner},
+		newTestResourceManager(), networkManager, newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if err = testLauncher.RunInstances(instances, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{InstanceIdent: instances[0].InstanceIdent, RunState: cloudprotocol.InstanceStateActive},
+			{InstanceIdent: instances[1].InstanceIdent, RunState: cloudprotocol.InstanceStateActive},
+			{
+				InstanceIdent: instances[2].InstanceIdent, RunState: cloudprotocol.InstanceStateFailed,
+				ErrorInfo: &cloudprotocol.ErrorInfo{Message: "runner crun is not supported by node"},
+			},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	runcInstance, err := storage.getInstanceByIdent(instances[0].InstanceIdent)
+	if err != nil {
+		t.Fatalf("Can't get stored instance: %v", err)
+	}
+
+	runxInstance, err := storage.getInstanceByIdent(instances[1].InstanceIdent)
+	if err != nil {
+		t.Fatalf("Can't get stored instance: %v", err)
+	}
+
+	mutex.Lock()
+
+	if !reflect.DeepEqual(runcInstances, []string{runcInstance.InstanceID}) {
+		t.Errorf("Wrong runc instances: %v", runcInstances)
+	}
+
+	if !reflect.DeepEqual(runxInstances, []string{runxInstance.InstanceID}) {
+		t.Errorf("Wrong runx instances: %v", runxInstances)
+	}
+
+	mutex.Unlock()
+
+	if _, err = networkManager.GetInstanceIP(runcInstance.InstanceID, ""); err != nil {
+		t.Errorf("Runc instance should be added to network: %v", err)
+	}
+
+	if _, err = networkManager.GetInstanceIP(runxInstance.InstanceID, ""); err == nil {
+		t.Error("Runx instance should not be added to network")
+	}
+
+	// Status of second runner should be handled
+
+	runxRunner.statusChannel <- []runner.InstanceStatus{{
+		InstanceID: runxInstance.InstanceID,
+		State:      cloudprotocol.InstanceStateFailed,
+		Err:        errors.New("instance crashed"), //nolint:goerr113
+	}}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		UpdateStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{
+				InstanceIdent: instances[1].InstanceIdent, RunState: cloudprotocol.InstanceStateFailed,
+				ErrorInfo: &cloudprotocol.ErrorInfo{Message: "instance crashed"},
+			},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	// Instance should be stopped by the runner which started it when new service version changes the runner
+
+	services[0].AosVersion = 1
+	services[0].serviceConfig = &launcher.ServiceConfig{ServiceConfig: aostypes.ServiceConfig{Runner: "runx"}}
+
+	if err := serviceProvider.installServices(services[:1]); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	mutex.Lock()
+	runcInstances, runxInstances = nil, nil
+	mutex.Unlock()
+
+	if err = testLauncher.RunInstances(instances[:1], false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{
+				InstanceIdent: instances[0].InstanceIdent, AosVersion: 1,
+				RunState: cloudprotocol.InstanceStateActive,
+			},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if !slices.Contains(runcStopped, runcInstance.InstanceID) {
+		t.Errorf("Instance should be stopped by runc runner: %v", runcStopped)
+	}
+
+	if slices.Contains(runxStopped, runcInstance.InstanceID) {
+		t.Errorf("Instance should not be stopped by runx runner: %v", runxStopped)
+	}
+
+	if !reflect.DeepEqual(runxInstances, []string{runcInstance.InstanceID}) {
+		t.Errorf("Wrong runx instances: %v", runxInstances)
+	}
+
+	if len(runcInstances) != 0 {
+		t.Errorf("Wrong runc instances: %v", runcInstances)
+	}
+}
+
+func TestInstanceDependencies(t *testing.T) {
+	var (
+		mutex            sync.Mutex
+		instanceServices = make(map[string]string)
+		startedServices  []string
+		stoppedServices  []string
+		storage          = newTestStorage()
+		serviceProvider  = newTestServiceProvider()
+	)
+
+	instanceRunner := newTestRunner(func(instanceID string) runner.InstanceStatus {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		storage.RLock()
+		instanceServices[instanceID] = storage.instances[instanceID].ServiceID
+		storage.RUnlock()
+
+		startedServices = append(startedServices, instanceServices[instanceID])
+
+		return runner.InstanceStatus{InstanceID: instanceID, State: cloudprotocol.InstanceStateActive}
+	}, func(instanceID string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		stoppedServices = append(stoppedServices, instanceServices[instanceID])
+
+		return nil
+	})
+
+	dependencyConfig := func(dependencies ...launcher.ServiceDependency) *launcher.ServiceConfig {
+		return &launcher.ServiceConfig{Dependencies: dependencies}
+	}
+
+	if err := serviceProvider.installServices([]serviceInfo{
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "broker"},
+			serviceConfig: &launcher.ServiceConfig{HealthCheck: &launcher.HealthCheck{
+				Exec:     &launcher.ExecProbe{Command: []string{"check"}},
+				Interval: aostypes.Duration{Duration: 50 * time.Millisecond},
+			}},
+		},
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "gateway"},
+			serviceConfig: dependencyConfig(launcher.ServiceDependency{
+				ServiceID: "broker", Condition: launcher.DependencyConditionHealthy,
+			}),
+		},
+		{
+			ServiceInfo:   aostypes.ServiceInfo{ID: "app"},
+			serviceConfig: dependencyConfig(launcher.ServiceDependency{ServiceID: "gateway"}),
+		},
+		{
+			ServiceInfo:   aostypes.ServiceInfo{ID: "cycle0"},
+			serviceConfig: dependencyConfig(launcher.ServiceDependency{ServiceID: "cycle1"}),
+		},
+		{
+			ServiceInfo:   aostypes.ServiceInfo{ID: "cycle1"},
+			serviceConfig: dependencyConfig(launcher.ServiceDependency{ServiceID: "cycle0"}),
+		},
+		{
+			ServiceInfo:   aostypes.ServiceInfo{ID: "orphan"},
+			serviceConfig: dependencyConfig(launcher.ServiceDependency{ServiceID: "missing"}),
+		},
+	}); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		newTestLayerProvider(), newTestRunners(instanceRunner), newTestResourceManager(), newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	instance := func(serviceID string, priority uint64) aostypes.InstanceInfo {
+		return aostypes.InstanceInfo{
+			InstanceIdent: aostypes.InstanceIdent{ServiceID: serviceID, SubjectID: "subject0"},
+			Priority:      priority,
+		}
+	}
+
+	// Priority doesn't override dependencies
+	instances := []aostypes.InstanceInfo{
+		instance("app", 300), instance("gateway", 200), instance("broker", 100),
+		instance("cycle0", 0), instance("cycle1", 0), instance("orphan", 0),
+	}
+
+	if err = testLauncher.RunInstances(instances, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{InstanceIdent: instances[0].InstanceIdent, RunState: cloudprotocol.InstanceStateActive},
+			{InstanceIdent: instances[1].InstanceIdent, RunState: cloudprotocol.InstanceStateActive},
+			{InstanceIdent: instances[2].InstanceIdent, RunState: cloudprotocol.InstanceStateActive},
+			{
+				InstanceIdent: instances[3].InstanceIdent, RunState: cloudprotocol.InstanceStateFailed,
+				ErrorInfo: &cloudprotocol.ErrorInfo{Message: "dependency cycle"},
+			},
+			{
+				InstanceIdent: instances[4].InstanceIdent, RunState: cloudprotocol.InstanceStateFailed,
+				ErrorInfo: &cloudprotocol.ErrorInfo{Message: "dependency cycle"},
+			},
+			{
+				InstanceIdent: instances[5].InstanceIdent, RunState: cloudprotocol.InstanceStateFailed,
+				ErrorInfo: &cloudprotocol.ErrorInfo{Message: "dependency service missing is not running"},
+			},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	mutex.Lock()
+
+	if !reflect.DeepEqual(startedServices, []string{"broker", "gateway", "app"}) {
+		t.Errorf("Wrong start order: %v", startedServices)
+	}
+
+	mutex.Unlock()
+
+	// Instances are stopped in reverse order
+
+	if err = testLauncher.RunInstances(nil, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if !reflect.DeepEqual(stoppedServices, []string{"app", "gateway", "broker"}) {
+		t.Errorf("Wrong stop order: %v", stoppedServices)
+	}
+}
+
+func TestDependencyTimeout(t *testing.T) {
+	var (
+		mutex           sync.Mutex
+		storage         = newTestStorage()
+		serviceProvider = newTestServiceProvider()
+		brokerStarted   = make(chan string, 1)
+	)
+
+	// Started instances stay activating until active status is sent by runner
+	instanceRunner := newTestRunner(func(instanceID string) runner.InstanceStatus {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		storage.RLock()
+		serviceID := storage.instances[instanceID].ServiceID
+		storage.RUnlock()
+
+		if serviceID == "broker" {
+			brokerStarted <- instanceID
+		}
+
+		return runner.InstanceStatus{InstanceID: instanceID, State: cloudprotocol.InstanceStateActivating}
+	}, nil)
+
+	if err := serviceProvider.installServices([]serviceInfo{
+		{ServiceInfo: aostypes.ServiceInfo{ID: "broker"}},
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "gateway"},
+			serviceConfig: &launcher.ServiceConfig{
+				Dependencies: []launcher.ServiceDependency{{ServiceID: "broker"}},
+			},
+		},
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "app"},
+			serviceConfig: &launcher.ServiceConfig{
+				Dependencies: []launcher.ServiceDependency{{ServiceID: "gateway"}},
+			},
+		},
+	}); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	dependencyTimeout := 1 * time.Second
+
+	testLauncher, err := launcher.New(&config.Config{
+		WorkingDir: tmpDir, ServiceDependencyTimeout: aostypes.Duration{Duration: dependencyTimeout},
+	}, storage, serviceProvider, newTestLayerProvider(), newTestRunners(instanceRunner), newTestResourceManager(),
+		newTestNetworkManager(), newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	instances := []aostypes.InstanceInfo{
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "broker", SubjectID: "subject0"}},
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "gateway", SubjectID: "subject0"}},
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "app", SubjectID: "subject0"}},
+	}
+
+	runDone := make(chan error, 1)
+	runStart := time.Now()
+
+	go func() {
+		runDone <- testLauncher.RunInstances(instances, false)
+	}()
+
+	var brokerInstanceID string
+
+	select {
+	case brokerInstanceID = <-brokerStarted:
+
+	case <-time.After(defaultStatusTimeout):
+		t.Fatal("Wait for broker start timeout")
+	}
+
+	// Launcher is not locked while instances wait for dependencies
+
+	if err = testLauncher.CloudConnection(true); err != nil {
+		t.Errorf("Can't set cloud connection: %v", err)
+	}
+
+	select {
+	case <-runDone:
+		t.Fatal("Run instances should wait for dependencies")
+
+	default:
+	}
+
+	// Dependency timeout is not restarted by dependency status change
+
+	time.Sleep(dependencyTimeout * 7 / 10)
+
+	instanceRunner.statusChannel <- []runner.InstanceStatus{
+		{InstanceID: brokerInstanceID, State: cloudprotocol.InstanceStateActive},
+	}
+
+	select {
+	case err = <-runDone:
+		if err != nil {
+			t.Errorf("Can't run instances: %v", err)
+		}
+
+	case <-time.After(2 * dependencyTimeout):
+		t.Fatal("Wait for run instances timeout")
+	}
+
+	if elapsed := time.Since(runStart); elapsed > dependencyTimeout*3/2 {
+		t.Errorf("Wrong dependency wait time: %v", elapsed)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{InstanceIdent: instances[0].InstanceIdent, RunState: cloudprotocol.InstanceStateActive},
+			{InstanceIdent: instances[1].InstanceIdent, RunState: cloudprotocol.InstanceStateActivating},
+			{
+				InstanceIdent: instances[2].InstanceIdent, RunState: cloudprotocol.InstanceStateFailed,
+				ErrorInfo: &cloudprotocol.ErrorInfo{Message: "dependency is not ready: gateway"},
+			},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+}
+
+func TestInitSteps(t *testing.T) {
+	var (
+		mutex           sync.Mutex
+		events          []string
+		storage         = newTestStorage()
+		serviceProvider = newTestServiceProvider()
+	)
+
+	instanceRunner := newTestRunner(func(instanceID string) runner.InstanceStatus {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		storage.RLock()
+		events = append(events, "start "+storage.instances[instanceID].ServiceID)
+		storage.RUnlock()
+
+		return runner.InstanceStatus{InstanceID: instanceID, State: cloudprotocol.InstanceStateActive}
+	}, nil)
+
+	instanceRunner.initFunc = func(ctx context.Context, containerID, bundleDir string) (int, error) {
+		var spec runtimespec.Spec
+
+		data, err := os.ReadFile(filepath.Join(bundleDir, runtimeConfigFile))
+		if err != nil {
+			return 0, aoserrors.Wrap(err)
+		}
+
+		if err = json.Unmarshal(data, &spec); err != nil {
+			return 0, aoserrors.Wrap(err)
+		}
+
+		if spec.Process.Args[0] == "hang" {
+			<-ctx.Done()
+
+			return 0, aoserrors.Wrap(ctx.Err())
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		events = append(events, "init "+strings.Join(spec.Process.Args, " "))
+
+		if spec.Process.Args[0] == "check" {
+			return 2, nil
+		}
+
+		return 0, nil
+	}
+
+	if err := serviceProvider.installServices([]serviceInfo{
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "migrate"},
+			serviceConfig: &launcher.ServiceConfig{InitSteps: []launcher.InitStep{
+				{Name: "schema", Cmd: []string{"migrate", "up"}},
+				{Cmd: []string{"seed"}},
+			}},
+		},
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "broken"},
+			serviceConfig: &launcher.ServiceConfig{InitSteps: []launcher.InitStep{
+				{Name: "check", Cmd: []string{"check"}},
+			}},
+		},
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "stuck"},
+			serviceConfig: &launcher.ServiceConfig{InitSteps: []launcher.InitStep{
+				{Name: "hang", Cmd: []string{"hang"}},
+			}},
+		},
+	}); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	// Steps without timeout are canceled by default init step timeout
+	testLauncher, err := launcher.New(&config.Config{
+		WorkingDir: tmpDir, InitStepTimeout: aostypes.Duration{Duration: 100 * time.Millisecond},
+	}, storage, serviceProvider, newTestLayerProvider(), newTestRunners(instanceRunner), newTestResourceManager(),
+		newTestNetworkManager(), newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	instances := []aostypes.InstanceInfo{
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "migrate", SubjectID: "subject0"}, Priority: 100},
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "broken", SubjectID: "subject0"}},
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "stuck", SubjectID: "subject0"}},
+	}
+
+	if err = testLauncher.RunInstances(instances, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{InstanceIdent: instances[0].InstanceIdent, RunState: cloudprotocol.InstanceStateActive},
+			{
+				InstanceIdent: instances[1].InstanceIdent, RunState: cloudprotocol.InstanceStateFailed,
+				ErrorInfo: &cloudprotocol.ErrorInfo{ExitCode: 2, Message: "init step check exited with code 2"},
+			},
+			{
+				InstanceIdent: instances[2].InstanceIdent, RunState: cloudprotocol.InstanceStateFailed,
+				ErrorInfo: &cloudprotocol.ErrorInfo{Message: "can't run init step hang"},
+			},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if !reflect.DeepEqual(events, []string{"init migrate up", "init seed", "start migrate", "init check"}) {
+		t.Errorf("Wrong init steps order: %v", events)
+	}
+}
+
+func TestJobInstances(t *testing.T) {
+	var (
+		mutex           sync.Mutex
+		startCount      int
+		storage         = newTestStorage()
+		serviceProvider = newTestServiceProvider()
+	)
+
+	instanceRunner := newTestRunner(func(instanceID string) runner.InstanceStatus {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		startCount++
+
+		return runner.InstanceStatus{InstanceID: instanceID, State: cloudprotocol.InstanceStateActive}
+	}, nil)
+
+	if err := serviceProvider.installServices([]serviceInfo{
+		{
+			ServiceInfo:   aostypes.ServiceInfo{ID: "diagnostics"},
+			serviceConfig: &launcher.ServiceConfig{Kind: launcher.InstanceKindJob},
+		},
+		{
+			ServiceInfo:   aostypes.ServiceInfo{ID: "unknown"},
+			serviceConfig: &launcher.ServiceConfig{Kind: "daemonset"},
+		},
+	}); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		newTestLayerProvider(), newTestRunners(instanceRunner), newTestResourceManager(), newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	instances := []aostypes.InstanceInfo{
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "diagnostics", SubjectID: "subject0"}},
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "unknown", SubjectID: "subject0"}},
+	}
+
+	if err = testLauncher.RunInstances(instances, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	unknownStatus := cloudprotocol.InstanceStatus{
+		InstanceIdent: instances[1].InstanceIdent, RunState: cloudprotocol.InstanceStateFailed,
+		ErrorInfo: &cloudprotocol.ErrorInfo{Message: "unsupported instance kind: daemonset"},
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{InstanceIdent: instances[0].InstanceIdent, RunState: cloudprotocol.InstanceStateActive},
+			unknownStatus,
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	jobInstance, err := storage.getInstanceByIdent(instances[0].InstanceIdent)
+	if err != nil {
+		t.Fatalf("Can't get stored instance: %v", err)
+	}
+
+	if !instanceRunner.getRunParams(jobInstance.InstanceID).OneShot {
+		t.Error("Job instance should be one-shot")
+	}
+
+	instanceRunner.statusChannel <- []runner.InstanceStatus{{
+		InstanceID: jobInstance.InstanceID, State: runner.InstanceStateCompleted, ExitCode: 3,
+	}}
+
+	completedStatus := cloudprotocol.InstanceStatus{
+		InstanceIdent: instances[0].InstanceIdent, RunState: runner.InstanceStateCompleted,
+		ErrorInfo: &cloudprotocol.ErrorInfo{ExitCode: 3},
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		UpdateStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{completedStatus}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	// Completed job is not restarted
+
+	if err = testLauncher.RunInstances(instances, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			completedStatus, unknownStatus,
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if startCount != 1 {
+		t.Errorf("Wrong job start count: %d", startCount)
+	}
+}
+
+func TestScheduledInstances(t *testing.T) {
+	var (
+		mutex           sync.Mutex
+		startedServices = make(map[string]int)
+		storage         = newTestStorage()
+		serviceProvider = newTestServiceProvider()
+		lastRunTime     = time.Now().AddDate(-2, 0, 0)
+	)
+
+	instanceRunner := newTestRunner(func(instanceID string) runner.InstanceStatus {
+		storage.RLock()
+		serviceID := storage.instances[instanceID].ServiceID
+		storage.RUnlock()
+
+		mutex.Lock()
+		startedServices[serviceID]++
+		mutex.Unlock()
+
+		if serviceID == "report" {
+			return runner.InstanceStatus{InstanceID: instanceID, State: runner.InstanceStateCompleted}
+		}
+
+		return runner.InstanceStatus{InstanceID: instanceID, State: cloudprotocol.InstanceStateActive}
+	}, nil)
+
+	if err := serviceProvider.installServices([]serviceInfo{
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "backup"},
+			serviceConfig: &launcher.ServiceConfig{Schedule: &launcher.Schedule{
+				Cron: "@yearly", MaxRuntime: aostypes.Duration{Duration: 500 * time.Millisecond},
+				MissedRuns: launcher.MissedRunsRunOnce,
+			}},
+		},
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "report"},
+			serviceConfig: &launcher.ServiceConfig{Schedule: &launcher.Schedule{
+				Cron: "@yearly", MissedRuns: launcher.MissedRunsRunOnce,
+			}},
+		},
+		{
+			ServiceInfo:   aostypes.ServiceInfo{ID: "cleanup"},
+			serviceConfig: &launcher.ServiceConfig{Schedule: &launcher.Schedule{Cron: "@yearly"}},
+		},
+	}); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	instances := []aostypes.InstanceInfo{
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "backup", SubjectID: "subject0"}},
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "report", SubjectID: "subject0"}},
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "cleanup", SubjectID: "subject0"}},
+	}
+
+	for _, instance := range instances {
+		if err := storage.AddScheduledRun(launcher.ScheduledRun{
+			InstanceIdent: instance.InstanceIdent, ScheduledTime: lastRunTime, Result: launcher.ScheduledRunCompleted,
+		}, 0); err != nil {
+			t.Fatalf("Can't add scheduled run: %v", err)
+		}
+	}
+
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		newTestLayerProvider(), newTestRunners(instanceRunner), newTestResourceManager(), newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if err = testLauncher.RunInstances(instances, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	instanceIDs := make(map[string]string)
+
+	for _, instance := range instances {
+		storedInstance, err := storage.getInstanceByIdent(instance.InstanceIdent)
+		if err != nil {
+			t.Fatalf("Can't get stored instance: %v", err)
+		}
+
+		instanceIDs[instance.ServiceID] = storedInstance.InstanceID
+	}
+
+	// Missed runs of backup and report are run once, missed run of cleanup is skipped
+
+	timeout := time.After(defaultStatusTimeout)
+
+	for len(storage.getScheduledRuns(instanceIDs["backup"])) == 0 ||
+		len(storage.getScheduledRuns(instanceIDs["report"])) == 0 ||
+		len(storage.getScheduledRuns(instanceIDs["cleanup"])) == 0 {
+		select {
+		case <-testLauncher.RuntimeStatusChannel():
+
+		case <-timeout:
+			t.Fatal("Wait for scheduled runs timeout")
+		}
+	}
+
+	for serviceID, result := range map[string]string{
+		"backup": launcher.ScheduledRunTimeout, "report": launcher.ScheduledRunCompleted,
+		"cleanup": launcher.ScheduledRunMissed,
+	} {
+		runs := storage.getScheduledRuns(instanceIDs[serviceID])
+
+		if len(runs) != 1 {
+			t.Fatalf("Wrong %s scheduled runs count: %d", serviceID, len(runs))
+		}
+
+		if runs[0].Result != result || !runs[0].ScheduledTime.After(lastRunTime) {
+			t.Errorf("Wrong %s scheduled run: %v", serviceID, runs[0])
+		}
+
+		if serviceID != "cleanup" && !instanceRunner.getRunParams(instanceIDs[serviceID]).OneShot {
+			t.Errorf("Scheduled instance %s should be one-shot", serviceID)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if startedServices["backup"] != 1 || startedServices["report"] != 1 || startedServices["cleanup"] != 0 {
+		t.Errorf("Wrong scheduled instances start count: %v", startedServices)
+	}
+}
+
+func TestSidecars(t *testing.T) {
+	var (
+		mutex           sync.Mutex
+		startedIDs      []string
+		stoppedIDs      []string
+		storage         = newTestStorage()
+		serviceProvider = newTestServiceProvider()
+		networkManager  = newTestNetworkManager()
+	)
+
+	instanceRunner := newTestRunner(func(instanceID string) runner.InstanceStatus {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		startedIDs = append(startedIDs, instanceID)
+
+		return runner.InstanceStatus{InstanceID: instanceID, State: cloudprotocol.InstanceStateActive}
+	}, func(instanceID string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		stoppedIDs = append(stoppedIDs, instanceID)
+
+		return nil
+	})
+
+	if err := serviceProvider.installServices([]serviceInfo{
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "app"},
+			serviceConfig: &launcher.ServiceConfig{Sidecars: []launcher.Sidecar{
+				{ServiceID: "proxy", ShareStorage: true}, {ServiceID: "telemetry"},
+			}},
+		},
+		{ServiceInfo: aostypes.ServiceInfo{ID: "proxy"}, serviceConfig: &launcher.ServiceConfig{}},
+		{ServiceInfo: aostypes.ServiceInfo{ID: "telemetry"}, serviceConfig: &launcher.ServiceConfig{}},
+	}); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	testLauncher, err := launcher.New(&config.Config{
+		WorkingDir: tmpDir,
+		StorageDir: filepath.Join(tmpDir, "storages"),
+	}, storage, serviceProvider, newTestLayerProvider(), newTestRunners(instanceRunner), newTestResourceManager(),
+		networkManager, newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	instances := []aostypes.InstanceInfo{{
+		InstanceIdent: aostypes.InstanceIdent{ServiceID: "app", SubjectID: "subject0"},
+		StoragePath:   "appStorage",
+		UID:           5000,
+	}}
+
+	if err = testLauncher.RunInstances(instances, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	// Sidecars are not reported as instances
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{InstanceIdent: instances[0].InstanceIdent, RunState: cloudprotocol.InstanceStateActive},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	appInstance, err := storage.getInstanceByIdent(instances[0].InstanceIdent)
+	if err != nil {
+		t.Fatalf("Can't get stored instance: %v", err)
+	}
+
+	sidecarIDs := []string{appInstance.InstanceID + "-sidecar0", appInstance.InstanceID + "-sidecar1"}
+
+	mutex.Lock()
+
+	if !reflect.DeepEqual(startedIDs, append([]string{appInstance.InstanceID}, sidecarIDs...)) {
+		t.Errorf("Wrong started containers: %v", startedIDs)
+	}
+
+	mutex.Unlock()
+
+	for i, sidecarID := range sidecarIDs {
+		runtimeSpec, err := getInstanceRuntimeSpec(sidecarID)
+		if err != nil {
+			t.Fatalf("Can't get sidecar runtime spec: %v", err)
+		}
+
+		if !hasNamespace(runtimeSpec, runtimespec.NetworkNamespace,
+			networkManager.GetNetnsPath(appInstance.InstanceID)) {
+			t.Errorf("Sidecar should use instance network namespace: %v", runtimeSpec.Linux.Namespaces)
+		}
+
+		if runtimeSpec.Process.User.UID != appInstance.UID {
+			t.Errorf("Wrong sidecar UID: %d", runtimeSpec.Process.User.UID)
+		}
+
+		if !slices.Contains(runtimeSpec.Process.Env, "AOS_INSTANCE_ID="+appInstance.InstanceID) {
+			t.Errorf("Wrong sidecar env: %v", runtimeSpec.Process.Env)
+		}
+
+		storageShared := slices.ContainsFunc(runtimeSpec.Mounts, func(mount runtimespec.Mount) bool {
+			return mount.Destination == "/storage" &&
+				mount.Source == filepath.Join(tmpDir, "storages", appInstance.StoragePath)
+		})
+
+		if storageShared != (i == 0) {
+			t.Errorf("Wrong sidecar storage mount: %v", runtimeSpec.Mounts)
+		}
+	}
+
+	// Sidecars are stopped before the instance
+
+	if err = testLauncher.RunInstances(nil, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if !reflect.DeepEqual(stoppedIDs, []string{sidecarIDs[1], sidecarIDs[0], appInstance.InstanceID}) {
+		t.Errorf("Wrong stopped containers: %v", stoppedIDs)
+	}
+
+	for _, sidecarID := range sidecarIDs {
+		if _, err := os.Stat(filepath.Join(launcher.RuntimeDir, sidecarID)); !os.IsNotExist(err) {
+			t.Errorf("Sidecar runtime dir should be removed: %s", sidecarID)
+		}
+	}
+}
+
+func TestSidecarRestart(t *testing.T) {
+	var (
+		mutex           sync.Mutex
+		instanceStarts  int
+		sidecarStarts   int
+		sidecarStops    int
+		storage         = newTestStorage()
+		serviceProvider = newTestServiceProvider()
+	)
+
+	instanceRunner := newTestRunner(func(instanceID string) runner.InstanceStatus {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		instanceStarts++
+
+		return runner.InstanceStatus{InstanceID: instanceID, State: cloudprotocol.InstanceStateActive}
+	}, nil)
+
+	// Sidecar can't be started on the first restart attempt
+	sidecarRunner := newTestRunner(func(instanceID string) runner.InstanceStatus {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		sidecarStarts++
+
+		if sidecarStarts == 2 {
+			return runner.InstanceStatus{
+				InstanceID: instanceID, State: cloudprotocol.InstanceStateFailed,
+				Err: errors.New("start failed"), //nolint:goerr113
+			}
+		}
+
+		return runner.InstanceStatus{InstanceID: instanceID, State: cloudprotocol.InstanceStateActive}
+	}, func(instanceID string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		sidecarStops++
+
+		return nil
+	})
+
+	if err := serviceProvider.installServices([]serviceInfo{
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "app"},
+			serviceConfig: &launcher.ServiceConfig{
+				ServiceConfig: aostypes.ServiceConfig{Runner: "runc"},
+				Sidecars:      []launcher.Sidecar{{ServiceID: "proxy"}},
+			},
+		},
+		{
+			ServiceInfo: aostypes.ServiceInfo{ID: "proxy"},
+			serviceConfig: &launcher.ServiceConfig{
+				ServiceConfig: aostypes.ServiceConfig{Runner: "crun"},
+			},
+		},
+	}); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	testLauncher, err := launcher.New(&config.Config{
+		WorkingDir: tmpDir,
+		CrashLoopBackoff: config.CrashLoopBackoff{
+			InitialDelay: aostypes.Duration{Duration: 100 * time.Millisecond},
+			MaxDelay:     aostypes.Duration{Duration: 1 * time.Second},
+		},
+	}, storage, serviceProvider, newTestLayerProvider(),
+		map[string]launcher.InstanceRunner{"runc": instanceRunner, "crun": sidecarRunner}, newTestResourceManager(),
+		newTestNetworkManager(), newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	instances := []aostypes.InstanceInfo{
+		{InstanceIdent: aostypes.InstanceIdent{ServiceID: "app", SubjectID: "subject0"}},
+	}
+
+	if err = testLauncher.RunInstances(instances, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{InstanceIdent: instances[0].InstanceIdent, RunState: cloudprotocol.InstanceStateActive},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	appInstance, err := storage.getInstanceByIdent(instances[0].InstanceIdent)
+	if err != nil {
+		t.Fatalf("Can't get stored instance: %v", err)
+	}
+
+	// Sidecar is started by the sidecar service runner
+
+	mutex.Lock()
+
+	if instanceStarts != 1 || sidecarStarts != 1 {
+		t.Errorf("Wrong start count: instance %d, sidecar %d", instanceStarts, sidecarStarts)
+	}
+
+	mutex.Unlock()
+
+	// Sidecar failure fails the instance
+
+	sidecarRunner.statusChannel <- []runner.InstanceStatus{{
+		InstanceID: appInstance.InstanceID + "-sidecar0", State: cloudprotocol.InstanceStateFailed,
+		Err: errors.New("sidecar crashed"), //nolint:goerr113
+	}}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		UpdateStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{{
+			InstanceIdent: instances[0].InstanceIdent, RunState: cloudprotocol.InstanceStateFailed,
+			ErrorInfo: &cloudprotocol.ErrorInfo{Message: "sidecar proxy failed: sidecar crashed"},
+		}}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	// The instance and sidecar are restarted together till sidecar is started
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		UpdateStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{
+			{InstanceIdent: instances[0].InstanceIdent, RunState: cloudprotocol.InstanceStateActive},
+		}},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if instanceStarts != 3 || sidecarStarts != 3 || sidecarStops != 2 {
+		t.Errorf("Wrong restart count: instance starts %d, sidecar starts %d, sidecar stops %d",
+			instanceStarts, sidecarStarts, sidecarStops)
+	}
+}
+
+/***********************************************************************************************************************
+ * testStorage
+ **********************************************************************************************************************/
+
+func newTestStorage() *testStorage {
+	return &testStorage{
+		instances:         make(map[string]launcher.InstanceInfo),
+		knownGoodVersions: make(map[string]uint64),
+	}
+}
+
+func (storage *testStorage) AddInstance(instance launcher.InstanceInfo) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if _, ok := storage.instances[instance.InstanceID]; ok {
+		return aoserrors.New("instance exists")
+	}
+
+	storage.instances[instance.InstanceID] = instance
+
+	return nil
+}
+
+func (storage *testStorage) UpdateInstance(instance launcher.InstanceInfo) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if _, ok := storage.instances[instance.InstanceID]; !ok {
+		return launcher.ErrNotExist
+	}
+
+	storage.instances[instance.InstanceID] = instance
+
+	return nil
+}
+
+func (storage *testStorage) RemoveInstance(instanceID string) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if _, ok := storage.instances[instanceID]; !ok {
+		return launcher.ErrNotExist
+	}
+
+	delete(storage.instances, instanceID)
+
+	return nil
+}
+
+func (storage *testStorage) GetAllInstances() (instances []launcher.InstanceInfo, err error) {
+	storage.RLock()
+	defer storage.RUnlock()
+
+	for _, instance := range storage.instances {
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
+
+func (storage *testStorage) GetOverrideEnvVars() ([]cloudprotocol.EnvVarsInstanceInfo, error) {
+	storage.RLock()
+	defer storage.RUnlock()
+
+	return storage.envVars, nil
+}
+
+func (storage *testStorage) SetOverrideEnvVars(envVarsInfo []cloudprotocol.EnvVarsInstanceInfo) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	storage.envVars = envVarsInfo
+
+	return nil
+}
+
+func (storage *testStorage) GetOnlineTime() (time.Time, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	return storage.onlineTime, nil
+}
+
+func (storage *testStorage) SetOnlineTime(onlineTime time.Time) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	storage.onlineTime = onlineTime
+
+	return nil
+}
+
+func (storage *testStorage) GetKnownGoodServiceVersion(serviceID string) (uint64, error) {
+	storage.RLock()
+	defer storage.RUnlock()
+
+	return storage.knownGoodVersions[serviceID], nil
+}
+
+func (storage *testStorage) SetKnownGoodServiceVersion(serviceID string, aosVersion uint64) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	storage.knownGoodVersions[serviceID] = aosVersion
+
+	return nil
+}
+
+func (storage *testStorage) AddInstanceEvent(event launcher.InstanceEvent, maxEvents int) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	storage.instanceEvents = append(storage.instanceEvents, event)
+
+	return nil
+}
+
+func (storage *testStorage) AddScheduledRun(run launcher.ScheduledRun, maxRuns int) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	storage.scheduledRuns = append(storage.scheduledRuns, run)
+
+	return nil
+}
+
+func (storage *testStorage) GetLastScheduledRun(
+	instanceIdent aostypes.InstanceIdent,
+) (run launcher.ScheduledRun, err error) {
+	storage.RLock()
+	defer storage.RUnlock()
+
+	for i := len(storage.scheduledRuns) - 1; i >= 0; i-- {
+		if storage.scheduledRuns[i].InstanceIdent == instanceIdent {
+			return storage.scheduledRuns[i], nil
+		}
+	}
+
+	return run, launcher.ErrNotExist
+}
+
+func (storage *testStorage) getScheduledRuns(instanceID string) (runs []launcher.ScheduledRun) {
+	storage.RLock()
+	defer storage.RUnlock()
+
+	for _, run := range storage.scheduledRuns {
+		if run.InstanceID == instanceID {
+			runs = append(runs, run)
+		}
+	}
+
+	return runs
+}
+
+func (storage *testStorage) getInstanceEvents(instanceID string) (eventTypes []string) {
+	storage.RLock()
+	defer storage.RUnlock()
+
+	for _, event := range storage.instanceEvents {
+		if event.InstanceID == instanceID {
+			eventTypes = append(eventTypes, event.Type)
+		}
+	}
+
+	return eventTypes
+}
+
+func (storage *testStorage) fromTestItem(item testItem) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	storage.instances = make(map[string]launcher.InstanceInfo)
+
+	for _, instance := range item.instances {
+		instanceID := uuid.New().String()
+
+		storage.instances[instanceID] = launcher.InstanceInfo{
+			InstanceInfo: instance,
+			InstanceID:   instanceID,
+		}
+	}
+}
+
+/***********************************************************************************************************************
+ * testServiceProvider
+ **********************************************************************************************************************/
+
+func newTestServiceProvider() *testServiceProvider {
+	return &testServiceProvider{}
+}
+
+func (provider *testServiceProvider) GetServiceInfo(serviceID string) (servicemanager.ServiceInfo, error) {
+	service, ok := provider.services[serviceID]
+	if !ok {
+		return servicemanager.ServiceInfo{}, servicemanager.ErrNotExist
+	}
+
+	return service, nil
+}
+
+func (provider *testServiceProvider) GetServiceVersionInfo(
+	serviceID string, aosVersion uint64,
+) (servicemanager.ServiceInfo, error) {
+	if service, ok := provider.services[serviceID]; ok && service.AosVersion == aosVersion {
+		return service, nil
+	}
+
+	for _, service := range provider.cachedServices[serviceID] {
+		if service.AosVersion == aosVersion {
+			return service, nil
+		}
+	}
+
+	return servicemanager.ServiceInfo{}, servicemanager.ErrNotExist
+}
+
+func (provider *testServiceProvider) GetImageParts(
 	service servicemanager.ServiceInfo,
 ) (servicemanager.ImageParts, error) {
 	if _, ok := provider.services[service.ServiceID]; !ok {
@@ -2643,11 +4962,26 @@ func (provider *testServiceProvider) ValidateService(service servicemanager.Serv
 	return nil
 }
 
+func (provider *testServiceProvider) UseService(serviceID string, aosVersion uint64) error {
+	return nil
+}
+
 func (provider *testServiceProvider) installServices(services []serviceInfo) error {
 	if err := os.RemoveAll(filepath.Join(tmpDir, servicesDir)); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
+	if provider.cachedServices == nil {
+		provider.cachedServices = make(map[string][]servicemanager.ServiceInfo)
+	}
+
+	// Keep previous service versions as cached ones
+	for _, service := range provider.services {
+		service.Cached = true
+
+		provider.cachedServices[service.ServiceID] = append(provider.cachedServices[service.ServiceID], service)
+	}
+
 	provider.services = make(map[string]servicemanager.ServiceInfo)
 	provider.layerDigests = map[string][]string{}
 
@@ -2719,6 +5053,10 @@ func (provider *testLayerProvider) GetLayerInfoByDigest(digest string) (layerman
 	return layer, nil
 }
 
+func (provider *testLayerProvider) UseLayer(digest string) error {
+	return nil
+}
+
 func (provider *testLayerProvider) installLayers(layers []aostypes.LayerInfo) error {
 	provider.layers = make(map[string]layermanager.LayerInfo)
 
@@ -2747,6 +5085,10 @@ func (provider *testLayerProvider) installLayers(layers []aostypes.LayerInfo) er
  * testRunner
  **********************************************************************************************************************/
 
+func newTestRunners(instanceRunner launcher.InstanceRunner) map[string]launcher.InstanceRunner {
+	return map[string]launcher.InstanceRunner{"runc": instanceRunner}
+}
+
 func newTestRunner(startFunc func(instanceID string) runner.InstanceStatus,
 	stopFunc func(instanceID string) error,
 ) *testRunner {
@@ -2754,6 +5096,7 @@ func newTestRunner(startFunc func(instanceID string) runner.InstanceStatus,
 		statusChannel: make(chan []runner.InstanceStatus, 1),
 		startFunc:     startFunc,
 		stopFunc:      stopFunc,
+		runParams:     make(map[string]runner.RunParameters),
 	}
 }
 
@@ -2763,6 +5106,8 @@ func (instanceRunner *testRunner) StartInstance(
 	instanceRunner.Lock()
 	defer instanceRunner.Unlock()
 
+	instanceRunner.runParams[instanceID] = params
+
 	if instanceRunner.startFunc == nil {
 		return runner.InstanceStatus{
 			InstanceID: instanceID,
@@ -2784,6 +5129,48 @@ func (instanceRunner *testRunner) StopInstance(instanceID string) error {
 	return instanceRunner.stopFunc(instanceID)
 }
 
+func (instanceRunner *testRunner) ExecInstance(ctx context.Context, instanceID string, cmd []string) error {
+	instanceRunner.Lock()
+	defer instanceRunner.Unlock()
+
+	if instanceRunner.execFunc == nil {
+		return nil
+	}
+
+	return instanceRunner.execFunc(instanceID, cmd)
+}
+
+func (instanceRunner *testRunner) CheckpointInstance(instanceID, imagePath string) error {
+	instanceRunner.Lock()
+	defer instanceRunner.Unlock()
+
+	if instanceRunner.checkpointFunc == nil {
+		return nil
+	}
+
+	return instanceRunner.checkpointFunc(instanceID, imagePath)
+}
+
+func (instanceRunner *testRunner) RunInitContainer(
+	ctx context.Context, containerID, bundleDir string,
+) (exitCode int, err error) {
+	instanceRunner.Lock()
+	defer instanceRunner.Unlock()
+
+	if instanceRunner.initFunc == nil {
+		return 0, nil
+	}
+
+	return instanceRunner.initFunc(ctx, containerID, bundleDir)
+}
+
+func (instanceRunner *testRunner) getRunParams(instanceID string) runner.RunParameters {
+	instanceRunner.Lock()
+	defer instanceRunner.Unlock()
+
+	return instanceRunner.runParams[instanceID]
+}
+
 func (instanceRunner *testRunner) InstanceStatusChannel() <-chan []runner.InstanceStatus {
 	return instanceRunner.statusChannel
 }
@@ -2933,6 +5320,18 @@ func (manager *testNetworkManager) GetNetnsPath(instanceID string) string {
 	return filepath.Join("/run/netns", instanceID)
 }
 
+func (manager *testNetworkManager) GetInstanceIP(instanceID, networkID string) (string, error) {
+	manager.Lock()
+	defer manager.Unlock()
+
+	params, ok := manager.instances[instanceID]
+	if !ok {
+		return "", aoserrors.Errorf("instance %s is not in network", instanceID)
+	}
+
+	return params.IP, nil
+}
+
 func (manager *testNetworkManager) AddInstanceToNetwork(
 	instanceID, networkID string, params networkmanager.NetworkParams,
 ) error {
@@ -2948,6 +5347,24 @@ func (manager *testNetworkManager) AddInstanceToNetwork(
 	return nil
 }
 
+func (manager *testNetworkManager) UpdateInstanceNetwork(
+	instanceID, networkID string, params networkmanager.NetworkParams,
+) error {
+	manager.Lock()
+	defer manager.Unlock()
+
+	prevParams, ok := manager.instances[instanceID]
+	if !ok {
+		return aoserrors.Errorf("instance %s is not in network", instanceID)
+	}
+
+	// Instance keeps IP it is attached with
+	params.IP = prevParams.IP
+	manager.instances[instanceID] = params
+
+	return nil
+}
+
 func (manager *testNetworkManager) RemoveInstanceFromNetwork(instanceID, networkID string) error {
 	manager.Lock()
 	defer manager.Unlock()
@@ -3083,17 +5500,97 @@ func (mounter *testMounter) Unmount(mountPoint string) error {
 	return nil
 }
 
+/***********************************************************************************************************************
+ * testQuotaProvider
+ **********************************************************************************************************************/
+
+func newTestQuotaProvider() *testQuotaProvider {
+	return &testQuotaProvider{quotas: make(map[string]*testDiskQuota)}
+}
+
+func (provider *testQuotaProvider) apply(path string, projectID uint32, limit uint64) (launcher.DiskQuota, error) {
+	provider.Lock()
+	defer provider.Unlock()
+
+	if diskQuota, ok := provider.quotas[path]; ok && !diskQuota.released {
+		return nil, aoserrors.Errorf("quota for %s already applied", path)
+	}
+
+	diskQuota := &testDiskQuota{path: path, projectID: projectID, limit: limit}
+
+	provider.quotas[path] = diskQuota
+
+	return diskQuota, nil
+}
+
+func (provider *testQuotaProvider) getQuota(path string) (*testDiskQuota, error) {
+	provider.Lock()
+	defer provider.Unlock()
+
+	diskQuota, ok := provider.quotas[path]
+	if !ok {
+		return nil, aoserrors.Errorf("quota for %s not found", path)
+	}
+
+	return diskQuota, nil
+}
+
+func (diskQuota *testDiskQuota) Limit() uint64 {
+	return diskQuota.limit
+}
+
+func (diskQuota *testDiskQuota) Usage() (uint64, error) {
+	diskQuota.Lock()
+	defer diskQuota.Unlock()
+
+	return diskQuota.usage, nil
+}
+
+func (diskQuota *testDiskQuota) Release() error {
+	diskQuota.Lock()
+	defer diskQuota.Unlock()
+
+	diskQuota.released = true
+
+	return nil
+}
+
+func (diskQuota *testDiskQuota) setUsage(usage uint64) {
+	diskQuota.Lock()
+	defer diskQuota.Unlock()
+
+	diskQuota.usage = usage
+}
+
+func (diskQuota *testDiskQuota) isReleased() bool {
+	diskQuota.Lock()
+	defer diskQuota.Unlock()
+
+	return diskQuota.released
+}
+
 /***********************************************************************************************************************
  * testAlertSender
  **********************************************************************************************************************/
 
 func newTestAlertSender() *testAlertSender {
-	return &testAlertSender{}
+	return &testAlertSender{quotaAlertChannel: make(chan cloudprotocol.InstanceQuotaAlert, 1)}
 }
 
 func (sender *testAlertSender) SendAlert(alertItem cloudprotocol.AlertItem) {
-	if alert, ok := alertItem.Payload.(cloudprotocol.DeviceAllocateAlert); ok {
+	switch alert := alertItem.Payload.(type) {
+	case cloudprotocol.DeviceAllocateAlert:
 		sender.alerts = append(sender.alerts, alert)
+
+	case cloudprotocol.ServiceInstanceAlert:
+		sender.instanceAlerts = append(sender.instanceAlerts, alert)
+
+	case cloudprotocol.InstanceQuotaAlert:
+		select {
+		case sender.quotaAlertChannel <- alert:
+
+		default:
+		}
 	}
 }
 
@@ -3110,6 +5607,8 @@ func setup() (err error) {
 	launcher.RuntimeDir = filepath.Join(tmpDir, "runtime")
 	launcher.MountFunc = mounter.Mount
 	launcher.UnmountFunc = mounter.Unmount
+	launcher.ApplyQuotaFunc = quotaProvider.apply
+	launcher.CheckQuotasPeriod = 100 * time.Millisecond
 
 	return nil
 }
@@ -3638,6 +6137,12 @@ func compareNetParams(p1, p2 networkmanager.NetworkParams) bool {
 		return false
 	}
 
+	if !compareArrays(len(p1.AllowedConnections), len(p2.AllowedConnections), func(index1, index2 int) bool {
+		return p1.AllowedConnections[index1] == p2.AllowedConnections[index2]
+	}) {
+		return false
+	}
+
 	if p1.HostsFilePath != p2.HostsFilePath {
 		return false
 	}
@@ -3731,3 +6236,13 @@ func compareDeviceAllocateAlerts(alerts1, alerts2 []cloudprotocol.DeviceAllocate
 
 	return nil
 }
+
+func hasNamespace(spec runtimespec.Spec, namespaceType runtimespec.LinuxNamespaceType, namespacePath string) bool {
+	for _, namespace := range spec.Linux.Namespaces {
+		if namespace.Type == namespaceType && namespace.Path == namespacePath {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,179 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/aostypes"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/utils/quota"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	storageQuotaParameter = "storage"
+	stateQuotaParameter   = "state"
+)
+
+const (
+	// State project IDs are shifted to not intersect with storage project IDs on the same file system.
+	stateProjectIDOffset = 1 << 24
+	// Percent of quota limit usage when quota is considered as reached.
+	quotaAlertThreshold = 99
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// DiskQuota instance storage or state disk quota.
+type DiskQuota interface {
+	Limit() uint64
+	Usage() (uint64, error)
+	Release() error
+}
+
+type instanceQuota struct {
+	DiskQuota
+	aostypes.InstanceIdent
+	path      string
+	parameter string
+	refCount  int
+	reached   bool
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// ApplyQuotaFunc applies disk quota to instance storage or state.
+//
+//nolint:gochecknoglobals // used to be overridden in unit tests
+var ApplyQuotaFunc = func(path string, projectID uint32, limit uint64) (DiskQuota, error) {
+	diskQuota, err := quota.Apply(path, projectID, limit)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return diskQuota, nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (launcher *Launcher) applyInstanceQuota(
+	instance *runtimeInstanceInfo, parameter, path string, projectID uint32, limit *uint64,
+) error {
+	if limit == nil || *limit == 0 {
+		return nil
+	}
+
+	launcher.quotaMutex.Lock()
+	defer launcher.quotaMutex.Unlock()
+
+	// Storage and state are shared between instances during rolling update
+	diskQuota, ok := launcher.diskQuotas[path]
+	if !ok {
+		log.WithFields(instanceLogFields(instance, log.Fields{
+			"parameter": parameter, "limit": *limit,
+		})).Debug("Apply instance quota")
+
+		appliedQuota, err := ApplyQuotaFunc(path, projectID, *limit)
+		if err != nil {
+			return err
+		}
+
+		diskQuota = &instanceQuota{
+			DiskQuota: appliedQuota, InstanceIdent: instance.InstanceIdent, path: path, parameter: parameter,
+		}
+
+		launcher.diskQuotas[path] = diskQuota
+	}
+
+	diskQuota.refCount++
+
+	instance.quotas = append(instance.quotas, diskQuota)
+
+	return nil
+}
+
+func (launcher *Launcher) releaseInstanceQuotas(instance *runtimeInstanceInfo) (err error) {
+	launcher.quotaMutex.Lock()
+	defer launcher.quotaMutex.Unlock()
+
+	for _, diskQuota := range instance.quotas {
+		if diskQuota.refCount--; diskQuota.refCount > 0 {
+			continue
+		}
+
+		delete(launcher.diskQuotas, diskQuota.path)
+
+		if releaseErr := diskQuota.Release(); releaseErr != nil && err == nil {
+			err = aoserrors.Wrap(releaseErr)
+		}
+	}
+
+	instance.quotas = nil
+
+	return err
+}
+
+func (launcher *Launcher) checkInstanceQuotas() {
+	launcher.quotaMutex.Lock()
+	defer launcher.quotaMutex.Unlock()
+
+	for _, diskQuota := range launcher.diskQuotas {
+		usage, err := diskQuota.Usage()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path": diskQuota.path, "parameter": diskQuota.parameter,
+			}).Errorf("Can't get quota usage: %v", err)
+
+			continue
+		}
+
+		reached := usage*100 >= diskQuota.Limit()*quotaAlertThreshold
+
+		if reached && !diskQuota.reached {
+			log.WithFields(log.Fields{
+				"serviceID": diskQuota.ServiceID, "subjectID": diskQuota.SubjectID, "instanceIndex": diskQuota.Instance,
+				"parameter": diskQuota.parameter, "usage": usage,
+			}).Warn("Instance quota reached")
+
+			launcher.alertSender.SendAlert(cloudprotocol.AlertItem{
+				Timestamp: time.Now(),
+				Tag:       cloudprotocol.AlertTagInstanceQuota,
+				Payload: cloudprotocol.InstanceQuotaAlert{
+					InstanceIdent: diskQuota.InstanceIdent,
+					Parameter:     diskQuota.parameter,
+					Value:         usage,
+				},
+			})
+		}
+
+		diskQuota.reached = reached
+	}
+}
@@ -0,0 +1,226 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/servicemanager"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// InstanceStateRolledBack instance is active with last known good service version after service update failure.
+const InstanceStateRolledBack = "rolledback"
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type serviceRollback struct {
+	failedVersion uint64
+	service       servicemanager.ServiceInfo
+	err           error
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (launcher *Launcher) applyServiceRollback(serviceID string, service *serviceInfo) {
+	rollback, ok := launcher.rollbacks[serviceID]
+	if !ok {
+		return
+	}
+
+	// New service version is received, forget rollback
+	if service.err != nil || service.AosVersion != rollback.failedVersion {
+		delete(launcher.rollbacks, serviceID)
+
+		return
+	}
+
+	service.ServiceInfo = rollback.service
+	service.rollback = rollback
+}
+
+func (launcher *Launcher) checkServiceRollback(instance *runtimeInstanceInfo) {
+	window := launcher.config.RollbackWindow.Duration
+
+	if window == 0 || instance.runStatus.State != cloudprotocol.InstanceStateFailed ||
+		instance.startTime.IsZero() || time.Since(instance.startTime) > window {
+		return
+	}
+
+	instance.startFailed = true
+
+	if instance.service == nil || instance.service.rollback != nil || instance.prevInstance != nil {
+		return
+	}
+
+	if _, ok := launcher.rollbacks[instance.ServiceID]; ok {
+		return
+	}
+
+	knownGoodVersion, err := launcher.storage.GetKnownGoodServiceVersion(instance.ServiceID)
+	if err != nil {
+		log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't get known good service version: %v", err)
+
+		return
+	}
+
+	if knownGoodVersion == 0 || knownGoodVersion == instance.service.AosVersion {
+		return
+	}
+
+	service, err := launcher.serviceProvider.GetServiceVersionInfo(instance.ServiceID, knownGoodVersion)
+	if err != nil {
+		log.WithFields(instanceLogFields(instance, log.Fields{
+			"aosVersion": knownGoodVersion,
+		})).Warnf("Can't get known good service version: %v", err)
+
+		return
+	}
+
+	log.WithFields(instanceLogFields(instance, log.Fields{
+		"failedVersion":   instance.service.AosVersion,
+		"rollbackVersion": knownGoodVersion,
+	})).Warn("Rollback service")
+
+	rollback := &serviceRollback{
+		failedVersion: instance.service.AosVersion,
+		service:       service,
+		err:           instance.runStatus.Err,
+	}
+
+	launcher.rollbacks[instance.ServiceID] = rollback
+	launcher.rollbackRequested = true
+
+	launcher.alertSender.SendAlert(cloudprotocol.AlertItem{
+		Timestamp: time.Now(),
+		Tag:       cloudprotocol.AlertTagServiceInstance,
+		Payload: cloudprotocol.ServiceInstanceAlert{
+			InstanceIdent: instance.InstanceIdent,
+			AosVersion:    rollback.failedVersion,
+			Message:       rollback.message(),
+		},
+	})
+}
+
+func (launcher *Launcher) takeRollbackRequest() bool {
+	launcher.runMutex.Lock()
+	defer launcher.runMutex.Unlock()
+
+	rollbackRequested := launcher.rollbackRequested
+	launcher.rollbackRequested = false
+
+	return rollbackRequested
+}
+
+// requestRollback wakes up rollbacks handler. Rollback is performed out of channels handler to keep runner statuses
+// handled while instances are restarted.
+func (launcher *Launcher) requestRollback() {
+	select {
+	case launcher.rollbackChannel <- struct{}{}:
+
+	default:
+	}
+}
+
+func (launcher *Launcher) handleRollbacks(ctx context.Context) {
+	defer launcher.handlersWaitGroup.Done()
+
+	for {
+		select {
+		case <-launcher.rollbackChannel:
+			launcher.rollbackServices()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (launcher *Launcher) rollbackServices() {
+	launcher.Lock()
+	defer launcher.Unlock()
+
+	launcher.waitRunInstancesDone()
+
+	if !launcher.takeRollbackRequest() {
+		return
+	}
+
+	instances, err := launcher.storage.GetAllInstances()
+	if err != nil {
+		log.Errorf("Can't get instances: %v", err)
+
+		return
+	}
+
+	if err = launcher.runInstances(instances); err != nil {
+		log.Errorf("Can't rollback services: %v", err)
+	}
+}
+
+func (launcher *Launcher) startKnownGoodTimer(instance *runtimeInstanceInfo) {
+	window := launcher.config.RollbackWindow.Duration
+
+	if window == 0 || instance.service == nil {
+		return
+	}
+
+	instance.knownGoodTimer = time.AfterFunc(window, func() {
+		launcher.runMutex.Lock()
+		defer launcher.runMutex.Unlock()
+
+		if launcher.currentInstances[instance.InstanceID] != instance || instance.startFailed ||
+			instance.runStatus.State != cloudprotocol.InstanceStateActive {
+			return
+		}
+
+		if err := launcher.storage.SetKnownGoodServiceVersion(
+			instance.ServiceID, instance.service.AosVersion); err != nil {
+			log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't set known good service version: %v", err)
+		}
+	})
+}
+
+func (instance *runtimeInstanceInfo) stopKnownGoodTimer() {
+	if instance.knownGoodTimer != nil {
+		instance.knownGoodTimer.Stop()
+	}
+}
+
+func (rollback *serviceRollback) message() string {
+	message := fmt.Sprintf("rolled back to version %d from version %d",
+		rollback.service.AosVersion, rollback.failedVersion)
+
+	if rollback.err != nil {
+		message += ": " + rollback.err.Error()
+	}
+
+	return message
+}
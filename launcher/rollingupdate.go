@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"reflect"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func newRollingUpdateInstance(info InstanceInfo, prevInstance *runtimeInstanceInfo) *runtimeInstanceInfo {
+	info.InstanceID = uuid.New().String()
+
+	instance := newRuntimeInstanceInfo(info)
+	instance.prevInstance = prevInstance
+
+	return instance
+}
+
+func (launcher *Launcher) isRollingUpdate(instance *runtimeInstanceInfo, runInstance InstanceInfo) bool {
+	service, ok := launcher.currentServices[instance.ServiceID]
+	if !ok || service.err != nil || instance.service == nil || instance.service.serviceConfig == nil {
+		return false
+	}
+
+	return service.serviceConfig.UpdateStrategy == UpdateStrategyRolling && !service.isJob() &&
+		instance.service.AosVersion != service.AosVersion &&
+		instance.runStatus.State == cloudprotocol.InstanceStateActive &&
+		instanceInfoEqual(instance.InstanceInfo.InstanceInfo, runInstance.InstanceInfo) &&
+		reflect.DeepEqual(instance.service.serviceConfig.Permissions, service.serviceConfig.Permissions)
+}
+
+func (launcher *Launcher) rollingUpdateInstance(instance *runtimeInstanceInfo) error {
+	prevInstance := instance.prevInstance
+
+	log.WithFields(instanceLogFields(instance, log.Fields{
+		"prevInstanceID": prevInstance.InstanceID,
+	})).Debug("Rolling update instance")
+
+	if err := launcher.startInstance(instance); err != nil || !launcher.isInstanceActive(instance) {
+		if err == nil {
+			err = aoserrors.New("instance is not active")
+		}
+
+		log.WithFields(instanceLogFields(instance, nil)).Errorf(
+			"Can't start new instance version, keep previous one: %v", err)
+
+		if stopErr := launcher.stopInstance(instance); stopErr != nil {
+			log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't stop instance: %v", stopErr)
+		}
+
+		return nil
+	}
+
+	prevInstance.replaced = true
+
+	if err := launcher.stopInstance(prevInstance); err != nil {
+		log.WithFields(instanceLogFields(prevInstance, nil)).Errorf("Can't stop previous instance: %v", err)
+	}
+
+	return launcher.completeRollingUpdate(instance)
+}
+
+func (launcher *Launcher) completeRollingUpdate(instance *runtimeInstanceInfo) (err error) {
+	prevInstance := instance.prevInstance
+
+	defer func() {
+		instance.prevInstance = nil
+		instance.pendingDevices = nil
+	}()
+
+	for _, device := range instance.pendingDevices {
+		if allocateErr := launcher.resourceManager.AllocateDevice(
+			device, instance.InstanceID); allocateErr != nil && err == nil {
+			launcher.alertSender.SendAlert(deviceAllocateAlert(instance, device, allocateErr))
+
+			err = aoserrors.Wrap(allocateErr)
+		}
+	}
+
+	if instance.networkEnabled() {
+		params, paramsErr := launcher.getNetworkParams(instance)
+		if paramsErr != nil && err == nil {
+			err = paramsErr
+		}
+
+		if paramsErr == nil {
+			if networkErr := launcher.networkManager.UpdateInstanceNetwork(
+				instance.InstanceID, instance.service.ServiceProvider, params); networkErr != nil && err == nil {
+				err = aoserrors.Wrap(networkErr)
+			}
+		}
+	}
+
+	if removeErr := launcher.storage.RemoveInstance(prevInstance.InstanceID); removeErr != nil {
+		log.WithFields(instanceLogFields(prevInstance, nil)).Errorf("Can't remove instance: %v", removeErr)
+	}
+
+	if addErr := launcher.storage.AddInstance(instance.InstanceInfo); addErr != nil {
+		log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't add instance: %v", addErr)
+	}
+
+	log.WithFields(instanceLogFields(instance, log.Fields{
+		"prevInstanceID": prevInstance.InstanceID,
+	})).Info("Rolling update completed")
+
+	return err
+}
+
+func (launcher *Launcher) isInstanceActive(instance *runtimeInstanceInfo) bool {
+	launcher.runMutex.Lock()
+	defer launcher.runMutex.Unlock()
+
+	return instance.runStatus.State == cloudprotocol.InstanceStateActive
+}
+
+func (launcher *Launcher) isDeviceAllocatedByPrevInstance(instance *runtimeInstanceInfo, device string) bool {
+	if instance.prevInstance == nil {
+		return false
+	}
+
+	instanceIDs, err := launcher.resourceManager.GetDeviceInstances(device)
+	if err != nil {
+		return false
+	}
+
+	return slices.Contains(instanceIDs, instance.prevInstance.InstanceID)
+}
@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+
+	"github.com/aosedge/aos_servicemanager/runner"
+)
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// getDefaultRunner returns runner used for services without runner in service config: the first runner feature
+// with registered runner or the only registered runner if runner features are not configured.
+func (launcher *Launcher) getDefaultRunner() string {
+	for _, feature := range launcher.config.RunnerFeatures {
+		if _, ok := launcher.instanceRunners[feature]; ok {
+			return feature
+		}
+	}
+
+	if len(launcher.config.RunnerFeatures) == 0 && len(launcher.instanceRunners) == 1 {
+		for name := range launcher.instanceRunners {
+			return name
+		}
+	}
+
+	return ""
+}
+
+func (launcher *Launcher) getServiceRunner(serviceConfig *ServiceConfig) (string, InstanceRunner, error) {
+	runnerName := serviceConfig.Runner
+
+	if runnerName == "" {
+		if runnerName = launcher.getDefaultRunner(); runnerName == "" {
+			return "", nil, aoserrors.New("no default runner available")
+		}
+	}
+
+	if len(launcher.config.RunnerFeatures) != 0 && !slices.Contains(launcher.config.RunnerFeatures, runnerName) {
+		return "", nil, aoserrors.Errorf("runner %s is not supported by node", runnerName)
+	}
+
+	instanceRunner, ok := launcher.instanceRunners[runnerName]
+	if !ok {
+		return "", nil, aoserrors.Errorf("runner %s is not available", runnerName)
+	}
+
+	return runnerName, instanceRunner, nil
+}
+
+// getLaunchedRunner returns runner which launched stored instance. Service runner is returned if the instance
+// runner is unknown.
+func (launcher *Launcher) getLaunchedRunner(instance *runtimeInstanceInfo) (string, InstanceRunner) {
+	data, err := os.ReadFile(filepath.Join(instance.runtimeDir, instanceRunnerFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithFields(instanceLogFields(instance, nil)).Warnf("Can't read instance runner: %v", err)
+		}
+
+		return instance.service.runnerName, instance.service.runner
+	}
+
+	runnerName := string(data)
+
+	instanceRunner, ok := launcher.instanceRunners[runnerName]
+	if !ok {
+		log.WithFields(instanceLogFields(instance, log.Fields{
+			"runner": runnerName,
+		})).Warn("Instance runner is not available")
+
+		return instance.service.runnerName, instance.service.runner
+	}
+
+	return runnerName, instanceRunner
+}
+
+// handleRunnerStatuses routes instance statuses of all registered runners to one status channel.
+func (launcher *Launcher) handleRunnerStatuses(ctx context.Context) {
+	handledRunners := make([]InstanceRunner, 0, len(launcher.instanceRunners))
+
+	for _, instanceRunner := range launcher.instanceRunners {
+		// The same runner may be registered for different runner names
+		if slices.Contains(handledRunners, instanceRunner) {
+			continue
+		}
+
+		handledRunners = append(handledRunners, instanceRunner)
+
+		launcher.handlersWaitGroup.Add(1)
+
+		go func(statusChannel <-chan []runner.InstanceStatus) {
+			defer launcher.handlersWaitGroup.Done()
+
+			for {
+				select {
+				case instances := <-statusChannel:
+					select {
+					case launcher.runnerStatusChannel <- instances:
+
+					case <-ctx.Done():
+						return
+					}
+
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(instanceRunner.InstanceStatusChannel())
+	}
+}
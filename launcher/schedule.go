@@ -0,0 +1,398 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/aostypes"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/runner"
+	"github.com/aosedge/aos_servicemanager/utils/cron"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Missed runs policies.
+const (
+	MissedRunsSkip    = "skip"
+	MissedRunsRunOnce = "runOnce"
+)
+
+// Scheduled run results.
+const (
+	ScheduledRunCompleted = "completed"
+	ScheduledRunFailed    = "failed"
+	ScheduledRunTimeout   = "timeout"
+	ScheduledRunMissed    = "missed"
+)
+
+const maxScheduledRuns = 100
+
+// Scheduled time is checked by wall clock with this period as timers don't count node suspend and don't follow
+// system clock change. Wake up later than this period means runs scheduled meanwhile are missed.
+const scheduleCheckPeriod = 1 * time.Minute
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// Schedule service schedule parameters. Instances of scheduled service are started at each time matching cron
+// expression and are stopped when they exit or max runtime is reached. Missed runs policy defines what to do with
+// runs missed while the node was off: skip them or run once on start.
+type Schedule struct {
+	Cron       string            `json:"cron"`
+	MaxRuntime aostypes.Duration `json:"maxRuntime,omitempty"`
+	MissedRuns string            `json:"missedRuns,omitempty"`
+}
+
+// ScheduledRun scheduled instance run result.
+type ScheduledRun struct {
+	aostypes.InstanceIdent
+	InstanceID    string
+	ScheduledTime time.Time
+	StartTime     time.Time
+	EndTime       time.Time
+	Result        string
+	ExitCode      int
+	Message       string
+}
+
+type instanceScheduler struct {
+	cancelFunction context.CancelFunc
+	doneChannel    chan struct{}
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var errMaxRuntime = errors.New("max runtime exceeded")
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// startSchedule starts scheduled instance runs. Between runs the instance is inactive and its runtime is not set up.
+func (launcher *Launcher) startSchedule(instance *runtimeInstanceInfo) error {
+	schedule := instance.service.serviceConfig.Schedule
+
+	expression, err := cron.Parse(schedule.Cron)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if schedule.MissedRuns != "" && schedule.MissedRuns != MissedRunsSkip &&
+		schedule.MissedRuns != MissedRunsRunOnce {
+		return aoserrors.Errorf("unsupported missed runs policy: %s", schedule.MissedRuns)
+	}
+
+	log.WithFields(instanceLogFields(instance, log.Fields{"cron": schedule.Cron})).Info("Instance scheduled")
+
+	launcher.runMutex.Lock()
+	defer launcher.runMutex.Unlock()
+
+	ctx, cancelFunction := context.WithCancel(context.Background())
+
+	instance.runStatus = runner.InstanceStatus{
+		InstanceID: instance.InstanceID, State: cloudprotocol.InstanceStateInactive,
+	}
+	instance.scheduler = &instanceScheduler{cancelFunction: cancelFunction, doneChannel: make(chan struct{})}
+
+	go launcher.runSchedule(ctx, instance, expression, instance.scheduler.doneChannel)
+
+	return nil
+}
+
+func (launcher *Launcher) stopSchedule(instance *runtimeInstanceInfo) {
+	launcher.runMutex.Lock()
+	scheduler := instance.scheduler
+	launcher.runMutex.Unlock()
+
+	if scheduler == nil {
+		return
+	}
+
+	scheduler.cancelFunction()
+	<-scheduler.doneChannel
+
+	launcher.runMutex.Lock()
+	instance.scheduler = nil
+	launcher.runMutex.Unlock()
+}
+
+func (launcher *Launcher) runSchedule(
+	ctx context.Context, instance *runtimeInstanceInfo, expression *cron.Expression, doneChannel chan<- struct{},
+) {
+	defer close(doneChannel)
+
+	scheduledTime, runNow := launcher.checkMissedRuns(instance, expression, launcher.getLastScheduledTime(instance))
+
+	for {
+		if !runNow {
+			if scheduledTime = expression.Next(time.Now()); scheduledTime.IsZero() {
+				log.WithFields(instanceLogFields(instance, nil)).Warn("Instance schedule has no next run")
+
+				return
+			}
+
+			log.WithFields(instanceLogFields(instance, log.Fields{
+				"scheduledTime": scheduledTime,
+			})).Debug("Wait scheduled run")
+
+			if !waitScheduledTime(ctx, scheduledTime) {
+				return
+			}
+
+			// Node suspend or clock jump delays wake up, runs scheduled during the delay are missed
+			if time.Since(scheduledTime) > scheduleCheckPeriod {
+				if scheduledTime, runNow = launcher.checkMissedRuns(
+					instance, expression, scheduledTime.Add(-time.Nanosecond)); !runNow {
+					continue
+				}
+			}
+		}
+
+		runNow = false
+
+		if !launcher.runScheduledInstance(ctx, instance, scheduledTime) {
+			return
+		}
+	}
+}
+
+// runScheduledInstance runs instance till it exits or max runtime is reached. Returns false if schedule is stopped
+// during the run, in this case the instance is stopped by stopInstance.
+func (launcher *Launcher) runScheduledInstance(
+	ctx context.Context, instance *runtimeInstanceInfo, scheduledTime time.Time,
+) bool {
+	run := ScheduledRun{
+		InstanceIdent: instance.InstanceIdent,
+		InstanceID:    instance.InstanceID,
+		ScheduledTime: scheduledTime,
+		StartTime:     time.Now(),
+	}
+
+	runDone := make(chan struct{})
+
+	launcher.runMutex.Lock()
+	instance.runStatus = runner.InstanceStatus{InstanceID: instance.InstanceID}
+	instance.runDone = runDone
+	launcher.runMutex.Unlock()
+
+	log.WithFields(instanceLogFields(instance, log.Fields{"scheduledTime": scheduledTime})).Info("Start scheduled run")
+
+	var runErr error
+
+	if err := launcher.launchInstance(instance); err != nil {
+		runErr = err
+	} else {
+		launcher.runMutex.Lock()
+		updateStatus := launcher.getUpdateStatus(instance)
+		launcher.runMutex.Unlock()
+
+		launcher.sendUpdateStatus(updateStatus)
+
+		var maxRuntime <-chan time.Time
+
+		if duration := instance.service.serviceConfig.Schedule.MaxRuntime.Duration; duration != 0 {
+			timer := time.NewTimer(duration)
+			defer timer.Stop()
+
+			maxRuntime = timer.C
+		}
+
+		select {
+		case <-runDone:
+
+		case <-maxRuntime:
+			runErr = errMaxRuntime
+
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	launcher.stopHealthCheck(instance)
+
+	if err := launcher.shutdownInstance(instance); err != nil {
+		log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't stop scheduled instance: %v", err)
+	}
+
+	launcher.runMutex.Lock()
+
+	instance.runDone = nil
+	instance.stopKnownGoodTimer()
+
+	var updateStatus *InstancesStatus
+
+	if runErr != nil {
+		launcher.instanceFailed(instance, runErr)
+
+		updateStatus = launcher.getUpdateStatus(instance)
+	}
+
+	run.EndTime = time.Now()
+	run.ExitCode = instance.runStatus.ExitCode
+
+	switch {
+	case errors.Is(runErr, errMaxRuntime):
+		run.Result = ScheduledRunTimeout
+
+	case instance.runStatus.State == runner.InstanceStateCompleted:
+		run.Result = ScheduledRunCompleted
+
+	default:
+		run.Result = ScheduledRunFailed
+	}
+
+	if instance.runStatus.Err != nil {
+		run.Message = instance.runStatus.Err.Error()
+	}
+
+	launcher.runMutex.Unlock()
+
+	launcher.sendUpdateStatus(updateStatus)
+
+	log.WithFields(instanceLogFields(instance, log.Fields{
+		"result": run.Result, "exitCode": run.ExitCode,
+	})).Info("Scheduled run finished")
+
+	if err := launcher.storage.AddScheduledRun(run, maxScheduledRuns); err != nil {
+		log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't add scheduled run: %v", err)
+	}
+
+	return true
+}
+
+// checkMissedRuns checks if scheduled runs were missed since the given time, e.g. while the node was off or
+// suspended. Returns the latest missed run time if it should be run now according to missed runs policy.
+func (launcher *Launcher) checkMissedRuns(
+	instance *runtimeInstanceInfo, expression *cron.Expression, since time.Time,
+) (scheduledTime time.Time, runNow bool) {
+	if since.IsZero() {
+		return time.Time{}, false
+	}
+
+	now := time.Now()
+
+	var missedTime time.Time
+
+	for next := expression.Next(since); !next.IsZero() && next.Before(now); {
+		missedTime = next
+		next = expression.Next(next)
+	}
+
+	if missedTime.IsZero() {
+		return time.Time{}, false
+	}
+
+	policy := instance.service.serviceConfig.Schedule.MissedRuns
+
+	log.WithFields(instanceLogFields(instance, log.Fields{
+		"scheduledTime": missedTime, "policy": policy,
+	})).Warn("Scheduled run missed")
+
+	if policy == MissedRunsRunOnce {
+		return missedTime, true
+	}
+
+	if err := launcher.storage.AddScheduledRun(ScheduledRun{
+		InstanceIdent: instance.InstanceIdent,
+		InstanceID:    instance.InstanceID,
+		ScheduledTime: missedTime,
+		Result:        ScheduledRunMissed,
+	}, maxScheduledRuns); err != nil {
+		log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't add scheduled run: %v", err)
+	}
+
+	return time.Time{}, false
+}
+
+// scheduledRunDone notifies scheduler that current run is finished.
+func (launcher *Launcher) scheduledRunDone(instance *runtimeInstanceInfo) {
+	if instance.runDone == nil {
+		return
+	}
+
+	close(instance.runDone)
+	instance.runDone = nil
+}
+
+// getLastScheduledTime returns scheduled time of the last instance run or zero time if the instance has no runs.
+func (launcher *Launcher) getLastScheduledTime(instance *runtimeInstanceInfo) time.Time {
+	lastRun, err := launcher.storage.GetLastScheduledRun(instance.InstanceIdent)
+	if err != nil {
+		if !errors.Is(err, ErrNotExist) {
+			log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't get last scheduled run: %v", err)
+		}
+
+		return time.Time{}
+	}
+
+	return lastRun.ScheduledTime
+}
+
+// getUpdateStatus returns instance update status. It should be called with run mutex taken and sent by
+// sendUpdateStatus after the mutex is released to not block runner statuses handling.
+func (launcher *Launcher) getUpdateStatus(instance *runtimeInstanceInfo) *InstancesStatus {
+	if launcher.runInstancesInProgress {
+		return nil
+	}
+
+	return &InstancesStatus{Instances: []cloudprotocol.InstanceStatus{instance.getCloudStatus()}}
+}
+
+func (launcher *Launcher) sendUpdateStatus(updateStatus *InstancesStatus) {
+	if updateStatus == nil {
+		return
+	}
+
+	launcher.runtimeStatusChannel <- RuntimeStatus{UpdateStatus: updateStatus}
+}
+
+// waitScheduledTime waits till scheduled time by wall clock. Returns false if the schedule is stopped.
+func waitScheduledTime(ctx context.Context, scheduledTime time.Time) bool {
+	timer := time.NewTimer(time.Until(scheduledTime))
+	defer timer.Stop()
+
+	ticker := time.NewTicker(scheduleCheckPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+
+		case <-ticker.C:
+
+		case <-ctx.Done():
+			return false
+		}
+
+		if !time.Now().Before(scheduledTime) {
+			return true
+		}
+	}
+}
@@ -28,14 +28,47 @@ import (
 	"github.com/aosedge/aos_servicemanager/servicemanager"
 )
 
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Service update strategies.
+const (
+	UpdateStrategyRestart = "restart"
+	UpdateStrategyRolling = "rolling"
+)
+
+// Instance kinds.
+const (
+	InstanceKindService = "service"
+	InstanceKindJob     = "job"
+)
+
 /***********************************************************************************************************************
  * Types
  **********************************************************************************************************************/
 
+// ServiceConfig service config extended with launcher specific parameters.
+type ServiceConfig struct {
+	aostypes.ServiceConfig
+	UpdateStrategy string              `json:"updateStrategy,omitempty"`
+	HealthCheck    *HealthCheck        `json:"healthCheck,omitempty"`
+	StopParameters *StopParameters     `json:"stopParameters,omitempty"`
+	Checkpoint     bool                `json:"checkpoint,omitempty"`
+	Dependencies   []ServiceDependency `json:"dependencies,omitempty"`
+	Kind           string              `json:"kind,omitempty"`
+	InitSteps      []InitStep          `json:"initSteps,omitempty"`
+	Schedule       *Schedule           `json:"schedule,omitempty"`
+	Sidecars       []Sidecar           `json:"sidecars,omitempty"`
+}
+
 type serviceInfo struct {
 	servicemanager.ServiceInfo
-	serviceConfig *aostypes.ServiceConfig
+	serviceConfig *ServiceConfig
 	imageConfig   *imagespec.Image
+	rollback      *serviceRollback
+	runnerName    string
+	runner        InstanceRunner
 	err           error
 }
 
@@ -58,39 +91,68 @@ func (launcher *Launcher) cacheCurrentServices(instances []InstanceInfo) {
 	launcher.currentServices = make(map[string]*serviceInfo)
 
 	for _, instance := range instances {
-		if _, ok := launcher.currentServices[instance.ServiceID]; ok {
-			continue
-		}
+		launcher.cacheService(instance.ServiceID, now)
+	}
 
-		var service serviceInfo
+	// Sidecar services are not instantiated by themselves but run alongside instances of other services
+	var sidecars []Sidecar
 
-		if service.ServiceInfo, service.err = launcher.serviceProvider.GetServiceInfo(
-			instance.ServiceID); errors.Is(service.err, servicemanager.ErrNotExist) {
-			service.ServiceID = instance.ServiceID
-			service.AosVersion = 0
+	for _, service := range launcher.currentServices {
+		if service.serviceConfig != nil {
+			sidecars = append(sidecars, service.serviceConfig.Sidecars...)
 		}
+	}
 
-		if service.err == nil {
-			service.serviceConfig, service.err = launcher.getServiceConfig(service.ServiceInfo)
-		}
+	for _, sidecar := range sidecars {
+		launcher.cacheService(sidecar.ServiceID, now)
+	}
+}
 
-		if service.err == nil {
-			if service.serviceConfig.OfflineTTL.Duration != 0 &&
-				launcher.onlineTime.Add(service.serviceConfig.OfflineTTL.Duration).Before(now) {
-				service.err = errOfflineTimeout
-			}
-		}
+func (launcher *Launcher) cacheService(serviceID string, now time.Time) {
+	if _, ok := launcher.currentServices[serviceID]; ok {
+		return
+	}
 
-		if service.err == nil {
-			service.imageConfig, service.err = launcher.getImageConfig(service.ServiceInfo)
-		}
+	var service serviceInfo
+
+	if service.ServiceInfo, service.err = launcher.serviceProvider.GetServiceInfo(
+		serviceID); errors.Is(service.err, servicemanager.ErrNotExist) {
+		service.ServiceID = serviceID
+		service.AosVersion = 0
+	}
 
-		if service.err == nil {
-			service.err = launcher.serviceProvider.ValidateService(service.ServiceInfo)
+	launcher.applyServiceRollback(serviceID, &service)
+
+	if service.err == nil {
+		service.serviceConfig, service.err = launcher.getServiceConfig(service.ServiceInfo)
+	}
+
+	if service.err == nil {
+		if service.serviceConfig.OfflineTTL.Duration != 0 &&
+			launcher.onlineTime.Add(service.serviceConfig.OfflineTTL.Duration).Before(now) {
+			service.err = errOfflineTimeout
 		}
+	}
 
-		launcher.currentServices[instance.ServiceID] = &service
+	if service.err == nil {
+		service.runnerName, service.runner, service.err = launcher.getServiceRunner(service.serviceConfig)
 	}
+
+	if service.err == nil {
+		service.imageConfig, service.err = launcher.getImageConfig(service.ServiceInfo)
+	}
+
+	if service.err == nil {
+		service.err = launcher.serviceProvider.ValidateService(service.ServiceInfo)
+	}
+
+	launcher.currentServices[serviceID] = &service
+}
+
+// isJob returns true if service instances run once and are not restarted. Scheduled instances are jobs as well.
+func (service *serviceInfo) isJob() bool {
+	return service.serviceConfig != nil &&
+		(service.serviceConfig.Kind == InstanceKindJob || service.serviceConfig.Schedule != nil)
 }
 
 func (launcher *Launcher) getCurrentServiceInfo(serviceID string) (*serviceInfo, error) {
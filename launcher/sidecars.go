@@ -0,0 +1,312 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	"github.com/opencontainers/runc/libcontainer/specconv"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/runner"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const sidecarContainerIDTemplate = "%s-sidecar%d"
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// Sidecar installed service which runs alongside each instance of the declaring service. Sidecar container shares
+// the instance network namespace and, optionally, the instance storage. It is started after the instance and is
+// stopped before it. The instance and its sidecars are handled as a pair: sidecar failure fails the instance and
+// the pair is restarted together by crash loop restart.
+type Sidecar struct {
+	ServiceID    string `json:"serviceId"`
+	ShareStorage bool   `json:"shareStorage,omitempty"`
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// startSidecars starts instance sidecars by the sidecar service runners. Instance fails if any sidecar can't be
+// started, in this case the instance and already started sidecars are stopped.
+func (launcher *Launcher) startSidecars(instance *runtimeInstanceInfo) (err error) {
+	sidecars := instance.service.serviceConfig.Sidecars
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		if stopErr := launcher.stopSidecars(instance); stopErr != nil {
+			log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't stop sidecars: %v", stopErr)
+		}
+
+		if stopErr := launcher.stopRuntimeInstance(instance); stopErr != nil {
+			log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't stop instance: %v", stopErr)
+		}
+	}()
+
+	if len(sidecars) > 0 && !instance.networkEnabled() {
+		return aoserrors.Errorf("sidecars are not supported by runner %s", instance.runnerName)
+	}
+
+	for i, sidecar := range sidecars {
+		log.WithFields(instanceLogFields(instance, log.Fields{"sidecar": sidecar.ServiceID})).Debug("Start sidecar")
+
+		if err = launcher.startSidecar(instance, i, sidecar); err != nil {
+			return aoserrors.Errorf("can't start sidecar %s: %v", sidecar.ServiceID, err)
+		}
+	}
+
+	return nil
+}
+
+func (launcher *Launcher) startSidecar(instance *runtimeInstanceInfo, index int, sidecar Sidecar) error {
+	launcher.runMutex.Lock()
+	service, err := launcher.getCurrentServiceInfo(sidecar.ServiceID)
+	launcher.runMutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// Devices are allocated per instance and can't be shared with sidecar
+	if len(service.serviceConfig.Devices) > 0 {
+		return aoserrors.New("sidecar service can't use devices")
+	}
+
+	// Sidecar joins the instance network namespace
+	if service.runnerName == runxRunner {
+		return aoserrors.Errorf("sidecar runner %s is not supported", service.runnerName)
+	}
+
+	containerID := getSidecarContainerID(instance, index)
+	runtimeDir := filepath.Join(RuntimeDir, containerID)
+
+	if err = os.MkdirAll(runtimeDir, 0o755); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	// Runner is stored to stop the sidecar by the same runner if sidecar service changes runner
+	if err = os.WriteFile(
+		filepath.Join(runtimeDir, instanceRunnerFile), []byte(service.runnerName), 0o600); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	spec, err := launcher.createSidecarSpec(instance, service, sidecar, containerID, runtimeDir)
+	if err != nil {
+		return err
+	}
+
+	if err = launcher.prepareRootFS(service, runtimeDir, spec); err != nil {
+		return err
+	}
+
+	status := service.runner.StartInstance(containerID, runtimeDir, runner.RunParameters{
+		StartInterval:   service.serviceConfig.RunParameters.StartInterval.Duration,
+		StartBurst:      service.serviceConfig.RunParameters.StartBurst,
+		RestartInterval: service.serviceConfig.RunParameters.RestartInterval.Duration,
+	})
+	if status.State != cloudprotocol.InstanceStateActive {
+		if status.Err != nil {
+			return aoserrors.Wrap(status.Err)
+		}
+
+		return aoserrors.Errorf("sidecar state: %s", status.State)
+	}
+
+	return nil
+}
+
+// createSidecarSpec creates sidecar runtime spec from the sidecar service image and config. Sidecar runs with the
+// instance UID, environment and network namespace.
+func (launcher *Launcher) createSidecarSpec(
+	instance *runtimeInstanceInfo, service *serviceInfo, sidecar Sidecar, containerID, runtimeDir string,
+) (*runtimeSpec, error) {
+	spec := &runtimeSpec{
+		resourceManager: launcher.resourceManager,
+		ociSpec:         *specconv.Example(),
+	}
+
+	spec.ociSpec.Process.Args = nil
+	spec.ociSpec.Process.Terminal = false
+	spec.ociSpec.Linux.CgroupsPath = cgroupsPath + containerID
+
+	spec.setRootfs(filepath.Join(runtimeDir, instanceRootFS))
+	spec.bindHostDirs(launcher.config.WorkingDir)
+	spec.setNamespacePath(runtimespec.NetworkNamespace, launcher.networkManager.GetNetnsPath(instance.InstanceID))
+	spec.mergeEnv(createAosEnvVars(instance))
+
+	// Sidecar resolves host names the same way as the instance
+	for _, networkFile := range []string{"hosts", "resolv.conf"} {
+		filePath := filepath.Join(instance.runtimeDir, instanceMountPointsDir, "etc", networkFile)
+
+		if _, err := os.Stat(filePath); err != nil {
+			continue
+		}
+
+		if err := spec.addBindMount(filePath, path.Join("/etc", networkFile), "ro"); err != nil {
+			return nil, err
+		}
+	}
+
+	if sidecar.ShareStorage && instance.StoragePath != "" {
+		if err := spec.addBindMount(
+			launcher.getAbsStoragePath(instance.StoragePath), instanceStorageDir, "rw"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := spec.setUserUIDGID(instance.UID, service.GID); err != nil {
+		return nil, err
+	}
+
+	if err := spec.applyImageConfig(service.imageConfig); err != nil {
+		return nil, err
+	}
+
+	if err := spec.applyServiceConfig(service.serviceConfig); err != nil {
+		return nil, err
+	}
+
+	spec.mergeEnv(instance.overrideEnvVars)
+
+	if err := spec.save(filepath.Join(runtimeDir, runtimeConfigFile)); err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+// stopSidecars stops instance sidecars in reverse order and removes their runtime.
+func (launcher *Launcher) stopSidecars(instance *runtimeInstanceInfo) (err error) {
+	if instance.service.serviceConfig == nil {
+		return nil
+	}
+
+	for i := len(instance.service.serviceConfig.Sidecars) - 1; i >= 0; i-- {
+		containerID := getSidecarContainerID(instance, i)
+		runtimeDir := filepath.Join(RuntimeDir, containerID)
+
+		// Sidecar is not started
+		if _, errStat := os.Stat(runtimeDir); errStat != nil {
+			continue
+		}
+
+		log.WithFields(instanceLogFields(instance, log.Fields{
+			"sidecar": instance.service.serviceConfig.Sidecars[i].ServiceID,
+		})).Debug("Stop sidecar")
+
+		if stopErr := launcher.getSidecarRunner(instance, runtimeDir).StopInstance(
+			containerID); stopErr != nil && err == nil {
+			err = aoserrors.Wrap(stopErr)
+		}
+
+		mountPoint := filepath.Join(runtimeDir, instanceRootFS)
+
+		if _, errStat := os.Stat(mountPoint); errStat == nil {
+			if unmountErr := UnmountFunc(mountPoint); unmountErr != nil && err == nil {
+				err = aoserrors.Wrap(unmountErr)
+			}
+		}
+
+		if removeErr := os.RemoveAll(runtimeDir); removeErr != nil && err == nil {
+			err = aoserrors.Wrap(removeErr)
+		}
+	}
+
+	return err
+}
+
+// getSidecarRunner returns runner which started the sidecar. Instance runner is returned if the sidecar runner is
+// unknown.
+func (launcher *Launcher) getSidecarRunner(instance *runtimeInstanceInfo, runtimeDir string) InstanceRunner {
+	data, err := os.ReadFile(filepath.Join(runtimeDir, instanceRunnerFile))
+	if err != nil {
+		return instance.runner
+	}
+
+	sidecarRunner, ok := launcher.instanceRunners[string(data)]
+	if !ok {
+		return instance.runner
+	}
+
+	return sidecarRunner
+}
+
+// sidecarStatusChanged handles runner status of sidecar container. Failed sidecar fails its active instance, the
+// instance is returned to report its changed status. Returns false if the status doesn't belong to any sidecar.
+func (launcher *Launcher) sidecarStatusChanged(
+	status runner.InstanceStatus,
+) (changedInstance *runtimeInstanceInfo, isSidecar bool) {
+	for _, instance := range launcher.currentInstances {
+		if instance.service == nil || instance.service.serviceConfig == nil {
+			continue
+		}
+
+		for i, sidecar := range instance.service.serviceConfig.Sidecars {
+			if getSidecarContainerID(instance, i) != status.InstanceID {
+				continue
+			}
+
+			logFields := instanceLogFields(instance, log.Fields{"sidecar": sidecar.ServiceID, "state": status.State})
+
+			if status.State != cloudprotocol.InstanceStateFailed {
+				log.WithFields(logFields).Debug("Sidecar state changed")
+
+				return nil, true
+			}
+
+			log.WithFields(logFields).Warnf("Sidecar failed: %v", status.Err)
+
+			if instance.stopping || instance.runStatus.State != cloudprotocol.InstanceStateActive {
+				return nil, true
+			}
+
+			err := aoserrors.Errorf("sidecar %s failed", sidecar.ServiceID)
+			if status.Err != nil {
+				err = aoserrors.Errorf("sidecar %s failed: %v", sidecar.ServiceID, status.Err)
+			}
+
+			launcher.instanceFailed(instance, err)
+			launcher.instanceStateChanged(instance)
+			launcher.notifyDependencies()
+
+			return instance, true
+		}
+	}
+
+	return nil, false
+}
+
+func getSidecarContainerID(instance *runtimeInstanceInfo, index int) string {
+	return fmt.Sprintf(sidecarContainerIDTemplate, instance.InstanceID, index)
+}
@@ -127,7 +127,7 @@ func (spec *runtimeSpec) setCPULimit(cpuLimit uint64) {
 	spec.ociSpec.Linux.Resources.CPU.Quota = &cpuQuota
 }
 
-func (spec *runtimeSpec) applyServiceConfig(config *aostypes.ServiceConfig) error {
+func (spec *runtimeSpec) applyServiceConfig(config *ServiceConfig) error {
 	if config.Hostname != nil {
 		spec.ociSpec.Hostname = *config.Hostname
 	}
@@ -515,13 +515,13 @@ func (launcher *Launcher) getImageConfig(service servicemanager.ServiceInfo) (*i
 	return &imageConfig, nil
 }
 
-func (launcher *Launcher) getServiceConfig(service servicemanager.ServiceInfo) (*aostypes.ServiceConfig, error) {
+func (launcher *Launcher) getServiceConfig(service servicemanager.ServiceInfo) (*ServiceConfig, error) {
 	imageParts, err := launcher.serviceProvider.GetImageParts(service)
 	if err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
 
-	var serviceConfig aostypes.ServiceConfig
+	var serviceConfig ServiceConfig
 
 	if imageParts.ServiceConfigPath != "" {
 		if err = getJSONFromFile(
@@ -555,6 +555,11 @@ func (launcher *Launcher) createRuntimeSpec(instance *runtimeInstanceInfo) (*run
 			return nil, err
 		}
 
+		if err := launcher.applyInstanceQuota(instance, stateQuotaParameter, absStatePath,
+			instance.UID+stateProjectIDOffset, instance.service.serviceConfig.Quotas.StateLimit); err != nil {
+			return nil, err
+		}
+
 		if err := spec.addBindMount(absStatePath, instanceStateFile, "rw"); err != nil {
 			return nil, err
 		}
@@ -567,6 +572,11 @@ func (launcher *Launcher) createRuntimeSpec(instance *runtimeInstanceInfo) (*run
 			return nil, err
 		}
 
+		if err := launcher.applyInstanceQuota(instance, storageQuotaParameter, absStoragePath,
+			instance.UID, instance.service.serviceConfig.Quotas.StorageLimit); err != nil {
+			return nil, err
+		}
+
 		if err := spec.addBindMount(absStoragePath, instanceStorageDir, "rw"); err != nil {
 			return nil, err
 		}
@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/aostypes"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/aosedge/aos_servicemanager/runner"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const stopForcedMessage = "instance stop forced after timeout"
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// StopParameters service instance stop parameters.
+type StopParameters struct {
+	Signal  string            `json:"signal,omitempty"`
+	Timeout aostypes.Duration `json:"timeout,omitempty"`
+	PreStop []string          `json:"preStop,omitempty"`
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (launcher *Launcher) getRunParameters(instance *runtimeInstanceInfo) (params runner.RunParameters, err error) {
+	serviceConfig := instance.service.serviceConfig
+
+	params = runner.RunParameters{
+		StartInterval:   serviceConfig.RunParameters.StartInterval.Duration,
+		StartBurst:      serviceConfig.RunParameters.StartBurst,
+		RestartInterval: serviceConfig.RunParameters.RestartInterval.Duration,
+	}
+
+	switch serviceConfig.Kind {
+	case "", InstanceKindService:
+
+	case InstanceKindJob:
+		params.OneShot = true
+
+	default:
+		return params, aoserrors.Errorf("unsupported instance kind: %s", serviceConfig.Kind)
+	}
+
+	if serviceConfig.Schedule != nil {
+		params.OneShot = true
+	}
+
+	var stopSignal string
+
+	if instance.service.imageConfig != nil {
+		stopSignal = instance.service.imageConfig.Config.StopSignal
+	}
+
+	if serviceConfig.StopParameters != nil {
+		if serviceConfig.StopParameters.Signal != "" {
+			stopSignal = serviceConfig.StopParameters.Signal
+		}
+
+		params.StopTimeout = serviceConfig.StopParameters.Timeout.Duration
+		params.PreStop = serviceConfig.StopParameters.PreStop
+	}
+
+	if stopSignal != "" {
+		if params.StopSignal, err = parseSignal(stopSignal); err != nil {
+			return params, err
+		}
+	}
+
+	return params, nil
+}
+
+func (launcher *Launcher) stopRuntimeInstance(instance *runtimeInstanceInfo) error {
+	err := instance.runner.StopInstance(instance.InstanceID)
+	if err == nil {
+		return nil
+	}
+
+	if !errors.Is(err, runner.ErrStopForced) {
+		return aoserrors.Wrap(err)
+	}
+
+	log.WithFields(instanceLogFields(instance, nil)).Warn("Instance stop forced")
+
+	var aosVersion uint64
+
+	if instance.service != nil {
+		aosVersion = instance.service.AosVersion
+	}
+
+	launcher.alertSender.SendAlert(cloudprotocol.AlertItem{
+		Timestamp: time.Now(),
+		Tag:       cloudprotocol.AlertTagServiceInstance,
+		Payload: cloudprotocol.ServiceInstanceAlert{
+			InstanceIdent: instance.InstanceIdent,
+			AosVersion:    aosVersion,
+			Message:       stopForcedMessage,
+		},
+	})
+
+	return nil
+}
+
+// parseSignal parses signal in the image config format: signal name with or without SIG prefix or signal number.
+func parseSignal(value string) (syscall.Signal, error) {
+	if number, err := strconv.ParseUint(value, 10, 8); err == nil {
+		if unix.SignalName(syscall.Signal(number)) == "" {
+			return 0, aoserrors.Errorf("invalid stop signal: %s", value)
+		}
+
+		return syscall.Signal(number), nil
+	}
+
+	name := strings.ToUpper(value)
+
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	signal := unix.SignalNum(name)
+	if signal == 0 {
+		return 0, aoserrors.Errorf("invalid stop signal: %s", value)
+	}
+
+	return signal, nil
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package layermanager
+
+import (
+	"fmt"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/eviction"
+)
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// UseLayer sets layer last use time. It should be called when instance of service which uses the layer is started.
+func (layermanager *LayerManager) UseLayer(digest string) error {
+	return layermanager.evictionPolicy.SetUsed(digest)
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// addOutdatedLayer adds cached layer to allocator outdated items. Allocator removes outdated items in timestamp
+// order, so layer eviction time is used as item timestamp. Pinned layers are never added.
+func (layermanager *LayerManager) addOutdatedLayer(layer LayerInfo) (err error) {
+	if layermanager.evictionPolicy.IsPinned(layer.LayerID, layer.AosVersion) {
+		log.WithFields(log.Fields{"id": layer.LayerID, "digest": layer.Digest}).Debug("Cached layer is pinned")
+
+		return nil
+	}
+
+	item := evictionItem(layer)
+
+	if item.LastUsed, err = layermanager.evictionPolicy.GetLastUsed(layer.Digest); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err = layermanager.layerAllocator.AddOutdatedItem(
+		layer.Digest, layer.Size, layermanager.evictionPolicy.EvictionTime(item)); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+// removeOutdatedLayer removes outdated layer on allocator request.
+func (layermanager *LayerManager) removeOutdatedLayer(digest string) error {
+	layer, err := layermanager.layerStorage.GetLayerInfoByDigest(digest)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err = layermanager.removeLayer(digest); err != nil {
+		return err
+	}
+
+	layermanager.evictionPolicy.AddEvicted(evictionItem(layer), eviction.ReasonNoSpace)
+
+	return nil
+}
+
+// ensureMinFreeSpace evicts cached layers until configured min free space is available.
+func (layermanager *LayerManager) ensureMinFreeSpace() error {
+	layers, err := layermanager.layerStorage.GetLayersInfo()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	var items []eviction.Item
+
+	cachedLayers := make(map[string]LayerInfo)
+
+	for _, layer := range layers {
+		if !layer.Cached {
+			continue
+		}
+
+		item := evictionItem(layer)
+
+		if item.LastUsed, err = layermanager.evictionPolicy.GetLastUsed(layer.Digest); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		items = append(items, item)
+		cachedLayers[fmt.Sprintf("%s_%d", layer.LayerID, layer.AosVersion)] = layer
+	}
+
+	return aoserrors.Wrap(layermanager.evictionPolicy.EnsureMinFreeSpace(layermanager.layersDir, items,
+		func(item eviction.Item) error {
+			layer := cachedLayers[fmt.Sprintf("%s_%d", item.ID, item.AosVersion)]
+
+			if err := layermanager.removeLayer(layer.Digest); err != nil {
+				return err
+			}
+
+			layermanager.layerAllocator.RestoreOutdatedItem(layer.Digest)
+			layermanager.layerAllocator.FreeSpace(layer.Size)
+
+			return nil
+		}))
+}
+
+func evictionItem(layer LayerInfo) eviction.Item {
+	return eviction.Item{
+		ID: layer.LayerID, AosVersion: layer.AosVersion, Size: layer.Size, Timestamp: layer.Timestamp,
+	}
+}
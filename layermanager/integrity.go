@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package layermanager
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/scrubber"
+)
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// CheckIntegrity validates installed layers content and marks damaged ones. Layers installed without content digest
+// are skipped.
+func (layermanager *LayerManager) CheckIntegrity(
+	ctx context.Context, open scrubber.OpenFunc,
+) (damaged []scrubber.DamagedItem, err error) {
+	layers, err := layermanager.layerStorage.GetLayersInfo()
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	for _, layer := range layers {
+		if layer.Damaged {
+			continue
+		}
+
+		if layer.ContentDigest == "" {
+			log.WithField("digest", layer.Digest).Debug("Layer has no content digest, skip integrity check")
+
+			continue
+		}
+
+		contentDigest, validateErr := getContentDigest(layer.Path, open)
+		if ctx.Err() != nil {
+			return damaged, aoserrors.Wrap(ctx.Err())
+		}
+
+		if validateErr == nil && contentDigest != layer.ContentDigest {
+			validateErr = aoserrors.New("layer content digest mismatch")
+		}
+
+		if validateErr == nil {
+			continue
+		}
+
+		// Layer may be removed while it is validated
+		if err = layermanager.layerStorage.SetLayerDamaged(layer.Digest, true); err != nil {
+			if errors.Is(err, ErrNotExist) {
+				continue
+			}
+
+			return damaged, aoserrors.Wrap(err)
+		}
+
+		damaged = append(damaged, scrubber.DamagedItem{
+			Type: scrubber.LayerItem, ID: layer.LayerID, AosVersion: layer.AosVersion, Err: validateErr,
+		})
+	}
+
+	return damaged, nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// removeDamagedLayers removes layers marked as damaged to reinstall them with next desired layers. Not cached layers
+// are used by current services, they are kept until they become cached.
+func (layermanager *LayerManager) removeDamagedLayers(layers []LayerInfo) (validLayers []LayerInfo, err error) {
+	for _, layer := range layers {
+		if !layer.Damaged {
+			validLayers = append(validLayers, layer)
+
+			continue
+		}
+
+		if !layer.Cached {
+			log.WithFields(log.Fields{
+				"id": layer.LayerID, "digest": layer.Digest,
+			}).Warn("Damaged layer is in use, skip remove")
+
+			validLayers = append(validLayers, layer)
+
+			continue
+		}
+
+		log.WithFields(log.Fields{"id": layer.LayerID, "digest": layer.Digest}).Warn("Remove damaged layer")
+
+		if err = layermanager.removeLayer(layer.Digest); err != nil {
+			return nil, err
+		}
+
+		layermanager.layerAllocator.RestoreOutdatedItem(layer.Digest)
+		layermanager.layerAllocator.FreeSpace(layer.Size)
+	}
+
+	return validLayers, nil
+}
+
+// getContentDigest calculates digest of unpacked layer dir. Unlike service rootfs, layer contains overlay whiteouts
+// and symlinks which can't be opened, so each entry is hashed according to its type: regular file by content,
+// symlink by target and other entries by mode only.
+func getContentDigest(dir string, open scrubber.OpenFunc) (contentDigest string, err error) {
+	hash := sha256.New()
+
+	if err = filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		relPath, err := filepath.Rel(dir, name)
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if relPath == "." {
+			return nil
+		}
+
+		if strings.Contains(relPath, "\n") {
+			return aoserrors.New("file names with new lines are not supported")
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		switch {
+		case info.Mode().IsRegular():
+			fileDigest, err := getFileDigest(name, open)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(hash, "%s %s %s\n", info.Mode(), fileDigest, relPath)
+
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(name)
+			if err != nil {
+				return aoserrors.Wrap(err)
+			}
+
+			fmt.Fprintf(hash, "%s %s %s\n", info.Mode(), target, relPath)
+
+		default:
+			fmt.Fprintf(hash, "%s %s\n", info.Mode(), relPath)
+		}
+
+		return nil
+	}); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	return digest.NewDigest(digest.SHA256, hash).String(), nil
+}
+
+func getFileDigest(name string, open scrubber.OpenFunc) (fileDigest string, err error) {
+	file, err := open(name)
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
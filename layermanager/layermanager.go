@@ -22,20 +22,31 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aosedge/aos_common/aoserrors"
 	"github.com/aosedge/aos_common/aostypes"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
 	"github.com/aosedge/aos_common/image"
 	"github.com/aosedge/aos_common/spaceallocator"
+	"github.com/aosedge/aos_common/utils/action"
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 
 	"github.com/aosedge/aos_servicemanager/config"
+	"github.com/aosedge/aos_servicemanager/eviction"
+	"github.com/aosedge/aos_servicemanager/fsimage"
+	"github.com/aosedge/aos_servicemanager/registry"
+	"github.com/aosedge/aos_servicemanager/scrubber"
+	"github.com/aosedge/aos_servicemanager/signature"
 	"github.com/aosedge/aos_servicemanager/utils/whiteouts"
 )
 
@@ -44,7 +55,9 @@ import (
  **********************************************************************************************************************/
 
 const (
-	layerOCIDescriptor = "layer.json"
+	layerOCIDescriptor  = "layer.json"
+	registryManifest    = "manifest.json"
+	maxParallelInstalls = 4
 )
 
 /***********************************************************************************************************************
@@ -76,14 +89,38 @@ type SpaceAllocator interface {
 type LayerManager struct {
 	sync.Mutex
 	layerStorage           LayerStorage
+	downloader             Downloader
+	signatureVerifier      SignatureVerifier
+	registry               *registry.Client
 	layersDir              string
 	extractDir             string
 	downloadDir            string
 	layerTTLDays           uint64
+	streamingUnpack        bool
+	reinstallDamaged       bool
 	layerAllocator         spaceallocator.Allocator
 	downloadAllocator      spaceallocator.Allocator
 	extractAllocator       spaceallocator.Allocator
+	fsStore                *fsimage.Store
+	evictionPolicy         *eviction.Policy
 	validateTTLStopChannel chan struct{}
+	installCtx             context.Context //nolint:containedctx // cancels installs on close
+	cancelFunction         context.CancelFunc
+}
+
+// Downloader downloads layer packages.
+type Downloader interface {
+	Download(ctx context.Context, url string, fileInfo image.FileInfo) (fileName string, err error)
+	DownloadWithHeader(
+		ctx context.Context, url string, header http.Header, fileInfo image.FileInfo) (fileName string, err error)
+	Release(url string) error
+	Unpack(ctx context.Context, url string, fileInfo image.FileInfo, destination string,
+		allocator spaceallocator.Allocator) (size uint64, space spaceallocator.Space, err error)
+}
+
+// SignatureVerifier verifies layer signatures.
+type SignatureVerifier interface {
+	Verify(fileName string, image signature.Image) error
 }
 
 // LayerStorage provides API to add, remove or access layer information.
@@ -93,35 +130,54 @@ type LayerStorage interface {
 	GetLayersInfo() ([]LayerInfo, error)
 	GetLayerInfoByDigest(digest string) (LayerInfo, error)
 	SetLayerCached(digest string, cached bool) error
+	SetLayerDamaged(digest string, damaged bool) error
+	fsimage.Storage
+	eviction.UsageStorage
 }
 
-// LayerInfo layer information.
+// LayerInfo layer information. Content digest is digest of unpacked layer dir used to check layer integrity.
 type LayerInfo struct {
 	aostypes.VersionInfo
-	Digest    string
-	LayerID   string
-	Path      string
-	OSVersion string
-	Timestamp time.Time
-	Cached    bool
-	Size      uint64
+	Digest        string
+	LayerID       string
+	Path          string
+	OSVersion     string
+	Timestamp     time.Time
+	Cached        bool
+	Size          uint64
+	ContentDigest string
+	Damaged       bool
 }
 
 /**********************************************************************************************************************
  * Public
  **********************************************************************************************************************/
 // New creates new layer manager instance.
-func New(config *config.Config, layerStorage LayerStorage) (layermanager *LayerManager, err error) {
+func New(
+	config *config.Config, layerStorage LayerStorage, downloader Downloader, signatureVerifier SignatureVerifier,
+) (layermanager *LayerManager, err error) {
 	layermanager = &LayerManager{
 		layersDir:              config.LayersDir,
 		layerStorage:           layerStorage,
+		downloader:             downloader,
+		signatureVerifier:      signatureVerifier,
+		registry:               registry.New(config, downloader),
 		extractDir:             config.ExtractDir,
 		downloadDir:            config.DownloadDir,
 		layerTTLDays:           config.LayerTTLDays,
+		streamingUnpack:        config.Downloader.StreamingUnpack,
+		reinstallDamaged:       config.Scrubber.ReinstallDamaged,
 		validateTTLStopChannel: make(chan struct{}),
 	}
 
-	if err := os.RemoveAll(layermanager.downloadDir); err != nil {
+	layermanager.installCtx, layermanager.cancelFunction = context.WithCancel(context.Background())
+
+	if layermanager.fsStore, err = fsimage.New(config, layerStorage); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if layermanager.evictionPolicy, err = eviction.New(
+		eviction.LayerItem, config.LayerEviction, layerStorage); err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
 
@@ -142,7 +198,7 @@ func New(config *config.Config, layerStorage LayerStorage) (layermanager *LayerM
 	}
 
 	if layermanager.layerAllocator, err = NewSpaceAllocator(
-		layermanager.layersDir, config.LayersPartLimit, layermanager.removeLayer); err != nil {
+		layermanager.layersDir, config.LayersPartLimit, layermanager.removeOutdatedLayer); err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
 
@@ -160,6 +216,10 @@ func New(config *config.Config, layerStorage LayerStorage) (layermanager *LayerM
 		log.Errorf("Can't remove damaged layer folders: %v", err)
 	}
 
+	if err := layermanager.mountLayersFS(); err != nil {
+		log.Errorf("Can't mount layers images: %v", err)
+	}
+
 	if err := layermanager.setOutdatedLayers(); err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
@@ -168,6 +228,10 @@ func New(config *config.Config, layerStorage LayerStorage) (layermanager *LayerM
 		log.Errorf("Can't remove outdated layers: %v", err)
 	}
 
+	if err := layermanager.ensureMinFreeSpace(); err != nil {
+		log.Errorf("Can't ensure min free space: %v", err)
+	}
+
 	go layermanager.validateTTLs()
 
 	return layermanager, nil
@@ -175,6 +239,8 @@ func New(config *config.Config, layerStorage LayerStorage) (layermanager *LayerM
 
 // Close closes layer manager instance.
 func (layermanager *LayerManager) Close() {
+	layermanager.cancelFunction()
+
 	if err := layermanager.layerAllocator.Close(); err != nil {
 		log.Errorf("Can't close layer allocator: %v", err)
 	}
@@ -199,36 +265,94 @@ func (layermanager *LayerManager) GetLayerInfoByDigest(digest string) (layer Lay
 	return layer, nil
 }
 
-// ProcessDesiredLayers installs, removes, restores desired layers on the system.
-func (layermanager *LayerManager) ProcessDesiredLayers(desiredLayers []aostypes.LayerInfo) error {
+// ProcessDesiredLayers installs, removes, restores desired layers on the system. It returns status of each desired
+// layer and the first install error.
+func (layermanager *LayerManager) ProcessDesiredLayers(
+	desiredLayers []aostypes.LayerInfo,
+) (statuses []cloudprotocol.LayerStatus, err error) {
 	layers, err := layermanager.layerStorage.GetLayersInfo()
 	if err != nil {
-		return aoserrors.Wrap(err)
+		return nil, aoserrors.Wrap(err)
 	}
 
-	if desiredLayers, err = layermanager.updateCachedLayers(desiredLayers, layers); err != nil {
-		return err
+	if layermanager.reinstallDamaged {
+		if layers, err = layermanager.removeDamagedLayers(layers); err != nil {
+			return nil, err
+		}
 	}
 
-	if err = layermanager.installLayers(desiredLayers); err != nil {
-		return err
+	layersToInstall, err := layermanager.updateCachedLayers(slices.Clone(desiredLayers), layers)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	installStatuses, err := layermanager.installLayers(layermanager.installCtx, layersToInstall)
+
+	if minFreeSpaceErr := layermanager.ensureMinFreeSpace(); minFreeSpaceErr != nil {
+		log.Errorf("Can't ensure min free space: %v", minFreeSpaceErr)
+	}
+
+	statuses = make([]cloudprotocol.LayerStatus, 0, len(desiredLayers))
+
+	for _, desiredLayer := range desiredLayers {
+		status := cloudprotocol.LayerStatus{
+			ID: desiredLayer.ID, AosVersion: desiredLayer.AosVersion, Digest: desiredLayer.Digest,
+			Status: cloudprotocol.InstalledStatus,
+		}
+
+		for _, installStatus := range installStatuses {
+			if installStatus.Digest == status.Digest {
+				status = installStatus
+
+				break
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, err
 }
 
 /***********************************************************************************************************************
  * Private
  **********************************************************************************************************************/
 
-func (layermanager *LayerManager) installLayers(desiredLayers []aostypes.LayerInfo) error {
+// installLayers installs layers in parallel. Failed layer doesn't prevent other layers from installing.
+// Install status of each layer and the first install error are returned.
+func (layermanager *LayerManager) installLayers(
+	ctx context.Context, desiredLayers []aostypes.LayerInfo,
+) (statuses []cloudprotocol.LayerStatus, err error) {
+	actionHandler := action.New(maxParallelInstalls)
+	installChannels := make([]<-chan error, 0, len(desiredLayers))
+
 	for _, desiredLayer := range desiredLayers {
-		if err := layermanager.installLayer(desiredLayer); err != nil {
-			return err
+		layerInfo := desiredLayer
+
+		installChannels = append(installChannels, actionHandler.Execute(layerInfo.Digest, func(string) error {
+			return layermanager.installLayer(ctx, layerInfo)
+		}))
+	}
+
+	statuses = make([]cloudprotocol.LayerStatus, len(desiredLayers))
+
+	for i, installChannel := range installChannels {
+		statuses[i] = cloudprotocol.LayerStatus{
+			ID: desiredLayers[i].ID, AosVersion: desiredLayers[i].AosVersion, Digest: desiredLayers[i].Digest,
+			Status: cloudprotocol.InstalledStatus,
+		}
+
+		if installErr := <-installChannel; installErr != nil {
+			statuses[i].Status = cloudprotocol.ErrorStatus
+			statuses[i].ErrorInfo = &cloudprotocol.ErrorInfo{Message: installErr.Error()}
+
+			if err == nil {
+				err = installErr
+			}
 		}
 	}
 
-	return nil
+	return statuses, err
 }
 
 func (layermanager *LayerManager) updateCachedLayers(
@@ -263,7 +387,7 @@ nextLayer:
 }
 
 func (layermanager *LayerManager) installLayer(
-	layerInfo aostypes.LayerInfo,
+	ctx context.Context, layerInfo aostypes.LayerInfo,
 ) (err error) {
 	log.WithFields(log.Fields{
 		"id":         layerInfo.ID,
@@ -271,6 +395,16 @@ func (layermanager *LayerManager) installLayer(
 		"digest":     layerInfo.Digest,
 	}).Debug("Install layer")
 
+	defer func() {
+		if err != nil {
+			log.WithFields(log.Fields{
+				"id":         layerInfo.ID,
+				"aosVersion": layerInfo.AosVersion,
+				"digest":     layerInfo.Digest,
+			}).Errorf("Can't install layer: %s", err)
+		}
+	}()
+
 	extractLayerDir := filepath.Join(layermanager.extractDir, layerInfo.Digest)
 
 	if err := os.MkdirAll(extractLayerDir, 0o755); err != nil {
@@ -278,7 +412,8 @@ func (layermanager *LayerManager) installLayer(
 	}
 	defer os.RemoveAll(extractLayerDir)
 
-	layerDescriptor, spaceExtract, err := layermanager.extractPackageByURL(extractLayerDir, &layerInfo)
+	layerDescriptor, signedFile, spaceExtract, err := layermanager.extractPackageByURL(
+		ctx, extractLayerDir, &layerInfo)
 	if err != nil {
 		return aoserrors.Wrap(err)
 	}
@@ -289,6 +424,13 @@ func (layermanager *LayerManager) installLayer(
 		}
 	}()
 
+	// Layer descriptor or registry manifest is signed, layer content is validated by descriptor digest
+	if err = layermanager.signatureVerifier.Verify(signedFile, signature.Image{
+		Type: signature.LayerImage, ID: layerInfo.ID, AosVersion: layerInfo.AosVersion,
+	}); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
 	layerPath, err := getValidLayerPath(layerDescriptor, extractLayerDir)
 	if err != nil {
 		return aoserrors.Wrap(err)
@@ -306,12 +448,6 @@ func (layermanager *LayerManager) installLayer(
 		if err != nil {
 			releaseAllocatedSpace(storeLayerPath, spaceLayer)
 
-			log.WithFields(log.Fields{
-				"id":         layerInfo.ID,
-				"aosVersion": layerInfo.AosVersion,
-				"digest":     layerInfo.Digest,
-			}).Errorf("Can't install layer: %s", err)
-
 			return
 		}
 
@@ -324,6 +460,30 @@ func (layermanager *LayerManager) installLayer(
 		return err
 	}
 
+	contentDigest, err := getContentDigest(storeLayerPath, scrubber.OpenFile)
+	if err != nil {
+		return err
+	}
+
+	layerSize := uint64(layerDescriptor.Size)
+
+	imageSize, err := layermanager.fsStore.Convert(storeLayerPath, layermanager.layerAllocator)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if imageSize != 0 {
+		layerSize = imageSize
+	}
+
+	defer func() {
+		if err != nil {
+			if removeErr := layermanager.fsStore.Remove(storeLayerPath); removeErr != nil {
+				log.Errorf("Can't remove layer image: %v", removeErr)
+			}
+		}
+	}()
+
 	var osVersion string
 
 	if layerDescriptor.Platform != nil {
@@ -331,13 +491,14 @@ func (layermanager *LayerManager) installLayer(
 	}
 
 	if err = layermanager.layerStorage.AddLayer(LayerInfo{
-		LayerID:     layerInfo.ID,
-		Digest:      layerInfo.Digest,
-		Path:        storeLayerPath,
-		OSVersion:   osVersion,
-		Size:        uint64(layerDescriptor.Size),
-		VersionInfo: layerInfo.VersionInfo,
-		Timestamp:   time.Now().UTC(),
+		LayerID:       layerInfo.ID,
+		Digest:        layerInfo.Digest,
+		Path:          storeLayerPath,
+		OSVersion:     osVersion,
+		Size:          layerSize,
+		VersionInfo:   layerInfo.VersionInfo,
+		Timestamp:     time.Now().UTC(),
+		ContentDigest: contentDigest,
 	}); err != nil {
 		return aoserrors.Wrap(err)
 	}
@@ -357,12 +518,7 @@ func (layermanager *LayerManager) setLayerCached(layer LayerInfo, cached bool) e
 	}
 
 	if cached {
-		if err := layermanager.layerAllocator.AddOutdatedItem(
-			layer.Digest, layer.Size, layer.Timestamp); err != nil {
-			return aoserrors.Wrap(err)
-		}
-
-		return nil
+		return layermanager.addOutdatedLayer(layer)
 	}
 
 	layermanager.layerAllocator.RestoreOutdatedItem(layer.Digest)
@@ -394,13 +550,14 @@ func (layermanager *LayerManager) removeOutdatedLayers() error {
 	}
 
 	for _, layer := range layers {
-		if layer.Cached &&
+		if layer.Cached && !layermanager.evictionPolicy.IsPinned(layer.LayerID, layer.AosVersion) &&
 			layer.Timestamp.Add(time.Hour*24*time.Duration(layermanager.layerTTLDays)).Before(time.Now()) {
 			if err := layermanager.removeLayer(layer.Digest); err != nil {
 				return err
 			}
 
 			layermanager.layerAllocator.RestoreOutdatedItem(layer.Digest)
+			layermanager.evictionPolicy.AddEvicted(evictionItem(layer), eviction.ReasonTTL)
 		}
 	}
 
@@ -413,6 +570,10 @@ func (layermanager *LayerManager) removeLayer(digest string) error {
 		return aoserrors.Wrap(err)
 	}
 
+	if err = layermanager.fsStore.Remove(layer.Path); err != nil {
+		log.WithField("digest", digest).Errorf("Can't remove layer image: %v", err)
+	}
+
 	if err = os.RemoveAll(layer.Path); err != nil {
 		return aoserrors.Wrap(err)
 	}
@@ -421,6 +582,8 @@ func (layermanager *LayerManager) removeLayer(digest string) error {
 		return aoserrors.Wrap(err)
 	}
 
+	layermanager.evictionPolicy.RemoveUsage(digest)
+
 	log.WithFields(log.Fields{"digest": digest}).Info("Layer successfully removed")
 
 	return nil
@@ -434,9 +597,8 @@ func (layermanager *LayerManager) setOutdatedLayers() error {
 
 	for _, layer := range layersInfo {
 		if layer.Cached {
-			if err = layermanager.layerAllocator.AddOutdatedItem(
-				layer.Digest, layer.Size, layer.Timestamp); err != nil {
-				return aoserrors.Wrap(err)
+			if err = layermanager.addOutdatedLayer(layer); err != nil {
+				return err
 			}
 		}
 	}
@@ -479,7 +641,8 @@ func (layermanager *LayerManager) removeDamagedLayerFolders() error {
 			digestPath := filepath.Join(algorithmPath, digest.Name())
 
 			for _, layer := range layersInfo {
-				if layer.Path == digestPath {
+				// Keep layer image files stored next to the layer dir
+				if digestPath == layer.Path || strings.HasPrefix(digestPath, layer.Path+".") {
 					continue digestsLoop
 				}
 			}
@@ -495,12 +658,44 @@ func (layermanager *LayerManager) removeDamagedLayerFolders() error {
 	return nil
 }
 
+// mountLayersFS mounts installed layers images. Layers which images can't be mounted or don't pass verity check are
+// marked as damaged.
+func (layermanager *LayerManager) mountLayersFS() error {
+	layersInfo, err := layermanager.layerStorage.GetLayersInfo()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	for _, layer := range layersInfo {
+		if err = layermanager.fsStore.Mount(layer.Path); err == nil {
+			continue
+		}
+
+		log.WithFields(log.Fields{"id": layer.LayerID, "digest": layer.Digest}).Errorf(
+			"Can't mount layer image: %v", err)
+
+		if err = layermanager.layerStorage.SetLayerDamaged(layer.Digest, true); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
 func (layermanager *LayerManager) extractPackageByURL(
-	extractDir string, layerInfo *aostypes.LayerInfo,
-) (layerDescriptor imagespec.Descriptor, space spaceallocator.Space, err error) {
+	ctx context.Context, extractDir string, layerInfo *aostypes.LayerInfo,
+) (layerDescriptor imagespec.Descriptor, signedFile string, space spaceallocator.Space, err error) {
 	urlVal, err := url.Parse(layerInfo.URL)
 	if err != nil {
-		return layerDescriptor, nil, aoserrors.Wrap(err)
+		return layerDescriptor, "", nil, aoserrors.Wrap(err)
+	}
+
+	if urlVal.Scheme == registry.Scheme {
+		return layermanager.pullPackage(ctx, extractDir, urlVal, layerInfo)
+	}
+
+	if urlVal.Scheme != "file" && layermanager.streamingUnpack {
+		return layermanager.unpackPackage(ctx, extractDir, layerInfo)
 	}
 
 	var sourceFile string
@@ -508,7 +703,7 @@ func (layermanager *LayerManager) extractPackageByURL(
 	if urlVal.Scheme != "file" {
 		spaceDownload, err := layermanager.downloadAllocator.AllocateSpace(layerInfo.Size)
 		if err != nil {
-			return layerDescriptor, nil, aoserrors.Wrap(err)
+			return layerDescriptor, "", nil, aoserrors.Wrap(err)
 		}
 
 		defer func() {
@@ -517,52 +712,218 @@ func (layermanager *LayerManager) extractPackageByURL(
 			}
 		}()
 
-		if sourceFile, err = image.Download(context.Background(), layermanager.downloadDir, layerInfo.URL); err != nil {
-			return layerDescriptor, nil, aoserrors.Wrap(err)
+		if sourceFile, err = layermanager.downloader.Download(ctx, layerInfo.URL, image.FileInfo{
+			Sha256: layerInfo.Sha256,
+			Sha512: layerInfo.Sha512,
+			Size:   layerInfo.Size,
+		}); err != nil {
+			return layerDescriptor, "", nil, aoserrors.Wrap(err)
 		}
 
-		defer os.RemoveAll(sourceFile)
+		defer func() {
+			if err := layermanager.downloader.Release(layerInfo.URL); err != nil {
+				log.Errorf("Can't release downloaded file: %v", err)
+			}
+		}()
 	} else {
 		sourceFile = urlVal.Path
 	}
 
-	if err = image.CheckFileInfo(context.Background(), sourceFile, image.FileInfo{
+	if err = image.CheckFileInfo(ctx, sourceFile, image.FileInfo{
 		Sha256: layerInfo.Sha256,
 		Sha512: layerInfo.Sha512,
 		Size:   layerInfo.Size,
 	}); err != nil {
-		return layerDescriptor, nil, aoserrors.Wrap(err)
+		return layerDescriptor, "", nil, aoserrors.Wrap(err)
 	}
 
 	size, err := image.GetUncompressedTarContentSize(sourceFile)
 	if err != nil {
-		return layerDescriptor, nil, aoserrors.Wrap(err)
+		return layerDescriptor, "", nil, aoserrors.Wrap(err)
 	}
 
 	spaceExtract, err := layermanager.extractAllocator.AllocateSpace(uint64(size))
 	if err != nil {
-		return layerDescriptor, nil, aoserrors.Wrap(err)
+		return layerDescriptor, "", nil, aoserrors.Wrap(err)
 	}
 
+	// Allocated space should be released on failure, otherwise it is lost for other installs
+	defer func() {
+		if err != nil {
+			if releaseErr := spaceExtract.Release(); releaseErr != nil {
+				log.Errorf("Can't release memory: %v", releaseErr)
+			}
+		}
+	}()
+
 	if err = image.UnpackTarImage(sourceFile, extractDir); err != nil {
-		return layerDescriptor, nil, aoserrors.Wrap(err)
+		return layerDescriptor, "", nil, aoserrors.Wrap(err)
+	}
+
+	if layerDescriptor, err = getLayerDescriptor(extractDir); err != nil {
+		return layerDescriptor, "", nil, aoserrors.Wrap(err)
 	}
 
-	var byteValue []byte
+	return layerDescriptor, filepath.Join(extractDir, layerOCIDescriptor), spaceExtract, nil
+}
 
-	if byteValue, err = os.ReadFile(filepath.Join(extractDir, layerOCIDescriptor)); err != nil {
-		return layerDescriptor, nil, aoserrors.Wrap(err)
+// unpackPackage unpacks layer package while downloading it. Download space is not used in this case.
+func (layermanager *LayerManager) unpackPackage(
+	ctx context.Context, extractDir string, layerInfo *aostypes.LayerInfo,
+) (layerDescriptor imagespec.Descriptor, signedFile string, space spaceallocator.Space, err error) {
+	_, spaceExtract, err := layermanager.downloader.Unpack(ctx, layerInfo.URL, image.FileInfo{
+		Sha256: layerInfo.Sha256,
+		Sha512: layerInfo.Sha512,
+		Size:   layerInfo.Size,
+	}, extractDir, layermanager.extractAllocator)
+	if err != nil {
+		return layerDescriptor, "", nil, aoserrors.Wrap(err)
+	}
+
+	if layerDescriptor, err = getLayerDescriptor(extractDir); err != nil {
+		if releaseErr := spaceExtract.Release(); releaseErr != nil {
+			log.Errorf("Can't release memory: %v", releaseErr)
+		}
+
+		return layerDescriptor, "", nil, aoserrors.Wrap(err)
+	}
+
+	return layerDescriptor, filepath.Join(extractDir, layerOCIDescriptor), spaceExtract, nil
+}
+
+// pullPackage pulls layer image from OCI registry. The layer blob is the first layer of the image manifest. The image
+// manifest is stored as signed file instead of layer descriptor.
+func (layermanager *LayerManager) pullPackage(
+	ctx context.Context, extractDir string, urlVal *url.URL, layerInfo *aostypes.LayerInfo,
+) (layerDescriptor imagespec.Descriptor, signedFile string, space spaceallocator.Space, err error) {
+	ref, err := registry.ParseReference(urlVal)
+	if err != nil {
+		return layerDescriptor, "", nil, aoserrors.Wrap(err)
+	}
+
+	manifestData, err := layermanager.registry.GetManifest(ctx, ref)
+	if err != nil {
+		return layerDescriptor, "", nil, aoserrors.Wrap(err)
+	}
+
+	var manifest imagespec.Manifest
+
+	if err = json.Unmarshal(manifestData, &manifest); err != nil {
+		return layerDescriptor, "", nil, aoserrors.Wrap(err)
+	}
+
+	if len(manifest.Layers) == 0 {
+		return layerDescriptor, "", nil, aoserrors.New("no layers in image")
+	}
+
+	layerDescriptor = manifest.Layers[0]
+
+	if layerInfo.Digest != "" && layerInfo.Digest != layerDescriptor.Digest.String() {
+		return layerDescriptor, "", nil, aoserrors.Errorf("layer digest mismatch: %s", layerDescriptor.Digest)
+	}
+
+	spaceExtract, err := layermanager.extractAllocator.AllocateSpace(uint64(len(manifestData)) +
+		uint64(layerDescriptor.Size))
+	if err != nil {
+		return layerDescriptor, "", nil, aoserrors.Wrap(err)
+	}
+
+	defer func() {
+		if err != nil {
+			if releaseErr := spaceExtract.Release(); releaseErr != nil {
+				log.Errorf("Can't release memory: %v", releaseErr)
+			}
+		}
+	}()
+
+	signedFile = filepath.Join(extractDir, registryManifest)
+
+	if err = os.WriteFile(signedFile, manifestData, 0o600); err != nil {
+		return layerDescriptor, "", nil, aoserrors.Wrap(err)
+	}
+
+	if err = layermanager.pullBlob(ctx, ref, layerDescriptor,
+		filepath.Join(extractDir, layerDescriptor.Digest.Encoded())); err != nil {
+		return layerDescriptor, "", nil, aoserrors.Wrap(err)
+	}
+
+	signatureData, err := layermanager.registry.GetSignature(ctx, ref)
+	if err != nil && !errors.Is(err, registry.ErrNotExist) {
+		return layerDescriptor, "", nil, aoserrors.Wrap(err)
+	}
+
+	if err == nil {
+		if err = os.WriteFile(signedFile+signature.SignatureFileExt, signatureData, 0o600); err != nil {
+			return layerDescriptor, "", nil, aoserrors.Wrap(err)
+		}
+	}
+
+	// Layer package descriptor contains uncompressed layer size
+	size, err := image.GetUncompressedTarContentSize(filepath.Join(extractDir, layerDescriptor.Digest.Encoded()))
+	if err != nil {
+		return layerDescriptor, "", nil, aoserrors.Wrap(err)
+	}
+
+	layerDescriptor.Size = size
+
+	return layerDescriptor, signedFile, spaceExtract, nil
+}
+
+// pullBlob downloads registry blob into the file. Download space is allocated while the blob is downloaded.
+func (layermanager *LayerManager) pullBlob(
+	ctx context.Context, ref registry.Reference, blob imagespec.Descriptor, fileName string,
+) error {
+	space, err := layermanager.downloadAllocator.AllocateSpace(uint64(blob.Size))
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	defer func() {
+		if err := space.Release(); err != nil {
+			log.Errorf("Can't release memory: %v", err)
+		}
+	}()
+
+	return aoserrors.Wrap(layermanager.registry.DownloadBlob(ctx, ref, blob, fileName))
+}
+
+func getLayerDescriptor(extractDir string) (layerDescriptor imagespec.Descriptor, err error) {
+	byteValue, err := os.ReadFile(filepath.Join(extractDir, layerOCIDescriptor))
+	if err != nil {
+		return layerDescriptor, aoserrors.Wrap(err)
 	}
 
 	if err = json.Unmarshal(byteValue, &layerDescriptor); err != nil {
-		return layerDescriptor, nil, aoserrors.Wrap(err)
+		return layerDescriptor, aoserrors.Wrap(err)
 	}
 
-	return layerDescriptor, spaceExtract, nil
+	return layerDescriptor, nil
 }
 
 func getValidLayerPath(layerDescriptor imagespec.Descriptor, unTarPath string) (layerPath string, err error) {
-	return filepath.Join(unTarPath, layerDescriptor.Digest.Hex()), nil
+	if err = layerDescriptor.Digest.Validate(); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	layerPath = filepath.Join(unTarPath, layerDescriptor.Digest.Hex())
+
+	file, err := os.Open(layerPath)
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+	defer file.Close()
+
+	verifier := layerDescriptor.Digest.Verifier()
+
+	if _, err = io.Copy(verifier, file); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	if !verifier.Verified() {
+		return "", aoserrors.New("layer digest mismatch")
+	}
+
+	return layerPath, nil
 }
 
 func releaseAllocatedSpace(path string, spaceLayer spaceallocator.Space) {
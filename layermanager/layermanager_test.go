@@ -25,16 +25,19 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/aosedge/aos_common/aoserrors"
 	"github.com/aosedge/aos_common/aostypes"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
 	"github.com/aosedge/aos_common/image"
 	"github.com/aosedge/aos_common/spaceallocator"
 	"github.com/opencontainers/go-digest"
@@ -42,7 +45,11 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/aosedge/aos_servicemanager/config"
+	"github.com/aosedge/aos_servicemanager/eviction"
+	"github.com/aosedge/aos_servicemanager/fsimage"
 	"github.com/aosedge/aos_servicemanager/layermanager"
+	"github.com/aosedge/aos_servicemanager/scrubber"
+	"github.com/aosedge/aos_servicemanager/signature"
 )
 
 /***********************************************************************************************************************
@@ -59,9 +66,14 @@ const (
  **********************************************************************************************************************/
 
 type testLayerStorage struct {
+	sync.Mutex
+
 	layers       []layermanager.LayerInfo
 	addLayerFail bool
 	getLayerFail bool
+	images       map[string]fsimage.ImageInfo
+	usage        map[string]time.Time
+	evicted      []eviction.EvictedItem
 }
 
 type testAllocator struct {
@@ -79,8 +91,22 @@ type testSpace struct {
 }
 
 type testOutdatedItem struct {
-	id   string
-	size uint64
+	id        string
+	size      uint64
+	timestamp time.Time
+}
+
+type testDownloader struct {
+	sync.Mutex
+
+	downloadDir  string
+	files        map[string]string
+	unpackedURLs []string
+	pulledURLs   []string
+}
+
+type testSignatureVerifier struct {
+	untrustedIDs []string
 }
 
 /***********************************************************************************************************************
@@ -146,7 +172,7 @@ func TestProcessDesiredLayers(t *testing.T) {
 			ExtractDir:   filepath.Join(tmpDir, "extract"),
 			DownloadDir:  filepath.Join(tmpDir, "download"),
 			LayerTTLDays: 0,
-		}, testLayerStorage)
+		}, testLayerStorage, newTestDownloader(filepath.Join(tmpDir, "download")), newTestSignatureVerifier())
 	if err != nil {
 		t.Fatalf("Can't create layer manager: %s", err)
 	}
@@ -196,7 +222,7 @@ func TestProcessDesiredLayers(t *testing.T) {
 	}
 
 	for _, tCase := range cases {
-		if err := layerManager.ProcessDesiredLayers(tCase.desiredLayers); err != nil {
+		if _, err := layerManager.ProcessDesiredLayers(tCase.desiredLayers); err != nil {
 			t.Errorf("Can't process desired layers: %v", err)
 		}
 
@@ -251,7 +277,7 @@ func TestRemoveDemageLayerFolder(t *testing.T) {
 			LayersDir:   layersDir,
 			ExtractDir:  filepath.Join(tmpDir, "extract"),
 			DownloadDir: filepath.Join(tmpDir, "download"),
-		}, testStorage)
+		}, testStorage, newTestDownloader(filepath.Join(tmpDir, "download")), newTestSignatureVerifier())
 	if err != nil {
 		t.Fatalf("Can't create layer manager: %v", err)
 	}
@@ -263,7 +289,7 @@ func TestRemoveDemageLayerFolder(t *testing.T) {
 		t.Fatalf("Can't create layer: %v", err)
 	}
 
-	if err = layerManager.ProcessDesiredLayers([]aostypes.LayerInfo{layerInfo}); err != nil {
+	if _, err = layerManager.ProcessDesiredLayers([]aostypes.LayerInfo{layerInfo}); err != nil {
 		t.Fatalf("Can't process desired layers: %v", err)
 	}
 
@@ -283,54 +309,638 @@ func TestRemoveDemageLayerFolder(t *testing.T) {
 			LayersDir:   layersDir,
 			ExtractDir:  filepath.Join(tmpDir, "extract"),
 			DownloadDir: filepath.Join(tmpDir, "download"),
-		}, testStorage)
+		}, testStorage, newTestDownloader(filepath.Join(tmpDir, "download")), newTestSignatureVerifier())
+	if err != nil {
+		t.Fatalf("Can't create layer manager: %v", err)
+	}
+
+	if _, err := os.Stat(testLayerDir); err == nil {
+		t.Error("Test layer folder should be deleted")
+	}
+
+	layerInfo, err = createLayer(filepath.Join(tmpDir, "layerdir2"), sizeLayerContent, "layer2")
+	if err != nil {
+		t.Fatalf("Can't create layer: %v", err)
+	}
+
+	if _, err = layerManager.ProcessDesiredLayers([]aostypes.LayerInfo{layerInfo}); err != nil {
+		t.Fatalf("Can't install layer: %v", err)
+	}
+
+	layer, err := layerManager.GetLayerInfoByDigest(layerInfo.Digest)
+	if err != nil {
+		t.Fatalf("Can't get layer: %v", err)
+	}
+
+	if err = os.RemoveAll(layer.Path); err != nil {
+		t.Fatalf("Can't remove layer path: %v", err)
+	}
+
+	layerManager.Close()
+
+	layerAllocator = &testAllocator{}
+
+	layerManager, err = layermanager.New(
+		&config.Config{
+			LayersDir:   layersDir,
+			ExtractDir:  filepath.Join(tmpDir, "extract"),
+			DownloadDir: filepath.Join(tmpDir, "download"),
+		}, testStorage, newTestDownloader(filepath.Join(tmpDir, "download")), newTestSignatureVerifier())
+	if err != nil {
+		t.Fatalf("Can't create layer manager: %v", err)
+	}
+	defer layerManager.Close()
+
+	if _, err = layerManager.GetLayerInfoByDigest(layerInfo.Digest); err == nil {
+		t.Fatal("Should be error: layer doesn't exist")
+	}
+}
+
+func TestRemoteDownloadLayer(t *testing.T) {
+	layerAllocator = &testAllocator{}
+
+	defer func() {
+		if err := os.RemoveAll(layersDir); err != nil {
+			t.Errorf("Can't remove layers dir: %v", err)
+		}
+	}()
+
+	layerManager, err := layermanager.New(
+		&config.Config{
+			LayersDir:   layersDir,
+			ExtractDir:  filepath.Join(tmpDir, "extract"),
+			DownloadDir: filepath.Join(tmpDir, "download"),
+		}, &testLayerStorage{}, newTestDownloader(filepath.Join(tmpDir, "download")), newTestSignatureVerifier())
 	if err != nil {
 		t.Fatalf("Can't create layer manager: %v", err)
 	}
+	defer layerManager.Close()
+
+	sizeLayerContent := int64(10 * kilobyte)
+
+	layerInfo, err := createLayer(filepath.Join(tmpDir, "layerdir1"), sizeLayerContent, "layer1")
+	if err != nil {
+		t.Fatalf("Can't create layer: %v", err)
+	}
+
+	fileServerDir, err := os.MkdirTemp("", "layers_fileserver")
+	if err != nil {
+		t.Fatalf("Error create temporary dir: %v", err)
+	}
+
+	defer os.RemoveAll(fileServerDir)
+
+	urlVal, err := url.Parse(layerInfo.URL)
+	if err != nil {
+		t.Fatalf("Can't parse url: %v", err)
+	}
+
+	if err = os.Rename(urlVal.Path, filepath.Join(fileServerDir, "downloadImage")); err != nil {
+		t.Fatalf("Can't rename directory: %v", err)
+	}
+
+	server := &http.Server{
+		Addr:              ":9000",
+		Handler:           http.FileServer(http.Dir(fileServerDir)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("Can't serve http server: %v", err)
+		}
+	}()
+
+	time.Sleep(1 * time.Second)
+
+	defer server.Close()
+
+	layerInfo.URL = "http://:9000/downloadImage"
+
+	if _, err = layerManager.ProcessDesiredLayers([]aostypes.LayerInfo{layerInfo}); err != nil {
+		t.Fatalf("Can't install layer: %v", err)
+	}
+}
+
+func TestStreamingUnpackLayer(t *testing.T) {
+	layerAllocator = &testAllocator{}
+
+	defer func() {
+		if err := os.RemoveAll(layersDir); err != nil {
+			t.Errorf("Can't remove layers dir: %v", err)
+		}
+	}()
+
+	testDownloader := newTestDownloader(filepath.Join(tmpDir, "download"))
+
+	layerManager, err := layermanager.New(
+		&config.Config{
+			LayersDir:   layersDir,
+			ExtractDir:  filepath.Join(tmpDir, "extract"),
+			DownloadDir: filepath.Join(tmpDir, "download"),
+			Downloader:  config.Downloader{StreamingUnpack: true},
+		}, &testLayerStorage{}, testDownloader, newTestSignatureVerifier())
+	if err != nil {
+		t.Fatalf("Can't create layer manager: %v", err)
+	}
+	defer layerManager.Close()
+
+	layerInfo, err := createLayer(filepath.Join(tmpDir, "layerdir1"), int64(10*kilobyte), "layer1")
+	if err != nil {
+		t.Fatalf("Can't create layer: %v", err)
+	}
+
+	fileServerDir, err := os.MkdirTemp("", "layers_fileserver")
+	if err != nil {
+		t.Fatalf("Error create temporary dir: %v", err)
+	}
+
+	defer os.RemoveAll(fileServerDir)
+
+	if err = os.Rename(
+		strings.TrimPrefix(layerInfo.URL, "file://"), filepath.Join(fileServerDir, "layer1")); err != nil {
+		t.Fatalf("Can't move layer package: %v", err)
+	}
+
+	server := httptest.NewServer(http.FileServer(http.Dir(fileServerDir)))
+	defer server.Close()
+
+	layerInfo.URL = server.URL + "/layer1"
+
+	if _, err = layerManager.ProcessDesiredLayers([]aostypes.LayerInfo{layerInfo}); err != nil {
+		t.Fatalf("Can't install layer: %v", err)
+	}
+
+	if _, err = layerManager.GetLayerInfoByDigest(layerInfo.Digest); err != nil {
+		t.Errorf("Can't get layer info: %v", err)
+	}
+
+	if len(testDownloader.unpackedURLs) != 1 || testDownloader.unpackedURLs[0] != layerInfo.URL {
+		t.Errorf("Layer package should be unpacked while downloading: %v", testDownloader.unpackedURLs)
+	}
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	layerAllocator = &testAllocator{}
+
+	defer func() {
+		if err := os.RemoveAll(layersDir); err != nil {
+			t.Errorf("Can't remove layers dir: %v", err)
+		}
+	}()
+
+	layerManager, err := layermanager.New(
+		&config.Config{
+			LayersDir:   layersDir,
+			ExtractDir:  filepath.Join(tmpDir, "extract"),
+			DownloadDir: filepath.Join(tmpDir, "download"),
+			Scrubber:    config.Scrubber{ReinstallDamaged: true},
+		}, &testLayerStorage{}, newTestDownloader(filepath.Join(tmpDir, "download")), newTestSignatureVerifier())
+	if err != nil {
+		t.Fatalf("Can't create layer manager: %v", err)
+	}
+	defer layerManager.Close()
+
+	var desiredLayers []aostypes.LayerInfo
+
+	for i := 1; i <= 2; i++ {
+		layerInfo, err := createLayer(
+			filepath.Join(tmpDir, fmt.Sprintf("layerdir%d", i)), int64(uint64(i)*kilobyte), fmt.Sprintf("layer%d", i))
+		if err != nil {
+			t.Fatalf("Can't create layer: %v", err)
+		}
+
+		desiredLayers = append(desiredLayers, layerInfo)
+	}
+
+	if _, err = layerManager.ProcessDesiredLayers(desiredLayers); err != nil {
+		t.Fatalf("Can't process desired layers: %v", err)
+	}
+
+	damaged, err := layerManager.CheckIntegrity(context.Background(), scrubber.OpenFile)
+	if err != nil {
+		t.Fatalf("Can't check integrity: %v", err)
+	}
+
+	if len(damaged) != 0 {
+		t.Errorf("Unexpected damaged layers: %v", damaged)
+	}
+
+	// Tamper layer file content and add symlink to another layer
+
+	layer1, err := layerManager.GetLayerInfoByDigest(desiredLayers[0].Digest)
+	if err != nil {
+		t.Fatalf("Can't get layer info: %v", err)
+	}
+
+	if err = os.WriteFile(filepath.Join(layer1.Path, "layer.txt"), []byte("tampered"), 0o600); err != nil {
+		t.Fatalf("Can't tamper layer: %v", err)
+	}
+
+	layer2, err := layerManager.GetLayerInfoByDigest(desiredLayers[1].Digest)
+	if err != nil {
+		t.Fatalf("Can't get layer info: %v", err)
+	}
+
+	if err = os.Symlink("/etc/shadow", filepath.Join(layer2.Path, "shadow")); err != nil {
+		t.Fatalf("Can't tamper layer: %v", err)
+	}
+
+	if damaged, err = layerManager.CheckIntegrity(context.Background(), scrubber.OpenFile); err != nil {
+		t.Fatalf("Can't check integrity: %v", err)
+	}
+
+	if len(damaged) != 2 {
+		t.Fatalf("Wrong damaged layers: %v", damaged)
+	}
+
+	for _, desiredLayer := range desiredLayers {
+		layer, err := layerManager.GetLayerInfoByDigest(desiredLayer.Digest)
+		if err != nil {
+			t.Fatalf("Can't get layer info: %v", err)
+		}
+
+		if !layer.Damaged {
+			t.Errorf("Layer %s should be marked as damaged", layer.LayerID)
+		}
+	}
+
+	// Damaged layers in use are not removed
+
+	if _, err = layerManager.ProcessDesiredLayers(desiredLayers); err != nil {
+		t.Fatalf("Can't process desired layers: %v", err)
+	}
+
+	if layer1, err = layerManager.GetLayerInfoByDigest(desiredLayers[0].Digest); err != nil {
+		t.Fatalf("Can't get layer info: %v", err)
+	}
+
+	if !layer1.Damaged {
+		t.Error("Layer in use should not be reinstalled")
+	}
+
+	// Damaged layers are reinstalled when they are desired again after they become cached
+
+	if _, err = layerManager.ProcessDesiredLayers(nil); err != nil {
+		t.Fatalf("Can't process desired layers: %v", err)
+	}
+
+	if _, err = layerManager.ProcessDesiredLayers(desiredLayers); err != nil {
+		t.Fatalf("Can't process desired layers: %v", err)
+	}
+
+	for _, desiredLayer := range desiredLayers {
+		layer, err := layerManager.GetLayerInfoByDigest(desiredLayer.Digest)
+		if err != nil {
+			t.Fatalf("Can't get layer info: %v", err)
+		}
+
+		if layer.Damaged {
+			t.Errorf("Reinstalled layer %s should not be damaged", layer.LayerID)
+		}
+	}
+
+	if damaged, err = layerManager.CheckIntegrity(context.Background(), scrubber.OpenFile); err != nil {
+		t.Fatalf("Can't check integrity: %v", err)
+	}
+
+	if len(damaged) != 0 {
+		t.Errorf("Unexpected damaged layers: %v", damaged)
+	}
+}
+
+func TestLayerImageStorage(t *testing.T) {
+	layerAllocator = &testAllocator{}
+	layerStorage := &testLayerStorage{}
+
+	defer func() {
+		if err := os.RemoveAll(layersDir); err != nil {
+			t.Errorf("Can't remove layers dir: %v", err)
+		}
+	}()
+
+	config := &config.Config{
+		LayersDir:    layersDir,
+		ExtractDir:   filepath.Join(tmpDir, "extract"),
+		DownloadDir:  filepath.Join(tmpDir, "download"),
+		ImageStorage: config.ImageStorage{Format: fsimage.FormatEROFS, Verity: true},
+	}
+
+	var failVerity bool
+
+	// Image is a copy of layer dir, verity device is image itself, mount copies image content back to layer dir
+	fsimage.RunCommand = func(name string, args ...string) ([]byte, error) {
+		switch {
+		case name == "mkfs.erofs":
+			return nil, copyDir(args[1], args[0])
+
+		case name == "veritysetup" && args[0] == "format":
+			return []byte("Root hash: 0123456789abcdef"), aoserrors.Wrap(os.WriteFile(args[2], nil, 0o600))
+
+		case name == "veritysetup" && args[0] == "open":
+			if failVerity {
+				return nil, aoserrors.New("verity verification failed")
+			}
+
+			return nil, aoserrors.Wrap(os.Symlink(args[1], filepath.Join(tmpDir, args[2])))
+
+		case name == "veritysetup" && args[0] == "close":
+			return nil, nil
+
+		case name == "mount":
+			target, err := os.Readlink(filepath.Join(tmpDir, filepath.Base(args[4])))
+			if err != nil {
+				return nil, aoserrors.Wrap(err)
+			}
+
+			if err = os.Remove(filepath.Join(tmpDir, filepath.Base(args[4]))); err != nil {
+				return nil, aoserrors.Wrap(err)
+			}
+
+			return nil, copyDir(target, args[5])
+		}
+
+		return nil, aoserrors.Errorf("unexpected command: %s %v", name, args)
+	}
+
+	layerManager, err := layermanager.New(
+		config, layerStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier())
+	if err != nil {
+		t.Fatalf("Can't create layer manager: %v", err)
+	}
+
+	layerInfo, err := createLayer(filepath.Join(tmpDir, "imagelayerdir"), int64(kilobyte), "imagelayer")
+	if err != nil {
+		t.Fatalf("Can't create layer: %v", err)
+	}
+
+	if _, err = layerManager.ProcessDesiredLayers([]aostypes.LayerInfo{layerInfo}); err != nil {
+		t.Fatalf("Can't process desired layers: %v", err)
+	}
+
+	layer, err := layerManager.GetLayerInfoByDigest(layerInfo.Digest)
+	if err != nil {
+		t.Fatalf("Can't get layer info: %v", err)
+	}
+
+	imageInfo, err := layerStorage.GetFSImage(layer.Path)
+	if err != nil {
+		t.Fatalf("Can't get layer image info: %v", err)
+	}
+
+	if imageInfo.RootHash != "0123456789abcdef" {
+		t.Errorf("Wrong root hash: %s", imageInfo.RootHash)
+	}
+
+	damaged, err := layerManager.CheckIntegrity(context.Background(), scrubber.OpenFile)
+	if err != nil {
+		t.Fatalf("Can't check integrity: %v", err)
+	}
+
+	if len(damaged) != 0 {
+		t.Errorf("Unexpected damaged layers: %v", damaged)
+	}
+
+	layerManager.Close()
+
+	// Emulate reboot with tampered image: image files are kept, layer is marked as damaged
+
+	if err = os.RemoveAll(layer.Path); err != nil {
+		t.Fatalf("Can't remove layer dir: %v", err)
+	}
+
+	if err = os.MkdirAll(layer.Path, 0o755); err != nil {
+		t.Fatalf("Can't create layer dir: %v", err)
+	}
+
+	failVerity = true
+
+	if layerManager, err = layermanager.New(
+		config, layerStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier()); err != nil {
+		t.Fatalf("Can't create layer manager: %v", err)
+	}
+	defer layerManager.Close()
+
+	if _, err = os.Stat(imageInfo.ImagePath); err != nil {
+		t.Errorf("Layer image should not be removed: %v", err)
+	}
+
+	if layer, err = layerManager.GetLayerInfoByDigest(layerInfo.Digest); err != nil {
+		t.Fatalf("Can't get layer info: %v", err)
+	}
+
+	if !layer.Damaged {
+		t.Error("Layer should be marked as damaged")
+	}
+}
+
+func TestLayerEvictionPolicy(t *testing.T) {
+	layerAllocator = &testAllocator{}
+	layerStorage := &testLayerStorage{}
+
+	defer func() {
+		if err := os.RemoveAll(layersDir); err != nil {
+			t.Errorf("Can't remove layers dir: %v", err)
+		}
+	}()
+
+	// Emulate min free space is reached when only two layers are left
+	getAvailableSpace := eviction.GetAvailableSpace
+	defer func() { eviction.GetAvailableSpace = getAvailableSpace }()
+
+	eviction.GetAvailableSpace = func(path string) (uint64, error) {
+		layers, err := layerStorage.GetLayersInfo()
+		if err != nil {
+			return 0, err
+		}
+
+		if len(layers) > 2 {
+			return 0, nil
+		}
+
+		return megabyte, nil
+	}
+
+	layerManager, err := layermanager.New(
+		&config.Config{
+			LayersDir:   layersDir,
+			ExtractDir:  filepath.Join(tmpDir, "extract"),
+			DownloadDir: filepath.Join(tmpDir, "download"),
+			LayerEviction: config.Eviction{
+				SizeWeight:   aostypes.Duration{Duration: time.Hour},
+				MinFreeSpace: megabyte,
+				Pins:         []config.EvictionPin{{ID: "layer3", AosVersion: 1}},
+			},
+		}, layerStorage, newTestDownloader(filepath.Join(tmpDir, "download")), newTestSignatureVerifier())
+	if err != nil {
+		t.Fatalf("Can't create layer manager: %v", err)
+	}
+	defer layerManager.Close()
+
+	var desiredLayers []aostypes.LayerInfo
+
+	for i, size := range []uint64{kilobyte, 256 * kilobyte, 512 * kilobyte} {
+		layerInfo, err := createLayer(
+			filepath.Join(tmpDir, fmt.Sprintf("layerdir%d", i)), int64(size), fmt.Sprintf("layer%d", i+1))
+		if err != nil {
+			t.Fatalf("Can't create layer: %v", err)
+		}
+
+		layerInfo.AosVersion = 1
+
+		desiredLayers = append(desiredLayers, layerInfo)
+	}
+
+	if _, err = layerManager.ProcessDesiredLayers(desiredLayers); err != nil {
+		t.Fatalf("Can't process desired layers: %v", err)
+	}
+
+	if _, err = layerManager.ProcessDesiredLayers(nil); err != nil {
+		t.Fatalf("Can't process desired layers: %v", err)
+	}
+
+	// Pinned layer3 is not outdated, bigger layer2 is evicted first to reach min free space
+
+	layerStorage.Lock()
+	report := layerStorage.evicted
+	layerStorage.Unlock()
+
+	if len(report) != 1 || report[0].ID != "layer2" || report[0].Reason != eviction.ReasonMinFreeSpace ||
+		report[0].Type != eviction.LayerItem {
+		t.Errorf("Wrong eviction report: %v", report)
+	}
+
+	if _, err = layerManager.GetLayerInfoByDigest(desiredLayers[1].Digest); err == nil {
+		t.Error("Layer2 should be evicted")
+	}
+
+	for _, i := range []int{0, 2} {
+		if _, err = layerManager.GetLayerInfoByDigest(desiredLayers[i].Digest); err != nil {
+			t.Errorf("Can't get layer info: %v", err)
+		}
+	}
+
+	if len(layerAllocator.outdatedItems) != 1 || layerAllocator.outdatedItems[0].id != desiredLayers[0].Digest {
+		t.Errorf("Wrong outdated items: %v", layerAllocator.outdatedItems)
+	}
+}
+
+func TestInstallLayersPartialFailure(t *testing.T) {
+	testLayerStorage := &testLayerStorage{}
+
+	layerAllocator = &testAllocator{}
+
+	defer func() {
+		if err := os.RemoveAll(layersDir); err != nil {
+			t.Errorf("Can't remove layers dir: %v", err)
+		}
+	}()
+
+	layerManager, err := layermanager.New(
+		&config.Config{
+			LayersDir:   layersDir,
+			ExtractDir:  filepath.Join(tmpDir, "extract"),
+			DownloadDir: filepath.Join(tmpDir, "download"),
+		}, testLayerStorage, newTestDownloader(filepath.Join(tmpDir, "download")), newTestSignatureVerifier())
+	if err != nil {
+		t.Fatalf("Can't create layer manager: %v", err)
+	}
+	defer layerManager.Close()
+
+	var desiredLayers []aostypes.LayerInfo
+
+	for i, layerID := range []string{"layer1", "brokenLayer", "layer2", "layer3", "layer4", "layer5"} {
+		layerInfo, err := createLayer(
+			filepath.Join(tmpDir, fmt.Sprintf("layerdir%d", i)), int64(uint64(i+1)*kilobyte), layerID)
+		if err != nil {
+			t.Fatalf("Can't prepare layer: %v", err)
+		}
+
+		if layerID == "brokenLayer" {
+			layerInfo.Sha256 = []byte("wrong checksum")
+		}
+
+		desiredLayers = append(desiredLayers, layerInfo)
+	}
 
-	if _, err := os.Stat(testLayerDir); err == nil {
-		t.Error("Test layer folder should be deleted")
+	if _, err := layerManager.ProcessDesiredLayers(desiredLayers); err == nil {
+		t.Error("Error expected")
 	}
 
-	layerInfo, err = createLayer(filepath.Join(tmpDir, "layerdir2"), sizeLayerContent, "layer2")
+	layers, err := testLayerStorage.GetLayersInfo()
 	if err != nil {
-		t.Fatalf("Can't create layer: %v", err)
+		t.Fatalf("Can't get layers info: %v", err)
 	}
 
-	if err = layerManager.ProcessDesiredLayers([]aostypes.LayerInfo{layerInfo}); err != nil {
-		t.Fatalf("Can't install layer: %v", err)
+	if len(layers) != len(desiredLayers)-1 {
+		t.Errorf("Wrong installed layers count: %d", len(layers))
 	}
 
-	layer, err := layerManager.GetLayerInfoByDigest(layerInfo.Digest)
-	if err != nil {
-		t.Fatalf("Can't get layer: %v", err)
+	var installedSize uint64
+
+	for _, layer := range layers {
+		if layer.LayerID == "brokenLayer" {
+			t.Errorf("Layer %s should not be installed", layer.LayerID)
+		}
+
+		installedSize += layer.Size
 	}
 
-	if err = os.RemoveAll(layer.Path); err != nil {
-		t.Fatalf("Can't remove layer path: %v", err)
+	if layerAllocator.allocatedSize != installedSize {
+		t.Errorf("Wrong allocated size: %d, expected: %d", layerAllocator.allocatedSize, installedSize)
 	}
+}
 
-	layerManager.Close()
+func TestUntrustedLayer(t *testing.T) {
+	testLayerStorage := &testLayerStorage{}
 
 	layerAllocator = &testAllocator{}
 
-	layerManager, err = layermanager.New(
+	defer func() {
+		if err := os.RemoveAll(layersDir); err != nil {
+			t.Errorf("Can't remove layers dir: %v", err)
+		}
+	}()
+
+	layerManager, err := layermanager.New(
 		&config.Config{
 			LayersDir:   layersDir,
 			ExtractDir:  filepath.Join(tmpDir, "extract"),
 			DownloadDir: filepath.Join(tmpDir, "download"),
-		}, testStorage)
+		}, testLayerStorage, newTestDownloader(filepath.Join(tmpDir, "download")),
+		newTestSignatureVerifier("untrustedLayer"))
 	if err != nil {
 		t.Fatalf("Can't create layer manager: %v", err)
 	}
 	defer layerManager.Close()
 
-	if _, err = layerManager.GetLayerInfoByDigest(layerInfo.Digest); err == nil {
-		t.Fatal("Should be error: layer doesn't exist")
+	var desiredLayers []aostypes.LayerInfo
+
+	for i, layerID := range []string{"layer1", "untrustedLayer"} {
+		layerInfo, err := createLayer(
+			filepath.Join(tmpDir, fmt.Sprintf("layerdir%d", i)), int64(uint64(i+1)*kilobyte), layerID)
+		if err != nil {
+			t.Fatalf("Can't prepare layer: %v", err)
+		}
+
+		desiredLayers = append(desiredLayers, layerInfo)
+	}
+
+	if _, err := layerManager.ProcessDesiredLayers(desiredLayers); !errors.Is(err, signature.ErrVerificationFailed) {
+		t.Errorf("Unexpected process desired layers error: %v", err)
+	}
+
+	layers, err := testLayerStorage.GetLayersInfo()
+	if err != nil {
+		t.Fatalf("Can't get layers info: %v", err)
+	}
+
+	if len(layers) != 1 || layers[0].LayerID != "layer1" {
+		t.Errorf("Wrong installed layers: %v", layers)
 	}
 }
 
-func TestRemoteDownloadLayer(t *testing.T) {
+func TestRegistryLayer(t *testing.T) {
 	layerAllocator = &testAllocator{}
 
 	defer func() {
@@ -339,12 +949,27 @@ func TestRemoteDownloadLayer(t *testing.T) {
 		}
 	}()
 
+	registryDir, err := os.MkdirTemp("", "layers_registry")
+	if err != nil {
+		t.Fatalf("Error create temporary dir: %v", err)
+	}
+
+	defer os.RemoveAll(registryDir)
+
+	server := httptest.NewServer(http.FileServer(http.Dir(registryDir)))
+	defer server.Close()
+
+	registryHost := strings.TrimPrefix(server.URL, "http://")
+	layerStorage := &testLayerStorage{}
+	testDownloader := newTestDownloader(filepath.Join(tmpDir, "download"))
+
 	layerManager, err := layermanager.New(
 		&config.Config{
 			LayersDir:   layersDir,
 			ExtractDir:  filepath.Join(tmpDir, "extract"),
 			DownloadDir: filepath.Join(tmpDir, "download"),
-		}, &testLayerStorage{})
+			Registries:  []config.Registry{{Host: registryHost, PlainHTTP: true}},
+		}, layerStorage, testDownloader, newTestSignatureVerifier())
 	if err != nil {
 		t.Fatalf("Can't create layer manager: %v", err)
 	}
@@ -357,42 +982,57 @@ func TestRemoteDownloadLayer(t *testing.T) {
 		t.Fatalf("Can't create layer: %v", err)
 	}
 
-	fileServerDir, err := os.MkdirTemp("", "layers_fileserver")
+	manifestDigest, err := pushLayer(registryDir, "aos/layer1", layerInfo)
 	if err != nil {
-		t.Fatalf("Error create temporary dir: %v", err)
+		t.Fatalf("Can't push layer: %v", err)
 	}
 
-	defer os.RemoveAll(fileServerDir)
+	// Layer digest doesn't match registry manifest
 
-	urlVal, err := url.Parse(layerInfo.URL)
-	if err != nil {
-		t.Fatalf("Can't parse url: %v", err)
+	wrongLayerInfo := layerInfo
+
+	wrongLayerInfo.Digest = string(digest.FromString("wrong layer"))
+	wrongLayerInfo.URL = fmt.Sprintf("oci://%s/aos/layer1@%s", registryHost, manifestDigest)
+
+	statuses, err := layerManager.ProcessDesiredLayers([]aostypes.LayerInfo{wrongLayerInfo})
+	if err == nil {
+		t.Error("Error expected")
 	}
 
-	if err = os.Rename(urlVal.Path, filepath.Join(fileServerDir, "downloadImage")); err != nil {
-		t.Fatalf("Can't rename directory: %v", err)
+	if len(statuses) != 1 || statuses[0].Digest != wrongLayerInfo.Digest ||
+		statuses[0].Status != cloudprotocol.ErrorStatus || statuses[0].ErrorInfo == nil {
+		t.Errorf("Wrong layer statuses: %v", statuses)
 	}
 
-	server := &http.Server{
-		Addr:              ":9000",
-		Handler:           http.FileServer(http.Dir(fileServerDir)),
-		ReadHeaderTimeout: 10 * time.Second,
+	if layerAllocator.allocatedSize != 0 {
+		t.Errorf("Wrong allocated size: %d", layerAllocator.allocatedSize)
 	}
 
-	go func() {
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Errorf("Can't serve http server: %v", err)
-		}
-	}()
+	layerInfo.URL = fmt.Sprintf("oci://%s/aos/layer1@%s", registryHost, manifestDigest)
 
-	time.Sleep(1 * time.Second)
+	if statuses, err = layerManager.ProcessDesiredLayers([]aostypes.LayerInfo{layerInfo}); err != nil {
+		t.Fatalf("Can't install layer: %v", err)
+	}
 
-	defer server.Close()
+	if len(statuses) != 1 || statuses[0].Status != cloudprotocol.InstalledStatus {
+		t.Errorf("Wrong layer statuses: %v", statuses)
+	}
 
-	layerInfo.URL = "http://:9000/downloadImage"
+	layer, err := layerManager.GetLayerInfoByDigest(layerInfo.Digest)
+	if err != nil {
+		t.Fatalf("Can't get layer info: %v", err)
+	}
 
-	if err = layerManager.ProcessDesiredLayers([]aostypes.LayerInfo{layerInfo}); err != nil {
-		t.Fatalf("Can't install layer: %v", err)
+	if _, err = os.Stat(filepath.Join(layer.Path, "layer.txt")); err != nil {
+		t.Errorf("Can't find layer content: %v", err)
+	}
+
+	if len(testDownloader.pulledURLs) != 1 {
+		t.Errorf("Wrong pulled URLs: %v", testDownloader.pulledURLs)
+	}
+
+	if len(testDownloader.files) != 0 {
+		t.Errorf("Downloaded blobs should be released: %v", testDownloader.files)
 	}
 }
 
@@ -413,7 +1053,7 @@ func TestInstallLayerNotEnoughSpace(t *testing.T) {
 			ExtractDir:   filepath.Join(tmpDir, "extract"),
 			DownloadDir:  filepath.Join(tmpDir, "download"),
 			LayerTTLDays: 2,
-		}, &testLayerStorage{})
+		}, &testLayerStorage{}, newTestDownloader(filepath.Join(tmpDir, "download")), newTestSignatureVerifier())
 	if err != nil {
 		t.Fatalf("Can't create layer manager: %v", err)
 	}
@@ -460,7 +1100,8 @@ func TestInstallLayerNotEnoughSpace(t *testing.T) {
 	}
 
 	for _, tCase := range cases {
-		if err := layerManager.ProcessDesiredLayers(tCase.desiredLayers); !errors.Is(err, tCase.processDesiredError) {
+		_, err := layerManager.ProcessDesiredLayers(tCase.desiredLayers)
+		if !errors.Is(err, tCase.processDesiredError) {
 			t.Errorf("Can't process desired layers: %v", err)
 		}
 	}
@@ -524,7 +1165,8 @@ func (allocator *testAllocator) FreeSpace(size uint64) {
 }
 
 func (allocator *testAllocator) AddOutdatedItem(id string, size uint64, timestamp time.Time) error {
-	allocator.outdatedItems = append(allocator.outdatedItems, testOutdatedItem{id: id, size: size})
+	allocator.outdatedItems = append(allocator.outdatedItems,
+		testOutdatedItem{id: id, size: size, timestamp: timestamp})
 
 	return nil
 }
@@ -554,6 +1196,9 @@ func (space *testSpace) Release() error {
 }
 
 func (infoProvider *testLayerStorage) AddLayer(layerInfo layermanager.LayerInfo) (err error) {
+	infoProvider.Lock()
+	defer infoProvider.Unlock()
+
 	if infoProvider.addLayerFail {
 		return aoserrors.New("can't add layer")
 	}
@@ -570,6 +1215,9 @@ func (infoProvider *testLayerStorage) AddLayer(layerInfo layermanager.LayerInfo)
 }
 
 func (infoProvider *testLayerStorage) DeleteLayerByDigest(digest string) (err error) {
+	infoProvider.Lock()
+	defer infoProvider.Unlock()
+
 	for i, layer := range infoProvider.layers {
 		if layer.Digest == digest {
 			infoProvider.layers = append(infoProvider.layers[:i], infoProvider.layers[i+1:]...)
@@ -582,11 +1230,14 @@ func (infoProvider *testLayerStorage) DeleteLayerByDigest(digest string) (err er
 }
 
 func (infoProvider *testLayerStorage) GetLayersInfo() (layersList []layermanager.LayerInfo, err error) {
+	infoProvider.Lock()
+	defer infoProvider.Unlock()
+
 	if infoProvider.getLayerFail {
 		return nil, aoserrors.New("can't get layers info")
 	}
 
-	layersList = infoProvider.layers
+	layersList = append([]layermanager.LayerInfo(nil), infoProvider.layers...)
 
 	return layersList, nil
 }
@@ -594,6 +1245,9 @@ func (infoProvider *testLayerStorage) GetLayersInfo() (layersList []layermanager
 func (infoProvider *testLayerStorage) GetLayerInfoByDigest(
 	digest string,
 ) (layerInfo layermanager.LayerInfo, err error) {
+	infoProvider.Lock()
+	defer infoProvider.Unlock()
+
 	for _, layer := range infoProvider.layers {
 		if layer.Digest == digest {
 			return layer, nil
@@ -604,6 +1258,9 @@ func (infoProvider *testLayerStorage) GetLayerInfoByDigest(
 }
 
 func (infoProvider *testLayerStorage) SetLayerCached(digest string, cached bool) error {
+	infoProvider.Lock()
+	defer infoProvider.Unlock()
+
 	for i, layer := range infoProvider.layers {
 		if layer.Digest == digest {
 			infoProvider.layers[i].Cached = cached
@@ -615,7 +1272,114 @@ func (infoProvider *testLayerStorage) SetLayerCached(digest string, cached bool)
 	return aoserrors.New("layer not found")
 }
 
+func (infoProvider *testLayerStorage) SetLayerDamaged(digest string, damaged bool) error {
+	infoProvider.Lock()
+	defer infoProvider.Unlock()
+
+	for i, layer := range infoProvider.layers {
+		if layer.Digest == digest {
+			infoProvider.layers[i].Damaged = damaged
+
+			return nil
+		}
+	}
+
+	return layermanager.ErrNotExist
+}
+
+func (infoProvider *testLayerStorage) AddFSImage(info fsimage.ImageInfo) error {
+	infoProvider.Lock()
+	defer infoProvider.Unlock()
+
+	if infoProvider.images == nil {
+		infoProvider.images = make(map[string]fsimage.ImageInfo)
+	}
+
+	infoProvider.images[info.MountPoint] = info
+
+	return nil
+}
+
+func (infoProvider *testLayerStorage) GetFSImage(mountPoint string) (fsimage.ImageInfo, error) {
+	infoProvider.Lock()
+	defer infoProvider.Unlock()
+
+	info, ok := infoProvider.images[mountPoint]
+	if !ok {
+		return info, fsimage.ErrNotExist
+	}
+
+	return info, nil
+}
+
+func (infoProvider *testLayerStorage) RemoveFSImage(mountPoint string) error {
+	infoProvider.Lock()
+	defer infoProvider.Unlock()
+
+	if _, ok := infoProvider.images[mountPoint]; !ok {
+		return fsimage.ErrNotExist
+	}
+
+	delete(infoProvider.images, mountPoint)
+
+	return nil
+}
+
+func (infoProvider *testLayerStorage) SetItemLastUsed(itemType, id string, lastUsed time.Time) error {
+	infoProvider.Lock()
+	defer infoProvider.Unlock()
+
+	if infoProvider.usage == nil {
+		infoProvider.usage = make(map[string]time.Time)
+	}
+
+	infoProvider.usage[itemType+"/"+id] = lastUsed
+
+	return nil
+}
+
+func (infoProvider *testLayerStorage) GetItemLastUsed(itemType, id string) (time.Time, error) {
+	infoProvider.Lock()
+	defer infoProvider.Unlock()
+
+	lastUsed, ok := infoProvider.usage[itemType+"/"+id]
+	if !ok {
+		return lastUsed, eviction.ErrNotExist
+	}
+
+	return lastUsed, nil
+}
+
+func (infoProvider *testLayerStorage) AddEvictedItem(item eviction.EvictedItem, maxItems int) error {
+	infoProvider.Lock()
+	defer infoProvider.Unlock()
+
+	infoProvider.evicted = append(infoProvider.evicted, item)
+
+	if len(infoProvider.evicted) > maxItems {
+		infoProvider.evicted = infoProvider.evicted[len(infoProvider.evicted)-maxItems:]
+	}
+
+	return nil
+}
+
+func (infoProvider *testLayerStorage) RemoveItemUsage(itemType, id string) error {
+	infoProvider.Lock()
+	defer infoProvider.Unlock()
+
+	if _, ok := infoProvider.usage[itemType+"/"+id]; !ok {
+		return eviction.ErrNotExist
+	}
+
+	delete(infoProvider.usage, itemType+"/"+id)
+
+	return nil
+}
+
 func (infoProvider *testLayerStorage) SetLayerTimestamp(digest string, timestamp time.Time) error {
+	infoProvider.Lock()
+	defer infoProvider.Unlock()
+
 	for i, layer := range infoProvider.layers {
 		if layer.Digest == digest {
 			infoProvider.layers[i].Timestamp = timestamp
@@ -627,6 +1391,137 @@ func (infoProvider *testLayerStorage) SetLayerTimestamp(digest string, timestamp
 	return aoserrors.New("layer not found")
 }
 
+func newTestSignatureVerifier(untrustedIDs ...string) *testSignatureVerifier {
+	return &testSignatureVerifier{untrustedIDs: untrustedIDs}
+}
+
+func (verifier *testSignatureVerifier) Verify(fileName string, image signature.Image) error {
+	if _, err := os.Stat(fileName); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	for _, untrustedID := range verifier.untrustedIDs {
+		if image.ID == untrustedID {
+			return aoserrors.Wrap(signature.ErrVerificationFailed)
+		}
+	}
+
+	return nil
+}
+
+func newTestDownloader(downloadDir string) *testDownloader {
+	return &testDownloader{downloadDir: downloadDir, files: make(map[string]string)}
+}
+
+func (downloader *testDownloader) Download(
+	ctx context.Context, url string, fileInfo image.FileInfo,
+) (fileName string, err error) {
+	if fileName, err = image.Download(ctx, downloader.downloadDir, url); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	downloader.Lock()
+	defer downloader.Unlock()
+
+	downloader.files[url] = fileName
+
+	return fileName, nil
+}
+
+func (downloader *testDownloader) DownloadWithHeader(
+	ctx context.Context, url string, header http.Header, fileInfo image.FileInfo,
+) (fileName string, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	req.Header = header.Clone()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", aoserrors.Errorf("download failed: %s", resp.Status)
+	}
+
+	file, err := os.CreateTemp(downloader.downloadDir, "")
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+	defer file.Close()
+
+	if _, err = io.Copy(file, resp.Body); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	downloader.Lock()
+	defer downloader.Unlock()
+
+	downloader.files[url] = file.Name()
+	downloader.pulledURLs = append(downloader.pulledURLs, url)
+
+	return file.Name(), nil
+}
+
+func (downloader *testDownloader) Release(url string) error {
+	downloader.Lock()
+	defer downloader.Unlock()
+
+	fileName, ok := downloader.files[url]
+	if !ok {
+		return nil
+	}
+
+	delete(downloader.files, url)
+
+	return aoserrors.Wrap(os.RemoveAll(fileName))
+}
+
+func (downloader *testDownloader) Unpack(
+	ctx context.Context, url string, fileInfo image.FileInfo, destination string, allocator spaceallocator.Allocator,
+) (size uint64, space spaceallocator.Space, err error) {
+	fileName, err := downloader.Download(ctx, url, fileInfo)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer func() {
+		if releaseErr := downloader.Release(url); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+	}()
+
+	if err = image.CheckFileInfo(ctx, fileName, fileInfo); err != nil {
+		return 0, nil, aoserrors.Wrap(err)
+	}
+
+	contentSize, err := image.GetUncompressedTarContentSize(fileName)
+	if err != nil {
+		return 0, nil, aoserrors.Wrap(err)
+	}
+
+	if space, err = allocator.AllocateSpace(uint64(contentSize)); err != nil {
+		return 0, nil, aoserrors.Wrap(err)
+	}
+
+	if err = image.UnpackTarImage(fileName, destination); err != nil {
+		_ = space.Release()
+
+		return 0, nil, aoserrors.Wrap(err)
+	}
+
+	downloader.Lock()
+	defer downloader.Unlock()
+
+	downloader.unpackedURLs = append(downloader.unpackedURLs, url)
+
+	return uint64(contentSize), space, nil
+}
+
 /***********************************************************************************************************************
  * Private
  **********************************************************************************************************************/
@@ -756,6 +1651,73 @@ func generateAndSaveDigest(folder string, data []byte) (retDigest digest.Digest,
 	return retDigest, nil
 }
 
+func pushLayer(registryDir, repository string, layerInfo aostypes.LayerInfo) (digest.Digest, error) {
+	packageDir, err := os.MkdirTemp("", "aos_")
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	defer os.RemoveAll(packageDir)
+
+	if output, err := exec.Command(
+		"tar", "-C", packageDir, "-xzf", strings.TrimPrefix(layerInfo.URL, "file://")).CombinedOutput(); err != nil {
+		return "", aoserrors.Errorf("tar error: %s, code: %s", string(output), err)
+	}
+
+	layerData, err := os.ReadFile(filepath.Join(packageDir, digest.Digest(layerInfo.Digest).Hex()))
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	configData := []byte("{}")
+
+	blobsDir := filepath.Join(registryDir, "v2", repository, "blobs")
+
+	if err = os.MkdirAll(blobsDir, 0o755); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	for _, data := range [][]byte{layerData, configData} {
+		if err = os.WriteFile(filepath.Join(blobsDir, digest.FromBytes(data).String()), data, 0o600); err != nil {
+			return "", aoserrors.Wrap(err)
+		}
+	}
+
+	manifest := imagespec.Manifest{
+		MediaType: imagespec.MediaTypeImageManifest,
+		Config: imagespec.Descriptor{
+			MediaType: imagespec.MediaTypeImageConfig,
+			Digest:    digest.FromBytes(configData),
+			Size:      int64(len(configData)),
+		},
+		Layers: []imagespec.Descriptor{{
+			MediaType: "application/vnd.aos.image.layer.v1.tar",
+			Digest:    digest.Digest(layerInfo.Digest),
+			Size:      int64(len(layerData)),
+		}},
+	}
+
+	manifest.SchemaVersion = 2
+
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	manifestDigest := digest.FromBytes(manifestData)
+	manifestsDir := filepath.Join(registryDir, "v2", repository, "manifests")
+
+	if err = os.MkdirAll(manifestsDir, 0o755); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	if err = os.WriteFile(filepath.Join(manifestsDir, manifestDigest.String()), manifestData, 0o600); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	return manifestDigest, nil
+}
+
 func getDesiredLayers(layers map[string]aostypes.LayerInfo, layersID []string) (desiredLayers []aostypes.LayerInfo) {
 	for _, layerID := range layersID {
 		layer, ok := layers[layerID]
@@ -766,3 +1728,15 @@ func getDesiredLayers(layers map[string]aostypes.LayerInfo, layersID []string) (
 
 	return
 }
+
+func copyDir(source, destination string) error {
+	if err := os.MkdirAll(destination, 0o755); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if output, err := exec.Command("cp", "-a", source+"/.", destination).CombinedOutput(); err != nil {
+		return aoserrors.Errorf("cp: %v: %s", err, output)
+	}
+
+	return nil
+}
@@ -50,9 +50,20 @@ const (
  * Types
  **********************************************************************************************************************/
 
-// InstanceIDProvider provides instances ID.
+// InstanceIDProvider provides instances ID and instances restart history.
 type InstanceIDProvider interface {
 	GetInstanceIDs(ids cloudprotocol.InstanceFilter) ([]string, error)
+	GetInstanceHistory(filter cloudprotocol.InstanceFilter) ([]InstanceEvent, error)
+}
+
+// InstanceEvent instance history event.
+type InstanceEvent struct {
+	InstanceID string
+	Timestamp  time.Time
+	Type       string
+	ExitCode   int
+	Signal     int
+	Message    string
 }
 
 // Logging instance.
@@ -79,6 +90,7 @@ type getLogRequest struct {
 	logID       string
 	from        *time.Time
 	till        *time.Time
+	events      []InstanceEvent
 }
 
 /***********************************************************************************************************************
@@ -151,6 +163,12 @@ func (instance *Logging) GetInstanceCrashLog(request cloudprotocol.RequestLog) e
 		return err
 	}
 
+	if logRequest.events, err = instance.getInstanceEvents(request); err != nil {
+		instance.sendErrorResponse(err.Error(), request.LogID)
+
+		return err
+	}
+
 	if len(logRequest.instanceIDs) == 0 {
 		log.WithField("logID", request.LogID).Debug("No instance ids for log request")
 
@@ -316,7 +334,7 @@ func (instance *Logging) getInstanceCrashLog(request getLogRequest) (err error)
 		return aoserrors.Wrap(err)
 	}
 
-	if crashTime == 0 {
+	if crashTime == 0 && len(request.events) == 0 {
 		log.WithFields(log.Fields{
 			"logID":       request.logID,
 			"instanceIDs": request.instanceIDs,
@@ -327,17 +345,29 @@ func (instance *Logging) getInstanceCrashLog(request getLogRequest) (err error)
 		return nil
 	}
 
-	if err = journal.AddDisjunction(); err != nil {
+	archInstance, err := newArchivator(instance.logChannel, instance.config.MaxPartSize, instance.config.MaxPartCount)
+	if err != nil {
 		return aoserrors.Wrap(err)
 	}
 
-	if err = instance.addServiceCgroupFilter(journal, request.instanceIDs); err != nil {
-		return aoserrors.Wrap(err)
+	for _, event := range request.events {
+		if err = archInstance.addLog(createEventString(event)); err != nil {
+			return aoserrors.Wrap(err)
+		}
 	}
 
-	archInstance, err := instance.archivateCrashLog(journal, crashTime, request.instanceIDs)
-	if err != nil {
-		return aoserrors.Wrap(err)
+	if crashTime != 0 {
+		if err = journal.AddDisjunction(); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if err = instance.addServiceCgroupFilter(journal, request.instanceIDs); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if err = instance.archivateCrashLog(archInstance, journal, crashTime, request.instanceIDs); err != nil {
+			return aoserrors.Wrap(err)
+		}
 	}
 
 	if err = archInstance.sendLog(request.logID); err != nil {
@@ -391,18 +421,13 @@ func (instance *Logging) getCrashTime(journal JournalInterface, from *time.Time)
 }
 
 func (instance *Logging) archivateCrashLog(
-	journal JournalInterface, crashTime uint64, instanceIDs []string,
-) (archivator *archivator, err error) {
-	archivator, err = newArchivator(instance.logChannel, instance.config.MaxPartSize, instance.config.MaxPartCount)
-	if err != nil {
-		return nil, aoserrors.Wrap(err)
-	}
-
+	archivator *archivator, journal JournalInterface, crashTime uint64, instanceIDs []string,
+) (err error) {
 	for {
 		var rowCount uint64
 
 		if rowCount, err = journal.Next(); err != nil {
-			return archivator, aoserrors.Wrap(err)
+			return aoserrors.Wrap(err)
 		}
 
 		// end of log
@@ -413,7 +438,7 @@ func (instance *Logging) archivateCrashLog(
 		var logEntry *sdjournal.JournalEntry
 
 		if logEntry, err = journal.GetEntry(); err != nil {
-			return archivator, aoserrors.Wrap(err)
+			return aoserrors.Wrap(err)
 		}
 
 		if logEntry.MonotonicTimestamp > crashTime {
@@ -423,13 +448,13 @@ func (instance *Logging) archivateCrashLog(
 		for _, instanceID := range instanceIDs {
 			if strings.Contains(getUnitNameFromLog(logEntry), makeUnitNameFromInstanceID(instanceID)) {
 				if err = archivator.addLog(createLogString(logEntry, false)); err != nil {
-					return archivator, aoserrors.Wrap(err)
+					return aoserrors.Wrap(err)
 				}
 			}
 		}
 	}
 
-	return archivator, nil
+	return nil
 }
 
 func (instance *Logging) sendErrorResponse(errorStr, logID string) {
@@ -510,6 +535,35 @@ func (instance *Logging) prepareInstanceLogRequest(
 	}, nil
 }
 
+func (instance *Logging) getInstanceEvents(
+	request cloudprotocol.RequestLog,
+) (events []InstanceEvent, err error) {
+	allEvents, err := instance.instanceProvider.GetInstanceHistory(request.Filter.InstanceFilter)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	for _, event := range allEvents {
+		if (request.Filter.From != nil && event.Timestamp.Before(*request.Filter.From)) ||
+			(request.Filter.Till != nil && event.Timestamp.After(*request.Filter.Till)) {
+			continue
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func createEventString(event InstanceEvent) (logStr string) {
+	if event.ExitCode == 0 && event.Signal == 0 && event.Message == "" {
+		return fmt.Sprintf("%s instance %s %s \n", event.Timestamp, event.InstanceID, event.Type)
+	}
+
+	return fmt.Sprintf("%s instance %s %s: exit code %d, signal %d, %s \n", event.Timestamp, event.InstanceID,
+		event.Type, event.ExitCode, event.Signal, event.Message)
+}
+
 func createLogString(entry *sdjournal.JournalEntry, addUnit bool) (logStr string) {
 	if addUnit {
 		return fmt.Sprintf("%s %s %s \n", getLogDate(entry), entry.Fields[sdjournal.SD_JOURNAL_FIELD_SYSTEMD_UNIT],
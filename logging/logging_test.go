@@ -69,6 +69,7 @@ func init() {
 
 type testInstanceIDProvider struct {
 	instances map[string]cloudprotocol.InstanceFilter
+	events    []logging.InstanceEvent
 }
 
 type testSystemdJournal struct {
@@ -284,6 +285,64 @@ func TestGetServiceCrashLog(t *testing.T) {
 	checkReceivedLog(t, logging.GetLogsDataChannel(), &from, &till)
 }
 
+func TestGetServiceCrashLogHistory(t *testing.T) {
+	instanceProvider := testInstanceIDProvider{instances: make(map[string]cloudprotocol.InstanceFilter)}
+	defer instanceProvider.Close()
+
+	testJournal := testSystemdJournal{}
+	logging.SDJournal = &testJournal
+
+	var (
+		instanceFilter = cloudprotocol.NewInstanceFilter("logservice5", "subject5", 0)
+		instanceID     = instanceProvider.addFilter(instanceFilter)
+		now            = time.Now()
+	)
+
+	instanceProvider.events = []logging.InstanceEvent{
+		{InstanceID: instanceID, Timestamp: now.Add(-time.Hour), Type: "start"},
+		{InstanceID: instanceID, Timestamp: now.Add(-time.Minute), Type: "start"},
+		{
+			InstanceID: instanceID, Timestamp: now, Type: "crash",
+			ExitCode: 137, Signal: 9, Message: "instance killed",
+		},
+	}
+
+	logging, err := logging.New(&config.Config{
+		Logging: config.Logging{MaxPartSize: 1024, MaxPartCount: 10},
+	}, &instanceProvider)
+	if err != nil {
+		t.Fatalf("Can't create logging: %s", err)
+	}
+	defer logging.Close()
+
+	from := now.Add(-2 * time.Minute)
+
+	if err := logging.GetInstanceCrashLog(cloudprotocol.RequestLog{
+		Filter: cloudprotocol.LogFilter{InstanceFilter: instanceFilter, From: &from},
+	}); err != nil {
+		t.Fatalf("Can't get instance crash log: %s", err)
+	}
+
+	receivedLog, err := receiveLog(logging.GetLogsDataChannel())
+	if err != nil {
+		t.Fatalf("Can't receive log: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(receivedLog), "\n")
+
+	if len(lines) != 2 {
+		t.Fatalf("Wrong log lines count: %d", len(lines))
+	}
+
+	if !strings.Contains(lines[0], instanceID+" start") {
+		t.Errorf("Wrong start event log: %s", lines[0])
+	}
+
+	if !strings.Contains(lines[1], "crash: exit code 137, signal 9, instance killed") {
+		t.Errorf("Wrong crash event log: %s", lines[1])
+	}
+}
+
 func TestMaxPartCountLog(t *testing.T) {
 	instanceProvider := testInstanceIDProvider{instances: make(map[string]cloudprotocol.InstanceFilter)}
 	defer instanceProvider.Close()
@@ -501,6 +560,21 @@ func (provider *testInstanceIDProvider) GetInstanceIDs(
 	return instances, nil
 }
 
+func (provider *testInstanceIDProvider) GetInstanceHistory(
+	filter cloudprotocol.InstanceFilter,
+) (events []logging.InstanceEvent, err error) {
+	for _, event := range provider.events {
+		instanceFilter, ok := provider.instances[event.InstanceID]
+		if !ok || (filter.ServiceID != nil && *filter.ServiceID != *instanceFilter.ServiceID) {
+			continue
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
 func (provider *testInstanceIDProvider) addFilter(filter cloudprotocol.InstanceFilter) (instanceID string) {
 	instanceID = instanceFormFilter(filter)
 
@@ -711,6 +785,36 @@ func checkReceivedLog(t *testing.T, logChannel <-chan cloudprotocol.PushLog, fro
 	}
 }
 
+func receiveLog(logChannel <-chan cloudprotocol.PushLog) (receivedLog string, err error) {
+	for {
+		select {
+		case result := <-logChannel:
+			if result.ErrorInfo != nil {
+				return "", aoserrors.Errorf("error log received: %s", result.ErrorInfo.Message)
+			}
+
+			zr, err := gzip.NewReader(bytes.NewBuffer(result.Content))
+			if err != nil {
+				return "", aoserrors.Wrap(err)
+			}
+
+			data, err := io.ReadAll(zr)
+			if err != nil {
+				return "", aoserrors.Wrap(err)
+			}
+
+			receivedLog += string(data)
+
+			if result.Part == result.PartsCount {
+				return receivedLog, nil
+			}
+
+		case <-time.After(5 * time.Second):
+			return "", aoserrors.New("receive log timeout")
+		}
+	}
+}
+
 func checkEmptyLog(t *testing.T, logChannel <-chan cloudprotocol.PushLog) {
 	t.Helper()
 
@@ -0,0 +1,257 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networkmanager
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/aostypes"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	allowedConnectionMinLen = 2
+	allowedConnectionMaxLen = 3
+	defaultConnectionProto  = "tcp"
+	connectionsChain        = "AOS_CONNECTIONS"
+	connectionsRootChain    = "FORWARD"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type dependentInstance struct {
+	instanceID string
+	networkID  string
+	data       netInstanceData
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (manager *NetworkManager) getInstanceServiceID(instanceID, networkID string) string {
+	manager.RLock()
+	defer manager.RUnlock()
+
+	return manager.instancesData[networkID][instanceID].params.ServiceID
+}
+
+// createConnectionsChain creates chain which accepts allowed connections of all instances. The chain is checked
+// before instance firewall chains. Rules of previous run are flushed.
+func (manager *NetworkManager) createConnectionsChain() error {
+	if err := manager.iptables.ClearChain("filter", connectionsChain); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err := deleteAllRules(manager.iptables, connectionsRootChain, "-j", connectionsChain); err != nil {
+		return err
+	}
+
+	if err := manager.iptables.Insert("filter", connectionsRootChain, 1, "-j", connectionsChain); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+// updateConnectionRules updates instance rules in connections chain in place: new rules are added before obsolete
+// ones are removed, so established connections are not interrupted.
+func (manager *NetworkManager) updateConnectionRules(prevRules, rules []aostypes.FirewallRule) error {
+	for _, rule := range rules {
+		if slices.Contains(prevRules, rule) {
+			continue
+		}
+
+		for _, rulespec := range getConnectionRulespecs(rule) {
+			if err := manager.iptables.Append("filter", connectionsChain, rulespec...); err != nil {
+				return aoserrors.Wrap(err)
+			}
+		}
+	}
+
+	for _, rule := range prevRules {
+		if slices.Contains(rules, rule) {
+			continue
+		}
+
+		for _, rulespec := range getConnectionRulespecs(rule) {
+			if err := deleteAllRules(manager.iptables, connectionsChain, rulespec...); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// getConnectionRules converts instance allowed connections into firewall rules which allow traffic from the instance
+// to all currently attached instances of target services.
+func (manager *NetworkManager) getConnectionRules(
+	instanceID, instanceIP string, params NetworkParams,
+) (rules []aostypes.FirewallRule, err error) {
+	manager.RLock()
+	defer manager.RUnlock()
+
+	for _, connection := range params.AllowedConnections {
+		serviceID, port, proto, err := parseAllowedConnection(connection)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, instances := range manager.instancesData {
+			for targetInstanceID, data := range instances {
+				if targetInstanceID == instanceID || data.params.ServiceID != serviceID || data.instanceIP == "" {
+					continue
+				}
+
+				rules = append(rules, aostypes.FirewallRule{
+					DstIP: data.instanceIP, DstPort: port, Proto: proto, SrcIP: instanceIP,
+				})
+			}
+		}
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].DstIP != rules[j].DstIP {
+			return rules[i].DstIP < rules[j].DstIP
+		}
+
+		if rules[i].DstPort != rules[j].DstPort {
+			return rules[i].DstPort < rules[j].DstPort
+		}
+
+		return rules[i].Proto < rules[j].Proto
+	})
+
+	return rules, nil
+}
+
+// updateAllowedConnections updates firewall rules of instances which have allowed connections to the service. It
+// should be called when instance of the service is added to or removed from network.
+func (manager *NetworkManager) updateAllowedConnections(changedInstanceID, serviceID string) {
+	if serviceID == "" {
+		return
+	}
+
+	manager.connectionsMutex.Lock()
+	defer manager.connectionsMutex.Unlock()
+
+	for _, instance := range manager.getDependentInstances(changedInstanceID, serviceID) {
+		rules, err := manager.getConnectionRules(instance.instanceID, instance.data.instanceIP, instance.data.params)
+		if err != nil {
+			log.WithField("instanceID", instance.instanceID).Errorf("Can't get connection rules: %v", err)
+
+			continue
+		}
+
+		if reflect.DeepEqual(rules, instance.data.connectionRules) {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"instanceID": instance.instanceID, "serviceID": serviceID,
+		}).Debug("Update instance allowed connections")
+
+		if err = manager.updateConnectionRules(instance.data.connectionRules, rules); err != nil {
+			log.WithField("instanceID", instance.instanceID).Errorf("Can't update allowed connections: %v", err)
+
+			continue
+		}
+
+		manager.setConnectionRules(instance.instanceID, instance.networkID, rules)
+	}
+}
+
+func (manager *NetworkManager) setConnectionRules(instanceID, networkID string, rules []aostypes.FirewallRule) {
+	manager.Lock()
+	defer manager.Unlock()
+
+	data, ok := manager.instancesData[networkID][instanceID]
+	if !ok {
+		return
+	}
+
+	data.connectionRules = rules
+	manager.instancesData[networkID][instanceID] = data
+}
+
+func (manager *NetworkManager) getDependentInstances(
+	changedInstanceID, serviceID string,
+) (dependentInstances []dependentInstance) {
+	manager.RLock()
+	defer manager.RUnlock()
+
+	for networkID, instances := range manager.instancesData {
+		for instanceID, data := range instances {
+			if instanceID == changedInstanceID {
+				continue
+			}
+
+			for _, connection := range data.params.AllowedConnections {
+				if strings.Split(connection, "/")[0] == serviceID {
+					dependentInstances = append(dependentInstances, dependentInstance{
+						instanceID: instanceID, networkID: networkID, data: data,
+					})
+
+					break
+				}
+			}
+		}
+	}
+
+	return dependentInstances
+}
+
+// getConnectionRulespecs returns iptables rules which accept connection from source to destination instance and
+// its replies.
+func getConnectionRulespecs(rule aostypes.FirewallRule) [][]string {
+	return [][]string{
+		{"-s", rule.SrcIP, "-d", rule.DstIP, "-p", rule.Proto, "--dport", rule.DstPort, "-j", "ACCEPT"},
+		{
+			"-s", rule.DstIP, "-d", rule.SrcIP, "-p", rule.Proto, "--sport", rule.DstPort,
+			"-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "ACCEPT",
+		},
+	}
+}
+
+// parseAllowedConnection parses allowed connection in serviceID/port/protocol format. Protocol is optional.
+func parseAllowedConnection(connection string) (serviceID, port, proto string, err error) {
+	connectionConfig := strings.Split(connection, "/")
+	if len(connectionConfig) < allowedConnectionMinLen || len(connectionConfig) > allowedConnectionMaxLen ||
+		connectionConfig[0] == "" || connectionConfig[1] == "" {
+		return "", "", "", aoserrors.Errorf("unsupported AllowedConnections format %s", connection)
+	}
+
+	proto = defaultConnectionProto
+
+	if len(connectionConfig) == allowedConnectionMaxLen {
+		proto = connectionConfig[2]
+	}
+
+	return connectionConfig[0], connectionConfig[1], proto, nil
+}
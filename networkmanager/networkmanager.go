@@ -26,6 +26,7 @@ import (
 	"net"
 	"os"
 	"path"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -36,6 +37,7 @@ import (
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/allocator"
+	"github.com/coreos/go-iptables/iptables"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netns"
@@ -78,20 +80,25 @@ type Storage interface {
 }
 
 type netInstanceData struct {
-	instanceIP string
-	hosts      []string
+	instanceIP      string
+	hosts           []string
+	nameservers     []string
+	params          NetworkParams
+	connectionRules []aostypes.FirewallRule
 }
 
 // NetworkManager network manager instance.
 type NetworkManager struct {
 	sync.RWMutex
 	cniInterface      cni.CNI
+	iptables          IPTablesInterface
 	hosts             []aostypes.Host
 	networkDir        string
 	trafficMonitoring *trafficMonitoring
 	instancesData     map[string]map[string]netInstanceData
 	providerNetworks  map[string]NetworkParameters
 	vlanIfNames       map[string]string
+	connectionsMutex  sync.Mutex
 
 	storage Storage
 }
@@ -114,12 +121,14 @@ type NetworkParams struct {
 	IngressKbit        uint64
 	EgressKbit         uint64
 	ExposedPorts       []string
+	AllowedConnections []string
 	Hosts              []aostypes.Host
 	DNSSevers          []string
 	HostsFilePath      string
 	ResolvConfFilePath string
 	UploadLimit        uint64
 	DownloadLimit      uint64
+	ReplacedInstanceID string
 }
 
 type cniNetwork struct {
@@ -218,6 +227,16 @@ func New(cfg *config.Config, storage Storage) (manager *NetworkManager, err erro
 		manager.cniInterface = cni.NewCNIConfigWithCacheDir([]string{cniBinPath}, cniDir, nil)
 	}
 
+	if manager.iptables = IPTables; manager.iptables == nil {
+		if manager.iptables, err = iptables.New(); err != nil {
+			return nil, aoserrors.Wrap(err)
+		}
+	}
+
+	if err = manager.createConnectionsChain(); err != nil {
+		return nil, err
+	}
+
 	networksInfo, err := storage.GetNetworksInfo()
 	if err != nil {
 		return nil, aoserrors.Wrap(err)
@@ -297,95 +316,104 @@ func (manager *NetworkManager) UpdateNetworks(networkParameters []aostypes.Netwo
 }
 
 // AddInstanceToNetwork adds instance to network.
-func (manager *NetworkManager) AddInstanceToNetwork(instanceID, networkID string, params NetworkParams) (err error) {
+func (manager *NetworkManager) AddInstanceToNetwork(instanceID, networkID string, params NetworkParams) error {
 	log.WithFields(log.Fields{"instanceID": instanceID, "networkID": networkID}).Debug("Add instance to network")
 
-	if manager.isInstanceInNetwork(instanceID, networkID) {
-		return aoserrors.Errorf("Instance %s already in the network %s", instanceID, networkID)
+	if err := manager.addInstanceToNetwork(instanceID, networkID, params); err != nil {
+		return err
 	}
 
-	manager.addInstanceNetworkToCache(instanceID, networkID)
+	manager.updateAllowedConnections(instanceID, params.ServiceID)
 
-	defer func() {
-		if err != nil {
-			if err := manager.deleteInstanceNetworkFromCache(instanceID, networkID); err != nil {
-				log.Errorf("Can't delete network instance: %v", err)
-			}
+	return nil
+}
+
+// RemoveInstanceFromNetwork removes instance from network.
+func (manager *NetworkManager) RemoveInstanceFromNetwork(instanceID, networkID string) error {
+	log.WithFields(log.Fields{"instanceID": instanceID}).Debug("Remove instance from network")
+
+	if !manager.isInstanceInNetwork(instanceID, networkID) {
+		return nil
+	}
+
+	serviceID := manager.getInstanceServiceID(instanceID, networkID)
+
+	if manager.trafficMonitoring != nil {
+		if err := manager.trafficMonitoring.stopInstanceTrafficMonitor(instanceID); err != nil {
+			return aoserrors.Wrap(err)
 		}
-	}()
+	}
 
-	if err = createNetNS(instanceID); err != nil {
+	if err := manager.removeInstanceFromNetwork(instanceID, networkID); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
-	defer func() {
-		if err != nil {
-			if delErr := netns.DeleteNamed(instanceID); delErr != nil {
-				log.Errorf("Can't delete named network namespace: %s", delErr)
-			}
-		}
-	}()
+	if err := manager.deleteInstanceNetworkFromCache(instanceID, networkID); err != nil {
+		return err
+	}
 
-	netConfig, runtimeConfig, hosts, err := manager.prepareCNIConfig(instanceID, networkID, params)
+	manager.updateAllowedConnections(instanceID, serviceID)
+
+	return nil
+}
+
+// UpdateInstanceNetwork updates network parameters of attached instance in place. Instance keeps its network
+// namespace, IP address and host names. Parameters applied by CNI plugins are changed on next instance attach.
+func (manager *NetworkManager) UpdateInstanceNetwork(instanceID, networkID string, params NetworkParams) error {
+	log.WithFields(log.Fields{"instanceID": instanceID, "networkID": networkID}).Debug("Update instance network")
+
+	data, err := manager.getInstanceData(instanceID, networkID)
 	if err != nil {
 		return err
 	}
 
-	defer func() {
-		if err != nil {
-			if err := manager.cniInterface.DelNetworkList(context.Background(), netConfig, runtimeConfig); err != nil {
-				log.Errorf("Can't delete network list: %s", err)
-			}
-		}
-	}()
+	if isCNIConfigChanged(data.params, params) {
+		log.WithField("instanceID", instanceID).Warn("Instance CNI parameters will be applied on next attach")
+	}
+
+	manager.connectionsMutex.Lock()
+
+	rules, err := manager.getConnectionRules(instanceID, data.instanceIP, params)
+	if err == nil {
+		err = manager.updateConnectionRules(data.connectionRules, rules)
+	}
+
+	manager.connectionsMutex.Unlock()
 
-	nameservers, instanceIP, err := manager.addNetwork(instanceID, netConfig, runtimeConfig)
 	if err != nil {
 		return err
 	}
 
-	if err = createResolvConfAndHostFile(networkID, instanceIP, nameservers, params); err != nil {
+	if err = createResolvConfAndHostFile(networkID, data.instanceIP, data.nameservers, params); err != nil {
 		return err
 	}
 
-	if manager.trafficMonitoring != nil {
-		if err = manager.trafficMonitoring.startInstanceTrafficMonitor(
-			instanceID, instanceIP, params.DownloadLimit, params.UploadLimit); err != nil {
+	if manager.trafficMonitoring != nil &&
+		(data.params.DownloadLimit != params.DownloadLimit || data.params.UploadLimit != params.UploadLimit) {
+		if err = manager.trafficMonitoring.stopInstanceTrafficMonitor(instanceID); err != nil {
 			return aoserrors.Wrap(err)
 		}
-	}
 
-	if err = manager.updateInstanceNetworkCache(instanceID, networkID, instanceIP, hosts); err != nil {
-		return err
+		if err = manager.trafficMonitoring.startInstanceTrafficMonitor(
+			instanceID, data.instanceIP, params.DownloadLimit, params.UploadLimit); err != nil {
+			return aoserrors.Wrap(err)
+		}
 	}
 
-	log.WithFields(log.Fields{
-		"instanceID": instanceID,
-		"IP":         instanceIP,
-	}).Debug("Instance has been added to the network")
+	prevServiceID := data.params.ServiceID
+	data.params, data.connectionRules = params, rules
 
-	return nil
-}
-
-// RemoveInstanceFromNetwork removes instance from network.
-func (manager *NetworkManager) RemoveInstanceFromNetwork(instanceID, networkID string) error {
-	log.WithFields(log.Fields{"instanceID": instanceID}).Debug("Remove instance from network")
-
-	if !manager.isInstanceInNetwork(instanceID, networkID) {
-		return nil
+	if err = manager.updateInstanceNetworkCache(instanceID, networkID, data); err != nil {
+		return err
 	}
 
-	if manager.trafficMonitoring != nil {
-		if err := manager.trafficMonitoring.stopInstanceTrafficMonitor(instanceID); err != nil {
-			return aoserrors.Wrap(err)
-		}
+	if prevServiceID != params.ServiceID {
+		manager.updateAllowedConnections(instanceID, prevServiceID)
 	}
 
-	if err := manager.removeInstanceFromNetwork(instanceID, networkID); err != nil {
-		return aoserrors.Wrap(err)
-	}
+	manager.updateAllowedConnections(instanceID, params.ServiceID)
 
-	return manager.deleteInstanceNetworkFromCache(instanceID, networkID)
+	return nil
 }
 
 // GetInstanceIP return instance IP address.
@@ -560,19 +588,15 @@ next:
 }
 
 func (manager *NetworkManager) updateInstanceNetworkCache(
-	instanceID, networkID, instanceIP string, hosts []string,
+	instanceID, networkID string, networkInstanceData netInstanceData,
 ) error {
 	manager.Lock()
 	defer manager.Unlock()
 
-	networkInstanceData, ok := manager.instancesData[networkID][instanceID]
-	if !ok {
+	if _, ok := manager.instancesData[networkID][instanceID]; !ok {
 		return aoserrors.Errorf("can't find network instanceID: %s", instanceID)
 	}
 
-	networkInstanceData.hosts = hosts
-	networkInstanceData.instanceIP = instanceIP
-
 	manager.instancesData[networkID][instanceID] = networkInstanceData
 
 	return nil
@@ -626,6 +650,114 @@ func createResolvConfAndHostFile(networkID, instanceIP string, nameservers []str
 	return nil
 }
 
+func (manager *NetworkManager) addInstanceToNetwork(instanceID, networkID string, params NetworkParams) (err error) {
+	if manager.isInstanceInNetwork(instanceID, networkID) {
+		return aoserrors.Errorf("Instance %s already in the network %s", instanceID, networkID)
+	}
+
+	manager.addInstanceNetworkToCache(instanceID, networkID)
+
+	defer func() {
+		if err != nil {
+			if err := manager.deleteInstanceNetworkFromCache(instanceID, networkID); err != nil {
+				log.Errorf("Can't delete network instance: %v", err)
+			}
+		}
+	}()
+
+	if err = createNetNS(instanceID); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	defer func() {
+		if err != nil {
+			if delErr := netns.DeleteNamed(instanceID); delErr != nil {
+				log.Errorf("Can't delete named network namespace: %s", delErr)
+			}
+		}
+	}()
+
+	netConfig, runtimeConfig, hosts, err := manager.prepareCNIConfig(instanceID, networkID, params)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			if err := manager.cniInterface.DelNetworkList(context.Background(), netConfig, runtimeConfig); err != nil {
+				log.Errorf("Can't delete network list: %s", err)
+			}
+		}
+	}()
+
+	nameservers, instanceIP, err := manager.addNetwork(instanceID, netConfig, runtimeConfig)
+	if err != nil {
+		return err
+	}
+
+	connectionRules, err := manager.addConnectionRules(instanceID, instanceIP, params)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			if err := manager.removeConnectionRules(connectionRules); err != nil {
+				log.Errorf("Can't remove connection rules: %v", err)
+			}
+		}
+	}()
+
+	if err = createResolvConfAndHostFile(networkID, instanceIP, nameservers, params); err != nil {
+		return err
+	}
+
+	if manager.trafficMonitoring != nil {
+		if err = manager.trafficMonitoring.startInstanceTrafficMonitor(
+			instanceID, instanceIP, params.DownloadLimit, params.UploadLimit); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	if err = manager.updateInstanceNetworkCache(instanceID, networkID, netInstanceData{
+		instanceIP: instanceIP, hosts: hosts, nameservers: nameservers, params: params,
+		connectionRules: connectionRules,
+	}); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"instanceID": instanceID,
+		"IP":         instanceIP,
+	}).Debug("Instance has been added to the network")
+
+	return nil
+}
+
+func (manager *NetworkManager) addConnectionRules(
+	instanceID, instanceIP string, params NetworkParams,
+) (rules []aostypes.FirewallRule, err error) {
+	manager.connectionsMutex.Lock()
+	defer manager.connectionsMutex.Unlock()
+
+	if rules, err = manager.getConnectionRules(instanceID, instanceIP, params); err != nil {
+		return nil, err
+	}
+
+	if err = manager.updateConnectionRules(nil, rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (manager *NetworkManager) removeConnectionRules(rules []aostypes.FirewallRule) error {
+	manager.connectionsMutex.Lock()
+	defer manager.connectionsMutex.Unlock()
+
+	return manager.updateConnectionRules(rules, nil)
+}
+
 func (manager *NetworkManager) addNetwork(
 	instanceID string, netConfig *cni.NetworkConfigList, runtimeConfig *cni.RuntimeConf) (
 	nameservers []string, instanceIP string, err error,
@@ -647,11 +779,10 @@ func (manager *NetworkManager) addNetwork(
 	return result.DNS.Nameservers, result.IPs[0].Address.IP.String(), nil
 }
 
-func (manager *NetworkManager) prepareCNIConfig(
-	instanceID, networkID string, params NetworkParams) (
+func (manager *NetworkManager) prepareCNIConfig(instanceID, networkID string, params NetworkParams) (
 	netConfig *cni.NetworkConfigList, runtimeConfig *cni.RuntimeConf, hosts []string, err error,
 ) {
-	if hosts, err = manager.prepareHostnameList(networkID, params); err != nil {
+	if hosts, err = manager.prepareHostnameList(instanceID, networkID, params); err != nil {
 		return nil, nil, nil, err
 	}
 
@@ -666,6 +797,18 @@ func (manager *NetworkManager) prepareCNIConfig(
 	return netConfig, manager.prepareRuntimeConfig(instanceID, networkID, hosts), hosts, nil
 }
 
+func (manager *NetworkManager) getInstanceData(instanceID, networkID string) (netInstanceData, error) {
+	manager.RLock()
+	defer manager.RUnlock()
+
+	data, ok := manager.instancesData[networkID][instanceID]
+	if !ok {
+		return netInstanceData{}, aoserrors.Errorf("instance %s not found in network %s", instanceID, networkID)
+	}
+
+	return data, nil
+}
+
 func (manager *NetworkManager) isInstanceInNetwork(instanceID, networkID string) (status bool) {
 	manager.RLock()
 	defer manager.RUnlock()
@@ -703,6 +846,15 @@ func (manager *NetworkManager) clearNetwork(networkID string) error {
 }
 
 func (manager *NetworkManager) removeInstanceFromNetwork(instanceID, networkID string) (err error) {
+	data, err := manager.getInstanceData(instanceID, networkID)
+	if err != nil {
+		return err
+	}
+
+	if err = manager.removeConnectionRules(data.connectionRules); err != nil {
+		return err
+	}
+
 	defer func() {
 		if delErr := netns.DeleteNamed(instanceID); delErr != nil {
 			log.Errorf("Can't delete named network namespace: %s", delErr)
@@ -713,6 +865,10 @@ func (manager *NetworkManager) removeInstanceFromNetwork(instanceID, networkID s
 		}
 	}()
 
+	return manager.detachInstanceFromNetwork(instanceID, networkID)
+}
+
+func (manager *NetworkManager) detachInstanceFromNetwork(instanceID, networkID string) error {
 	networkConfig, runtimeConfig := getRuntimeNetConfig(instanceID, networkID)
 
 	confBytes, runtimeConfig, err := manager.cniInterface.GetNetworkListCachedConfig(networkConfig, runtimeConfig)
@@ -764,7 +920,11 @@ func (manager *NetworkManager) prepareRuntimeConfig(instanceID, networkID string
 	return runtimeConfig
 }
 
-func (manager *NetworkManager) isHostnameExists(networkID string, hosts []string) error {
+// isHostnameExists checks host names are not used by other instances. Instance which replaces attached one during
+// rolling update shares host names with it.
+func (manager *NetworkManager) isHostnameExists(
+	instanceID, networkID string, params NetworkParams, hosts []string,
+) error {
 	manager.RLock()
 	defer manager.RUnlock()
 
@@ -773,7 +933,11 @@ func (manager *NetworkManager) isHostnameExists(networkID string, hosts []string
 		return nil
 	}
 
-	for _, networkInstanceData := range instances {
+	for existInstanceID, networkInstanceData := range instances {
+		if existInstanceID == instanceID || existInstanceID == params.ReplacedInstanceID {
+			continue
+		}
+
 		for _, existHostname := range networkInstanceData.hosts {
 			for _, newHostname := range hosts {
 				if existHostname == newHostname {
@@ -786,7 +950,9 @@ func (manager *NetworkManager) isHostnameExists(networkID string, hosts []string
 	return nil
 }
 
-func (manager *NetworkManager) prepareHostnameList(networkID string, params NetworkParams) (hosts []string, err error) {
+func (manager *NetworkManager) prepareHostnameList(
+	instanceID, networkID string, params NetworkParams,
+) (hosts []string, err error) {
 	hosts = append(hosts, params.Aliases...)
 
 	if params.Hostname != "" {
@@ -804,7 +970,7 @@ func (manager *NetworkManager) prepareHostnameList(networkID string, params Netw
 	if len(hosts) != 0 {
 		hosts = tryAppendDomainNameToHostname(hosts, networkID)
 
-		if err = manager.isHostnameExists(networkID, hosts); err != nil {
+		if err = manager.isHostnameExists(instanceID, networkID, params, hosts); err != nil {
 			return nil, aoserrors.Wrap(err)
 		}
 	}
@@ -942,6 +1108,16 @@ func getDNSPluginConfig(networkID string, dnsServers []string) (config json.RawM
 	return config, nil
 }
 
+// isCNIConfigChanged checks if parameters applied by CNI plugins on instance attach are changed.
+func isCNIConfigChanged(prevParams, params NetworkParams) bool {
+	return prevParams.InstanceIdent != params.InstanceIdent ||
+		prevParams.IngressKbit != params.IngressKbit || prevParams.EgressKbit != params.EgressKbit ||
+		!reflect.DeepEqual(prevParams.ExposedPorts, params.ExposedPorts) ||
+		!reflect.DeepEqual(prevParams.FirewallRules, params.FirewallRules) ||
+		!reflect.DeepEqual(prevParams.DNSServers, params.DNSServers) ||
+		prevParams.Hostname != params.Hostname || !reflect.DeepEqual(prevParams.Aliases, params.Aliases)
+}
+
 func getRuntimeNetConfig(instanceID, networkID string) (
 	networkingConfig *cni.NetworkConfigList, runtimeConfig *cni.RuntimeConf,
 ) {
@@ -959,8 +1135,9 @@ func getRuntimeNetConfig(instanceID, networkID string) (
 	return networkingConfig, runtimeConfig
 }
 
-func prepareNetworkConfigList(networkDir, instanceID, networkID string, params NetworkParams,
-) (cniNetworkConfig *cni.NetworkConfigList, err error) {
+func prepareNetworkConfigList(networkDir, instanceID, networkID string, params NetworkParams) (
+	cniNetworkConfig *cni.NetworkConfigList, err error,
+) {
 	networkConfig := cniNetwork{Name: networkID, CNIVersion: cniVersion}
 
 	// Bridge
@@ -974,7 +1151,7 @@ func prepareNetworkConfigList(networkDir, instanceID, networkID string, params N
 
 	// Firewall
 
-	firewallConfig, err := getFirewallPluginConfig(instanceID, params.ExposedPorts, params.NetworkParameters.FirewallRules)
+	firewallConfig, err := getFirewallPluginConfig(instanceID, params.ExposedPorts, params.FirewallRules)
 	if err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
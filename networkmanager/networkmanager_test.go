@@ -27,6 +27,7 @@ import (
 	"path"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 	"unicode"
@@ -58,6 +59,10 @@ type testCNIInterface struct {
 	errorAddNetwork      bool
 	emptyIPAddress       bool
 	errorValidateNetwork bool
+	instanceIPs          map[string]string
+	networkConfigs       map[string]*cni.NetworkConfigList
+	addCounts            map[string]int
+	delCounts            map[string]int
 }
 
 type cniNetwork struct {
@@ -111,6 +116,11 @@ type testIPTablesInterface struct {
 	notifyIptablesCacheUpdate     chan struct{}
 }
 
+type testRulesIPTables struct {
+	sync.Mutex
+	rules map[string][][]string
+}
+
 type testVlanCreate struct {
 	createVlanCh chan struct{}
 }
@@ -391,6 +401,224 @@ func TestFirewallPlugin(t *testing.T) {
 	}
 }
 
+func TestAllowedConnections(t *testing.T) {
+	cniInterface := &testCNIInterface{
+		instanceIPs: map[string]string{
+			"instance0": "172.17.0.2", "instance1": "172.17.0.3", "instance2": "172.17.0.4",
+		},
+		networkConfigs: make(map[string]*cni.NetworkConfigList),
+		addCounts:      make(map[string]int),
+		delCounts:      make(map[string]int),
+	}
+	iptables := newTestRulesIPTables()
+
+	networkmanager.CNIPlugins = cniInterface
+	networkmanager.IPTables = iptables
+
+	defer func() { networkmanager.IPTables = nil }()
+
+	storage := testStorage{chains: make(map[string]trafficData)}
+
+	manager, err := networkmanager.New(&config.Config{}, &storage)
+	if err != nil {
+		t.Fatalf("Can't create network manager: %s", err)
+	}
+	defer manager.Close()
+
+	networkParameters := func(ip string) aostypes.NetworkParameters {
+		return aostypes.NetworkParameters{IP: ip, Subnet: "172.17.0.0/16"}
+	}
+
+	if err := manager.AddInstanceToNetwork("instance0", "network0", networkmanager.NetworkParams{
+		InstanceIdent:      aostypes.InstanceIdent{ServiceID: "service0"},
+		AllowedConnections: []string{"service1/8080", "service2/53/udp"},
+		NetworkParameters:  networkParameters("172.17.0.2"),
+	}); err != nil {
+		t.Fatalf("Can't add instance to network: %s", err)
+	}
+
+	if rules := iptables.getConnectionRules(); len(rules) != 0 {
+		t.Errorf("Unexpected connection rules: %v", rules)
+	}
+
+	if err := manager.AddInstanceToNetwork("instance1", "network0", networkmanager.NetworkParams{
+		InstanceIdent:     aostypes.InstanceIdent{ServiceID: "service1"},
+		NetworkParameters: networkParameters("172.17.0.3"),
+	}); err != nil {
+		t.Fatalf("Can't add instance to network: %s", err)
+	}
+
+	if rules := iptables.getConnectionRules(); !reflect.DeepEqual(rules,
+		[]aostypes.FirewallRule{{DstIP: "172.17.0.3", DstPort: "8080", Proto: "tcp", SrcIP: "172.17.0.2"}}) {
+		t.Errorf("Wrong connection rules: %v", rules)
+	}
+
+	if err := manager.AddInstanceToNetwork("instance2", "network0", networkmanager.NetworkParams{
+		InstanceIdent:     aostypes.InstanceIdent{ServiceID: "service2"},
+		NetworkParameters: networkParameters("172.17.0.4"),
+	}); err != nil {
+		t.Fatalf("Can't add instance to network: %s", err)
+	}
+
+	if rules := iptables.getConnectionRules(); !reflect.DeepEqual(rules,
+		[]aostypes.FirewallRule{
+			{DstIP: "172.17.0.3", DstPort: "8080", Proto: "tcp", SrcIP: "172.17.0.2"},
+			{DstIP: "172.17.0.4", DstPort: "53", Proto: "udp", SrcIP: "172.17.0.2"},
+		}) {
+		t.Errorf("Wrong connection rules: %v", rules)
+	}
+
+	if err := manager.RemoveInstanceFromNetwork("instance1", "network0"); err != nil {
+		t.Fatalf("Can't remove instance from network: %s", err)
+	}
+
+	if rules := iptables.getConnectionRules(); !reflect.DeepEqual(rules,
+		[]aostypes.FirewallRule{{DstIP: "172.17.0.4", DstPort: "53", Proto: "udp", SrcIP: "172.17.0.2"}}) {
+		t.Errorf("Wrong connection rules: %v", rules)
+	}
+
+	// Rules are updated in place: instance keeps its CNI attachment, IP and network namespace
+
+	if cniInterface.addCounts["instance0"] != 1 || cniInterface.delCounts["instance0"] != 0 {
+		t.Errorf("Instance should not be reattached: add %d, del %d",
+			cniInterface.addCounts["instance0"], cniInterface.delCounts["instance0"])
+	}
+
+	if ip, err := manager.GetInstanceIP("instance0", "network0"); err != nil || ip != "172.17.0.2" {
+		t.Errorf("Wrong instance IP: %s, %v", ip, err)
+	}
+
+	if _, err := os.Stat(manager.GetNetnsPath("instance0")); err != nil {
+		t.Errorf("Instance network namespace should exist: %v", err)
+	}
+
+	if err := manager.AddInstanceToNetwork("instance3", "network0", networkmanager.NetworkParams{
+		AllowedConnections: []string{"service1"},
+		NetworkParameters:  networkParameters("172.17.0.5"),
+	}); err == nil {
+		t.Error("Should be error: unsupported allowed connections format")
+	}
+
+	for _, instanceID := range []string{"instance0", "instance2"} {
+		if err := manager.RemoveInstanceFromNetwork(instanceID, "network0"); err != nil {
+			t.Fatalf("Can't remove instance from network: %s", err)
+		}
+	}
+
+	if rules := iptables.getConnectionRules(); len(rules) != 0 {
+		t.Errorf("Unexpected connection rules: %v", rules)
+	}
+}
+
+func TestUpdateInstanceNetwork(t *testing.T) {
+	cniInterface := &testCNIInterface{
+		instanceIPs: map[string]string{
+			"instance0": "172.17.0.2", "instance1": "172.17.0.3", "instance2": "172.17.0.4",
+		},
+		networkConfigs: make(map[string]*cni.NetworkConfigList),
+		addCounts:      make(map[string]int),
+		delCounts:      make(map[string]int),
+	}
+	iptables := newTestRulesIPTables()
+
+	networkmanager.CNIPlugins = cniInterface
+	networkmanager.IPTables = iptables
+
+	defer func() { networkmanager.IPTables = nil }()
+
+	storage := testStorage{chains: make(map[string]trafficData)}
+
+	manager, err := networkmanager.New(&config.Config{}, &storage)
+	if err != nil {
+		t.Fatalf("Can't create network manager: %s", err)
+	}
+	defer manager.Close()
+
+	hostsFilePath := path.Join(tmpDir, "hosts")
+
+	params := networkmanager.NetworkParams{
+		InstanceIdent:     aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0"},
+		Hostname:          "host0",
+		NetworkParameters: aostypes.NetworkParameters{IP: "172.17.0.2", Subnet: "172.17.0.0/16"},
+	}
+
+	if err := manager.AddInstanceToNetwork("instance0", "network0", params); err != nil {
+		t.Fatalf("Can't add instance to network: %s", err)
+	}
+
+	// New instance version shares host names with the previous one during rolling update
+
+	newParams := params
+	newParams.IP = ""
+
+	if err := manager.AddInstanceToNetwork("instance1", "network0", newParams); err == nil {
+		t.Error("Should be error: host name already exists")
+	}
+
+	newParams.ReplacedInstanceID = "instance0"
+
+	if err := manager.AddInstanceToNetwork("instance1", "network0", newParams); err != nil {
+		t.Fatalf("Can't add instance to network: %s", err)
+	}
+
+	if err := manager.RemoveInstanceFromNetwork("instance0", "network0"); err != nil {
+		t.Fatalf("Can't remove instance from network: %s", err)
+	}
+
+	params.HostsFilePath = hostsFilePath
+	params.AllowedConnections = []string{"service1/8080"}
+
+	if err := manager.UpdateInstanceNetwork("instance1", "network0", params); err != nil {
+		t.Fatalf("Can't update instance network: %s", err)
+	}
+
+	// Instance is updated in place
+
+	if cniInterface.addCounts["instance1"] != 1 || cniInterface.delCounts["instance1"] != 0 {
+		t.Errorf("Instance should not be reattached: add %d, del %d",
+			cniInterface.addCounts["instance1"], cniInterface.delCounts["instance1"])
+	}
+
+	if ip, err := manager.GetInstanceIP("instance1", "network0"); err != nil || ip != "172.17.0.3" {
+		t.Errorf("Wrong instance IP: %s, %v", ip, err)
+	}
+
+	if _, err := os.Stat(manager.GetNetnsPath("instance1")); err != nil {
+		t.Errorf("Instance network namespace should exist: %v", err)
+	}
+
+	hostsData, err := os.ReadFile(hostsFilePath)
+	if err != nil {
+		t.Fatalf("Can't read hosts file: %v", err)
+	}
+
+	if !strings.Contains(string(hostsData), "172.17.0.3\tnetwork0 host0") {
+		t.Errorf("Wrong hosts file: %s", string(hostsData))
+	}
+
+	if err := manager.AddInstanceToNetwork("instance2", "network0", networkmanager.NetworkParams{
+		InstanceIdent:     aostypes.InstanceIdent{ServiceID: "service1"},
+		NetworkParameters: aostypes.NetworkParameters{IP: "172.17.0.4", Subnet: "172.17.0.0/16"},
+	}); err != nil {
+		t.Fatalf("Can't add instance to network: %s", err)
+	}
+
+	if rules := iptables.getConnectionRules(); !reflect.DeepEqual(rules,
+		[]aostypes.FirewallRule{{DstIP: "172.17.0.4", DstPort: "8080", Proto: "tcp", SrcIP: "172.17.0.3"}}) {
+		t.Errorf("Wrong connection rules: %v", rules)
+	}
+
+	if err := manager.UpdateInstanceNetwork("instance3", "network0", params); err == nil {
+		t.Error("Should be error: instance is not in network")
+	}
+
+	for _, instanceID := range []string{"instance1", "instance2"} {
+		if err := manager.RemoveInstanceFromNetwork(instanceID, "network0"); err != nil {
+			t.Fatalf("Can't remove instance from network: %s", err)
+		}
+	}
+}
+
 func TestBandwithPlugin(t *testing.T) {
 	testData := []testPluginsData{
 		{
@@ -1260,6 +1488,14 @@ func (c *testCNIInterface) AddNetworkList(ctx context.Context, list *cni.Network
 	c.networkConfig = list
 	c.runtimeConfig = rt
 
+	if c.networkConfigs != nil {
+		c.networkConfigs[rt.ContainerID] = list
+	}
+
+	if c.addCounts != nil {
+		c.addCounts[rt.ContainerID]++
+	}
+
 	result := &current.Result{
 		CNIVersion: current.ImplementedSpecVersion,
 		Interfaces: []*current.Interface{},
@@ -1269,8 +1505,14 @@ func (c *testCNIInterface) AddNetworkList(ctx context.Context, list *cni.Network
 	}
 
 	if !c.emptyIPAddress {
+		ip := "192.168.0.1"
+
+		if instanceIP, ok := c.instanceIPs[rt.ContainerID]; ok {
+			ip = instanceIP
+		}
+
 		ipConfig := &current.IPConfig{
-			Address: net.IPNet{IP: net.ParseIP("192.168.0.1")},
+			Address: net.IPNet{IP: net.ParseIP(ip)},
 		}
 
 		result.IPs = append(result.IPs, ipConfig)
@@ -1313,6 +1555,10 @@ func (c *testCNIInterface) DelNetworkList(ctx context.Context, list *cni.Network
 		return aoserrors.New("network list empty")
 	}
 
+	if c.delCounts != nil {
+		c.delCounts[rt.ContainerID]++
+	}
+
 	return nil
 }
 
@@ -1407,10 +1653,6 @@ func (iptables *testIPTablesInterface) Insert(table, chain string, pos int, rule
 }
 
 func (iptables *testIPTablesInterface) ClearChain(table, chain string) error {
-	if _, ok := iptables.chain[chain]; !ok {
-		return networkmanager.ErrRuleNotExist
-	}
-
 	iptables.chain[chain] = iptablesData{}
 
 	return nil
@@ -1460,6 +1702,104 @@ func (iptables *testIPTablesInterface) waitUpdateIptablesCache() {
 	time.Sleep(100 * time.Millisecond)
 }
 
+func newTestRulesIPTables() *testRulesIPTables {
+	return &testRulesIPTables{rules: make(map[string][][]string)}
+}
+
+func (iptables *testRulesIPTables) Append(table, chain string, rulespec ...string) error {
+	iptables.Lock()
+	defer iptables.Unlock()
+
+	iptables.rules[chain] = append(iptables.rules[chain], rulespec)
+
+	return nil
+}
+
+func (iptables *testRulesIPTables) Delete(table, chain string, rulespec ...string) error {
+	iptables.Lock()
+	defer iptables.Unlock()
+
+	for i, rule := range iptables.rules[chain] {
+		if reflect.DeepEqual(rule, rulespec) {
+			iptables.rules[chain] = append(iptables.rules[chain][:i], iptables.rules[chain][i+1:]...)
+
+			return nil
+		}
+	}
+
+	return networkmanager.ErrRuleNotExist
+}
+
+func (iptables *testRulesIPTables) NewChain(table, chain string) error {
+	iptables.Lock()
+	defer iptables.Unlock()
+
+	iptables.rules[chain] = nil
+
+	return nil
+}
+
+func (iptables *testRulesIPTables) Insert(table, chain string, pos int, rulespec ...string) error {
+	iptables.Lock()
+	defer iptables.Unlock()
+
+	rules := iptables.rules[chain]
+
+	if pos-1 > len(rules) {
+		return networkmanager.ErrRuleNotExist
+	}
+
+	iptables.rules[chain] = append(rules[:pos-1], append([][]string{rulespec}, rules[pos-1:]...)...)
+
+	return nil
+}
+
+func (iptables *testRulesIPTables) ClearChain(table, chain string) error {
+	return iptables.NewChain(table, chain)
+}
+
+func (iptables *testRulesIPTables) DeleteChain(table, chain string) error {
+	iptables.Lock()
+	defer iptables.Unlock()
+
+	delete(iptables.rules, chain)
+
+	return nil
+}
+
+func (iptables *testRulesIPTables) ListChains(table string) ([]string, error) {
+	iptables.Lock()
+	defer iptables.Unlock()
+
+	chains := make([]string, 0, len(iptables.rules))
+
+	for chain := range iptables.rules {
+		chains = append(chains, chain)
+	}
+
+	return chains, nil
+}
+
+func (iptables *testRulesIPTables) ListAllRulesWithCounters(table string) ([]string, error) {
+	return nil, nil
+}
+
+// getConnectionRules converts rules of allowed connections chain back to firewall rules. Reply rules are skipped.
+func (iptables *testRulesIPTables) getConnectionRules() (rules []aostypes.FirewallRule) {
+	iptables.Lock()
+	defer iptables.Unlock()
+
+	for _, rule := range iptables.rules["AOS_CONNECTIONS"] {
+		if len(rule) < 8 || rule[6] != "--dport" {
+			continue
+		}
+
+		rules = append(rules, aostypes.FirewallRule{SrcIP: rule[1], DstIP: rule[3], Proto: rule[5], DstPort: rule[7]})
+	}
+
+	return rules
+}
+
 func setup() (err error) {
 	if tmpDir, err = os.MkdirTemp("", "aos_"); err != nil {
 		return aoserrors.Wrap(err)
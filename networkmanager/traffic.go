@@ -300,8 +300,13 @@ func (monitor *trafficMonitoring) setChainState(chain, addresses string, enable
 }
 
 func (monitor *trafficMonitoring) deleteAllRules(chain string, rulespec ...string) (err error) {
+	return deleteAllRules(monitor.iptables, chain, rulespec...)
+}
+
+// deleteAllRules deletes all rules matching rulespec from filter table chain.
+func deleteAllRules(ipt IPTablesInterface, chain string, rulespec ...string) (err error) {
 	for {
-		if err = monitor.iptables.Delete("filter", chain, rulespec...); err != nil {
+		if err = ipt.Delete("filter", chain, rulespec...); err != nil {
 			var errIPTables *iptables.Error
 
 			if errors.As(err, &errIPTables) {
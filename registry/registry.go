@@ -0,0 +1,556 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package registry provides client to pull images from OCI distribution registry.
+package registry
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/image"
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/config"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Scheme URL scheme of images stored in OCI registry: oci://registry/repository@digest.
+const Scheme = "oci"
+
+const (
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	maxManifestSize         = 4 * 1024 * 1024
+	signatureTagSuffix      = ".sig"
+	credentialsPermMask     = 0o077
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// ErrNotExist is returned when requested manifest or blob does not exist in registry.
+var ErrNotExist = errors.New("registry entry does not exist")
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// Reference image reference in registry.
+type Reference struct {
+	Host       string
+	Repository string
+	Digest     digest.Digest
+}
+
+// Downloader downloads registry blobs.
+type Downloader interface {
+	DownloadWithHeader(
+		ctx context.Context, url string, header http.Header, fileInfo image.FileInfo) (fileName string, err error)
+	Release(url string) error
+}
+
+// Client OCI distribution registry client.
+type Client struct {
+	sync.Mutex
+
+	registries map[string]config.Registry
+	downloader Downloader
+	httpClient *http.Client
+	tokens     map[string]string
+}
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"` //nolint:tagliatelle // defined by token auth specification
+}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// New creates new registry client. Blobs are downloaded by downloader.
+func New(cfg *config.Config, downloader Downloader) *Client {
+	client := &Client{
+		registries: make(map[string]config.Registry),
+		downloader: downloader,
+		httpClient: &http.Client{},
+		tokens:     make(map[string]string),
+	}
+
+	for _, registry := range cfg.Registries {
+		client.registries[registry.Host] = registry
+	}
+
+	return client
+}
+
+// ParseReference parses oci://registry/repository@digest URL.
+func ParseReference(urlVal *url.URL) (ref Reference, err error) {
+	if urlVal.Scheme != Scheme {
+		return ref, aoserrors.Errorf("wrong registry URL scheme: %s", urlVal.Scheme)
+	}
+
+	repository, digestStr, ok := strings.Cut(strings.TrimPrefix(urlVal.Path, "/"), "@")
+	if !ok || urlVal.Host == "" || repository == "" {
+		return ref, aoserrors.Errorf("wrong registry URL: %s", urlVal.String())
+	}
+
+	ref = Reference{Host: urlVal.Host, Repository: repository, Digest: digest.Digest(digestStr)}
+
+	if err = ref.Digest.Validate(); err != nil {
+		return ref, aoserrors.Wrap(err)
+	}
+
+	return ref, nil
+}
+
+// GetManifest returns image manifest referenced by digest.
+func (client *Client) GetManifest(ctx context.Context, ref Reference) (manifest []byte, err error) {
+	if err = ref.Digest.Validate(); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if manifest, err = client.getManifest(ctx, ref, ref.Digest.String()); err != nil {
+		return nil, err
+	}
+
+	if ref.Digest.Algorithm().FromBytes(manifest) != ref.Digest {
+		return nil, aoserrors.New("manifest digest mismatch")
+	}
+
+	return manifest, nil
+}
+
+// GetSignature returns detached signature of image manifest. The signature is stored cosign-like as single layer
+// image tagged by manifest digest with .sig suffix.
+func (client *Client) GetSignature(ctx context.Context, ref Reference) (signature []byte, err error) {
+	tag := fmt.Sprintf("%s-%s%s", ref.Digest.Algorithm(), ref.Digest.Encoded(), signatureTagSuffix)
+
+	manifestData, err := client.getManifest(ctx, ref, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest imagespec.Manifest
+
+	if err = json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if len(manifest.Layers) == 0 || manifest.Layers[0].Size > maxManifestSize {
+		return nil, aoserrors.New("wrong signature manifest")
+	}
+
+	body, err := client.getBlob(ctx, ref, manifest.Layers[0].Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	if signature, err = readVerified(body, manifest.Layers[0]); err != nil {
+		return nil, err
+	}
+
+	return signature, nil
+}
+
+// DownloadBlob downloads blob by downloader and copies it into the file. Blob content is verified by descriptor
+// digest and size.
+func (client *Client) DownloadBlob(
+	ctx context.Context, ref Reference, descriptor imagespec.Descriptor, fileName string,
+) (err error) {
+	log.WithFields(log.Fields{
+		"host": ref.Host, "repository": ref.Repository, "digest": descriptor.Digest,
+	}).Debug("Download blob")
+
+	if err = descriptor.Digest.Validate(); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	blobURL, header, err := client.getBlobRequest(ctx, ref, descriptor.Digest)
+	if err != nil {
+		return err
+	}
+
+	fileInfo := image.FileInfo{Size: uint64(descriptor.Size)}
+
+	if descriptor.Digest.Algorithm() == digest.SHA256 {
+		if fileInfo.Sha256, err = hex.DecodeString(descriptor.Digest.Encoded()); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	downloadedFile, err := client.downloader.DownloadWithHeader(ctx, blobURL, header, fileInfo)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	defer func() {
+		if releaseErr := client.downloader.Release(blobURL); releaseErr != nil && err == nil {
+			err = aoserrors.Wrap(releaseErr)
+		}
+	}()
+
+	source, err := os.Open(downloadedFile)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+	defer source.Close()
+
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+	defer file.Close()
+
+	verifier := descriptor.Digest.Verifier()
+
+	size, err := io.Copy(io.MultiWriter(file, verifier), io.LimitReader(source, descriptor.Size+1))
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if size != descriptor.Size {
+		return aoserrors.Errorf("blob %s size mismatch", descriptor.Digest)
+	}
+
+	if !verifier.Verified() {
+		return aoserrors.Errorf("blob %s digest mismatch", descriptor.Digest)
+	}
+
+	return nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (client *Client) getManifest(ctx context.Context, ref Reference, reference string) ([]byte, error) {
+	resp, err := client.get(ctx, ref, "manifests/"+reference,
+		[]string{imagespec.MediaTypeImageManifest, dockerManifestMediaType})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	manifest, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if len(manifest) > maxManifestSize {
+		return nil, aoserrors.New("manifest is too big")
+	}
+
+	return manifest, nil
+}
+
+func (client *Client) getBlob(ctx context.Context, ref Reference, blobDigest digest.Digest) (io.ReadCloser, error) {
+	resp, _, err := client.request(ctx, http.MethodGet, ref, "blobs/"+blobDigest.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// getBlobRequest checks blob existence and returns blob URL and auth header to download the blob.
+func (client *Client) getBlobRequest(
+	ctx context.Context, ref Reference, blobDigest digest.Digest,
+) (blobURL string, header http.Header, err error) {
+	resp, blobURL, err := client.request(ctx, http.MethodHead, ref, "blobs/"+blobDigest.String(), nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	resp.Body.Close()
+
+	header = make(http.Header)
+
+	if token := client.getToken(ref); token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	return blobURL, header, nil
+}
+
+func (client *Client) get(ctx context.Context, ref Reference, path string, accept []string) (*http.Response, error) {
+	resp, _, err := client.request(ctx, http.MethodGet, ref, path, accept)
+
+	return resp, err
+}
+
+// request performs registry API request. If registry requires token auth, the token is requested according to
+// the registry challenge and the request is repeated.
+func (client *Client) request(
+	ctx context.Context, method string, ref Reference, path string, accept []string,
+) (resp *http.Response, requestURL string, err error) {
+	registry := client.getRegistry(ref.Host)
+
+	scheme := "https"
+	if registry.PlainHTTP {
+		scheme = "http"
+	}
+
+	requestURL = fmt.Sprintf("%s://%s/v2/%s/%s", scheme, ref.Host, ref.Repository, path)
+
+	if resp, err = client.doRequest(ctx, method, requestURL, accept, client.getToken(ref)); err != nil {
+		return nil, "", err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+
+		resp.Body.Close()
+
+		token, err := client.requestToken(ctx, ref, registry, challenge)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if resp, err = client.doRequest(ctx, method, requestURL, accept, token); err != nil {
+			return nil, "", err
+		}
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+
+		return nil, "", aoserrors.Wrap(ErrNotExist)
+
+	case resp.StatusCode != http.StatusOK:
+		resp.Body.Close()
+
+		return nil, "", aoserrors.Errorf("registry request %s failed: %s", requestURL, resp.Status)
+	}
+
+	return resp, requestURL, nil
+}
+
+func (client *Client) doRequest(
+	ctx context.Context, method, requestURL string, accept []string, token string,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, nil)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if len(accept) != 0 {
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return resp, nil
+}
+
+func (client *Client) requestToken(
+	ctx context.Context, ref Reference, registry config.Registry, challenge string,
+) (string, error) {
+	authScheme, params, ok := strings.Cut(challenge, " ")
+	if !ok || !strings.EqualFold(authScheme, "Bearer") {
+		return "", aoserrors.Errorf("unsupported registry auth challenge: %s", challenge)
+	}
+
+	authParams := parseAuthParams(params)
+
+	realm, ok := authParams["realm"]
+	if !ok {
+		return "", aoserrors.New("no realm in registry auth challenge")
+	}
+
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	query := tokenURL.Query()
+
+	if service, ok := authParams["service"]; ok {
+		query.Set("service", service)
+	}
+
+	scope, ok := authParams["scope"]
+	if !ok {
+		scope = fmt.Sprintf("repository:%s:pull", ref.Repository)
+	}
+
+	query.Set("scope", scope)
+
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	creds, err := readCredentials(registry.CredentialsFile)
+	if err != nil {
+		return "", err
+	}
+
+	if creds.Username != "" {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", aoserrors.Errorf("can't get registry auth token: %s", resp.Status)
+	}
+
+	var tokenResp tokenResponse
+
+	if err = json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	token := tokenResp.Token
+	if token == "" {
+		token = tokenResp.AccessToken
+	}
+
+	if token == "" {
+		return "", aoserrors.New("empty registry auth token")
+	}
+
+	client.Lock()
+	defer client.Unlock()
+
+	client.tokens[ref.Host+"/"+ref.Repository] = token
+
+	return token, nil
+}
+
+func (client *Client) getToken(ref Reference) string {
+	client.Lock()
+	defer client.Unlock()
+
+	return client.tokens[ref.Host+"/"+ref.Repository]
+}
+
+func (client *Client) getRegistry(host string) config.Registry {
+	if registry, ok := client.registries[host]; ok {
+		return registry
+	}
+
+	return config.Registry{Host: host}
+}
+
+// readCredentials reads registry credentials from the file. The file should be accessible by owner only.
+func readCredentials(fileName string) (creds credentials, err error) {
+	if fileName == "" {
+		return creds, nil
+	}
+
+	fileInfo, err := os.Stat(fileName)
+	if err != nil {
+		return creds, aoserrors.Wrap(err)
+	}
+
+	if fileInfo.Mode().Perm()&credentialsPermMask != 0 {
+		return creds, aoserrors.Errorf("credentials file %s is accessible by other users", fileName)
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return creds, aoserrors.Wrap(err)
+	}
+
+	if err = json.Unmarshal(data, &creds); err != nil {
+		return creds, aoserrors.Wrap(err)
+	}
+
+	return creds, nil
+}
+
+// parseAuthParams parses comma separated key="value" pairs of auth challenge.
+func parseAuthParams(params string) map[string]string {
+	result := make(map[string]string)
+
+	for len(params) > 0 {
+		key, rest, ok := strings.Cut(params, "=")
+		if !ok {
+			break
+		}
+
+		key = strings.ToLower(strings.TrimSpace(strings.TrimLeft(key, ", ")))
+
+		var value string
+
+		if strings.HasPrefix(rest, "\"") {
+			value, rest, _ = strings.Cut(rest[1:], "\"")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+
+		result[key] = value
+		params = rest
+	}
+
+	return result
+}
+
+func readVerified(reader io.Reader, descriptor imagespec.Descriptor) ([]byte, error) {
+	if err := descriptor.Digest.Validate(); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, descriptor.Size+1))
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if int64(len(data)) != descriptor.Size || descriptor.Digest.Algorithm().FromBytes(data) != descriptor.Digest {
+		return nil, aoserrors.Errorf("blob %s verification failed", descriptor.Digest)
+	}
+
+	return data, nil
+}
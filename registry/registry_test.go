@@ -0,0 +1,484 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/image"
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/config"
+	"github.com/aosedge/aos_servicemanager/registry"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	testRepository = "aos/service1"
+	testUsername   = "user"
+	testPassword   = "password"
+	testToken      = "testToken"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type testRegistry struct {
+	sync.Mutex
+
+	server       *httptest.Server
+	manifests    map[string][]byte
+	blobs        map[string][]byte
+	tokenRequest int
+}
+
+type testDownloader struct {
+	sync.Mutex
+
+	files map[string]string
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var tmpDir string
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Main
+ **********************************************************************************************************************/
+
+func TestMain(m *testing.M) {
+	var err error
+
+	if tmpDir, err = os.MkdirTemp("", "sm_"); err != nil {
+		log.Fatalf("Error create temporary dir: %v", err)
+	}
+
+	ret := m.Run()
+
+	if err = os.RemoveAll(tmpDir); err != nil {
+		log.Errorf("Error removing tmp dir: %v", err)
+	}
+
+	os.Exit(ret)
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestParseReference(t *testing.T) {
+	cases := []struct {
+		url         string
+		expectedRef registry.Reference
+		expectedErr bool
+	}{
+		{
+			url: "oci://registry.example.com:5000/aos/service1@sha256:" + strings.Repeat("a", 64),
+			expectedRef: registry.Reference{
+				Host: "registry.example.com:5000", Repository: "aos/service1",
+				Digest: digest.Digest("sha256:" + strings.Repeat("a", 64)),
+			},
+		},
+		{url: "oci://registry.example.com/aos/service1:latest", expectedErr: true},
+		{url: "oci://registry.example.com/aos/service1@sha256:1234", expectedErr: true},
+		{url: "oci:///aos/service1@sha256:" + strings.Repeat("a", 64), expectedErr: true},
+		{url: "https://registry.example.com/aos/service1@sha256:" + strings.Repeat("a", 64), expectedErr: true},
+	}
+
+	for _, tCase := range cases {
+		urlVal, err := url.Parse(tCase.url)
+		if err != nil {
+			t.Fatalf("Can't parse URL: %v", err)
+		}
+
+		ref, err := registry.ParseReference(urlVal)
+		if (err != nil) != tCase.expectedErr {
+			t.Errorf("Unexpected parse result for %s: %v", tCase.url, err)
+		}
+
+		if err == nil && ref != tCase.expectedRef {
+			t.Errorf("Wrong reference: %v", ref)
+		}
+	}
+}
+
+func TestPull(t *testing.T) {
+	testRegistry := newTestRegistry()
+	defer testRegistry.server.Close()
+
+	blob := []byte("layer content")
+	blobDescriptor := testRegistry.addBlob(blob)
+
+	manifestDigest, err := testRegistry.addManifest("", imagespec.Manifest{
+		Config: testRegistry.addBlob([]byte("{}")),
+		Layers: []imagespec.Descriptor{blobDescriptor},
+	})
+	if err != nil {
+		t.Fatalf("Can't add manifest: %v", err)
+	}
+
+	testDownloader := newTestDownloader()
+
+	client := registry.New(testRegistry.config(t, testUsername, testPassword, 0o600), testDownloader)
+	ref := testRegistry.reference(manifestDigest)
+
+	manifestData, err := client.GetManifest(context.Background(), ref)
+	if err != nil {
+		t.Fatalf("Can't get manifest: %v", err)
+	}
+
+	if !bytes.Equal(manifestData, testRegistry.manifests[manifestDigest.String()]) {
+		t.Error("Wrong manifest content")
+	}
+
+	fileName := filepath.Join(tmpDir, "blob")
+
+	if err = client.DownloadBlob(context.Background(), ref, blobDescriptor, fileName); err != nil {
+		t.Fatalf("Can't download blob: %v", err)
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Can't read blob: %v", err)
+	}
+
+	if len(testDownloader.files) != 0 {
+		t.Errorf("Downloaded blob should be released: %v", testDownloader.files)
+	}
+
+	if !bytes.Equal(data, blob) {
+		t.Error("Wrong blob content")
+	}
+
+	if _, err = client.GetSignature(context.Background(), ref); !errors.Is(err, registry.ErrNotExist) {
+		t.Errorf("Unexpected get signature error: %v", err)
+	}
+
+	signature := []byte(`{"signature":"c2lnbmF0dXJl"}`)
+
+	if _, err = testRegistry.addManifest(
+		fmt.Sprintf("sha256-%s.sig", manifestDigest.Encoded()), imagespec.Manifest{
+			Config: testRegistry.addBlob([]byte("{}")),
+			Layers: []imagespec.Descriptor{testRegistry.addBlob(signature)},
+		}); err != nil {
+		t.Fatalf("Can't add signature manifest: %v", err)
+	}
+
+	data, err = client.GetSignature(context.Background(), ref)
+	if err != nil {
+		t.Fatalf("Can't get signature: %v", err)
+	}
+
+	if !bytes.Equal(data, signature) {
+		t.Error("Wrong signature content")
+	}
+
+	// Token should be requested once and reused by next requests
+	if testRegistry.tokenRequest != 1 {
+		t.Errorf("Wrong token request count: %d", testRegistry.tokenRequest)
+	}
+}
+
+func TestWrongCredentials(t *testing.T) {
+	testRegistry := newTestRegistry()
+	defer testRegistry.server.Close()
+
+	manifestDigest, err := testRegistry.addManifest("", imagespec.Manifest{
+		Config: testRegistry.addBlob([]byte("{}")),
+	})
+	if err != nil {
+		t.Fatalf("Can't add manifest: %v", err)
+	}
+
+	client := registry.New(testRegistry.config(t, testUsername, "wrongPassword", 0o600), newTestDownloader())
+
+	if _, err := client.GetManifest(context.Background(), testRegistry.reference(manifestDigest)); err == nil {
+		t.Error("Error expected")
+	}
+
+	// Credentials file accessible by other users should not be used
+
+	client = registry.New(testRegistry.config(t, testUsername, testPassword, 0o644), newTestDownloader())
+
+	if _, err := client.GetManifest(context.Background(), testRegistry.reference(manifestDigest)); err == nil {
+		t.Error("Error expected")
+	}
+}
+
+func TestCorruptedContent(t *testing.T) {
+	testRegistry := newTestRegistry()
+	defer testRegistry.server.Close()
+
+	blobDescriptor := testRegistry.addBlob([]byte("layer content"))
+
+	manifestDigest, err := testRegistry.addManifest("", imagespec.Manifest{
+		Config: testRegistry.addBlob([]byte("{}")),
+		Layers: []imagespec.Descriptor{blobDescriptor},
+	})
+	if err != nil {
+		t.Fatalf("Can't add manifest: %v", err)
+	}
+
+	testRegistry.blobs[blobDescriptor.Digest.String()] = []byte("corrupted content")
+
+	client := registry.New(testRegistry.config(t, testUsername, testPassword, 0o600), newTestDownloader())
+	ref := testRegistry.reference(manifestDigest)
+
+	if err = client.DownloadBlob(
+		context.Background(), ref, blobDescriptor, filepath.Join(tmpDir, "blob")); err == nil {
+		t.Error("Error expected")
+	}
+
+	testRegistry.manifests[manifestDigest.String()] = []byte("{}")
+
+	if _, err = client.GetManifest(context.Background(), ref); err == nil {
+		t.Error("Error expected")
+	}
+
+	ref.Digest = digest.FromString("unknown")
+
+	if _, err = client.GetManifest(context.Background(), ref); !errors.Is(err, registry.ErrNotExist) {
+		t.Errorf("Unexpected get manifest error: %v", err)
+	}
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func newTestRegistry() *testRegistry {
+	testRegistry := &testRegistry{
+		manifests: make(map[string][]byte),
+		blobs:     make(map[string][]byte),
+	}
+
+	testRegistry.server = httptest.NewServer(http.HandlerFunc(testRegistry.handleRequest))
+
+	return testRegistry
+}
+
+func (testRegistry *testRegistry) config(
+	t *testing.T, username, password string, perm os.FileMode,
+) *config.Config {
+	t.Helper()
+
+	credentialsFile := filepath.Join(t.TempDir(), "credentials.json")
+
+	data, err := json.Marshal(map[string]string{"username": username, "password": password})
+	if err != nil {
+		t.Fatalf("Can't marshal credentials: %v", err)
+	}
+
+	if err = os.WriteFile(credentialsFile, data, perm); err != nil {
+		t.Fatalf("Can't write credentials: %v", err)
+	}
+
+	return &config.Config{Registries: []config.Registry{{
+		Host: testRegistry.host(), CredentialsFile: credentialsFile, PlainHTTP: true,
+	}}}
+}
+
+func (testRegistry *testRegistry) host() string {
+	return strings.TrimPrefix(testRegistry.server.URL, "http://")
+}
+
+func (testRegistry *testRegistry) reference(manifestDigest digest.Digest) registry.Reference {
+	return registry.Reference{Host: testRegistry.host(), Repository: testRepository, Digest: manifestDigest}
+}
+
+func (testRegistry *testRegistry) addBlob(data []byte) imagespec.Descriptor {
+	testRegistry.Lock()
+	defer testRegistry.Unlock()
+
+	blobDigest := digest.FromBytes(data)
+
+	testRegistry.blobs[blobDigest.String()] = data
+
+	return imagespec.Descriptor{
+		MediaType: imagespec.MediaTypeImageLayer, Digest: blobDigest, Size: int64(len(data)),
+	}
+}
+
+func (testRegistry *testRegistry) addManifest(tag string, manifest imagespec.Manifest) (digest.Digest, error) {
+	testRegistry.Lock()
+	defer testRegistry.Unlock()
+
+	manifest.SchemaVersion = 2
+	manifest.MediaType = imagespec.MediaTypeImageManifest
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+
+	manifestDigest := digest.FromBytes(data)
+
+	testRegistry.manifests[manifestDigest.String()] = data
+
+	if tag != "" {
+		testRegistry.manifests[tag] = data
+	}
+
+	return manifestDigest, nil
+}
+
+func (testRegistry *testRegistry) handleRequest(w http.ResponseWriter, r *http.Request) {
+	testRegistry.Lock()
+	defer testRegistry.Unlock()
+
+	if r.URL.Path == "/token" {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != testUsername || password != testPassword ||
+			r.URL.Query().Get("scope") != "repository:"+testRepository+":pull" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		testRegistry.tokenRequest++
+
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": testToken})
+
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+testToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+			`Bearer realm="%s/token",service="test",scope="repository:%s:pull"`,
+			testRegistry.server.URL, testRepository))
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	var (
+		data []byte
+		ok   bool
+	)
+
+	prefix := "/v2/" + testRepository + "/"
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, prefix+"manifests/"):
+		data, ok = testRegistry.manifests[strings.TrimPrefix(r.URL.Path, prefix+"manifests/")]
+		w.Header().Set("Content-Type", imagespec.MediaTypeImageManifest)
+
+	case strings.HasPrefix(r.URL.Path, prefix+"blobs/"):
+		data, ok = testRegistry.blobs[strings.TrimPrefix(r.URL.Path, prefix+"blobs/")]
+	}
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	_, _ = w.Write(data)
+}
+
+func newTestDownloader() *testDownloader {
+	return &testDownloader{files: make(map[string]string)}
+}
+
+func (downloader *testDownloader) DownloadWithHeader(
+	ctx context.Context, url string, header http.Header, fileInfo image.FileInfo,
+) (fileName string, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	req.Header = header.Clone()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", aoserrors.Errorf("download failed: %s", resp.Status)
+	}
+
+	file, err := os.CreateTemp(tmpDir, "")
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+	defer file.Close()
+
+	if _, err = io.Copy(file, resp.Body); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	downloader.Lock()
+	defer downloader.Unlock()
+
+	downloader.files[url] = file.Name()
+
+	return file.Name(), nil
+}
+
+func (downloader *testDownloader) Release(url string) error {
+	downloader.Lock()
+	defer downloader.Unlock()
+
+	fileName, ok := downloader.files[url]
+	if !ok {
+		return nil
+	}
+
+	delete(downloader.files, url)
+
+	return aoserrors.Wrap(os.RemoveAll(fileName))
+}
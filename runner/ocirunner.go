@@ -0,0 +1,596 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Supported OCI runtimes.
+const (
+	RuncRuntime = "runc"
+	CrunRuntime = "crun"
+	RunxRuntime = "runx"
+)
+
+// Runner backends.
+const (
+	BackendSystemd = "systemd"
+	BackendOCI     = "oci"
+)
+
+const (
+	startStatusChannelSize = 32
+	signaledExitCodeOffset = 128
+	// Time to wait for container exit after it is killed or checkpointed
+	exitTimeout = 5 * time.Second
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// OCIRunner runs service instances by calling OCI runtime directly.
+type OCIRunner struct {
+	sync.Mutex
+	runtimePath        string
+	instanceStatusChan chan []InstanceStatus
+	instances          map[string]*ociInstance
+}
+
+type exitStatus struct {
+	exitCode int
+	signal   int
+}
+
+type ociInstance struct {
+	instanceID string
+	runtimeDir string
+	params     RunParameters
+	startTimes []time.Time
+	startChan  chan InstanceStatus
+	stopChan   chan struct{}
+	stopOnce   sync.Once
+	doneChan   chan struct{}
+	stopForced bool
+	// instance is checkpointed instead of stop if set
+	checkpointPath string
+	checkpointErr  error
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// LookPath looks for OCI runtime binary.
+var LookPath = exec.LookPath //nolint:gochecknoglobals // used for unit tests
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// NewOCIRunner creates new OCI runtime runner.
+func NewOCIRunner(runtime string) (runner *OCIRunner, err error) {
+	log.WithField("runtime", runtime).Debug("Create OCI runner")
+
+	if runtime != RuncRuntime && runtime != CrunRuntime && runtime != RunxRuntime {
+		return nil, aoserrors.Errorf("unsupported OCI runtime: %s", runtime)
+	}
+
+	runner = &OCIRunner{
+		instanceStatusChan: make(chan []InstanceStatus, unitStatusChannelSize),
+		instances:          make(map[string]*ociInstance),
+	}
+
+	if runner.runtimePath, err = LookPath(runtime); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return runner, nil
+}
+
+// Close closes OCI runner.
+func (runner *OCIRunner) Close() {
+	log.Debug("Close OCI runner")
+
+	runner.Lock()
+
+	instances := make([]*ociInstance, 0, len(runner.instances))
+
+	for _, instance := range runner.instances {
+		instances = append(instances, instance)
+	}
+
+	runner.instances = make(map[string]*ociInstance)
+
+	runner.Unlock()
+
+	for _, instance := range instances {
+		instance.stop()
+	}
+}
+
+// InstanceStatusChannel returns instance status channel.
+func (runner *OCIRunner) InstanceStatusChannel() <-chan []InstanceStatus {
+	return runner.instanceStatusChan
+}
+
+// StartInstance starts service instance by OCI runtime.
+func (runner *OCIRunner) StartInstance(instanceID, runtimeDir string, params RunParameters) (status InstanceStatus) {
+	status.InstanceID = instanceID
+	status.State = cloudprotocol.InstanceStateFailed
+
+	log.WithFields(log.Fields{
+		"StartInterval":   params.StartInterval,
+		"StartBurst":      params.StartBurst,
+		"RestartInterval": params.RestartInterval,
+		"StopSignal":      params.StopSignal,
+		"StopTimeout":     params.StopTimeout,
+		"RestorePath":     params.RestorePath,
+		"OneShot":         params.OneShot,
+	}).Debug("Start service instance")
+
+	if params = setDefaultStopParameters(setDefaultRunParameters(params)); !validRunParameters(params) {
+		status.Err = aoserrors.New("invalid parameters")
+
+		return status
+	}
+
+	instance := &ociInstance{
+		instanceID: instanceID,
+		runtimeDir: runtimeDir,
+		params:     params,
+		startChan:  make(chan InstanceStatus, startStatusChannelSize),
+		stopChan:   make(chan struct{}),
+		doneChan:   make(chan struct{}),
+	}
+
+	runner.Lock()
+
+	prevInstance := runner.instances[instanceID]
+	runner.instances[instanceID] = instance
+
+	runner.Unlock()
+
+	if prevInstance != nil {
+		prevInstance.stop()
+	}
+
+	go runner.superviseInstance(instance)
+
+	status = runner.getStartingState(instance)
+
+	runner.Lock()
+
+	instance.startChan = nil
+
+	if status.State == cloudprotocol.InstanceStateFailed && runner.instances[instanceID] == instance {
+		delete(runner.instances, instanceID)
+	}
+
+	runner.Unlock()
+
+	if status.State == cloudprotocol.InstanceStateFailed {
+		instance.stop()
+
+		if status.Err == nil {
+			status.Err = aoserrors.Errorf("instance failed")
+		}
+	}
+
+	return status
+}
+
+// StopInstance stops service instance.
+func (runner *OCIRunner) StopInstance(instanceID string) (err error) {
+	runner.Lock()
+
+	instance, ok := runner.instances[instanceID]
+	if ok {
+		delete(runner.instances, instanceID)
+	}
+
+	runner.Unlock()
+
+	if !ok {
+		log.WithField("id", instanceID).Warn("Service not loaded")
+
+		return nil
+	}
+
+	instance.stop()
+
+	if instance.stopForced {
+		return aoserrors.Wrap(ErrStopForced)
+	}
+
+	return nil
+}
+
+// ExecInstance executes command inside service instance container.
+func (runner *OCIRunner) ExecInstance(ctx context.Context, instanceID string, cmd []string) error {
+	return execContainer(ctx, runner.runtimePath, instanceID, cmd)
+}
+
+// RunInitContainer runs container from bundle till its process exits and returns process exit code.
+func (runner *OCIRunner) RunInitContainer(
+	ctx context.Context, containerID, bundleDir string,
+) (exitCode int, err error) {
+	return runInitContainer(ctx, runner.runtimePath, containerID, bundleDir)
+}
+
+// CheckpointInstance checkpoints service instance into image path and stops it. The instance is stopped as usual if
+// checkpoint fails.
+func (runner *OCIRunner) CheckpointInstance(instanceID, imagePath string) error {
+	runner.Lock()
+
+	instance, ok := runner.instances[instanceID]
+	if ok {
+		delete(runner.instances, instanceID)
+	}
+
+	runner.Unlock()
+
+	if !ok {
+		return aoserrors.New("instance is not running")
+	}
+
+	instance.checkpointPath = imagePath
+	// Reset by supervisor if container is running and checkpointed
+	instance.checkpointErr = aoserrors.New("instance is not running")
+
+	instance.stop()
+
+	return instance.checkpointErr
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (runner *OCIRunner) superviseInstance(instance *ociInstance) {
+	defer close(instance.doneChan)
+
+	// Restore is done on first start only
+	restorePath := instance.params.RestorePath
+
+	for {
+		if !instance.checkStartLimit(time.Now()) {
+			log.WithField("instanceID", instance.instanceID).Warn("Instance start limit reached")
+
+			runner.sendStatus(instance, InstanceStatus{
+				InstanceID: instance.instanceID,
+				State:      cloudprotocol.InstanceStateFailed,
+				Err:        aoserrors.New("start limit reached"),
+			})
+
+			if err := runner.deleteContainer(instance.instanceID); err != nil {
+				log.WithField("instanceID", instance.instanceID).Errorf("Can't delete container: %v", err)
+			}
+
+			return
+		}
+
+		exitChan, err := runner.runContainer(instance, restorePath)
+		if err != nil {
+			log.WithField("instanceID", instance.instanceID).Errorf("Can't run instance: %v", err)
+
+			runner.sendStatus(instance, InstanceStatus{
+				InstanceID: instance.instanceID,
+				State:      cloudprotocol.InstanceStateFailed,
+				Err:        err,
+			})
+
+			if instance.params.OneShot {
+				return
+			}
+		} else {
+			runner.sendStatus(instance, InstanceStatus{
+				InstanceID: instance.instanceID,
+				State:      cloudprotocol.InstanceStateActive,
+			})
+
+			select {
+			case status := <-exitChan:
+				log.WithFields(log.Fields{
+					"instanceID": instance.instanceID, "exitCode": status.exitCode, "signal": status.signal,
+				}).Warn("Instance exited")
+
+				if instance.params.OneShot {
+					runner.completeInstance(instance, status)
+
+					return
+				}
+
+				runner.sendStatus(instance, InstanceStatus{
+					InstanceID: instance.instanceID,
+					State:      cloudprotocol.InstanceStateFailed,
+					Err:        aoserrors.Errorf("instance exited with code %d", status.exitCode),
+					ExitCode:   status.exitCode,
+					Signal:     status.signal,
+				})
+
+			case <-instance.stopChan:
+				if instance.checkpointPath != "" {
+					instance.checkpointErr = runner.checkpointContainer(instance, exitChan)
+					if instance.checkpointErr == nil {
+						return
+					}
+				}
+
+				instance.stopForced = runner.stopContainer(instance, exitChan)
+
+				return
+			}
+		}
+
+		restorePath = ""
+
+		select {
+		case <-time.After(instance.params.RestartInterval):
+
+		case <-instance.stopChan:
+			if err := runner.deleteContainer(instance.instanceID); err != nil {
+				log.WithField("instanceID", instance.instanceID).Errorf("Can't delete container: %v", err)
+			}
+
+			return
+		}
+	}
+}
+
+func (runner *OCIRunner) runContainer(
+	instance *ociInstance, restorePath string,
+) (exitChan <-chan exitStatus, err error) {
+	if err = runner.deleteContainer(instance.instanceID); err != nil {
+		log.WithField("instanceID", instance.instanceID).Debugf("Can't delete container: %v", err)
+	}
+
+	logWriter := log.WithField("instanceID", instance.instanceID).Writer()
+
+	args := []string{"run", "--bundle", instance.runtimeDir, instance.instanceID}
+
+	if restorePath != "" {
+		args = []string{"restore", "--image-path", restorePath, "--bundle", instance.runtimeDir, instance.instanceID}
+	}
+
+	cmd := exec.Command(runner.runtimePath, args...)
+
+	cmd.Stdout = logWriter
+	cmd.Stderr = logWriter
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err = cmd.Start(); err != nil {
+		logWriter.Close()
+
+		return nil, aoserrors.Wrap(err)
+	}
+
+	exitStatusChan := make(chan exitStatus, 1)
+
+	go func() {
+		exitStatusChan <- getExitStatus(cmd.Wait())
+
+		logWriter.Close()
+	}()
+
+	return exitStatusChan, nil
+}
+
+func (runner *OCIRunner) completeInstance(instance *ociInstance, status exitStatus) {
+	if err := runner.deleteContainer(instance.instanceID); err != nil {
+		log.WithField("instanceID", instance.instanceID).Errorf("Can't delete container: %v", err)
+	}
+
+	runner.sendStatus(instance, InstanceStatus{
+		InstanceID: instance.instanceID,
+		State:      InstanceStateCompleted,
+		ExitCode:   status.exitCode,
+		Signal:     status.signal,
+	})
+}
+
+func (runner *OCIRunner) stopContainer(instance *ociInstance, exitChan <-chan exitStatus) (forced bool) {
+	log.WithField("instanceID", instance.instanceID).Debug("Stop container")
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), instance.params.StopTimeout)
+	defer cancelFunc()
+
+	if len(instance.params.PreStop) > 0 {
+		if err := execContainer(ctx, runner.runtimePath, instance.instanceID, instance.params.PreStop); err != nil {
+			log.WithField("instanceID", instance.instanceID).Warnf("Can't execute pre-stop command: %v", err)
+		}
+	}
+
+	runner.killContainer(instance.instanceID, instance.params.StopSignal)
+
+	select {
+	case <-exitChan:
+
+	case <-ctx.Done():
+		log.WithField("instanceID", instance.instanceID).Warn("Instance stop timeout, kill container")
+
+		forced = true
+
+		runner.killContainer(instance.instanceID, syscall.SIGKILL)
+
+		select {
+		case <-exitChan:
+
+		case <-time.After(exitTimeout):
+			log.WithField("instanceID", instance.instanceID).Error("Timeout waiting instance exit")
+		}
+	}
+
+	if err := runner.deleteContainer(instance.instanceID); err != nil {
+		log.WithField("instanceID", instance.instanceID).Errorf("Can't delete container: %v", err)
+	}
+
+	return forced
+}
+
+func (runner *OCIRunner) checkpointContainer(instance *ociInstance, exitChan <-chan exitStatus) error {
+	log.WithField("instanceID", instance.instanceID).Debug("Checkpoint container")
+
+	if err := checkpointContainer(runner.runtimePath, instance.instanceID, instance.checkpointPath); err != nil {
+		log.WithField("instanceID", instance.instanceID).Errorf("Can't checkpoint container: %v", err)
+
+		return err
+	}
+
+	select {
+	case <-exitChan:
+
+	case <-time.After(exitTimeout):
+		log.WithField("instanceID", instance.instanceID).Error("Timeout waiting instance exit")
+	}
+
+	if err := runner.deleteContainer(instance.instanceID); err != nil {
+		log.WithField("instanceID", instance.instanceID).Errorf("Can't delete container: %v", err)
+	}
+
+	return nil
+}
+
+func (runner *OCIRunner) killContainer(instanceID string, signal syscall.Signal) {
+	if output, err := exec.Command(
+		runner.runtimePath, "kill", instanceID, unix.SignalName(signal)).CombinedOutput(); err != nil {
+		log.WithField("instanceID", instanceID).Warnf("Can't kill container: %s", string(output))
+	}
+}
+
+func (runner *OCIRunner) deleteContainer(instanceID string) error {
+	if output, err := exec.Command(
+		runner.runtimePath, "delete", "--force", instanceID).CombinedOutput(); err != nil {
+		return aoserrors.Errorf("%v: %s", err, string(output))
+	}
+
+	return nil
+}
+
+func (runner *OCIRunner) sendStatus(instance *ociInstance, status InstanceStatus) {
+	runner.Lock()
+	defer runner.Unlock()
+
+	if runner.instances[instance.instanceID] != instance {
+		return
+	}
+
+	if instance.startChan != nil {
+		select {
+		case instance.startChan <- status:
+
+		default:
+			log.Error("Instance start status channel full")
+		}
+
+		return
+	}
+
+	select {
+	case runner.instanceStatusChan <- []InstanceStatus{status}:
+
+	default:
+		log.Error("Instance status channel full")
+	}
+}
+
+func (runner *OCIRunner) getStartingState(instance *ociInstance) (status InstanceStatus) {
+	status = InstanceStatus{InstanceID: instance.instanceID, State: cloudprotocol.InstanceStateFailed}
+	timeout := time.After(time.Duration(startTimeoutMultiplier * float32(instance.params.StartInterval)))
+
+	for {
+		select {
+		case status = <-instance.startChan:
+
+		case <-instance.doneChan:
+			for {
+				select {
+				case status = <-instance.startChan:
+
+				default:
+					return status
+				}
+			}
+
+		case <-timeout:
+			return status
+		}
+	}
+}
+
+func (instance *ociInstance) checkStartLimit(now time.Time) bool {
+	startTimes := make([]time.Time, 0, len(instance.startTimes)+1)
+
+	for _, startTime := range instance.startTimes {
+		if now.Sub(startTime) < instance.params.StartInterval {
+			startTimes = append(startTimes, startTime)
+		}
+	}
+
+	if uint(len(startTimes)) >= instance.params.StartBurst {
+		instance.startTimes = startTimes
+
+		return false
+	}
+
+	instance.startTimes = append(startTimes, now)
+
+	return true
+}
+
+func (instance *ociInstance) stop() {
+	// Instance may be stopped concurrently by StartInstance failure, StopInstance and Close
+	instance.stopOnce.Do(func() { close(instance.stopChan) })
+
+	<-instance.doneChan
+}
+
+func getExitStatus(err error) exitStatus {
+	if err == nil {
+		return exitStatus{}
+	}
+
+	var exitErr *exec.ExitError
+
+	if !errors.As(err, &exitErr) {
+		return exitStatus{exitCode: -1}
+	}
+
+	if waitStatus, ok := exitErr.Sys().(syscall.WaitStatus); ok && waitStatus.Signaled() {
+		return exitStatus{
+			exitCode: signaledExitCodeOffset + int(waitStatus.Signal()), signal: int(waitStatus.Signal()),
+		}
+	}
+
+	return exitStatus{exitCode: exitErr.ExitCode()}
+}
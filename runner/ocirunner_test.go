@@ -0,0 +1,579 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/runner"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Fake OCI runtime: "run" fails if bundle contains fail file, exits with code from exit file if bundle contains it,
+// otherwise sleeps and stores its pid. Stop signals are
+// ignored if bundle contains ignore file. "exec" runs command on host if container is running. "checkpoint" creates
+// checkpoint file in image dir and kills container, "restore" fails if there is no checkpoint file.
+const fakeRuntimeScript = `#!/bin/sh
+STATE_DIR=$(dirname "$0")
+
+case "$1" in
+run)
+	if [ -f "$3/fail" ]; then
+		exit 1
+	fi
+
+	if [ -f "$3/exit" ]; then
+		exit $(cat "$3/exit")
+	fi
+
+	if [ -f "$3/ignore" ]; then
+		trap '' INT TERM
+	fi
+
+	echo $$ > "$STATE_DIR/$4.pid"
+	exec sleep 100
+	;;
+
+kill)
+	[ -f "$STATE_DIR/$2.pid" ] && kill -s "${3#SIG}" $(cat "$STATE_DIR/$2.pid")
+	;;
+
+delete)
+	rm -f "$STATE_DIR/$3.pid"
+	;;
+
+checkpoint)
+	[ -f "$STATE_DIR/$4.pid" ] || exit 1
+	touch "$3/checkpoint"
+	kill -9 $(cat "$STATE_DIR/$4.pid")
+	;;
+
+restore)
+	if [ ! -f "$3/checkpoint" ]; then
+		exit 1
+	fi
+
+	echo $$ > "$STATE_DIR/$6.pid"
+	touch "$STATE_DIR/$6.restored"
+	exec sleep 100
+	;;
+
+exec)
+	[ -f "$STATE_DIR/$2.pid" ] || exit 1
+	shift 2
+	exec "$@"
+	;;
+esac
+
+exit 0
+`
+
+const waitStatusTimeout = 5 * time.Second
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var tmpDir string
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Main
+ **********************************************************************************************************************/
+
+func TestMain(m *testing.M) {
+	var err error
+
+	if tmpDir, err = os.MkdirTemp("", "sm_"); err != nil {
+		log.Fatalf("Error create temporary dir: %v", err)
+	}
+
+	runtimePath := filepath.Join(tmpDir, runner.RuncRuntime)
+
+	if err = os.WriteFile(runtimePath, []byte(fakeRuntimeScript), 0o600); err != nil {
+		log.Fatalf("Can't create fake runtime: %v", err)
+	}
+
+	if err = os.Chmod(runtimePath, 0o700); err != nil { //nolint:gosec // runtime should be executable
+		log.Fatalf("Can't set fake runtime mode: %v", err)
+	}
+
+	runner.LookPath = func(file string) (string, error) {
+		return filepath.Join(tmpDir, file), nil
+	}
+
+	ret := m.Run()
+
+	if err = os.RemoveAll(tmpDir); err != nil {
+		log.Errorf("Error removing tmp dir: %v", err)
+	}
+
+	os.Exit(ret)
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestOCIRunnerStartStop(t *testing.T) {
+	ociRunner, err := runner.NewOCIRunner(runner.RuncRuntime)
+	if err != nil {
+		t.Fatalf("Can't create OCI runner: %v", err)
+	}
+	defer ociRunner.Close()
+
+	bundleDir, err := createBundle("instance0", false)
+	if err != nil {
+		t.Fatalf("Can't create bundle: %v", err)
+	}
+
+	status := ociRunner.StartInstance("instance0", bundleDir, runner.RunParameters{
+		StartInterval: 200 * time.Millisecond,
+	})
+	if status.State != cloudprotocol.InstanceStateActive {
+		t.Fatalf("Wrong instance state: %s, err: %v", status.State, status.Err)
+	}
+
+	pid, err := getInstancePID("instance0")
+	if err != nil {
+		t.Fatalf("Can't get instance pid: %v", err)
+	}
+
+	if err = ociRunner.StopInstance("instance0"); err != nil {
+		t.Errorf("Can't stop instance: %v", err)
+	}
+
+	if err = syscall.Kill(pid, 0); err == nil {
+		t.Error("Instance process should be killed")
+	}
+
+	if _, err = os.Stat(filepath.Join(tmpDir, "instance0.pid")); !os.IsNotExist(err) {
+		t.Error("Container should be deleted")
+	}
+}
+
+func TestOCIRunnerStartLimit(t *testing.T) {
+	ociRunner, err := runner.NewOCIRunner(runner.RuncRuntime)
+	if err != nil {
+		t.Fatalf("Can't create OCI runner: %v", err)
+	}
+	defer ociRunner.Close()
+
+	bundleDir, err := createBundle("instance1", true)
+	if err != nil {
+		t.Fatalf("Can't create bundle: %v", err)
+	}
+
+	status := ociRunner.StartInstance("instance1", bundleDir, runner.RunParameters{
+		StartInterval:   1 * time.Second,
+		StartBurst:      2,
+		RestartInterval: 10 * time.Millisecond,
+	})
+	if status.State != cloudprotocol.InstanceStateFailed {
+		t.Errorf("Wrong instance state: %s", status.State)
+	}
+
+	if status.Err == nil {
+		t.Error("Error expected")
+	}
+}
+
+func TestOCIRunnerRestart(t *testing.T) {
+	ociRunner, err := runner.NewOCIRunner(runner.RuncRuntime)
+	if err != nil {
+		t.Fatalf("Can't create OCI runner: %v", err)
+	}
+	defer ociRunner.Close()
+
+	bundleDir, err := createBundle("instance2", false)
+	if err != nil {
+		t.Fatalf("Can't create bundle: %v", err)
+	}
+
+	status := ociRunner.StartInstance("instance2", bundleDir, runner.RunParameters{
+		StartInterval:   200 * time.Millisecond,
+		StartBurst:      2,
+		RestartInterval: 10 * time.Millisecond,
+	})
+	if status.State != cloudprotocol.InstanceStateActive {
+		t.Fatalf("Wrong instance state: %s, err: %v", status.State, status.Err)
+	}
+
+	pid, err := getInstancePID("instance2")
+	if err != nil {
+		t.Fatalf("Can't get instance pid: %v", err)
+	}
+
+	if err = syscall.Kill(pid, syscall.SIGKILL); err != nil {
+		t.Fatalf("Can't kill instance process: %v", err)
+	}
+
+	if err = waitInstanceStatus(ociRunner.InstanceStatusChannel(), runner.InstanceStatus{
+		InstanceID: "instance2", State: cloudprotocol.InstanceStateFailed, ExitCode: 128 + int(syscall.SIGKILL),
+		Signal: int(syscall.SIGKILL),
+	}); err != nil {
+		t.Errorf("Wrong instance status: %v", err)
+	}
+
+	if err = waitInstanceStatus(ociRunner.InstanceStatusChannel(), runner.InstanceStatus{
+		InstanceID: "instance2", State: cloudprotocol.InstanceStateActive,
+	}); err != nil {
+		t.Errorf("Wrong instance status: %v", err)
+	}
+
+	// Wait restarted container is created to stop it gracefully
+
+	if err = waitInstancePID("instance2", pid); err != nil {
+		t.Errorf("Can't wait instance pid: %v", err)
+	}
+
+	if err = ociRunner.StopInstance("instance2"); err != nil {
+		t.Errorf("Can't stop instance: %v", err)
+	}
+}
+
+func TestOCIRunnerExec(t *testing.T) {
+	ociRunner, err := runner.NewOCIRunner(runner.RuncRuntime)
+	if err != nil {
+		t.Fatalf("Can't create OCI runner: %v", err)
+	}
+	defer ociRunner.Close()
+
+	bundleDir, err := createBundle("instance3", false)
+	if err != nil {
+		t.Fatalf("Can't create bundle: %v", err)
+	}
+
+	status := ociRunner.StartInstance("instance3", bundleDir, runner.RunParameters{
+		StartInterval: 200 * time.Millisecond,
+	})
+	if status.State != cloudprotocol.InstanceStateActive {
+		t.Fatalf("Wrong instance state: %s, err: %v", status.State, status.Err)
+	}
+
+	if err = ociRunner.ExecInstance(context.Background(), "instance3", []string{"true"}); err != nil {
+		t.Errorf("Can't exec instance command: %v", err)
+	}
+
+	if err = ociRunner.ExecInstance(context.Background(), "instance3", []string{"false"}); err == nil {
+		t.Error("Error expected")
+	}
+
+	if err = ociRunner.StopInstance("instance3"); err != nil {
+		t.Errorf("Can't stop instance: %v", err)
+	}
+
+	if err = ociRunner.ExecInstance(context.Background(), "instance3", []string{"true"}); err == nil {
+		t.Error("Error expected")
+	}
+}
+
+func TestOCIRunnerGracefulStop(t *testing.T) {
+	ociRunner, err := runner.NewOCIRunner(runner.RuncRuntime)
+	if err != nil {
+		t.Fatalf("Can't create OCI runner: %v", err)
+	}
+	defer ociRunner.Close()
+
+	preStopFile := filepath.Join(tmpDir, "prestop")
+
+	bundleDir, err := createBundle("instance4", false)
+	if err != nil {
+		t.Fatalf("Can't create bundle: %v", err)
+	}
+
+	status := ociRunner.StartInstance("instance4", bundleDir, runner.RunParameters{
+		StartInterval: 200 * time.Millisecond,
+		StopSignal:    syscall.SIGINT,
+		StopTimeout:   1 * time.Second,
+		PreStop:       []string{"touch", preStopFile},
+	})
+	if status.State != cloudprotocol.InstanceStateActive {
+		t.Fatalf("Wrong instance state: %s, err: %v", status.State, status.Err)
+	}
+
+	if err = ociRunner.StopInstance("instance4"); err != nil {
+		t.Errorf("Can't stop instance: %v", err)
+	}
+
+	if _, err = os.Stat(preStopFile); err != nil {
+		t.Errorf("Pre-stop command should be executed: %v", err)
+	}
+
+	// Instance which ignores stop signal should be killed after stop timeout
+
+	if err = os.WriteFile(filepath.Join(bundleDir, "ignore"), nil, 0o600); err != nil {
+		t.Fatalf("Can't create ignore file: %v", err)
+	}
+
+	status = ociRunner.StartInstance("instance4", bundleDir, runner.RunParameters{
+		StartInterval: 200 * time.Millisecond,
+		StopSignal:    syscall.SIGINT,
+		StopTimeout:   200 * time.Millisecond,
+	})
+	if status.State != cloudprotocol.InstanceStateActive {
+		t.Fatalf("Wrong instance state: %s, err: %v", status.State, status.Err)
+	}
+
+	pid, err := getInstancePID("instance4")
+	if err != nil {
+		t.Fatalf("Can't get instance pid: %v", err)
+	}
+
+	if err = ociRunner.StopInstance("instance4"); !errors.Is(err, runner.ErrStopForced) {
+		t.Errorf("Wrong stop error: %v", err)
+	}
+
+	if err = syscall.Kill(pid, 0); err == nil {
+		t.Error("Instance process should be killed")
+	}
+}
+
+func TestOCIRunnerCheckpointRestore(t *testing.T) {
+	ociRunner, err := runner.NewOCIRunner(runner.RuncRuntime)
+	if err != nil {
+		t.Fatalf("Can't create OCI runner: %v", err)
+	}
+	defer ociRunner.Close()
+
+	bundleDir, err := createBundle("instance5", false)
+	if err != nil {
+		t.Fatalf("Can't create bundle: %v", err)
+	}
+
+	imageDir := filepath.Join(tmpDir, "checkpoint")
+
+	if err = os.MkdirAll(imageDir, 0o755); err != nil {
+		t.Fatalf("Can't create image dir: %v", err)
+	}
+
+	status := ociRunner.StartInstance("instance5", bundleDir, runner.RunParameters{
+		StartInterval: 200 * time.Millisecond,
+	})
+	if status.State != cloudprotocol.InstanceStateActive {
+		t.Fatalf("Wrong instance state: %s, err: %v", status.State, status.Err)
+	}
+
+	pid, err := getInstancePID("instance5")
+	if err != nil {
+		t.Fatalf("Can't get instance pid: %v", err)
+	}
+
+	if err = ociRunner.CheckpointInstance("instance5", imageDir); err != nil {
+		t.Fatalf("Can't checkpoint instance: %v", err)
+	}
+
+	if err = syscall.Kill(pid, 0); err == nil {
+		t.Error("Instance process should be killed")
+	}
+
+	if err = ociRunner.CheckpointInstance("instance5", imageDir); err == nil {
+		t.Error("Error expected")
+	}
+
+	status = ociRunner.StartInstance("instance5", bundleDir, runner.RunParameters{
+		StartInterval: 200 * time.Millisecond,
+		RestorePath:   imageDir,
+	})
+	if status.State != cloudprotocol.InstanceStateActive {
+		t.Fatalf("Wrong instance state: %s, err: %v", status.State, status.Err)
+	}
+
+	if _, err = os.Stat(filepath.Join(tmpDir, "instance5.restored")); err != nil {
+		t.Errorf("Instance should be restored: %v", err)
+	}
+
+	if err = ociRunner.StopInstance("instance5"); err != nil {
+		t.Errorf("Can't stop instance: %v", err)
+	}
+}
+
+func TestOCIRunnerOneShot(t *testing.T) {
+	ociRunner, err := runner.NewOCIRunner(runner.RuncRuntime)
+	if err != nil {
+		t.Fatalf("Can't create OCI runner: %v", err)
+	}
+	defer ociRunner.Close()
+
+	bundleDir, err := createBundle("instance6", false)
+	if err != nil {
+		t.Fatalf("Can't create bundle: %v", err)
+	}
+
+	if err = os.WriteFile(filepath.Join(bundleDir, "exit"), []byte("3"), 0o600); err != nil {
+		t.Fatalf("Can't create exit file: %v", err)
+	}
+
+	status := ociRunner.StartInstance("instance6", bundleDir, runner.RunParameters{
+		StartInterval:   200 * time.Millisecond,
+		RestartInterval: 10 * time.Millisecond,
+		OneShot:         true,
+	})
+	if status.State != runner.InstanceStateCompleted || status.ExitCode != 3 || status.Err != nil {
+		t.Errorf("Wrong instance status: %v", status)
+	}
+
+	select {
+	case statuses := <-ociRunner.InstanceStatusChannel():
+		t.Errorf("One-shot instance should not be restarted: %v", statuses)
+
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err = ociRunner.StopInstance("instance6"); err != nil {
+		t.Errorf("Can't stop instance: %v", err)
+	}
+}
+
+func TestOCIRunnerInitContainer(t *testing.T) {
+	ociRunner, err := runner.NewOCIRunner(runner.RuncRuntime)
+	if err != nil {
+		t.Fatalf("Can't create OCI runner: %v", err)
+	}
+	defer ociRunner.Close()
+
+	bundleDir, err := createBundle("init0", false)
+	if err != nil {
+		t.Fatalf("Can't create bundle: %v", err)
+	}
+
+	if err = os.WriteFile(filepath.Join(bundleDir, "exit"), []byte("5"), 0o600); err != nil {
+		t.Fatalf("Can't create exit file: %v", err)
+	}
+
+	exitCode, err := ociRunner.RunInitContainer(context.Background(), "init0", bundleDir)
+	if err != nil {
+		t.Fatalf("Can't run init container: %v", err)
+	}
+
+	if exitCode != 5 {
+		t.Errorf("Wrong exit code: %d", exitCode)
+	}
+
+	if err = os.Remove(filepath.Join(bundleDir, "exit")); err != nil {
+		t.Fatalf("Can't remove exit file: %v", err)
+	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelFunc()
+
+	if _, err = ociRunner.RunInitContainer(ctx, "init0", bundleDir); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Timeout error expected: %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(tmpDir, "init0.pid")); !os.IsNotExist(err) {
+		t.Error("Container should be deleted")
+	}
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func createBundle(instanceID string, fail bool) (bundleDir string, err error) {
+	bundleDir = filepath.Join(tmpDir, "bundles", instanceID)
+
+	if err = os.MkdirAll(bundleDir, 0o755); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	if fail {
+		if err = os.WriteFile(filepath.Join(bundleDir, "fail"), nil, 0o600); err != nil {
+			return "", aoserrors.Wrap(err)
+		}
+	}
+
+	return bundleDir, nil
+}
+
+func getInstancePID(instanceID string) (pid int, err error) {
+	data, err := os.ReadFile(filepath.Join(tmpDir, instanceID+".pid"))
+	if err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	if pid, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	return pid, nil
+}
+
+func waitInstancePID(instanceID string, prevPID int) error {
+	timeout := time.After(waitStatusTimeout)
+
+	for {
+		if pid, err := getInstancePID(instanceID); err == nil && pid != prevPID {
+			return nil
+		}
+
+		select {
+		case <-time.After(10 * time.Millisecond):
+
+		case <-timeout:
+			return aoserrors.New("wait pid timeout")
+		}
+	}
+}
+
+func waitInstanceStatus(statusChannel <-chan []runner.InstanceStatus, expectedStatus runner.InstanceStatus) error {
+	select {
+	case statuses := <-statusChannel:
+		if len(statuses) != 1 {
+			return aoserrors.Errorf("wrong statuses count: %d", len(statuses))
+		}
+
+		if statuses[0].InstanceID != expectedStatus.InstanceID || statuses[0].State != expectedStatus.State ||
+			statuses[0].ExitCode != expectedStatus.ExitCode || statuses[0].Signal != expectedStatus.Signal {
+			return aoserrors.Errorf("wrong status: %v", statuses[0])
+		}
+
+		return nil
+
+	case <-time.After(waitStatusTimeout):
+		return aoserrors.New("wait status timeout")
+	}
+}
@@ -19,17 +19,21 @@ package runner
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/aosedge/aos_common/aoserrors"
 	"github.com/aosedge/aos_common/api/cloudprotocol"
 	"github.com/coreos/go-systemd/v22/dbus"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 /***********************************************************************************************************************
@@ -42,14 +46,20 @@ const (
 	defaultStartInterval   = 5 * time.Second
 	defaultStartBurst      = 3
 	defaultRestartInterval = 1 * time.Second
+	defaultStopSignal      = syscall.SIGTERM
+	defaultStopTimeout     = 10 * time.Second
 	startTimeoutMultiplier = 1.2
 )
 
+// Stop signal used by aos-service@.service unit.
+const unitStopSignal = syscall.SIGKILL
+
 const systemdUnitNameTemplate = "aos-service@%s.service"
 
 const (
 	errNotLoaded  = "not loaded"
 	jobStatusDone = "done"
+	resultTimeout = "timeout"
 )
 
 const (
@@ -59,6 +69,15 @@ const (
 
 const statusPollPeriod = 1 * time.Second
 
+// InstanceStateCompleted one-shot instance main process has exited.
+const InstanceStateCompleted = "completed"
+
+// Service main process exit codes reported by systemd (see waitid(2)).
+const (
+	cldKilled = 2
+	cldDumped = 3
+)
+
 /***********************************************************************************************************************
   Types
  **********************************************************************************************************************/
@@ -68,6 +87,13 @@ type RunParameters struct {
 	StartInterval   time.Duration
 	StartBurst      uint
 	RestartInterval time.Duration
+	StopSignal      syscall.Signal
+	StopTimeout     time.Duration
+	PreStop         []string
+	// instance is restored from checkpoint image if set
+	RestorePath string
+	// instance is not restarted and is reported completed when its main process exits
+	OneShot bool
 }
 
 // InstanceStatus service instance status.
@@ -76,6 +102,7 @@ type InstanceStatus struct {
 	State      string
 	Err        error
 	ExitCode   int
+	Signal     int
 }
 
 // Runner runner instance.
@@ -84,21 +111,40 @@ type Runner struct {
 	systemd            *dbus.Conn
 	instanceStatusChan chan []InstanceStatus
 	runningUnits       map[string]chan dbus.UnitStatus
+	oneShotUnits       map[string]struct{}
 	stopChan           chan struct{}
+	runtimePath        string
 }
 
+/***********************************************************************************************************************
+  Vars
+ **********************************************************************************************************************/
+
+// ErrStopForced is returned when instance doesn't exit within stop timeout and is killed.
+var ErrStopForced = errors.New("instance stop forced")
+
 /***********************************************************************************************************************
   Public
  **********************************************************************************************************************/
 
 // New creates new systemd runner.
-func New() (runner *Runner, err error) {
+func New(runtime string) (runner *Runner, err error) {
+	// aos-service@.service unit starts instances by runc
+	if runtime != RuncRuntime {
+		return nil, aoserrors.Errorf("runtime %s is not supported by systemd runner", runtime)
+	}
+
 	runner = &Runner{
 		instanceStatusChan: make(chan []InstanceStatus, unitStatusChannelSize),
 		runningUnits:       make(map[string]chan dbus.UnitStatus),
+		oneShotUnits:       make(map[string]struct{}),
 		stopChan:           make(chan struct{}, 1),
 	}
 
+	if runner.runtimePath, err = LookPath(runtime); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
 	// Create systemd connection
 	if runner.systemd, err = dbus.NewSystemConnectionContext(context.Background()); err != nil {
 		return nil, aoserrors.Wrap(err)
@@ -135,6 +181,10 @@ func (runner *Runner) StartInstance(instanceID, runtimeDir string, params RunPar
 
 	runner.runningUnits[unitName] = unitStatusChannel
 
+	if params.OneShot {
+		runner.oneShotUnits[unitName] = struct{}{}
+	}
+
 	runner.Unlock()
 
 	defer func() {
@@ -146,6 +196,7 @@ func (runner *Runner) StartInstance(instanceID, runtimeDir string, params RunPar
 
 		if status.State == cloudprotocol.InstanceStateFailed {
 			delete(runner.runningUnits, unitName)
+			delete(runner.oneShotUnits, unitName)
 
 			if status.Err == nil {
 				status.Err = aoserrors.Errorf("instance failed")
@@ -159,21 +210,15 @@ func (runner *Runner) StartInstance(instanceID, runtimeDir string, params RunPar
 		"StartInterval":   params.StartInterval,
 		"StartBurst":      params.StartBurst,
 		"RestartInterval": params.RestartInterval,
+		"StopSignal":      params.StopSignal,
+		"StopTimeout":     params.StopTimeout,
+		"RestorePath":     params.RestorePath,
+		"OneShot":         params.OneShot,
 	}).Debug("Start service instance")
 
-	if params.StartInterval == 0 {
-		params.StartInterval = defaultStartInterval
-	}
+	params = setDefaultRunParameters(params)
 
-	if params.StartBurst == 0 {
-		params.StartBurst = defaultStartBurst
-	}
-
-	if params.RestartInterval == 0 {
-		params.RestartInterval = defaultRestartInterval
-	}
-
-	if status.Err = runner.setRunParameters(unitName, params); status.Err != nil {
+	if status.Err = runner.setRunParameters(unitName, runtimeDir, params); status.Err != nil {
 		return status
 	}
 
@@ -190,12 +235,21 @@ func (runner *Runner) StartInstance(instanceID, runtimeDir string, params RunPar
 		"name": unitName, "jobStatus": jobStatus, "instanceID": instanceID,
 	}).Debug("Start instance")
 
+	// Restore is done once, further unit restarts should start instance from scratch
+	if params.RestorePath != "" {
+		runner.resetRestoreParameters(unitName, runtimeDir, params)
+	}
+
 	if jobStatus != jobStatusDone {
 		return status
 	}
 
 	status.State = runner.getStartingState(unitName, unitStatusChannel, params)
 
+	if params.OneShot {
+		runner.setOneShotStatus(&status)
+	}
+
 	return status
 }
 
@@ -205,7 +259,8 @@ func (runner *Runner) StopInstance(instanceID string) (err error) {
 
 	runner.Lock()
 
-	delete(runner.runningUnits, fmt.Sprintf(systemdUnitNameTemplate, instanceID))
+	delete(runner.runningUnits, unitName)
+	delete(runner.oneShotUnits, unitName)
 
 	runner.Unlock()
 
@@ -228,6 +283,12 @@ func (runner *Runner) StopInstance(instanceID string) (err error) {
 		if jobStatus != jobStatusDone && err == nil {
 			err = aoserrors.Errorf("job status %s", jobStatus)
 		}
+
+		if result, resultErr := runner.systemd.GetServicePropertyContext(
+			context.Background(), unitName, "Result"); resultErr == nil && err == nil &&
+			result.Value.Value() == resultTimeout {
+			err = aoserrors.Wrap(ErrStopForced)
+		}
 	}
 
 	if resetErr := runner.systemd.ResetFailedUnitContext(context.Background(), unitName); resetErr != nil {
@@ -244,6 +305,28 @@ func (runner *Runner) StopInstance(instanceID string) (err error) {
 	return err
 }
 
+// ExecInstance executes command inside service instance container.
+func (runner *Runner) ExecInstance(ctx context.Context, instanceID string, cmd []string) error {
+	return execContainer(ctx, runner.runtimePath, instanceID, cmd)
+}
+
+// RunInitContainer runs container from bundle till its process exits and returns process exit code.
+func (runner *Runner) RunInitContainer(ctx context.Context, containerID, bundleDir string) (exitCode int, err error) {
+	return runInitContainer(ctx, runner.runtimePath, containerID, bundleDir)
+}
+
+// CheckpointInstance checkpoints service instance into image path. Instance container is terminated by checkpoint
+// and the instance should be stopped afterwards.
+func (runner *Runner) CheckpointInstance(instanceID, imagePath string) error {
+	runner.Lock()
+
+	delete(runner.runningUnits, fmt.Sprintf(systemdUnitNameTemplate, instanceID))
+
+	runner.Unlock()
+
+	return checkpointContainer(runner.runtimePath, instanceID, imagePath)
+}
+
 /***********************************************************************************************************************
   Private
  **********************************************************************************************************************/
@@ -282,6 +365,18 @@ func (runner *Runner) monitorUnitStates() {
 				continue
 			}
 
+			for i := range instancesStatus {
+				if runner.isOneShot(instancesStatus[i].InstanceID) {
+					runner.setOneShotStatus(&instancesStatus[i])
+
+					continue
+				}
+
+				if instancesStatus[i].State == cloudprotocol.InstanceStateFailed {
+					runner.setExitStatus(&instancesStatus[i])
+				}
+			}
+
 			select {
 			case runner.instanceStatusChan <- instancesStatus:
 
@@ -318,6 +413,11 @@ func (runner *Runner) getStartingState(
 			currentState = unitStatus.ActiveState
 
 		case <-time.After(time.Duration(startTimeoutMultiplier * float32(params.StartInterval))):
+			// One-shot instance may exit successfully before start timeout
+			if params.OneShot && currentState == cloudprotocol.InstanceStateInactive {
+				return cloudprotocol.InstanceStateInactive
+			}
+
 			if currentState != cloudprotocol.InstanceStateActive {
 				return cloudprotocol.InstanceStateFailed
 			}
@@ -327,6 +427,82 @@ func (runner *Runner) getStartingState(
 	}
 }
 
+func (runner *Runner) setExitStatus(status *InstanceStatus) {
+	unitName := fmt.Sprintf(systemdUnitNameTemplate, status.InstanceID)
+
+	code, err := runner.getServiceProperty(unitName, "ExecMainCode")
+	if err != nil {
+		log.WithField("instanceID", status.InstanceID).Warnf("Can't get instance exit code: %v", err)
+
+		return
+	}
+
+	exitStatus, err := runner.getServiceProperty(unitName, "ExecMainStatus")
+	if err != nil {
+		log.WithField("instanceID", status.InstanceID).Warnf("Can't get instance exit status: %v", err)
+
+		return
+	}
+
+	if code == cldKilled || code == cldDumped {
+		status.Signal = int(exitStatus)
+		status.ExitCode = signaledExitCodeOffset + int(exitStatus)
+
+		return
+	}
+
+	status.ExitCode = int(exitStatus)
+}
+
+// setOneShotStatus sets completed state if one-shot instance main process has exited. Instance fails only if its
+// main process has never been started.
+func (runner *Runner) setOneShotStatus(status *InstanceStatus) {
+	switch status.State {
+	case cloudprotocol.InstanceStateInactive:
+		status.State = InstanceStateCompleted
+
+	case cloudprotocol.InstanceStateFailed:
+		code, err := runner.getServiceProperty(fmt.Sprintf(systemdUnitNameTemplate, status.InstanceID), "ExecMainCode")
+		if err != nil {
+			log.WithField("instanceID", status.InstanceID).Warnf("Can't get instance exit code: %v", err)
+
+			return
+		}
+
+		if code == 0 {
+			return
+		}
+
+		status.State = InstanceStateCompleted
+		status.Err = nil
+
+		runner.setExitStatus(status)
+	}
+}
+
+func (runner *Runner) isOneShot(instanceID string) bool {
+	runner.RLock()
+	defer runner.RUnlock()
+
+	_, ok := runner.oneShotUnits[fmt.Sprintf(systemdUnitNameTemplate, instanceID)]
+
+	return ok
+}
+
+func (runner *Runner) getServiceProperty(unitName, propertyName string) (int32, error) {
+	property, err := runner.systemd.GetServicePropertyContext(context.Background(), unitName, propertyName)
+	if err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	value, ok := property.Value.Value().(int32)
+	if !ok {
+		return 0, aoserrors.Errorf("wrong %s property type", propertyName)
+	}
+
+	return value, nil
+}
+
 func (runner *Runner) isUnitUnderMonitoring(unitName string) bool {
 	runner.RLock()
 	defer runner.RUnlock()
@@ -367,19 +543,63 @@ func isUnitStatusChanged(u1, u2 *dbus.UnitStatus) bool {
 		u1.SubState != u2.SubState
 }
 
-func (runner *Runner) setRunParameters(unitName string, params RunParameters) error {
+func (runner *Runner) setRunParameters(unitName, runtimeDir string, params RunParameters) error {
 	const parametersFormat = `[Unit]
 StartLimitIntervalSec=%s
 StartLimitBurst=%d
 
 [Service]
+Restart=%s
 RestartSec=%s
-`
+%s`
 
-	if params.StartInterval < 1*time.Microsecond || params.RestartInterval < 1*time.Microsecond {
+	if !validRunParameters(params) {
 		return aoserrors.New("invalid parameters")
 	}
 
+	restart := "always"
+
+	if params.OneShot {
+		restart = "no"
+	}
+
+	// Stop parameters are set only if provided by the service, otherwise ones from the unit file are used
+	var service strings.Builder
+
+	if params.StopSignal != 0 {
+		signalName := unix.SignalName(params.StopSignal)
+
+		fmt.Fprintf(&service, "KillSignal=%s\nSuccessExitStatus=%s\n", signalName, signalName)
+	}
+
+	if params.StopTimeout != 0 {
+		fmt.Fprintf(&service, "TimeoutStopSec=%s\n", params.StopTimeout)
+	}
+
+	if params.StopSignal != 0 || len(params.PreStop) > 0 {
+		service.WriteString("ExecStop=\n")
+
+		if len(params.PreStop) > 0 {
+			// Pre-stop command failure should not prevent the instance from stopping
+			fmt.Fprintf(&service, "ExecStop=-%s exec %%i %s\n", runner.runtimePath, escapeUnitCommand(params.PreStop))
+		}
+
+		stopSignal := params.StopSignal
+		if stopSignal == 0 {
+			stopSignal = unitStopSignal
+		}
+
+		fmt.Fprintf(&service, "ExecStop=%s kill %%i %s\n", runner.runtimePath, unix.SignalName(stopSignal))
+	}
+
+	if params.RestorePath != "" {
+		fmt.Fprintf(&service, "ExecStart=\nExecStart=%s restore -d %s %%i\n", runner.runtimePath, escapeUnitCommand(
+			[]string{
+				"--image-path", params.RestorePath, "--pid-file", filepath.Join(runtimeDir, ".pid"),
+				"--bundle", runtimeDir,
+			}))
+	}
+
 	parametersDir := filepath.Join(systemdDropInsDir, unitName+".d")
 
 	if err := os.MkdirAll(parametersDir, 0o755); err != nil {
@@ -388,7 +608,8 @@ RestartSec=%s
 
 	if err := os.WriteFile( //nolint:gosec // To fix systemd warning, file parameters.conf should be 644
 		filepath.Join(parametersDir, parametersFileName),
-		[]byte(fmt.Sprintf(parametersFormat, params.StartInterval, params.StartBurst, params.RestartInterval)),
+		[]byte(fmt.Sprintf(parametersFormat, params.StartInterval, params.StartBurst, restart, params.RestartInterval,
+			service.String())),
 		0o644); err != nil {
 		return aoserrors.Wrap(err)
 	}
@@ -396,6 +617,123 @@ RestartSec=%s
 	return nil
 }
 
+func (runner *Runner) resetRestoreParameters(unitName, runtimeDir string, params RunParameters) {
+	params.RestorePath = ""
+
+	if err := runner.setRunParameters(unitName, runtimeDir, params); err != nil {
+		log.WithField("name", unitName).Errorf("Can't reset restore parameters: %v", err)
+
+		return
+	}
+
+	if err := runner.systemd.ReloadContext(context.Background()); err != nil {
+		log.WithField("name", unitName).Errorf("Can't reload systemd: %v", err)
+	}
+}
+
+func setDefaultRunParameters(params RunParameters) RunParameters {
+	if params.StartInterval == 0 {
+		params.StartInterval = defaultStartInterval
+	}
+
+	if params.StartBurst == 0 {
+		params.StartBurst = defaultStartBurst
+	}
+
+	if params.RestartInterval == 0 {
+		params.RestartInterval = defaultRestartInterval
+	}
+
+	return params
+}
+
+func setDefaultStopParameters(params RunParameters) RunParameters {
+	if params.StopSignal == 0 {
+		params.StopSignal = defaultStopSignal
+	}
+
+	if params.StopTimeout == 0 {
+		params.StopTimeout = defaultStopTimeout
+	}
+
+	return params
+}
+
+func validRunParameters(params RunParameters) bool {
+	return params.StartInterval >= 1*time.Microsecond && params.RestartInterval >= 1*time.Microsecond &&
+		params.StopTimeout >= 0 && (params.StopSignal == 0 || unix.SignalName(params.StopSignal) != "")
+}
+
+// escapeUnitCommand quotes command arguments and escapes systemd specifiers and variables.
+func escapeUnitCommand(cmd []string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$", "\n", `\n`)
+	args := make([]string, 0, len(cmd))
+
+	for _, arg := range cmd {
+		args = append(args, `"`+replacer.Replace(arg)+`"`)
+	}
+
+	return strings.Join(args, " ")
+}
+
+func checkpointContainer(runtimePath, instanceID, imagePath string) error {
+	if output, err := exec.Command(
+		runtimePath, "checkpoint", "--image-path", imagePath, instanceID).CombinedOutput(); err != nil {
+		return aoserrors.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func runInitContainer(ctx context.Context, runtimePath, containerID, bundleDir string) (exitCode int, err error) {
+	deleteContainer := func() {
+		if output, deleteErr := exec.Command(
+			runtimePath, "delete", "--force", containerID).CombinedOutput(); deleteErr != nil {
+			log.WithField("containerID", containerID).Debugf("Can't delete container: %s", string(output))
+		}
+	}
+
+	deleteContainer()
+	defer deleteContainer()
+
+	logWriter := log.WithField("containerID", containerID).Writer()
+	defer logWriter.Close()
+
+	cmd := exec.CommandContext(ctx, runtimePath, "run", "--bundle", bundleDir, containerID)
+
+	cmd.Stdout = logWriter
+	cmd.Stderr = logWriter
+
+	if err = cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return 0, aoserrors.Wrap(ctx.Err())
+		}
+
+		status := getExitStatus(err)
+		if status.exitCode < 0 {
+			return 0, aoserrors.Wrap(err)
+		}
+
+		return status.exitCode, nil
+	}
+
+	return 0, nil
+}
+
+func execContainer(ctx context.Context, runtimePath, instanceID string, cmd []string) error {
+	if len(cmd) == 0 {
+		return aoserrors.New("empty command")
+	}
+
+	args := append([]string{"exec", instanceID}, cmd...)
+
+	if output, err := exec.CommandContext(ctx, runtimePath, args...).CombinedOutput(); err != nil {
+		return aoserrors.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
 func (runner *Runner) removeRunParameters(unitName string) error {
 	if err := os.RemoveAll(filepath.Join(systemdDropInsDir, unitName+".d")); err != nil {
 		return aoserrors.Wrap(err)
@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scrubber provides periodic integrity check of installed services and layers.
+package scrubber
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/config"
+	"github.com/aosedge/aos_servicemanager/utils/ratelimiter"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Checked item types.
+const (
+	ServiceItem = "service"
+	LayerItem   = "layer"
+)
+
+const coreComponent = "aos-servicemanager"
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// OpenFunc opens file of checked item.
+type OpenFunc func(name string) (io.ReadCloser, error)
+
+// DamagedItem item which content doesn't match its digest.
+type DamagedItem struct {
+	Type       string
+	ID         string
+	AosVersion uint64
+	Err        error
+}
+
+// Checker checks integrity of installed items. Checker should read items content with provided open function and
+// should mark damaged items in its storage. Items which are already marked as damaged should be skipped.
+type Checker interface {
+	CheckIntegrity(ctx context.Context, open OpenFunc) (damaged []DamagedItem, err error)
+}
+
+// AlertSender provides alert sender interface.
+type AlertSender interface {
+	SendAlert(alert cloudprotocol.AlertItem)
+}
+
+// Scrubber periodically checks integrity of installed items.
+type Scrubber struct {
+	sync.Mutex
+
+	period      time.Duration
+	rateLimiter *ratelimiter.RateLimiter
+	alertSender AlertSender
+	checkers    []Checker
+	cancelFunc  context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+type limitedFile struct {
+	io.Reader
+	io.Closer
+}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// New creates scrubber. Checkers are run periodically if scrubbing period is configured.
+func New(config *config.Config, alertSender AlertSender, checkers ...Checker) (scrubber *Scrubber) {
+	log.Debug("Create scrubber")
+
+	scrubber = &Scrubber{
+		period:      config.Scrubber.Period.Duration,
+		alertSender: alertSender,
+		checkers:    checkers,
+	}
+
+	if config.Scrubber.MaxReadRate != 0 {
+		scrubber.rateLimiter = ratelimiter.New(config.Scrubber.MaxReadRate)
+	}
+
+	if scrubber.period == 0 {
+		log.Warn("Integrity scrubbing is disabled")
+
+		return scrubber
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	scrubber.cancelFunc = cancelFunc
+
+	scrubber.wg.Add(1)
+
+	go scrubber.run(ctx)
+
+	return scrubber
+}
+
+// Close closes scrubber. Running check is canceled.
+func (scrubber *Scrubber) Close() {
+	log.Debug("Close scrubber")
+
+	if scrubber.cancelFunc != nil {
+		scrubber.cancelFunc()
+	}
+
+	scrubber.wg.Wait()
+}
+
+// Scrub checks integrity of all items once. Alert is sent for each found damaged item.
+func (scrubber *Scrubber) Scrub(ctx context.Context) (err error) {
+	scrubber.Lock()
+	defer scrubber.Unlock()
+
+	log.Debug("Start integrity scrubbing")
+
+	open := func(name string) (io.ReadCloser, error) {
+		return scrubber.open(ctx, name)
+	}
+
+	for _, checker := range scrubber.checkers {
+		damaged, checkErr := checker.CheckIntegrity(ctx, open)
+
+		for _, item := range damaged {
+			scrubber.sendAlert(item)
+		}
+
+		if checkErr != nil && err == nil {
+			err = aoserrors.Wrap(checkErr)
+		}
+	}
+
+	log.Debug("Integrity scrubbing finished")
+
+	return err
+}
+
+// OpenFile opens file without read rate limit.
+func OpenFile(name string) (io.ReadCloser, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return file, nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (scrubber *Scrubber) run(ctx context.Context) {
+	defer scrubber.wg.Done()
+
+	ticker := time.NewTicker(scrubber.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := scrubber.Scrub(ctx); err != nil && ctx.Err() == nil {
+				log.Errorf("Integrity scrubbing failed: %v", err)
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (scrubber *Scrubber) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if scrubber.rateLimiter == nil {
+		return file, nil
+	}
+
+	return &limitedFile{Reader: ratelimiter.NewReader(ctx, file, scrubber.rateLimiter), Closer: file}, nil
+}
+
+func (scrubber *Scrubber) sendAlert(item DamagedItem) {
+	log.WithFields(log.Fields{
+		"type": item.Type, "id": item.ID, "aosVersion": item.AosVersion,
+	}).Errorf("Integrity check failed: %v", item.Err)
+
+	if scrubber.alertSender == nil {
+		return
+	}
+
+	scrubber.alertSender.SendAlert(cloudprotocol.AlertItem{
+		Timestamp: time.Now(),
+		Tag:       cloudprotocol.AlertTagAosCore,
+		Payload: cloudprotocol.CoreAlert{
+			CoreComponent: coreComponent,
+			Message: fmt.Sprintf("%s %s version %d is damaged or tampered: %v",
+				item.Type, item.ID, item.AosVersion, item.Err),
+		},
+	})
+}
@@ -0,0 +1,237 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scrubber_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aosedge/aos_common/aostypes"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/config"
+	"github.com/aosedge/aos_servicemanager/scrubber"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type testAlertSender struct {
+	sync.Mutex
+	alerts []cloudprotocol.CoreAlert
+}
+
+type testChecker struct {
+	files      []string
+	damaged    []scrubber.DamagedItem
+	err        error
+	checkCount int
+	readSize   int64
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var tmpDir string
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Main
+ **********************************************************************************************************************/
+
+func TestMain(m *testing.M) {
+	var err error
+
+	if tmpDir, err = os.MkdirTemp("", "sm_"); err != nil {
+		log.Fatalf("Error create temporary dir: %v", err)
+	}
+
+	ret := m.Run()
+
+	if err = os.RemoveAll(tmpDir); err != nil {
+		log.Errorf("Error removing tmp dir: %v", err)
+	}
+
+	os.Exit(ret)
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestScrub(t *testing.T) {
+	alertSender := &testAlertSender{}
+	checkErr := errors.New("check error")
+
+	serviceChecker := &testChecker{damaged: []scrubber.DamagedItem{
+		{Type: scrubber.ServiceItem, ID: "service1", AosVersion: 1, Err: errors.New("rootfs checksum mismatch")},
+	}}
+	layerChecker := &testChecker{
+		damaged: []scrubber.DamagedItem{
+			{Type: scrubber.LayerItem, ID: "layer1", AosVersion: 2, Err: errors.New("content digest mismatch")},
+		},
+		err: checkErr,
+	}
+
+	scrubber := scrubber.New(&config.Config{}, alertSender, serviceChecker, layerChecker)
+	defer scrubber.Close()
+
+	if err := scrubber.Scrub(context.Background()); !errors.Is(err, checkErr) {
+		t.Errorf("Unexpected scrub error: %v", err)
+	}
+
+	if serviceChecker.checkCount != 1 || layerChecker.checkCount != 1 {
+		t.Errorf("Wrong check count: %d, %d", serviceChecker.checkCount, layerChecker.checkCount)
+	}
+
+	if len(alertSender.getAlerts()) != 2 {
+		t.Errorf("Wrong alerts count: %d", len(alertSender.getAlerts()))
+	}
+}
+
+func TestReadRateLimit(t *testing.T) {
+	const (
+		fileSize    = 16 * 1024
+		maxReadRate = 128 * 1024
+	)
+
+	fileName := filepath.Join(tmpDir, "file")
+
+	if err := os.WriteFile(fileName, make([]byte, fileSize), 0o600); err != nil {
+		t.Fatalf("Can't write file: %v", err)
+	}
+
+	checker := &testChecker{files: []string{fileName, fileName}}
+
+	scrubber := scrubber.New(&config.Config{Scrubber: config.Scrubber{MaxReadRate: maxReadRate}}, nil, checker)
+	defer scrubber.Close()
+
+	startTime := time.Now()
+
+	if err := scrubber.Scrub(context.Background()); err != nil {
+		t.Fatalf("Scrub error: %v", err)
+	}
+
+	if checker.readSize != 2*fileSize {
+		t.Errorf("Wrong read size: %d", checker.readSize)
+	}
+
+	if time.Since(startTime) < 2*fileSize*time.Second/maxReadRate {
+		t.Errorf("Read rate is not limited: %v", time.Since(startTime))
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	cancelFunc()
+
+	if err := scrubber.Scrub(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Unexpected scrub error: %v", err)
+	}
+}
+
+func TestPeriodicScrub(t *testing.T) {
+	alertSender := &testAlertSender{}
+	checker := &testChecker{damaged: []scrubber.DamagedItem{
+		{Type: scrubber.ServiceItem, ID: "service1", AosVersion: 1, Err: errors.New("rootfs checksum mismatch")},
+	}}
+
+	scrubber := scrubber.New(&config.Config{
+		Scrubber: config.Scrubber{Period: aostypes.Duration{Duration: 100 * time.Millisecond}},
+	}, alertSender, checker)
+	defer scrubber.Close()
+
+	// Checker doesn't mark damaged items, so alert is sent on each scrubbing
+	for timeout := time.After(time.Second); len(alertSender.getAlerts()) < 2; {
+		select {
+		case <-timeout:
+			t.Fatalf("Wrong alerts count: %d", len(alertSender.getAlerts()))
+
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+/***********************************************************************************************************************
+ * Interfaces
+ **********************************************************************************************************************/
+
+func (sender *testAlertSender) SendAlert(alertItem cloudprotocol.AlertItem) {
+	sender.Lock()
+	defer sender.Unlock()
+
+	if alert, ok := alertItem.Payload.(cloudprotocol.CoreAlert); ok {
+		sender.alerts = append(sender.alerts, alert)
+	}
+}
+
+func (checker *testChecker) CheckIntegrity(
+	ctx context.Context, open scrubber.OpenFunc,
+) (damaged []scrubber.DamagedItem, err error) {
+	checker.checkCount++
+
+	for _, fileName := range checker.files {
+		file, err := open(fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := io.Copy(io.Discard, file)
+
+		file.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		checker.readSize += size
+	}
+
+	return checker.damaged, checker.err
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (sender *testAlertSender) getAlerts() []cloudprotocol.CoreAlert {
+	sender.Lock()
+	defer sender.Unlock()
+
+	return append([]cloudprotocol.CoreAlert(nil), sender.alerts...)
+}
@@ -39,6 +39,7 @@ import (
 	"github.com/aosedge/aos_servicemanager/alerts"
 	"github.com/aosedge/aos_servicemanager/config"
 	"github.com/aosedge/aos_servicemanager/database"
+	"github.com/aosedge/aos_servicemanager/downloader"
 	"github.com/aosedge/aos_servicemanager/iamclient"
 	"github.com/aosedge/aos_servicemanager/launcher"
 	"github.com/aosedge/aos_servicemanager/layermanager"
@@ -47,7 +48,9 @@ import (
 	"github.com/aosedge/aos_servicemanager/networkmanager"
 	resource "github.com/aosedge/aos_servicemanager/resourcemanager"
 	"github.com/aosedge/aos_servicemanager/runner"
+	"github.com/aosedge/aos_servicemanager/scrubber"
 	"github.com/aosedge/aos_servicemanager/servicemanager"
+	"github.com/aosedge/aos_servicemanager/signature"
 	"github.com/aosedge/aos_servicemanager/smclient"
 )
 
@@ -67,6 +70,8 @@ type serviceManager struct {
 	alerts            *alerts.Alerts
 	cfg               *config.Config
 	db                *database.Database
+	downloader        *downloader.Downloader
+	signatureVerifier *signature.Verifier
 	launcher          *launcher.Launcher
 	resourcemanager   *resource.ResourceManager
 	logging           *logging.Logging
@@ -77,7 +82,13 @@ type serviceManager struct {
 	client            *smclient.SMClient
 	layerMgr          *layermanager.LayerManager
 	serviceMgr        *servicemanager.ServiceManager
-	runner            *runner.Runner
+	scrubber          *scrubber.Scrubber
+	runners           []instanceRunner
+}
+
+type instanceRunner interface {
+	launcher.InstanceRunner
+	Close()
 }
 
 type journalHook struct {
@@ -176,23 +187,33 @@ func newServiceManager(cfg *config.Config) (sm *serviceManager, err error) {
 		}
 	}
 
-	if sm.layerMgr, err = layermanager.New(cfg, sm.db); err != nil {
+	if sm.alerts, err = alerts.New(); err != nil {
 		return sm, aoserrors.Wrap(err)
 	}
 
-	if sm.serviceMgr, err = servicemanager.New(cfg, sm.db); err != nil {
+	if sm.downloader, err = downloader.New(cfg, sm.db); err != nil {
 		return sm, aoserrors.Wrap(err)
 	}
 
-	if sm.cryptoContext, err = cryptutils.NewCryptoContext(cfg.CACert); err != nil {
+	if sm.signatureVerifier, err = signature.New(cfg, sm.alerts); err != nil {
 		return sm, aoserrors.Wrap(err)
 	}
 
-	if sm.iam, err = iamclient.New(cfg, sm.cryptoContext, false); err != nil {
+	if sm.layerMgr, err = layermanager.New(cfg, sm.db, sm.downloader, sm.signatureVerifier); err != nil {
 		return sm, aoserrors.Wrap(err)
 	}
 
-	if sm.alerts, err = alerts.New(); err != nil {
+	if sm.serviceMgr, err = servicemanager.New(cfg, sm.db, sm.downloader, sm.signatureVerifier); err != nil {
+		return sm, aoserrors.Wrap(err)
+	}
+
+	sm.scrubber = scrubber.New(cfg, sm.alerts, sm.serviceMgr, sm.layerMgr)
+
+	if sm.cryptoContext, err = cryptutils.NewCryptoContext(cfg.CACert); err != nil {
+		return sm, aoserrors.Wrap(err)
+	}
+
+	if sm.iam, err = iamclient.New(cfg, sm.cryptoContext, false); err != nil {
 		return sm, aoserrors.Wrap(err)
 	}
 
@@ -208,7 +229,12 @@ func newServiceManager(cfg *config.Config) (sm *serviceManager, err error) {
 		return sm, aoserrors.Wrap(err)
 	}
 
-	if !slices.Contains(cfg.RunnerFeatures, "runx") {
+	// Node network is not used if all services are run by runx
+	runxOnly := len(cfg.RunnerFeatures) != 0 && !slices.ContainsFunc(cfg.RunnerFeatures, func(feature string) bool {
+		return feature != runner.RunxRuntime
+	})
+
+	if !runxOnly {
 		if sm.monitor, err = resourcemonitor.New(
 			sm.iam.GetNodeID(), cfg.Monitoring, sm.alerts, sm.monitorController, sm.network); err != nil {
 			return sm, aoserrors.Wrap(err)
@@ -224,11 +250,12 @@ func newServiceManager(cfg *config.Config) (sm *serviceManager, err error) {
 		return sm, aoserrors.Wrap(err)
 	}
 
-	if sm.runner, err = runner.New(); err != nil {
+	instanceRunners, err := sm.createInstanceRunners(cfg)
+	if err != nil {
 		return sm, aoserrors.Wrap(err)
 	}
 
-	if sm.launcher, err = launcher.New(cfg, sm.db, sm.serviceMgr, sm.layerMgr, sm.runner, sm.resourcemanager,
+	if sm.launcher, err = launcher.New(cfg, sm.db, sm.serviceMgr, sm.layerMgr, instanceRunners, sm.resourcemanager,
 		sm.network, sm.iam, sm.monitor, sm.alerts); err != nil {
 		return sm, aoserrors.Wrap(err)
 	}
@@ -249,7 +276,58 @@ func newServiceManager(cfg *config.Config) (sm *serviceManager, err error) {
 	return sm, nil
 }
 
+// createInstanceRunners creates instance runner for each runner feature. Runner backend is selected per runner
+// feature by config: instances of features without configured backend are run by systemd. Systemd backend supports
+// only runc runtime as it is used by aos-service@.service unit, other runtimes should be configured with OCI backend.
+func (sm *serviceManager) createInstanceRunners(cfg *config.Config) (map[string]launcher.InstanceRunner, error) {
+	runnerFeatures := cfg.RunnerFeatures
+	if len(runnerFeatures) == 0 {
+		runnerFeatures = []string{runner.RuncRuntime}
+	}
+
+	instanceRunners := make(map[string]launcher.InstanceRunner)
+
+	for _, feature := range runnerFeatures {
+		if _, ok := instanceRunners[feature]; ok {
+			continue
+		}
+
+		switch backend := cfg.RunnerBackends[feature]; backend {
+		case "", runner.BackendSystemd:
+			systemdRunner, err := runner.New(feature)
+			if err != nil {
+				return nil, aoserrors.Wrap(err)
+			}
+
+			sm.runners = append(sm.runners, systemdRunner)
+			instanceRunners[feature] = systemdRunner
+
+			log.WithField("runner", feature).Info("Run instances by systemd")
+
+		case runner.BackendOCI:
+			ociRunner, err := runner.NewOCIRunner(feature)
+			if err != nil {
+				return nil, aoserrors.Wrap(err)
+			}
+
+			sm.runners = append(sm.runners, ociRunner)
+			instanceRunners[feature] = ociRunner
+
+			log.WithField("runner", feature).Info("Run instances by OCI runtime")
+
+		default:
+			return nil, aoserrors.Errorf("unsupported runner backend %s for runner %s", backend, feature)
+		}
+	}
+
+	return instanceRunners, nil
+}
+
 func (sm *serviceManager) close() {
+	if sm.scrubber != nil {
+		sm.scrubber.Close()
+	}
+
 	if sm.serviceMgr != nil {
 		sm.serviceMgr.Close()
 	}
@@ -266,8 +344,8 @@ func (sm *serviceManager) close() {
 		sm.launcher.Close()
 	}
 
-	if sm.runner != nil {
-		sm.runner.Close()
+	for _, instanceRunner := range sm.runners {
+		instanceRunner.Close()
 	}
 
 	if sm.monitor != nil {
@@ -348,6 +426,12 @@ func main() {
 	showVersion := flag.Bool("version", false, `Show service manager version`)
 	useJournal := flag.Bool("j", false, "output logs to systemd journal")
 
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] [command [-json]]\n\nOptions:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), cliUsage())
+	}
+
 	flag.Parse()
 
 	// Show version
@@ -372,6 +456,15 @@ func main() {
 
 	log.SetLevel(logLevel)
 
+	// Run read only command
+	if flag.NArg() > 0 {
+		if err = runCLICommand(*configFile, flag.Args(), os.Stdout); err != nil {
+			log.Fatalf("Can't run command: %s", err)
+		}
+
+		return
+	}
+
 	log.WithFields(log.Fields{"configFile": *configFile, "version": GitSummary}).Info("Start service manager")
 
 	cfg, err := config.New(*configFile)
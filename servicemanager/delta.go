@@ -0,0 +1,319 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicemanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/aostypes"
+	"github.com/aosedge/aos_common/image"
+	"github.com/aosedge/aos_common/spaceallocator"
+	"github.com/aosedge/aos_common/utils/fs"
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/scrubber"
+	"github.com/aosedge/aos_servicemanager/utils/fscopy"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const deltaFileName = "delta.json"
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// deltaInfo describes delta package. Besides this file, delta package contains new image manifest, blobs which differ
+// from base version and rootfs diff archive. New rootfs is rebuilt from base version rootfs: removed paths are deleted
+// and diff archive is unpacked over it.
+type deltaInfo struct {
+	BaseManifestDigest digest.Digest `json:"baseManifestDigest"`
+	RootFSDiff         digest.Digest `json:"rootfsDiff"`
+	Removed            []string      `json:"removed,omitempty"`
+	FullImage          fullImageInfo `json:"fullImage"`
+
+	baseImagePath string
+}
+
+// fullImageInfo full image package used when base version is not installed.
+type fullImageInfo struct {
+	URL    string `json:"url"`
+	Sha256 []byte `json:"sha256"`
+	Sha512 []byte `json:"sha512"`
+	Size   uint64 `json:"size"`
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// extractServicePackage extracts service package. If it is delta package and its base version is not installed,
+// full image package is extracted instead.
+func (sm *ServiceManager) extractServicePackage(ctx context.Context, serviceInfo aostypes.ServiceInfo) (
+	imagePath string, size uint64, space spaceallocator.Space, delta *deltaInfo, err error,
+) {
+	if imagePath, size, space, err = sm.extractPackageByURL(ctx, &serviceInfo); err != nil {
+		return "", 0, nil, nil, aoserrors.Wrap(err)
+	}
+
+	if delta, err = getDeltaInfo(imagePath); err != nil || delta == nil {
+		if err != nil {
+			releaseAllocatedSpace(imagePath, nil, space)
+
+			return "", 0, nil, nil, aoserrors.Wrap(err)
+		}
+
+		return imagePath, size, space, nil, nil
+	}
+
+	if delta.baseImagePath, err = sm.getDeltaBase(serviceInfo.ID, delta.BaseManifestDigest); err == nil {
+		return imagePath, size, space, delta, nil
+	}
+
+	releaseAllocatedSpace(imagePath, nil, space)
+
+	if !errors.Is(err, ErrNotExist) {
+		return "", 0, nil, nil, aoserrors.Wrap(err)
+	}
+
+	log.WithFields(log.Fields{
+		"id":         serviceInfo.ID,
+		"aosVersion": serviceInfo.AosVersion,
+		"baseDigest": delta.BaseManifestDigest,
+	}).Warn("Delta base version is not installed, install full image")
+
+	serviceInfo.URL = delta.FullImage.URL
+	serviceInfo.Sha256 = delta.FullImage.Sha256
+	serviceInfo.Sha512 = delta.FullImage.Sha512
+	serviceInfo.Size = delta.FullImage.Size
+
+	if imagePath, size, space, err = sm.extractPackageByURL(ctx, &serviceInfo); err != nil {
+		return "", 0, nil, nil, aoserrors.Wrap(err)
+	}
+
+	return imagePath, size, space, nil, nil
+}
+
+func (sm *ServiceManager) getDeltaBase(serviceID string, baseDigest digest.Digest) (imagePath string, err error) {
+	services, err := sm.serviceInfoProvider.GetAllServiceVersions(serviceID)
+	if err != nil && !errors.Is(err, ErrNotExist) {
+		return "", aoserrors.Wrap(err)
+	}
+
+	for _, service := range services {
+		if digest.NewDigestFromBytes(digest.SHA256, service.OriginalManifestDigest) != baseDigest {
+			continue
+		}
+
+		imageParts, err := getImageParts(service.ImagePath)
+		if err != nil {
+			log.WithField("imagePath", service.ImagePath).Errorf("Can't get delta base image parts: %v", err)
+
+			break
+		}
+
+		if fi, err := os.Stat(imageParts.ServiceFSPath); err != nil || !fi.IsDir() {
+			log.WithField("imagePath", service.ImagePath).Error("Delta base rootfs is damaged")
+
+			break
+		}
+
+		return service.ImagePath, nil
+	}
+
+	return "", ErrNotExist
+}
+
+// prepareDeltaServiceFS rebuilds service rootfs from base version rootfs and rootfs diff. Rebuilt rootfs is placed as
+// manifest rootfs layer and should be validated by validateUnpackedImage.
+func (sm *ServiceManager) prepareDeltaServiceFS(imagePath string, delta *deltaInfo, gid int) (
+	serviceSize int64, archiveSize uint64, space spaceallocator.Space, rootFSDigest digest.Digest, err error,
+) {
+	if err = copyDeltaBaseBlobs(imagePath, delta.baseImagePath); err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
+	}
+
+	if err = validateDigest(imagePath, delta.RootFSDiff, scrubber.OpenFile); err != nil {
+		return 0, 0, nil, "", aoserrors.Errorf("invalid rootfs diff: %v", err)
+	}
+
+	diffPath := filepath.Join(imagePath, blobsFolder, string(delta.RootFSDiff.Algorithm()), delta.RootFSDiff.Hex())
+
+	baseParts, err := getImageParts(delta.baseImagePath)
+	if err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
+	}
+
+	baseSize, err := fs.GetDirSize(baseParts.ServiceFSPath)
+	if err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
+	}
+
+	diffSize, err := image.GetUncompressedTarContentSize(diffPath)
+	if err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
+	}
+
+	serviceSize = baseSize + diffSize
+
+	serviceSpace, err := sm.serviceAllocator.AllocateSpace(uint64(serviceSize))
+	if err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
+	}
+
+	defer func() {
+		if err != nil {
+			if releaseErr := serviceSpace.Release(); releaseErr != nil {
+				log.Errorf("Can't release memory: %v", releaseErr)
+			}
+		}
+	}()
+
+	tmpRootFS := filepath.Join(imagePath, tmpRootFSDir)
+
+	if err = fscopy.CopyDir(baseParts.ServiceFSPath, tmpRootFS); err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
+	}
+
+	for _, removed := range delta.Removed {
+		if err = os.RemoveAll(filepath.Join(tmpRootFS, filepath.Clean("/"+removed))); err != nil {
+			return 0, 0, nil, "", aoserrors.Wrap(err)
+		}
+	}
+
+	if err = image.UnpackTarImage(diffPath, tmpRootFS); err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
+	}
+
+	if archiveSize, err = getFileSize(diffPath); err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
+	}
+
+	for _, fileName := range []string{diffPath, filepath.Join(imagePath, deltaFileName)} {
+		if removeErr := os.RemoveAll(fileName); removeErr != nil {
+			log.Errorf("Can't remove temp file: %s", removeErr)
+		}
+	}
+
+	if err = prepareRootFS(tmpRootFS, gid); err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
+	}
+
+	manifest, err := getImageManifest(imagePath)
+	if err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
+	}
+
+	if len(manifest.Layers) == 0 {
+		return 0, 0, nil, "", aoserrors.New("no layers in image")
+	}
+
+	rootFSDigest = manifest.Layers[0].Digest
+
+	if err = rootFSDigest.Validate(); err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
+	}
+
+	rootFSPath := filepath.Join(imagePath, blobsFolder, string(rootFSDigest.Algorithm()), rootFSDigest.Hex())
+
+	if err = os.MkdirAll(filepath.Dir(rootFSPath), 0o755); err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
+	}
+
+	if err = os.Rename(tmpRootFS, rootFSPath); err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
+	}
+
+	return serviceSize, archiveSize, serviceSpace, rootFSDigest, nil
+}
+
+func getDeltaInfo(imagePath string) (delta *deltaInfo, err error) {
+	deltaJSON, err := os.ReadFile(filepath.Join(imagePath, deltaFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, aoserrors.Wrap(err)
+	}
+
+	delta = new(deltaInfo)
+
+	decoder := json.NewDecoder(bytes.NewReader(deltaJSON))
+	decoder.DisallowUnknownFields()
+
+	if err = decoder.Decode(delta); err != nil {
+		return nil, aoserrors.Errorf("invalid delta info: %v", err)
+	}
+
+	if err = delta.BaseManifestDigest.Validate(); err != nil {
+		return nil, aoserrors.Errorf("invalid delta base digest: %v", err)
+	}
+
+	return delta, nil
+}
+
+// copyDeltaBaseBlobs copies image config and service config blobs which are not included into delta package.
+func copyDeltaBaseBlobs(imagePath, baseImagePath string) error {
+	manifest, err := getImageManifest(imagePath)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	blobs := []digest.Digest{manifest.Config.Digest}
+
+	if manifest.AosService != nil {
+		blobs = append(blobs, manifest.AosService.Digest)
+	}
+
+	for _, blob := range blobs {
+		if err = blob.Validate(); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		blobPath := filepath.Join(blobsFolder, string(blob.Algorithm()), blob.Hex())
+
+		if _, err = os.Stat(filepath.Join(imagePath, blobPath)); err == nil {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(baseImagePath, blobPath))
+		if err != nil {
+			return aoserrors.Errorf("blob %s is not found in delta and base image", blob)
+		}
+
+		if err = os.MkdirAll(filepath.Dir(filepath.Join(imagePath, blobPath)), 0o755); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if err = os.WriteFile(filepath.Join(imagePath, blobPath), data, 0o600); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicemanager
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/eviction"
+)
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// UseService sets service last use time. It should be called when service instance is started.
+func (sm *ServiceManager) UseService(serviceID string, aosVersion uint64) error {
+	return sm.evictionPolicy.SetUsed(fmt.Sprintf("%s_%d", serviceID, aosVersion))
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// addOutdatedService adds cached service to allocator outdated items. Allocator removes outdated items in timestamp
+// order, so service eviction time is used as item timestamp. Protected services are never added.
+func (sm *ServiceManager) addOutdatedService(service ServiceInfo) error {
+	if sm.isProtected(service) {
+		log.WithFields(log.Fields{
+			"serviceID": service.ServiceID, "aosVersion": service.AosVersion,
+		}).Debug("Cached service is protected from eviction")
+
+		return nil
+	}
+
+	id := fmt.Sprintf("%s_%d", service.ServiceID, service.AosVersion)
+
+	item := evictionItem(service)
+
+	lastUsed, err := sm.evictionPolicy.GetLastUsed(id)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	item.LastUsed = lastUsed
+
+	if err := sm.serviceAllocator.AddOutdatedItem(
+		id, service.Size, sm.evictionPolicy.EvictionTime(item)); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+// ensureMinFreeSpace evicts cached services until configured min free space is available.
+func (sm *ServiceManager) ensureMinFreeSpace() error {
+	services, err := sm.serviceInfoProvider.GetServices()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	var items []eviction.Item
+
+	cachedServices := make(map[string]ServiceInfo)
+
+	for _, service := range services {
+		if !service.Cached || sm.isProtected(service) {
+			continue
+		}
+
+		id := fmt.Sprintf("%s_%d", service.ServiceID, service.AosVersion)
+
+		item := evictionItem(service)
+
+		if item.LastUsed, err = sm.evictionPolicy.GetLastUsed(id); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		items = append(items, item)
+		cachedServices[id] = service
+	}
+
+	return aoserrors.Wrap(sm.evictionPolicy.EnsureMinFreeSpace(sm.servicesDir, items, func(item eviction.Item) error {
+		return sm.removeService(cachedServices[fmt.Sprintf("%s_%d", item.ID, item.AosVersion)])
+	}))
+}
+
+// removeOutdatedService removes outdated service on allocator request.
+func (sm *ServiceManager) removeOutdatedService(id string) error {
+	serviceInfo := strings.Split(id, "_")
+	if len(serviceInfo) < 2 { //nolint:gomnd
+		return aoserrors.New("Unexpected service id format")
+	}
+
+	aosVersionStr := serviceInfo[len(serviceInfo)-1]
+	serviceInfo = serviceInfo[:len(serviceInfo)-1]
+	serviceID := strings.Join(serviceInfo, "_")
+
+	services, err := sm.serviceInfoProvider.GetAllServiceVersions(serviceID)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	aosVersion, err := strconv.ParseUint(aosVersionStr, 10, 64)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	for _, service := range services {
+		if service.AosVersion == aosVersion {
+			sm.removeServiceFS(service.ImagePath)
+
+			if err := os.RemoveAll(service.ImagePath); err != nil {
+				return aoserrors.Wrap(err)
+			}
+
+			if err := sm.serviceInfoProvider.RemoveService(service.ServiceID, service.AosVersion); err != nil {
+				return aoserrors.Wrap(err)
+			}
+
+			sm.evictionPolicy.RemoveUsage(id)
+			sm.evictionPolicy.AddEvicted(evictionItem(service), eviction.ReasonNoSpace)
+		}
+	}
+
+	return nil
+}
+
+// isProtected returns true if cached service should never be evicted: it is pinned or it is the last known good
+// service version which is used to roll back failed service update.
+func (sm *ServiceManager) isProtected(service ServiceInfo) bool {
+	if sm.evictionPolicy.IsPinned(service.ServiceID, service.AosVersion) {
+		return true
+	}
+
+	knownGoodVersion, err := sm.serviceInfoProvider.GetKnownGoodServiceVersion(service.ServiceID)
+	if err != nil {
+		// Keep service if it is unknown whether it is needed for rollback
+		log.WithField("serviceID", service.ServiceID).Errorf("Can't get known good service version: %v", err)
+
+		return true
+	}
+
+	return knownGoodVersion == service.AosVersion
+}
+
+func evictionItem(service ServiceInfo) eviction.Item {
+	return eviction.Item{
+		ID: service.ServiceID, AosVersion: service.AosVersion, Size: service.Size, Timestamp: service.Timestamp,
+	}
+}
@@ -32,6 +32,8 @@ import (
 	"github.com/opencontainers/go-digest"
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	"golang.org/x/mod/sumdb/dirhash"
+
+	"github.com/aosedge/aos_servicemanager/scrubber"
 )
 
 /***********************************************************************************************************************
@@ -65,20 +67,20 @@ type serviceManifest struct {
  * Private
  **********************************************************************************************************************/
 
-func validateUnpackedImage(installDir string) (err error) {
+func validateUnpackedImage(installDir string, open scrubber.OpenFunc) (err error) {
 	manifest, err := getImageManifest(installDir)
 	if err != nil {
 		return aoserrors.Wrap(err)
 	}
 
 	// validate image config
-	if err = validateDigest(installDir, manifest.Config.Digest); err != nil {
+	if err = validateDigest(installDir, manifest.Config.Digest, open); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
 	// validate aos service config
 	if manifest.AosService != nil {
-		if err = validateDigest(installDir, manifest.AosService.Digest); err != nil {
+		if err = validateDigest(installDir, manifest.AosService.Digest, open); err != nil {
 			return aoserrors.Wrap(err)
 		}
 
@@ -110,10 +112,13 @@ func validateUnpackedImage(installDir string) (err error) {
 	}
 
 	if !fi.Mode().IsDir() {
-		return aoserrors.Wrap(validateDigest(installDir, manifest.Layers[0].Digest))
+		return aoserrors.Wrap(validateDigest(installDir, manifest.Layers[0].Digest, open))
 	}
 
-	rootfsHash, err := dirhash.HashDir(rootfsPath, rootfsPath, dirDigest)
+	rootfsHash, err := dirhash.HashDir(rootfsPath, rootfsPath,
+		func(files []string, _ func(string) (io.ReadCloser, error)) (string, error) {
+			return dirDigest(files, open)
+		})
 	if err != nil {
 		return aoserrors.Wrap(err)
 	}
@@ -153,12 +158,12 @@ func saveImageManifest(manifest *serviceManifest, installDir string) (err error)
 	return nil
 }
 
-func validateDigest(installDir string, digest digest.Digest) (err error) {
+func validateDigest(installDir string, digest digest.Digest, open scrubber.OpenFunc) (err error) {
 	if err = digest.Validate(); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
-	file, err := os.Open(path.Join(installDir, blobsFolder, string(digest.Algorithm()), digest.Hex()))
+	file, err := open(path.Join(installDir, blobsFolder, string(digest.Algorithm()), digest.Hex()))
 	if err != nil {
 		return aoserrors.Wrap(err)
 	}
@@ -20,27 +20,36 @@ package servicemanager
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
-	"strconv"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/aosedge/aos_common/aoserrors"
 	"github.com/aosedge/aos_common/aostypes"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
 	"github.com/aosedge/aos_common/image"
 	"github.com/aosedge/aos_common/spaceallocator"
+	"github.com/aosedge/aos_common/utils/action"
 	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 	"golang.org/x/mod/sumdb/dirhash"
 
 	"github.com/aosedge/aos_servicemanager/config"
+	"github.com/aosedge/aos_servicemanager/eviction"
+	"github.com/aosedge/aos_servicemanager/fsimage"
+	"github.com/aosedge/aos_servicemanager/registry"
+	"github.com/aosedge/aos_servicemanager/scrubber"
+	"github.com/aosedge/aos_servicemanager/signature"
 	"github.com/aosedge/aos_servicemanager/utils/whiteouts"
 )
 
@@ -48,7 +57,10 @@ import (
  * Consts
  **********************************************************************************************************************/
 
-const tmpRootFSDir = "tmprootfs"
+const (
+	tmpRootFSDir        = "tmprootfs"
+	maxParallelInstalls = 4
+)
 
 /***********************************************************************************************************************
  * Types
@@ -61,6 +73,25 @@ type ServiceStorage interface {
 	AddService(info ServiceInfo) error
 	RemoveService(serviceID string, aosVersion uint64) error
 	SetServiceCached(serviceID string, aosVersion uint64, cached bool) error
+	SetServiceDamaged(serviceID string, aosVersion uint64, damaged bool) error
+	GetKnownGoodServiceVersion(serviceID string) (uint64, error)
+	fsimage.Storage
+	eviction.UsageStorage
+}
+
+// Downloader downloads service packages.
+type Downloader interface {
+	Download(ctx context.Context, url string, fileInfo image.FileInfo) (fileName string, err error)
+	DownloadWithHeader(
+		ctx context.Context, url string, header http.Header, fileInfo image.FileInfo) (fileName string, err error)
+	Release(url string) error
+	Unpack(ctx context.Context, url string, fileInfo image.FileInfo, destination string,
+		allocator spaceallocator.Allocator) (size uint64, space spaceallocator.Space, err error)
+}
+
+// SignatureVerifier verifies service image signatures.
+type SignatureVerifier interface {
+	Verify(fileName string, image signature.Image) error
 }
 
 // ServiceManager instance.
@@ -69,23 +100,34 @@ type ServiceManager struct {
 	servicesDir            string
 	downloadDir            string
 	serviceTTLDays         uint64
+	streamingUnpack        bool
+	reinstallDamaged       bool
 	serviceInfoProvider    ServiceStorage
+	downloader             Downloader
+	signatureVerifier      SignatureVerifier
+	registry               *registry.Client
 	serviceAllocator       spaceallocator.Allocator
 	downloadAllocator      spaceallocator.Allocator
+	fsStore                *fsimage.Store
+	evictionPolicy         *eviction.Policy
 	validateTTLStopChannel chan struct{}
+	installCtx             context.Context //nolint:containedctx // cancels installs on close
+	cancelFunction         context.CancelFunc
 }
 
 // ServiceInfo service information.
 type ServiceInfo struct {
 	aostypes.VersionInfo
-	ServiceID       string
-	ServiceProvider string
-	ImagePath       string
-	ManifestDigest  []byte
-	Timestamp       time.Time
-	Cached          bool
-	Size            uint64
-	GID             uint32
+	ServiceID              string
+	ServiceProvider        string
+	ImagePath              string
+	ManifestDigest         []byte
+	OriginalManifestDigest []byte
+	Timestamp              time.Time
+	Cached                 bool
+	Size                   uint64
+	GID                    uint32
+	Damaged                bool
 }
 
 /***********************************************************************************************************************
@@ -115,21 +157,34 @@ var RemoveCachedServicesPeriod = 24 * time.Hour
 
 // New creates new service manager object.
 func New(
-	config *config.Config, serviceInfoProvider ServiceStorage,
+	config *config.Config, serviceInfoProvider ServiceStorage, downloader Downloader,
+	signatureVerifier SignatureVerifier,
 ) (sm *ServiceManager, err error) {
 	sm = &ServiceManager{
 		servicesDir:            config.ServicesDir,
 		downloadDir:            config.DownloadDir,
 		serviceTTLDays:         config.ServiceTTLDays,
+		streamingUnpack:        config.Downloader.StreamingUnpack,
+		reinstallDamaged:       config.Scrubber.ReinstallDamaged,
 		serviceInfoProvider:    serviceInfoProvider,
+		downloader:             downloader,
+		signatureVerifier:      signatureVerifier,
+		registry:               registry.New(config, downloader),
 		validateTTLStopChannel: make(chan struct{}),
 	}
 
-	if err = os.MkdirAll(sm.servicesDir, 0o755); err != nil {
+	sm.installCtx, sm.cancelFunction = context.WithCancel(context.Background())
+
+	if sm.fsStore, err = fsimage.New(config, serviceInfoProvider); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if sm.evictionPolicy, err = eviction.New(
+		eviction.ServiceItem, config.ServiceEviction, serviceInfoProvider); err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
 
-	if err := os.RemoveAll(sm.downloadDir); err != nil {
+	if err = os.MkdirAll(sm.servicesDir, 0o755); err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
 
@@ -156,9 +211,8 @@ func New(
 			continue
 		}
 
-		if err := sm.serviceAllocator.AddOutdatedItem(
-			fmt.Sprintf("%s_%d", service.ServiceID, service.AosVersion), service.Size, service.Timestamp); err != nil {
-			return nil, aoserrors.Wrap(err)
+		if err := sm.addOutdatedService(service); err != nil {
+			return nil, err
 		}
 	}
 
@@ -166,10 +220,18 @@ func New(
 		log.Errorf("Can't remove damaged service folders: %v", err)
 	}
 
+	if err := sm.mountServicesFS(); err != nil {
+		log.Errorf("Can't mount services images: %v", err)
+	}
+
 	if err := sm.removeOutdatedServices(services); err != nil {
 		log.Errorf("Can't remove outdated services: %v", err)
 	}
 
+	if err := sm.ensureMinFreeSpace(); err != nil {
+		log.Errorf("Can't ensure min free space: %v", err)
+	}
+
 	go sm.validateTTLs()
 
 	return sm, nil
@@ -177,6 +239,8 @@ func New(
 
 // Close closes service manager instance.
 func (sm *ServiceManager) Close() {
+	sm.cancelFunction()
+
 	if err := sm.serviceAllocator.Close(); err != nil {
 		log.Errorf("Can't close service allocator: %v", err)
 	}
@@ -204,47 +268,161 @@ func (sm *ServiceManager) GetServiceInfo(serviceID string) (serviceInfo ServiceI
 	return serviceInfo, ErrNotExist
 }
 
+// GetServiceVersionInfo gets information of specified service version including cached one.
+func (sm *ServiceManager) GetServiceVersionInfo(
+	serviceID string, aosVersion uint64,
+) (serviceInfo ServiceInfo, err error) {
+	services, err := sm.serviceInfoProvider.GetAllServiceVersions(serviceID)
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	for _, service := range services {
+		if service.ServiceID == serviceID && service.AosVersion == aosVersion {
+			return service, nil
+		}
+	}
+
+	return serviceInfo, ErrNotExist
+}
+
 // GetImageParts gets image parts for the service.
 func (sm *ServiceManager) GetImageParts(service ServiceInfo) (parts ImageParts, err error) {
 	return getImageParts(service.ImagePath)
 }
 
-// ProcessDesiredServices installs, removes, restores desired services on the system.
-func (sm *ServiceManager) ProcessDesiredServices(desiredServices []aostypes.ServiceInfo) error {
+// ProcessDesiredServices installs, removes, restores desired services on the system. It returns status of each
+// desired service and the first install error.
+func (sm *ServiceManager) ProcessDesiredServices(
+	desiredServices []aostypes.ServiceInfo,
+) (statuses []cloudprotocol.ServiceStatus, err error) {
 	services, err := sm.serviceInfoProvider.GetServices()
 	if err != nil {
-		return aoserrors.Wrap(err)
+		return nil, aoserrors.Wrap(err)
 	}
 
-	if desiredServices, err = sm.updateCachedServices(desiredServices, services); err != nil {
-		return err
+	if sm.reinstallDamaged {
+		if services, err = sm.removeDamagedServices(services); err != nil {
+			return nil, err
+		}
 	}
 
-	if err = sm.installServices(desiredServices); err != nil {
-		return err
+	servicesToInstall, err := sm.updateCachedServices(slices.Clone(desiredServices), services)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	installStatuses, err := sm.installServices(sm.installCtx, servicesToInstall)
+
+	if minFreeSpaceErr := sm.ensureMinFreeSpace(); minFreeSpaceErr != nil {
+		log.Errorf("Can't ensure min free space: %v", minFreeSpaceErr)
+	}
+
+	statuses = make([]cloudprotocol.ServiceStatus, 0, len(desiredServices))
+
+	for _, desiredService := range desiredServices {
+		status := cloudprotocol.ServiceStatus{
+			ID: desiredService.ID, AosVersion: desiredService.AosVersion, Status: cloudprotocol.InstalledStatus,
+		}
+
+		for _, installStatus := range installStatuses {
+			if installStatus.ID == status.ID && installStatus.AosVersion == status.AosVersion {
+				status = installStatus
+
+				break
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, err
 }
 
 // ValidateService validate service.
 func (sm *ServiceManager) ValidateService(service ServiceInfo) error {
-	manifestCheckSum, err := getManifestChecksum(service.ImagePath)
+	return validateService(service, scrubber.OpenFile)
+}
+
+// CheckIntegrity validates installed services and marks damaged ones.
+func (sm *ServiceManager) CheckIntegrity(
+	ctx context.Context, open scrubber.OpenFunc,
+) (damaged []scrubber.DamagedItem, err error) {
+	services, err := sm.serviceInfoProvider.GetServices()
 	if err != nil {
-		return aoserrors.Wrap(err)
+		return nil, aoserrors.Wrap(err)
 	}
 
-	if !bytes.Equal(service.ManifestDigest, manifestCheckSum) {
-		return aoserrors.New("manifest checksum mismatch")
+	for _, service := range services {
+		if service.Damaged {
+			continue
+		}
+
+		validateErr := validateService(service, open)
+		if ctx.Err() != nil {
+			return damaged, aoserrors.Wrap(ctx.Err())
+		}
+
+		if validateErr == nil {
+			continue
+		}
+
+		// Service may be removed while it is validated
+		if err = sm.serviceInfoProvider.SetServiceDamaged(
+			service.ServiceID, service.AosVersion, true); err != nil {
+			if errors.Is(err, ErrNotExist) {
+				continue
+			}
+
+			return damaged, aoserrors.Wrap(err)
+		}
+
+		damaged = append(damaged, scrubber.DamagedItem{
+			Type: scrubber.ServiceItem, ID: service.ServiceID, AosVersion: service.AosVersion, Err: validateErr,
+		})
 	}
 
-	return validateUnpackedImage(service.ImagePath)
+	return damaged, nil
 }
 
 /***********************************************************************************************************************
  * Private
  **********************************************************************************************************************/
 
+// removeDamagedServices removes services marked as damaged to reinstall them with next desired services. Not cached
+// services are used by current instances, they are kept until they become cached.
+func (sm *ServiceManager) removeDamagedServices(services []ServiceInfo) (validServices []ServiceInfo, err error) {
+	for _, service := range services {
+		if !service.Damaged {
+			validServices = append(validServices, service)
+
+			continue
+		}
+
+		if !service.Cached {
+			log.WithFields(log.Fields{
+				"serviceID":  service.ServiceID,
+				"aosVersion": service.AosVersion,
+			}).Warn("Damaged service is in use, skip remove")
+
+			validServices = append(validServices, service)
+
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"serviceID":  service.ServiceID,
+			"aosVersion": service.AosVersion,
+		}).Warn("Remove damaged service")
+
+		if err = sm.removeService(service); err != nil {
+			return nil, err
+		}
+	}
+
+	return validServices, nil
+}
+
 func (sm *ServiceManager) updateCachedServices(
 	desiredServices []aostypes.ServiceInfo, storeServices []ServiceInfo,
 ) (installServices []aostypes.ServiceInfo, err error) {
@@ -274,57 +452,124 @@ nextService:
 	return desiredServices, nil
 }
 
-func (sm *ServiceManager) installServices(desiredServices []aostypes.ServiceInfo) error {
+// installServices installs services in parallel. Failed service doesn't prevent other services from installing.
+// Install status of each service and the first install error are returned.
+func (sm *ServiceManager) installServices(
+	ctx context.Context, desiredServices []aostypes.ServiceInfo,
+) (statuses []cloudprotocol.ServiceStatus, err error) {
+	actionHandler := action.New(maxParallelInstalls)
+	installChannels := make([]<-chan error, 0, len(desiredServices))
+
 	for _, desiredService := range desiredServices {
-		if err := sm.installService(desiredService); err != nil {
-			return err
+		serviceInfo := desiredService
+
+		// Versions of the same service are installed sequentially
+		installChannels = append(installChannels, actionHandler.Execute(serviceInfo.ID, func(string) error {
+			return sm.installService(ctx, serviceInfo)
+		}))
+	}
+
+	statuses = make([]cloudprotocol.ServiceStatus, len(desiredServices))
+
+	for i, installChannel := range installChannels {
+		statuses[i] = cloudprotocol.ServiceStatus{
+			ID: desiredServices[i].ID, AosVersion: desiredServices[i].AosVersion, Status: cloudprotocol.InstalledStatus,
+		}
+
+		if installErr := <-installChannel; installErr != nil {
+			statuses[i].Status = cloudprotocol.ErrorStatus
+			statuses[i].ErrorInfo = &cloudprotocol.ErrorInfo{Message: installErr.Error()}
+
+			if err == nil {
+				err = installErr
+			}
 		}
 	}
 
-	return nil
+	return statuses, err
 }
 
-func (sm *ServiceManager) installService(serviceInfo aostypes.ServiceInfo) error {
+func (sm *ServiceManager) installService(ctx context.Context, serviceInfo aostypes.ServiceInfo) (err error) {
 	log.WithFields(log.Fields{
 		"ID":         serviceInfo.ID,
 		"AosVersion": serviceInfo.AosVersion,
 	}).Debug("Install service")
 
-	var spacePackage, spaceService spaceallocator.Space
-
-	imagePath, size, spacePackage, err := sm.extractPackageByURL(&serviceInfo)
-	if err != nil {
-		return aoserrors.Wrap(err)
-	}
+	var (
+		spacePackage, spaceService spaceallocator.Space
+		imagePath                  string
+		size                       uint64
+		archiveSize                uint64
+		delta                      *deltaInfo
+	)
 
 	defer func() {
 		if err != nil {
-			releaseAllocatedSpace(imagePath, spaceService, spacePackage)
-
 			log.WithFields(log.Fields{
 				"id":         serviceInfo.ID,
 				"aosVersion": serviceInfo.AosVersion,
 				"imagePath":  imagePath,
 			}).Errorf("Can't install service: %v", err)
+		}
+	}()
+
+	if imagePath, size, spacePackage, delta, err = sm.extractServicePackage(ctx, serviceInfo); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	defer func() {
+		if err != nil {
+			releaseAllocatedSpace(imagePath, spaceService, spacePackage)
 
 			return
 		}
 
+		sm.serviceAllocator.FreeSpace(archiveSize)
 		acceptAllocatedSpace(spaceService, spacePackage)
 	}()
 
-	if err = validateUnpackedImage(imagePath); err != nil {
+	// Image manifest is signed, other image parts are validated by manifest digests
+	if err = sm.signatureVerifier.Verify(filepath.Join(imagePath, manifestFileName), signature.Image{
+		Type: signature.ServiceImage, ID: serviceInfo.ID, AosVersion: serviceInfo.AosVersion,
+	}); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
-	serviceSize, space, rootFSDigest, err := sm.prepareServiceFS(imagePath, int(serviceInfo.GID))
-	if err != nil {
-		return aoserrors.Wrap(err)
+	var (
+		serviceSize  int64
+		rootFSDigest digest.Digest
+	)
+
+	if delta != nil {
+		// Delta rootfs is rebuilt first, then it is validated together with other image parts
+		serviceSize, archiveSize, spaceService, rootFSDigest, err = sm.prepareDeltaServiceFS(
+			imagePath, delta, int(serviceInfo.GID))
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if err = validateUnpackedImage(imagePath, scrubber.OpenFile); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	} else {
+		if err = validateUnpackedImage(imagePath, scrubber.OpenFile); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if serviceSize, archiveSize, spaceService, rootFSDigest, err = sm.prepareServiceFS(
+			imagePath, int(serviceInfo.GID)); err != nil {
+			return aoserrors.Wrap(err)
+		}
 	}
 
-	spaceService = space
 	size += uint64(serviceSize)
 
+	// Delta packages reference base service by the original manifest digest, so it is kept before the manifest update
+	originalManifestDigest, err := getManifestChecksum(imagePath)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
 	if err = updateRootFSDigestInManifest(imagePath, rootFSDigest); err != nil {
 		return aoserrors.Wrap(err)
 	}
@@ -334,15 +579,32 @@ func (sm *ServiceManager) installService(serviceInfo aostypes.ServiceInfo) error
 		return aoserrors.Wrap(err)
 	}
 
+	imageSize, err := sm.convertServiceFS(imagePath)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	// Converted rootfs occupies image size instead of unpacked size
+	if imageSize != 0 {
+		size = size - uint64(serviceSize) + imageSize
+	}
+
+	defer func() {
+		if err != nil {
+			sm.removeServiceFS(imagePath)
+		}
+	}()
+
 	if err = sm.serviceInfoProvider.AddService(ServiceInfo{
-		VersionInfo:     serviceInfo.VersionInfo,
-		ServiceID:       serviceInfo.ID,
-		ServiceProvider: serviceInfo.ProviderID,
-		ImagePath:       imagePath,
-		Size:            size,
-		ManifestDigest:  manifestDigest,
-		Timestamp:       time.Now().UTC(),
-		GID:             serviceInfo.GID,
+		VersionInfo:            serviceInfo.VersionInfo,
+		ServiceID:              serviceInfo.ID,
+		ServiceProvider:        serviceInfo.ProviderID,
+		ImagePath:              imagePath,
+		Size:                   size,
+		ManifestDigest:         manifestDigest,
+		OriginalManifestDigest: originalManifestDigest,
+		Timestamp:              time.Now().UTC(),
+		GID:                    serviceInfo.GID,
 	}); err != nil {
 		return aoserrors.Wrap(err)
 	}
@@ -382,48 +644,14 @@ func (sm *ServiceManager) validateTTLs() {
 
 func (sm *ServiceManager) removeOutdatedServices(services []ServiceInfo) error {
 	for _, service := range services {
-		if service.Cached {
+		if service.Cached && !sm.isProtected(service) {
 			if service.Timestamp.Add(time.Hour * 24 * time.Duration(sm.serviceTTLDays)).Before(time.Now()) {
 				if err := sm.removeService(service); err != nil {
 					return err
 				}
 
 				sm.serviceAllocator.RestoreOutdatedItem(fmt.Sprintf("%s_%d", service.ServiceID, service.AosVersion))
-			}
-		}
-	}
-
-	return nil
-}
-
-func (sm *ServiceManager) removeOutdatedService(id string) error {
-	serviceInfo := strings.Split(id, "_")
-	if len(serviceInfo) < 2 { //nolint:gomnd
-		return aoserrors.New("Unexpected service id format")
-	}
-
-	aosVersionStr := serviceInfo[len(serviceInfo)-1]
-	serviceInfo = serviceInfo[:len(serviceInfo)-1]
-	serviceID := strings.Join(serviceInfo, "_")
-
-	services, err := sm.serviceInfoProvider.GetAllServiceVersions(serviceID)
-	if err != nil {
-		return aoserrors.Wrap(err)
-	}
-
-	aosVersion, err := strconv.ParseUint(aosVersionStr, 10, 64)
-	if err != nil {
-		return aoserrors.Wrap(err)
-	}
-
-	for _, service := range services {
-		if service.AosVersion == aosVersion {
-			if err := os.RemoveAll(service.ImagePath); err != nil {
-				return aoserrors.Wrap(err)
-			}
-
-			if err := sm.serviceInfoProvider.RemoveService(service.ServiceID, service.AosVersion); err != nil {
-				return aoserrors.Wrap(err)
+				sm.evictionPolicy.AddEvicted(evictionItem(service), eviction.ReasonTTL)
 			}
 		}
 	}
@@ -436,23 +664,18 @@ func (sm *ServiceManager) setServiceCached(service ServiceInfo, cached bool) err
 		return aoserrors.Wrap(err)
 	}
 
-	id := fmt.Sprintf("%s_%d", service.ServiceID, service.AosVersion)
-
 	if cached {
-		if err := sm.serviceAllocator.AddOutdatedItem(
-			id, service.Size, service.Timestamp); err != nil {
-			return aoserrors.Wrap(err)
-		}
-
-		return nil
+		return sm.addOutdatedService(service)
 	}
 
-	sm.serviceAllocator.RestoreOutdatedItem(id)
+	sm.serviceAllocator.RestoreOutdatedItem(fmt.Sprintf("%s_%d", service.ServiceID, service.AosVersion))
 
 	return nil
 }
 
 func (sm *ServiceManager) removeService(service ServiceInfo) error {
+	sm.removeServiceFS(service.ImagePath)
+
 	if err := os.RemoveAll(service.ImagePath); err != nil {
 		return aoserrors.Wrap(err)
 	}
@@ -467,6 +690,8 @@ func (sm *ServiceManager) removeService(service ServiceInfo) error {
 		return aoserrors.Wrap(err)
 	}
 
+	sm.evictionPolicy.RemoveUsage(fmt.Sprintf("%s_%d", service.ServiceID, service.AosVersion))
+
 	log.WithFields(log.Fields{
 		"serviceID":  service.ServiceID,
 		"aosVersion": service.AosVersion,
@@ -475,74 +700,128 @@ func (sm *ServiceManager) removeService(service ServiceInfo) error {
 	return nil
 }
 
-func (sm *ServiceManager) prepareServiceFS(
-	imagePath string, gid int,
-) (serviceSize int64, space spaceallocator.Space, rootFSDigest digest.Digest, err error) {
+// prepareServiceFS unpacks service rootfs and removes its archive. Returned archive size should be freed in service
+// allocator when service is successfully installed, on failure it is released with the service package space.
+func (sm *ServiceManager) prepareServiceFS(imagePath string, gid int) (
+	serviceSize int64, archiveSize uint64, space spaceallocator.Space, rootFSDigest digest.Digest, err error,
+) {
 	imageParts, err := getImageParts(imagePath)
 	if err != nil {
-		return 0, nil, "", aoserrors.Wrap(err)
+		return 0, 0, nil, "", aoserrors.Wrap(err)
 	}
 
 	if serviceSize, err = image.GetUncompressedTarContentSize(imageParts.ServiceFSPath); err != nil {
-		return 0, nil, "", aoserrors.Wrap(err)
+		return 0, 0, nil, "", aoserrors.Wrap(err)
 	}
 
-	if space, err = sm.serviceAllocator.AllocateSpace(uint64(serviceSize)); err != nil {
-		return 0, nil, "", aoserrors.Wrap(err)
+	serviceSpace, err := sm.serviceAllocator.AllocateSpace(uint64(serviceSize))
+	if err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
 	}
 
+	defer func() {
+		if err != nil {
+			if releaseErr := serviceSpace.Release(); releaseErr != nil {
+				log.Errorf("Can't release memory: %v", releaseErr)
+			}
+		}
+	}()
+
 	originRootFSPath := imageParts.ServiceFSPath
 
 	tmpRootFS := filepath.Join(imagePath, tmpRootFSDir)
 
 	// unpack rootfs layer
 	if err = image.UnpackTarImage(imageParts.ServiceFSPath, tmpRootFS); err != nil {
-		return 0, nil, "", aoserrors.Wrap(err)
+		return 0, 0, nil, "", aoserrors.Wrap(err)
 	}
 
 	serviceFSArchiveSize, err := getFileSize(imageParts.ServiceFSPath)
 	if err != nil {
-		return 0, nil, "", aoserrors.Wrap(err)
+		return 0, 0, nil, "", aoserrors.Wrap(err)
 	}
 
-	sm.serviceAllocator.FreeSpace(serviceFSArchiveSize)
-
 	if err = os.RemoveAll(imageParts.ServiceFSPath); err != nil {
 		log.Errorf("Can't remove temp file: %s", err)
 	}
 
-	if err = filepath.Walk(tmpRootFS, func(name string, info os.FileInfo, err error) error {
-		if err != nil {
-			return aoserrors.Wrap(err)
-		}
+	if err = prepareRootFS(tmpRootFS, gid); err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
+	}
 
-		if err = os.Chown(name, 0, gid); err != nil {
-			return aoserrors.Wrap(err)
-		}
+	rootFSHash, err := dirhash.HashDir(tmpRootFS, tmpRootFS, dirDigest)
+	if err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
+	}
 
-		return nil
-	}); err != nil {
-		return 0, nil, "", aoserrors.Wrap(err)
+	if rootFSDigest, err = digest.Parse(rootFSHash); err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
 	}
 
-	if err := whiteouts.OCIWhiteoutsToOverlay(tmpRootFS, 0, gid); err != nil {
-		return 0, nil, "", aoserrors.Wrap(err)
+	if err = os.Rename(tmpRootFS, filepath.Join(path.Dir(originRootFSPath), rootFSDigest.Hex())); err != nil {
+		return 0, 0, nil, "", aoserrors.Wrap(err)
 	}
 
-	rootFSHash, err := dirhash.HashDir(tmpRootFS, tmpRootFS, dirDigest)
+	return serviceSize, serviceFSArchiveSize, serviceSpace, rootFSDigest, nil
+}
+
+// convertServiceFS converts unpacked service rootfs into read-only image if image storage is configured. Image is
+// mounted to the rootfs dir, so rootfs path is the same for both storage modes.
+func (sm *ServiceManager) convertServiceFS(imagePath string) (imageSize uint64, err error) {
+	imageParts, err := getImageParts(imagePath)
 	if err != nil {
-		return 0, nil, "", aoserrors.Wrap(err)
+		return 0, aoserrors.Wrap(err)
 	}
 
-	if rootFSDigest, err = digest.Parse(rootFSHash); err != nil {
-		return 0, nil, "", aoserrors.Wrap(err)
+	if imageSize, err = sm.fsStore.Convert(imageParts.ServiceFSPath, sm.serviceAllocator); err != nil {
+		return 0, aoserrors.Wrap(err)
 	}
 
-	if err = os.Rename(tmpRootFS, filepath.Join(path.Dir(originRootFSPath), rootFSDigest.Hex())); err != nil {
-		return 0, nil, "", aoserrors.Wrap(err)
+	return imageSize, nil
+}
+
+// removeServiceFS unmounts and removes service rootfs image. It should be called before service dir is removed.
+func (sm *ServiceManager) removeServiceFS(imagePath string) {
+	imageParts, err := getImageParts(imagePath)
+	if err != nil {
+		log.WithField("imagePath", imagePath).Errorf("Can't get service image parts: %v", err)
+
+		return
 	}
 
-	return serviceSize, space, rootFSDigest, nil
+	if err = sm.fsStore.Remove(imageParts.ServiceFSPath); err != nil {
+		log.WithField("imagePath", imagePath).Errorf("Can't remove service rootfs image: %v", err)
+	}
+}
+
+// mountServicesFS mounts installed services rootfs images. Services which images can't be mounted or don't pass
+// verity check are marked as damaged.
+func (sm *ServiceManager) mountServicesFS() error {
+	services, err := sm.serviceInfoProvider.GetServices()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	for _, service := range services {
+		imageParts, err := getImageParts(service.ImagePath)
+		if err == nil {
+			err = sm.fsStore.Mount(imageParts.ServiceFSPath)
+		}
+
+		if err == nil {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"serviceID": service.ServiceID, "aosVersion": service.AosVersion,
+		}).Errorf("Can't mount service rootfs image: %v", err)
+
+		if err = sm.serviceInfoProvider.SetServiceDamaged(service.ServiceID, service.AosVersion, true); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	return nil
 }
 
 func (sm *ServiceManager) removeDamagedServiceFolders(services []ServiceInfo) error {
@@ -583,13 +862,21 @@ filesLoop:
 }
 
 func (sm *ServiceManager) extractPackageByURL(
-	serviceInfo *aostypes.ServiceInfo,
+	ctx context.Context, serviceInfo *aostypes.ServiceInfo,
 ) (imagePath string, serviceSize uint64, space spaceallocator.Space, err error) {
 	urlVal, err := url.Parse(serviceInfo.URL)
 	if err != nil {
 		return "", 0, nil, aoserrors.Wrap(err)
 	}
 
+	if urlVal.Scheme == registry.Scheme {
+		return sm.pullPackage(ctx, urlVal)
+	}
+
+	if urlVal.Scheme != "file" && sm.streamingUnpack {
+		return sm.unpackPackage(ctx, serviceInfo)
+	}
+
 	var sourceFile string
 
 	if urlVal.Scheme != "file" {
@@ -604,16 +891,24 @@ func (sm *ServiceManager) extractPackageByURL(
 			}
 		}()
 
-		if sourceFile, err = image.Download(context.Background(), sm.downloadDir, serviceInfo.URL); err != nil {
+		if sourceFile, err = sm.downloader.Download(ctx, serviceInfo.URL, image.FileInfo{
+			Sha256: serviceInfo.Sha256,
+			Sha512: serviceInfo.Sha512,
+			Size:   serviceInfo.Size,
+		}); err != nil {
 			return "", 0, nil, aoserrors.Wrap(err)
 		}
 
-		defer os.RemoveAll(sourceFile)
+		defer func() {
+			if err := sm.downloader.Release(serviceInfo.URL); err != nil {
+				log.Errorf("Can't release downloaded file: %v", err)
+			}
+		}()
 	} else {
 		sourceFile = urlVal.Path
 	}
 
-	if err = image.CheckFileInfo(context.Background(), sourceFile, image.FileInfo{
+	if err = image.CheckFileInfo(ctx, sourceFile, image.FileInfo{
 		Sha256: serviceInfo.Sha256,
 		Sha512: serviceInfo.Sha512,
 		Size:   serviceInfo.Size,
@@ -626,20 +921,205 @@ func (sm *ServiceManager) extractPackageByURL(
 		return "", 0, nil, aoserrors.Wrap(err)
 	}
 
-	if space, err = sm.serviceAllocator.AllocateSpace(uint64(size)); err != nil {
+	serviceSpace, err := sm.serviceAllocator.AllocateSpace(uint64(size))
+	if err != nil {
 		return "", 0, nil, aoserrors.Wrap(err)
 	}
 
-	imagePath, err = os.MkdirTemp(sm.servicesDir, "")
+	// Allocated space should be released on failure, otherwise it is lost for other installs
+	defer func() {
+		if err != nil {
+			if releaseErr := serviceSpace.Release(); releaseErr != nil {
+				log.Errorf("Can't release memory: %v", releaseErr)
+			}
+		}
+	}()
+
+	unpackPath, err := os.MkdirTemp(sm.servicesDir, "")
 	if err != nil {
 		return "", 0, nil, aoserrors.Wrap(err)
 	}
 
-	if err = image.UnpackTarImage(sourceFile, imagePath); err != nil {
+	if err = image.UnpackTarImage(sourceFile, unpackPath); err != nil {
+		if removeErr := os.RemoveAll(unpackPath); removeErr != nil {
+			log.Errorf("Can't remove service image: %v", removeErr)
+		}
+
 		return "", 0, nil, aoserrors.Wrap(err)
 	}
 
-	return imagePath, uint64(size), space, nil
+	return unpackPath, uint64(size), serviceSpace, nil
+}
+
+// unpackPackage unpacks service package while downloading it. Download space is not used in this case.
+func (sm *ServiceManager) unpackPackage(
+	ctx context.Context, serviceInfo *aostypes.ServiceInfo,
+) (imagePath string, serviceSize uint64, space spaceallocator.Space, err error) {
+	unpackPath, err := os.MkdirTemp(sm.servicesDir, "")
+	if err != nil {
+		return "", 0, nil, aoserrors.Wrap(err)
+	}
+
+	if serviceSize, space, err = sm.downloader.Unpack(ctx, serviceInfo.URL, image.FileInfo{
+		Sha256: serviceInfo.Sha256,
+		Sha512: serviceInfo.Sha512,
+		Size:   serviceInfo.Size,
+	}, unpackPath, sm.serviceAllocator); err != nil {
+		if removeErr := os.RemoveAll(unpackPath); removeErr != nil {
+			log.Errorf("Can't remove service image: %v", removeErr)
+		}
+
+		return "", 0, nil, aoserrors.Wrap(err)
+	}
+
+	return unpackPath, serviceSize, space, nil
+}
+
+// pullPackage pulls service image from OCI registry into the same layout as unpacked service package. Aos layers
+// referenced by the manifest are not pulled as they are installed and shared by layer manager.
+func (sm *ServiceManager) pullPackage(
+	ctx context.Context, urlVal *url.URL,
+) (imagePath string, serviceSize uint64, space spaceallocator.Space, err error) {
+	ref, err := registry.ParseReference(urlVal)
+	if err != nil {
+		return "", 0, nil, aoserrors.Wrap(err)
+	}
+
+	manifestData, err := sm.registry.GetManifest(ctx, ref)
+	if err != nil {
+		return "", 0, nil, aoserrors.Wrap(err)
+	}
+
+	var manifest serviceManifest
+
+	if err = json.Unmarshal(manifestData, &manifest); err != nil {
+		return "", 0, nil, aoserrors.Wrap(err)
+	}
+
+	if len(manifest.Layers) == 0 {
+		return "", 0, nil, aoserrors.New("no layers in image")
+	}
+
+	blobs := []imagespec.Descriptor{manifest.Config, manifest.Layers[0]}
+
+	if manifest.AosService != nil {
+		blobs = append(blobs, *manifest.AosService)
+	}
+
+	size := uint64(len(manifestData))
+
+	for _, blob := range blobs {
+		size += uint64(blob.Size)
+	}
+
+	serviceSpace, err := sm.serviceAllocator.AllocateSpace(size)
+	if err != nil {
+		return "", 0, nil, aoserrors.Wrap(err)
+	}
+
+	defer func() {
+		if err != nil {
+			if releaseErr := serviceSpace.Release(); releaseErr != nil {
+				log.Errorf("Can't release memory: %v", releaseErr)
+			}
+		}
+	}()
+
+	pullPath, err := os.MkdirTemp(sm.servicesDir, "")
+	if err != nil {
+		return "", 0, nil, aoserrors.Wrap(err)
+	}
+
+	defer func() {
+		if err != nil {
+			if removeErr := os.RemoveAll(pullPath); removeErr != nil {
+				log.Errorf("Can't remove service image: %v", removeErr)
+			}
+		}
+	}()
+
+	if err = os.WriteFile(filepath.Join(pullPath, manifestFileName), manifestData, 0o600); err != nil {
+		return "", 0, nil, aoserrors.Wrap(err)
+	}
+
+	for _, blob := range blobs {
+		blobDir := filepath.Join(pullPath, blobsFolder, string(blob.Digest.Algorithm()))
+
+		if err = os.MkdirAll(blobDir, 0o755); err != nil {
+			return "", 0, nil, aoserrors.Wrap(err)
+		}
+
+		if err = sm.pullBlob(ctx, ref, blob, filepath.Join(blobDir, blob.Digest.Encoded())); err != nil {
+			return "", 0, nil, aoserrors.Wrap(err)
+		}
+	}
+
+	signatureData, err := sm.registry.GetSignature(ctx, ref)
+	if err != nil && !errors.Is(err, registry.ErrNotExist) {
+		return "", 0, nil, aoserrors.Wrap(err)
+	}
+
+	if err == nil {
+		if err = os.WriteFile(filepath.Join(pullPath, manifestFileName+signature.SignatureFileExt),
+			signatureData, 0o600); err != nil {
+			return "", 0, nil, aoserrors.Wrap(err)
+		}
+	}
+
+	return pullPath, size, serviceSpace, nil
+}
+
+// pullBlob downloads registry blob into the file. Download space is allocated while the blob is downloaded.
+func (sm *ServiceManager) pullBlob(
+	ctx context.Context, ref registry.Reference, blob imagespec.Descriptor, fileName string,
+) error {
+	space, err := sm.downloadAllocator.AllocateSpace(uint64(blob.Size))
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	defer func() {
+		if err := space.Release(); err != nil {
+			log.Errorf("Can't release memory: %v", err)
+		}
+	}()
+
+	return aoserrors.Wrap(sm.registry.DownloadBlob(ctx, ref, blob, fileName))
+}
+
+func prepareRootFS(rootFSPath string, gid int) error {
+	if err := filepath.Walk(rootFSPath, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if err = os.Chown(name, 0, gid); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		return nil
+	}); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err := whiteouts.OCIWhiteoutsToOverlay(rootFSPath, 0, gid); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func validateService(service ServiceInfo, open scrubber.OpenFunc) error {
+	manifestCheckSum, err := getManifestChecksum(service.ImagePath)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if !bytes.Equal(service.ManifestDigest, manifestCheckSum) {
+		return aoserrors.New("manifest checksum mismatch")
+	}
+
+	return validateUnpackedImage(service.ImagePath, open)
 }
 
 func acceptAllocatedSpace(spaceService spaceallocator.Space, spacePackage spaceallocator.Space) {
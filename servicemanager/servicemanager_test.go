@@ -18,31 +18,42 @@
 package servicemanager_test
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/aosedge/aos_common/aoserrors"
 	"github.com/aosedge/aos_common/aostypes"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
 	"github.com/aosedge/aos_common/image"
 	"github.com/aosedge/aos_common/spaceallocator"
 	"github.com/aosedge/aos_common/utils/fs"
 	"github.com/opencontainers/go-digest"
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/mod/sumdb/dirhash"
 
 	"github.com/aosedge/aos_servicemanager/config"
+	"github.com/aosedge/aos_servicemanager/eviction"
+	"github.com/aosedge/aos_servicemanager/fsimage"
+	"github.com/aosedge/aos_servicemanager/scrubber"
 	"github.com/aosedge/aos_servicemanager/servicemanager"
+	"github.com/aosedge/aos_servicemanager/signature"
 )
 
 func init() {
@@ -73,8 +84,14 @@ const (
  **********************************************************************************************************************/
 
 type testServiceStorage struct {
+	sync.Mutex
+
 	getAllError bool
 	Services    []servicemanager.ServiceInfo
+	images      map[string]fsimage.ImageInfo
+	usage       map[string]time.Time
+	evicted     []eviction.EvictedItem
+	knownGood   map[string]uint64
 }
 
 type testAllocator struct {
@@ -87,6 +104,11 @@ type testAllocator struct {
 	outdatedItems []testOutdatedItem
 }
 
+type testServiceManifest struct {
+	imagespec.Manifest
+	AosService *imagespec.Descriptor `json:"aosService,omitempty"`
+}
+
 type expectedService struct {
 	serviceID string
 	version   uint64
@@ -98,8 +120,22 @@ type testSpace struct {
 }
 
 type testOutdatedItem struct {
-	id   string
-	size uint64
+	id        string
+	size      uint64
+	timestamp time.Time
+}
+
+type testDownloader struct {
+	sync.Mutex
+
+	downloadDir  string
+	files        map[string]string
+	unpackedURLs []string
+	pulledURLs   []string
+}
+
+type testSignatureVerifier struct {
+	untrustedIDs []string
 }
 
 /***********************************************************************************************************************
@@ -156,7 +192,8 @@ func TestInstallService(t *testing.T) {
 
 	serviceAllocator = &testAllocator{}
 
-	sm, err := servicemanager.New(config, serviceStorage)
+	sm, err := servicemanager.New(
+		config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier())
 	if err != nil {
 		t.Fatalf("Can't create SM: %s", err)
 	}
@@ -253,7 +290,7 @@ func TestInstallService(t *testing.T) {
 	}
 
 	for _, tCase := range cases {
-		if err := sm.ProcessDesiredServices(tCase.desiredServices); err != nil {
+		if _, err := sm.ProcessDesiredServices(tCase.desiredServices); err != nil {
 			t.Errorf("Can't process desired services: %v", err)
 		}
 
@@ -306,7 +343,8 @@ func TestRemoteDownloadLayer(t *testing.T) {
 
 	serviceAllocator = &testAllocator{}
 
-	sm, err := servicemanager.New(config, serviceStorage)
+	sm, err := servicemanager.New(
+		config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier())
 	if err != nil {
 		t.Fatalf("Can't create SM: %s", err)
 	}
@@ -348,7 +386,7 @@ func TestRemoteDownloadLayer(t *testing.T) {
 
 	serviceInfo.URL = "http://:9000/downloadImage"
 
-	if err := sm.ProcessDesiredServices([]aostypes.ServiceInfo{serviceInfo}); err != nil {
+	if _, err := sm.ProcessDesiredServices([]aostypes.ServiceInfo{serviceInfo}); err != nil {
 		t.Errorf("Can't process desired services: %v", err)
 	}
 
@@ -357,6 +395,59 @@ func TestRemoteDownloadLayer(t *testing.T) {
 	}
 }
 
+func TestStreamingUnpackService(t *testing.T) {
+	serviceStorage := &testServiceStorage{}
+
+	config := &config.Config{
+		ServicesDir: filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir: filepath.Join(tmpDir, "downloads"),
+		Downloader:  config.Downloader{StreamingUnpack: true},
+	}
+
+	serviceAllocator = &testAllocator{}
+	testDownloader := newTestDownloader(config.DownloadDir)
+
+	sm, err := servicemanager.New(config, serviceStorage, testDownloader, newTestSignatureVerifier())
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
+
+	fileServerDir, err := os.MkdirTemp("", "sm_fileserver")
+	if err != nil {
+		t.Fatalf("Error create temporary dir: %v", err)
+	}
+
+	defer os.RemoveAll(fileServerDir)
+
+	serviceInfo, err := prepareService("Service content", "service1", 1, defaultServiceSize)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
+
+	if err = os.Rename(
+		strings.TrimPrefix(serviceInfo.URL, "file://"), filepath.Join(fileServerDir, "service1")); err != nil {
+		t.Fatalf("Can't move service package: %v", err)
+	}
+
+	server := httptest.NewServer(http.FileServer(http.Dir(fileServerDir)))
+	defer server.Close()
+
+	serviceInfo.URL = server.URL + "/service1"
+
+	if _, err := sm.ProcessDesiredServices([]aostypes.ServiceInfo{serviceInfo}); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	if _, err := sm.GetServiceInfo("service1"); err != nil {
+		t.Errorf("Can't get service info: %v", err)
+	}
+
+	if len(testDownloader.unpackedURLs) != 1 || testDownloader.unpackedURLs[0] != serviceInfo.URL {
+		t.Errorf("Service package should be unpacked while downloading: %v", testDownloader.unpackedURLs)
+	}
+}
+
 func TestImageParts(t *testing.T) {
 	serviceStorage := &testServiceStorage{}
 
@@ -368,7 +459,8 @@ func TestImageParts(t *testing.T) {
 
 	serviceAllocator = &testAllocator{}
 
-	sm, err := servicemanager.New(config, serviceStorage)
+	sm, err := servicemanager.New(
+		config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier())
 	if err != nil {
 		t.Fatalf("Can't create SM: %s", err)
 	}
@@ -381,7 +473,7 @@ func TestImageParts(t *testing.T) {
 		t.Fatalf("Can't prepare test service: %s", err)
 	}
 
-	if err = sm.ProcessDesiredServices([]aostypes.ServiceInfo{serviceInfo}); err != nil {
+	if _, err = sm.ProcessDesiredServices([]aostypes.ServiceInfo{serviceInfo}); err != nil {
 		t.Errorf("Can't install service: %s", err)
 	}
 
@@ -423,7 +515,8 @@ func TestValidateService(t *testing.T) {
 
 	serviceAllocator = &testAllocator{}
 
-	sm, err := servicemanager.New(config, serviceStorage)
+	sm, err := servicemanager.New(
+		config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier())
 	if err != nil {
 		t.Fatalf("Can't create SM: %s", err)
 	}
@@ -436,7 +529,7 @@ func TestValidateService(t *testing.T) {
 		t.Errorf("Can't prepare test service: %s", err)
 	}
 
-	if err := sm.ProcessDesiredServices([]aostypes.ServiceInfo{service}); err != nil {
+	if _, err := sm.ProcessDesiredServices([]aostypes.ServiceInfo{service}); err != nil {
 		t.Errorf("Can't process desired services: %v", err)
 	}
 
@@ -450,263 +543,980 @@ func TestValidateService(t *testing.T) {
 	}
 }
 
-func TestAllocateMemoryInstallService(t *testing.T) {
+func TestCheckIntegrity(t *testing.T) {
 	serviceStorage := &testServiceStorage{}
 
 	config := &config.Config{
-		ServicesDir:    filepath.Join(tmpDir, "servicemanager", "services"),
-		DownloadDir:    filepath.Join(tmpDir, "downloads"),
-		ServiceTTLDays: 2,
+		ServicesDir: filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir: filepath.Join(tmpDir, "downloads"),
+		Scrubber:    config.Scrubber{ReinstallDamaged: true},
 	}
 
-	serviceAllocator = &testAllocator{
-		totalSize: 1 * megabyte,
-	}
+	serviceAllocator = &testAllocator{}
 
-	sm, err := servicemanager.New(config, serviceStorage)
+	sm, err := servicemanager.New(
+		config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier())
 	if err != nil {
 		t.Fatalf("Can't create SM: %v", err)
 	}
 	defer sm.Close()
 
-	services := make(map[string][]aostypes.ServiceInfo)
+	service1, err := prepareService("Service content", "service1", 1, defaultServiceSize)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
 
-	generateService := []struct {
-		serviceID      string
-		serviceContent string
-		serviceSize    uint64
-		version        int
-	}{
-		{serviceID: "service1", serviceContent: "service1", serviceSize: 512 * kilobyte, version: 1},
-		{serviceID: "service2", serviceContent: "service2", serviceSize: 520 * kilobyte, version: 1},
-		{serviceID: "service3", serviceContent: "service2", serviceSize: 600 * kilobyte, version: 1},
+	service2, err := prepareService("Service content", "service2", 1, defaultServiceSize)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
 	}
 
-	for _, service := range generateService {
-		serviceInfo, err := prepareService(
-			service.serviceContent, service.serviceID, uint64(service.version), int64(service.serviceSize))
-		if err != nil {
-			t.Fatalf("Can't prepare service: %v", err)
-		}
+	desiredServices := []aostypes.ServiceInfo{service1, service2}
 
-		services[service.serviceID] = append(services[service.serviceID], serviceInfo)
+	if _, err := sm.ProcessDesiredServices(desiredServices); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
 	}
 
-	cases := []struct {
-		desiredServices     []aostypes.ServiceInfo
-		processDesiredError error
-	}{
-		{
-			desiredServices: getDesiredServices(services, []expectedService{
-				{serviceID: "service1", version: 1},
-			}),
-		},
-		{
-			desiredServices: getDesiredServices(services, []expectedService{
-				{serviceID: "service2", version: 1},
-			}),
-		},
-		{
-			desiredServices: getDesiredServices(services, []expectedService{
-				{serviceID: "service2", version: 1},
-				{serviceID: "service3", version: 1},
-			}),
-			processDesiredError: spaceallocator.ErrNoSpace,
-		},
-		{
-			desiredServices: getDesiredServices(services, []expectedService{
-				{serviceID: "service3", version: 1},
-			}),
-		},
+	damaged, err := sm.CheckIntegrity(context.Background(), scrubber.OpenFile)
+	if err != nil {
+		t.Fatalf("Can't check integrity: %v", err)
 	}
 
-	for _, tCase := range cases {
-		if err := sm.ProcessDesiredServices(tCase.desiredServices); !errors.Is(err, tCase.processDesiredError) {
-			t.Errorf("Can't process desired service: %v", err)
-		}
+	if len(damaged) != 0 {
+		t.Errorf("Unexpected damaged services: %v", damaged)
 	}
-}
-
-func TestCachedServiceOnStart(t *testing.T) {
-	serviceStorage := &testServiceStorage{}
 
-	config := &config.Config{
-		ServicesDir:    filepath.Join(tmpDir, "servicemanager", "services"),
-		DownloadDir:    filepath.Join(tmpDir, "downloads"),
-		ServiceTTLDays: 2,
+	serviceInfo, err := sm.GetServiceInfo("service1")
+	if err != nil {
+		t.Fatalf("Can't get service info: %v", err)
 	}
 
-	serviceAllocator = &testAllocator{
-		totalSize: 1 * megabyte,
+	imageParts, err := sm.GetImageParts(serviceInfo)
+	if err != nil {
+		t.Fatalf("Can't get image parts: %v", err)
 	}
 
-	sm, err := servicemanager.New(config, serviceStorage)
-	if err != nil {
-		t.Fatalf("Can't create SM: %v", err)
+	if err = os.WriteFile(
+		filepath.Join(imageParts.ServiceFSPath, "home", "service.py"), []byte("tampered"), 0o600); err != nil {
+		t.Fatalf("Can't tamper service: %v", err)
 	}
 
-	services := make(map[string][]aostypes.ServiceInfo)
+	if damaged, err = sm.CheckIntegrity(context.Background(), scrubber.OpenFile); err != nil {
+		t.Fatalf("Can't check integrity: %v", err)
+	}
 
-	generateService := []struct {
-		serviceID      string
-		serviceContent string
-		serviceSize    uint64
-		version        int
-	}{
-		{serviceID: "service1", serviceContent: "service1", serviceSize: 512 * kilobyte, version: 1},
-		{serviceID: "service2", serviceContent: "service2", serviceSize: 256 * kilobyte, version: 1},
-		{serviceID: "service3", serviceContent: "service3", serviceSize: 300 * kilobyte, version: 1},
+	if len(damaged) != 1 || damaged[0].Type != scrubber.ServiceItem || damaged[0].ID != "service1" {
+		t.Fatalf("Wrong damaged services: %v", damaged)
 	}
 
-	for _, service := range generateService {
-		serviceInfo, err := prepareService(
-			service.serviceContent, service.serviceID, uint64(service.version), int64(service.serviceSize))
-		if err != nil {
-			t.Fatalf("Can't prepare service: %v", err)
-		}
+	if serviceInfo, err = sm.GetServiceInfo("service1"); err != nil {
+		t.Fatalf("Can't get service info: %v", err)
+	}
 
-		services[service.serviceID] = append(services[service.serviceID], serviceInfo)
+	if !serviceInfo.Damaged {
+		t.Error("Service should be marked as damaged")
 	}
 
-	cases := []struct {
-		desiredServices     []aostypes.ServiceInfo
-		processDesiredError error
-		useServiceID        expectedService
-	}{
-		{
-			desiredServices: getDesiredServices(services, []expectedService{
-				{serviceID: "service1", version: 1},
-				{serviceID: "service2", version: 1},
-			}),
-			useServiceID: expectedService{serviceID: "service1", version: 1},
-		},
-		{
-			desiredServices: getDesiredServices(services, []expectedService{
-				{serviceID: "service1", version: 1},
-				{serviceID: "service2", version: 1},
-				{serviceID: "service3", version: 1},
-			}),
-			processDesiredError: spaceallocator.ErrNoSpace,
-		},
-		{
-			desiredServices: getDesiredServices(services, []expectedService{
-				{serviceID: "service2", version: 1},
-			}),
-		},
+	// Already damaged service is not reported again
+
+	if damaged, err = sm.CheckIntegrity(context.Background(), scrubber.OpenFile); err != nil {
+		t.Fatalf("Can't check integrity: %v", err)
 	}
 
-	for _, tCase := range cases {
-		if err := sm.ProcessDesiredServices(tCase.desiredServices); !errors.Is(err, tCase.processDesiredError) {
-			t.Errorf("Can't process desired service: %v", err)
-		}
+	if len(damaged) != 0 {
+		t.Errorf("Unexpected damaged services: %v", damaged)
 	}
 
-	sm.Close()
+	// Damaged service in use is not removed
 
-	if sm, err = servicemanager.New(config, serviceStorage); err != nil {
-		t.Fatalf("Can't create SM: %v", err)
+	if _, err := sm.ProcessDesiredServices(desiredServices); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
 	}
-	defer sm.Close()
 
-	if _, err := sm.GetServiceInfo("service1"); !errors.Is(err, servicemanager.ErrNotExist) {
-		t.Fatalf("Should be error not exist: %v", err)
+	if serviceInfo, err = sm.GetServiceInfo("service1"); err != nil {
+		t.Fatalf("Can't get service info: %v", err)
 	}
 
-	if err := sm.ProcessDesiredServices(getDesiredServices(services, []expectedService{
-		{serviceID: "service2", version: 1},
-		{serviceID: "service3", version: 1},
-	})); err != nil {
-		t.Errorf("Can't process desired service: %v", err)
+	if !serviceInfo.Damaged {
+		t.Error("Service in use should not be reinstalled")
 	}
-}
 
-func TestFailCreateAllocator(t *testing.T) {
-	serviceAllocator = &testAllocator{
-		partLimit: 100,
+	// Damaged service is reinstalled when it is desired again after it becomes cached
+
+	if _, err := sm.ProcessDesiredServices([]aostypes.ServiceInfo{service2}); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
 	}
 
-	serviceStorage := &testServiceStorage{}
+	if _, err := sm.ProcessDesiredServices(desiredServices); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
 
-	config := &config.Config{
-		ServicesDir:       filepath.Join(tmpDir, "servicemanager", "services"),
-		DownloadDir:       filepath.Join(tmpDir, "downloads"),
-		ServicesPartLimit: 110,
+	if serviceInfo, err = sm.GetServiceInfo("service1"); err != nil {
+		t.Fatalf("Can't get service info: %v", err)
 	}
 
-	if _, err := servicemanager.New(config, serviceStorage); err == nil {
-		t.Fatal("Should be error creating allocator")
+	if serviceInfo.Damaged {
+		t.Error("Reinstalled service should not be damaged")
+	}
+
+	if err = sm.ValidateService(serviceInfo); err != nil {
+		t.Errorf("Reinstalled service validation failed: %v", err)
 	}
 }
 
-func TestRemoveServiceVersionOnInstall(t *testing.T) {
+func TestServiceImageStorage(t *testing.T) {
 	serviceStorage := &testServiceStorage{}
 
 	config := &config.Config{
-		ServicesDir:    filepath.Join(tmpDir, "servicemanager", "services"),
-		DownloadDir:    filepath.Join(tmpDir, "downloads"),
-		ServiceTTLDays: 2,
+		ServicesDir:  filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir:  filepath.Join(tmpDir, "downloads"),
+		ImageStorage: config.ImageStorage{Format: fsimage.FormatSquashFS},
+		Scrubber:     config.Scrubber{ReinstallDamaged: true},
+	}
+
+	var failMount bool
+
+	// Image is a copy of rootfs dir, mount copies image content back to rootfs dir
+	fsimage.RunCommand = func(name string, args ...string) ([]byte, error) {
+		switch name {
+		case "mksquashfs":
+			return nil, copyDir(args[0], args[1])
+
+		case "mount":
+			if failMount {
+				return nil, aoserrors.New("mount failed")
+			}
+
+			return nil, copyDir(args[4], args[5])
+		}
+
+		return nil, aoserrors.Errorf("unexpected command: %s", name)
 	}
 
 	serviceAllocator = &testAllocator{}
 
-	sm, err := servicemanager.New(config, serviceStorage)
+	sm, err := servicemanager.New(
+		config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier())
 	if err != nil {
 		t.Fatalf("Can't create SM: %v", err)
 	}
-	defer sm.Close()
-
-	services := make(map[string][]aostypes.ServiceInfo)
 
-	generateService := []struct {
-		serviceID      string
-		serviceContent string
-		serviceSize    uint64
-		version        int
-	}{
-		{serviceID: "service1", serviceContent: "service1", serviceSize: 512 * kilobyte, version: 1},
-		{serviceID: "service1", serviceContent: "service2", serviceSize: 256 * kilobyte, version: 2},
-		{serviceID: "service1", serviceContent: "service3", serviceSize: 300 * kilobyte, version: 2},
+	service, err := prepareService("Service content", "service1", 1, defaultServiceSize)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
 	}
 
-	for _, service := range generateService {
-		serviceInfo, err := prepareService(
-			service.serviceContent, service.serviceID, uint64(service.version), int64(service.serviceSize))
-		if err != nil {
-			t.Fatalf("Can't prepare service: %v", err)
-		}
-
-		services[service.serviceID] = append(services[service.serviceID], serviceInfo)
+	if _, err := sm.ProcessDesiredServices([]aostypes.ServiceInfo{service}); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
 	}
 
-	cases := []struct {
-		desiredServices      []aostypes.ServiceInfo
-		expectedServiceCount int
-	}{
-		{
-			desiredServices: getDesiredServices(services, []expectedService{
-				{serviceID: "service1", version: 1},
-			}),
-			expectedServiceCount: 1,
-		},
-		{
-			desiredServices: getDesiredServices(services, []expectedService{
-				{serviceID: "service1", version: 1},
-				{serviceID: "service1", version: 2},
-			}),
-			expectedServiceCount: 2,
-		},
-		{
-			desiredServices: getDesiredServices(services, []expectedService{
-				{serviceID: "service1", version: 1},
-				{serviceID: "service1", version: 2},
-				{serviceID: "service1", version: 3},
-			}),
-			expectedServiceCount: 2,
-		},
+	serviceInfo, err := sm.GetServiceInfo("service1")
+	if err != nil {
+		t.Fatalf("Can't get service info: %v", err)
+	}
+
+	imageParts, err := sm.GetImageParts(serviceInfo)
+	if err != nil {
+		t.Fatalf("Can't get image parts: %v", err)
+	}
+
+	imageInfo, err := serviceStorage.GetFSImage(imageParts.ServiceFSPath)
+	if err != nil {
+		t.Fatalf("Can't get rootfs image info: %v", err)
+	}
+
+	if _, err = os.Stat(imageInfo.ImagePath); err != nil {
+		t.Errorf("Rootfs image not found: %v", err)
+	}
+
+	if err = sm.ValidateService(serviceInfo); err != nil {
+		t.Errorf("Service validation failed: %v", err)
+	}
+
+	sm.Close()
+
+	// Emulate reboot: rootfs image is mounted on start
+
+	if err = os.RemoveAll(imageParts.ServiceFSPath); err != nil {
+		t.Fatalf("Can't remove rootfs dir: %v", err)
+	}
+
+	if sm, err = servicemanager.New(
+		config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier()); err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+
+	if err = sm.ValidateService(serviceInfo); err != nil {
+		t.Errorf("Service validation failed: %v", err)
+	}
+
+	sm.Close()
+
+	// Service which image can't be mounted is marked as damaged
+
+	if err = os.RemoveAll(imageParts.ServiceFSPath); err != nil {
+		t.Fatalf("Can't remove rootfs dir: %v", err)
+	}
+
+	failMount = true
+
+	if sm, err = servicemanager.New(
+		config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier()); err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
+
+	if serviceInfo, err = sm.GetServiceInfo("service1"); err != nil {
+		t.Fatalf("Can't get service info: %v", err)
+	}
+
+	if !serviceInfo.Damaged {
+		t.Error("Service should be marked as damaged")
+	}
+
+	// Image is removed with damaged service when it is not used anymore
+
+	for i := 0; i < 2; i++ {
+		if _, err := sm.ProcessDesiredServices(nil); err != nil {
+			t.Fatalf("Can't process desired services: %v", err)
+		}
+	}
+
+	if _, err = sm.GetServiceVersionInfo("service1", 1); !errors.Is(err, servicemanager.ErrNotExist) {
+		t.Errorf("Damaged service should be removed: %v", err)
+	}
+
+	if _, err = serviceStorage.GetFSImage(imageParts.ServiceFSPath); !errors.Is(err, fsimage.ErrNotExist) {
+		t.Errorf("Rootfs image info should be removed: %v", err)
+	}
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	serviceStorage := &testServiceStorage{knownGood: map[string]uint64{"service4": 1}}
+
+	config := &config.Config{
+		ServicesDir: filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir: filepath.Join(tmpDir, "downloads"),
+		ServiceEviction: config.Eviction{
+			Policy: eviction.PolicyLRU, MinFreeSpace: megabyte, Pins: []config.EvictionPin{{ID: "service3"}},
+		},
+	}
+
+	// Emulate min free space is reached when only three services are left
+	getAvailableSpace := eviction.GetAvailableSpace
+	defer func() { eviction.GetAvailableSpace = getAvailableSpace }()
+
+	eviction.GetAvailableSpace = func(path string) (uint64, error) {
+		services, err := serviceStorage.GetServices()
+		if err != nil {
+			return 0, err
+		}
+
+		if len(services) > 3 {
+			return 0, nil
+		}
+
+		return megabyte, nil
+	}
+
+	serviceAllocator = &testAllocator{}
+
+	sm, err := servicemanager.New(
+		config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier())
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
+
+	var desiredServices []aostypes.ServiceInfo
+
+	for i := 1; i <= 4; i++ {
+		service, err := prepareService("Service content", fmt.Sprintf("service%d", i), 1, defaultServiceSize)
+		if err != nil {
+			t.Fatalf("Can't prepare service: %v", err)
+		}
+
+		desiredServices = append(desiredServices, service)
+	}
+
+	if _, err := sm.ProcessDesiredServices(desiredServices); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	// Service2 is installed earlier than service1 but it is used after service1 is installed. Known good service4 is
+	// installed first.
+
+	serviceStorage.Lock()
+
+	for i := range serviceStorage.Services {
+		switch serviceStorage.Services[i].ServiceID {
+		case "service2":
+			serviceStorage.Services[i].Timestamp = serviceStorage.Services[i].Timestamp.Add(-time.Hour)
+
+		case "service4":
+			serviceStorage.Services[i].Timestamp = serviceStorage.Services[i].Timestamp.Add(-2 * time.Hour)
+		}
+	}
+
+	serviceStorage.Unlock()
+
+	if err := sm.UseService("service2", 1); err != nil {
+		t.Fatalf("Can't use service: %v", err)
+	}
+
+	if _, err := sm.ProcessDesiredServices(nil); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	// Pinned service3 and known good service4 are not outdated, service1 is least recently used and evicted to reach
+	// min free space
+
+	serviceStorage.Lock()
+	report := serviceStorage.evicted
+	serviceStorage.Unlock()
+
+	if len(report) != 1 || report[0].ID != "service1" || report[0].Reason != eviction.ReasonMinFreeSpace ||
+		report[0].Type != eviction.ServiceItem {
+		t.Errorf("Wrong eviction report: %v", report)
+	}
+
+	if _, err := sm.GetServiceVersionInfo("service1", 1); !errors.Is(err, servicemanager.ErrNotExist) {
+		t.Errorf("Service1 should be evicted: %v", err)
+	}
+
+	for _, serviceID := range []string{"service2", "service3", "service4"} {
+		if _, err := sm.GetServiceVersionInfo(serviceID, 1); err != nil {
+			t.Errorf("Can't get service info: %v", err)
+		}
+	}
+
+	if len(serviceAllocator.outdatedItems) != 1 || serviceAllocator.outdatedItems[0].id != "service2_1" {
+		t.Errorf("Wrong outdated items: %v", serviceAllocator.outdatedItems)
+	}
+
+	if _, err := serviceStorage.GetItemLastUsed(eviction.ServiceItem, "service1_1"); !errors.Is(
+		err, eviction.ErrNotExist) {
+		t.Errorf("Evicted service usage should be removed: %v", err)
+	}
+}
+
+func TestInstallServicesPartialFailure(t *testing.T) {
+	serviceIDs := []string{"service1", "service2", "service3", "service4"}
+	desiredServices := make(map[string]aostypes.ServiceInfo)
+
+	for _, serviceID := range append([]string{errorAddServiceID}, serviceIDs...) {
+		serviceInfo, err := prepareService(serviceID, serviceID, 1, defaultServiceSize)
+		if err != nil {
+			t.Fatalf("Can't prepare service: %v", err)
+		}
+
+		desiredServices[serviceID] = serviceInfo
+	}
+
+	cases := []struct {
+		name          string
+		serviceIDs    []string
+		errorExpected bool
+	}{
+		{
+			name:       "successful",
+			serviceIDs: serviceIDs,
+		},
+		{
+			name:          "partial",
+			serviceIDs:    append([]string{serviceIDs[0], errorAddServiceID}, serviceIDs[1:]...),
+			errorExpected: true,
+		},
+	}
+
+	allocatedSizes := make([]uint64, 0, len(cases))
+
+	for _, tCase := range cases {
+		serviceStorage := &testServiceStorage{}
+
+		config := &config.Config{
+			ServicesDir: filepath.Join(tmpDir, "servicemanager", tCase.name),
+			DownloadDir: filepath.Join(tmpDir, "downloads"),
+		}
+
+		serviceAllocator = &testAllocator{}
+
+		sm, err := servicemanager.New(
+			config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier())
+		if err != nil {
+			t.Fatalf("Can't create SM: %v", err)
+		}
+
+		services := make([]aostypes.ServiceInfo, 0, len(tCase.serviceIDs))
+
+		for _, serviceID := range tCase.serviceIDs {
+			services = append(services, desiredServices[serviceID])
+		}
+
+		if _, err = sm.ProcessDesiredServices(services); (err != nil) != tCase.errorExpected {
+			t.Errorf("Unexpected process desired services result: %v", err)
+		}
+
+		sm.Close()
+
+	nextService:
+		for _, serviceID := range serviceIDs {
+			for _, storeService := range serviceStorage.Services {
+				if serviceID == storeService.ServiceID {
+					continue nextService
+				}
+			}
+
+			t.Errorf("Service %s should be installed", serviceID)
+		}
+
+		if len(serviceStorage.Services) != len(serviceIDs) {
+			t.Errorf("Wrong installed services count: %d", len(serviceStorage.Services))
+		}
+
+		allocatedSizes = append(allocatedSizes, serviceAllocator.allocatedSize)
+	}
+
+	// Failed service should release all allocated space
+	if allocatedSizes[0] != allocatedSizes[1] {
+		t.Errorf("Wrong allocated size: %d, expected: %d", allocatedSizes[1], allocatedSizes[0])
+	}
+}
+
+func TestUntrustedService(t *testing.T) {
+	serviceStorage := &testServiceStorage{}
+
+	config := &config.Config{
+		ServicesDir: filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir: filepath.Join(tmpDir, "downloads"),
+	}
+
+	serviceAllocator = &testAllocator{}
+
+	sm, err := servicemanager.New(
+		config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier("untrustedService"))
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
+
+	var desiredServices []aostypes.ServiceInfo
+
+	for _, serviceID := range []string{"service1", "untrustedService"} {
+		serviceInfo, err := prepareService(serviceID, serviceID, 1, defaultServiceSize)
+		if err != nil {
+			t.Fatalf("Can't prepare service: %v", err)
+		}
+
+		desiredServices = append(desiredServices, serviceInfo)
+	}
+
+	if _, err := sm.ProcessDesiredServices(desiredServices); !errors.Is(err, signature.ErrVerificationFailed) {
+		t.Errorf("Unexpected process desired services error: %v", err)
+	}
+
+	if len(serviceStorage.Services) != 1 || serviceStorage.Services[0].ServiceID != "service1" {
+		t.Errorf("Wrong installed services: %v", serviceStorage.Services)
+	}
+}
+
+func TestRegistryService(t *testing.T) {
+	serviceStorage := &testServiceStorage{}
+
+	registryDir, err := os.MkdirTemp("", "sm_registry")
+	if err != nil {
+		t.Fatalf("Error create temporary dir: %s", err)
+	}
+
+	defer os.RemoveAll(registryDir)
+
+	server := httptest.NewServer(http.FileServer(http.Dir(registryDir)))
+	defer server.Close()
+
+	registryHost := strings.TrimPrefix(server.URL, "http://")
+
+	config := &config.Config{
+		ServicesDir: filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir: filepath.Join(tmpDir, "downloads"),
+		Registries:  []config.Registry{{Host: registryHost, PlainHTTP: true}},
+	}
+
+	serviceAllocator = &testAllocator{}
+	testDownloader := newTestDownloader(config.DownloadDir)
+
+	sm, err := servicemanager.New(config, serviceStorage, testDownloader, newTestSignatureVerifier())
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
+
+	serviceInfo, err := prepareService("Service content", "service1", 1, defaultServiceSize)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
+
+	manifestDigest, err := pushService(registryDir, "aos/service1", serviceInfo)
+	if err != nil {
+		t.Fatalf("Can't push service: %v", err)
+	}
+
+	serviceInfo.URL = fmt.Sprintf("oci://%s/aos/service1@%s", registryHost, manifestDigest)
+
+	if _, err := sm.ProcessDesiredServices([]aostypes.ServiceInfo{serviceInfo}); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	service, err := sm.GetServiceInfo("service1")
+	if err != nil {
+		t.Fatalf("Can't get service info: %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(service.ImagePath, "manifest.json")); err != nil {
+		t.Errorf("Can't find service manifest: %v", err)
+	}
+
+	if len(testDownloader.pulledURLs) == 0 {
+		t.Error("Service blobs should be downloaded by downloader")
+	}
+
+	if len(testDownloader.files) != 0 {
+		t.Errorf("Downloaded blobs should be released: %v", testDownloader.files)
+	}
+
+	// Not existing manifest
+
+	allocatedSize := serviceAllocator.allocatedSize
+
+	wrongServiceInfo := serviceInfo
+
+	wrongServiceInfo.ID = "service2"
+	wrongServiceInfo.URL = fmt.Sprintf("oci://%s/aos/service2@%s", registryHost, manifestDigest)
+
+	statuses, err := sm.ProcessDesiredServices([]aostypes.ServiceInfo{serviceInfo, wrongServiceInfo})
+	if err == nil {
+		t.Error("Error expected")
+	}
+
+	if len(statuses) != 2 {
+		t.Fatalf("Wrong statuses count: %d", len(statuses))
+	}
+
+	if statuses[0].ID != "service1" || statuses[0].Status != cloudprotocol.InstalledStatus {
+		t.Errorf("Wrong service status: %v", statuses[0])
+	}
+
+	if statuses[1].ID != "service2" || statuses[1].Status != cloudprotocol.ErrorStatus ||
+		statuses[1].ErrorInfo == nil {
+		t.Errorf("Wrong service status: %v", statuses[1])
+	}
+
+	if _, err := sm.GetServiceInfo("service2"); !errors.Is(err, servicemanager.ErrNotExist) {
+		t.Errorf("Unexpected get service info error: %v", err)
+	}
+
+	if serviceAllocator.allocatedSize != allocatedSize {
+		t.Errorf("Wrong allocated size: %d, expected: %d", serviceAllocator.allocatedSize, allocatedSize)
+	}
+}
+
+func TestDeltaService(t *testing.T) {
+	serviceStorage := &testServiceStorage{}
+
+	config := &config.Config{
+		ServicesDir: filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir: filepath.Join(tmpDir, "downloads"),
+	}
+
+	serviceAllocator = &testAllocator{}
+
+	sm, err := servicemanager.New(
+		config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier())
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
+
+	baseInfo, err := prepareService("Service content", "service1", 1, defaultServiceSize)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
+
+	if _, err := sm.ProcessDesiredServices([]aostypes.ServiceInfo{baseInfo}); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	baseService, err := sm.GetServiceInfo("service1")
+	if err != nil {
+		t.Fatalf("Can't get service info: %v", err)
+	}
+
+	if len(baseService.OriginalManifestDigest) == 0 ||
+		bytes.Equal(baseService.OriginalManifestDigest, baseService.ManifestDigest) {
+		t.Error("Original manifest digest should be stored")
+	}
+
+	// Delta applied to installed base version
+
+	deltaInfo, err := prepareDeltaService(baseService.ImagePath, baseService.OriginalManifestDigest,
+		aostypes.ServiceInfo{ID: "service1", VersionInfo: aostypes.VersionInfo{AosVersion: 2}}, "", false)
+	if err != nil {
+		t.Fatalf("Can't prepare delta service: %v", err)
+	}
+
+	if _, err := sm.ProcessDesiredServices([]aostypes.ServiceInfo{deltaInfo}); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	service, err := sm.GetServiceInfo("service1")
+	if err != nil {
+		t.Fatalf("Can't get service info: %v", err)
+	}
+
+	if service.AosVersion != 2 {
+		t.Errorf("Wrong service version: %d", service.AosVersion)
+	}
+
+	imageParts, err := sm.GetImageParts(service)
+	if err != nil {
+		t.Fatalf("Can't get image parts: %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(imageParts.ServiceFSPath, "home", "delta.py")); err != nil {
+		t.Errorf("Delta file is not found: %v", err)
+	}
+
+	_, err = os.Stat(filepath.Join(imageParts.ServiceFSPath, "home", "service.py"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Removed file should not exist: %v", err)
+	}
+
+	if err = sm.ValidateService(service); err != nil {
+		t.Errorf("Service validation failed: %v", err)
+	}
+
+	// Wrong rebuilt rootfs digest
+
+	allocatedSize := serviceAllocator.allocatedSize
+
+	deltaInfo, err = prepareDeltaService(baseService.ImagePath, baseService.OriginalManifestDigest,
+		aostypes.ServiceInfo{ID: "service1", VersionInfo: aostypes.VersionInfo{AosVersion: 3}}, "", true)
+	if err != nil {
+		t.Fatalf("Can't prepare delta service: %v", err)
+	}
+
+	if _, err := sm.ProcessDesiredServices([]aostypes.ServiceInfo{deltaInfo}); err == nil {
+		t.Error("Error expected")
+	}
+
+	if serviceAllocator.allocatedSize != allocatedSize {
+		t.Errorf("Wrong allocated size: %d, expected: %d", serviceAllocator.allocatedSize, allocatedSize)
+	}
+
+	// Base version is not installed, full image is used
+
+	fullInfo, err := prepareService("Service content", "service2", 2, defaultServiceSize)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
+
+	deltaInfo, err = prepareDeltaService(baseService.ImagePath, baseService.OriginalManifestDigest,
+		aostypes.ServiceInfo{ID: "service2", VersionInfo: aostypes.VersionInfo{AosVersion: 2}}, fullInfo.URL, false)
+	if err != nil {
+		t.Fatalf("Can't prepare delta service: %v", err)
+	}
+
+	if _, err := sm.ProcessDesiredServices([]aostypes.ServiceInfo{deltaInfo}); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	if service, err = sm.GetServiceInfo("service2"); err != nil {
+		t.Fatalf("Can't get service info: %v", err)
+	}
+
+	if imageParts, err = sm.GetImageParts(service); err != nil {
+		t.Fatalf("Can't get image parts: %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(imageParts.ServiceFSPath, "home", "service.py")); err != nil {
+		t.Errorf("Full image file is not found: %v", err)
+	}
+}
+
+func TestAllocateMemoryInstallService(t *testing.T) {
+	serviceStorage := &testServiceStorage{}
+
+	config := &config.Config{
+		ServicesDir:    filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir:    filepath.Join(tmpDir, "downloads"),
+		ServiceTTLDays: 2,
+	}
+
+	serviceAllocator = &testAllocator{
+		totalSize: 1 * megabyte,
+	}
+
+	sm, err := servicemanager.New(
+		config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier())
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
+
+	services := make(map[string][]aostypes.ServiceInfo)
+
+	generateService := []struct {
+		serviceID      string
+		serviceContent string
+		serviceSize    uint64
+		version        int
+	}{
+		{serviceID: "service1", serviceContent: "service1", serviceSize: 512 * kilobyte, version: 1},
+		{serviceID: "service2", serviceContent: "service2", serviceSize: 520 * kilobyte, version: 1},
+		{serviceID: "service3", serviceContent: "service2", serviceSize: 600 * kilobyte, version: 1},
+	}
+
+	for _, service := range generateService {
+		serviceInfo, err := prepareService(
+			service.serviceContent, service.serviceID, uint64(service.version), int64(service.serviceSize))
+		if err != nil {
+			t.Fatalf("Can't prepare service: %v", err)
+		}
+
+		services[service.serviceID] = append(services[service.serviceID], serviceInfo)
+	}
+
+	cases := []struct {
+		desiredServices     []aostypes.ServiceInfo
+		processDesiredError error
+	}{
+		{
+			desiredServices: getDesiredServices(services, []expectedService{
+				{serviceID: "service1", version: 1},
+			}),
+		},
+		{
+			desiredServices: getDesiredServices(services, []expectedService{
+				{serviceID: "service2", version: 1},
+			}),
+		},
+		{
+			desiredServices: getDesiredServices(services, []expectedService{
+				{serviceID: "service2", version: 1},
+				{serviceID: "service3", version: 1},
+			}),
+			processDesiredError: spaceallocator.ErrNoSpace,
+		},
+		{
+			desiredServices: getDesiredServices(services, []expectedService{
+				{serviceID: "service3", version: 1},
+			}),
+		},
+	}
+
+	for _, tCase := range cases {
+		if _, err := sm.ProcessDesiredServices(tCase.desiredServices); !errors.Is(err, tCase.processDesiredError) {
+			t.Errorf("Can't process desired service: %v", err)
+		}
+	}
+}
+
+func TestCachedServiceOnStart(t *testing.T) {
+	serviceStorage := &testServiceStorage{}
+
+	config := &config.Config{
+		ServicesDir:    filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir:    filepath.Join(tmpDir, "downloads"),
+		ServiceTTLDays: 2,
+	}
+
+	serviceAllocator = &testAllocator{
+		totalSize: 1 * megabyte,
+	}
+
+	sm, err := servicemanager.New(
+		config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier())
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+
+	services := make(map[string][]aostypes.ServiceInfo)
+
+	generateService := []struct {
+		serviceID      string
+		serviceContent string
+		serviceSize    uint64
+		version        int
+	}{
+		{serviceID: "service1", serviceContent: "service1", serviceSize: 512 * kilobyte, version: 1},
+		{serviceID: "service2", serviceContent: "service2", serviceSize: 256 * kilobyte, version: 1},
+		{serviceID: "service3", serviceContent: "service3", serviceSize: 300 * kilobyte, version: 1},
+	}
+
+	for _, service := range generateService {
+		serviceInfo, err := prepareService(
+			service.serviceContent, service.serviceID, uint64(service.version), int64(service.serviceSize))
+		if err != nil {
+			t.Fatalf("Can't prepare service: %v", err)
+		}
+
+		services[service.serviceID] = append(services[service.serviceID], serviceInfo)
+	}
+
+	cases := []struct {
+		desiredServices     []aostypes.ServiceInfo
+		processDesiredError error
+		useServiceID        expectedService
+	}{
+		{
+			desiredServices: getDesiredServices(services, []expectedService{
+				{serviceID: "service1", version: 1},
+				{serviceID: "service2", version: 1},
+			}),
+			useServiceID: expectedService{serviceID: "service1", version: 1},
+		},
+		{
+			desiredServices: getDesiredServices(services, []expectedService{
+				{serviceID: "service1", version: 1},
+				{serviceID: "service2", version: 1},
+				{serviceID: "service3", version: 1},
+			}),
+			processDesiredError: spaceallocator.ErrNoSpace,
+		},
+		{
+			desiredServices: getDesiredServices(services, []expectedService{
+				{serviceID: "service2", version: 1},
+			}),
+		},
+	}
+
+	for _, tCase := range cases {
+		if _, err := sm.ProcessDesiredServices(tCase.desiredServices); !errors.Is(err, tCase.processDesiredError) {
+			t.Errorf("Can't process desired service: %v", err)
+		}
+	}
+
+	sm.Close()
+
+	if sm, err = servicemanager.New(
+		config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier()); err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
+
+	if _, err := sm.GetServiceInfo("service1"); !errors.Is(err, servicemanager.ErrNotExist) {
+		t.Fatalf("Should be error not exist: %v", err)
+	}
+
+	cachedService, err := sm.GetServiceVersionInfo("service1", 1)
+	if err != nil {
+		t.Fatalf("Can't get cached service version: %v", err)
+	}
+
+	if !cachedService.Cached {
+		t.Error("Service should be cached")
+	}
+
+	if _, err := sm.GetServiceVersionInfo("service1", 2); !errors.Is(err, servicemanager.ErrNotExist) {
+		t.Errorf("Should be error not exist: %v", err)
+	}
+
+	if _, err := sm.ProcessDesiredServices(getDesiredServices(services, []expectedService{
+		{serviceID: "service2", version: 1},
+		{serviceID: "service3", version: 1},
+	})); err != nil {
+		t.Errorf("Can't process desired service: %v", err)
+	}
+}
+
+func TestFailCreateAllocator(t *testing.T) {
+	serviceAllocator = &testAllocator{
+		partLimit: 100,
+	}
+
+	serviceStorage := &testServiceStorage{}
+
+	config := &config.Config{
+		ServicesDir:       filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir:       filepath.Join(tmpDir, "downloads"),
+		ServicesPartLimit: 110,
+	}
+
+	if _, err := servicemanager.New(
+		config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier()); err == nil {
+		t.Fatal("Should be error creating allocator")
+	}
+}
+
+func TestRemoveServiceVersionOnInstall(t *testing.T) {
+	serviceStorage := &testServiceStorage{}
+
+	config := &config.Config{
+		ServicesDir:    filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir:    filepath.Join(tmpDir, "downloads"),
+		ServiceTTLDays: 2,
+	}
+
+	serviceAllocator = &testAllocator{}
+
+	sm, err := servicemanager.New(
+		config, serviceStorage, newTestDownloader(config.DownloadDir), newTestSignatureVerifier())
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
+
+	services := make(map[string][]aostypes.ServiceInfo)
+
+	generateService := []struct {
+		serviceID      string
+		serviceContent string
+		serviceSize    uint64
+		version        int
+	}{
+		{serviceID: "service1", serviceContent: "service1", serviceSize: 512 * kilobyte, version: 1},
+		{serviceID: "service1", serviceContent: "service2", serviceSize: 256 * kilobyte, version: 2},
+		{serviceID: "service1", serviceContent: "service3", serviceSize: 300 * kilobyte, version: 2},
+	}
+
+	for _, service := range generateService {
+		serviceInfo, err := prepareService(
+			service.serviceContent, service.serviceID, uint64(service.version), int64(service.serviceSize))
+		if err != nil {
+			t.Fatalf("Can't prepare service: %v", err)
+		}
+
+		services[service.serviceID] = append(services[service.serviceID], serviceInfo)
+	}
+
+	cases := []struct {
+		desiredServices      []aostypes.ServiceInfo
+		expectedServiceCount int
+	}{
+		{
+			desiredServices: getDesiredServices(services, []expectedService{
+				{serviceID: "service1", version: 1},
+			}),
+			expectedServiceCount: 1,
+		},
+		{
+			desiredServices: getDesiredServices(services, []expectedService{
+				{serviceID: "service1", version: 1},
+				{serviceID: "service1", version: 2},
+			}),
+			expectedServiceCount: 2,
+		},
+		{
+			desiredServices: getDesiredServices(services, []expectedService{
+				{serviceID: "service1", version: 1},
+				{serviceID: "service1", version: 2},
+				{serviceID: "service1", version: 3},
+			}),
+			expectedServiceCount: 2,
+		},
 	}
 
 	for _, tCase := range cases {
-		if err := sm.ProcessDesiredServices(tCase.desiredServices); err != nil {
+		if _, err := sm.ProcessDesiredServices(tCase.desiredServices); err != nil {
 			t.Errorf("Can't process desired service: %v", err)
 		}
 
@@ -721,160 +1531,418 @@ func TestRemoveServiceVersionOnInstall(t *testing.T) {
 	}
 }
 
-/***********************************************************************************************************************
-* Interfaces
-***********************************************************************************************************************/
+/***********************************************************************************************************************
+* Interfaces
+***********************************************************************************************************************/
+
+func newSpaceAllocator(
+	path string, partLimit uint, remover spaceallocator.ItemRemover,
+) (spaceallocator.Allocator, error) {
+	if serviceAllocator.partLimit < partLimit {
+		return nil, aoserrors.New("can't create space allocator")
+	}
+
+	if remover != nil {
+		serviceAllocator.remover = remover
+	}
+
+	return serviceAllocator, nil
+}
+
+func (allocator *testAllocator) AllocateSpace(size uint64) (spaceallocator.Space, error) {
+	allocator.Lock()
+	defer allocator.Unlock()
+
+	if allocator.totalSize != 0 && allocator.allocatedSize+size > allocator.totalSize {
+		for allocator.allocatedSize+size > allocator.totalSize {
+			if len(allocator.outdatedItems) == 0 {
+				return nil, spaceallocator.ErrNoSpace
+			}
+
+			if err := allocator.remover(allocator.outdatedItems[0].id); err != nil {
+				return nil, err
+			}
+
+			if allocator.outdatedItems[0].size < allocator.allocatedSize {
+				allocator.allocatedSize -= allocator.outdatedItems[0].size
+			} else {
+				allocator.allocatedSize = 0
+			}
+
+			allocator.outdatedItems = allocator.outdatedItems[1:]
+		}
+	}
+
+	allocator.allocatedSize += size
+
+	return &testSpace{allocator: allocator, size: size}, nil
+}
+
+func (allocator *testAllocator) FreeSpace(size uint64) {
+	allocator.Lock()
+	defer allocator.Unlock()
+
+	if size > allocator.allocatedSize {
+		allocator.allocatedSize = 0
+	} else {
+		allocator.allocatedSize -= size
+	}
+}
+
+func (allocator *testAllocator) AddOutdatedItem(id string, size uint64, timestamp time.Time) error {
+	allocator.outdatedItems = append(allocator.outdatedItems,
+		testOutdatedItem{id: id, size: size, timestamp: timestamp})
+
+	return nil
+}
+
+func (allocator *testAllocator) RestoreOutdatedItem(id string) {
+	for i, item := range allocator.outdatedItems {
+		if item.id == id {
+			allocator.outdatedItems = append(allocator.outdatedItems[:i], allocator.outdatedItems[i+1:]...)
+
+			break
+		}
+	}
+}
+
+func (allocator *testAllocator) Close() error {
+	return nil
+}
+
+func (space *testSpace) Accept() error {
+	return nil
+}
+
+func (space *testSpace) Release() error {
+	space.allocator.FreeSpace(space.size)
+
+	return nil
+}
+
+func (storage *testServiceStorage) GetAllServiceVersions(
+	serviceID string,
+) (service []servicemanager.ServiceInfo, err error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if serviceID == errorGetServicID {
+		return service, aoserrors.New("can't get service")
+	}
+
+	for _, storeService := range storage.Services {
+		if storeService.ServiceID == serviceID {
+			service = append(service, storeService)
+		}
+	}
+
+	if len(service) == 0 {
+		return service, servicemanager.ErrNotExist
+	}
+
+	return service, nil
+}
+
+func (storage *testServiceStorage) GetKnownGoodServiceVersion(serviceID string) (uint64, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	return storage.knownGood[serviceID], nil
+}
+
+func (storage *testServiceStorage) GetServices() (services []servicemanager.ServiceInfo, err error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if storage.getAllError {
+		return nil, aoserrors.New("can't get services")
+	}
+
+	return append([]servicemanager.ServiceInfo(nil), storage.Services...), nil
+}
+
+func (storage *testServiceStorage) AddService(service servicemanager.ServiceInfo) (err error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if service.ServiceID == errorAddServiceID {
+		return aoserrors.New("can't add service")
+	}
+
+	storage.Services = append(storage.Services, service)
+
+	return err
+}
+
+func (storage *testServiceStorage) RemoveService(serviceID string, aosVersion uint64) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	for i, outService := range storage.Services {
+		if outService.ServiceID == serviceID && outService.AosVersion == aosVersion {
+			storage.Services = append(storage.Services[:i], storage.Services[i+1:]...)
+
+			return nil
+		}
+	}
+
+	return servicemanager.ErrNotExist
+}
+
+func (storage *testServiceStorage) SetServiceCached(serviceID string, aosVersion uint64, cached bool) (err error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	var found bool
+
+	for i, serviceInfo := range storage.Services {
+		if serviceInfo.ServiceID == serviceID {
+			storage.Services[i].Cached = cached
+
+			found = true
+		}
+	}
+
+	if !found {
+		err = servicemanager.ErrNotExist
+	}
+
+	return err
+}
+
+func (storage *testServiceStorage) SetServiceDamaged(serviceID string, aosVersion uint64, damaged bool) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	for i, serviceInfo := range storage.Services {
+		if serviceInfo.ServiceID == serviceID && serviceInfo.AosVersion == aosVersion {
+			storage.Services[i].Damaged = damaged
+
+			return nil
+		}
+	}
+
+	return servicemanager.ErrNotExist
+}
+
+func (storage *testServiceStorage) AddFSImage(info fsimage.ImageInfo) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if storage.images == nil {
+		storage.images = make(map[string]fsimage.ImageInfo)
+	}
+
+	storage.images[info.MountPoint] = info
+
+	return nil
+}
+
+func (storage *testServiceStorage) GetFSImage(mountPoint string) (fsimage.ImageInfo, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	info, ok := storage.images[mountPoint]
+	if !ok {
+		return info, fsimage.ErrNotExist
+	}
+
+	return info, nil
+}
+
+func (storage *testServiceStorage) RemoveFSImage(mountPoint string) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if _, ok := storage.images[mountPoint]; !ok {
+		return fsimage.ErrNotExist
+	}
+
+	delete(storage.images, mountPoint)
+
+	return nil
+}
+
+func (storage *testServiceStorage) SetItemLastUsed(itemType, id string, lastUsed time.Time) error {
+	storage.Lock()
+	defer storage.Unlock()
 
-func newSpaceAllocator(
-	path string, partLimit uint, remover spaceallocator.ItemRemover,
-) (spaceallocator.Allocator, error) {
-	if serviceAllocator.partLimit < partLimit {
-		return nil, aoserrors.New("can't create space allocator")
+	if storage.usage == nil {
+		storage.usage = make(map[string]time.Time)
 	}
 
-	if remover != nil {
-		serviceAllocator.remover = remover
-	}
+	storage.usage[itemType+"/"+id] = lastUsed
 
-	return serviceAllocator, nil
+	return nil
 }
 
-func (allocator *testAllocator) AllocateSpace(size uint64) (spaceallocator.Space, error) {
-	allocator.Lock()
-	defer allocator.Unlock()
+func (storage *testServiceStorage) GetItemLastUsed(itemType, id string) (time.Time, error) {
+	storage.Lock()
+	defer storage.Unlock()
 
-	if allocator.totalSize != 0 && allocator.allocatedSize+size > allocator.totalSize {
-		for allocator.allocatedSize+size > allocator.totalSize {
-			if len(allocator.outdatedItems) == 0 {
-				return nil, spaceallocator.ErrNoSpace
-			}
+	lastUsed, ok := storage.usage[itemType+"/"+id]
+	if !ok {
+		return lastUsed, eviction.ErrNotExist
+	}
 
-			if err := allocator.remover(allocator.outdatedItems[0].id); err != nil {
-				return nil, err
-			}
+	return lastUsed, nil
+}
 
-			if allocator.outdatedItems[0].size < allocator.allocatedSize {
-				allocator.allocatedSize -= allocator.outdatedItems[0].size
-			} else {
-				allocator.allocatedSize = 0
-			}
+func (storage *testServiceStorage) AddEvictedItem(item eviction.EvictedItem, maxItems int) error {
+	storage.Lock()
+	defer storage.Unlock()
 
-			allocator.outdatedItems = allocator.outdatedItems[1:]
-		}
-	}
+	storage.evicted = append(storage.evicted, item)
 
-	allocator.allocatedSize += size
+	if len(storage.evicted) > maxItems {
+		storage.evicted = storage.evicted[len(storage.evicted)-maxItems:]
+	}
 
-	return &testSpace{allocator: allocator, size: size}, nil
+	return nil
 }
 
-func (allocator *testAllocator) FreeSpace(size uint64) {
-	allocator.Lock()
-	defer allocator.Unlock()
+func (storage *testServiceStorage) RemoveItemUsage(itemType, id string) error {
+	storage.Lock()
+	defer storage.Unlock()
 
-	if size > allocator.allocatedSize {
-		allocator.allocatedSize = 0
-	} else {
-		allocator.allocatedSize -= size
+	if _, ok := storage.usage[itemType+"/"+id]; !ok {
+		return eviction.ErrNotExist
 	}
-}
 
-func (allocator *testAllocator) AddOutdatedItem(id string, size uint64, timestamp time.Time) error {
-	allocator.outdatedItems = append(allocator.outdatedItems, testOutdatedItem{id: id, size: size})
+	delete(storage.usage, itemType+"/"+id)
 
 	return nil
 }
 
-func (allocator *testAllocator) RestoreOutdatedItem(id string) {
-	for i, item := range allocator.outdatedItems {
-		if item.id == id {
-			allocator.outdatedItems = append(allocator.outdatedItems[:i], allocator.outdatedItems[i+1:]...)
+func newTestSignatureVerifier(untrustedIDs ...string) *testSignatureVerifier {
+	return &testSignatureVerifier{untrustedIDs: untrustedIDs}
+}
 
-			break
+func (verifier *testSignatureVerifier) Verify(fileName string, image signature.Image) error {
+	if _, err := os.Stat(fileName); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	for _, untrustedID := range verifier.untrustedIDs {
+		if image.ID == untrustedID {
+			return aoserrors.Wrap(signature.ErrVerificationFailed)
 		}
 	}
-}
 
-func (allocator *testAllocator) Close() error {
 	return nil
 }
 
-func (space *testSpace) Accept() error {
-	return nil
+func newTestDownloader(downloadDir string) *testDownloader {
+	return &testDownloader{downloadDir: downloadDir, files: make(map[string]string)}
 }
 
-func (space *testSpace) Release() error {
-	space.allocator.FreeSpace(space.size)
+func (downloader *testDownloader) Download(
+	ctx context.Context, url string, fileInfo image.FileInfo,
+) (fileName string, err error) {
+	if fileName, err = image.Download(ctx, downloader.downloadDir, url); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
 
-	return nil
+	downloader.Lock()
+	defer downloader.Unlock()
+
+	downloader.files[url] = fileName
+
+	return fileName, nil
 }
 
-func (storage *testServiceStorage) GetAllServiceVersions(
-	serviceID string,
-) (service []servicemanager.ServiceInfo, err error) {
-	if serviceID == errorGetServicID {
-		return service, aoserrors.New("can't get service")
+func (downloader *testDownloader) DownloadWithHeader(
+	ctx context.Context, url string, header http.Header, fileInfo image.FileInfo,
+) (fileName string, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", aoserrors.Wrap(err)
 	}
 
-	for _, storeService := range storage.Services {
-		if storeService.ServiceID == serviceID {
-			service = append(service, storeService)
-		}
+	req.Header = header.Clone()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", aoserrors.Wrap(err)
 	}
+	defer resp.Body.Close()
 
-	if len(service) == 0 {
-		return service, servicemanager.ErrNotExist
+	if resp.StatusCode != http.StatusOK {
+		return "", aoserrors.Errorf("download failed: %s", resp.Status)
 	}
 
-	return service, nil
-}
+	file, err := os.CreateTemp(downloader.downloadDir, "")
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+	defer file.Close()
 
-func (storage *testServiceStorage) GetServices() (services []servicemanager.ServiceInfo, err error) {
-	if storage.getAllError {
-		return nil, aoserrors.New("can't get services")
+	if _, err = io.Copy(file, resp.Body); err != nil {
+		return "", aoserrors.Wrap(err)
 	}
 
-	return storage.Services, nil
+	downloader.Lock()
+	defer downloader.Unlock()
+
+	downloader.files[url] = file.Name()
+	downloader.pulledURLs = append(downloader.pulledURLs, url)
+
+	return file.Name(), nil
 }
 
-func (storage *testServiceStorage) AddService(service servicemanager.ServiceInfo) (err error) {
-	if service.ServiceID == errorAddServiceID {
-		return aoserrors.New("can't add service")
+func (downloader *testDownloader) Release(url string) error {
+	downloader.Lock()
+	defer downloader.Unlock()
+
+	fileName, ok := downloader.files[url]
+	if !ok {
+		return nil
 	}
 
-	storage.Services = append(storage.Services, service)
+	delete(downloader.files, url)
 
-	return err
+	return aoserrors.Wrap(os.RemoveAll(fileName))
 }
 
-func (storage *testServiceStorage) RemoveService(serviceID string, aosVersion uint64) error {
-	for i, outService := range storage.Services {
-		if outService.ServiceID == serviceID && outService.AosVersion == aosVersion {
-			storage.Services = append(storage.Services[:i], storage.Services[i+1:]...)
+func (downloader *testDownloader) Unpack(
+	ctx context.Context, url string, fileInfo image.FileInfo, destination string, allocator spaceallocator.Allocator,
+) (size uint64, space spaceallocator.Space, err error) {
+	fileName, err := downloader.Download(ctx, url, fileInfo)
+	if err != nil {
+		return 0, nil, err
+	}
 
-			return nil
+	defer func() {
+		if releaseErr := downloader.Release(url); releaseErr != nil && err == nil {
+			err = releaseErr
 		}
+	}()
+
+	if err = image.CheckFileInfo(ctx, fileName, fileInfo); err != nil {
+		return 0, nil, aoserrors.Wrap(err)
 	}
 
-	return servicemanager.ErrNotExist
-}
+	contentSize, err := image.GetUncompressedTarContentSize(fileName)
+	if err != nil {
+		return 0, nil, aoserrors.Wrap(err)
+	}
 
-func (storage *testServiceStorage) SetServiceCached(serviceID string, aosVersion uint64, cached bool) (err error) {
-	var found bool
+	if space, err = allocator.AllocateSpace(uint64(contentSize)); err != nil {
+		return 0, nil, aoserrors.Wrap(err)
+	}
 
-	for i, serviceInfo := range storage.Services {
-		if serviceInfo.ServiceID == serviceID {
-			storage.Services[i].Cached = cached
+	if err = image.UnpackTarImage(fileName, destination); err != nil {
+		_ = space.Release()
 
-			found = true
-		}
+		return 0, nil, aoserrors.Wrap(err)
 	}
 
-	if !found {
-		err = servicemanager.ErrNotExist
-	}
+	downloader.Lock()
+	defer downloader.Unlock()
 
-	return err
+	downloader.unpackedURLs = append(downloader.unpackedURLs, url)
+
+	return uint64(contentSize), space, nil
 }
 
 /***********************************************************************************************************************
@@ -1106,6 +2174,213 @@ func packImage(source, name string) (err error) {
 	return nil
 }
 
+func pushService(registryDir, repository string, serviceInfo aostypes.ServiceInfo) (digest.Digest, error) {
+	imageDir, err := os.MkdirTemp("", "aos_")
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	defer os.RemoveAll(imageDir)
+
+	if output, err := exec.Command(
+		"tar", "-C", imageDir, "-xf", strings.TrimPrefix(serviceInfo.URL, "file://")).CombinedOutput(); err != nil {
+		return "", aoserrors.Errorf("tar error: %s, code: %s", string(output), err)
+	}
+
+	var manifest testServiceManifest
+
+	manifestData, err := os.ReadFile(filepath.Join(imageDir, "manifest.json"))
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	if err = json.Unmarshal(manifestData, &manifest); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	// Registry verifies blob sizes, so put real blob sizes into the manifest
+	blobs := []*imagespec.Descriptor{&manifest.Config, &manifest.Layers[0], manifest.AosService}
+
+	blobsDir := filepath.Join(registryDir, "v2", repository, "blobs")
+
+	if err = os.MkdirAll(blobsDir, 0o755); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	for _, blob := range blobs {
+		data, err := os.ReadFile(filepath.Join(imageDir, blobsFolder, "sha256", blob.Digest.Hex()))
+		if err != nil {
+			return "", aoserrors.Wrap(err)
+		}
+
+		blob.Size = int64(len(data))
+
+		if err = os.WriteFile(filepath.Join(blobsDir, blob.Digest.String()), data, 0o600); err != nil {
+			return "", aoserrors.Wrap(err)
+		}
+	}
+
+	if manifestData, err = json.Marshal(manifest); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	manifestDigest := digest.FromBytes(manifestData)
+	manifestsDir := filepath.Join(registryDir, "v2", repository, "manifests")
+
+	if err = os.MkdirAll(manifestsDir, 0o755); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	if err = os.WriteFile(filepath.Join(manifestsDir, manifestDigest.String()), manifestData, 0o600); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	return manifestDigest, nil
+}
+
+// prepareDeltaService creates delta package which removes home/service.py and adds home/delta.py to the base rootfs.
+func prepareDeltaService(
+	baseImagePath string, baseManifestDigest []byte, serviceInfo aostypes.ServiceInfo, fullImageURL string,
+	wrongDigest bool,
+) (aostypes.ServiceInfo, error) {
+	deltaDir, err := os.MkdirTemp("", "aos_")
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	defer os.RemoveAll(deltaDir)
+
+	rootfsDir := filepath.Join(deltaDir, "rootfs")
+
+	if err = os.MkdirAll(filepath.Join(rootfsDir, "home"), 0o755); err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	if err = os.WriteFile(filepath.Join(rootfsDir, "home", "delta.py"), []byte("delta content"), 0o600); err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	rootfsHash, err := dirhash.HashDir(rootfsDir, rootfsDir, testDirDigest)
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	if wrongDigest {
+		rootfsHash = digest.FromString("wrong rootfs").String()
+	}
+
+	diffDigest, err := generateFsLayer(deltaDir, rootfsDir)
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	var manifest testServiceManifest
+
+	manifestData, err := os.ReadFile(filepath.Join(baseImagePath, "manifest.json"))
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	if err = json.Unmarshal(manifestData, &manifest); err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	manifest.Layers[0].Digest = digest.Digest(rootfsHash)
+
+	if manifestData, err = json.Marshal(manifest); err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	if err = os.WriteFile(filepath.Join(deltaDir, "manifest.json"), manifestData, 0o600); err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	var fullImage map[string]interface{}
+
+	if fullImageURL != "" {
+		urlVal, err := url.Parse(fullImageURL)
+		if err != nil {
+			return serviceInfo, aoserrors.Wrap(err)
+		}
+
+		fileInfo, err := image.CreateFileInfo(context.Background(), urlVal.Path)
+		if err != nil {
+			return serviceInfo, aoserrors.Wrap(err)
+		}
+
+		fullImage = map[string]interface{}{
+			"url": fullImageURL, "sha256": fileInfo.Sha256, "sha512": fileInfo.Sha512, "size": fileInfo.Size,
+		}
+	}
+
+	deltaData, err := json.Marshal(map[string]interface{}{
+		"baseManifestDigest": digest.NewDigestFromBytes(digest.SHA256, baseManifestDigest),
+		"rootfsDiff":         diffDigest,
+		"removed":            []string{"home/service.py"},
+		"fullImage":          fullImage,
+	})
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	if err = os.WriteFile(filepath.Join(deltaDir, "delta.json"), deltaData, 0o600); err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	imageFile, err := os.CreateTemp("", "aos_")
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	outputURL := imageFile.Name()
+	imageFile.Close()
+
+	if err = packImage(deltaDir, outputURL); err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	fileInfo, err := image.CreateFileInfo(context.Background(), outputURL)
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	serviceInfo.URL = "file://" + outputURL
+	serviceInfo.Sha256 = fileInfo.Sha256
+	serviceInfo.Sha512 = fileInfo.Sha512
+	serviceInfo.Size = fileInfo.Size
+
+	return serviceInfo, nil
+}
+
+func testDirDigest(files []string, open func(string) (io.ReadCloser, error)) (string, error) {
+	h := sha256.New()
+
+	files = append([]string(nil), files...)
+
+	sort.Strings(files)
+
+	for _, file := range files {
+		r, err := open(file)
+		if err != nil {
+			return "", aoserrors.Wrap(err)
+		}
+
+		hf := sha256.New()
+
+		_, err = io.Copy(hf, r)
+
+		r.Close()
+
+		if err != nil {
+			return "", aoserrors.Wrap(err)
+		}
+
+		fmt.Fprintf(h, "%x\n", hf.Sum(nil))
+	}
+
+	return digest.NewDigest("sha256", h).String(), nil
+}
+
 func getDesiredServices(
 	services map[string][]aostypes.ServiceInfo, expectedServices []expectedService,
 ) (desiredServices []aostypes.ServiceInfo) {
@@ -1125,3 +2400,15 @@ func getDesiredServices(
 
 	return
 }
+
+func copyDir(source, destination string) error {
+	if err := os.MkdirAll(destination, 0o755); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if output, err := exec.Command("cp", "-a", source+"/.", destination).CombinedOutput(); err != nil {
+		return aoserrors.Errorf("cp: %v: %s", err, output)
+	}
+
+	return nil
+}
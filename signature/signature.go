@@ -0,0 +1,236 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package signature verifies detached signatures of service and layer images.
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	"github.com/aosedge/aos_common/utils/cryptutils"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/config"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// SignatureFileExt extension of detached signature file. Signature file is placed next to the signed file.
+const SignatureFileExt = ".sig"
+
+// Signed image types.
+const (
+	ServiceImage = "service"
+	LayerImage   = "layer"
+)
+
+const coreComponent = "aos-servicemanager"
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// ErrVerificationFailed is returned when image is not signed or its signature is not trusted.
+var ErrVerificationFailed = errors.New("image signature verification failed")
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// AlertSender provides alert sender interface.
+type AlertSender interface {
+	SendAlert(alert cloudprotocol.AlertItem)
+}
+
+// Image signed image identification.
+type Image struct {
+	Type       string
+	ID         string
+	AosVersion uint64
+}
+
+// Signature detached signature. Signature is calculated over SHA256 of the signed file (over the file itself for
+// Ed25519 keys) with the key of the first certificate. Other certificates are intermediate certificates of chain.
+type Signature struct {
+	Signature    []byte `json:"signature"`
+	Certificates string `json:"certificates"`
+}
+
+// Verifier verifies image signatures against configured trust anchors.
+type Verifier struct {
+	roots       *x509.CertPool
+	alertSender AlertSender
+}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// New creates new signature verifier.
+func New(config *config.Config, alertSender AlertSender) (verifier *Verifier, err error) {
+	log.Debug("Create signature verifier")
+
+	verifier = &Verifier{alertSender: alertSender}
+
+	if len(config.ImageSignature.TrustAnchors) == 0 {
+		log.Warn("Image signature verification is disabled")
+
+		return verifier, nil
+	}
+
+	verifier.roots = x509.NewCertPool()
+
+	for _, trustAnchor := range config.ImageSignature.TrustAnchors {
+		certs, err := cryptutils.LoadCertificateFromFile(trustAnchor)
+		if err != nil {
+			return nil, aoserrors.Errorf("can't load trust anchor %s: %v", trustAnchor, err)
+		}
+
+		for _, cert := range certs {
+			verifier.roots.AddCert(cert)
+		}
+	}
+
+	return verifier, nil
+}
+
+// Verify verifies detached signature of the file. Alert is sent if image is not signed or its signature is not
+// trusted.
+func (verifier *Verifier) Verify(fileName string, image Image) error {
+	if verifier.roots == nil {
+		return nil
+	}
+
+	if err := verifier.verifySignature(fileName); err != nil {
+		log.WithFields(log.Fields{
+			"type": image.Type, "id": image.ID, "aosVersion": image.AosVersion,
+		}).Errorf("Image signature verification failed: %v", err)
+
+		verifier.sendAlert(image, err)
+
+		return aoserrors.Errorf("%w: %v", ErrVerificationFailed, err)
+	}
+
+	log.WithFields(log.Fields{
+		"type": image.Type, "id": image.ID, "aosVersion": image.AosVersion,
+	}).Debug("Image signature verified")
+
+	return nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (verifier *Verifier) verifySignature(fileName string) error {
+	signatureData, err := os.ReadFile(fileName + SignatureFileExt)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return aoserrors.New("image is not signed")
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	var signature Signature
+
+	if err = json.Unmarshal(signatureData, &signature); err != nil {
+		return aoserrors.Errorf("invalid signature: %v", err)
+	}
+
+	certs, err := cryptutils.PEMToX509Cert([]byte(signature.Certificates))
+	if err != nil || len(certs) == 0 {
+		return aoserrors.New("invalid signature certificates")
+	}
+
+	intermediates := x509.NewCertPool()
+
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	if _, err = certs[0].Verify(x509.VerifyOptions{
+		Roots:         verifier.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return aoserrors.Errorf("untrusted signer: %v", err)
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return checkSignature(certs[0].PublicKey, data, signature.Signature)
+}
+
+func checkSignature(publicKey crypto.PublicKey, data, signature []byte) error {
+	hash := sha256.Sum256(data)
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+			return aoserrors.Errorf("wrong signature: %v", err)
+		}
+
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hash[:], signature) {
+			return aoserrors.New("wrong signature")
+		}
+
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, data, signature) {
+			return aoserrors.New("wrong signature")
+		}
+
+	default:
+		return aoserrors.Errorf("unsupported signer key type: %T", publicKey)
+	}
+
+	return nil
+}
+
+func (verifier *Verifier) sendAlert(image Image, err error) {
+	if verifier.alertSender == nil {
+		return
+	}
+
+	verifier.alertSender.SendAlert(cloudprotocol.AlertItem{
+		Timestamp: time.Now(),
+		Tag:       cloudprotocol.AlertTagAosCore,
+		Payload: cloudprotocol.CoreAlert{
+			CoreComponent: coreComponent,
+			Message: fmt.Sprintf("%s %s version %d signature verification failed: %v",
+				image.Type, image.ID, image.AosVersion, err),
+		},
+	})
+}
@@ -0,0 +1,338 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signature_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"github.com/aosedge/aos_common/api/cloudprotocol"
+	"github.com/aosedge/aos_common/utils/cryptutils"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/config"
+	"github.com/aosedge/aos_servicemanager/signature"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type testAlertSender struct {
+	alerts []cloudprotocol.CoreAlert
+}
+
+type testSigner struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var tmpDir string
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Main
+ **********************************************************************************************************************/
+
+func TestMain(m *testing.M) {
+	var err error
+
+	if tmpDir, err = os.MkdirTemp("", "sm_"); err != nil {
+		log.Fatalf("Error create temporary dir: %v", err)
+	}
+
+	ret := m.Run()
+
+	if err = os.RemoveAll(tmpDir); err != nil {
+		log.Errorf("Error removing tmp dir: %v", err)
+	}
+
+	os.Exit(ret)
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestVerify(t *testing.T) {
+	rootCA, err := newTestSigner(nil, true, nil)
+	if err != nil {
+		t.Fatalf("Can't create root CA: %v", err)
+	}
+
+	intermediateCA, err := newTestSigner(rootCA, true, nil)
+	if err != nil {
+		t.Fatalf("Can't create intermediate CA: %v", err)
+	}
+
+	signer, err := newTestSigner(intermediateCA, false, nil)
+	if err != nil {
+		t.Fatalf("Can't create signer: %v", err)
+	}
+
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Can't generate key: %v", err)
+	}
+
+	ed25519Signer, err := newTestSigner(rootCA, false, ed25519Key)
+	if err != nil {
+		t.Fatalf("Can't create signer: %v", err)
+	}
+
+	untrustedCA, err := newTestSigner(nil, true, nil)
+	if err != nil {
+		t.Fatalf("Can't create untrusted CA: %v", err)
+	}
+
+	untrustedSigner, err := newTestSigner(untrustedCA, false, nil)
+	if err != nil {
+		t.Fatalf("Can't create untrusted signer: %v", err)
+	}
+
+	trustAnchor := filepath.Join(tmpDir, "root.pem")
+
+	if err = cryptutils.SaveCertificateToFile(trustAnchor, []*x509.Certificate{rootCA.cert}); err != nil {
+		t.Fatalf("Can't save trust anchor: %v", err)
+	}
+
+	alertSender := &testAlertSender{}
+
+	verifier, err := signature.New(&config.Config{
+		ImageSignature: config.ImageSignature{TrustAnchors: []string{trustAnchor}},
+	}, alertSender)
+	if err != nil {
+		t.Fatalf("Can't create verifier: %v", err)
+	}
+
+	fileName := filepath.Join(tmpDir, "manifest.json")
+
+	cases := []struct {
+		name          string
+		signer        *testSigner
+		chain         []*x509.Certificate
+		tamper        bool
+		expectedError error
+	}{
+		{name: "valid signature", signer: signer, chain: []*x509.Certificate{signer.cert, intermediateCA.cert}},
+		{name: "ed25519 signature", signer: ed25519Signer, chain: []*x509.Certificate{ed25519Signer.cert}},
+		{name: "unsigned", expectedError: signature.ErrVerificationFailed},
+		{
+			name: "no intermediate", signer: signer, chain: []*x509.Certificate{signer.cert},
+			expectedError: signature.ErrVerificationFailed,
+		},
+		{
+			name: "untrusted signer", signer: untrustedSigner,
+			chain:         []*x509.Certificate{untrustedSigner.cert, untrustedCA.cert},
+			expectedError: signature.ErrVerificationFailed,
+		},
+		{
+			name: "tampered file", signer: signer, chain: []*x509.Certificate{signer.cert, intermediateCA.cert},
+			tamper: true, expectedError: signature.ErrVerificationFailed,
+		},
+	}
+
+	for _, tCase := range cases {
+		t.Run(tCase.name, func(t *testing.T) {
+			alertSender.alerts = nil
+
+			if err := os.WriteFile(fileName, []byte(`{"schemaVersion":2}`), 0o600); err != nil {
+				t.Fatalf("Can't write file: %v", err)
+			}
+
+			if err := os.RemoveAll(fileName + signature.SignatureFileExt); err != nil {
+				t.Fatalf("Can't remove signature: %v", err)
+			}
+
+			if tCase.signer != nil {
+				if err := signFile(fileName, tCase.signer, tCase.chain); err != nil {
+					t.Fatalf("Can't sign file: %v", err)
+				}
+			}
+
+			if tCase.tamper {
+				if err := os.WriteFile(fileName, []byte(`{"schemaVersion":3}`), 0o600); err != nil {
+					t.Fatalf("Can't write file: %v", err)
+				}
+			}
+
+			err := verifier.Verify(fileName, signature.Image{Type: signature.ServiceImage, ID: "service1"})
+			if !errors.Is(err, tCase.expectedError) {
+				t.Errorf("Unexpected verify error: %v", err)
+			}
+
+			if tCase.expectedError != nil && len(alertSender.alerts) != 1 {
+				t.Errorf("Wrong alerts count: %d", len(alertSender.alerts))
+			}
+
+			if tCase.expectedError == nil && len(alertSender.alerts) != 0 {
+				t.Errorf("Unexpected alerts: %v", alertSender.alerts)
+			}
+		})
+	}
+}
+
+func TestVerificationDisabled(t *testing.T) {
+	verifier, err := signature.New(&config.Config{}, nil)
+	if err != nil {
+		t.Fatalf("Can't create verifier: %v", err)
+	}
+
+	if err = verifier.Verify(filepath.Join(tmpDir, "notExist"), signature.Image{}); err != nil {
+		t.Errorf("Verify error: %v", err)
+	}
+}
+
+func TestInvalidTrustAnchor(t *testing.T) {
+	if _, err := signature.New(&config.Config{
+		ImageSignature: config.ImageSignature{TrustAnchors: []string{filepath.Join(tmpDir, "notExist.pem")}},
+	}, nil); err == nil {
+		t.Error("Error expected")
+	}
+}
+
+/***********************************************************************************************************************
+ * Interfaces
+ **********************************************************************************************************************/
+
+func (sender *testAlertSender) SendAlert(alertItem cloudprotocol.AlertItem) {
+	if alert, ok := alertItem.Payload.(cloudprotocol.CoreAlert); ok {
+		sender.alerts = append(sender.alerts, alert)
+	}
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func newTestSigner(issuer *testSigner, isCA bool, key crypto.Signer) (*testSigner, error) {
+	if key == nil {
+		ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, aoserrors.Wrap(err)
+		}
+
+		key = ecdsaKey
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Aos test " + serial.String()},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+	}
+
+	if isCA {
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}
+	}
+
+	parent, parentKey := template, key
+
+	if issuer != nil {
+		parent, parentKey = issuer.cert, issuer.key
+	}
+
+	certData, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	cert, err := x509.ParseCertificate(certData)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return &testSigner{cert: cert, key: key}, nil
+}
+
+func signFile(fileName string, signer *testSigner, chain []*x509.Certificate) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	var signatureData []byte
+
+	if _, ok := signer.key.(ed25519.PrivateKey); ok {
+		if signatureData, err = signer.key.Sign(rand.Reader, data, crypto.Hash(0)); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	} else {
+		hash := sha256.Sum256(data)
+
+		if signatureData, err = signer.key.Sign(rand.Reader, hash[:], crypto.SHA256); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	var certificates []byte
+
+	for _, cert := range chain {
+		certificates = append(certificates, cryptutils.CertToPEM(cert)...)
+	}
+
+	signatureJSON, err := json.Marshal(signature.Signature{
+		Signature: signatureData, Certificates: string(certificates),
+	})
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return aoserrors.Wrap(os.WriteFile(fileName+signature.SignatureFileExt, signatureJSON, 0o600))
+}
@@ -0,0 +1,383 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	pb "github.com/aosedge/aos_common/api/servicemanager/v3"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/aosedge/aos_servicemanager/config"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	localServiceName      = "servicemanager.v3.SMLocalService"
+	localConnectStream    = "Connect"
+	localGetRunStatus     = "GetRunStatus"
+	localSecurityProtocol = "local"
+	localSendQueueSize    = 64
+)
+
+// Local API full method names.
+const (
+	LocalAPIConnectMethod      = "/" + localServiceName + "/" + localConnectStream
+	LocalAPIGetRunStatusMethod = "/" + localServiceName + "/" + localGetRunStatus
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type localAPI struct {
+	sync.Mutex
+
+	client      *SMClient
+	grpcServer  *grpc.Server
+	connections map[*localConnection]struct{}
+}
+
+type localConnection struct {
+	sendChannel chan *pb.SMOutgoingMessages
+}
+
+type peerCredentials struct {
+	allowedUIDs []uint32
+}
+
+type peerAuthInfo struct {
+	credentials.CommonAuthInfo
+	ucred unix.Ucred
+}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// NewLocalAPIStream opens local API stream. The stream accepts SMIncomingMessages and returns SMOutgoingMessages.
+func NewLocalAPIStream(ctx context.Context, connection *grpc.ClientConn) (grpc.ClientStream, error) {
+	stream, err := connection.NewStream(ctx, &grpc.StreamDesc{
+		StreamName: localConnectStream, ServerStreams: true, ClientStreams: true,
+	}, LocalAPIConnectMethod)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return stream, nil
+}
+
+// GetLocalRunStatus returns last run instances status by local API. Unlike local API stream, it doesn't subscribe to
+// service manager notifications.
+func GetLocalRunStatus(ctx context.Context, connection *grpc.ClientConn) (*pb.RunInstancesStatus, error) {
+	runStatus := &pb.RunInstancesStatus{}
+
+	if err := connection.Invoke(ctx, LocalAPIGetRunStatusMethod, &emptypb.Empty{}, runStatus); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return runStatus, nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func newLocalAPI(client *SMClient, apiConfig config.LocalAPI) (api *localAPI, err error) {
+	log.WithField("socket", apiConfig.Socket).Debug("Start local API server")
+
+	api = &localAPI{client: client, connections: make(map[*localConnection]struct{})}
+
+	if err = os.MkdirAll(filepath.Dir(apiConfig.Socket), 0o755); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if err = os.Remove(apiConfig.Socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	listener, err := net.Listen("unix", apiConfig.Socket)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	// Socket is accessible by other users only if they are explicitly allowed. Peer credentials are checked anyway.
+	var socketMode os.FileMode = 0o600
+
+	if len(apiConfig.AllowedUIDs) != 0 {
+		socketMode = 0o666
+	}
+
+	if err = os.Chmod(apiConfig.Socket, socketMode); err != nil {
+		listener.Close()
+
+		return nil, aoserrors.Wrap(err)
+	}
+
+	api.grpcServer = grpc.NewServer(grpc.Creds(&peerCredentials{allowedUIDs: apiConfig.AllowedUIDs}))
+
+	api.grpcServer.RegisterService(&grpc.ServiceDesc{
+		ServiceName: localServiceName,
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: localGetRunStatus,
+			Handler: func(
+				srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor,
+			) (interface{}, error) {
+				if err := dec(&emptypb.Empty{}); err != nil {
+					return nil, aoserrors.Wrap(err)
+				}
+
+				return srv.(*localAPI).getRunStatus(), nil //nolint:forcetypeassert // registered with localAPI only
+			},
+		}},
+		Streams: []grpc.StreamDesc{{
+			StreamName: localConnectStream,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(*localAPI).connect(stream) //nolint:forcetypeassert // registered with localAPI only
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		}},
+	}, api)
+
+	go func() {
+		if err := api.grpcServer.Serve(listener); err != nil {
+			log.Errorf("Can't serve local API: %v", err)
+		}
+	}()
+
+	return api, nil
+}
+
+func (api *localAPI) close() {
+	log.Debug("Stop local API server")
+
+	api.grpcServer.Stop()
+}
+
+func (api *localAPI) connect(stream grpc.ServerStream) error {
+	logFields := log.Fields{}
+
+	if streamPeer, ok := peer.FromContext(stream.Context()); ok {
+		if authInfo, ok := streamPeer.AuthInfo.(peerAuthInfo); ok {
+			logFields["pid"] = authInfo.ucred.Pid
+			logFields["uid"] = authInfo.ucred.Uid
+		}
+	}
+
+	log.WithFields(logFields).Info("Local API client connected")
+	defer log.WithFields(logFields).Info("Local API client disconnected")
+
+	connection := &localConnection{sendChannel: make(chan *pb.SMOutgoingMessages, localSendQueueSize)}
+
+	// Send current run status first as CM gets it on registration
+	api.client.Lock()
+
+	if runStatusMessage := api.client.getRunStatusMessage(); runStatusMessage != nil {
+		connection.sendChannel <- runStatusMessage
+	}
+
+	api.client.Unlock()
+
+	api.addConnection(connection)
+	defer api.removeConnection(connection)
+
+	var wg sync.WaitGroup
+
+	ctx, cancelFunc := context.WithCancel(stream.Context())
+
+	defer wg.Wait()
+	defer cancelFunc()
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		connection.sendMessages(ctx, stream)
+	}()
+
+	for {
+		message := &pb.SMIncomingMessages{}
+
+		if err := stream.RecvMsg(message); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, context.Canceled) {
+				return nil
+			}
+
+			return aoserrors.Wrap(err)
+		}
+
+		api.client.processMessage(message)
+	}
+}
+
+func (api *localAPI) getRunStatus() *pb.RunInstancesStatus {
+	api.client.Lock()
+	defer api.client.Unlock()
+
+	if api.client.runStatus == nil {
+		return &pb.RunInstancesStatus{}
+	}
+
+	return runInstanceStatusToPB(api.client.runStatus)
+}
+
+func (api *localAPI) addConnection(connection *localConnection) {
+	api.Lock()
+	api.connections[connection] = struct{}{}
+	api.Unlock()
+
+	api.client.notifyReceivers()
+}
+
+func (api *localAPI) removeConnection(connection *localConnection) {
+	api.Lock()
+	delete(api.connections, connection)
+	api.Unlock()
+
+	api.client.notifyReceivers()
+}
+
+func (api *localAPI) hasConnections() bool {
+	api.Lock()
+	defer api.Unlock()
+
+	return len(api.connections) != 0
+}
+
+func (api *localAPI) sendMessage(message *pb.SMOutgoingMessages) {
+	api.Lock()
+	defer api.Unlock()
+
+	for connection := range api.connections {
+		select {
+		case connection.sendChannel <- message:
+
+		default:
+			log.Warn("Local API send queue is full, message dropped")
+		}
+	}
+}
+
+func (connection *localConnection) sendMessages(ctx context.Context, stream grpc.ServerStream) {
+	for {
+		select {
+		case message := <-connection.sendChannel:
+			if err := stream.SendMsg(message); err != nil {
+				log.Errorf("Can't send local API message: %v", err)
+
+				return
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (creds *peerCredentials) ClientHandshake(
+	ctx context.Context, authority string, conn net.Conn,
+) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, aoserrors.New("client handshake is not supported")
+}
+
+func (creds *peerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, nil, aoserrors.New("connection is not unix socket")
+	}
+
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil, nil, aoserrors.Wrap(err)
+	}
+
+	var (
+		ucred    *unix.Ucred
+		ucredErr error
+	)
+
+	if err = rawConn.Control(func(fd uintptr) {
+		ucred, ucredErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return nil, nil, aoserrors.Wrap(err)
+	}
+
+	if ucredErr != nil {
+		return nil, nil, aoserrors.Wrap(ucredErr)
+	}
+
+	if !creds.uidAllowed(ucred.Uid) {
+		log.WithFields(log.Fields{"pid": ucred.Pid, "uid": ucred.Uid}).Warn("Local API access denied")
+
+		return nil, nil, aoserrors.Errorf("access denied for uid %d", ucred.Uid)
+	}
+
+	return conn, peerAuthInfo{
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+		ucred:          *ucred,
+	}, nil
+}
+
+func (creds *peerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: localSecurityProtocol}
+}
+
+func (creds *peerCredentials) Clone() credentials.TransportCredentials {
+	return &peerCredentials{allowedUIDs: append([]uint32(nil), creds.allowedUIDs...)}
+}
+
+func (creds *peerCredentials) OverrideServerName(string) error {
+	return nil
+}
+
+// uidAllowed checks peer uid: the same user as service manager and explicitly allowed users have access.
+func (creds *peerCredentials) uidAllowed(uid uint32) bool {
+	if uid == uint32(os.Geteuid()) {
+		return true
+	}
+
+	for _, allowedUID := range creds.allowedUIDs {
+		if uid == allowedUID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (authInfo peerAuthInfo) AuthType() string {
+	return localSecurityProtocol
+}
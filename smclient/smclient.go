@@ -50,6 +50,7 @@ import (
 const (
 	cmRequestTimeout   = 30 * time.Second
 	cmReconnectTimeout = 10 * time.Second
+	cmPendingQueueSize = 64
 )
 
 /***********************************************************************************************************************
@@ -78,6 +79,10 @@ type SMClient struct {
 	nodeDescription      NodeDescription
 	nodeMonitoringData   cloudprotocol.NodeMonitoringData
 	runStatus            *launcher.InstancesStatus
+	localAPI             *localAPI
+	pendingMessages      []*pb.SMOutgoingMessages
+	receiversChannel     chan struct{}
+	processMutex         sync.Mutex
 }
 
 type NodeDescription struct {
@@ -100,12 +105,12 @@ type UnitConfigProcessor interface {
 
 // ServicesProcessor process desired services list.
 type ServicesProcessor interface {
-	ProcessDesiredServices(services []aostypes.ServiceInfo) error
+	ProcessDesiredServices(services []aostypes.ServiceInfo) (statuses []cloudprotocol.ServiceStatus, err error)
 }
 
 // LayersProcessor process desired layer list.
 type LayersProcessor interface {
-	ProcessDesiredLayers(layers []aostypes.LayerInfo) error
+	ProcessDesiredLayers(layers []aostypes.LayerInfo) (statuses []cloudprotocol.LayerStatus, err error)
 }
 
 // InstanceLauncher service instances launcher interface.
@@ -159,10 +164,7 @@ func New(config *config.Config, nodeDescription NodeDescription, certificateProv
 		layersProcessor: layersProcessor, launcher: launcher, unitConfigProcessor: unitConfigProcessor,
 		monitoringProvider: monitoringProvider, logsProvider: logsProvider,
 		networkManager: networkManager, closeChannel: make(chan struct{}, 1),
-	}
-
-	if err := cmClient.createConnection(config, certificateProvider, cryptcoxontext, insecure); err != nil {
-		return nil, aoserrors.Wrap(err)
+		receiversChannel: make(chan struct{}, 1),
 	}
 
 	if cmClient.launcher != nil {
@@ -181,6 +183,24 @@ func New(config *config.Config, nodeDescription NodeDescription, certificateProv
 		cmClient.logsChannel = logsProvider.GetLogsDataChannel()
 	}
 
+	if config.LocalAPI.Socket != "" {
+		var err error
+
+		if cmClient.localAPI, err = newLocalAPI(cmClient, config.LocalAPI); err != nil {
+			return nil, aoserrors.Wrap(err)
+		}
+	}
+
+	if err := cmClient.createConnection(config, certificateProvider, cryptcoxontext, insecure); err != nil {
+		if cmClient.localAPI != nil {
+			cmClient.localAPI.close()
+		}
+
+		return nil, aoserrors.Wrap(err)
+	}
+
+	go cmClient.handleChannels()
+
 	return cmClient, nil
 }
 
@@ -188,8 +208,16 @@ func New(config *config.Config, nodeDescription NodeDescription, certificateProv
 func (client *SMClient) Close() (err error) {
 	log.Debug("Close SM client")
 
-	if client.stream != nil {
-		err = client.stream.CloseSend()
+	if client.localAPI != nil {
+		client.localAPI.close()
+	}
+
+	client.Lock()
+	stream := client.stream
+	client.Unlock()
+
+	if stream != nil {
+		err = stream.CloseSend()
 	}
 
 	if client.connection != nil {
@@ -233,10 +261,17 @@ func (client *SMClient) createConnection(
 		secureOpt = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
 	}
 
+	dialOptions := []grpc.DialOption{secureOpt}
+
+	// Don't wait for CM if local API is enabled as desired state may be set locally.
+	if client.localAPI == nil {
+		dialOptions = append(dialOptions, grpc.WithBlock())
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), cmRequestTimeout)
 	defer cancel()
 
-	if client.connection, err = grpc.DialContext(ctx, config.CMServerURL, secureOpt, grpc.WithBlock()); err != nil {
+	if client.connection, err = grpc.DialContext(ctx, config.CMServerURL, dialOptions...); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
@@ -276,12 +311,10 @@ func (client *SMClient) createConnection(
 }
 
 func (client *SMClient) register(config *config.Config) (err error) {
-	client.Lock()
-	defer client.Unlock()
-
 	log.Debug("Registering to CM...")
 
-	if client.stream, err = pb.NewSMServiceClient(client.connection).RegisterSM(context.Background()); err != nil {
+	stream, err := pb.NewSMServiceClient(client.connection).RegisterSM(context.Background())
+	if err != nil {
 		return aoserrors.Wrap(err)
 	}
 
@@ -298,31 +331,55 @@ func (client *SMClient) register(config *config.Config) (err error) {
 		}
 	}
 
-	if err := client.stream.Send(
+	if err := stream.Send(
 		&pb.SMOutgoingMessages{
 			SMOutgoingMessage: &pb.SMOutgoingMessages_NodeConfiguration{NodeConfiguration: &nodeCfg},
 		}); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
-	if client.runStatus != nil {
-		if err := client.sendRuntimeInstanceNotifications(launcher.RuntimeStatus{
-			RunStatus: client.runStatus,
-		}); err != nil {
-			return err
+	client.Lock()
+	defer client.Unlock()
+
+	if runStatusMessage := client.getRunStatusMessage(); runStatusMessage != nil {
+		if err := stream.Send(runStatusMessage); err != nil {
+			return aoserrors.Errorf("Can't send runtime status notification: %v", err)
 		}
 	}
 
+	// Send notifications which are handled while only local API clients are connected
+	for len(client.pendingMessages) > 0 {
+		if err := stream.Send(client.pendingMessages[0]); err != nil {
+			return aoserrors.Errorf("Can't send pending notification: %v", err)
+		}
+
+		client.pendingMessages = client.pendingMessages[1:]
+	}
+
+	client.stream = stream
+
 	log.Debug("Registered to CM")
 
-	go client.handleChannels()
+	client.notifyReceivers()
 
 	return nil
 }
 
 func (client *SMClient) processMessages() (err error) {
+	client.Lock()
+	stream := client.stream
+	client.Unlock()
+
+	defer func() {
+		client.Lock()
+		client.stream = nil
+		client.Unlock()
+
+		client.notifyReceivers()
+	}()
+
 	for {
-		message, err := client.stream.Recv()
+		message, err := stream.Recv()
 		if err != nil {
 			if code, ok := status.FromError(err); ok {
 				if code.Code() == codes.Canceled {
@@ -334,47 +391,54 @@ func (client *SMClient) processMessages() (err error) {
 			return aoserrors.Wrap(err)
 		}
 
-		switch data := message.GetSMIncomingMessage().(type) {
-		case *pb.SMIncomingMessages_GetUnitConfigStatus:
-			client.processGetUnitConfigStatus()
+		client.processMessage(message)
+	}
+}
+
+func (client *SMClient) processMessage(message *pb.SMIncomingMessages) {
+	client.processMutex.Lock()
+	defer client.processMutex.Unlock()
 
-		case *pb.SMIncomingMessages_CheckUnitConfig:
-			client.processCheckUnitConfig(data.CheckUnitConfig)
+	switch data := message.GetSMIncomingMessage().(type) {
+	case *pb.SMIncomingMessages_GetUnitConfigStatus:
+		client.processGetUnitConfigStatus()
 
-		case *pb.SMIncomingMessages_SetUnitConfig:
-			client.processSetUnitConfig(data.SetUnitConfig)
+	case *pb.SMIncomingMessages_CheckUnitConfig:
+		client.processCheckUnitConfig(data.CheckUnitConfig)
 
-		case *pb.SMIncomingMessages_RunInstances:
-			client.processRunInstances(data.RunInstances)
+	case *pb.SMIncomingMessages_SetUnitConfig:
+		client.processSetUnitConfig(data.SetUnitConfig)
 
-		case *pb.SMIncomingMessages_UpdateNetworks:
-			client.processUpdateNetworks(data.UpdateNetworks)
+	case *pb.SMIncomingMessages_RunInstances:
+		client.processRunInstances(data.RunInstances)
 
-		case *pb.SMIncomingMessages_SystemLogRequest:
-			client.processGetSystemLogRequest(data.SystemLogRequest)
+	case *pb.SMIncomingMessages_UpdateNetworks:
+		client.processUpdateNetworks(data.UpdateNetworks)
 
-		case *pb.SMIncomingMessages_InstanceLogRequest:
-			client.processGetInstanceLogRequest(data.InstanceLogRequest)
+	case *pb.SMIncomingMessages_SystemLogRequest:
+		client.processGetSystemLogRequest(data.SystemLogRequest)
 
-		case *pb.SMIncomingMessages_InstanceCrashLogRequest:
-			client.processGetInstanceCrashLogRequest(data.InstanceCrashLogRequest)
+	case *pb.SMIncomingMessages_InstanceLogRequest:
+		client.processGetInstanceLogRequest(data.InstanceLogRequest)
 
-		case *pb.SMIncomingMessages_OverrideEnvVars:
-			client.processOverrideEnvVars(data.OverrideEnvVars)
+	case *pb.SMIncomingMessages_InstanceCrashLogRequest:
+		client.processGetInstanceCrashLogRequest(data.InstanceCrashLogRequest)
 
-		case *pb.SMIncomingMessages_GetNodeMonitoring:
-			client.processNodeMonitoringData()
+	case *pb.SMIncomingMessages_OverrideEnvVars:
+		client.processOverrideEnvVars(data.OverrideEnvVars)
 
-		case *pb.SMIncomingMessages_ConnectionStatus:
-			client.processConnectionStatus(data.ConnectionStatus)
-		}
+	case *pb.SMIncomingMessages_GetNodeMonitoring:
+		client.processNodeMonitoringData()
+
+	case *pb.SMIncomingMessages_ConnectionStatus:
+		client.processConnectionStatus(data.ConnectionStatus)
 	}
 }
 
 func (client *SMClient) processGetUnitConfigStatus() {
 	status := &pb.UnitConfigStatus{VendorVersion: client.unitConfigProcessor.GetUnitConfigInfo()}
 
-	if err := client.stream.Send(&pb.SMOutgoingMessages{
+	if err := client.sendMessage(&pb.SMOutgoingMessages{
 		SMOutgoingMessage: &pb.SMOutgoingMessages_UnitConfigStatus{UnitConfigStatus: status},
 	}); err != nil {
 		log.Errorf("Can't send unit config status: %v", err)
@@ -388,7 +452,7 @@ func (client *SMClient) processCheckUnitConfig(check *pb.CheckUnitConfig) {
 		status.Error = err.Error()
 	}
 
-	if err := client.stream.Send(&pb.SMOutgoingMessages{
+	if err := client.sendMessage(&pb.SMOutgoingMessages{
 		SMOutgoingMessage: &pb.SMOutgoingMessages_UnitConfigStatus{UnitConfigStatus: status},
 	}); err != nil {
 		log.Errorf("Can't send unit config status: %v", err)
@@ -402,7 +466,7 @@ func (client *SMClient) processSetUnitConfig(config *pb.SetUnitConfig) {
 		status.Error = err.Error()
 	}
 
-	if err := client.stream.Send(&pb.SMOutgoingMessages{
+	if err := client.sendMessage(&pb.SMOutgoingMessages{
 		SMOutgoingMessage: &pb.SMOutgoingMessages_UnitConfigStatus{UnitConfigStatus: status},
 	}); err != nil {
 		log.Errorf("Can't send unit config status: %v", err)
@@ -442,10 +506,19 @@ func (client *SMClient) processRunInstances(runInstances *pb.RunInstances) {
 		}
 	}
 
-	if err := client.servicesProcessor.ProcessDesiredServices(services); err != nil {
+	serviceStatuses, err := client.servicesProcessor.ProcessDesiredServices(services)
+	if err != nil {
 		log.Errorf("Can't process desired services list %v", err)
 	}
 
+	for _, status := range serviceStatuses {
+		if status.Status == cloudprotocol.ErrorStatus && status.ErrorInfo != nil {
+			log.WithFields(log.Fields{
+				"id": status.ID, "aosVersion": status.AosVersion,
+			}).Errorf("Can't install service: %s", status.ErrorInfo.Message)
+		}
+	}
+
 	layers := make([]aostypes.LayerInfo, len(runInstances.GetLayers()))
 
 	for i, pbLayer := range runInstances.GetLayers() {
@@ -460,10 +533,19 @@ func (client *SMClient) processRunInstances(runInstances *pb.RunInstances) {
 		}
 	}
 
-	if err := client.layersProcessor.ProcessDesiredLayers(layers); err != nil {
+	layerStatuses, err := client.layersProcessor.ProcessDesiredLayers(layers)
+	if err != nil {
 		log.Errorf("Can't process desired layer list %v", err)
 	}
 
+	for _, status := range layerStatuses {
+		if status.Status == cloudprotocol.ErrorStatus && status.ErrorInfo != nil {
+			log.WithFields(log.Fields{
+				"id": status.ID, "aosVersion": status.AosVersion, "digest": status.Digest,
+			}).Errorf("Can't install layer: %s", status.ErrorInfo.Message)
+		}
+	}
+
 	instances := make([]aostypes.InstanceInfo, len(runInstances.GetInstances()))
 
 	for i, pbInstance := range runInstances.GetInstances() {
@@ -552,7 +634,7 @@ func (client *SMClient) processOverrideEnvVars(envVars *pb.OverrideEnvVars) {
 		}
 	}
 
-	if err := client.stream.Send(&pb.SMOutgoingMessages{
+	if err := client.sendMessage(&pb.SMOutgoingMessages{
 		SMOutgoingMessage: &pb.SMOutgoingMessages_OverrideEnvVarStatus{OverrideEnvVarStatus: statuses},
 	}); err != nil {
 		log.Errorf("Can't send roverride env vars status: %v", err)
@@ -560,7 +642,7 @@ func (client *SMClient) processOverrideEnvVars(envVars *pb.OverrideEnvVars) {
 }
 
 func (client *SMClient) processNodeMonitoringData() {
-	if err := client.stream.Send(
+	if err := client.sendMessage(
 		&pb.SMOutgoingMessages{
 			SMOutgoingMessage: &pb.SMOutgoingMessages_NodeMonitoring{
 				NodeMonitoring: cloudprotocolMonitoringToPB(client.nodeMonitoringData),
@@ -578,16 +660,27 @@ func (client *SMClient) processConnectionStatus(status *pb.ConnectionStatus) {
 
 func (client *SMClient) handleChannels() {
 	for {
+		// Keep notifications in channels till CM or local API client is connected
+		if !client.hasReceivers() {
+			select {
+			case <-client.receiversChannel:
+				continue
+
+			case <-client.closeChannel:
+				return
+			}
+		}
+
 		select {
 		case runtimeStatus := <-client.runtimeStatusChannel:
 			if runtimeStatus.RunStatus != nil {
+				client.Lock()
 				client.runStatus = runtimeStatus.RunStatus
+				client.Unlock()
 			}
 
 			if err := client.sendRuntimeInstanceNotifications(runtimeStatus); err != nil {
 				log.Errorf("Can't send runtime instance notification: %v", err)
-
-				return
 			}
 
 		case alert := <-client.alertChannel:
@@ -600,37 +693,33 @@ func (client *SMClient) handleChannels() {
 
 			alertNtf := &pb.SMOutgoingMessages_Alert{Alert: pbAlert}
 
-			if err := client.stream.Send(&pb.SMOutgoingMessages{SMOutgoingMessage: alertNtf}); err != nil {
+			if err := client.sendMessage(&pb.SMOutgoingMessages{SMOutgoingMessage: alertNtf}); err != nil {
 				log.Errorf("Can't send alert: %v", err)
-
-				return
 			}
 
 		case monitoringData := <-client.monitoringChannel:
 			client.nodeMonitoringData = monitoringData
 
-			if err := client.stream.Send(
+			if err := client.sendMessage(
 				&pb.SMOutgoingMessages{
 					SMOutgoingMessage: &pb.SMOutgoingMessages_NodeMonitoring{
 						NodeMonitoring: cloudprotocolMonitoringToPB(monitoringData),
 					},
 				}); err != nil {
 				log.Errorf("Can't send monitoring notification: %v", err)
-
-				return
 			}
 
 		case logs := <-client.logsChannel:
-			if err := client.stream.Send(
+			if err := client.sendMessage(
 				&pb.SMOutgoingMessages{
 					SMOutgoingMessage: &pb.SMOutgoingMessages_Log{Log: cloudprotocolLogToPB(logs)},
 				}); err != nil {
 				log.Errorf("Can't send logs: %v", err)
-
-				return
 			}
 
-		case <-client.stream.Context().Done():
+		case <-client.receiversChannel:
+
+		case <-client.closeChannel:
 			return
 		}
 	}
@@ -642,7 +731,7 @@ func (client *SMClient) sendRuntimeInstanceNotifications(runtimeStatus launcher.
 			RunInstancesStatus: runInstanceStatusToPB(runtimeStatus.RunStatus),
 		}
 
-		if err := client.stream.Send(&pb.SMOutgoingMessages{SMOutgoingMessage: runStatusNtf}); err != nil {
+		if err := client.sendMessage(&pb.SMOutgoingMessages{SMOutgoingMessage: runStatusNtf}); err != nil {
 			return aoserrors.Errorf("Can't send runtime status notification: %v", err)
 		}
 	}
@@ -652,7 +741,7 @@ func (client *SMClient) sendRuntimeInstanceNotifications(runtimeStatus launcher.
 			UpdateInstancesStatus: updateInstanceStatusToPB(runtimeStatus.UpdateStatus),
 		}
 
-		if err := client.stream.Send(&pb.SMOutgoingMessages{SMOutgoingMessage: updateStatusNtf}); err != nil {
+		if err := client.sendMessage(&pb.SMOutgoingMessages{SMOutgoingMessage: updateStatusNtf}); err != nil {
 			return aoserrors.Errorf("Can't send update status notification: %v", err)
 		}
 	}
@@ -660,6 +749,81 @@ func (client *SMClient) sendRuntimeInstanceNotifications(runtimeStatus launcher.
 	return nil
 }
 
+// sendMessage sends message to all local API clients and to CM. The message is kept till CM is connected.
+func (client *SMClient) sendMessage(message *pb.SMOutgoingMessages) error {
+	if client.localAPI != nil {
+		client.localAPI.sendMessage(message)
+	}
+
+	client.Lock()
+	defer client.Unlock()
+
+	if client.stream == nil {
+		client.addPendingMessage(message)
+
+		return nil
+	}
+
+	if err := client.stream.Send(message); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+// addPendingMessage keeps message to be sent on CM registration. Should be called under client lock.
+func (client *SMClient) addPendingMessage(message *pb.SMOutgoingMessages) {
+	// Run instances status is sent on registration and overrides previous instances status updates
+	if message.GetRunInstancesStatus() != nil {
+		pendingMessages := make([]*pb.SMOutgoingMessages, 0, len(client.pendingMessages))
+
+		for _, pendingMessage := range client.pendingMessages {
+			if pendingMessage.GetUpdateInstancesStatus() == nil {
+				pendingMessages = append(pendingMessages, pendingMessage)
+			}
+		}
+
+		client.pendingMessages = pendingMessages
+
+		return
+	}
+
+	if len(client.pendingMessages) >= cmPendingQueueSize {
+		log.Warn("CM pending queue is full, the oldest message dropped")
+
+		client.pendingMessages = client.pendingMessages[1:]
+	}
+
+	client.pendingMessages = append(client.pendingMessages, message)
+}
+
+func (client *SMClient) hasReceivers() bool {
+	client.Lock()
+	connected := client.stream != nil
+	client.Unlock()
+
+	return connected || (client.localAPI != nil && client.localAPI.hasConnections())
+}
+
+func (client *SMClient) notifyReceivers() {
+	select {
+	case client.receiversChannel <- struct{}{}:
+
+	default:
+	}
+}
+
+// getRunStatusMessage returns last run instances status message. Should be called under client lock.
+func (client *SMClient) getRunStatusMessage() *pb.SMOutgoingMessages {
+	if client.runStatus == nil {
+		return nil
+	}
+
+	return &pb.SMOutgoingMessages{SMOutgoingMessage: &pb.SMOutgoingMessages_RunInstancesStatus{
+		RunInstancesStatus: runInstanceStatusToPB(client.runStatus),
+	}}
+}
+
 func runInstanceStatusToPB(runStatus *launcher.InstancesStatus) *pb.RunInstancesStatus {
 	pbStatus := &pb.RunInstancesStatus{Instances: make([]*pb.InstanceStatus, len(runStatus.Instances))}
 
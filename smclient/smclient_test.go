@@ -18,10 +18,12 @@
 package smclient_test
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -32,6 +34,7 @@ import (
 	pb "github.com/aosedge/aos_common/api/servicemanager/v3"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
@@ -101,8 +104,9 @@ type testLauncher struct {
 	envVarsInfo   []cloudprotocol.EnvVarsInstanceInfo
 	envVarsStatus []cloudprotocol.EnvVarsInstanceStatus
 
-	callChannel       chan struct{}
-	connectionChannel chan bool
+	callChannel          chan struct{}
+	connectionChannel    chan bool
+	runtimeStatusChannel chan launcher.RuntimeStatus
 }
 
 /***********************************************************************************************************************
@@ -1015,6 +1019,178 @@ func TestCloudConnection(t *testing.T) {
 	}
 }
 
+func TestLocalAPI(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "sm.sock")
+
+	serviceManager := &testServiceManager{}
+	layerManager := &testLayerManager{}
+	testLauncher := newTestLauncher()
+	testMonitoring := &testMonitoringProvider{
+		monitoringChannel: make(chan cloudprotocol.NodeMonitoringData, 10),
+	}
+
+	// CM is not available: client should be created and served by local API
+
+	client, err := smclient.New(&config.Config{CMServerURL: serverURL, LocalAPI: config.LocalAPI{Socket: socketPath}},
+		smclient.NodeDescription{NodeID: "mainSM", NodeType: "model1", SystemInfo: cloudprotocol.SystemInfo{}},
+		nil, serviceManager, layerManager, testLauncher, nil, nil, testMonitoring, nil, nil, nil, true)
+	if err != nil {
+		t.Fatalf("Can't create SM client: %v", err)
+	}
+	defer client.Close()
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("Can't stat local API socket: %v", err)
+	}
+
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Wrong local API socket mode: %v", info.Mode().Perm())
+	}
+
+	connection, err := grpc.Dial("unix://"+socketPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Can't connect to local API: %v", err)
+	}
+	defer connection.Close()
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	// Run status request doesn't subscribe to notifications: run status is kept in launcher channel
+
+	instanceStatus := cloudprotocol.InstanceStatus{
+		InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0"},
+		AosVersion:    1, RunState: cloudprotocol.InstanceStateActive,
+	}
+
+	testLauncher.runtimeStatusChannel <- launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: []cloudprotocol.InstanceStatus{instanceStatus}},
+	}
+
+	runStatus, err := smclient.GetLocalRunStatus(ctx, connection)
+	if err != nil {
+		t.Fatalf("Can't get run status: %v", err)
+	}
+
+	if len(runStatus.GetInstances()) != 0 {
+		t.Errorf("Unexpected run status: %v", runStatus)
+	}
+
+	stream, err := smclient.NewLocalAPIStream(ctx, connection)
+	if err != nil {
+		t.Fatalf("Can't open local API stream: %v", err)
+	}
+
+	expectedRunStatus := &pb.RunInstancesStatus{Instances: []*pb.InstanceStatus{{
+		Instance:   &pb.InstanceIdent{ServiceId: "service0", SubjectId: "subject0"},
+		AosVersion: 1, RunState: cloudprotocol.InstanceStateActive,
+	}}}
+
+	message := &pb.SMOutgoingMessages{}
+
+	if err = stream.RecvMsg(message); err != nil {
+		t.Fatalf("Can't receive message: %v", err)
+	}
+
+	if !proto.Equal(message.GetRunInstancesStatus(), expectedRunStatus) {
+		t.Errorf("Unexpected message: %v", message)
+	}
+
+	if runStatus, err = smclient.GetLocalRunStatus(ctx, connection); err != nil {
+		t.Fatalf("Can't get run status: %v", err)
+	}
+
+	if !proto.Equal(runStatus, expectedRunStatus) {
+		t.Errorf("Wrong run status: %v", runStatus)
+	}
+
+	runInstances := &pb.RunInstances{
+		Services: []*pb.ServiceInfo{{
+			VersionInfo: &pb.VersionInfo{AosVersion: 1}, ServiceId: "service1", ProviderId: "provider1",
+			Url: "file:///var/aos/service1.tar.gz",
+		}},
+		Layers: []*pb.LayerInfo{{
+			VersionInfo: &pb.VersionInfo{AosVersion: 1}, LayerId: "layer1", Digest: "digest1",
+			Url: "file:///var/aos/layer1.tar.gz",
+		}},
+		Instances: []*pb.InstanceInfo{{
+			Instance: &pb.InstanceIdent{ServiceId: "service1", SubjectId: "subject1"},
+			NetworkParameters: &pb.NetworkParameters{
+				Ip: "172.17.0.1", Subnet: "172.17.0.0/16", DnsServers: []string{"10.10.2.1"},
+			},
+			Uid: 5000,
+		}},
+	}
+
+	if err = stream.SendMsg(&pb.SMIncomingMessages{
+		SMIncomingMessage: &pb.SMIncomingMessages_RunInstances{RunInstances: runInstances},
+	}); err != nil {
+		t.Fatalf("Can't send request: %v", err)
+	}
+
+	if err = testLauncher.waitCall(); err != nil {
+		t.Fatalf("Error waiting call: %v", err)
+	}
+
+	services, layers, instances, _ := convertRunInstancesReq(runInstances)
+
+	if !reflect.DeepEqual(serviceManager.services, services) {
+		t.Errorf("Wrong services: %v", serviceManager.services)
+	}
+
+	if !reflect.DeepEqual(layerManager.layers, layers) {
+		t.Errorf("Wrong layers: %v", layerManager.layers)
+	}
+
+	if !reflect.DeepEqual(testLauncher.instances, instances) {
+		t.Errorf("Wrong instances: %v expected %v", testLauncher.instances, instances)
+	}
+
+	// Outgoing messages should be streamed to local API client
+
+	testMonitoring.monitoringChannel <- cloudprotocol.NodeMonitoringData{
+		MonitoringData: cloudprotocol.MonitoringData{RAM: 10, CPU: 20},
+	}
+
+	message = &pb.SMOutgoingMessages{}
+
+	if err = stream.RecvMsg(message); err != nil {
+		t.Fatalf("Can't receive message: %v", err)
+	}
+
+	monitoring := message.GetNodeMonitoring()
+	if monitoring == nil {
+		t.Fatalf("Unexpected message: %v", message)
+	}
+
+	if monitoring.GetMonitoringData().GetRam() != 10 || monitoring.GetMonitoringData().GetCpu() != 20 {
+		t.Errorf("Wrong monitoring data: %v", monitoring.GetMonitoringData())
+	}
+
+	// Notifications handled by local API should be sent to CM when it is connected
+
+	server, err := newTestServer(serverURL)
+	if err != nil {
+		t.Fatalf("Can't create test server: %v", err)
+	}
+	defer server.close()
+
+	if err = server.waitClientRegistered(&pb.NodeConfiguration{NodeId: "mainSM", NodeType: "model1"}); err != nil {
+		t.Fatalf("SM registration error: %v", err)
+	}
+
+	select {
+	case cmMonitoring := <-server.monitoringChannel:
+		if !proto.Equal(cmMonitoring.NodeMonitoring, monitoring) {
+			t.Errorf("Wrong monitoring data: %v", cmMonitoring.NodeMonitoring)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Error("Wait monitoring data timeout")
+	}
+}
+
 /***********************************************************************************************************************
  * Private
  **********************************************************************************************************************/
@@ -1280,16 +1456,20 @@ func (alerts *testAlertProvider) GetAlertsChannel() (channel <-chan cloudprotoco
 	return alerts.alertsChannel
 }
 
-func (processor *testServiceManager) ProcessDesiredServices(services []aostypes.ServiceInfo) error {
+func (processor *testServiceManager) ProcessDesiredServices(
+	services []aostypes.ServiceInfo,
+) ([]cloudprotocol.ServiceStatus, error) {
 	processor.services = services
 
-	return nil
+	return nil, nil
 }
 
-func (processor *testLayerManager) ProcessDesiredLayers(layers []aostypes.LayerInfo) error {
+func (processor *testLayerManager) ProcessDesiredLayers(
+	layers []aostypes.LayerInfo,
+) ([]cloudprotocol.LayerStatus, error) {
 	processor.layers = layers
 
-	return nil
+	return nil, nil
 }
 
 func (networkmanager *testNetworkUpdates) UpdateNetworks(networkParameters []aostypes.NetworkParameters) error {
@@ -1310,7 +1490,10 @@ func (networkmanager *testNetworkUpdates) waitCall() error {
 }
 
 func newTestLauncher() *testLauncher {
-	return &testLauncher{callChannel: make(chan struct{}, 1), connectionChannel: make(chan bool, 1)}
+	return &testLauncher{
+		callChannel: make(chan struct{}, 1), connectionChannel: make(chan bool, 1),
+		runtimeStatusChannel: make(chan launcher.RuntimeStatus, 1),
+	}
 }
 
 func (launcher *testLauncher) RunInstances(instances []aostypes.InstanceInfo, forceRestart bool) error {
@@ -1323,7 +1506,7 @@ func (launcher *testLauncher) RunInstances(instances []aostypes.InstanceInfo, fo
 }
 
 func (launcher *testLauncher) RuntimeStatusChannel() <-chan launcher.RuntimeStatus {
-	return nil
+	return launcher.runtimeStatusChannel
 }
 
 func (launcher *testLauncher) OverrideEnvVars(
@@ -0,0 +1,265 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cron provides parser of standard five fields cron expressions.
+package cron
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	numFields = 5
+	// Next time is searched within this period, longer periods mean the expression never matches
+	maxSearchYears = 5
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// Expression parsed cron expression.
+type Expression struct {
+	minutes    uint64
+	hours      uint64
+	days       uint64
+	months     uint64
+	weekdays   uint64
+	anyDay     bool
+	anyWeekday bool
+}
+
+type fieldRange struct {
+	min   int
+	max   int
+	names map[string]int
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+//nolint:gochecknoglobals // cron predefined expressions
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+//nolint:gochecknoglobals // cron fields ranges
+var (
+	minuteRange = fieldRange{min: 0, max: 59}
+	hourRange   = fieldRange{min: 0, max: 23}
+	dayRange    = fieldRange{min: 1, max: 31}
+	monthRange  = fieldRange{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is Sunday as well as 0
+	weekdayRange = fieldRange{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// Parse parses cron expression: "minute hour day-of-month month day-of-week". Each field accepts "*", values, ranges,
+// steps and comma separated lists of them. Months and weekdays accept three letters names. Predefined expressions
+// such as @daily or @hourly are supported as well.
+func Parse(expression string) (*Expression, error) {
+	if macro, ok := macros[strings.TrimSpace(expression)]; ok {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != numFields {
+		return nil, aoserrors.Errorf("wrong cron expression fields count: %s", expression)
+	}
+
+	var (
+		cronExpression Expression
+		err            error
+	)
+
+	if cronExpression.minutes, err = parseField(fields[0], minuteRange); err != nil {
+		return nil, err
+	}
+
+	if cronExpression.hours, err = parseField(fields[1], hourRange); err != nil {
+		return nil, err
+	}
+
+	if cronExpression.days, err = parseField(fields[2], dayRange); err != nil {
+		return nil, err
+	}
+
+	if cronExpression.months, err = parseField(fields[3], monthRange); err != nil {
+		return nil, err
+	}
+
+	if cronExpression.weekdays, err = parseField(fields[4], weekdayRange); err != nil {
+		return nil, err
+	}
+
+	if cronExpression.weekdays&(1<<7) != 0 {
+		cronExpression.weekdays |= 1
+	}
+
+	cronExpression.anyDay = strings.HasPrefix(fields[2], "*")
+	cronExpression.anyWeekday = strings.HasPrefix(fields[4], "*")
+
+	return &cronExpression, nil
+}
+
+// Next returns the first time matching the expression after the specified time. Zero time is returned if there is no
+// such time.
+func (expression *Expression) Next(after time.Time) time.Time {
+	next := after.Truncate(time.Minute).Add(time.Minute)
+	limit := next.AddDate(maxSearchYears, 0, 0)
+
+	for next.Before(limit) {
+		if expression.months&(1<<uint(next.Month())) == 0 {
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+
+			continue
+		}
+
+		if !expression.matchDay(next) {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+
+			continue
+		}
+
+		if expression.hours&(1<<uint(next.Hour())) == 0 {
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+
+			continue
+		}
+
+		if expression.minutes&(1<<uint(next.Minute())) == 0 {
+			next = next.Truncate(time.Minute).Add(time.Minute)
+
+			continue
+		}
+
+		return next
+	}
+
+	return time.Time{}
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// matchDay matches day of month and day of week. As in standard cron, if both fields are restricted, the day matches
+// if any of them matches.
+func (expression *Expression) matchDay(date time.Time) bool {
+	dayMatch := expression.days&(1<<uint(date.Day())) != 0
+	weekdayMatch := expression.weekdays&(1<<uint(date.Weekday())) != 0
+
+	if expression.anyDay || expression.anyWeekday {
+		return dayMatch && weekdayMatch
+	}
+
+	return dayMatch || weekdayMatch
+}
+
+func parseField(field string, valueRange fieldRange) (bits uint64, err error) {
+	for _, item := range strings.Split(field, ",") {
+		itemBits, err := parseItem(item, valueRange)
+		if err != nil {
+			return 0, err
+		}
+
+		bits |= itemBits
+	}
+
+	return bits, nil
+}
+
+func parseItem(item string, valueRange fieldRange) (bits uint64, err error) {
+	rangeItem, stepItem, hasStep := strings.Cut(item, "/")
+	step := 1
+
+	if hasStep {
+		if step, err = strconv.Atoi(stepItem); err != nil || step <= 0 {
+			return 0, aoserrors.Errorf("wrong cron step: %s", item)
+		}
+	}
+
+	start, end := valueRange.min, valueRange.max
+
+	if rangeItem != "*" {
+		startItem, endItem, isRange := strings.Cut(rangeItem, "-")
+
+		if start, err = valueRange.parseValue(startItem); err != nil {
+			return 0, err
+		}
+
+		end = start
+
+		if isRange {
+			if end, err = valueRange.parseValue(endItem); err != nil {
+				return 0, err
+			}
+		} else if hasStep {
+			end = valueRange.max
+		}
+
+		if start > end {
+			return 0, aoserrors.Errorf("wrong cron range: %s", item)
+		}
+	}
+
+	for value := start; value <= end; value += step {
+		bits |= 1 << uint(value)
+	}
+
+	return bits, nil
+}
+
+func (valueRange fieldRange) parseValue(item string) (int, error) {
+	if value, ok := valueRange.names[strings.ToLower(item)]; ok {
+		return value, nil
+	}
+
+	value, err := strconv.Atoi(item)
+	if err != nil {
+		return 0, aoserrors.Errorf("wrong cron value: %s", item)
+	}
+
+	if value < valueRange.min || value > valueRange.max {
+		return 0, aoserrors.Errorf("cron value out of range: %s", item)
+	}
+
+	return value, nil
+}
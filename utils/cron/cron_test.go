@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aosedge/aos_servicemanager/utils/cron"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestParseErrors(t *testing.T) {
+	expressions := []string{
+		"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8",
+		"*/0 * * * *", "5-1 * * * *", "a * * * *", "* * * foo *", "@reboot",
+	}
+
+	for _, expression := range expressions {
+		if _, err := cron.Parse(expression); err == nil {
+			t.Errorf("Error expected for expression: %s", expression)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	type testData struct {
+		expression string
+		after      time.Time
+		next       time.Time
+	}
+
+	date := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	// 2024-03-01 is Friday
+	data := []testData{
+		{expression: "* * * * *", after: date(3, 1, 10, 0).Add(30 * time.Second), next: date(3, 1, 10, 1)},
+		{expression: "*/15 * * * *", after: date(3, 1, 10, 1), next: date(3, 1, 10, 15)},
+		{expression: "30 2 * * *", after: date(3, 1, 10, 0), next: date(3, 2, 2, 30)},
+		{expression: "@hourly", after: date(3, 1, 10, 0), next: date(3, 1, 11, 0)},
+		{expression: "@daily", after: date(3, 1, 10, 0), next: date(3, 2, 0, 0)},
+		{expression: "0 0 * * mon", after: date(3, 1, 10, 0), next: date(3, 4, 0, 0)},
+		{expression: "0 0 * * 7", after: date(3, 1, 10, 0), next: date(3, 3, 0, 0)},
+		{expression: "0 9-17/4 * * 1-5", after: date(3, 1, 17, 0), next: date(3, 4, 9, 0)},
+		{expression: "0 0 31 * *", after: date(3, 31, 10, 0), next: date(5, 31, 0, 0)},
+		{expression: "0 0 1,15 feb *", after: date(3, 1, 10, 0), next: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{expression: "0 0 29 2 *", after: date(3, 1, 10, 0), next: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Day of month or day of week
+		{expression: "0 0 10 * sun", after: date(3, 1, 10, 0), next: date(3, 3, 0, 0)},
+		{expression: "0 0 30 2 *", after: date(3, 1, 10, 0)},
+	}
+
+	for _, item := range data {
+		expression, err := cron.Parse(item.expression)
+		if err != nil {
+			t.Fatalf("Can't parse expression %s: %v", item.expression, err)
+		}
+
+		if next := expression.Next(item.after); !next.Equal(item.next) {
+			t.Errorf("Wrong next time for %s: %v", item.expression, next)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fscopy provides helpers to copy file system trees.
+package fscopy
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	xattrListSize  = 1024
+	xattrValueSize = 256
+	modeMask       = 0o7777
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// CopyDir copies source dir content into destination dir. Modes, owners, timestamps, extended attributes, symlinks,
+// hard links and special files are preserved. Regular files are cloned if file system supports reflinks, otherwise
+// their content is copied.
+func CopyDir(source, destination string) error {
+	links := make(map[inode]string)
+
+	var dirs []string
+
+	if err := filepath.WalkDir(source, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		relPath, err := filepath.Rel(source, name)
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		target := filepath.Join(destination, relPath)
+
+		info, err := entry.Info()
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return aoserrors.Errorf("can't get %s stat", name)
+		}
+
+		if !info.IsDir() && stat.Nlink > 1 {
+			key := inode{dev: stat.Dev, ino: stat.Ino}
+
+			if linkTarget, ok := links[key]; ok {
+				return aoserrors.Wrap(os.Link(linkTarget, target))
+			}
+
+			links[key] = target
+		}
+
+		if err = copyEntry(name, target, info, stat); err != nil {
+			return err
+		}
+
+		if err = copyAttributes(name, target, info, stat); err != nil {
+			return err
+		}
+
+		// Directory times are changed by its content, they are set when all content is copied
+		if info.IsDir() {
+			dirs = append(dirs, name)
+
+			return nil
+		}
+
+		return copyTimes(target, stat)
+	}); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		var stat syscall.Stat_t
+
+		if err := syscall.Lstat(dirs[i], &stat); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		relPath, err := filepath.Rel(source, dirs[i])
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if err = copyTimes(filepath.Join(destination, relPath), &stat); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func copyEntry(source, target string, info fs.FileInfo, stat *syscall.Stat_t) error {
+	switch mode := info.Mode(); {
+	case mode.IsDir():
+		if err := os.Mkdir(target, mode.Perm()); err != nil && !errors.Is(err, fs.ErrExist) {
+			return aoserrors.Wrap(err)
+		}
+
+	case mode&fs.ModeSymlink != 0:
+		linkTarget, err := os.Readlink(source)
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if err = os.Symlink(linkTarget, target); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+	case mode.IsRegular():
+		return copyFile(source, target, mode.Perm())
+
+	default:
+		if err := unix.Mknod(target, stat.Mode, int(stat.Rdev)); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func copyFile(source, target string, perm fs.FileMode) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+	defer sourceFile.Close()
+
+	targetFile, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_EXCL, perm)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+	defer targetFile.Close()
+
+	if err = unix.IoctlFileClone(int(targetFile.Fd()), int(sourceFile.Fd())); err == nil {
+		return nil
+	}
+
+	if _, err = io.Copy(targetFile, sourceFile); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func copyAttributes(source, target string, info fs.FileInfo, stat *syscall.Stat_t) error {
+	if err := os.Lchown(target, int(stat.Uid), int(stat.Gid)); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err := copyXattrs(source, target); err != nil {
+		return err
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return nil
+	}
+
+	// Chown clears setuid and setgid bits, so mode is set after owner
+	if err := unix.Chmod(target, stat.Mode&modeMask); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func copyXattrs(source, target string) error {
+	names, err := listXattrs(source)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		value, err := getXattr(source, name)
+		if err != nil {
+			return err
+		}
+
+		// Like cp -a, attributes not supported by destination file system are skipped
+		if err = unix.Lsetxattr(target, name, value, 0); err != nil && !errors.Is(err, unix.ENOTSUP) {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func listXattrs(name string) ([]string, error) {
+	buffer := make([]byte, xattrListSize)
+
+	for {
+		size, err := unix.Llistxattr(name, buffer)
+		if err != nil {
+			if errors.Is(err, unix.ERANGE) {
+				buffer = make([]byte, len(buffer)*2)
+
+				continue
+			}
+
+			if errors.Is(err, unix.ENOTSUP) {
+				return nil, nil
+			}
+
+			return nil, aoserrors.Wrap(err)
+		}
+
+		var names []string
+
+		for start, i := 0, 0; i < size; i++ {
+			if buffer[i] == 0 {
+				if i > start {
+					names = append(names, string(buffer[start:i]))
+				}
+
+				start = i + 1
+			}
+		}
+
+		return names, nil
+	}
+}
+
+func getXattr(name, attr string) ([]byte, error) {
+	buffer := make([]byte, xattrValueSize)
+
+	for {
+		size, err := unix.Lgetxattr(name, attr, buffer)
+		if err != nil {
+			if errors.Is(err, unix.ERANGE) {
+				buffer = make([]byte, len(buffer)*2)
+
+				continue
+			}
+
+			return nil, aoserrors.Wrap(err)
+		}
+
+		return buffer[:size], nil
+	}
+}
+
+func copyTimes(target string, stat *syscall.Stat_t) error {
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, target, []unix.Timespec{
+		unix.NsecToTimespec(stat.Atim.Nano()), unix.NsecToTimespec(stat.Mtim.Nano()),
+	}, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
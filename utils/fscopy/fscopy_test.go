@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/aosedge/aos_servicemanager/utils/fscopy"
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var tmpDir string
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Main
+ **********************************************************************************************************************/
+
+func TestMain(m *testing.M) {
+	var err error
+
+	if tmpDir, err = os.MkdirTemp("", "aos_"); err != nil {
+		log.Fatalf("Error create tmp folder: %v", err)
+	}
+
+	ret := m.Run()
+
+	if err = os.RemoveAll(tmpDir); err != nil {
+		log.Errorf("Can't remove tmp folder: %v", err)
+	}
+
+	os.Exit(ret)
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestCopyDir(t *testing.T) {
+	source := filepath.Join(tmpDir, "source")
+	destination := filepath.Join(tmpDir, "destination")
+	content := []byte("file content")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	if err := os.MkdirAll(filepath.Join(source, "dir"), 0o750); err != nil {
+		t.Fatalf("Can't create dir: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(source, "dir", "file"), content, 0o640); err != nil {
+		t.Fatalf("Can't create file: %v", err)
+	}
+
+	if err := os.Chown(filepath.Join(source, "dir", "file"), 5000, 5001); err != nil {
+		t.Fatalf("Can't set file owner: %v", err)
+	}
+
+	if err := os.Chmod(filepath.Join(source, "dir", "file"), os.ModeSetuid|0o750); err != nil {
+		t.Fatalf("Can't set file mode: %v", err)
+	}
+
+	if err := os.Link(filepath.Join(source, "dir", "file"), filepath.Join(source, "link")); err != nil {
+		t.Fatalf("Can't create hard link: %v", err)
+	}
+
+	if err := os.Symlink("dir/file", filepath.Join(source, "symlink")); err != nil {
+		t.Fatalf("Can't create symlink: %v", err)
+	}
+
+	if err := unix.Mknod(filepath.Join(source, "whiteout"), unix.S_IFCHR, 0); err != nil {
+		t.Fatalf("Can't create char device: %v", err)
+	}
+
+	xattrSupported := unix.Setxattr(filepath.Join(source, "dir"), "trusted.overlay.opaque", []byte{'y'}, 0) == nil
+
+	if err := os.Chtimes(filepath.Join(source, "dir"), modTime, modTime); err != nil {
+		t.Fatalf("Can't set dir times: %v", err)
+	}
+
+	if err := os.MkdirAll(destination, 0o755); err != nil {
+		t.Fatalf("Can't create destination dir: %v", err)
+	}
+
+	if err := fscopy.CopyDir(source, destination); err != nil {
+		t.Fatalf("Can't copy dir: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(destination, "dir", "file"))
+	if err != nil {
+		t.Fatalf("Can't read file: %v", err)
+	}
+
+	if !bytes.Equal(data, content) {
+		t.Errorf("Wrong file content: %s", data)
+	}
+
+	var fileStat, linkStat syscall.Stat_t
+
+	if err = syscall.Lstat(filepath.Join(destination, "dir", "file"), &fileStat); err != nil {
+		t.Fatalf("Can't stat file: %v", err)
+	}
+
+	if fileStat.Mode&0o7777 != 0o4750 || fileStat.Uid != 5000 || fileStat.Gid != 5001 {
+		t.Errorf("Wrong file attributes: mode %o, uid %d, gid %d", fileStat.Mode&0o7777, fileStat.Uid, fileStat.Gid)
+	}
+
+	if err = syscall.Lstat(filepath.Join(destination, "link"), &linkStat); err != nil {
+		t.Fatalf("Can't stat hard link: %v", err)
+	}
+
+	if linkStat.Ino != fileStat.Ino {
+		t.Error("Hard link is not preserved")
+	}
+
+	if linkTarget, err := os.Readlink(filepath.Join(destination, "symlink")); err != nil || linkTarget != "dir/file" {
+		t.Errorf("Wrong symlink: %s, %v", linkTarget, err)
+	}
+
+	var whiteoutStat syscall.Stat_t
+
+	if err = syscall.Lstat(filepath.Join(destination, "whiteout"), &whiteoutStat); err != nil {
+		t.Fatalf("Can't stat char device: %v", err)
+	}
+
+	if whiteoutStat.Mode&syscall.S_IFMT != syscall.S_IFCHR || whiteoutStat.Rdev != 0 {
+		t.Errorf("Wrong char device: mode %o, rdev %d", whiteoutStat.Mode, whiteoutStat.Rdev)
+	}
+
+	dirInfo, err := os.Stat(filepath.Join(destination, "dir"))
+	if err != nil {
+		t.Fatalf("Can't stat dir: %v", err)
+	}
+
+	if dirInfo.Mode().Perm() != 0o750 || !dirInfo.ModTime().Equal(modTime) {
+		t.Errorf("Wrong dir attributes: mode %o, time %v", dirInfo.Mode().Perm(), dirInfo.ModTime())
+	}
+
+	if xattrSupported {
+		value := make([]byte, 1)
+
+		if _, err = unix.Getxattr(filepath.Join(destination, "dir"), "trusted.overlay.opaque", value); err != nil ||
+			value[0] != 'y' {
+			t.Errorf("Extended attribute is not preserved: %v", err)
+		}
+	}
+}
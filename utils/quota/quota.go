@@ -0,0 +1,528 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package quota provides hard disk quotas for directories and files.
+package quota
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/aosedge/aos_common/aoserrors"
+	aosfs "github.com/aosedge/aos_common/utils/fs"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+* Consts
+***********************************************************************************************************************/
+
+const (
+	prjQuota        = 2
+	qGetQuota       = 0x800007
+	qSetQuota       = 0x800008
+	qifBLimits      = 1
+	quotaSubCmdMask = 0x00ff
+	quotaSubCmdShft = 8
+	quotaBlockSize  = 1024
+)
+
+const (
+	fsIocFsGetXAttr    = 0x801c581f
+	fsIocFsSetXAttr    = 0x401c5820
+	fsXFlagProjInherit = 0x00000200
+)
+
+const (
+	imageSuffix      = ".img"
+	tmpImageSuffix   = ".tmp"
+	fileMountSuffix  = ".quota"
+	migrateDirPrefix = ".quota-"
+)
+
+/***********************************************************************************************************************
+* Types
+***********************************************************************************************************************/
+
+// Quota hard disk quota applied to directory or file.
+type Quota struct {
+	path       string
+	limit      uint64
+	projectID  uint32
+	device     string
+	mountPoint string
+}
+
+type dqblk struct {
+	bHardLimit uint64
+	bSoftLimit uint64
+	curSpace   uint64
+	iHardLimit uint64
+	iSoftLimit uint64
+	curInodes  uint64
+	bTime      uint64
+	iTime      uint64
+	valid      uint32
+}
+
+type fsxattr struct {
+	xflags     uint32
+	extsize    uint32
+	nextents   uint32
+	projid     uint32
+	cowextsize uint32
+	pad        [8]byte
+}
+
+/***********************************************************************************************************************
+* Public
+***********************************************************************************************************************/
+
+// Apply applies hard disk quota to existing directory or file. Project quota is used if it is supported by file
+// system, otherwise data is moved to loop mounted image of limit size. The data is accessible by the original path in
+// both cases: directory is used as image mount point and file is bind mounted from the image. Existing image is
+// resized if the limit is changed.
+func Apply(path string, projectID uint32, limit uint64) (quota *Quota, err error) {
+	log.WithFields(log.Fields{"path": path, "projectID": projectID, "limit": limit}).Debug("Apply disk quota")
+
+	quota = &Quota{path: path, limit: limit, projectID: projectID}
+
+	if quota.device, err = getMountDevice(path); err != nil {
+		return nil, err
+	}
+
+	if projectQuotaSupported(quota.device) {
+		if err = setProjectID(path, projectID); err != nil {
+			return nil, err
+		}
+
+		if err = setProjectLimit(quota.device, projectID, limit); err != nil {
+			return nil, err
+		}
+
+		return quota, nil
+	}
+
+	log.WithField("path", path).Debug("Project quota is not supported, use loop image")
+
+	quota.device = ""
+
+	if err = quota.mountImage(); err != nil {
+		return nil, err
+	}
+
+	return quota, nil
+}
+
+// Limit returns quota limit. For loop image it is the image file system size available for data.
+func (quota *Quota) Limit() uint64 {
+	if quota.mountPoint == "" {
+		return quota.limit
+	}
+
+	var stat unix.Statfs_t
+
+	if err := unix.Statfs(quota.mountPoint, &stat); err != nil {
+		return quota.limit
+	}
+
+	return stat.Blocks * uint64(stat.Bsize)
+}
+
+// Usage returns used size.
+func (quota *Quota) Usage() (usage uint64, err error) {
+	if quota.mountPoint == "" {
+		var info dqblk
+
+		if err = quotactl(qGetQuota, quota.device, quota.projectID, unsafe.Pointer(&info)); err != nil {
+			return 0, err
+		}
+
+		return info.curSpace, nil
+	}
+
+	var stat unix.Statfs_t
+
+	if err = unix.Statfs(quota.mountPoint, &stat); err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	return (stat.Blocks - stat.Bfree) * uint64(stat.Bsize), nil
+}
+
+// Release releases quota resources: project quota limit is removed and loop image is unmounted. Quota data is kept.
+func (quota *Quota) Release() error {
+	if quota.mountPoint == "" {
+		log.WithFields(log.Fields{"path": quota.path, "projectID": quota.projectID}).Debug("Remove project quota limit")
+
+		return setProjectLimit(quota.device, quota.projectID, 0)
+	}
+
+	log.WithField("mountPoint", quota.mountPoint).Debug("Unmount quota image")
+
+	if quota.mountPoint == quota.path {
+		return unmount(quota.mountPoint)
+	}
+
+	if err := unmount(quota.path); err != nil {
+		return err
+	}
+
+	if err := unmount(quota.mountPoint); err != nil {
+		return err
+	}
+
+	if err := os.Remove(quota.mountPoint); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+/***********************************************************************************************************************
+* Private
+***********************************************************************************************************************/
+
+func (quota *Quota) mountImage() error {
+	info, err := os.Stat(quota.path)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	quota.mountPoint = quota.path
+
+	if !info.IsDir() {
+		quota.mountPoint = quota.path + fileMountSuffix
+
+		if err = os.MkdirAll(quota.mountPoint, 0o755); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	mounted, err := isMounted(quota.mountPoint)
+	if err != nil {
+		return err
+	}
+
+	if !mounted {
+		imagePath := quota.path + imageSuffix
+
+		if err = quota.prepareImage(imagePath, info); err != nil {
+			return err
+		}
+
+		if err = runCommand("mount", "-o", "loop", imagePath, quota.mountPoint); err != nil {
+			return err
+		}
+	}
+
+	if info.IsDir() {
+		return nil
+	}
+
+	// Keep file path stable by bind mounting the file from the image over the original one
+
+	if mounted, err = isMounted(quota.path); err != nil || mounted {
+		return err
+	}
+
+	return runCommand("mount", "--bind", filepath.Join(quota.mountPoint, filepath.Base(quota.path)), quota.path)
+}
+
+func (quota *Quota) prepareImage(imagePath string, info fs.FileInfo) error {
+	imageInfo, err := os.Stat(imagePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return aoserrors.Wrap(err)
+		}
+
+		return quota.createImage(imagePath, info)
+	}
+
+	if imageSize := uint64(imageInfo.Size()); imageSize != quota.limit {
+		// Quota data is kept in current image if it can't be resized, e.g. if the data doesn't fit the new limit
+		if err = quota.resizeImage(imagePath, imageSize); err != nil {
+			log.WithField("imagePath", imagePath).Errorf("Can't resize quota image: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (quota *Quota) createImage(imagePath string, info fs.FileInfo) error {
+	log.WithFields(log.Fields{"imagePath": imagePath, "size": quota.limit}).Debug("Create quota image")
+
+	// Image is built under temporary name to not use incomplete image if SM is stopped or copying fails
+	tmpImagePath := imagePath + tmpImageSuffix
+
+	if err := quota.buildImage(tmpImagePath, info); err != nil {
+		os.Remove(tmpImagePath)
+
+		return err
+	}
+
+	if err := os.Rename(tmpImagePath, imagePath); err != nil {
+		os.Remove(tmpImagePath)
+
+		return aoserrors.Wrap(err)
+	}
+
+	// Remove original data when it is completely copied to the image
+
+	if !info.IsDir() {
+		// Original file is kept empty as bind mount target
+		return aoserrors.Wrap(os.Truncate(quota.path, 0))
+	}
+
+	entries, err := os.ReadDir(quota.path)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	for _, entry := range entries {
+		if err = os.RemoveAll(filepath.Join(quota.path, entry.Name())); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func (quota *Quota) buildImage(imagePath string, info fs.FileInfo) error {
+	if err := os.WriteFile(imagePath, nil, 0o600); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err := os.Truncate(imagePath, int64(quota.limit)); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err := runCommand("mkfs.ext4", "-q", "-F", "-m", "0", imagePath); err != nil {
+		return err
+	}
+
+	return quota.copyToImage(imagePath, info)
+}
+
+func (quota *Quota) copyToImage(imagePath string, info fs.FileInfo) (err error) {
+	migrateDir, err := os.MkdirTemp(filepath.Dir(quota.path), migrateDirPrefix)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+	defer os.RemoveAll(migrateDir)
+
+	if err = runCommand("mount", "-o", "loop", imagePath, migrateDir); err != nil {
+		return err
+	}
+
+	defer func() {
+		if umountErr := aosfs.Umount(migrateDir); umountErr != nil && err == nil {
+			err = aoserrors.Wrap(umountErr)
+		}
+	}()
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return aoserrors.New("can't get file owner")
+	}
+
+	if info.IsDir() {
+		if err = os.Chown(migrateDir, int(stat.Uid), int(stat.Gid)); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if err = os.Chmod(migrateDir, info.Mode().Perm()); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		return runCommand("cp", "-a", quota.path+"/.", migrateDir)
+	}
+
+	return runCommand("cp", "-a", quota.path, filepath.Join(migrateDir, filepath.Base(quota.path)))
+}
+
+// resizeImage grows or shrinks not mounted quota image to the quota limit.
+func (quota *Quota) resizeImage(imagePath string, imageSize uint64) error {
+	log.WithFields(log.Fields{
+		"imagePath": imagePath, "size": imageSize, "newSize": quota.limit,
+	}).Debug("Resize quota image")
+
+	// Exit code 1 means file system errors are corrected
+	if err := exec.Command("e2fsck", "-f", "-p", imagePath).Run(); err != nil {
+		var exitErr *exec.ExitError
+
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() > 1 {
+			return aoserrors.Errorf("e2fsck: %v", err)
+		}
+	}
+
+	if quota.limit > imageSize {
+		if err := os.Truncate(imagePath, int64(quota.limit)); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		return runCommand("resize2fs", imagePath)
+	}
+
+	if err := runCommand("resize2fs", imagePath, strconv.FormatUint(quota.limit/quotaBlockSize, 10)+"K"); err != nil {
+		return err
+	}
+
+	return aoserrors.Wrap(os.Truncate(imagePath, int64(quota.limit)))
+}
+
+func projectQuotaSupported(device string) bool {
+	var info dqblk
+
+	return quotactl(qGetQuota, device, 0, unsafe.Pointer(&info)) == nil
+}
+
+func setProjectID(path string, projectID uint32) error {
+	if err := filepath.WalkDir(path, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if !entry.IsDir() && !entry.Type().IsRegular() {
+			return nil
+		}
+
+		return setFileProjectID(name, projectID, entry.IsDir())
+	}); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func setFileProjectID(path string, projectID uint32, inherit bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+	defer file.Close()
+
+	var attr fsxattr
+
+	if err = ioctl(file.Fd(), fsIocFsGetXAttr, unsafe.Pointer(&attr)); err != nil {
+		return err
+	}
+
+	attr.projid = projectID
+
+	if inherit {
+		attr.xflags |= fsXFlagProjInherit
+	}
+
+	return ioctl(file.Fd(), fsIocFsSetXAttr, unsafe.Pointer(&attr))
+}
+
+func setProjectLimit(device string, projectID uint32, limit uint64) error {
+	info := dqblk{
+		bHardLimit: (limit + quotaBlockSize - 1) / quotaBlockSize,
+		valid:      qifBLimits,
+	}
+
+	return quotactl(qSetQuota, device, projectID, unsafe.Pointer(&info))
+}
+
+func quotactl(cmd uintptr, device string, id uint32, target unsafe.Pointer) error {
+	devicePtr, err := syscall.BytePtrFromString(device)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if _, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL, cmd<<quotaSubCmdShft|prjQuota&quotaSubCmdMask,
+		uintptr(unsafe.Pointer(devicePtr)), uintptr(id), uintptr(target), 0, 0); errno != 0 {
+		return aoserrors.Wrap(errno)
+	}
+
+	return nil
+}
+
+func ioctl(fd, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg)); errno != 0 {
+		return aoserrors.Wrap(errno)
+	}
+
+	return nil
+}
+
+func getMountDevice(path string) (device string, err error) {
+	mountPoint, err := aosfs.GetMountPoint(path)
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	file, err := os.Open("/proc/mounts")
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) >= 2 && fields[1] == mountPoint {
+			device = fields[0]
+		}
+	}
+
+	if device == "" {
+		return "", aoserrors.Errorf("can't find device for %s", path)
+	}
+
+	return device, nil
+}
+
+func isMounted(path string) (mounted bool, err error) {
+	mountPoint, err := aosfs.GetMountPoint(path)
+	if err != nil {
+		return false, aoserrors.Wrap(err)
+	}
+
+	return mountPoint == path, nil
+}
+
+func unmount(path string) error {
+	syscall.Sync()
+
+	if err := unix.Unmount(path, 0); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func runCommand(name string, args ...string) error {
+	if output, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return aoserrors.Errorf("%s: %v: %s", name, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
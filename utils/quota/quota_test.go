@@ -0,0 +1,242 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quota_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aosedge/aos_servicemanager/utils/quota"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const quotaLimit = 4 * 1024 * 1024
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var tmpDir string
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Main
+ **********************************************************************************************************************/
+
+func TestMain(m *testing.M) {
+	var err error
+
+	if tmpDir, err = os.MkdirTemp("", "aos_"); err != nil {
+		log.Fatalf("Error create temporary dir: %v", err)
+	}
+
+	ret := m.Run()
+
+	if err = os.RemoveAll(tmpDir); err != nil {
+		log.Fatalf("Error removing tmp dir: %v", err)
+	}
+
+	os.Exit(ret)
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestDirQuota(t *testing.T) {
+	storageDir := filepath.Join(tmpDir, "storage")
+
+	if err := os.MkdirAll(storageDir, 0o755); err != nil {
+		t.Fatalf("Can't create storage dir: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(storageDir, "data"), []byte("data"), 0o600); err != nil {
+		t.Fatalf("Can't write data: %v", err)
+	}
+
+	dirQuota, err := quota.Apply(storageDir, 1000, quotaLimit)
+	if err != nil {
+		t.Fatalf("Can't apply quota: %v", err)
+	}
+
+	checkQuota(t, dirQuota, filepath.Join(storageDir, "data"))
+
+	if err = dirQuota.Release(); err != nil {
+		t.Errorf("Can't release quota: %v", err)
+	}
+
+	// Data should be moved to the image
+
+	if _, err = os.Stat(storageDir + ".img.tmp"); !os.IsNotExist(err) {
+		t.Errorf("Temporary quota image should be removed: %v", err)
+	}
+
+	entries, err := os.ReadDir(storageDir)
+	if err != nil {
+		t.Fatalf("Can't read storage dir: %v", err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("Data is not moved to quota image: %d entries left", len(entries))
+	}
+
+	// Data should be kept after quota is applied again
+
+	if dirQuota, err = quota.Apply(storageDir, 1000, quotaLimit); err != nil {
+		t.Fatalf("Can't apply quota: %v", err)
+	}
+	defer dirQuota.Release()
+
+	checkData(t, filepath.Join(storageDir, "data"))
+}
+
+func TestFileQuota(t *testing.T) {
+	stateFile := filepath.Join(tmpDir, "state.dat")
+
+	if err := os.WriteFile(stateFile, []byte("data"), 0o600); err != nil {
+		t.Fatalf("Can't write state file: %v", err)
+	}
+
+	fileQuota, err := quota.Apply(stateFile, 1001, quotaLimit)
+	if err != nil {
+		t.Fatalf("Can't apply quota: %v", err)
+	}
+
+	checkQuota(t, fileQuota, stateFile)
+
+	if err = fileQuota.Release(); err != nil {
+		t.Errorf("Can't release quota: %v", err)
+	}
+
+	// Data should be kept after quota is applied again
+
+	if fileQuota, err = quota.Apply(stateFile, 1001, quotaLimit); err != nil {
+		t.Fatalf("Can't apply quota: %v", err)
+	}
+	defer fileQuota.Release()
+
+	checkData(t, stateFile)
+}
+
+func TestResizeQuota(t *testing.T) {
+	storageDir := filepath.Join(tmpDir, "resize")
+
+	if err := os.MkdirAll(storageDir, 0o755); err != nil {
+		t.Fatalf("Can't create storage dir: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(storageDir, "data"), []byte("data"), 0o600); err != nil {
+		t.Fatalf("Can't write data: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(storageDir, "payload"), make([]byte, quotaLimit/4), 0o600); err != nil {
+		t.Fatalf("Can't write data: %v", err)
+	}
+
+	testData := []struct {
+		limit    uint64
+		minLimit uint64
+		maxLimit uint64
+	}{
+		{limit: 2 * quotaLimit, minLimit: quotaLimit, maxLimit: 2 * quotaLimit},
+		{limit: 4 * quotaLimit, minLimit: 2 * quotaLimit, maxLimit: 4 * quotaLimit},
+		{limit: quotaLimit, minLimit: quotaLimit / 2, maxLimit: quotaLimit},
+		// Data doesn't fit new limit, current image size is kept
+		{limit: quotaLimit / 8, minLimit: quotaLimit / 2, maxLimit: quotaLimit},
+	}
+
+	for _, item := range testData {
+		dirQuota, err := quota.Apply(storageDir, 1002, item.limit)
+		if err != nil {
+			t.Fatalf("Can't apply quota: %v", err)
+		}
+
+		if dirQuota.Limit() < item.minLimit || dirQuota.Limit() > item.maxLimit {
+			t.Errorf("Wrong quota limit: %d", dirQuota.Limit())
+		}
+
+		checkData(t, filepath.Join(storageDir, "data"))
+
+		if err = dirQuota.Release(); err != nil {
+			t.Errorf("Can't release quota: %v", err)
+		}
+	}
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func checkQuota(t *testing.T, diskQuota *quota.Quota, dataPath string) {
+	t.Helper()
+
+	if diskQuota.Limit() == 0 || diskQuota.Limit() > quotaLimit {
+		t.Errorf("Wrong quota limit: %d", diskQuota.Limit())
+	}
+
+	checkData(t, dataPath)
+
+	if err := os.WriteFile(dataPath, make([]byte, 2*quotaLimit), 0o600); err == nil {
+		t.Error("Quota limit should be exceeded")
+	}
+
+	usage, err := diskQuota.Usage()
+	if err != nil {
+		t.Fatalf("Can't get quota usage: %v", err)
+	}
+
+	if usage == 0 || usage > diskQuota.Limit() {
+		t.Errorf("Wrong quota usage: %d", usage)
+	}
+
+	if err = os.WriteFile(dataPath, []byte("data"), 0o600); err != nil {
+		t.Errorf("Can't write data: %v", err)
+	}
+}
+
+func checkData(t *testing.T, dataPath string) {
+	t.Helper()
+
+	data, err := os.ReadFile(dataPath)
+	if err != nil {
+		t.Fatalf("Can't read data: %v", err)
+	}
+
+	if string(data) != "data" {
+		t.Errorf("Wrong data: %s", string(data))
+	}
+}
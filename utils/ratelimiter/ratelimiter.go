@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2024 Renesas Electronics Corporation.
+// Copyright (C) 2024 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimiter provides limiter of summary transfer rate shared between transfers.
+package ratelimiter
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aosedge/aos_common/aoserrors"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// RateLimiter limits summary transfer rate of all transfers. Each transferred chunk shifts the time when next chunk
+// may be transferred.
+type RateLimiter struct {
+	sync.Mutex
+
+	bytesPerSecond uint64
+	next           time.Time
+}
+
+// Reader limits rate of data read from underlying reader.
+type Reader struct {
+	ctx     context.Context //nolint:containedctx
+	reader  io.Reader
+	limiter *RateLimiter
+}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// New creates rate limiter.
+func New(bytesPerSecond uint64) *RateLimiter {
+	return &RateLimiter{bytesPerSecond: bytesPerSecond}
+}
+
+// NewReader creates reader limited by rate limiter. Read is not limited if limiter is nil.
+func NewReader(ctx context.Context, reader io.Reader, limiter *RateLimiter) *Reader {
+	return &Reader{ctx: ctx, reader: reader, limiter: limiter}
+}
+
+// WaitN blocks until n transferred bytes fit into the rate limit.
+func (limiter *RateLimiter) WaitN(ctx context.Context, n int) error {
+	limiter.Lock()
+
+	now := time.Now()
+
+	if limiter.next.Before(now) {
+		limiter.next = now
+	}
+
+	limiter.next = limiter.next.Add(time.Duration(uint64(n) * uint64(time.Second) / limiter.bytesPerSecond))
+	delay := limiter.next.Sub(now)
+
+	limiter.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+
+	case <-ctx.Done():
+		return aoserrors.Wrap(ctx.Err())
+	}
+}
+
+// Read reads data from underlying reader and waits until read bytes fit into the rate limit.
+func (reader *Reader) Read(p []byte) (n int, err error) {
+	n, err = reader.reader.Read(p)
+
+	if n > 0 && reader.limiter != nil {
+		if limitErr := reader.limiter.WaitN(reader.ctx, n); limitErr != nil {
+			return n, limitErr
+		}
+	}
+
+	return n, err //nolint:wrapcheck
+}